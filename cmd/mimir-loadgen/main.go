@@ -0,0 +1,136 @@
+// mimir-loadgen writes synthetic series through the Prometheus remote write
+// API, to soak test write proxies and Mimir tenants before migrations.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon"
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/loadgen"
+)
+
+// This value will be overridden during the build process using -ldflags.
+var version = "development"
+
+func main() {
+	var (
+		writeCfg   remotewrite.Config
+		loadgenCfg loadgen.Config
+	)
+	writeCfg.RegisterFlags(flag.CommandLine)
+	loadgenCfg.RegisterFlags(flag.CommandLine)
+	tenantID := flag.String("tenant-id", "", "The tenant to write the series for.")
+	var apiKey secrets.Secret
+	flag.Var(&apiKey, "api-key", "The API key to write with, sent with basic auth along with the tenant ID. Either the key, or a file:<path>, env:<name> or vault:<path>#<key> reference to it.")
+	duration := flag.Duration("duration", 0, "How long to write for. 0 to write until interrupted.")
+	versionFlag := flag.Bool("version", false, "Display the version of the binary")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage of mimir-loadgen:
+
+mimir-loadgen [arguments]
+
+mimir-loadgen writes synthetic series through the Prometheus remote write API,
+with a configurable cardinality, churn rate, value distribution and share of
+native histograms, until interrupted or for --duration.
+
+Flags:
+
+`)
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+
+Example Usage:
+
+	mimir-loadgen --write-endpoint https://mimir/api/v1/push --tenant-id 12345 --api-key <key> --loadgen.series 100000 --loadgen.churn-percent 5 --duration 1h
+`)
+	}
+	flag.Parse()
+
+	if *versionFlag {
+		_, _ = fmt.Fprintf(os.Stdout, "%s\n", version)
+		os.Exit(0)
+	}
+
+	logger := level.NewFilter(log.NewLogfmtLogger(os.Stderr), level.AllowInfo())
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+
+	for _, validate := range []func() error{writeCfg.Validate, loadgenCfg.Validate} {
+		if err := validate(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if *tenantID == "" {
+		_, _ = fmt.Fprintf(os.Stderr, "ERROR: Need to specify --tenant-id\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if apiKey.IsSet() {
+		transport = basicAuthTransport{next: transport, username: *tenantID, password: apiKey}
+	}
+	writeCfg.HTTPClient = &http.Client{Transport: appcommon.NewTracedAuthRoundTripper(transport, "Remote Write")}
+	client, err := remotewrite.NewClient(writeCfg, remotewrite.NewRecorder("loadgen", prometheus.NewRegistry()), nil)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to create the remote write client", "err", err)
+		os.Exit(1)
+	}
+	client, deadLetters, err := remotewrite.NewFileDeadLetterClient(client, writeCfg.DeadLetter, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to open the dead letter file", "err", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	ctx = user.InjectOrgID(ctx, *tenantID)
+
+	level.Info(logger).Log("msg", "writing", "endpoint", writeCfg.Endpoint, "series", loadgenCfg.Series, "interval", loadgenCfg.Interval)
+	start := time.Now()
+	stats := loadgen.NewGenerator(loadgenCfg).Run(ctx, client, logger)
+	level.Info(logger).Log("msg", "done", "duration", time.Since(start), "requests", stats.Requests, "failed_requests", stats.FailedRequests, "series_written", stats.Series)
+	if err := deadLetters.Close(); err != nil {
+		level.Error(logger).Log("msg", "failed to write the dead letters", "err", err)
+	}
+	if stats.FailedRequests > 0 {
+		os.Exit(1)
+	}
+}
+
+type basicAuthTransport struct {
+	next     http.RoundTripper
+	username string
+	// password is read for every request to pick up rotations.
+	password secrets.Secret
+}
+
+func (t basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	password, err := t.password.Get(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("can't read the API key: %w", err)
+	}
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(t.username, password)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,131 @@
+// mimir-read-compare runs the same query on two Prometheus remote read
+// endpoints, eg. a Graphite cluster bridge and Mimir, and reports how their
+// series differ, to validate migrations.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+	"github.com/grafana/mimir-graphite/v2/pkg/remoteread"
+)
+
+// This value will be overridden during the build process using -ldflags.
+var version = "development"
+
+type side struct {
+	endpoint, tenantID string
+	apiKey             secrets.Secret
+	notFoundAsEmpty    bool
+}
+
+func (s *side) registerFlags(name string) {
+	flag.StringVar(&s.endpoint, name+".read-endpoint", "", "URL of the Prometheus remote read API of "+name+", e.g. https://mimir/prometheus/api/v1/read.")
+	flag.StringVar(&s.tenantID, name+".tenant-id", "", "The tenant to read the series of from "+name+".")
+	flag.Var(&s.apiKey, name+".api-key", "The API key to read from "+name+" with, sent with basic auth along with the tenant ID. Either the key, or a file:<path>, env:<name> or vault:<path>#<key> reference to it.")
+	flag.BoolVar(&s.notFoundAsEmpty, name+".not-found-as-empty", false, "If true, "+name+" returning not found for the tenant, eg. because it has no data yet, is compared as no series rather than failing.")
+}
+
+func (s *side) queryable(name string, timeout time.Duration) (storage.Queryable, error) {
+	q, err := remoteread.NewRemoteReadQueryable(name, s.endpoint, s.tenantID, s.apiKey, timeout)
+	if err != nil || !s.notFoundAsEmpty {
+		return q, err
+	}
+	return remoteread.NewNotFoundAsEmptyQueryable(q), nil
+}
+
+func main() {
+	var a, b side
+	a.registerFlags("a")
+	b.registerFlags("b")
+	selector := flag.String("selector", "", "Series selector of the series to compare, e.g. {__name__=~\"servers_.*\"}.")
+	from := flag.Duration("from", time.Hour, "How long ago the compared range starts.")
+	until := flag.Duration("until", 0, "How long ago the compared range ends.")
+	timeout := flag.Duration("timeout", time.Minute, "Timeout of the queries.")
+	var cfg remoteread.CompareConfig
+	flag.Float64Var(&cfg.Tolerance, "tolerance", 0, "Max relative difference of two values considered equal, e.g. 0.01 for 1%.")
+	flag.DurationVar(&cfg.MaxTimestampDrift, "max-timestamp-drift", 0, "How far apart the timestamps of the samples of each side can be to be compared with each other.")
+	flag.IntVar(&cfg.MaxDiffsPerSeries, "max-diffs-per-series", 10, "Max number of sample diffs reported per series. 0 for no limit.")
+	versionFlag := flag.Bool("version", false, "Display the version of the binary")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage of mimir-read-compare:
+
+mimir-read-compare [arguments]
+
+mimir-read-compare queries the series matching --selector from two Prometheus
+remote read endpoints and prints a JSON report of the series missing on either
+side, and of the samples with different values or timestamps. It exits with
+status 1 if the series differ.
+
+Flags:
+
+`)
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+
+Example Usage:
+
+	mimir-read-compare --a.read-endpoint https://graphite-bridge/api/v1/read --a.tenant-id 12345 --b.read-endpoint https://mimir/prometheus/api/v1/read --b.tenant-id 12345 --b.api-key <key> --selector '{__name__=~"servers_.*"}' --from 24h --tolerance 0.001
+`)
+	}
+	flag.Parse()
+
+	if *versionFlag {
+		_, _ = fmt.Fprintf(os.Stdout, "%s\n", version)
+		os.Exit(0)
+	}
+
+	if a.endpoint == "" || b.endpoint == "" || *selector == "" {
+		_, _ = fmt.Fprintf(os.Stderr, "ERROR: Need to specify --a.read-endpoint, --b.read-endpoint and --selector\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	matchers, err := parser.ParseMetricSelector(*selector)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "ERROR: invalid selector: %v\n", err)
+		os.Exit(1)
+	}
+	if *until >= *from {
+		_, _ = fmt.Fprintf(os.Stderr, "ERROR: --until must be less than --from\n")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	queryableA, err := a.queryable("a", *timeout)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "ERROR: invalid --a.read-endpoint or --a.api-key: %v\n", err)
+		os.Exit(1)
+	}
+	queryableB, err := b.queryable("b", *timeout)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "ERROR: invalid --b.read-endpoint or --b.api-key: %v\n", err)
+		os.Exit(1)
+	}
+	now := time.Now()
+	report, err := remoteread.Compare(ctx, queryableA, queryableB, now.Add(-*from).UnixMilli(), now.Add(-*until).UnixMilli(), cfg, matchers...)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	if len(report.Diffs) > 0 {
+		os.Exit(1)
+	}
+}
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"flag"
 	"fmt"
@@ -18,7 +19,10 @@ import (
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/prometheus/model/labels"
 
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
 	"github.com/grafana/mimir-graphite/v2/pkg/graphite/convert/whisperconverter"
+	"github.com/grafana/mimir-graphite/v2/pkg/remoteread"
+	"github.com/grafana/mimir-graphite/v2/pkg/tsdb"
 )
 
 const (
@@ -26,6 +30,8 @@ const (
 	DATERANGE = "daterange"
 	PASS1     = "pass1"
 	PASS2     = "pass2"
+	UPLOAD    = "upload"
+	OVERLAPS  = "overlaps"
 )
 
 // This value will be overridden during the build process using -ldflags.
@@ -93,6 +99,60 @@ var (
 		"An optional comma-separated list of extra label name to label value to be applied to all metrics during conversion. This can be useful if you want to mark all metrics as coming from a specific archive, for example. This is applied during the second pass and has no effect on the first pass conversion.",
 	)
 
+	carbonLinkHosts = flag.String(
+		"carbonlink-hosts",
+		"",
+		"An optional comma-separated list of carbon-cache query addresses (host:port, usually port 7002). If set, the datapoints cached by carbon-cache and not yet written to the Whisper files are merged with them during the first pass. Use it for the final conversion of a live cluster, so the last minutes of data aren't lost.",
+	)
+	carbonLinkTimeout = flag.Duration(
+		"carbonlink-timeout",
+		time.Second,
+		"Timeout of the queries to carbon-cache.",
+	)
+	uploadAddress = flag.String(
+		"upload-address",
+		"",
+		"The address of Mimir to upload the blocks to, eg. https://prometheus-prod-XX-prod-us-central-0.grafana.net/",
+	)
+	uploadTenantID = flag.String(
+		"upload-tenant-id",
+		"",
+		"The tenant to upload the blocks for. On Grafana Cloud this is the ID of the Graphite instance.",
+	)
+	// uploadAPIKeySecret is registered in init.
+	uploadAPIKeySecret secrets.Secret
+	uploadConcurrency  = flag.Int(
+		"upload-concurrency",
+		4, //nolint:gomnd
+		"The number of blocks to upload at the same time.",
+	)
+	uploadRetries = flag.Int(
+		"upload-retries",
+		5, //nolint:gomnd
+		"How many times each request to Mimir is retried on network errors, 429 and 5xx responses.",
+	)
+
+	overlapReadEndpoint = flag.String(
+		"overlap-read-endpoint",
+		"",
+		"The remote read endpoint of Mimir to check the series against the data the destination tenant, --upload-tenant-id, already has, eg. https://mimir/prometheus/api/v1/read. Required by the overlaps command. If set, pass2 also checks the series and reports the overlaps.",
+	)
+	overlapReadTimeout = flag.Duration(
+		"overlap-read-timeout",
+		time.Minute,
+		"Timeout of the reads of the existing data of each series.",
+	)
+	overlapReport = flag.String(
+		"overlap-report",
+		"",
+		"Path of the JSON file to write the series overlapping the existing data of the destination tenant to. If blank, the overlaps are only counted in the logs.",
+	)
+	excludeOverlaps = flag.Bool(
+		"exclude-overlaps",
+		false,
+		"If true, pass2 leaves the samples overlapping the existing data of the destination tenant out of the blocks, rather than creating duplicates. Requires --overlap-read-endpoint.",
+	)
+
 	versionFlag = flag.Bool("version", false, "Display the version of the binary")
 	verboseFlag = flag.Bool("verbose", false, "If true, outputs info logging")
 	debugFlag   = flag.Bool("debug", false, "If true, outputs debug logging")
@@ -102,6 +162,10 @@ var (
 	endDateFlag   = flag.String("end-date", "", "The last date to process in YYYY-MM-DD format")
 )
 
+func init() {
+	flag.Var(&uploadAPIKeySecret, "upload-api-key", "The API key to upload the blocks with, sent with basic auth along with the tenant ID. Either the key, or a file:<path>, env:<name> or vault:<path>#<key> reference to it.")
+}
+
 // Will be simplifying main() as we go.
 //
 //nolint:gocyclo
@@ -143,6 +207,20 @@ The commands are:
 
 			Required flags: , --start-date, --end-date, --intermediate-directory, --blocks-directory
 
+	overlaps	Check the series of the intermediate files against the data the
+			destination tenant already has, without generating any block, and
+			report the series whose samples overlap it. Importing them would
+			create duplicates.
+
+			Required flags: --start-date, --end-date, --intermediate-directory, --overlap-read-endpoint, --upload-tenant-id
+
+	upload		Upload the Mimir blocks generated by pass2 with the block upload API
+			of Mimir, waiting for each block to be validated. Blocks that were
+			already uploaded are skipped, so an interrupted upload can be resumed
+			by running the command again.
+
+			Required flags: --blocks-directory, --upload-address, --upload-tenant-id
+
 Flags:
 
 `)
@@ -154,6 +232,7 @@ Example Usage:
 	rangeOpts=$(mimir-whisper-converter --whisper-directory /opt/graphite/storage/whisper --quiet daterange)
 	mimir-whisper-converter --whisper-directory /opt/graphite/storage/whisper $rangeOpts --intermediate-directory /tmp/intermediate pass1
 	mimir-whisper-converter --intermiedate-directory /tmp/intermediate --blocks-directory /opt/mimir/blocks $rangeOpts pass2
+	mimir-whisper-converter --blocks-directory /opt/mimir/blocks --upload-address https://mimir --upload-tenant-id 12345 --upload-api-key <key> upload
 `)
 
 	}
@@ -182,7 +261,7 @@ Example Usage:
 	}
 
 	var dates []time.Time
-	if command != DATERANGE && command != FILELIST {
+	if command != DATERANGE && command != FILELIST && command != UPLOAD {
 		if *startDateFlag == "" {
 			_, _ = fmt.Fprintf(os.Stderr, "ERROR: Need to specify --start-date\n")
 			flag.Usage()
@@ -234,6 +313,27 @@ Example Usage:
 		logger,
 	)
 
+	if *carbonLinkHosts != "" {
+		carbonLink := whisperconverter.NewCarbonLink(strings.Split(*carbonLinkHosts, ","), *carbonLinkTimeout, *threads)
+		defer carbonLink.Close()
+		converter.SetCarbonLink(carbonLink)
+	}
+
+	var overlapChecker *whisperconverter.OverlapChecker
+	if *overlapReadEndpoint != "" {
+		queryable, err := remoteread.NewRemoteReadQueryable("overlaps", *overlapReadEndpoint, *uploadTenantID, uploadAPIKeySecret, *overlapReadTimeout)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "ERROR: invalid --overlap-read-endpoint: %v\n", err)
+			os.Exit(1)
+		}
+		overlapChecker = whisperconverter.NewOverlapChecker(queryable, *excludeOverlaps && command == PASS2)
+		converter.SetOverlapChecker(overlapChecker)
+	} else if command == OVERLAPS || *excludeOverlaps {
+		_, _ = fmt.Fprintf(os.Stderr, "ERROR: Need to specify --overlap-read-endpoint\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
 	go func() {
 		err := http.ListenAndServe("localhost:8081", nil)
 		if err != nil {
@@ -243,6 +343,28 @@ Example Usage:
 		}
 	}()
 
+	if command == UPLOAD {
+		uploader, err := tsdb.NewUploader(tsdb.UploaderConfig{
+			Address:     *uploadAddress,
+			TenantID:    *uploadTenantID,
+			APIKey:      uploadAPIKeySecret,
+			Concurrency: *uploadConcurrency,
+			MaxRetries:  *uploadRetries,
+		}, logger)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+		uploaded, err := uploader.UploadBlocks(context.Background(), *blocksDirectory)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error uploading blocks", "err", err)
+			os.Exit(1)
+		}
+		level.Info(logger).Log("msg", fmt.Sprintf("All done. Uploaded %d blocks", uploaded))
+		return
+	}
+
 	switch command {
 	case DATERANGE:
 		converter.CommandDateRange(*targetWhisperFiles)
@@ -264,12 +386,30 @@ Example Usage:
 			level.Error(logger).Log("msg", "Error running pass2", "err", err)
 			os.Exit(1)
 		}
+	case OVERLAPS:
+		err := converter.CommandOverlaps(*intermediateDirectory)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error checking overlaps", "err", err)
+			os.Exit(1)
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "ERROR: Unknown command: %s\n", command)
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	if overlapChecker != nil {
+		if overlaps := overlapChecker.Overlaps(); len(overlaps) > 0 {
+			level.Warn(logger).Log("msg", "series overlapping the existing data of the destination tenant", "series", len(overlaps))
+		}
+		if *overlapReport != "" {
+			if err := overlapChecker.WriteFile(*overlapReport); err != nil {
+				level.Error(logger).Log("msg", "Error writing overlap report", "err", err)
+				os.Exit(1)
+			}
+		}
+	}
+
 	processed := converter.GetProcessedCount()
 	skipped := converter.GetSkippedCount()
 	level.Info(logger).Log("msg", fmt.Sprintf("All done. Processed %d files, %d skipped", processed, skipped))
@@ -0,0 +1,108 @@
+package appcommontest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon"
+)
+
+// App is an appcommon.App started for a test, with the registry and tracer it
+// was created with.
+type App struct {
+	appcommon.App
+
+	Registry *prometheus.Registry
+	Tracer   *mocktracer.MockTracer
+	// Client sends the requests of Do, Get and Post.
+	Client *http.Client
+}
+
+// Config returns the default config of an app, listening on random ports.
+func Config() appcommon.Config {
+	var cfg appcommon.Config
+	flagext.DefaultValues(&cfg)
+	cfg.ServiceName = "test"
+	cfg.ServerConfig.HTTPListenPort = 0
+	cfg.ServerConfig.GRPCListenPort = 0
+	cfg.InternalServerConfig.HTTPListenPort = 0
+	return cfg
+}
+
+// StartApp creates an app with cfg, a pedantic registry and a mock tracer,
+// calls register to add the routes of the test, and runs the app's Group
+// until the test finishes.
+func StartApp(t testing.TB, cfg appcommon.Config, register func(app appcommon.App)) *App {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	tracer := mocktracer.New()
+	app, err := appcommon.New(cfg, reg, "", tracer)
+	require.NoError(t, err)
+	if register != nil {
+		register(app)
+	}
+
+	stop := make(chan struct{})
+	app.Group.Add(func() error {
+		<-stop
+		return nil
+	}, func(error) {})
+	done := make(chan error, 1)
+	go func() { done <- app.Group.Run() }()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	t.Cleanup(func() {
+		close(stop)
+		require.NoError(t, <-done)
+		require.NoError(t, app.Close())
+		client.CloseIdleConnections()
+	})
+
+	return &App{App: app, Registry: reg, Tracer: tracer, Client: client}
+}
+
+// URL returns the URL of path on the app's server.
+func (a *App) URL(path string) string {
+	return fmt.Sprintf("http://%s%s", a.Server.Addr(), path)
+}
+
+// Do sends req as tenant, or without an org ID if tenant is empty, and
+// returns the status code and body of the response.
+func (a *App) Do(t testing.TB, tenant string, req *http.Request) (int, string) {
+	t.Helper()
+	if tenant != "" {
+		req.Header.Set(user.OrgIDHeaderName, tenant)
+	}
+	resp, err := a.Client.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+// Get requests path as tenant.
+func (a *App) Get(t testing.TB, tenant, path string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, a.URL(path), nil)
+	require.NoError(t, err)
+	return a.Do(t, tenant, req)
+}
+
+// Post sends body to path as tenant.
+func (a *App) Post(t testing.TB, tenant, path, contentType, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, a.URL(path), strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	return a.Do(t, tenant, req)
+}
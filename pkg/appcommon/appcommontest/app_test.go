@@ -0,0 +1,117 @@
+package appcommontest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/dskit/user"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon"
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+	"github.com/grafana/mimir-graphite/v2/pkg/remoteread"
+)
+
+func TestStartApp(t *testing.T) {
+	VerifyNoLeaks(t, goleak.IgnoreTopFunction("os/signal.loop"))
+
+	app := StartApp(t, Config(), func(app appcommon.App) {
+		app.Server.Router.HandleFunc("/tenant", func(w http.ResponseWriter, r *http.Request) {
+			tenant, _ := user.ExtractOrgID(r.Context())
+			_, _ = fmt.Fprint(w, tenant)
+		})
+		app.Server.Router.HandleFunc("/traced", func(w http.ResponseWriter, r *http.Request) {
+			span, _ := opentracing.StartSpanFromContext(r.Context(), "lookup")
+			span.SetTag("tenant", "12345")
+			span.Finish()
+		})
+	})
+
+	code, body := app.Get(t, "12345", "/tenant")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "12345", body)
+
+	code, _ = app.Get(t, "", "/tenant")
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	require.NotEmpty(t, app.Tracer.FinishedSpans())
+
+	t.Run("spans", func(t *testing.T) {
+		code, _ := app.Get(t, "12345", "/traced")
+		require.Equal(t, http.StatusOK, code)
+
+		server := app.RequireSpan(t, "HTTP GET - traced", map[string]interface{}{"http.url": "/traced", "http.status_code": http.StatusOK})
+		lookup := app.RequireSpan(t, "lookup", map[string]interface{}{"tenant": "12345"})
+		RequireDescendantOf(t, app.Tracer, lookup, server)
+
+		require.False(t, hasTags(lookup, map[string]interface{}{"tenant": "other"}))
+		require.Contains(t, describeSpans(app.Tracer.FinishedSpans()), `"lookup" map[tenant:12345]`)
+	})
+}
+
+func TestRequireChildOf(t *testing.T) {
+	tracer := mocktracer.New()
+	parent := tracer.StartSpan("parent")
+	child := tracer.StartSpan("child", opentracing.ChildOf(parent.Context()))
+	grandchild := tracer.StartSpan("grandchild", opentracing.ChildOf(child.Context()))
+	grandchild.Finish()
+	child.Finish()
+	parent.Finish()
+
+	RequireChildOf(t, RequireSpan(t, tracer, "child", nil), RequireSpan(t, tracer, "parent", nil))
+	RequireDescendantOf(t, tracer, RequireSpan(t, tracer, "grandchild", nil), RequireSpan(t, tracer, "parent", nil))
+}
+
+func TestDownstream(t *testing.T) {
+	d := NewDownstream(t)
+	series := prompb.TimeSeries{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "test"}},
+		Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 5000, Value: 2}},
+	}
+
+	data, err := proto.Marshal(&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{series}})
+	require.NoError(t, err)
+	push := func(tenant string) int {
+		req, err := http.NewRequest(http.MethodPost, d.WriteURL(), bytes.NewReader(snappy.Encode(nil, data)))
+		require.NoError(t, err)
+		if tenant != "" {
+			req.Header.Set(user.OrgIDHeaderName, tenant)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusOK, push("12345"))
+	require.Equal(t, http.StatusUnauthorized, push(""))
+	require.Equal(t, []prompb.TimeSeries{series}, d.Series("12345"))
+	require.Empty(t, d.Series("other"))
+
+	q, err := remoteread.NewRemoteReadQueryable("test", d.ReadURL(), "12345", secrets.Secret{}, time.Second)
+	require.NoError(t, err)
+	querier, err := q.Querier(0, 2000)
+	require.NoError(t, err)
+	set := querier.Select(context.Background(), true, nil, labels.MustNewMatcher(labels.MatchEqual, "job", "test"))
+	require.True(t, set.Next())
+	require.Equal(t, "test", set.At().Labels().Get("job"))
+	it := set.At().Iterator(nil)
+	var timestamps []int64
+	for it.Next() != 0 {
+		ts, _ := it.At()
+		timestamps = append(timestamps, ts)
+	}
+	require.Equal(t, []int64{1000}, timestamps)
+	require.False(t, set.Next())
+	require.NoError(t, set.Err())
+}
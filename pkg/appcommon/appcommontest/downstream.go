@@ -0,0 +1,175 @@
+package appcommontest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+const (
+	// WritePath and ReadPath are the paths of the remote write and remote
+	// read APIs of Downstream, the same as Mimir's.
+	WritePath = "/api/v1/push"
+	ReadPath  = "/prometheus/api/v1/read"
+)
+
+// Downstream is an in-memory fake of Mimir's remote write and remote read
+// APIs. The series written by a tenant can be read back by the same tenant.
+type Downstream struct {
+	Server *httptest.Server
+
+	mtx sync.Mutex
+	// series are the series of each tenant, by tenant and labels.
+	series map[string]map[string]*prompb.TimeSeries
+}
+
+// NewDownstream starts a Downstream, closed when the test finishes.
+func NewDownstream(t testing.TB) *Downstream {
+	d := &Downstream{series: map[string]map[string]*prompb.TimeSeries{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc(WritePath, d.write)
+	mux.HandleFunc(ReadPath, d.read)
+	d.Server = httptest.NewServer(mux)
+	t.Cleanup(d.Server.Close)
+	return d
+}
+
+// WriteURL is the URL of the remote write API.
+func (d *Downstream) WriteURL() string {
+	return d.Server.URL + WritePath
+}
+
+// ReadURL is the URL of the remote read API.
+func (d *Downstream) ReadURL() string {
+	return d.Server.URL + ReadPath
+}
+
+// Add stores series for tenant, as if they were written.
+func (d *Downstream) Add(tenant string, series ...prompb.TimeSeries) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	tenantSeries, ok := d.series[tenant]
+	if !ok {
+		tenantSeries = map[string]*prompb.TimeSeries{}
+		d.series[tenant] = tenantSeries
+	}
+	for _, s := range series {
+		key := labelsFromProto(s.Labels).String()
+		stored, ok := tenantSeries[key]
+		if !ok {
+			stored = &prompb.TimeSeries{Labels: s.Labels}
+			tenantSeries[key] = stored
+		}
+		stored.Samples = append(stored.Samples, s.Samples...)
+		stored.Histograms = append(stored.Histograms, s.Histograms...)
+	}
+}
+
+// Series returns the series of tenant, sorted by labels.
+func (d *Downstream) Series(tenant string) []prompb.TimeSeries {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	keys := make([]string, 0, len(d.series[tenant]))
+	for key := range d.series[tenant] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]prompb.TimeSeries, 0, len(keys))
+	for _, key := range keys {
+		series = append(series, *d.series[tenant][key])
+	}
+	return series
+}
+
+func (d *Downstream) write(w http.ResponseWriter, r *http.Request) {
+	tenant, err := orgID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	req, err := remote.DecodeWriteRequest(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.Add(tenant, req.Timeseries...)
+}
+
+func (d *Downstream) read(w http.ResponseWriter, r *http.Request) {
+	tenant, err := orgID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	req, err := remote.DecodeReadRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := &prompb.ReadResponse{Results: make([]*prompb.QueryResult, 0, len(req.Queries))}
+	for _, query := range req.Queries {
+		matchers, err := remote.FromLabelMatchers(query.Matchers)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp.Results = append(resp.Results, &prompb.QueryResult{Timeseries: d.selectSeries(tenant, query.StartTimestampMs, query.EndTimestampMs, matchers)})
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	if err := remote.EncodeReadResponse(resp, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// selectSeries returns the series of tenant matching matchers, with their
+// samples between start and end.
+func (d *Downstream) selectSeries(tenant string, start, end int64, matchers []*labels.Matcher) []*prompb.TimeSeries {
+	var selected []*prompb.TimeSeries
+	for _, s := range d.Series(tenant) {
+		lbls := labelsFromProto(s.Labels)
+		if !matchesAll(lbls, matchers) {
+			continue
+		}
+		out := &prompb.TimeSeries{Labels: s.Labels}
+		for _, sample := range s.Samples {
+			if sample.Timestamp >= start && sample.Timestamp <= end {
+				out.Samples = append(out.Samples, sample)
+			}
+		}
+		if len(out.Samples) > 0 {
+			selected = append(selected, out)
+		}
+	}
+	return selected
+}
+
+func orgID(r *http.Request) (string, error) {
+	tenant, _, err := user.ExtractOrgIDFromHTTPRequest(r)
+	return tenant, err
+}
+
+func matchesAll(lbls labels.Labels, matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(lbls.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func labelsFromProto(lbls []prompb.Label) labels.Labels {
+	b := labels.NewScratchBuilder(len(lbls))
+	for _, l := range lbls {
+		b.Add(l.Name, l.Value)
+	}
+	b.Sort()
+	return b.Labels()
+}
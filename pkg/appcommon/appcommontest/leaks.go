@@ -0,0 +1,50 @@
+// Package appcommontest provides test helpers for apps built with appcommon:
+// an app started on random ports, fakes of its downstreams, leak checks and
+// assertions on the spans of the app.
+package appcommontest
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon"
+)
+
+// leakTimeout is how long the goroutines and file descriptors of the test are
+// given to go away after it finishes.
+const leakTimeout = 5 * time.Second
+
+// VerifyNoLeaks fails the test if, once it finishes, there are goroutines or
+// open file descriptors that weren't there when VerifyNoLeaks was called. Call
+// it first in the test, so its check runs after every other cleanup, and close
+// the idle connections of any HTTP client used by the test.
+//
+// File descriptors are only checked on Linux.
+func VerifyNoLeaks(t testing.TB, opts ...goleak.Option) {
+	t.Helper()
+	opts = append([]goleak.Option{goleak.IgnoreCurrent()}, opts...)
+	fdsBefore, fdErr := appcommon.CountOpenFDs()
+
+	t.Cleanup(func() {
+		if err := goleak.Find(opts...); err != nil {
+			t.Errorf("goroutines leaked: %v", err)
+		}
+		if fdErr != nil {
+			return
+		}
+		deadline := time.Now().Add(leakTimeout)
+		for {
+			fds, err := appcommon.CountOpenFDs()
+			if err != nil || fds <= fdsBefore {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("file descriptors leaked: %d open before the test, %d after", fdsBefore, fds)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package appcommontest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// spanTimeout is how long RequireSpan waits for the span to finish, as the
+// spans of the server finish after the response is sent.
+const spanTimeout = 5 * time.Second
+
+// RequireSpan returns the first finished span of tracer named operationName
+// with at least the given tags, failing the test if none finishes in time.
+// The tag values are compared as in assert.ObjectsAreEqualValues, so eg. an
+// int matches the uint16 HTTP status code tag.
+func RequireSpan(t testing.TB, tracer *mocktracer.MockTracer, operationName string, tags map[string]interface{}) *mocktracer.MockSpan {
+	t.Helper()
+	deadline := time.Now().Add(spanTimeout)
+	for {
+		spans := tracer.FinishedSpans()
+		for _, span := range spans {
+			if span.OperationName == operationName && hasTags(span, tags) {
+				return span
+			}
+		}
+		if time.Now().After(deadline) {
+			require.Failf(t, "span not found", "no finished span %q with tags %v in:\n%s", operationName, tags, describeSpans(spans))
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// RequireChildOf fails the test unless child is a child of parent, in the
+// same trace.
+func RequireChildOf(t testing.TB, child, parent *mocktracer.MockSpan) {
+	t.Helper()
+	require.Equal(t, parent.SpanContext.TraceID, child.SpanContext.TraceID, "span %q isn't in the trace of %q", child.OperationName, parent.OperationName)
+	require.Equal(t, parent.SpanContext.SpanID, child.ParentID, "span %q isn't a child of %q", child.OperationName, parent.OperationName)
+}
+
+// RequireDescendantOf fails the test unless span is a descendant of
+// ancestor, through finished spans of tracer, eg. when middlewares start
+// spans between the span of the server and the spans of the handler.
+func RequireDescendantOf(t testing.TB, tracer *mocktracer.MockTracer, span, ancestor *mocktracer.MockSpan) {
+	t.Helper()
+	spans := map[int]*mocktracer.MockSpan{}
+	for _, s := range tracer.FinishedSpans() {
+		spans[s.SpanContext.SpanID] = s
+	}
+	for s := span; s != nil; s = spans[s.ParentID] {
+		if s.ParentID == ancestor.SpanContext.SpanID && s.SpanContext.TraceID == ancestor.SpanContext.TraceID {
+			return
+		}
+	}
+	require.Failf(t, "span not a descendant", "span %q isn't a descendant of %q", span.OperationName, ancestor.OperationName)
+}
+
+// RequireSpan returns the first finished span of the app named
+// operationName with at least the given tags, see RequireSpan.
+func (a *App) RequireSpan(t testing.TB, operationName string, tags map[string]interface{}) *mocktracer.MockSpan {
+	t.Helper()
+	return RequireSpan(t, a.Tracer, operationName, tags)
+}
+
+func hasTags(span *mocktracer.MockSpan, tags map[string]interface{}) bool {
+	for k, want := range tags {
+		if got := span.Tag(k); got == nil || !assert.ObjectsAreEqualValues(want, got) {
+			return false
+		}
+	}
+	return true
+}
+
+// describeSpans lists the spans with their tags, for the failure messages.
+func describeSpans(spans []*mocktracer.MockSpan) string {
+	if len(spans) == 0 {
+		return "  (no finished spans)"
+	}
+	var b strings.Builder
+	for _, span := range spans {
+		fmt.Fprintf(&b, "  %q %v\n", span.OperationName, span.Tags())
+	}
+	return b.String()
+}
@@ -0,0 +1,316 @@
+package appcommon
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/cache"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	CacheBackendInMemory  = "inmemory"
+	CacheBackendMemcached = cache.BackendMemcached
+	CacheBackendRedis     = cache.BackendRedis
+)
+
+// Cache is a key-value cache of byte values, like the results of find and
+// render requests or verified tokens. Errors of the backend are logged and
+// counted as misses, a cache being an optimization.
+type Cache interface {
+	// Get returns the value of key, and whether it was found.
+	Get(ctx context.Context, key string) ([]byte, bool)
+	// MGet returns the values of the keys found.
+	MGet(ctx context.Context, keys []string) map[string][]byte
+	// Set stores the value of key for ttl. Remote backends store it
+	// asynchronously.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+}
+
+// CacheConfig configures a Cache. The app components with a cache embed one
+// under their own prefix, so each can use a different backend.
+type CacheConfig struct {
+	// Backend is one of inmemory, memcached or redis. The cache is disabled
+	// if empty.
+	Backend    string                      `yaml:"backend"`
+	MaxEntries int                         `yaml:"max_entries"`
+	Memcached  cache.MemcachedClientConfig `yaml:"memcached"`
+	Redis      cache.RedisClientConfig     `yaml:"redis"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *CacheConfig) RegisterFlags(flags *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (cfg *CacheConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.StringVar(&cfg.Backend, prefix+"cache.backend", "", fmt.Sprintf("Cache backend, one of %s, %s or %s. The cache is disabled if empty.", CacheBackendInMemory, CacheBackendMemcached, CacheBackendRedis))
+	flags.IntVar(&cfg.MaxEntries, prefix+"cache.inmemory.max-entries", 10000, "Max entries of the inmemory cache, the least recently used are evicted first.")
+	cfg.Memcached.RegisterFlagsWithPrefix(prefix+"cache.memcached.", flags)
+	cfg.Redis.RegisterFlagsWithPrefix(prefix+"cache.redis.", flags)
+}
+
+func (cfg *CacheConfig) Validate() error {
+	switch cfg.Backend {
+	case "":
+		return nil
+	case CacheBackendInMemory:
+		if cfg.MaxEntries <= 0 {
+			return errors.New("inmemory cache max entries must be positive")
+		}
+		return nil
+	case CacheBackendMemcached:
+		return cfg.Memcached.Validate()
+	case CacheBackendRedis:
+		return cfg.Redis.Validate()
+	default:
+		return fmt.Errorf("unsupported cache backend %q", cfg.Backend)
+	}
+}
+
+// NewCache creates the cache called name, which labels its metrics and
+// names its spans. It returns nil if the cache is disabled. The stop function
+// releases the connections of the remote backends.
+func NewCache(name string, cfg CacheConfig, logger log.Logger, metricPrefix string, reg prometheus.Registerer) (c Cache, stop func(), err error) {
+	stop = func() {}
+	switch cfg.Backend {
+	case "":
+		return nil, stop, nil
+	case CacheBackendInMemory:
+		if c, err = NewInMemoryCache(cfg.MaxEntries); err != nil {
+			return nil, nil, err
+		}
+	default:
+		client, err := cache.CreateClient(name, cache.BackendConfig{
+			Backend:   cfg.Backend,
+			Memcached: cfg.Memcached,
+			Redis:     cfg.Redis,
+		}, logger, reg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't create %s cache: %w", name, err)
+		}
+		c, stop = &remoteCache{client: client}, client.Stop
+	}
+
+	metrics, err := NewCacheMetrics(metricPrefix, reg)
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	return NewTracedCache(name, metrics.Instrument(name, c)), stop, nil
+}
+
+type cacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// InMemoryCache is an LRU Cache, for single replica deployments or as a
+// first level in front of a remote Cache.
+type InMemoryCache struct {
+	lru *lru.Cache[string, cacheEntry]
+	now func() time.Time
+}
+
+// NewInMemoryCache creates an InMemoryCache of at most maxEntries entries.
+func NewInMemoryCache(maxEntries int) (*InMemoryCache, error) {
+	l, err := lru.New[string, cacheEntry](maxEntries)
+	if err != nil {
+		return nil, fmt.Errorf("can't create inmemory cache: %w", err)
+	}
+	return &InMemoryCache{lru: l, now: time.Now}, nil
+}
+
+func (c *InMemoryCache) Get(_ context.Context, key string) ([]byte, bool) {
+	e, ok := c.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(e.expires) {
+		c.lru.Remove(key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *InMemoryCache) MGet(ctx context.Context, keys []string) map[string][]byte {
+	found := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if v, ok := c.Get(ctx, key); ok {
+			found[key] = v
+		}
+	}
+	return found
+}
+
+func (c *InMemoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.lru.Add(key, cacheEntry{value: value, expires: c.now().Add(ttl)})
+}
+
+// remoteCache adapts the memcached and redis clients of dskit, which log
+// and count their own errors.
+type remoteCache struct {
+	client cache.Cache
+}
+
+func (c *remoteCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	v, ok := c.client.GetMulti(ctx, []string{key})[key]
+	return v, ok
+}
+
+func (c *remoteCache) MGet(ctx context.Context, keys []string) map[string][]byte {
+	return c.client.GetMulti(ctx, keys)
+}
+
+func (c *remoteCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) {
+	c.client.SetAsync(key, value, ttl)
+}
+
+// CacheMetrics are the metrics of the caches, labeled with the cache name.
+type CacheMetrics struct {
+	requests *prometheus.CounterVec
+	hits     *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewCacheMetrics creates the cache metrics and registers them. They are
+// registered once, the caches created later reuse the registered ones.
+func NewCacheMetrics(metricPrefix string, reg prometheus.Registerer) (*CacheMetrics, error) {
+	m := &CacheMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricPrefix,
+			Name:      "cache_keys_requested_total",
+			Help:      "The number of keys looked up in the caches.",
+		}, []string{"cache"}),
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricPrefix,
+			Name:      "cache_keys_found_total",
+			Help:      "The number of keys found in the caches.",
+		}, []string{"cache"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricPrefix,
+			Name:      "cache_request_duration_seconds",
+			Help:      "Time spent on the requests of the caches.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"cache", "method"}),
+	}
+	var err error
+	if m.requests, err = registerOrExisting(reg, m.requests); err != nil {
+		return nil, err
+	}
+	if m.hits, err = registerOrExisting(reg, m.hits); err != nil {
+		return nil, err
+	}
+	if m.duration, err = registerOrExisting(reg, m.duration); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func registerOrExisting[C prometheus.Collector](reg prometheus.Registerer, c C) (C, error) {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing, nil
+			}
+		}
+		return c, err
+	}
+	return c, nil
+}
+
+// Instrument returns c recording its requests in the metrics, labeled with
+// name.
+func (m *CacheMetrics) Instrument(name string, c Cache) Cache {
+	return &instrumentedCache{
+		next:     c,
+		requests: m.requests.WithLabelValues(name),
+		hits:     m.hits.WithLabelValues(name),
+		duration: m.duration.MustCurryWith(prometheus.Labels{"cache": name}),
+	}
+}
+
+type instrumentedCache struct {
+	next     Cache
+	requests prometheus.Counter
+	hits     prometheus.Counter
+	duration prometheus.ObserverVec
+}
+
+func (c *instrumentedCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	start := time.Now()
+	v, ok := c.next.Get(ctx, key)
+	c.duration.WithLabelValues("get").Observe(time.Since(start).Seconds())
+	c.requests.Inc()
+	if ok {
+		c.hits.Inc()
+	}
+	return v, ok
+}
+
+func (c *instrumentedCache) MGet(ctx context.Context, keys []string) map[string][]byte {
+	start := time.Now()
+	found := c.next.MGet(ctx, keys)
+	c.duration.WithLabelValues("mget").Observe(time.Since(start).Seconds())
+	c.requests.Add(float64(len(keys)))
+	c.hits.Add(float64(len(found)))
+	return found
+}
+
+func (c *instrumentedCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	start := time.Now()
+	c.next.Set(ctx, key, value, ttl)
+	c.duration.WithLabelValues("set").Observe(time.Since(start).Seconds())
+}
+
+// NewTracedCache returns c recording a span for each of its requests, named
+// after the cache name.
+func NewTracedCache(name string, c Cache) Cache {
+	return &tracedCache{name: name, next: c}
+}
+
+type tracedCache struct {
+	name string
+	next Cache
+}
+
+func (c *tracedCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, c.name+".Get")
+	defer span.Finish()
+	v, ok := c.next.Get(ctx, key)
+	span.SetTag("hit", ok)
+	return v, ok
+}
+
+func (c *tracedCache) MGet(ctx context.Context, keys []string) map[string][]byte {
+	span, ctx := opentracing.StartSpanFromContext(ctx, c.name+".MGet")
+	defer span.Finish()
+	found := c.next.MGet(ctx, keys)
+	span.SetTag("keys", len(keys))
+	span.SetTag("hits", len(found))
+	return found
+}
+
+func (c *tracedCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, c.name+".Set")
+	defer span.Finish()
+	span.SetTag("bytes", len(value))
+	c.next.Set(ctx, key, value, ttl)
+}
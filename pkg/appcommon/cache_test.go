@@ -0,0 +1,96 @@
+package appcommon
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewInMemoryCache(2)
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Second)
+	c.Set(ctx, "c", []byte("3"), 0)
+	v, ok := c.Get(ctx, "a")
+	require.True(t, ok)
+	require.Equal(t, []byte("1"), v)
+	require.Equal(t, map[string][]byte{"a": []byte("1"), "b": []byte("2")}, c.MGet(ctx, []string{"a", "b", "c"}))
+
+	// Entries expire after their TTL.
+	now = now.Add(time.Second)
+	_, ok = c.Get(ctx, "b")
+	require.False(t, ok)
+
+	// The least recently used entries are evicted first.
+	c.Set(ctx, "d", []byte("4"), time.Minute)
+	c.Set(ctx, "e", []byte("5"), time.Minute)
+	require.Equal(t, map[string][]byte{"d": []byte("4"), "e": []byte("5")}, c.MGet(ctx, []string{"a", "d", "e"}))
+}
+
+func TestNewCache(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		c, stop, err := NewCache("find", CacheConfig{}, log.NewNopLogger(), "test", prometheus.NewPedanticRegistry())
+		require.NoError(t, err)
+		defer stop()
+		require.Nil(t, c)
+	})
+
+	t.Run("instrumented", func(t *testing.T) {
+		tracer := mocktracer.New()
+		opentracing.SetGlobalTracer(tracer)
+		defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+		ctx := context.Background()
+		reg := prometheus.NewPedanticRegistry()
+		find, stop, err := NewCache("find", CacheConfig{Backend: CacheBackendInMemory, MaxEntries: 10}, log.NewNopLogger(), "test", reg)
+		require.NoError(t, err)
+		defer stop()
+		render, stop, err := NewCache("render", CacheConfig{Backend: CacheBackendInMemory, MaxEntries: 10}, log.NewNopLogger(), "test", reg)
+		require.NoError(t, err)
+		defer stop()
+
+		find.Set(ctx, "a", []byte("1"), time.Minute)
+		find.MGet(ctx, []string{"a", "b"})
+		_, ok := render.Get(ctx, "a")
+		require.False(t, ok)
+
+		require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP test_cache_keys_found_total The number of keys found in the caches.
+# TYPE test_cache_keys_found_total counter
+test_cache_keys_found_total{cache="find"} 1
+test_cache_keys_found_total{cache="render"} 0
+# HELP test_cache_keys_requested_total The number of keys looked up in the caches.
+# TYPE test_cache_keys_requested_total counter
+test_cache_keys_requested_total{cache="find"} 2
+test_cache_keys_requested_total{cache="render"} 1
+`), "test_cache_keys_found_total", "test_cache_keys_requested_total"))
+
+		var ops []string
+		for _, span := range tracer.FinishedSpans() {
+			ops = append(ops, span.OperationName)
+		}
+		require.Equal(t, []string{"find.Set", "find.MGet", "render.Get"}, ops)
+		require.Equal(t, 1, tracer.FinishedSpans()[1].Tag("hits"))
+	})
+}
+
+func TestCacheConfig_Validate(t *testing.T) {
+	require.NoError(t, (&CacheConfig{}).Validate())
+	require.NoError(t, (&CacheConfig{Backend: CacheBackendInMemory, MaxEntries: 1}).Validate())
+	require.Error(t, (&CacheConfig{Backend: CacheBackendInMemory}).Validate())
+	require.Error(t, (&CacheConfig{Backend: CacheBackendMemcached}).Validate())
+	require.Error(t, (&CacheConfig{Backend: "disk"}).Validate())
+}
@@ -0,0 +1,170 @@
+package appcommon
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Validator is implemented by config structs that can check their own values.
+type Validator interface {
+	Validate() error
+}
+
+// Validate checks the config for values the app can't start with.
+func (cfg *Config) Validate() error {
+	if cfg.ServiceName == "" {
+		return fmt.Errorf("service name can't be empty")
+	}
+	if _, err := parseFloats(cfg.InstrumentBuckets); err != nil {
+		return fmt.Errorf("can't parse instrument buckets: %w", err)
+	}
+	if err := validateProfile(cfg.Profile); err != nil {
+		return err
+	}
+	if err := cfg.ServerConfig.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.InternalServerConfig.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Diagnostics.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.HTTPClient.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.TailSampling.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Startup.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.UsageStats.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.TenantUsage.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Log.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Sharding.Validate(); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Components)) {
+		if err := cfg.Components[name].Validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if cfg.ServerConfig.HTTPUnixSocketPath == "" &&
+		cfg.ServerConfig.HTTPListenPort != 0 &&
+		cfg.ServerConfig.HTTPListenPort == cfg.InternalServerConfig.HTTPListenPort {
+		return fmt.Errorf("server and internal server can't both listen on port %d", cfg.ServerConfig.HTTPListenPort)
+	}
+	return nil
+}
+
+// CheckConfig validates cfg and writes a normalized YAML dump of it to w, so
+// deployment configs can be checked in CI without starting the app. The dump
+// is written even if the config is invalid, and the validation error is
+// returned. Durations are printed in their human readable form, and fields
+// that marshal themselves to YAML, like dskit's flagext.Secret, are redacted
+// by their own MarshalYAML.
+func CheckConfig(w io.Writer, cfg Validator) error {
+	out, err := yaml.Marshal(normalizeConfig(reflect.ValueOf(cfg)))
+	if err != nil {
+		return fmt.Errorf("can't marshal config: %w", err)
+	}
+	if _, err := w.Write(out); err != nil {
+		return err
+	}
+	return cfg.Validate()
+}
+
+var (
+	durationType      = reflect.TypeOf(time.Duration(0))
+	yamlMarshalerType = reflect.TypeOf((*yaml.Marshaler)(nil)).Elem()
+)
+
+// normalizeConfig turns a config value into plain maps, slices and scalars,
+// keyed by the yaml tags of the struct fields.
+func normalizeConfig(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(yamlMarshalerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return nil
+		}
+		if out, err := v.Interface().(yaml.Marshaler).MarshalYAML(); err == nil {
+			return out
+		}
+	}
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return normalizeConfig(v.Elem())
+	case reflect.Struct:
+		out := map[string]interface{}{}
+		normalizeStruct(v, out)
+		return out
+	case reflect.Slice, reflect.Array:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = normalizeConfig(v.Index(i))
+		}
+		return out
+	case reflect.Map:
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = normalizeConfig(iter.Value())
+		}
+		return out
+	case reflect.Func, reflect.Chan:
+		return nil
+	default:
+		return v.Interface()
+	}
+}
+
+func normalizeStruct(v reflect.Value, out map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			fv := v.Field(i)
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				normalizeStruct(fv, out)
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		out[name] = normalizeConfig(v.Field(i))
+	}
+}
@@ -0,0 +1,218 @@
+package appcommon
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// RenamedField is a config field whose flag or YAML key was renamed. The old
+// name keeps working, with a warning, so deployments can migrate at their
+// own pace.
+type RenamedField struct {
+	// OldFlag and NewFlag are the flag names, without the leading dash.
+	OldFlag string
+	NewFlag string
+	// OldYAML and NewYAML are the dotted paths of the YAML keys, eg.
+	// "storage.query_timeout".
+	OldYAML string
+	NewYAML string
+	// FailAfter, if set, is when using the old name starts failing the
+	// startup instead of logging a warning.
+	FailAfter time.Time
+}
+
+// ConfigDeprecations tracks the use of the old names of renamed config
+// fields. Apps register the old flags with RegisterFlags and migrate their
+// YAML config files with MigrateYAML, then set it in Config.Deprecations so
+// the harness reports the old names used when the app starts.
+type ConfigDeprecations struct {
+	fields []RenamedField
+
+	mtx sync.Mutex
+	// used are the fields whose old name is used, by old name.
+	used map[string]RenamedField
+}
+
+func NewConfigDeprecations(fields ...RenamedField) *ConfigDeprecations {
+	return &ConfigDeprecations{fields: fields, used: map[string]RenamedField{}}
+}
+
+func (d *ConfigDeprecations) markUsed(oldName string, field RenamedField) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.used[oldName] = field
+}
+
+// RegisterFlags registers the old flags as aliases of the new flags, which
+// must already be registered in flags.
+func (d *ConfigDeprecations) RegisterFlags(flags *flag.FlagSet) {
+	for _, field := range d.fields {
+		if field.OldFlag == "" {
+			continue
+		}
+		newFlag := flags.Lookup(field.NewFlag)
+		if newFlag == nil {
+			panic(fmt.Sprintf("flag %s renamed to %s, which isn't registered", field.OldFlag, field.NewFlag))
+		}
+		flags.Var(&aliasFlag{Value: newFlag.Value, set: func() { d.markUsed(field.OldFlag, field) }}, field.OldFlag, fmt.Sprintf("Deprecated: use -%s instead.", field.NewFlag))
+	}
+}
+
+// aliasFlag sets the value of another flag.
+type aliasFlag struct {
+	flag.Value
+	set func()
+}
+
+func (f *aliasFlag) Set(s string) error {
+	f.set()
+	return f.Value.Set(s)
+}
+
+func (f *aliasFlag) IsBoolFlag() bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// MigrateYAML returns the YAML document in content with the old keys moved
+// to their new paths. It fails if a document sets both the old and the new
+// key of a field.
+func (d *ConfigDeprecations) MigrateYAML(content []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return content, nil
+	}
+	root := doc.Content[0]
+
+	migrated := false
+	for _, field := range d.fields {
+		if field.OldYAML == "" {
+			continue
+		}
+		key, value := removeYAMLPath(root, strings.Split(field.OldYAML, "."))
+		if key == nil {
+			continue
+		}
+		newPath := strings.Split(field.NewYAML, ".")
+		if err := setYAMLPath(root, newPath, value); err != nil {
+			return nil, fmt.Errorf("can't move %s to %s: %w", field.OldYAML, field.NewYAML, err)
+		}
+		d.markUsed(field.OldYAML, field)
+		migrated = true
+	}
+	if !migrated {
+		return content, nil
+	}
+	return yaml.Marshal(&doc)
+}
+
+// removeYAMLPath removes the key at path from the mapping node, and returns
+// the key and value removed, or nil if there is no such key.
+func removeYAMLPath(node *yaml.Node, path []string) (key, value *yaml.Node) {
+	for i, name := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil, nil
+		}
+		idx := yamlKeyIndex(node, name)
+		if idx < 0 {
+			return nil, nil
+		}
+		if i == len(path)-1 {
+			key, value = node.Content[idx], node.Content[idx+1]
+			node.Content = append(node.Content[:idx], node.Content[idx+2:]...)
+			return key, value
+		}
+		node = node.Content[idx+1]
+	}
+	return nil, nil
+}
+
+// setYAMLPath sets value at path in the mapping node, creating the missing
+// mappings on the way.
+func setYAMLPath(node *yaml.Node, path []string, value *yaml.Node) error {
+	for i, name := range path {
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s isn't a mapping", strings.Join(path[:i], "."))
+		}
+		idx := yamlKeyIndex(node, name)
+		if i == len(path)-1 {
+			if idx >= 0 {
+				return fmt.Errorf("%s is already set", strings.Join(path, "."))
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, value)
+			return nil
+		}
+		if idx < 0 {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+			idx = len(node.Content) - 2
+		}
+		node = node.Content[idx+1]
+	}
+	return nil
+}
+
+func yamlKeyIndex(mapping *yaml.Node, name string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Check logs a warning for each old name used, exports them in the
+// config_deprecated_names_used metric, and returns an error if any of them is
+// used past its FailAfter date.
+func (d *ConfigDeprecations) Check(logger log.Logger, metricPrefix string, reg prometheus.Registerer, now time.Time) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	used := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricPrefix,
+		Name:      "config_deprecated_names_used",
+		Help:      "Set to 1 for each deprecated config name used, by old and new name.",
+	}, []string{"old", "new"})
+	if err := reg.Register(used); err != nil {
+		return err
+	}
+
+	oldNames := make([]string, 0, len(d.used))
+	for oldName := range d.used {
+		oldNames = append(oldNames, oldName)
+	}
+	sort.Strings(oldNames)
+
+	var errs []error
+	for _, oldName := range oldNames {
+		field := d.used[oldName]
+		newName := field.NewFlag
+		if oldName == field.OldYAML {
+			newName = field.NewYAML
+		}
+		used.WithLabelValues(oldName, newName).Set(1)
+
+		if !field.FailAfter.IsZero() && now.After(field.FailAfter) {
+			errs = append(errs, fmt.Errorf("config %s was renamed to %s and isn't supported since %s", oldName, newName, field.FailAfter.Format(time.DateOnly)))
+			continue
+		}
+		logger := log.With(logger, "old", oldName, "new", newName)
+		if !field.FailAfter.IsZero() {
+			logger = log.With(logger, "fail_after", field.FailAfter.Format(time.DateOnly))
+		}
+		_ = level.Warn(logger).Log("msg", "deprecated config name used, rename it")
+	}
+	return errors.Join(errs...)
+}
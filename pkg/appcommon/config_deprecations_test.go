@@ -0,0 +1,98 @@
+package appcommon
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigDeprecations_Flags(t *testing.T) {
+	var cfg struct {
+		Timeout time.Duration
+		Strict  bool
+	}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.DurationVar(&cfg.Timeout, "storage.timeout", time.Second, "")
+	flags.BoolVar(&cfg.Strict, "storage.strict", false, "")
+
+	d := NewConfigDeprecations(
+		RenamedField{OldFlag: "storage.query-timeout", NewFlag: "storage.timeout"},
+		RenamedField{OldFlag: "storage.strict-mode", NewFlag: "storage.strict"},
+	)
+	d.RegisterFlags(flags)
+	require.NoError(t, flags.Parse([]string{"-storage.query-timeout=5s", "-storage.strict-mode"}))
+	require.Equal(t, 5*time.Second, cfg.Timeout)
+	require.True(t, cfg.Strict)
+
+	var logs bytes.Buffer
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, d.Check(log.NewLogfmtLogger(&logs), "test", reg, time.Now()))
+	require.Contains(t, logs.String(), "old=storage.query-timeout new=storage.timeout")
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP test_config_deprecated_names_used Set to 1 for each deprecated config name used, by old and new name.
+# TYPE test_config_deprecated_names_used gauge
+test_config_deprecated_names_used{new="storage.strict",old="storage.strict-mode"} 1
+test_config_deprecated_names_used{new="storage.timeout",old="storage.query-timeout"} 1
+`)))
+
+	t.Run("unregistered new flag", func(t *testing.T) {
+		require.Panics(t, func() {
+			NewConfigDeprecations(RenamedField{OldFlag: "a", NewFlag: "b"}).RegisterFlags(flag.NewFlagSet("test", flag.ContinueOnError))
+		})
+	})
+}
+
+func TestConfigDeprecations_MigrateYAML(t *testing.T) {
+	d := NewConfigDeprecations(
+		RenamedField{OldYAML: "storage.query_timeout", NewYAML: "storage.timeout"},
+		RenamedField{OldYAML: "max_series", NewYAML: "limits.max_series"},
+		RenamedField{OldYAML: "unused", NewYAML: "still_unused"},
+	)
+	migrated, err := d.MigrateYAML([]byte(`
+storage:
+  endpoint: http://mimir
+  query_timeout: 5s
+max_series: 100
+`))
+	require.NoError(t, err)
+	require.YAMLEq(t, `
+storage:
+  endpoint: http://mimir
+  timeout: 5s
+limits:
+  max_series: 100
+`, string(migrated))
+
+	t.Run("unchanged", func(t *testing.T) {
+		content := []byte("storage:\n  timeout: 5s # kept\n")
+		migrated, err := NewConfigDeprecations(RenamedField{OldYAML: "storage.query_timeout", NewYAML: "storage.timeout"}).MigrateYAML(content)
+		require.NoError(t, err)
+		require.Equal(t, content, migrated)
+	})
+
+	t.Run("both names set", func(t *testing.T) {
+		_, err := d.MigrateYAML([]byte("storage:\n  query_timeout: 5s\n  timeout: 1s\n"))
+		require.ErrorContains(t, err, "storage.timeout is already set")
+	})
+}
+
+func TestConfigDeprecations_Check(t *testing.T) {
+	failAfter := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := NewConfigDeprecations(RenamedField{OldYAML: "query_timeout", NewYAML: "timeout", FailAfter: failAfter})
+	_, err := d.MigrateYAML([]byte("query_timeout: 5s"))
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	require.NoError(t, d.Check(log.NewLogfmtLogger(&logs), "", prometheus.NewRegistry(), failAfter.Add(-time.Hour)))
+	require.Contains(t, logs.String(), "fail_after=2026-01-01")
+
+	err = d.Check(log.NewNopLogger(), "", prometheus.NewRegistry(), failAfter.Add(time.Hour))
+	require.EqualError(t, err, "config query_timeout was renamed to timeout and isn't supported since 2026-01-01")
+}
@@ -0,0 +1,160 @@
+package appcommon
+
+import (
+	"bytes"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	defaultConfig := func() Config {
+		var cfg Config
+		cfg.RegisterFlags(flag.NewFlagSet("test", flag.PanicOnError))
+		cfg.ServiceName = "test"
+		return cfg
+	}
+
+	for name, tc := range map[string]struct {
+		mutate  func(cfg *Config)
+		wantErr string
+	}{
+		"defaults are valid": {
+			mutate: func(*Config) {},
+		},
+		"empty service name": {
+			mutate:  func(cfg *Config) { cfg.ServiceName = "" },
+			wantErr: "service name can't be empty",
+		},
+		"unparseable buckets": {
+			mutate:  func(cfg *Config) { cfg.InstrumentBuckets = "0.1,fast" },
+			wantErr: "can't parse instrument buckets",
+		},
+		"unknown profile": {
+			mutate:  func(cfg *Config) { cfg.Profile = "batch" },
+			wantErr: `unknown profile "batch"`,
+		},
+		"negative server timeout": {
+			mutate:  func(cfg *Config) { cfg.ServerConfig.HTTPServerReadTimeout = -time.Second },
+			wantErr: "http server read timeout can't be negative",
+		},
+		"malformed listen address": {
+			mutate:  func(cfg *Config) { cfg.ServerConfig.HTTPListenAddress = "0.0.0.0:8080" },
+			wantErr: "invalid http listen address",
+		},
+		"negative header limit": {
+			mutate:  func(cfg *Config) { cfg.ServerConfig.HTTPMaxHeaderCount = -1 },
+			wantErr: "http max uri length and header limits can't be negative",
+		},
+		"negative decompression ratio": {
+			mutate:  func(cfg *Config) { cfg.ServerConfig.HTTPMaxDecompressionRatio = -1 },
+			wantErr: "http decompression limits can't be negative",
+		},
+		"shadow percent out of range": {
+			mutate:  func(cfg *Config) { cfg.ServerConfig.ShadowPercent = 101 },
+			wantErr: "shadow percent 101 must be between 0 and 100",
+		},
+		"relative shadow URL": {
+			mutate:  func(cfg *Config) { cfg.ServerConfig.ShadowURL = "/graphite" },
+			wantErr: `shadow URL "/graphite" must be an absolute http or https URL`,
+		},
+		"usage stats without a URL": {
+			mutate:  func(cfg *Config) { cfg.UsageStats.Enabled = true },
+			wantErr: `usage stats URL "" must be an absolute http or https URL`,
+		},
+		"unknown tenant usage sink": {
+			mutate: func(cfg *Config) {
+				cfg.TenantUsage.Enabled = true
+				cfg.TenantUsage.Sink = "s3"
+			},
+			wantErr: `unknown tenant usage sink "s3"`,
+		},
+		"tenant usage kafka sink without brokers": {
+			mutate: func(cfg *Config) {
+				cfg.TenantUsage.Enabled = true
+				cfg.TenantUsage.Sink = TenantUsageSinkKafka
+			},
+			wantErr: "tenant usage kafka sink needs brokers and a topic",
+		},
+		"internal server port out of range": {
+			mutate:  func(cfg *Config) { cfg.InternalServerConfig.HTTPListenPort = 70000 },
+			wantErr: "internal server listen port 70000 is out of range",
+		},
+		"server and internal server on the same port": {
+			mutate:  func(cfg *Config) { cfg.InternalServerConfig.HTTPListenPort = cfg.ServerConfig.HTTPListenPort },
+			wantErr: "server and internal server can't both listen on port 8000",
+		},
+		"same port is fine when the server uses a unix socket": {
+			mutate: func(cfg *Config) {
+				cfg.InternalServerConfig.HTTPListenPort = cfg.ServerConfig.HTTPListenPort
+				cfg.ServerConfig.HTTPUnixSocketPath = "/tmp/server.sock"
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tc.mutate(&cfg)
+			err := cfg.Validate()
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
+
+type checkedConfig struct {
+	Timeout  time.Duration   `yaml:"timeout"`
+	Password flagext.Secret  `yaml:"password"`
+	Nested   checkedNested   `yaml:"nested"`
+	Inlined  checkedInlined  `yaml:",inline"`
+	Hidden   string          `yaml:"-"`
+	Callback func() error    `yaml:"callback"`
+	Items    []checkedNested `yaml:"items"`
+
+	err error
+}
+
+type checkedNested struct {
+	Name string
+}
+
+type checkedInlined struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+func (c *checkedConfig) Validate() error { return c.err }
+
+func TestCheckConfig(t *testing.T) {
+	cfg := &checkedConfig{
+		Timeout:  90 * time.Second,
+		Password: flagext.SecretWithValue("hunter2"),
+		Nested:   checkedNested{Name: "nested"},
+		Inlined:  checkedInlined{Enabled: true},
+		Hidden:   "hidden",
+		Items:    []checkedNested{{Name: "first"}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, CheckConfig(&buf, cfg))
+	require.Equal(t, `callback: null
+enabled: true
+items:
+    - name: first
+nested:
+    name: nested
+password: '********'
+timeout: 1m30s
+`, buf.String())
+
+	t.Run("config is still printed when invalid", func(t *testing.T) {
+		cfg.err = flag.ErrHelp
+		buf.Reset()
+		require.ErrorIs(t, CheckConfig(&buf, cfg), flag.ErrHelp)
+		require.Contains(t, buf.String(), "timeout: 1m30s")
+	})
+}
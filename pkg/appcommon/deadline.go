@@ -0,0 +1,32 @@
+package appcommon
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
+)
+
+// RemainingBudget returns how long is left until the deadline of ctx, which is
+// negative once it has passed, and false if ctx has no deadline. Requests
+// served by the app get their deadline from the server's request timeout or
+// the caller's X-Deadline header.
+func RemainingBudget(ctx context.Context, now time.Time) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return deadline.Sub(now), true
+}
+
+// InjectDeadlineIntoHTTPRequest sets the deadline of ctx in the X-Deadline
+// header of an outgoing request, so the downstream can abandon the work once
+// the caller has given up. gRPC requests don't need this, as gRPC sends the
+// context deadline in the grpc-timeout header itself.
+func InjectDeadlineIntoHTTPRequest(ctx context.Context, req *http.Request) {
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(middleware.DeadlineHeader, strconv.FormatInt(deadline.UnixMilli(), 10))
+	}
+}
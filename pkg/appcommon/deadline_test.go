@@ -0,0 +1,35 @@
+package appcommon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
+)
+
+func TestDeadlinePropagation(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+
+	_, ok := RemainingBudget(context.Background(), now)
+	require.False(t, ok)
+	req, err := http.NewRequest(http.MethodPost, "http://mimir/api/v1/push", nil)
+	require.NoError(t, err)
+	InjectDeadlineIntoHTTPRequest(context.Background(), req)
+	require.Empty(t, req.Header.Get(middleware.DeadlineHeader))
+
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(3*time.Second))
+	defer cancel()
+	budget, ok := RemainingBudget(ctx, now)
+	require.True(t, ok)
+	require.Equal(t, 3*time.Second, budget)
+
+	InjectDeadlineIntoHTTPRequest(ctx, req)
+	require.Equal(t, "1700000003000", req.Header.Get(middleware.DeadlineHeader))
+	deadline, ok := middleware.ParseDeadlineHeader(req.Header)
+	require.True(t, ok)
+	require.Equal(t, now.Add(3*time.Second), deadline)
+}
@@ -0,0 +1,218 @@
+package appcommon
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/backoff"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/internalserver"
+)
+
+// Dependency is something the app needs before it can serve requests, like a
+// KV store, a cache or a downstream being healthy.
+type Dependency struct {
+	Name string
+	// DependsOn are the names of the dependencies started before this one.
+	DependsOn []string
+	// Start starts or checks the dependency. It's retried with backoff until
+	// it succeeds, within StartupConfig.AttemptTimeout each time.
+	Start func(ctx context.Context) error
+}
+
+type StartupConfig struct {
+	Retries        int           `yaml:"retries"`
+	MinBackoff     time.Duration `yaml:"min_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	AttemptTimeout time.Duration `yaml:"attempt_timeout"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *StartupConfig) RegisterFlags(flags *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+//
+//nolint:gomnd
+func (cfg *StartupConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.IntVar(&cfg.Retries, prefix+"startup.retries", 10, "How many times starting a dependency of the app is retried before the app gives up and exits.")
+	flags.DurationVar(&cfg.MinBackoff, prefix+"startup.min-backoff", 100*time.Millisecond, "Min delay between the attempts to start a dependency.")
+	flags.DurationVar(&cfg.MaxBackoff, prefix+"startup.max-backoff", 10*time.Second, "Max delay between the attempts to start a dependency.")
+	flags.DurationVar(&cfg.AttemptTimeout, prefix+"startup.attempt-timeout", 30*time.Second, "Timeout of each attempt to start a dependency. 0 for no timeout.")
+}
+
+func (cfg *StartupConfig) Validate() error {
+	if cfg.Retries < 0 {
+		return errors.New("startup retries can't be negative")
+	}
+	if cfg.MinBackoff < 0 || cfg.MaxBackoff < 0 || cfg.AttemptTimeout < 0 {
+		return errors.New("startup backoffs and timeout can't be negative")
+	}
+	return nil
+}
+
+// dependencies starts the dependencies added to the app in order, and
+// reports the app ready once they all started.
+type dependencies struct {
+	cfg    StartupConfig
+	logger log.Logger
+
+	mtx  sync.Mutex
+	deps []Dependency
+
+	started atomic.Bool
+}
+
+func newDependencies(cfg StartupConfig, logger log.Logger) *dependencies {
+	return &dependencies{cfg: cfg, logger: logger}
+}
+
+func (d *dependencies) add(dep Dependency) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.deps = append(d.deps, dep)
+}
+
+// Ready implements internalserver.ReadinessProvider.
+func (d *dependencies) Ready() bool {
+	return d.started.Load()
+}
+
+// Handler returns the run.Group actor starting the dependencies. It fails,
+// stopping the app, if one of them can't be started.
+func (d *dependencies) Handler() (run func() error, stop func(error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return func() error {
+			if err := d.startAll(ctx); err != nil {
+				return err
+			}
+			<-ctx.Done()
+			return nil
+		}, func(error) {
+			cancel()
+		}
+}
+
+func (d *dependencies) startAll(ctx context.Context) error {
+	d.mtx.Lock()
+	ordered, err := orderDependencies(d.deps)
+	d.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, dep := range ordered {
+		if err := d.start(ctx, dep); err != nil {
+			return err
+		}
+	}
+	d.started.Store(true)
+	return nil
+}
+
+func (d *dependencies) start(ctx context.Context, dep Dependency) error {
+	retries := backoff.New(ctx, backoff.Config{
+		MinBackoff: d.cfg.MinBackoff,
+		MaxBackoff: d.cfg.MaxBackoff,
+		MaxRetries: d.cfg.Retries + 1,
+	})
+	var err error
+	for retries.Ongoing() {
+		start := time.Now()
+		if err = d.attempt(ctx, dep); err == nil {
+			level.Info(d.logger).Log("msg", "started dependency", "dependency", dep.Name, "duration", time.Since(start))
+			return nil
+		}
+		level.Warn(d.logger).Log("msg", "failed to start dependency", "dependency", dep.Name, "attempt", retries.NumRetries()+1, "err", err)
+		retries.Wait()
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("can't start %s after %d attempts: %w", dep.Name, retries.NumRetries(), err)
+}
+
+func (d *dependencies) attempt(ctx context.Context, dep Dependency) error {
+	if d.cfg.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.cfg.AttemptTimeout)
+		defer cancel()
+	}
+	return dep.Start(ctx)
+}
+
+// orderDependencies returns the dependencies in an order starting each one
+// after the ones it depends on, keeping the order they were added in
+// otherwise.
+func orderDependencies(deps []Dependency) ([]Dependency, error) {
+	byName := make(map[string]Dependency, len(deps))
+	for _, dep := range deps {
+		if _, ok := byName[dep.Name]; ok {
+			return nil, fmt.Errorf("dependency %s was added twice", dep.Name)
+		}
+		byName[dep.Name] = dep
+	}
+	for _, dep := range deps {
+		for _, name := range dep.DependsOn {
+			if _, ok := byName[name]; !ok {
+				return nil, fmt.Errorf("dependency %s depends on unknown dependency %s", dep.Name, name)
+			}
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(deps))
+	ordered := make([]Dependency, 0, len(deps))
+	var visit func(dep Dependency, path []string) error
+	visit = func(dep Dependency, path []string) error {
+		switch state[dep.Name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, dep.Name), " -> "))
+		}
+		state[dep.Name] = visiting
+		for _, name := range dep.DependsOn {
+			if err := visit(byName[name], append(path, dep.Name)); err != nil {
+				return err
+			}
+		}
+		state[dep.Name] = visited
+		ordered = append(ordered, dep)
+		return nil
+	}
+	for _, dep := range deps {
+		if err := visit(dep, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// allReady is ready when all its providers are.
+type allReady []internalserver.ReadinessProvider
+
+func (r allReady) Ready() bool {
+	for _, p := range r {
+		if !p.Ready() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package appcommon
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDependencies(t *testing.T) {
+	cfg := StartupConfig{Retries: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	t.Run("started in order", func(t *testing.T) {
+		deps := newDependencies(cfg, log.NewNopLogger())
+		var started []string
+		failures := 2
+		add := func(name string, dependsOn ...string) {
+			deps.add(Dependency{Name: name, DependsOn: dependsOn, Start: func(context.Context) error {
+				if name == "kv" && failures > 0 {
+					failures--
+					return errors.New("connection refused")
+				}
+				started = append(started, name)
+				return nil
+			}})
+		}
+		add("remote-read", "cache")
+		add("cache", "kv")
+		add("kv")
+
+		run, stop := deps.Handler()
+		done := make(chan error)
+		go func() { done <- run() }()
+		require.Eventually(t, deps.Ready, time.Second, time.Millisecond)
+		require.Equal(t, []string{"kv", "cache", "remote-read"}, started)
+
+		stop(nil)
+		require.NoError(t, <-done)
+	})
+
+	t.Run("retries are bounded", func(t *testing.T) {
+		deps := newDependencies(cfg, log.NewNopLogger())
+		attempts := 0
+		deps.add(Dependency{Name: "kv", Start: func(context.Context) error {
+			attempts++
+			return errors.New("connection refused")
+		}})
+
+		run, _ := deps.Handler()
+		require.EqualError(t, run(), "can't start kv after 3 attempts: connection refused")
+		require.Equal(t, 3, attempts)
+		require.False(t, deps.Ready())
+	})
+
+	t.Run("attempts time out", func(t *testing.T) {
+		deps := newDependencies(StartupConfig{AttemptTimeout: time.Millisecond}, log.NewNopLogger())
+		deps.add(Dependency{Name: "cache", Start: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}})
+
+		run, _ := deps.Handler()
+		require.ErrorIs(t, run(), context.DeadlineExceeded)
+	})
+}
+
+func TestOrderDependencies(t *testing.T) {
+	start := func(context.Context) error { return nil }
+	for name, tc := range map[string]struct {
+		deps    []Dependency
+		want    []string
+		wantErr string
+	}{
+		"no dependencies": {
+			deps: []Dependency{{Name: "a", Start: start}, {Name: "b", Start: start}},
+			want: []string{"a", "b"},
+		},
+		"ordered": {
+			deps: []Dependency{{Name: "a", DependsOn: []string{"c"}}, {Name: "b"}, {Name: "c", DependsOn: []string{"b"}}},
+			want: []string{"b", "c", "a"},
+		},
+		"unknown": {
+			deps:    []Dependency{{Name: "a", DependsOn: []string{"b"}}},
+			wantErr: "dependency a depends on unknown dependency b",
+		},
+		"duplicate": {
+			deps:    []Dependency{{Name: "a"}, {Name: "a"}},
+			wantErr: "dependency a was added twice",
+		},
+		"cycle": {
+			deps:    []Dependency{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}},
+			wantErr: "dependency cycle: a -> b -> a",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ordered, err := orderDependencies(tc.deps)
+			if tc.wantErr != "" {
+				require.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			var got []string
+			for _, dep := range ordered {
+				got = append(got, dep.Name)
+			}
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package appcommon
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// otherSubsystem labels the goroutines that don't belong to a known subsystem.
+const otherSubsystem = "other"
+
+// goroutineSubsystems maps the known subsystems to a function prefix found in
+// the stacks of their goroutines. The first match wins, so more specific
+// prefixes go first.
+var goroutineSubsystems = []struct {
+	subsystem string
+	prefix    string
+}{
+	{subsystem: "http_server", prefix: "net/http.(*conn)."},
+	{subsystem: "http_server", prefix: "net/http.(*Server)."},
+	{subsystem: "http_client", prefix: "net/http.(*persistConn)."},
+	{subsystem: "grpc", prefix: "google.golang.org/grpc."},
+	{subsystem: "tracing", prefix: "github.com/uber/jaeger-client-go."},
+	{subsystem: "carbon", prefix: "github.com/grafana/mimir-graphite/v2/pkg/remotewrite/carbon."},
+	{subsystem: "influx", prefix: "github.com/grafana/mimir-graphite/v2/pkg/remotewrite/influx."},
+}
+
+type DiagnosticsConfig struct {
+	// Interval is how often goroutines and file descriptors are counted. 0
+	// disables the diagnostics.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *DiagnosticsConfig) RegisterFlags(flags *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (cfg *DiagnosticsConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.DurationVar(&cfg.Interval, prefix+"diagnostics.interval", time.Minute, "How often to count the goroutines per subsystem and the open file descriptors, to spot leaks. 0 to disable.")
+}
+
+func (cfg *DiagnosticsConfig) Validate() error {
+	if cfg.Interval < 0 {
+		return fmt.Errorf("diagnostics interval can't be negative")
+	}
+	return nil
+}
+
+// Diagnostics periodically counts the goroutines of the known subsystems and
+// the open file descriptors, so a leak shows up as a steadily growing gauge.
+type Diagnostics struct {
+	interval time.Duration
+	logger   log.Logger
+
+	goroutines *prometheus.GaugeVec
+	openFDs    prometheus.Gauge
+
+	stop chan struct{}
+}
+
+func NewDiagnostics(cfg DiagnosticsConfig, prefix string, reg prometheus.Registerer, logger log.Logger) *Diagnostics {
+	d := &Diagnostics{
+		interval: cfg.Interval,
+		logger:   log.With(logger, "component", "diagnostics"),
+		goroutines: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "diagnostics_goroutines",
+			Help:      "The number of goroutines per subsystem, at the last snapshot.",
+		}, []string{"subsystem"}),
+		openFDs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "diagnostics_open_fds",
+			Help:      "The number of open file descriptors, at the last snapshot.",
+		}),
+		stop: make(chan struct{}),
+	}
+	reg.MustRegister(d.goroutines, d.openFDs)
+	return d
+}
+
+// Handler returns two functions to run and stop the diagnostics.
+func (d *Diagnostics) Handler() (run func() error, stop func(error)) {
+	return d.run, func(error) { close(d.stop) }
+}
+
+func (d *Diagnostics) run() error {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		runRecovered(d.logger, "diagnostics", d.snapshot)
+		select {
+		case <-ticker.C:
+		case <-d.stop:
+			return nil
+		}
+	}
+}
+
+func (d *Diagnostics) snapshot() {
+	counts := countGoroutines(goroutineStacks())
+	for _, s := range goroutineSubsystems {
+		d.goroutines.WithLabelValues(s.subsystem).Set(float64(counts[s.subsystem]))
+	}
+	d.goroutines.WithLabelValues(otherSubsystem).Set(float64(counts[otherSubsystem]))
+
+	fds, err := CountOpenFDs()
+	if err != nil {
+		level.Debug(d.logger).Log("msg", "can't count open file descriptors", "err", err)
+		return
+	}
+	d.openFDs.Set(float64(fds))
+}
+
+// goroutineStacks returns the stack traces of all goroutines, in the format of
+// runtime.Stack.
+func goroutineStacks() []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// countGoroutines counts the goroutines of each subsystem in stacks.
+func countGoroutines(stacks []byte) map[string]int {
+	counts := map[string]int{}
+	for _, stack := range bytes.Split(stacks, []byte("\n\n")) {
+		if len(bytes.TrimSpace(stack)) == 0 {
+			continue
+		}
+		subsystem := otherSubsystem
+		for _, s := range goroutineSubsystems {
+			if bytes.Contains(stack, []byte(s.prefix)) {
+				subsystem = s.subsystem
+				break
+			}
+		}
+		counts[subsystem]++
+	}
+	return counts
+}
+
+// CountOpenFDs returns the number of open file descriptors of the process. It's
+// only supported on Linux.
+func CountOpenFDs() (int, error) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
@@ -0,0 +1,64 @@
+package appcommon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountGoroutines(t *testing.T) {
+	stacks := []byte(`goroutine 1 [running]:
+main.main()
+	/app/main.go:10 +0x1d
+
+goroutine 7 [IO wait]:
+net/http.(*conn).serve(0xc000150000, {0x1, 0x2})
+	/usr/local/go/src/net/http/server.go:2009 +0x5f4
+created by net/http.(*Server).Serve in goroutine 6
+	/usr/local/go/src/net/http/server.go:3285 +0x4b4
+
+goroutine 8 [select]:
+net/http.(*persistConn).readLoop(0xc0001)
+	/usr/local/go/src/net/http/transport.go:2205 +0x185
+
+goroutine 9 [select]:
+google.golang.org/grpc.(*Server).serveStreams(0xc0002)
+	/go/pkg/mod/google.golang.org/grpc/server.go:1000 +0x10
+
+goroutine 10 [chan receive]:
+github.com/uber/jaeger-client-go.(*remoteReporter).processQueue(0xc0003)
+	/go/pkg/mod/github.com/uber/jaeger-client-go/reporter.go:296 +0x10
+`)
+
+	require.Equal(t, map[string]int{
+		"other":       1,
+		"http_server": 1,
+		"http_client": 1,
+		"grpc":        1,
+		"tracing":     1,
+	}, countGoroutines(stacks))
+}
+
+func TestDiagnostics(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	d := NewDiagnostics(DiagnosticsConfig{Interval: time.Hour}, "test", reg, log.NewNopLogger())
+
+	run, stop := d.Handler()
+	done := make(chan error)
+	go func() { done <- run() }()
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(d.goroutines.WithLabelValues(otherSubsystem)) > 0
+	}, time.Second, 10*time.Millisecond)
+	stop(nil)
+	require.NoError(t, <-done)
+
+	if _, err := CountOpenFDs(); err == nil {
+		require.Positive(t, testutil.ToFloat64(d.openFDs))
+	}
+	// One series per known subsystem, plus other.
+	require.Equal(t, 7, testutil.CollectAndCount(d.goroutines))
+}
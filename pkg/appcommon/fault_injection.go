@@ -0,0 +1,159 @@
+package appcommon
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+)
+
+// FaultInjectionConfig configures the faults injected in the requests of the
+// HTTP clients, to run game days against staging without external proxies.
+// Each fault is injected in a percentage of the requests, independently of
+// the others.
+type FaultInjectionConfig struct {
+	// Clients and Tenants restrict the faults to the requests of the clients
+	// with these names and for these tenants. Empty for all of them.
+	Clients flagext.StringSliceCSV `yaml:"clients"`
+	Tenants flagext.StringSliceCSV `yaml:"tenants"`
+
+	Latency        time.Duration `yaml:"latency"`
+	LatencyPercent float64       `yaml:"latency_percent"`
+	// ErrorPercent percent of the requests get a response with
+	// ErrorStatusCode instead of being sent.
+	ErrorPercent    float64 `yaml:"error_percent"`
+	ErrorStatusCode int     `yaml:"error_status_code"`
+	// TruncatePercent percent of the responses have their body cut in half,
+	// failing with io.ErrUnexpectedEOF.
+	TruncatePercent float64 `yaml:"truncate_percent"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *FaultInjectionConfig) RegisterFlags(flags *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (cfg *FaultInjectionConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.Var(&cfg.Clients, prefix+"fault-injection.clients", "Comma separated list of the names of the clients to inject faults in, eg. remote_write. Empty for all of them.")
+	flags.Var(&cfg.Tenants, prefix+"fault-injection.tenants", "Comma separated list of the tenants whose requests get faults injected. Empty for all of them.")
+	flags.DurationVar(&cfg.Latency, prefix+"fault-injection.latency", 0, "Latency added to fault-injection.latency-percent of the requests.")
+	flags.Float64Var(&cfg.LatencyPercent, prefix+"fault-injection.latency-percent", 0, "Percentage of the requests delayed by fault-injection.latency.")
+	flags.Float64Var(&cfg.ErrorPercent, prefix+"fault-injection.error-percent", 0, "Percentage of the requests failed with fault-injection.error-status-code instead of being sent.")
+	flags.IntVar(&cfg.ErrorStatusCode, prefix+"fault-injection.error-status-code", http.StatusServiceUnavailable, "Status code of the responses of the failed requests.")
+	flags.Float64Var(&cfg.TruncatePercent, prefix+"fault-injection.truncate-percent", 0, "Percentage of the responses whose body is truncated.")
+}
+
+func (cfg *FaultInjectionConfig) Validate() error {
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{
+		{"latency", cfg.LatencyPercent},
+		{"error", cfg.ErrorPercent},
+		{"truncate", cfg.TruncatePercent},
+	} {
+		if p.value < 0 || p.value > 100 {
+			return fmt.Errorf("fault injection %s percent %v must be between 0 and 100", p.name, p.value)
+		}
+	}
+	if cfg.Latency < 0 {
+		return errors.New("fault injection latency can't be negative")
+	}
+	if cfg.ErrorPercent > 0 && (cfg.ErrorStatusCode < 100 || cfg.ErrorStatusCode > 599) {
+		return fmt.Errorf("invalid fault injection error status code %d", cfg.ErrorStatusCode)
+	}
+	return nil
+}
+
+// Enabled returns whether any fault is injected.
+func (cfg *FaultInjectionConfig) Enabled() bool {
+	return cfg.LatencyPercent > 0 || cfg.ErrorPercent > 0 || cfg.TruncatePercent > 0
+}
+
+// FaultInjectionTransport is a RoundTripper injecting the faults of a
+// FaultInjectionConfig in the requests for its tenants. It must run after
+// the org ID was injected in the request context.
+type FaultInjectionTransport struct {
+	http.RoundTripper
+	cfg    FaultInjectionConfig
+	sample func() float64
+}
+
+// NewFaultInjectionRoundTripper wraps rt to inject the faults of cfg. It can
+// also be used as the tripperware of remotewrite.NewClient.
+func NewFaultInjectionRoundTripper(cfg FaultInjectionConfig, rt http.RoundTripper) http.RoundTripper {
+	return &FaultInjectionTransport{RoundTripper: rt, cfg: cfg, sample: rand.Float64}
+}
+
+func (t *FaultInjectionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.cfg.Tenants) > 0 {
+		tenant, err := user.ExtractOrgID(req.Context())
+		if err != nil {
+			tenant = req.Header.Get(user.OrgIDHeaderName)
+		}
+		if !slices.Contains(t.cfg.Tenants, tenant) {
+			return t.RoundTripper.RoundTrip(req)
+		}
+	}
+
+	if t.sample()*100 < t.cfg.LatencyPercent {
+		select {
+		case <-time.After(t.cfg.Latency):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if t.sample()*100 < t.cfg.ErrorPercent {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		body := "injected fault"
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", t.cfg.ErrorStatusCode, http.StatusText(t.cfg.ErrorStatusCode)),
+			StatusCode:    t.cfg.ErrorStatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || t.sample()*100 >= t.cfg.TruncatePercent {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.ContentLength = -1
+	resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body[:len(body)/2]), errReader{io.ErrUnexpectedEOF}))
+	return resp, nil
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
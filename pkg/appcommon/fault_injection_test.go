@@ -0,0 +1,91 @@
+package appcommon
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultInjectionTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	defer srv.Close()
+
+	get := func(t *testing.T, rt http.RoundTripper, tenant string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(user.InjectOrgID(context.Background(), tenant), http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		return rt.RoundTrip(req)
+	}
+	// always injects the faults enabled in cfg.
+	newTransport := func(cfg FaultInjectionConfig) http.RoundTripper {
+		rt := NewFaultInjectionRoundTripper(cfg, http.DefaultTransport)
+		rt.(*FaultInjectionTransport).sample = func() float64 { return 0 }
+		return rt
+	}
+
+	t.Run("error", func(t *testing.T) {
+		resp, err := get(t, newTransport(FaultInjectionConfig{ErrorPercent: 1, ErrorStatusCode: http.StatusTooManyRequests}), "tenant-1")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "injected fault", string(body))
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		start := time.Now()
+		resp, err := get(t, newTransport(FaultInjectionConfig{LatencyPercent: 1, Latency: 50 * time.Millisecond}), "tenant-1")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		resp, err := get(t, newTransport(FaultInjectionConfig{TruncatePercent: 1}), "tenant-1")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		require.Equal(t, "01234", string(body))
+	})
+
+	t.Run("other tenants are left alone", func(t *testing.T) {
+		rt := newTransport(FaultInjectionConfig{ErrorPercent: 100, ErrorStatusCode: http.StatusServiceUnavailable, Tenants: []string{"tenant-1"}})
+		resp, err := get(t, rt, "tenant-2")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("factory clients", func(t *testing.T) {
+		factory, err := NewHTTPClientFactory(HTTPClientConfig{FaultInjection: FaultInjectionConfig{
+			ErrorPercent:    100,
+			ErrorStatusCode: http.StatusServiceUnavailable,
+			Clients:         []string{"remote_write"},
+		}}, "test", prometheus.NewRegistry())
+		require.NoError(t, err)
+
+		for name, status := range map[string]int{"remote_write": http.StatusServiceUnavailable, "other": http.StatusOK} {
+			req, err := http.NewRequestWithContext(user.InjectOrgID(context.Background(), "tenant-1"), http.MethodGet, srv.URL, nil)
+			require.NoError(t, err)
+			resp, err := factory.Client(name).Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, status, resp.StatusCode, name)
+		}
+	})
+}
+
+func TestFaultInjectionConfig_Validate(t *testing.T) {
+	require.NoError(t, (&FaultInjectionConfig{}).Validate())
+	require.EqualError(t, (&FaultInjectionConfig{ErrorPercent: 101}).Validate(), "fault injection error percent 101 must be between 0 and 100")
+	require.EqualError(t, (&FaultInjectionConfig{ErrorPercent: 10}).Validate(), "invalid fault injection error status code 0")
+	require.EqualError(t, (&FaultInjectionConfig{Latency: -time.Second}).Validate(), "fault injection latency can't be negative")
+}
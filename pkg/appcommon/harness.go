@@ -4,22 +4,27 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/mwitkow/go-conntrack"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/gorilla/mux"
+	"github.com/grafana/dskit/flagext"
 	"github.com/oklog/run"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/grafana/mimir-graphite/v2/pkg/ctxlog"
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
 	"github.com/grafana/mimir-graphite/v2/pkg/internalserver"
 	"github.com/grafana/mimir-graphite/v2/pkg/server"
 	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
@@ -35,14 +40,57 @@ type Config struct {
 	InstrumentBuckets string `yaml:"instrument_buckets"`
 	EnableAuth        bool   `yaml:"enable_auth"`
 	ServiceName       string `yaml:"service_name"`
+	// Profile, if set, is the name of the Profiles whose defaults the flags
+	// not set on the command line get. When it's set in YAML or directly,
+	// New applies its defaults to the fields still at their defaults.
+	Profile string `yaml:"profile"`
+	// profileApplied is set once the profile flag applied the profile.
+	profileApplied bool
+
+	// AuthStrict rejects the requests without an org ID with an
+	// errorx.Unauthorized error, except on AuthUnauthenticatedPaths, instead
+	// of serving them as the "fake" tenant when EnableAuth is false.
+	AuthStrict               bool                   `yaml:"auth_strict"`
+	AuthUnauthenticatedPaths flagext.StringSliceCSV `yaml:"auth_unauthenticated_paths"`
 
 	// AuthMiddleware, if set, replaces the default auth middleware
 	// (HTTPAuth or HTTPFakeAuth). This allows consumers to provide
 	// custom authentication that runs before the logging middleware.
 	AuthMiddleware middleware.Interface `yaml:"-"`
 
+	// ShadowHandler, if set, is the handler ServerConfig.ShadowPercent
+	// percent of the requests are mirrored to, eg. a new implementation of
+	// the app's API, instead of ServerConfig.ShadowURL.
+	ShadowHandler http.Handler `yaml:"-"`
+
+	// TenantUsageSink, if set, receives the usage of the tenants instead of
+	// the sink configured in TenantUsage.
+	TenantUsageSink TenantUsageSink `yaml:"-"`
+
 	ServerConfig         server.Config         `yaml:"server_config"`
 	InternalServerConfig internalserver.Config `yaml:"internal_server_config"`
+	Diagnostics          DiagnosticsConfig     `yaml:"diagnostics"`
+	HTTPClient           HTTPClientConfig      `yaml:"http_client"`
+	TailSampling         TailSamplingConfig    `yaml:"tail_sampling"`
+	Startup              StartupConfig         `yaml:"startup"`
+	UsageStats           UsageStatsConfig      `yaml:"usage_stats"`
+	TenantUsage          TenantUsageConfig     `yaml:"tenant_usage"`
+	Log                  LogConfig             `yaml:"log"`
+	Sharding             ShardingConfig        `yaml:"sharding"`
+
+	// Deprecations, if set, are the renamed config fields of the app, whose
+	// old names used are reported when the app starts.
+	Deprecations *ConfigDeprecations `yaml:"-"`
+
+	// Components, if set, are the configs of the components of the app, eg.
+	// its remoteread.Config or remotewrite.Config, keyed by their name in the
+	// printed config. They're validated along with the config.
+	Components map[string]Validator `yaml:"components"`
+
+	// ValidateConfig makes New print the config with CheckConfig instead of
+	// starting the app, and return ErrConfigValidated if it's valid or its
+	// validation error otherwise.
+	ValidateConfig bool `yaml:"-"`
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet
@@ -59,10 +107,25 @@ func (cfg *Config) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
 	}
 	flags.StringVar(&cfg.InstrumentBuckets, prefix+"instrument-buckets", ".005,.010,.015,.020,.025,.050,.100,.250,.500,1,2.5,5,10", "Buckets for instrumentation, comma separated list of seconds as floats.")
 	flags.BoolVar(&cfg.EnableAuth, prefix+"auth.enable", true, "require X-Scope-OrgId header")
+	flags.BoolVar(&cfg.AuthStrict, prefix+"auth.strict", false, "Reject requests without an X-Scope-OrgID header with a message explaining how to set it, except on auth.unauthenticated-paths. Takes precedence over auth.enable.")
+	cfg.AuthUnauthenticatedPaths = []string{"/healthz", "/metrics"}
+	flags.Var(&cfg.AuthUnauthenticatedPaths, prefix+"auth.unauthenticated-paths", "Comma separated list of paths served without an X-Scope-OrgID header in strict auth mode.")
 	flags.StringVar(&cfg.ServiceName, prefix+"service-name", "", "the service name used in traces")
+	flags.BoolVar(&cfg.ValidateConfig, prefix+"validate-config", false, "Validate the config, print it with secrets redacted and exit.")
 
 	cfg.ServerConfig.RegisterFlagsWithPrefix(prefix, flags)
 	cfg.InternalServerConfig.RegisterFlagsWithPrefix(prefix, flags)
+	cfg.Diagnostics.RegisterFlagsWithPrefix(prefix, flags)
+	cfg.HTTPClient.RegisterFlagsWithPrefix(prefix, flags)
+	cfg.TailSampling.RegisterFlagsWithPrefix(prefix, flags)
+	cfg.Startup.RegisterFlagsWithPrefix(prefix, flags)
+	cfg.UsageStats.RegisterFlagsWithPrefix(prefix, flags)
+	cfg.TenantUsage.RegisterFlagsWithPrefix(prefix, flags)
+	cfg.Log.RegisterFlagsWithPrefix(prefix, flags)
+	cfg.Sharding.RegisterFlagsWithPrefix(prefix, flags)
+
+	// Registered last, as it sets the flags above.
+	flags.Var(&profileFlag{name: &cfg.Profile, applied: &cfg.profileApplied, prefix: prefix, flags: flags}, prefix+"profile", fmt.Sprintf("Profile of the app, whose defaults apply to the server timeouts, middleware limits and client timeouts not set on the command line before it: one of %s.", strings.Join(profileNames(), ", ")))
 }
 
 type App struct {
@@ -70,11 +133,30 @@ type App struct {
 
 	Logger      log.Logger
 	LogProvider ctxlog.Provider
-	Server      *server.Server
-	Tracer      opentracing.Tracer
-	closers     []func() error
+	// LogLevels hands out the loggers of the components of the app, see
+	// LogComponentServer.
+	LogLevels *LogLevels
+	Server    *server.Server
+	Tracer    opentracing.Tracer
+	// HTTPClients hands out the HTTP clients the components of the app
+	// should use to call downstreams.
+	HTTPClients *HTTPClientFactory
+	// Sharder, if sharding is enabled, shares out the background work of
+	// the app between its replicas.
+	Sharder      *Sharder
+	dependencies *dependencies
+	closers      []func() error
 }
 
+// ErrConfigValidated is returned by New with Config.ValidateConfig once the
+// config was printed and found valid. The app isn't created, and the callers
+// should exit successfully.
+var ErrConfigValidated = errors.New("config is valid")
+
+// validateConfigOutput is where the config is printed with
+// Config.ValidateConfig, replaced in the tests.
+var validateConfigOutput io.Writer = os.Stdout
+
 func init() {
 	// Monitor outgoing connections on default transport with conntrack.
 	http.DefaultTransport.(*http.Transport).DialContext = conntrack.NewDialContextFunc(
@@ -86,8 +168,17 @@ func init() {
 // New creates a new App.
 // Callers should call App.Close() after use.
 func New(cfg Config, reg prometheus.Registerer, metricPrefix string, tracer opentracing.Tracer) (app App, err error) {
-	if cfg.ServiceName == "" {
-		return app, fmt.Errorf("service name can't be empty")
+	if err := cfg.applyProfile(); err != nil {
+		return app, fmt.Errorf("invalid config: %w", err)
+	}
+	if cfg.ValidateConfig {
+		if err := CheckConfig(validateConfigOutput, &cfg); err != nil {
+			return app, fmt.Errorf("invalid config: %w", err)
+		}
+		return app, ErrConfigValidated
+	}
+	if err := cfg.Validate(); err != nil {
+		return app, fmt.Errorf("invalid config: %w", err)
 	}
 
 	app = App{
@@ -102,9 +193,27 @@ func New(cfg Config, reg prometheus.Registerer, metricPrefix string, tracer open
 
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout))
 	logger = log.WithPrefix(logger, "ts", log.DefaultTimestampUTC)
+	app.LogLevels = NewLogLevels(cfg.Log, logger)
+	logger = app.LogLevels.Logger("")
+	serverLogger := app.LogLevels.Logger(LogComponentServer)
 	app.Logger = logger
 	app.LogProvider = ctxlog.NewProvider(logger)
 
+	if cfg.Deprecations != nil {
+		if err := cfg.Deprecations.Check(logger, metricPrefix, reg, time.Now()); err != nil {
+			return app, err
+		}
+	}
+
+	app.HTTPClients, err = NewHTTPClientFactory(cfg.HTTPClient, metricPrefix, reg)
+	if err != nil {
+		return app, err
+	}
+	app.closers = append(app.closers, func() error {
+		app.HTTPClients.CloseIdleConnections()
+		return nil
+	})
+
 	router := mux.NewRouter()
 
 	// Configure middlewares
@@ -129,13 +238,15 @@ func New(cfg Config, reg prometheus.Registerer, metricPrefix string, tracer open
 	}
 	tracerMiddleware := middleware.NewTracer(router, app.Tracer)
 
-	logMiddleware := middleware.NewLoggingMiddleware(logger)
+	logMiddleware := middleware.NewLoggingMiddleware(serverLogger)
 
 	var authMiddleware middleware.Interface
 	if cfg.AuthMiddleware != nil {
 		authMiddleware = cfg.AuthMiddleware
+	} else if cfg.AuthStrict {
+		authMiddleware = middleware.NewStrictHTTPAuth(serverLogger, cfg.AuthUnauthenticatedPaths)
 	} else if cfg.EnableAuth {
-		authMiddleware = middleware.NewHTTPAuth(logger)
+		authMiddleware = middleware.NewHTTPAuth(serverLogger)
 	} else {
 		authMiddleware = middleware.HTTPFakeAuth{}
 	}
@@ -144,28 +255,125 @@ func New(cfg Config, reg prometheus.Registerer, metricPrefix string, tracer open
 	middlewares := []middleware.Interface{
 		tracerMiddleware,
 		instrumentMiddleware,
+	}
+	if cfg.ServerConfig.NegotiateErrorFormat {
+		// Before the middlewares failing requests, so their errors honor
+		// the Accept header too.
+		middlewares = append(middlewares, middleware.NewErrorFormatMiddleware())
+	}
+	middlewares = append(middlewares,
 		authMiddleware,
 		logMiddleware,
+		middleware.NewRecoveryMiddleware(serverLogger),
+		middleware.NewDeadlineMiddleware(cfg.ServerConfig.HTTPRequestTimeout),
+	)
+
+	if cfg.ServerConfig.ServerTimingHeader {
+		// First, so the total duration covers the other middlewares.
+		middlewares = append([]middleware.Interface{middleware.NewServerTimingMiddleware()}, middlewares...)
 	}
 
 	if cfg.ServerConfig.HTTPMaxRequestSizeLimit > 0 {
-		requestLimitsMiddleware := middleware.NewRequestLimitsMiddleware(cfg.ServerConfig.HTTPMaxRequestSizeLimit, logger)
+		requestLimitsMiddleware := middleware.NewRequestLimitsMiddleware(cfg.ServerConfig.HTTPMaxRequestSizeLimit, serverLogger)
 		middlewares = append(middlewares, requestLimitsMiddleware)
 	}
 
-	srv, err := server.NewServer(logger, cfg.ServerConfig, router, middlewares)
+	if cfg.ServerConfig.HTTPMaxURILength > 0 || cfg.ServerConfig.HTTPMaxHeaderCount > 0 || cfg.ServerConfig.HTTPMaxHeaderBytes > 0 {
+		middlewares = append(middlewares, middleware.NewHeaderLimitsMiddleware(cfg.ServerConfig.HTTPMaxURILength, cfg.ServerConfig.HTTPMaxHeaderCount, cfg.ServerConfig.HTTPMaxHeaderBytes, serverLogger))
+	}
+
+	if cfg.ServerConfig.HTTPMaxDecompressedBytes > 0 || cfg.ServerConfig.HTTPMaxDecompressionRatio > 0 {
+		middlewares = append(middlewares, middleware.NewDecompressionMiddleware(cfg.ServerConfig.HTTPMaxDecompressedBytes, cfg.ServerConfig.HTTPMaxDecompressionRatio, serverLogger))
+	}
+
+	if cfg.ServerConfig.PerTenantByteMetrics {
+		middlewares = append(middlewares, middleware.NewTenantBytesMiddleware(metricPrefix, reg))
+	}
+
+	var tenantUsage *TenantUsageExporter
+	if cfg.TenantUsage.Enabled {
+		sink := cfg.TenantUsageSink
+		if sink == nil {
+			var closeSink func()
+			sink, closeSink, err = NewTenantUsageSink(cfg.TenantUsage, metricPrefix, reg)
+			if err != nil {
+				return app, err
+			}
+			app.closers = append(app.closers, func() error {
+				closeSink()
+				return nil
+			})
+		}
+		usage := middleware.NewTenantUsageMiddleware()
+		tenantUsage = NewTenantUsageExporter(cfg.TenantUsage, usage, sink, logger)
+		middlewares = append(middlewares, usage)
+	}
+
+	var usageStats *UsageStats
+	if cfg.UsageStats.Enabled {
+		usageStats = NewUsageStats(cfg.UsageStats, cfg.ServiceName, logger)
+		middlewares = append(middlewares, usageStats)
+	}
+
+	if cfg.ServerConfig.IdempotencyWindow > 0 {
+		middlewares = append(middlewares, middleware.NewIdempotencyMiddleware(cfg.ServerConfig.IdempotencyWindow, cfg.ServerConfig.IdempotencyMaxEntries, cfg.ServerConfig.IdempotencyMaxBodyBytes, serverLogger))
+	}
+
+	if cfg.TailSampling.Enabled {
+		middlewares = append(middlewares, middleware.NewTailSamplingMiddleware(cfg.TailSampling.LatencyThreshold, cfg.TailSampling.Tenants))
+	}
+
+	shadowHandler := cfg.ShadowHandler
+	if shadowHandler == nil && cfg.ServerConfig.ShadowURL != "" {
+		shadowURL, err := url.Parse(cfg.ServerConfig.ShadowURL)
+		if err != nil {
+			return app, fmt.Errorf("invalid shadow URL: %w", err)
+		}
+		shadowHandler = middleware.NewShadowProxy(shadowURL, app.HTTPClients.Client("shadow"))
+	}
+	if shadowHandler != nil && cfg.ServerConfig.ShadowPercent > 0 {
+		middlewares = append(middlewares, middleware.NewShadowMiddleware(shadowHandler, cfg.ServerConfig.ShadowPercent, cfg.ServerConfig.ShadowTimeout, metricPrefix, reg, serverLogger))
+	}
+
+	srv, err := server.NewServer(serverLogger, cfg.ServerConfig, router, middlewares)
 	if err != nil {
 		level.Error(logger).Log("msg", "failed to start server", "err", err)
 		return app, fmt.Errorf("failed to start server: %w", err)
 	}
+	srv.InstrumentConnections(server.NewConnMetrics(metricPrefix, reg))
 	app.Server = srv
 
 	signalHandler := stopsignal.NewSignalHandler(cfg.InternalServerConfig.ServerGracefulShutdownTimeout, logger)
-	cfg.InternalServerConfig.ReadinessProvider = signalHandler
+	app.dependencies = newDependencies(cfg.Startup, logger)
+	cfg.InternalServerConfig.ReadinessProvider = allReady{signalHandler, app.dependencies}
+	cfg.InternalServerConfig.InflightRequests = instrumentMiddleware.Inflight().Handler()
+	cfg.InternalServerConfig.LogLevels = app.LogLevels
+	cfg.InternalServerConfig.Listen = cfg.ServerConfig.ListenConfig()
+
+	if cfg.Sharding.Enabled {
+		app.Sharder, err = NewSharder(cfg.Sharding, cfg.ServerConfig.HTTPListenPort, metricPrefix, reg, logger)
+		if err != nil {
+			return app, err
+		}
+		cfg.InternalServerConfig.Ring = app.Sharder
+	}
 
 	app.Group.Add(app.Server.Handler())
 	app.Group.Add(internalserver.Handler(logger, cfg.InternalServerConfig))
 	app.Group.Add(signalHandler.Handler(syscall.SIGTERM, syscall.SIGINT))
+	app.Group.Add(app.dependencies.Handler())
+	if cfg.Diagnostics.Interval > 0 {
+		app.Group.Add(NewDiagnostics(cfg.Diagnostics, metricPrefix, reg, logger).Handler())
+	}
+	if usageStats != nil {
+		app.Group.Add(usageStats.Handler())
+	}
+	if tenantUsage != nil {
+		app.Group.Add(tenantUsage.Handler())
+	}
+	if app.Sharder != nil {
+		app.Group.Add(app.Sharder.Handler())
+	}
 
 	if err := registerVersionMetrics(reg, cfg.ServiceName, metricPrefix); err != nil {
 		return app, err
@@ -175,6 +383,13 @@ func New(cfg Config, reg prometheus.Registerer, metricPrefix string, tracer open
 	return app, nil
 }
 
+// AddDependency adds a dependency started, in order with the others, when
+// the app's Group runs. The app isn't ready until all its dependencies
+// started, and stops if one of them can't be.
+func (app App) AddDependency(dep Dependency) {
+	app.dependencies.add(dep)
+}
+
 type AppError []error
 
 func (ae AppError) Error() string {
@@ -238,3 +453,14 @@ func parseFloats(str string) ([]float64, error) {
 	}
 	return vals, nil
 }
+
+// runRecovered runs a tick of a background job, logging a panic instead of
+// crashing the app, so the next ticks still run.
+func runRecovered(logger log.Logger, job string, tick func()) {
+	defer func() {
+		if err := errorx.FromPanic(recover()); err != nil {
+			level.Error(logger).Log("msg", "panic in background job", "job", job, "err", err, "stack", errorx.StackTrace(err))
+		}
+	}()
+	tick()
+}
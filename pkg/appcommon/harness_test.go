@@ -1,12 +1,17 @@
 package appcommon
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/flagext"
 	"github.com/grafana/dskit/user"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/mocktracer"
@@ -75,6 +80,40 @@ func TestApp_Close(t *testing.T) {
 
 }
 
+func TestNew_ValidateConfig(t *testing.T) {
+	var out bytes.Buffer
+	validateConfigOutput = &out
+	t.Cleanup(func() { validateConfigOutput = os.Stdout })
+
+	validate := func(cfg Config) error {
+		out.Reset()
+		_, err := New(cfg, prometheus.NewPedanticRegistry(), "", opentracing.NoopTracer{})
+		return err
+	}
+
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.ServiceName = "test"
+	cfg.ValidateConfig = true
+	component := &checkedConfig{Timeout: time.Second}
+	cfg.Components = map[string]Validator{"remote_read": component}
+	require.ErrorIs(t, validate(cfg), ErrConfigValidated)
+	require.Contains(t, out.String(), "service_name: test")
+	require.Contains(t, out.String(), "timeout: 1s")
+
+	component.err = errors.New("endpoint can't be empty")
+	err := validate(cfg)
+	require.EqualError(t, err, "invalid config: remote_read: endpoint can't be empty")
+	require.NotErrorIs(t, err, ErrConfigValidated)
+
+	component.err = nil
+	cfg.Log.Level = "loud"
+	err = validate(cfg)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrConfigValidated)
+	require.Contains(t, out.String(), "level: loud")
+}
+
 func TestApp_Config_Tracer(t *testing.T) {
 	t.Run("tracer from config is set as global tracer", func(t *testing.T) {
 		defer resetTracingGlobals(t)
@@ -176,6 +215,99 @@ func TestApp_CustomAuthMiddleware(t *testing.T) {
 	})
 }
 
+func TestApp_StrictAuth(t *testing.T) {
+	defer resetTracingGlobals(t)
+
+	app, err := New(Config{
+		ServiceName:              "test",
+		InstrumentBuckets:        "0.1",
+		AuthStrict:               true,
+		AuthUnauthenticatedPaths: []string{"/healthz"},
+		ServerConfig:             serverConfigWithPort0(),
+	}, prometheus.NewRegistry(), "", mocktracer.New())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, app.Close()) }()
+
+	go func() { _ = app.Server.Run() }()
+
+	for _, path := range []string{"/test", "/healthz"} {
+		app.Server.Router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, "ok")
+		})
+	}
+	get := func(path string) (int, string) {
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", app.Server.Addr(), path))
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/test")
+	require.Equal(t, http.StatusUnauthorized, code)
+	require.Equal(t, "no org ID: set the X-Scope-OrgID header to the tenant ID\n", body)
+
+	code, body = get("/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ok", body)
+}
+
+func TestApp_StrictAuthErrorFormat(t *testing.T) {
+	defer resetTracingGlobals(t)
+
+	serverConfig := serverConfigWithPort0()
+	serverConfig.NegotiateErrorFormat = true
+	app, err := New(Config{
+		ServiceName:       "test",
+		InstrumentBuckets: "0.1",
+		AuthStrict:        true,
+		ServerConfig:      serverConfig,
+	}, prometheus.NewRegistry(), "", mocktracer.New())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, app.Close()) }()
+
+	go func() { _ = app.Server.Run() }()
+
+	app.Server.Router.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "ok")
+	})
+
+	for accept, contentType := range map[string]string{
+		"":                 "application/json",
+		"application/json": "application/json",
+		"text/plain":       "text/plain; charset=utf-8",
+	} {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/test", app.Server.Addr()), nil)
+		require.NoError(t, err)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode, accept)
+		require.Equal(t, contentType, resp.Header.Get("Content-Type"), accept)
+		require.Contains(t, string(body), "no org ID", accept)
+	}
+}
+
+func TestRunRecovered(t *testing.T) {
+	var logs bytes.Buffer
+	ticks := 0
+	for i := 0; i < 2; i++ {
+		runRecovered(log.NewLogfmtLogger(&logs), "test-job", func() {
+			ticks++
+			panic("boom")
+		})
+	}
+	require.Equal(t, 2, ticks)
+	require.Contains(t, logs.String(), `msg="panic in background job" job=test-job err="panic: boom"`)
+}
+
 func serverConfigWithPort0() server.Config {
 	return server.Config{
 		HTTPListenPort: 0,
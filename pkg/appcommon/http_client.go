@@ -0,0 +1,169 @@
+package appcommon
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/dskit/crypto/tls"
+	"github.com/mwitkow/go-conntrack"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type HTTPClientConfig struct {
+	DialTimeout     time.Duration `yaml:"dial_timeout"`
+	KeepAlive       time.Duration `yaml:"keep_alive"`
+	IdleConnTimeout time.Duration `yaml:"idle_conn_timeout"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	MaxConns        int           `yaml:"max_conns"`
+	// ProxyURL, if set, is the proxy all the requests go through. Otherwise
+	// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply.
+	ProxyURL string           `yaml:"proxy_url"`
+	TLS      tls.ClientConfig `yaml:",inline"`
+
+	FaultInjection FaultInjectionConfig `yaml:"fault_injection"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *HTTPClientConfig) RegisterFlags(flags *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+//
+//nolint:gomnd
+func (cfg *HTTPClientConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.DurationVar(&cfg.DialTimeout, prefix+"http-client.dial-timeout", 5*time.Second, "Timeout for establishing connections of the shared HTTP client.")
+	flags.DurationVar(&cfg.KeepAlive, prefix+"http-client.keep-alive", 30*time.Second, "TCP keep alive period of the connections of the shared HTTP client.")
+	flags.DurationVar(&cfg.IdleConnTimeout, prefix+"http-client.idle-conn-timeout", 90*time.Second, "How long idle connections of the shared HTTP client are kept open.")
+	flags.IntVar(&cfg.MaxIdleConns, prefix+"http-client.max-idle-conns", 100, "Max idle connections per host of the shared HTTP client.")
+	flags.IntVar(&cfg.MaxConns, prefix+"http-client.max-conns", 0, "Max open connections per host of the shared HTTP client. 0 for no limit.")
+	flags.StringVar(&cfg.ProxyURL, prefix+"http-client.proxy-url", "", "URL of the proxy the shared HTTP client sends requests through. If empty, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.")
+	cfg.TLS.RegisterFlagsWithPrefix(prefix+"http-client", flags)
+	cfg.FaultInjection.RegisterFlagsWithPrefix(prefix+"http-client", flags)
+}
+
+func (cfg *HTTPClientConfig) Validate() error {
+	if cfg.DialTimeout < 0 || cfg.KeepAlive < 0 || cfg.IdleConnTimeout < 0 {
+		return errors.New("http client timeouts can't be negative")
+	}
+	if cfg.MaxIdleConns < 0 || cfg.MaxConns < 0 {
+		return errors.New("http client connection limits can't be negative")
+	}
+	if cfg.ProxyURL != "" {
+		if u, err := url.Parse(cfg.ProxyURL); err != nil || u.Host == "" {
+			return fmt.Errorf("invalid http client proxy URL %q", cfg.ProxyURL)
+		}
+	}
+	return cfg.FaultInjection.Validate()
+}
+
+// HTTPClientFactory hands out HTTP clients sharing a single connection pool,
+// so the components of an app calling the same downstreams reuse each other's
+// connections, and get the same connection, TLS and proxy settings. Each
+// client is traced, injects the org ID of the request context, and records
+// its requests in metrics labeled with the client name.
+type HTTPClientFactory struct {
+	transport *http.Transport
+	faults    FaultInjectionConfig
+	duration  *prometheus.HistogramVec
+	inFlight  *prometheus.GaugeVec
+
+	mtx     sync.Mutex
+	clients map[string]*http.Client
+}
+
+// NewHTTPClientFactory creates an HTTPClientFactory and registers its metrics.
+func NewHTTPClientFactory(cfg HTTPClientConfig, metricPrefix string, reg prometheus.Registerer) (*HTTPClientFactory, error) {
+	tlsConfig, err := cfg.TLS.GetTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid http client TLS config: %w", err)
+	}
+	proxy := http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid http client proxy URL: %w", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+	transport.MaxIdleConns = cfg.MaxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	transport.MaxConnsPerHost = cfg.MaxConns
+	transport.DialContext = conntrack.NewDialContextFunc(
+		conntrack.DialWithName("shared"),
+		conntrack.DialWithTracing(),
+		conntrack.DialWithDialer(&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: cfg.KeepAlive,
+		}),
+	)
+
+	f := &HTTPClientFactory{
+		transport: transport,
+		faults:    cfg.FaultInjection,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricPrefix,
+			Name:      "http_client_request_duration_seconds",
+			Help:      "Time spent on the requests of the shared HTTP clients.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"client", "method", "code"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricPrefix,
+			Name:      "http_client_in_flight_requests",
+			Help:      "The number of requests of the shared HTTP clients in flight.",
+		}, []string{"client"}),
+		clients: map[string]*http.Client{},
+	}
+	if err := reg.Register(f.duration); err != nil {
+		return nil, err
+	}
+	if err := reg.Register(f.inFlight); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Client returns the client of the component called name, which names its
+// spans, labels its metrics and selects the faults injected in its requests.
+// The same client is returned for the same name.
+func (f *HTTPClientFactory) Client(name string) *http.Client {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if c, ok := f.clients[name]; ok {
+		return c
+	}
+
+	var rt http.RoundTripper = f.transport
+	if f.faults.Enabled() && (len(f.faults.Clients) == 0 || slices.Contains(f.faults.Clients, name)) {
+		rt = NewFaultInjectionRoundTripper(f.faults, rt)
+	}
+	rt = promhttp.InstrumentRoundTripperDuration(f.duration.MustCurryWith(prometheus.Labels{"client": name}), rt)
+	rt = promhttp.InstrumentRoundTripperInFlight(f.inFlight.WithLabelValues(name), rt)
+	c := &http.Client{Transport: NewTracedAuthRoundTripper(rt, name)}
+	f.clients[name] = c
+	return c
+}
+
+// CloseIdleConnections closes the idle connections of the shared pool.
+func (f *HTTPClientFactory) CloseIdleConnections() {
+	f.transport.CloseIdleConnections()
+}
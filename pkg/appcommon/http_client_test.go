@@ -0,0 +1,84 @@
+package appcommon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPClientFactory(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(user.OrgIDHeaderName) != "12345" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	reg := prometheus.NewPedanticRegistry()
+	factory, err := NewHTTPClientFactory(HTTPClientConfig{MaxIdleConns: 10}, "test", reg)
+	require.NoError(t, err)
+	defer factory.CloseIdleConnections()
+
+	client := factory.Client("remote_write")
+	require.Same(t, client, factory.Client("remote_write"))
+	require.NotSame(t, client, factory.Client("other"))
+
+	req, err := http.NewRequestWithContext(user.InjectOrgID(context.Background(), "12345"), http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	require.Equal(t, 1, testutil.CollectAndCount(reg, "test_http_client_request_duration_seconds"))
+	require.NoError(t, testutil.CollectAndCompare(reg, strings.NewReader(`
+# HELP test_http_client_in_flight_requests The number of requests of the shared HTTP clients in flight.
+# TYPE test_http_client_in_flight_requests gauge
+test_http_client_in_flight_requests{client="other"} 0
+test_http_client_in_flight_requests{client="remote_write"} 0
+`), "test_http_client_in_flight_requests"))
+}
+
+func TestHTTPClientConfig_Validate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cfg         HTTPClientConfig
+		expectedErr string
+	}{
+		"defaults": {
+			cfg: HTTPClientConfig{},
+		},
+		"proxy": {
+			cfg: HTTPClientConfig{ProxyURL: "http://proxy:3128"},
+		},
+		"negative timeout": {
+			cfg:         HTTPClientConfig{DialTimeout: -1},
+			expectedErr: "http client timeouts can't be negative",
+		},
+		"negative connection limit": {
+			cfg:         HTTPClientConfig{MaxConns: -1},
+			expectedErr: "http client connection limits can't be negative",
+		},
+		"invalid proxy": {
+			cfg:         HTTPClientConfig{ProxyURL: "proxy"},
+			expectedErr: `invalid http client proxy URL "proxy"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
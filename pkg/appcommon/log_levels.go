@@ -0,0 +1,203 @@
+package appcommon
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/flagext"
+)
+
+// The components whose log level can be set on their own. The apps get their
+// loggers from LogLevels.Logger.
+const (
+	LogComponentServer = "server"
+)
+
+// logComponents are the components whose level can be set.
+var logComponents = []string{LogComponentServer}
+
+// logLevelRanks orders the levels, the lines of a lower rank than the level of
+// their component are dropped.
+var logLevelRanks = map[string]int{
+	level.DebugValue().String(): 0,
+	level.InfoValue().String():  1,
+	level.WarnValue().String():  2,
+	level.ErrorValue().String(): 3,
+}
+
+// LogConfig configures the log level of the app, and of each component. An
+// empty level logs all the lines.
+type LogConfig struct {
+	Level           string                    `yaml:"level"`
+	ComponentLevels flagext.LimitsMap[string] `yaml:"component_levels"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *LogConfig) RegisterFlags(flags *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (cfg *LogConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	cfg.ComponentLevels = flagext.NewLimitsMap[string](validateComponentLogLevel)
+	flags.StringVar(&cfg.Level, prefix+"log.level", "info", "Only log lines of this level or above: debug, info, warn or error. Empty logs all the lines. The default, info, drops the debug lines that were logged before this flag existed; set it to debug to keep them.")
+	flags.Var(&cfg.ComponentLevels, prefix+"log.component-levels", fmt.Sprintf("Per-component overrides of log.level, as a JSON object of component to level, e.g. {%q: \"debug\"}. The components are: %s. They can also be changed at runtime on /debug/log-levels of the internal server.", LogComponentServer, strings.Join(logComponents, ", ")))
+}
+
+func validateComponentLogLevel(component, lvl string) error {
+	if !slices.Contains(logComponents, component) {
+		return fmt.Errorf("unknown log component %q", component)
+	}
+	if _, ok := logLevelRanks[lvl]; !ok {
+		return fmt.Errorf("unknown log level %q for component %q", lvl, component)
+	}
+	return nil
+}
+
+func (cfg *LogConfig) Validate() error {
+	if _, ok := logLevelRanks[cfg.Level]; !ok && cfg.Level != "" {
+		return fmt.Errorf("unknown log level %q", cfg.Level)
+	}
+	for component, lvl := range cfg.ComponentLevels.Read() {
+		if err := validateComponentLogLevel(component, lvl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogLevels filters the log lines of the app and of its components by their
+// level. The levels can be changed at runtime, with Set or over HTTP.
+type LogLevels struct {
+	logger log.Logger
+
+	mtx        sync.RWMutex
+	level      string
+	components map[string]string
+}
+
+// NewLogLevels returns the LogLevels of cfg, filtering the lines logged to
+// logger.
+func NewLogLevels(cfg LogConfig, logger log.Logger) *LogLevels {
+	l := &LogLevels{logger: logger, level: cfg.Level, components: map[string]string{}}
+	for component, lvl := range cfg.ComponentLevels.Read() {
+		l.components[component] = lvl
+	}
+	return l
+}
+
+// Logger returns the logger of component, adding it to the lines logged. The
+// empty component is the app's.
+func (l *LogLevels) Logger(component string) log.Logger {
+	var logger log.Logger = levelFilter{levels: l, component: component, next: l.logger}
+	if component != "" {
+		logger = log.With(logger, "component", component)
+	}
+	return logger
+}
+
+// Set sets the log level of component, or of the app if component is empty.
+// An empty level removes the level of the component, which then uses the
+// app's.
+func (l *LogLevels) Set(component, lvl string) error {
+	if component != "" && !slices.Contains(logComponents, component) {
+		return fmt.Errorf("unknown log component %q", component)
+	}
+	if _, ok := logLevelRanks[lvl]; !ok && (component == "" || lvl != "") {
+		return fmt.Errorf("unknown log level %q", lvl)
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	switch {
+	case component == "":
+		l.level = lvl
+	case lvl == "":
+		delete(l.components, component)
+	default:
+		l.components[component] = lvl
+	}
+	return nil
+}
+
+// allowed returns whether a line of level lvl of component is logged.
+func (l *LogLevels) allowed(component string, lvl level.Value) bool {
+	rank, ok := logLevelRanks[lvl.String()]
+	if !ok {
+		return true
+	}
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+	min, ok := l.components[component]
+	if !ok {
+		min = l.level
+	}
+	return min == "" || rank >= logLevelRanks[min]
+}
+
+// logLevelsResponse is the body of the responses of LogLevels.ServeHTTP:
+//
+//	{"level": "info", "components": {"remoteread": "debug"}}
+type logLevelsResponse struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+}
+
+// ServeHTTP serves the log levels as JSON. POST requests set the level of the
+// component form value, or of the app if it's missing, to the level form
+// value, as Set does.
+func (l *LogLevels) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := l.Set(r.FormValue("component"), r.FormValue("level")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	l.mtx.RLock()
+	resp := logLevelsResponse{Level: l.level, Components: make(map[string]string, len(l.components))}
+	for component, lvl := range l.components {
+		resp.Components[component] = lvl
+	}
+	l.mtx.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// levelFilter drops the lines of its component below its level.
+type levelFilter struct {
+	levels    *LogLevels
+	component string
+	next      log.Logger
+}
+
+func (f levelFilter) Log(keyvals ...interface{}) error {
+	for i := 0; i < len(keyvals)-1; i += 2 {
+		if keyvals[i] != level.Key() {
+			continue
+		}
+		if lvl, ok := keyvals[i+1].(level.Value); ok && !f.levels.allowed(f.component, lvl) {
+			return nil
+		}
+		break
+	}
+	return f.next.Log(keyvals...)
+}
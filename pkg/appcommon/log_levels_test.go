@@ -0,0 +1,98 @@
+package appcommon
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/flagext"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	levels := NewLogLevels(LogConfig{
+		Level:           "info",
+		ComponentLevels: flagext.NewLimitsMapWithData(map[string]string{LogComponentServer: "debug"}, nil),
+	}, log.NewLogfmtLogger(&buf))
+
+	logAll := func() []string {
+		buf.Reset()
+		for _, component := range []string{"", LogComponentServer} {
+			logger := levels.Logger(component)
+			level.Debug(logger).Log("msg", "debug")
+			level.Info(logger).Log("msg", "info")
+			logger.Log("msg", "no level")
+		}
+		return strings.Split(strings.TrimSpace(buf.String()), "\n")
+	}
+
+	require.Equal(t, []string{
+		"level=info msg=info",
+		"msg=\"no level\"",
+		"level=debug component=server msg=debug",
+		"level=info component=server msg=info",
+		"component=server msg=\"no level\"",
+	}, logAll())
+
+	require.NoError(t, levels.Set(LogComponentServer, ""))
+	require.NoError(t, levels.Set("", "warn"))
+	require.Equal(t, []string{
+		"msg=\"no level\"",
+		"component=server msg=\"no level\"",
+	}, logAll())
+
+	require.NoError(t, levels.Set(LogComponentServer, "debug"))
+	require.Equal(t, []string{
+		"msg=\"no level\"",
+		"level=debug component=server msg=debug",
+		"level=info component=server msg=info",
+		"component=server msg=\"no level\"",
+	}, logAll())
+
+	require.Error(t, levels.Set(LogComponentServer, "verbose"))
+	require.Error(t, levels.Set("", ""))
+	require.ErrorContains(t, levels.Set("renderer", "debug"), "unknown log component")
+}
+
+func TestLogConfigValidate(t *testing.T) {
+	require.NoError(t, (&LogConfig{Level: "info", ComponentLevels: flagext.NewLimitsMapWithData(map[string]string{LogComponentServer: "debug"}, nil)}).Validate())
+	require.ErrorContains(t, (&LogConfig{Level: "loud"}).Validate(), "unknown log level")
+	require.ErrorContains(t, (&LogConfig{ComponentLevels: flagext.NewLimitsMapWithData(map[string]string{"renderer": "debug"}, nil)}).Validate(), "unknown log component")
+}
+
+func TestLogLevelsHTTP(t *testing.T) {
+	levels := NewLogLevels(LogConfig{Level: "info"}, log.NewNopLogger())
+
+	recorder := httptest.NewRecorder()
+	levels.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/log-levels", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.JSONEq(t, `{"level": "info", "components": {}}`, recorder.Body.String())
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/debug/log-levels", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+		levels.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder = post(url.Values{"component": {LogComponentServer}, "level": {"debug"}})
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.JSONEq(t, `{"level": "info", "components": {"server": "debug"}}`, recorder.Body.String())
+
+	recorder = post(url.Values{"component": {"converter"}, "level": {"debug"}})
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = post(url.Values{"level": {"loud"}})
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	levels.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/debug/log-levels", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
@@ -0,0 +1,145 @@
+package appcommon
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profiles are named sets of coherent defaults for the server timeouts,
+// middleware limits and client timeouts of the apps, by the name of their
+// flags without prefix, so a new app picks the profile of its workload
+// rather than reasoning about each of them.
+var Profiles = map[string]map[string]string{
+	// interactive-query apps serve queries users wait for: the requests are
+	// small, and abandoned quickly along with their downstream requests.
+	"interactive-query": {
+		"server.http-request-timeout":        "30s",
+		"server.http-server-read-timeout":    "30s",
+		"server.http-server-write-timeout":   "35s",
+		"server.http-max-req-size-limit":     "1048576",
+		"server.http-max-uri-length":         "65536",
+		"server.http-max-header-bytes":       "1048576",
+		"http-client.dial-timeout":           "2s",
+		"http-client.idle-conn-timeout":      "90s",
+		"server.http-max-decompressed-bytes": "10485760",
+	},
+	// bulk-import apps ingest large batches, eg. backfills, which take long
+	// to upload and write.
+	"bulk-import": {
+		"server.http-request-timeout":         "10m",
+		"server.http-server-read-timeout":     "10m",
+		"server.http-server-write-timeout":    "10m30s",
+		"server.http-max-req-size-limit":      "268435456",
+		"server.http-max-decompressed-bytes":  "1073741824",
+		"server.http-max-decompression-ratio": "100",
+		"http-client.dial-timeout":            "10s",
+		"http-client.idle-conn-timeout":       "5m",
+	},
+	// write-proxy apps forward many small writes to Mimir, which clients
+	// retry.
+	"write-proxy": {
+		"server.http-request-timeout":         "30s",
+		"server.http-server-read-timeout":     "30s",
+		"server.http-server-write-timeout":    "35s",
+		"server.http-max-req-size-limit":      "10485760",
+		"server.http-max-decompressed-bytes":  "104857600",
+		"server.http-max-decompression-ratio": "50",
+		"server.idempotency-window":           "5m",
+		"http-client.dial-timeout":            "2s",
+		"http-client.max-idle-conns":          "500",
+	},
+}
+
+func profileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateProfile(name string) error {
+	if _, ok := Profiles[name]; name != "" && !ok {
+		return fmt.Errorf("unknown profile %q, must be one of %s", name, strings.Join(profileNames(), ", "))
+	}
+	return nil
+}
+
+// profileFlag applies the defaults of the profile it's set to to the flags
+// that aren't set yet. The flags set after it on the command line take
+// precedence as well.
+type profileFlag struct {
+	name    *string
+	applied *bool
+	prefix  string
+	flags   *flag.FlagSet
+}
+
+func (f *profileFlag) String() string {
+	if f.name == nil {
+		return ""
+	}
+	return *f.name
+}
+
+func (f *profileFlag) Set(s string) error {
+	if err := validateProfile(s); err != nil {
+		return err
+	}
+	set := map[string]bool{}
+	f.flags.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+	for name, value := range Profiles[s] {
+		name = f.prefix + name
+		fl := f.flags.Lookup(name)
+		if fl == nil || set[name] {
+			continue
+		}
+		if err := fl.Value.Set(value); err != nil {
+			return fmt.Errorf("can't apply profile %s to %s: %w", s, name, err)
+		}
+	}
+	*f.name = s
+	*f.applied = true
+	return nil
+}
+
+// applyProfile applies the defaults of cfg.Profile to the fields of cfg
+// still at their defaults, when the profile wasn't set with its flag, eg. in
+// YAML.
+func (cfg *Config) applyProfile() error {
+	if cfg.Profile == "" || cfg.profileApplied {
+		return nil
+	}
+	if err := validateProfile(cfg.Profile); err != nil {
+		return err
+	}
+
+	// The flags of tmp hold the defaults, then the values of cfg once it's
+	// copied into tmp.
+	var tmp Config
+	flags := flag.NewFlagSet("profile", flag.ContinueOnError)
+	tmp.RegisterFlags(flags)
+	defaults := map[string]string{}
+	for name := range Profiles[cfg.Profile] {
+		if fl := flags.Lookup(name); fl != nil {
+			defaults[name] = fl.Value.String()
+		}
+	}
+
+	tmp = *cfg
+	for name, value := range Profiles[cfg.Profile] {
+		fl := flags.Lookup(name)
+		if fl == nil || fl.Value.String() != defaults[name] {
+			continue
+		}
+		if err := fl.Value.Set(value); err != nil {
+			return fmt.Errorf("can't apply profile %s to %s: %w", cfg.Profile, name, err)
+		}
+	}
+	tmp.profileApplied = true
+	*cfg = tmp
+	return nil
+}
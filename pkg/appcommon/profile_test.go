@@ -0,0 +1,82 @@
+package appcommon
+
+import (
+	"flag"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfile(t *testing.T) {
+	parse := func(t *testing.T, args ...string) (Config, error) {
+		var cfg Config
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		cfg.RegisterFlags(flags)
+		cfg.ServiceName = "test"
+		return cfg, flags.Parse(args)
+	}
+
+	t.Run("applies the defaults of the profile", func(t *testing.T) {
+		cfg, err := parse(t, "-profile=bulk-import")
+		require.NoError(t, err)
+		require.Equal(t, "bulk-import", cfg.Profile)
+		require.Equal(t, 10*time.Minute, cfg.ServerConfig.HTTPRequestTimeout)
+		require.Equal(t, int64(256<<20), cfg.ServerConfig.HTTPMaxRequestSizeLimit)
+		require.Equal(t, 10*time.Second, cfg.HTTPClient.DialTimeout)
+		require.NoError(t, cfg.Validate())
+	})
+
+	t.Run("flags set before or after it take precedence", func(t *testing.T) {
+		cfg, err := parse(t, "-server.http-request-timeout=5s", "-profile=write-proxy", "-http-client.dial-timeout=1s")
+		require.NoError(t, err)
+		require.Equal(t, 5*time.Second, cfg.ServerConfig.HTTPRequestTimeout)
+		require.Equal(t, time.Second, cfg.HTTPClient.DialTimeout)
+		require.Equal(t, 5*time.Minute, cfg.ServerConfig.IdempotencyWindow)
+	})
+
+	t.Run("unknown profile", func(t *testing.T) {
+		_, err := parse(t, "-profile=batch")
+		require.ErrorContains(t, err, `unknown profile "batch", must be one of bulk-import, interactive-query, write-proxy`)
+	})
+
+	t.Run("every profile sets registered flags with valid values", func(t *testing.T) {
+		for name, defaults := range Profiles {
+			var cfg Config
+			flags := flag.NewFlagSet("test", flag.PanicOnError)
+			cfg.RegisterFlags(flags)
+			for flagName := range defaults {
+				require.NotNil(t, flags.Lookup(flagName), "profile %s sets unknown flag %s", name, flagName)
+			}
+			require.NoError(t, flags.Parse([]string{"-profile=" + name}))
+			cfg.ServiceName = "test"
+			require.NoError(t, cfg.Validate(), name)
+		}
+	})
+
+	t.Run("applies the profile set in the config to the fields at their defaults", func(t *testing.T) {
+		cfg, err := parse(t)
+		require.NoError(t, err)
+		cfg.Profile = "bulk-import"
+		cfg.HTTPClient.DialTimeout = time.Second
+		require.NoError(t, cfg.applyProfile())
+		require.Equal(t, 10*time.Minute, cfg.ServerConfig.HTTPRequestTimeout)
+		require.Equal(t, int64(256<<20), cfg.ServerConfig.HTTPMaxRequestSizeLimit)
+		require.Equal(t, time.Second, cfg.HTTPClient.DialTimeout)
+		require.NoError(t, cfg.Validate())
+
+		cfg.Profile = "batch"
+		cfg.profileApplied = false
+		require.ErrorContains(t, cfg.applyProfile(), `unknown profile "batch"`)
+	})
+
+	t.Run("the profile set with its flag isn't applied again", func(t *testing.T) {
+		cfg, err := parse(t, "-profile=bulk-import")
+		require.NoError(t, err)
+		cfg.ServerConfig.HTTPRequestTimeout = 30 * time.Second
+		require.NoError(t, cfg.applyProfile())
+		require.Equal(t, 30*time.Second, cfg.ServerConfig.HTTPRequestTimeout)
+	})
+}
@@ -0,0 +1,17 @@
+package appcommon
+
+import (
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+)
+
+// Secret is a config field for a credential, see secrets.Secret.
+type Secret = secrets.Secret
+
+// SecretProvider returns the current value of a secret, see
+// secrets.Provider.
+type SecretProvider = secrets.Provider
+
+// NewSecretProvider returns the provider for a secret reference, see Secret.
+func NewSecretProvider(ref string) (SecretProvider, error) {
+	return secrets.NewProvider(ref)
+}
@@ -0,0 +1,262 @@
+// Package secrets implements the config fields of credentials, read from
+// files, environment variables or Vault. It's apart from appcommon so the
+// packages appcommon depends on, like internalserver, can use it too.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultVaultRefreshInterval is how long a secret read from Vault is cached
+// before being read again to pick up rotations.
+const defaultVaultRefreshInterval = 5 * time.Minute
+
+const redactedSecret = "********"
+
+// Provider returns the current value of a secret. Implementations pick
+// up rotated secrets, so callers should call Get every time they need the
+// secret instead of keeping its value around.
+type Provider interface {
+	Get(ctx context.Context) (string, error)
+}
+
+// Secret is a config field for a credential, like a password, an API key or a
+// TLS key. It's set to a reference to the secret:
+//
+//   - "file:<path>" reads the file, and re-reads it when it changes. Use it for
+//     Kubernetes mounted secrets, which kubelet updates in place on rotation.
+//   - "env:<name>" reads an environment variable.
+//   - "vault:<path>#<key>" reads the key of a Vault KV secret, v1 or v2, from
+//     the server at VAULT_ADDR with the token in VAULT_TOKEN, re-reading it
+//     every 5 minutes.
+//   - anything else is used as the secret value itself.
+//
+// Secret values are never printed: String and MarshalYAML return the
+// reference, or a redacted placeholder for literal values.
+type Secret struct {
+	ref      string
+	provider Provider
+}
+
+var (
+	_ yaml.Marshaler   = Secret{}
+	_ yaml.Unmarshaler = &Secret{}
+)
+
+// Set implements flag.Value.
+func (s *Secret) Set(ref string) error {
+	provider, err := NewProvider(ref)
+	if err != nil {
+		return err
+	}
+	s.ref, s.provider = ref, provider
+	return nil
+}
+
+// String implements flag.Value.
+func (s Secret) String() string {
+	if s.ref == "" {
+		return ""
+	}
+	if _, isLiteral := s.provider.(literalSecret); isLiteral {
+		return redactedSecret
+	}
+	return s.ref
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (s *Secret) UnmarshalYAML(value *yaml.Node) error {
+	var ref string
+	if err := value.Decode(&ref); err != nil {
+		return err
+	}
+	return s.Set(ref)
+}
+
+// IsSet returns whether the secret was configured.
+func (s Secret) IsSet() bool {
+	return s.provider != nil
+}
+
+// Get returns the current value of the secret, or an empty string if it isn't
+// set.
+func (s Secret) Get(ctx context.Context) (string, error) {
+	if s.provider == nil {
+		return "", nil
+	}
+	return s.provider.Get(ctx)
+}
+
+// NewProvider returns the provider for a secret reference, see Secret.
+func NewProvider(ref string) (Provider, error) {
+	scheme, location, _ := strings.Cut(ref, ":")
+	switch scheme {
+	case "file":
+		if location == "" {
+			return nil, fmt.Errorf("missing path in secret reference %q", ref)
+		}
+		return &fileSecret{path: location}, nil
+	case "env":
+		if location == "" {
+			return nil, fmt.Errorf("missing variable name in secret reference %q", ref)
+		}
+		return envSecret(location), nil
+	case "vault":
+		path, key, ok := strings.Cut(location, "#")
+		if !ok || path == "" || key == "" {
+			return nil, fmt.Errorf("expected \"vault:<path>#<key>\", got %q", ref)
+		}
+		return &vaultSecret{
+			addr:            os.Getenv("VAULT_ADDR"),
+			token:           envSecret("VAULT_TOKEN"),
+			path:            strings.TrimPrefix(path, "/"),
+			key:             key,
+			refreshInterval: defaultVaultRefreshInterval,
+			client:          http.DefaultClient,
+			timeNow:         time.Now,
+		}, nil
+	default:
+		return literalSecret(ref), nil
+	}
+}
+
+type literalSecret string
+
+func (s literalSecret) Get(context.Context) (string, error) {
+	return string(s), nil
+}
+
+type envSecret string
+
+func (s envSecret) Get(context.Context) (string, error) {
+	value, ok := os.LookupEnv(string(s))
+	if !ok {
+		return "", fmt.Errorf("secret environment variable %s isn't set", string(s))
+	}
+	return value, nil
+}
+
+// fileSecret caches the content of a file, re-reading it when its size or
+// modification time changes. Trailing newlines are trimmed.
+type fileSecret struct {
+	path string
+
+	mtx     sync.Mutex
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+func (s *fileSecret) Get(context.Context) (string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", fmt.Errorf("can't read secret file: %w", err)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.value, nil
+	}
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", fmt.Errorf("can't read secret file: %w", err)
+	}
+	s.value = string(bytes.TrimRight(content, "\r\n"))
+	s.modTime, s.size = info.ModTime(), info.Size()
+	return s.value, nil
+}
+
+// vaultSecret reads a key of a Vault KV secret, caching it for
+// refreshInterval.
+type vaultSecret struct {
+	addr            string
+	token           Provider
+	path            string
+	key             string
+	refreshInterval time.Duration
+	client          *http.Client
+	timeNow         func() time.Time
+
+	mtx       sync.Mutex
+	value     string
+	fetchedAt time.Time
+}
+
+func (s *vaultSecret) Get(ctx context.Context) (string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.fetchedAt.IsZero() && s.timeNow().Sub(s.fetchedAt) < s.refreshInterval {
+		return s.value, nil
+	}
+
+	value, err := s.fetch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("can't read secret %s from vault: %w", s.path, err)
+	}
+	s.value, s.fetchedAt = value, s.timeNow()
+	return value, nil
+}
+
+func (s *vaultSecret) fetch(ctx context.Context) (string, error) {
+	if s.addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR isn't set")
+	}
+	token, err := s.token.Get(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(s.addr, "/")+"/v1/"+s.path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("vault returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	// KV v2 nests the secret in data.data, KV v1 in data.
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("can't decode vault response: %w", err)
+	}
+	data := body.Data
+	var nested map[string]json.RawMessage
+	if err := json.Unmarshal(data["data"], &nested); err == nil && nested != nil {
+		data = nested
+	}
+	raw, ok := data[s.key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found", s.key)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("key %q isn't a string", s.key)
+	}
+	return value, nil
+}
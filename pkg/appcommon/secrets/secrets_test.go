@@ -0,0 +1,153 @@
+package secrets
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSecret(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("TEST_SECRET", "from-env")
+	path := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(path, []byte("from-file\n"), 0o600))
+
+	for ref, tc := range map[string]struct {
+		want       string
+		wantString string
+	}{
+		"env:TEST_SECRET": {want: "from-env", wantString: "env:TEST_SECRET"},
+		"file:" + path:    {want: "from-file", wantString: "file:" + path},
+		"literal":         {want: "literal", wantString: redactedSecret},
+	} {
+		t.Run(ref, func(t *testing.T) {
+			var s Secret
+			require.NoError(t, s.Set(ref))
+			require.True(t, s.IsSet())
+			got, err := s.Get(ctx)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+			require.Equal(t, tc.wantString, s.String())
+
+			out, err := yaml.Marshal(struct {
+				S Secret `yaml:"s"`
+			}{s})
+			require.NoError(t, err)
+			require.NotContains(t, string(out), "literal")
+		})
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		var s Secret
+		require.False(t, s.IsSet())
+		got, err := s.Get(ctx)
+		require.NoError(t, err)
+		require.Empty(t, got)
+	})
+
+	t.Run("missing env var", func(t *testing.T) {
+		var s Secret
+		require.NoError(t, s.Set("env:TEST_SECRET_MISSING"))
+		_, err := s.Get(ctx)
+		require.Error(t, err)
+	})
+
+	t.Run("invalid references", func(t *testing.T) {
+		for _, ref := range []string{"file:", "env:", "vault:secret/data/x", "vault:#key"} {
+			require.Error(t, (&Secret{}).Set(ref), ref)
+		}
+	})
+
+	t.Run("flag and yaml", func(t *testing.T) {
+		var cfg struct {
+			Password Secret `yaml:"password"`
+		}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Var(&cfg.Password, "password", "")
+		require.NoError(t, fs.Parse([]string{"-password=env:TEST_SECRET"}))
+		got, err := cfg.Password.Get(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "from-env", got)
+
+		require.NoError(t, yaml.Unmarshal([]byte("password: file:"+path), &cfg))
+		got, err = cfg.Password.Get(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "from-file", got)
+	})
+}
+
+func TestFileSecret_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0o600))
+	s := &fileSecret{path: path}
+
+	got, err := s.Get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "old", got)
+
+	require.NoError(t, os.WriteFile(path, []byte("rotated"), 0o600))
+	got, err = s.Get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "rotated", got)
+}
+
+func TestVaultSecret(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		require.Equal(t, "vault-token", r.Header.Get("X-Vault-Token"))
+		switch r.URL.Path {
+		case "/v1/secret/data/mimir":
+			_, _ = w.Write([]byte(`{"data": {"data": {"password": "v2-password"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/mimir":
+			_, _ = w.Write([]byte(`{"data": {"password": "v1-password"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "vault-token")
+
+	now := time.Now()
+	for ref, want := range map[string]string{
+		"vault:secret/data/mimir#password": "v2-password",
+		"vault:/kv/mimir#password":         "v1-password",
+	} {
+		provider, err := NewProvider(ref)
+		require.NoError(t, err)
+		provider.(*vaultSecret).timeNow = func() time.Time { return now }
+		got, err := provider.Get(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+	require.Equal(t, 2, requests)
+
+	provider, err := NewProvider("vault:secret/data/mimir#password")
+	require.NoError(t, err)
+	vault := provider.(*vaultSecret)
+	vault.timeNow = func() time.Time { return now }
+	_, err = vault.Get(context.Background())
+	require.NoError(t, err)
+	_, err = vault.Get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 3, requests, "the secret is cached")
+
+	now = now.Add(defaultVaultRefreshInterval)
+	_, err = vault.Get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 4, requests, "the secret is read again after the refresh interval")
+
+	provider, err = NewProvider("vault:secret/data/missing#password")
+	require.NoError(t, err)
+	_, err = provider.Get(context.Background())
+	require.ErrorContains(t, err, "404")
+}
@@ -0,0 +1,213 @@
+package appcommon
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/dns"
+	"github.com/grafana/dskit/kv"
+	"github.com/grafana/dskit/kv/memberlist"
+	"github.com/grafana/dskit/netutil"
+	"github.com/grafana/dskit/ring"
+	"github.com/grafana/dskit/services"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	shardingRingName = "sharding"
+	shardingRingKey  = "ring"
+	// shardingTokens is the number of tokens of each replica in the ring,
+	// enough for the keys to be shared out evenly.
+	shardingTokens = 128
+)
+
+// shardingOp only owns the keys of the replicas that are active.
+var shardingOp = ring.NewOp([]ring.InstanceState{ring.ACTIVE}, nil)
+
+// ShardingConfig configures the ring the replicas of the app join, by
+// gossiping with memberlist by default, to share out background work by key.
+type ShardingConfig struct {
+	Enabled          bool                `yaml:"enabled"`
+	InstanceID       string              `yaml:"instance_id"`
+	InstanceAddr     string              `yaml:"instance_addr"`
+	HeartbeatPeriod  time.Duration       `yaml:"heartbeat_period"`
+	HeartbeatTimeout time.Duration       `yaml:"heartbeat_timeout"`
+	KVStore          kv.Config           `yaml:"kvstore"`
+	Memberlist       memberlist.KVConfig `yaml:"memberlist"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *ShardingConfig) RegisterFlags(flags *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (cfg *ShardingConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	hostname, _ := os.Hostname()
+	flags.BoolVar(&cfg.Enabled, prefix+"sharding.enabled", false, "Join the ring of the replicas of the app, to share out the background work by tenant.")
+	flags.StringVar(&cfg.InstanceID, prefix+"sharding.instance-id", hostname, "ID of the replica in the ring. Defaults to the hostname.")
+	flags.StringVar(&cfg.InstanceAddr, prefix+"sharding.instance-addr", "", "Address of the replica shown in the ring. Defaults to the address of the first private network interface.")
+	flags.DurationVar(&cfg.HeartbeatPeriod, prefix+"sharding.heartbeat-period", 15*time.Second, "How often the replica updates its heartbeat in the ring.")
+	flags.DurationVar(&cfg.HeartbeatTimeout, prefix+"sharding.heartbeat-timeout", time.Minute, "How long after its last heartbeat a replica is left out of the ring, and its keys owned by the others.")
+	cfg.KVStore.Store = "memberlist"
+	cfg.KVStore.RegisterFlagsWithPrefix(prefix+"sharding.", "sharding/", flags)
+	cfg.Memberlist.RegisterFlagsWithPrefix(flags, prefix+"sharding.")
+}
+
+// Validate checks the ring can be joined.
+func (cfg *ShardingConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.InstanceID == "" {
+		return errors.New("sharding instance ID can't be empty")
+	}
+	if cfg.HeartbeatPeriod <= 0 || cfg.HeartbeatTimeout <= 0 {
+		return errors.New("sharding heartbeat period and timeout must be positive")
+	}
+	if cfg.KVStore.Store == "memberlist" && len(cfg.Memberlist.JoinMembers) == 0 {
+		return errors.New("sharding with the memberlist store requires members to join, see sharding.memberlist.join")
+	}
+	return nil
+}
+
+// Sharder shares out keys, eg. tenants, between the replicas of the app in
+// its ring, with consistent hashing: the replicas joining or leaving the ring
+// only move the keys of their part of the ring. Each replica runs the
+// background work of the keys it owns, so the work isn't done twice.
+type Sharder struct {
+	instanceID string
+	ring       *ring.Ring
+	manager    *services.Manager
+	stop       chan struct{}
+}
+
+// NewSharder returns the Sharder of the replica, joining the ring once its
+// Handler runs. listenPort is the port of the replica shown in the ring.
+func NewSharder(cfg ShardingConfig, listenPort int, metricPrefix string, reg prometheus.Registerer, logger log.Logger) (*Sharder, error) {
+	if metricPrefix != "" {
+		reg = prometheus.WrapRegistererWithPrefix(metricPrefix+"_", reg)
+	}
+
+	var subservices []services.Service
+	kvCfg := cfg.KVStore
+	if kvCfg.Store == "memberlist" {
+		mlCfg := cfg.Memberlist
+		mlCfg.Codecs = append(mlCfg.Codecs, ring.GetCodec())
+		kvInit := memberlist.NewKVInitService(&mlCfg, logger, dns.NewProvider(logger, reg, dns.GolangResolverType), reg)
+		kvCfg.MemberlistKV = kvInit.GetMemberlistKV
+		subservices = append(subservices, kvInit)
+	}
+
+	ringCfg := ring.Config{
+		KVStore:              kvCfg,
+		HeartbeatTimeout:     cfg.HeartbeatTimeout,
+		ReplicationFactor:    1,
+		SubringCacheDisabled: true,
+	}
+	r, err := ring.New(ringCfg, shardingRingName, shardingRingKey, logger, reg)
+	if err != nil {
+		return nil, fmt.Errorf("can't create sharding ring: %w", err)
+	}
+
+	addr, err := ring.GetInstanceAddr(cfg.InstanceAddr, netutil.PrivateNetworkInterfacesWithFallback([]string{"eth0", "en0"}, logger), logger, false)
+	if err != nil {
+		return nil, fmt.Errorf("can't get sharding instance address: %w", err)
+	}
+	store, err := kv.NewClient(kvCfg, ring.GetCodec(), kv.RegistererWithKVName(reg, shardingRingName+"-lifecycler"), logger)
+	if err != nil {
+		return nil, fmt.Errorf("can't create sharding ring store: %w", err)
+	}
+	var delegate ring.BasicLifecyclerDelegate = ring.NewInstanceRegisterDelegate(ring.ACTIVE, shardingTokens)
+	delegate = ring.NewLeaveOnStoppingDelegate(delegate, logger)
+	delegate = ring.NewAutoForgetDelegate(2*cfg.HeartbeatTimeout, delegate, logger)
+	lifecycler, err := ring.NewBasicLifecycler(ring.BasicLifecyclerConfig{
+		ID:               cfg.InstanceID,
+		Addr:             fmt.Sprintf("%s:%d", addr, listenPort),
+		HeartbeatPeriod:  cfg.HeartbeatPeriod,
+		HeartbeatTimeout: cfg.HeartbeatTimeout,
+		NumTokens:        shardingTokens,
+	}, shardingRingName, shardingRingKey, store, delegate, logger, reg)
+	if err != nil {
+		return nil, fmt.Errorf("can't create sharding lifecycler: %w", err)
+	}
+
+	manager, err := services.NewManager(append(subservices, lifecycler, r)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Sharder{instanceID: cfg.InstanceID, ring: r, manager: manager, stop: make(chan struct{})}, nil
+}
+
+// Handler joins the ring and leaves it when stopped. It fails if one of the
+// services of the ring fails, eg. its store, so the app doesn't keep running
+// with a ring that's no longer updated.
+func (s *Sharder) Handler() (run func() error, stop func(error)) {
+	return s.run, func(error) { close(s.stop) }
+}
+
+func (s *Sharder) run() error {
+	watcher := services.NewFailureWatcher()
+	defer watcher.Close()
+	watcher.WatchManager(s.manager)
+
+	if err := services.StartManagerAndAwaitHealthy(context.Background(), s.manager); err != nil {
+		return fmt.Errorf("can't join the sharding ring: %w", err)
+	}
+	select {
+	case <-s.stop:
+	case err := <-watcher.Chan():
+		_ = services.StopManagerAndAwaitStopped(context.Background(), s.manager)
+		return fmt.Errorf("sharding ring failed: %w", err)
+	}
+	return services.StopManagerAndAwaitStopped(context.Background(), s.manager)
+}
+
+// Shard returns the ID of the replica owning key.
+func (s *Sharder) Shard(key string) (string, error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	set, err := s.ring.Get(h.Sum32(), shardingOp, nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(set.Instances) == 0 {
+		return "", ring.ErrEmptyRing
+	}
+	return set.Instances[0].Id, nil
+}
+
+// Owns returns whether this replica owns key, and so should run its
+// background work. It returns an error if the owner can't be known, eg.
+// while the ring is empty, in which case callers should skip the work
+// rather than risk doing it twice.
+func (s *Sharder) Owns(key string) (bool, error) {
+	owner, err := s.Shard(key)
+	if err != nil {
+		return false, err
+	}
+	return owner == s.instanceID, nil
+}
+
+// Replicas returns the number of replicas in the ring.
+func (s *Sharder) Replicas() int {
+	return s.ring.InstancesCount()
+}
+
+// ServeHTTP serves the status page of the ring.
+func (s *Sharder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.ring.ServeHTTP(w, r)
+}
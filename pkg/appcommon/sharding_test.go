@@ -0,0 +1,113 @@
+package appcommon
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/services"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharder(t *testing.T) {
+	// The ring's store traces its requests with the global tracer.
+	tracer := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+	t.Cleanup(func() { opentracing.SetGlobalTracer(tracer) })
+
+	newSharder := func(id string) *Sharder {
+		cfg := ShardingConfig{
+			Enabled:          true,
+			InstanceID:       id,
+			InstanceAddr:     "127.0.0.1",
+			HeartbeatPeriod:  100 * time.Millisecond,
+			HeartbeatTimeout: time.Minute,
+		}
+		cfg.KVStore.Store = "inmemory"
+		cfg.KVStore.Prefix = "test-sharder/"
+		s, err := NewSharder(cfg, 8080, "test", prometheus.NewPedanticRegistry(), log.NewNopLogger())
+		require.NoError(t, err)
+		return s
+	}
+
+	a := newSharder("a")
+	_, err := a.Owns("tenant-1")
+	require.Error(t, err, "the ring is empty until the replica joins it")
+
+	b := newSharder("b")
+	for _, s := range []*Sharder{a, b} {
+		run, stop := s.Handler()
+		done := make(chan error)
+		go func() { done <- run() }()
+		t.Cleanup(func() {
+			stop(nil)
+			require.NoError(t, <-done)
+		})
+	}
+	require.Eventually(t, func() bool {
+		return a.Replicas() == 2 && b.Replicas() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Each tenant is owned by exactly one of the replicas, and both own some.
+	owned := map[string]int{}
+	for i := 0; i < 100; i++ {
+		tenant := fmt.Sprintf("tenant-%d", i)
+		ownedByA, err := a.Owns(tenant)
+		require.NoError(t, err)
+		ownedByB, err := b.Owns(tenant)
+		require.NoError(t, err)
+		require.NotEqual(t, ownedByA, ownedByB, tenant)
+
+		owner, err := a.Shard(tenant)
+		require.NoError(t, err)
+		owned[owner]++
+	}
+	require.Len(t, owned, 2)
+}
+
+func TestSharder_Failure(t *testing.T) {
+	failing := services.NewBasicService(nil, func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(50 * time.Millisecond):
+			return errors.New("store unreachable")
+		}
+	}, nil)
+	manager, err := services.NewManager(failing)
+	require.NoError(t, err)
+	s := &Sharder{manager: manager, stop: make(chan struct{})}
+
+	run, _ := s.Handler()
+	done := make(chan error)
+	go func() { done <- run() }()
+	select {
+	case err := <-done:
+		require.ErrorContains(t, err, "store unreachable")
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the sharder didn't stop when its ring failed")
+	}
+}
+
+func TestShardingConfig_Validate(t *testing.T) {
+	var cfg ShardingConfig
+	flags := flag.NewFlagSet("test", flag.PanicOnError)
+	cfg.RegisterFlags(flags)
+	require.NoError(t, cfg.Validate())
+
+	require.NoError(t, flags.Parse([]string{"-sharding.enabled", "-sharding.instance-id=a"}))
+	require.ErrorContains(t, cfg.Validate(), "requires members to join")
+
+	require.NoError(t, flags.Parse([]string{"-sharding.memberlist.join=app-gossip:7946"}))
+	require.NoError(t, cfg.Validate())
+
+	cfg.Memberlist.JoinMembers = nil
+	cfg.KVStore.Store = "consul"
+	require.NoError(t, cfg.Validate())
+}
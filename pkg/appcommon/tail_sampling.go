@@ -0,0 +1,44 @@
+package appcommon
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/grafana/dskit/flagext"
+)
+
+// TailSamplingConfig selects the requests whose spans are marked to be kept
+// by the tail sampling policies of the trace collector, on top of the ones
+// failing with a 5xx.
+type TailSamplingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// LatencyThreshold marks the requests slower than it. 0 disables it.
+	LatencyThreshold time.Duration          `yaml:"latency_threshold"`
+	Tenants          flagext.StringSliceCSV `yaml:"tenants"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *TailSamplingConfig) RegisterFlags(flags *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (cfg *TailSamplingConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.BoolVar(&cfg.Enabled, prefix+"tracing.tail-sampling.enabled", false, "Mark the spans of the requests failing with a 5xx, slower than tracing.tail-sampling.latency-threshold or for tracing.tail-sampling.tenants with sampling.keep=true and a sampling.reason attribute, for the tail sampling policies of the trace collector.")
+	flags.DurationVar(&cfg.LatencyThreshold, prefix+"tracing.tail-sampling.latency-threshold", 5*time.Second, "Requests slower than this are marked to be kept by tail sampling. 0 to disable.")
+	flags.Var(&cfg.Tenants, prefix+"tracing.tail-sampling.tenants", "Comma separated list of the tenants whose requests are marked to be kept by tail sampling.")
+}
+
+func (cfg *TailSamplingConfig) Validate() error {
+	if cfg.LatencyThreshold < 0 {
+		return errors.New("tail sampling latency threshold can't be negative")
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package appcommon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/flagext"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
+)
+
+const (
+	TenantUsageSinkPrometheus = "prometheus"
+	TenantUsageSinkHTTP       = "http"
+	TenantUsageSinkKafka      = "kafka"
+
+	tenantUsageTimeout = 30 * time.Second
+)
+
+// TenantUsageConfig configures the accounting of the requests, samples
+// returned and bytes sent per tenant, for chargeback.
+type TenantUsageConfig struct {
+	Enabled      bool                   `yaml:"enabled"`
+	Interval     time.Duration          `yaml:"interval"`
+	Sink         string                 `yaml:"sink"`
+	URL          string                 `yaml:"url"`
+	KafkaBrokers flagext.StringSliceCSV `yaml:"kafka_brokers"`
+	KafkaTopic   string                 `yaml:"kafka_topic"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *TenantUsageConfig) RegisterFlags(flags *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (cfg *TenantUsageConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.BoolVar(&cfg.Enabled, prefix+"tenant-usage.enabled", false, "Count the requests, samples returned and response bytes of each tenant over calendar months, and periodically export them to tenant-usage.sink.")
+	flags.DurationVar(&cfg.Interval, prefix+"tenant-usage.interval", time.Minute, "How often the usage of the tenants is exported.")
+	flags.StringVar(&cfg.Sink, prefix+"tenant-usage.sink", TenantUsageSinkPrometheus, "Where the usage of the tenants is exported: prometheus (gauges of the current month), http (JSON posted to tenant-usage.url) or kafka (JSON records produced to tenant-usage.kafka-topic).")
+	flags.StringVar(&cfg.URL, prefix+"tenant-usage.url", "", "URL the usage of the tenants is posted to as JSON, with the http sink.")
+	flags.Var(&cfg.KafkaBrokers, prefix+"tenant-usage.kafka-brokers", "Comma separated list of the Kafka brokers, with the kafka sink.")
+	flags.StringVar(&cfg.KafkaTopic, prefix+"tenant-usage.kafka-topic", "tenant-usage", "Kafka topic the usage of the tenants is produced to, with the kafka sink.")
+}
+
+func (cfg *TenantUsageConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Interval <= 0 {
+		return errors.New("tenant usage interval must be positive")
+	}
+	switch cfg.Sink {
+	case TenantUsageSinkPrometheus:
+	case TenantUsageSinkHTTP:
+		if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("tenant usage URL %q must be an absolute http or https URL", cfg.URL)
+		}
+	case TenantUsageSinkKafka:
+		if len(cfg.KafkaBrokers) == 0 || cfg.KafkaTopic == "" {
+			return errors.New("tenant usage kafka sink needs brokers and a topic")
+		}
+	default:
+		return fmt.Errorf("unknown tenant usage sink %q", cfg.Sink)
+	}
+	return nil
+}
+
+// TenantUsageSink receives the usage of the tenants. The reports of a month
+// are exported again on each interval, with the counts so far, until the
+// final one is exported successfully.
+type TenantUsageSink interface {
+	Export(ctx context.Context, reports []middleware.TenantUsageReport) error
+}
+
+// NewTenantUsageSink returns the sink configured in cfg, and a function to
+// close it.
+func NewTenantUsageSink(cfg TenantUsageConfig, metricPrefix string, reg prometheus.Registerer) (TenantUsageSink, func(), error) {
+	switch cfg.Sink {
+	case TenantUsageSinkHTTP:
+		return &httpUsageSink{url: cfg.URL, client: &http.Client{Timeout: tenantUsageTimeout}}, func() {}, nil
+	case TenantUsageSinkKafka:
+		client, err := kgo.NewClient(kgo.SeedBrokers(cfg.KafkaBrokers...), kgo.DefaultProduceTopic(cfg.KafkaTopic))
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't create tenant usage kafka client: %w", err)
+		}
+		return kafkaUsageSink{client: client}, client.Close, nil
+	default:
+		sink := prometheusUsageSink{
+			usage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: metricPrefix,
+				Name:      "tenant_usage_month_to_date",
+				Help:      "The usage of each tenant since the start of the calendar month, by tenant and kind: requests, samples or bytes_sent.",
+			}, []string{"user", "kind"}),
+		}
+		if err := reg.Register(sink.usage); err != nil {
+			return nil, nil, err
+		}
+		return sink, func() {}, nil
+	}
+}
+
+// prometheusUsageSink exports the usage of the current month as gauges. They
+// start over from 0 when the process restarts.
+type prometheusUsageSink struct {
+	usage *prometheus.GaugeVec
+}
+
+func (s prometheusUsageSink) Export(_ context.Context, reports []middleware.TenantUsageReport) error {
+	s.usage.Reset()
+	for _, report := range reports {
+		if report.Final {
+			continue
+		}
+		for tenant, counts := range report.Tenants {
+			s.usage.WithLabelValues(tenant, "requests").Set(float64(counts.Requests))
+			s.usage.WithLabelValues(tenant, "samples").Set(float64(counts.Samples))
+			s.usage.WithLabelValues(tenant, "bytes_sent").Set(float64(counts.BytesSent))
+		}
+	}
+	return nil
+}
+
+// httpUsageSink posts the reports as a JSON array.
+type httpUsageSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpUsageSink) Export(ctx context.Context, reports []middleware.TenantUsageReport) error {
+	body, err := json.Marshal(reports)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New("tenant usage endpoint returned HTTP status " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
+
+// kafkaUsageSink produces a JSON record per report, keyed by its instance and
+// the start of its month, so a compacted topic keeps the last report of each
+// instance for each month.
+type kafkaUsageSink struct {
+	client *kgo.Client
+}
+
+func (s kafkaUsageSink) Export(ctx context.Context, reports []middleware.TenantUsageReport) error {
+	records := make([]*kgo.Record, 0, len(reports))
+	for _, report := range reports {
+		value, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		key := report.Instance + "/" + report.PeriodStart.Format(time.DateOnly)
+		records = append(records, &kgo.Record{Key: []byte(key), Value: value})
+	}
+	return s.client.ProduceSync(ctx, records...).FirstErr()
+}
+
+// TenantUsageExporter periodically exports the usage counted by a
+// middleware.TenantUsage to a TenantUsageSink. The counts of a month are
+// dropped once its final report is exported.
+type TenantUsageExporter struct {
+	cfg    TenantUsageConfig
+	usage  *middleware.TenantUsage
+	sink   TenantUsageSink
+	logger log.Logger
+	stop   chan struct{}
+}
+
+func NewTenantUsageExporter(cfg TenantUsageConfig, usage *middleware.TenantUsage, sink TenantUsageSink, logger log.Logger) *TenantUsageExporter {
+	return &TenantUsageExporter{
+		cfg:    cfg,
+		usage:  usage,
+		sink:   sink,
+		logger: log.With(logger, "component", "tenant-usage"),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Handler returns two functions to run and stop the exports. The usage is
+// exported one last time when stopping.
+func (e *TenantUsageExporter) Handler() (run func() error, stop func(error)) {
+	return e.run, func(error) { close(e.stop) }
+}
+
+func (e *TenantUsageExporter) run() error {
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			runRecovered(e.logger, "tenant-usage", func() { e.export(time.Now()) })
+		case <-e.stop:
+			runRecovered(e.logger, "tenant-usage", func() { e.export(time.Now()) })
+			return nil
+		}
+	}
+}
+
+func (e *TenantUsageExporter) export(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), tenantUsageTimeout)
+	defer cancel()
+	reports := e.usage.Reports(now)
+	if err := e.sink.Export(ctx, reports); err != nil {
+		level.Warn(e.logger).Log("msg", "can't export tenant usage", "err", err)
+		return
+	}
+	for _, report := range reports {
+		if report.Final {
+			e.usage.Forget(report.PeriodStart)
+		}
+	}
+}
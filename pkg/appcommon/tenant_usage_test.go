@@ -0,0 +1,100 @@
+package appcommon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
+)
+
+func TestTenantUsageSinks(t *testing.T) {
+	january := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	reports := []middleware.TenantUsageReport{{
+		PeriodStart: january,
+		PeriodEnd:   january.AddDate(0, 1, 0),
+		Final:       true,
+		Tenants:     map[string]middleware.TenantUsageCounts{"a": {Requests: 100}},
+	}, {
+		PeriodStart: january.AddDate(0, 1, 0),
+		PeriodEnd:   january.AddDate(0, 2, 0),
+		Tenants:     map[string]middleware.TenantUsageCounts{"a": {Requests: 2, Samples: 10, BytesSent: 50}},
+	}}
+
+	t.Run("prometheus", func(t *testing.T) {
+		reg := prometheus.NewPedanticRegistry()
+		sink, closeSink, err := NewTenantUsageSink(TenantUsageConfig{Sink: TenantUsageSinkPrometheus}, "test", reg)
+		require.NoError(t, err)
+		defer closeSink()
+		require.NoError(t, sink.Export(context.Background(), reports))
+		require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP test_tenant_usage_month_to_date The usage of each tenant since the start of the calendar month, by tenant and kind: requests, samples or bytes_sent.
+# TYPE test_tenant_usage_month_to_date gauge
+test_tenant_usage_month_to_date{kind="bytes_sent",user="a"} 50
+test_tenant_usage_month_to_date{kind="requests",user="a"} 2
+test_tenant_usage_month_to_date{kind="samples",user="a"} 10
+`)))
+	})
+
+	t.Run("http", func(t *testing.T) {
+		var received []middleware.TenantUsageReport
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		}))
+		defer srv.Close()
+
+		sink, closeSink, err := NewTenantUsageSink(TenantUsageConfig{Sink: TenantUsageSinkHTTP, URL: srv.URL}, "", prometheus.NewRegistry())
+		require.NoError(t, err)
+		defer closeSink()
+		require.NoError(t, sink.Export(context.Background(), reports))
+		require.Equal(t, reports, received)
+	})
+}
+
+func TestTenantUsageExporter(t *testing.T) {
+	usage := middleware.NewTenantUsageMiddleware()
+	handler := usage.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(user.InjectOrgID(req.Context(), "a")))
+
+	sink := &recordingUsageSink{err: errors.New("unavailable")}
+	e := NewTenantUsageExporter(TenantUsageConfig{}, usage, sink, log.NewNopLogger())
+
+	// The month is over, but its final report isn't exported yet, so it's
+	// kept for the next export.
+	nextMonth := time.Now().AddDate(0, 1, 0)
+	e.export(nextMonth)
+	require.Len(t, usage.Reports(nextMonth), 2)
+
+	sink.err = nil
+	e.export(nextMonth)
+	require.Len(t, sink.exported, 2)
+	require.True(t, sink.exported[0].Final)
+	require.Equal(t, int64(1), sink.exported[0].Tenants["a"].Requests)
+	require.Len(t, usage.Reports(nextMonth), 1)
+}
+
+type recordingUsageSink struct {
+	err      error
+	exported []middleware.TenantUsageReport
+}
+
+func (s *recordingUsageSink) Export(_ context.Context, reports []middleware.TenantUsageReport) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.exported = reports
+	return nil
+}
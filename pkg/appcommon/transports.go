@@ -7,9 +7,14 @@ import (
 	"github.com/opentracing-contrib/go-stdlib/nethttp"
 
 	opentracing "github.com/opentracing/opentracing-go"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
 )
 
 // TracerTransport is a RoundTripper that records opentracing information
+//
+// Deprecated: use middleware.NewClientTracing, which also tags the spans with
+// the response size and the errorx classification of the failures.
 type TracerTransport struct {
 	http.RoundTripper
 	// name is the name of the Operation being performed for the span.
@@ -47,12 +52,5 @@ func (t *AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 // NewTracedAuthRoundTripper creates a RoundTripper that does both tracing
 // and org ID injection.
 func NewTracedAuthRoundTripper(rt http.RoundTripper, name string) http.RoundTripper {
-	return &TracerTransport{
-		RoundTripper: &AuthTransport{
-			RoundTripper: &nethttp.Transport{
-				RoundTripper: rt,
-			},
-		},
-		name: name,
-	}
+	return middleware.NewClientTracing(&AuthTransport{RoundTripper: rt}, name)
 }
@@ -0,0 +1,216 @@
+package appcommon
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/user"
+)
+
+const (
+	// maxUsageStatsFamilies bounds the API families counted between two
+	// reports, the requests of the others are counted as otherFamily.
+	maxUsageStatsFamilies = 50
+	otherFamily           = "other"
+	rootFamily            = "root"
+
+	usageStatsTimeout = 30 * time.Second
+)
+
+// UsageStatsConfig configures the opt-in reporting of anonymous usage
+// statistics.
+type UsageStatsConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	URL      string        `yaml:"url"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *UsageStatsConfig) RegisterFlags(flags *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (cfg *UsageStatsConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.BoolVar(&cfg.Enabled, prefix+"usage-stats.enabled", false, "Periodically send anonymous usage statistics, the version, the number of active tenants and the request rate per API family, to usage-stats.url. Tenant IDs aren't sent.")
+	flags.StringVar(&cfg.URL, prefix+"usage-stats.url", "", "URL the usage statistics are posted to as JSON.")
+	flags.DurationVar(&cfg.Interval, prefix+"usage-stats.interval", time.Hour, "How often the usage statistics are sent.")
+}
+
+func (cfg *UsageStatsConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("usage stats URL %q must be an absolute http or https URL", cfg.URL)
+	}
+	if cfg.Interval <= 0 {
+		return errors.New("usage stats interval must be positive")
+	}
+	return nil
+}
+
+// UsageReport is the JSON body of the usage statistics.
+type UsageReport struct {
+	// InstanceID identifies the process, it's random and changes on restarts.
+	InstanceID      string `json:"instance_id"`
+	ServiceName     string `json:"service_name"`
+	Version         string `json:"version"`
+	CommitTimestamp string `json:"commit_timestamp"`
+	// IntervalSeconds is the time covered by the report.
+	IntervalSeconds float64 `json:"interval_seconds"`
+	ActiveTenants   int     `json:"active_tenants"`
+	// RequestRates is the number of requests per second by API family, the
+	// first segment of the request path.
+	RequestRates map[string]float64 `json:"request_rates"`
+}
+
+// UsageStats is a Middleware counting the tenants and requests served, and
+// periodically posting them to the configured URL. It must run after the
+// auth middleware, so the tenant is set in the request context.
+type UsageStats struct {
+	cfg         UsageStatsConfig
+	instanceID  string
+	serviceName string
+	client      *http.Client
+	logger      log.Logger
+	stop        chan struct{}
+
+	mtx      sync.Mutex
+	since    time.Time
+	tenants  map[string]struct{}
+	requests map[string]int
+}
+
+func NewUsageStats(cfg UsageStatsConfig, serviceName string, logger log.Logger) *UsageStats {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return &UsageStats{
+		cfg:         cfg,
+		instanceID:  hex.EncodeToString(id),
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: usageStatsTimeout},
+		logger:      log.With(logger, "component", "usage-stats"),
+		stop:        make(chan struct{}),
+		since:       time.Now(),
+		tenants:     map[string]struct{}{},
+		requests:    map[string]int{},
+	}
+}
+
+// Wrap implements middleware.Interface
+func (u *UsageStats) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, _ := user.ExtractOrgID(r.Context())
+		family := apiFamily(r.URL.Path)
+
+		u.mtx.Lock()
+		if tenant != "" {
+			u.tenants[tenant] = struct{}{}
+		}
+		if _, ok := u.requests[family]; !ok && len(u.requests) >= maxUsageStatsFamilies {
+			family = otherFamily
+		}
+		u.requests[family]++
+		u.mtx.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiFamily returns the first segment of path.
+func apiFamily(path string) string {
+	family, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if family == "" {
+		return rootFamily
+	}
+	return family
+}
+
+// Handler returns two functions to run and stop the reporting.
+func (u *UsageStats) Handler() (run func() error, stop func(error)) {
+	return u.run, func(error) { close(u.stop) }
+}
+
+func (u *UsageStats) run() error {
+	ticker := time.NewTicker(u.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			runRecovered(u.logger, "usage-stats", func() {
+				if err := u.send(context.Background(), u.report(time.Now())); err != nil {
+					level.Debug(u.logger).Log("msg", "can't send usage stats", "err", err)
+				}
+			})
+		case <-u.stop:
+			return nil
+		}
+	}
+}
+
+// report returns the report of the requests since the last one, and resets
+// the counts.
+func (u *UsageStats) report(now time.Time) UsageReport {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	interval := now.Sub(u.since).Seconds()
+	report := UsageReport{
+		InstanceID:      u.instanceID,
+		ServiceName:     u.serviceName,
+		Version:         DockerTag,
+		CommitTimestamp: CommitUnixTimestamp,
+		IntervalSeconds: interval,
+		ActiveTenants:   len(u.tenants),
+		RequestRates:    make(map[string]float64, len(u.requests)),
+	}
+	for family, count := range u.requests {
+		report.RequestRates[family] = float64(count) / interval
+	}
+
+	u.since = now
+	u.tenants = map[string]struct{}{}
+	u.requests = map[string]int{}
+	return report
+}
+
+func (u *UsageStats) send(ctx context.Context, report UsageReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New("usage stats endpoint returned HTTP status " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
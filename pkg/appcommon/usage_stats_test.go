@@ -0,0 +1,54 @@
+package appcommon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageStats(t *testing.T) {
+	reports := make(chan UsageReport, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report UsageReport
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&report))
+		reports <- report
+	}))
+	defer srv.Close()
+
+	u := NewUsageStats(UsageStatsConfig{Enabled: true, URL: srv.URL, Interval: time.Hour}, "graphite-proxy", log.NewNopLogger())
+	handler := u.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for _, req := range []struct{ tenant, path string }{
+		{"tenant-1", "/graphite/render"},
+		{"tenant-1", "/graphite/metrics/find"},
+		{"tenant-2", "/api/v1/push"},
+		{"", "/"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, req.path, nil)
+		if req.tenant != "" {
+			r = r.WithContext(user.InjectOrgID(r.Context(), req.tenant))
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	report := u.report(u.since.Add(10 * time.Second))
+	require.NoError(t, u.send(context.Background(), report))
+	sent := <-reports
+	require.Equal(t, report, sent)
+	require.Equal(t, "graphite-proxy", sent.ServiceName)
+	require.Len(t, sent.InstanceID, 32)
+	require.Equal(t, 10.0, sent.IntervalSeconds)
+	require.Equal(t, 2, sent.ActiveTenants)
+	require.Equal(t, map[string]float64{"graphite": 0.2, "api": 0.1, "root": 0.1}, sent.RequestRates)
+
+	// The counts are reset after each report.
+	report = u.report(u.since.Add(10 * time.Second))
+	require.Zero(t, report.ActiveTenants)
+	require.Empty(t, report.RequestRates)
+}
@@ -1,14 +1,16 @@
 package errorx
 
 // NOTE: If you add a new error type to this file you must create a new
-// type enum value in subquery.proto. You must also create a new round-trip
-// test in errors_test.go.
+// type enum value in errors.proto, and convert it back in fromDetails. You
+// must also add it to the conversion matrix in errorxtest.
 
 import (
 	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"google.golang.org/grpc/codes"
 
@@ -22,16 +24,38 @@ import (
 type Error interface {
 	error
 	HTTPStatusCode() int
+	// Message is the operator-facing message, which is logged. Unlike
+	// Error, it doesn't include the wrapped errors.
 	Message() string
 	GRPCStatus() *grpcStatus.Status
 	GRPCStatusDetails() []protov1.Message
 }
 
+// UserMessager is implemented by the errors whose message returned to users
+// isn't their Message, like the errors of this package, whose UserMessage is
+// their UserMsg when set, so internal details in Msg, eg. a bucket name,
+// don't leak.
+type UserMessager interface {
+	UserMessage() string
+}
+
+// UserMessage returns the message of err returned to users: the UserMessage
+// of the first error of its chain implementing UserMessager, or its Message.
+func UserMessage(err Error) string {
+	var u UserMessager
+	if errors.As(err, &u) {
+		return u.UserMessage()
+	}
+	return err.Message()
+}
+
 // FromGRPCStatus converts a Status to either context.Canceled or a native Error
 // type. The GRPC Status type is ignored in this conversion -- instead we expect
 // ErrorDetails to be included naming the correct internal type. Statuses
-// without details will be returned as Internal errors.
-func FromGRPCStatus(s *grpcStatus.Status) error { //nolint:gocyclo
+// without details will be returned as Internal errors. The messages of the
+// errors are prefixed with the status code, except the ones of the
+// Validation errors, which are the original messages.
+func FromGRPCStatus(s *grpcStatus.Status) error {
 	msg := fmt.Sprintf("grpc %v: %s", s.Code(), s.Message())
 	if s.Code() == codes.OK {
 		return nil
@@ -41,39 +65,74 @@ func FromGRPCStatus(s *grpcStatus.Status) error { //nolint:gocyclo
 
 	for _, di := range s.Details() {
 		if d, ok := di.(*errorxpb.ErrorDetails); ok {
-			switch d.Type {
-			case errorxpb.ErrorxType_UNKNOWN:
-				return Internal{Msg: "unknown errorx type specifier. " + msg}
-			case errorxpb.ErrorxType_INTERNAL:
-				return Internal{Msg: msg}
-			case errorxpb.ErrorxType_BAD_REQUEST:
-				return BadRequest{Msg: msg}
-			case errorxpb.ErrorxType_REQUIRES_PROXY_REQUEST:
-				return RequiresProxyRequest{Msg: msg, Reason: d.Reason}
-			case errorxpb.ErrorxType_RATE_LIMITED:
-				return TooManyRequests{Msg: msg}
-			case errorxpb.ErrorxType_DISABLED:
-				return Disabled{}
-			case errorxpb.ErrorxType_UNIMPLEMENTED:
-				return Unimplemented{Msg: msg}
-			case errorxpb.ErrorxType_UNPROCESSABLE_ENTITY:
-				return UnprocessableEntity{Msg: msg}
-			case errorxpb.ErrorxType_CONFLICT:
-				return Conflict{Msg: msg}
-			case errorxpb.ErrorxType_TOO_MANY_REQUESTS:
-				return TooManyRequests{Msg: msg}
-			case errorxpb.ErrorxType_UNSUPPORTED_MEDIA_TYPE:
-				return UnsupportedMediaType{Msg: msg}
-			case errorxpb.ErrorxType_REQUEST_TIMEOUT:
-				return RequestTimeout{Msg: msg}
-			default:
-				return Internal{Msg: "invalid errorx type specifier. " + msg}
+			if d.Type == errorxpb.ErrorxType_VALIDATION {
+				return validationFromGRPCStatus(s, d)
 			}
+			return fromDetails(d, msg, nil)
 		}
 	}
 	return Internal{Msg: "missing errorx type specifier. " + msg}
 }
 
+// validationFromGRPCStatus returns the Validation error of s, whose message
+// is the one of the original error, without the violations it lists, as
+// they're in the details already.
+func validationFromGRPCStatus(s *grpcStatus.Status, d *errorxpb.ErrorDetails) Validation {
+	v := Validation{UserMsg: d.UserMessage, Violations: violationsFromDetails(d)}
+	v.Msg = s.Message()
+	if len(v.Violations) > 0 {
+		v.Msg = strings.TrimSuffix(v.Msg, ": "+v.violations())
+	}
+	return v
+}
+
+// fromDetails returns the Error of the type named in d, with the given
+// message and wrapped error.
+func fromDetails(d *errorxpb.ErrorDetails, msg string, err error) Error { //nolint:gocyclo
+	switch d.Type {
+	case errorxpb.ErrorxType_UNKNOWN:
+		return Internal{Msg: "unknown errorx type specifier. " + msg, Err: err}
+	case errorxpb.ErrorxType_INTERNAL:
+		return Internal{Msg: msg, UserMsg: d.UserMessage, Err: err}
+	case errorxpb.ErrorxType_BAD_REQUEST:
+		return BadRequest{Msg: msg, UserMsg: d.UserMessage, Err: err}
+	case errorxpb.ErrorxType_REQUIRES_PROXY_REQUEST:
+		return RequiresProxyRequest{Msg: msg, UserMsg: d.UserMessage, Err: err, Reason: d.Reason}
+	case errorxpb.ErrorxType_RATE_LIMITED:
+		return TooManyRequests{Msg: msg, UserMsg: d.UserMessage, Err: err}
+	case errorxpb.ErrorxType_DISABLED:
+		return Disabled{}
+	case errorxpb.ErrorxType_UNIMPLEMENTED:
+		return Unimplemented{Msg: msg, UserMsg: d.UserMessage}
+	case errorxpb.ErrorxType_UNPROCESSABLE_ENTITY:
+		return UnprocessableEntity{Msg: msg, UserMsg: d.UserMessage}
+	case errorxpb.ErrorxType_CONFLICT:
+		return Conflict{Msg: msg, UserMsg: d.UserMessage, Err: err}
+	case errorxpb.ErrorxType_TOO_MANY_REQUESTS:
+		return TooManyRequests{Msg: msg, UserMsg: d.UserMessage, Err: err, RetryAfter: retryAfterFromDetails(d), Limit: d.Limit}
+	case errorxpb.ErrorxType_UNSUPPORTED_MEDIA_TYPE:
+		return UnsupportedMediaType{Msg: msg, UserMsg: d.UserMessage, Err: err}
+	case errorxpb.ErrorxType_REQUEST_TIMEOUT:
+		return RequestTimeout{Msg: msg, UserMsg: d.UserMessage, Err: err}
+	case errorxpb.ErrorxType_UNAVAILABLE:
+		return Unavailable{Msg: msg, UserMsg: d.UserMessage, Err: err, RetryAfter: retryAfterFromDetails(d)}
+	case errorxpb.ErrorxType_VALIDATION:
+		return Validation{Msg: msg, UserMsg: d.UserMessage, Violations: violationsFromDetails(d)}
+	case errorxpb.ErrorxType_UNAUTHORIZED:
+		return Unauthorized{Msg: msg, UserMsg: d.UserMessage, Err: err}
+	case errorxpb.ErrorxType_PARTIAL_DATA:
+		return PartialData{Msg: msg, UserMsg: d.UserMessage, Err: err, Start: fromUnixMilli(d.PartialStartMs), End: fromUnixMilli(d.PartialEndMs)}
+	case errorxpb.ErrorxType_PARSE:
+		return Parse{Msg: msg, UserMsg: d.UserMessage, Err: err, Input: d.ParseInput, Position: int(d.ParsePosition)}
+	case errorxpb.ErrorxType_LIMIT_EXCEEDED:
+		return LimitExceeded{Msg: msg, UserMsg: d.UserMessage, Err: err, Limit: d.Limit, Value: d.LimitValue, Max: d.LimitMax}
+	case errorxpb.ErrorxType_UNSUPPORTED:
+		return Unsupported{Msg: msg, UserMsg: d.UserMessage, Err: err, Feature: d.Feature}
+	default:
+		return Internal{Msg: "invalid errorx type specifier. " + msg, Err: err}
+	}
+}
+
 func WithErrorxTypeDetail(s *grpcStatus.Status, details ...protov1.Message) *grpcStatus.Status {
 	var err error
 
@@ -108,8 +167,12 @@ func ErrorAsGRPCStatus(err error) *grpcStatus.Status {
 var _ Error = Internal{}
 
 type Internal struct {
-	Msg string
-	Err error
+	Msg     string
+	UserMsg string
+	Err     error
+
+	// stack is set by Wrap and Internalf.
+	stack *stack
 }
 
 func (e Internal) Error() string {
@@ -123,6 +186,10 @@ func (e Internal) Message() string {
 	return e.Msg
 }
 
+func (e Internal) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
 func (e Internal) Unwrap() error {
 	return e.Err
 }
@@ -137,15 +204,17 @@ func (e Internal) GRPCStatus() *grpcStatus.Status {
 
 func (e Internal) GRPCStatusDetails() []protov1.Message {
 	return []protov1.Message{&errorxpb.ErrorDetails{
-		Type: errorxpb.ErrorxType_INTERNAL,
+		Type:        errorxpb.ErrorxType_INTERNAL,
+		UserMessage: e.UserMsg,
 	}}
 }
 
 var _ Error = BadRequest{}
 
 type BadRequest struct {
-	Msg string
-	Err error
+	Msg     string
+	UserMsg string
+	Err     error
 }
 
 func (e BadRequest) Error() string {
@@ -159,6 +228,10 @@ func (e BadRequest) Message() string {
 	return e.Msg
 }
 
+func (e BadRequest) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
 func (e BadRequest) Unwrap() error {
 	return e.Err
 }
@@ -173,7 +246,8 @@ func (e BadRequest) GRPCStatus() *grpcStatus.Status {
 
 func (e BadRequest) GRPCStatusDetails() []protov1.Message {
 	return []protov1.Message{&errorxpb.ErrorDetails{
-		Type: errorxpb.ErrorxType_BAD_REQUEST,
+		Type:        errorxpb.ErrorxType_BAD_REQUEST,
+		UserMessage: e.UserMsg,
 	}}
 }
 
@@ -181,8 +255,9 @@ var _ Error = RequiresProxyRequest{}
 
 // RequiresProxyRequest signifies the request could not be completed locally (eg. unsupported target function), so should be forwarded to the appropriate proxy.
 type RequiresProxyRequest struct {
-	Msg string
-	Err error
+	Msg     string
+	UserMsg string
+	Err     error
 	// Reason field should be a low cardinality value, used for labeling metrics.
 	Reason string
 }
@@ -198,6 +273,10 @@ func (e RequiresProxyRequest) Message() string {
 	return e.Msg
 }
 
+func (e RequiresProxyRequest) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
 func (e RequiresProxyRequest) Unwrap() error {
 	return e.Err
 }
@@ -212,8 +291,9 @@ func (e RequiresProxyRequest) GRPCStatus() *grpcStatus.Status {
 
 func (e RequiresProxyRequest) GRPCStatusDetails() []protov1.Message {
 	return []protov1.Message{&errorxpb.ErrorDetails{
-		Type:   errorxpb.ErrorxType_REQUIRES_PROXY_REQUEST,
-		Reason: e.Reason,
+		Type:        errorxpb.ErrorxType_REQUIRES_PROXY_REQUEST,
+		Reason:      e.Reason,
+		UserMessage: e.UserMsg,
 	}}
 }
 
@@ -225,6 +305,10 @@ func (e Disabled) Message() string {
 	return "feature disabled"
 }
 
+func (e Disabled) UserMessage() string {
+	return e.Message()
+}
+
 func (e Disabled) Error() string {
 	return "disabled"
 }
@@ -246,7 +330,8 @@ func (e Disabled) GRPCStatusDetails() []protov1.Message {
 var _ Error = Unimplemented{}
 
 type Unimplemented struct {
-	Msg string
+	Msg     string
+	UserMsg string
 }
 
 func (e Unimplemented) Error() string {
@@ -257,6 +342,10 @@ func (e Unimplemented) Message() string {
 	return e.Msg
 }
 
+func (e Unimplemented) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
 func (e Unimplemented) HTTPStatusCode() int {
 	return http.StatusNotImplemented
 }
@@ -267,14 +356,16 @@ func (e Unimplemented) GRPCStatus() *grpcStatus.Status {
 
 func (e Unimplemented) GRPCStatusDetails() []protov1.Message {
 	return []protov1.Message{&errorxpb.ErrorDetails{
-		Type: errorxpb.ErrorxType_UNIMPLEMENTED,
+		Type:        errorxpb.ErrorxType_UNIMPLEMENTED,
+		UserMessage: e.UserMsg,
 	}}
 }
 
 var _ Error = UnprocessableEntity{}
 
 type UnprocessableEntity struct {
-	Msg string
+	Msg     string
+	UserMsg string
 }
 
 func (e UnprocessableEntity) Error() string {
@@ -285,6 +376,10 @@ func (e UnprocessableEntity) Message() string {
 	return e.Msg
 }
 
+func (e UnprocessableEntity) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
 func (e UnprocessableEntity) HTTPStatusCode() int {
 	return http.StatusUnprocessableEntity
 }
@@ -295,15 +390,17 @@ func (e UnprocessableEntity) GRPCStatus() *grpcStatus.Status {
 
 func (e UnprocessableEntity) GRPCStatusDetails() []protov1.Message {
 	return []protov1.Message{&errorxpb.ErrorDetails{
-		Type: errorxpb.ErrorxType_UNPROCESSABLE_ENTITY,
+		Type:        errorxpb.ErrorxType_UNPROCESSABLE_ENTITY,
+		UserMessage: e.UserMsg,
 	}}
 }
 
 var _ Error = Conflict{}
 
 type Conflict struct {
-	Msg string
-	Err error
+	Msg     string
+	UserMsg string
+	Err     error
 }
 
 func (e Conflict) Error() string {
@@ -317,6 +414,10 @@ func (e Conflict) Message() string {
 	return e.Msg
 }
 
+func (e Conflict) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
 func (e Conflict) Unwrap() error {
 	return e.Err
 }
@@ -331,15 +432,17 @@ func (e Conflict) GRPCStatus() *grpcStatus.Status {
 
 func (e Conflict) GRPCStatusDetails() []protov1.Message {
 	return []protov1.Message{&errorxpb.ErrorDetails{
-		Type: errorxpb.ErrorxType_CONFLICT,
+		Type:        errorxpb.ErrorxType_CONFLICT,
+		UserMessage: e.UserMsg,
 	}}
 }
 
 var _ Error = UnsupportedMediaType{}
 
 type UnsupportedMediaType struct {
-	Msg string
-	Err error
+	Msg     string
+	UserMsg string
+	Err     error
 }
 
 func (e UnsupportedMediaType) Error() string {
@@ -353,6 +456,10 @@ func (e UnsupportedMediaType) Message() string {
 	return e.Msg
 }
 
+func (e UnsupportedMediaType) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
 func (e UnsupportedMediaType) Unwrap() error {
 	return e.Err
 }
@@ -367,15 +474,22 @@ func (e UnsupportedMediaType) GRPCStatus() *grpcStatus.Status {
 
 func (e UnsupportedMediaType) GRPCStatusDetails() []protov1.Message {
 	return []protov1.Message{&errorxpb.ErrorDetails{
-		Type: errorxpb.ErrorxType_UNSUPPORTED_MEDIA_TYPE,
+		Type:        errorxpb.ErrorxType_UNSUPPORTED_MEDIA_TYPE,
+		UserMessage: e.UserMsg,
 	}}
 }
 
 var _ Error = TooManyRequests{}
 
 type TooManyRequests struct {
-	Msg string
-	Err error
+	Msg     string
+	UserMsg string
+	Err     error
+	// RetryAfter is the back-off hint given by the downstream, if any.
+	RetryAfter time.Duration
+	// Limit names the downstream limit that was hit, if known. For Mimir
+	// this is the error ID, eg. "err-mimir-tenant-max-ingestion-rate".
+	Limit string
 }
 
 func (e TooManyRequests) Error() string {
@@ -389,6 +503,10 @@ func (e TooManyRequests) Message() string {
 	return e.Msg
 }
 
+func (e TooManyRequests) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
 func (e TooManyRequests) Unwrap() error {
 	return e.Err
 }
@@ -403,15 +521,19 @@ func (e TooManyRequests) GRPCStatus() *grpcStatus.Status {
 
 func (e TooManyRequests) GRPCStatusDetails() []protov1.Message {
 	return []protov1.Message{&errorxpb.ErrorDetails{
-		Type: errorxpb.ErrorxType_TOO_MANY_REQUESTS,
+		Type:         errorxpb.ErrorxType_TOO_MANY_REQUESTS,
+		RetryAfterMs: e.RetryAfter.Milliseconds(),
+		Limit:        e.Limit,
+		UserMessage:  e.UserMsg,
 	}}
 }
 
 var _ Error = RequestTimeout{}
 
 type RequestTimeout struct {
-	Msg string
-	Err error
+	Msg     string
+	UserMsg string
+	Err     error
 }
 
 func (e RequestTimeout) Error() string {
@@ -425,6 +547,10 @@ func (e RequestTimeout) Message() string {
 	return e.Msg
 }
 
+func (e RequestTimeout) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
 func (e RequestTimeout) Unwrap() error {
 	return e.Err
 }
@@ -439,13 +565,429 @@ func (e RequestTimeout) GRPCStatus() *grpcStatus.Status {
 
 func (e RequestTimeout) GRPCStatusDetails() []protov1.Message {
 	return []protov1.Message{&errorxpb.ErrorDetails{
-		Type: errorxpb.ErrorxType_REQUEST_TIMEOUT,
+		Type:        errorxpb.ErrorxType_REQUEST_TIMEOUT,
+		UserMessage: e.UserMsg,
+	}}
+}
+
+var _ Error = Unavailable{}
+
+// Unavailable signifies a downstream dependency is temporarily unable to
+// serve the request (eg. a 502, 503 or 504 response) and the request may be
+// retried.
+type Unavailable struct {
+	Msg     string
+	UserMsg string
+	Err     error
+	// RetryAfter is the back-off hint given by the downstream, if any.
+	RetryAfter time.Duration
+}
+
+func (e Unavailable) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Msg, e.Err)
+	}
+	return e.Msg
+}
+
+func (e Unavailable) Message() string {
+	return e.Msg
+}
+
+func (e Unavailable) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
+func (e Unavailable) Unwrap() error {
+	return e.Err
+}
+
+func (e Unavailable) HTTPStatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e Unavailable) GRPCStatus() *grpcStatus.Status {
+	return WithErrorxTypeDetail(grpcStatus.New(codes.Unavailable, e.Error()), e.GRPCStatusDetails()...)
+}
+
+func (e Unavailable) GRPCStatusDetails() []protov1.Message {
+	return []protov1.Message{&errorxpb.ErrorDetails{
+		Type:         errorxpb.ErrorxType_UNAVAILABLE,
+		RetryAfterMs: e.RetryAfter.Milliseconds(),
+		UserMessage:  e.UserMsg,
+	}}
+}
+
+var _ Error = Validation{}
+
+// Validation signifies some fields of the request are invalid. Unlike
+// BadRequest, it reports every invalid field at once, so the client can fix
+// them all in one go.
+type Validation struct {
+	Msg        string
+	UserMsg    string
+	Violations []FieldViolation
+}
+
+// FieldViolation describes why a single request field is invalid. Field is a
+// path to the field, eg. "rules[2].pattern".
+type FieldViolation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e Validation) Error() string {
+	if len(e.Violations) == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s: %s", e.Msg, e.violations())
+}
+
+// violations lists the violations, as in the message of the error.
+func (e Validation) violations() string {
+	violations := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		violations = append(violations, v.Field+": "+v.Message)
+	}
+	return strings.Join(violations, "; ")
+}
+
+func (e Validation) Message() string {
+	return e.Msg
+}
+
+func (e Validation) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
+func (e Validation) HTTPStatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (e Validation) GRPCStatus() *grpcStatus.Status {
+	return WithErrorxTypeDetail(grpcStatus.New(codes.InvalidArgument, e.Error()), e.GRPCStatusDetails()...)
+}
+
+func (e Validation) GRPCStatusDetails() []protov1.Message {
+	violations := make([]*errorxpb.FieldViolation, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		violations = append(violations, &errorxpb.FieldViolation{Field: v.Field, Message: v.Message})
+	}
+	return []protov1.Message{&errorxpb.ErrorDetails{
+		Type:            errorxpb.ErrorxType_VALIDATION,
+		FieldViolations: violations,
+		UserMessage:     e.UserMsg,
+	}}
+}
+
+var _ Error = Unauthorized{}
+
+// Unauthorized signifies the request isn't authenticated, eg. because it has
+// no org ID.
+type Unauthorized struct {
+	Msg     string
+	UserMsg string
+	Err     error
+}
+
+func (e Unauthorized) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Msg, e.Err)
+	}
+	return e.Msg
+}
+
+func (e Unauthorized) Message() string {
+	return e.Msg
+}
+
+func (e Unauthorized) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
+func (e Unauthorized) Unwrap() error {
+	return e.Err
+}
+
+func (e Unauthorized) HTTPStatusCode() int {
+	return http.StatusUnauthorized
+}
+
+func (e Unauthorized) GRPCStatus() *grpcStatus.Status {
+	return WithErrorxTypeDetail(grpcStatus.New(codes.Unauthenticated, e.Error()), e.GRPCStatusDetails()...)
+}
+
+func (e Unauthorized) GRPCStatusDetails() []protov1.Message {
+	return []protov1.Message{&errorxpb.ErrorDetails{
+		Type:        errorxpb.ErrorxType_UNAUTHORIZED,
+		UserMessage: e.UserMsg,
+	}}
+}
+
+var _ Error = PartialData{}
+
+// PartialData signifies some of the data asked for couldn't be read, eg.
+// because some of Mimir's store-gateways are unavailable. Start and End, if
+// known, are the time range of the request affected, so callers can decide
+// between serving the data they have and failing.
+type PartialData struct {
+	Msg     string
+	UserMsg string
+	Err     error
+	Start   time.Time
+	End     time.Time
+}
+
+func (e PartialData) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Msg, e.Err)
+	}
+	return e.Msg
+}
+
+func (e PartialData) Message() string {
+	return e.Msg
+}
+
+func (e PartialData) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
+func (e PartialData) Unwrap() error {
+	return e.Err
+}
+
+func (e PartialData) HTTPStatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e PartialData) GRPCStatus() *grpcStatus.Status {
+	return WithErrorxTypeDetail(grpcStatus.New(codes.Unavailable, e.Error()), e.GRPCStatusDetails()...)
+}
+
+func (e PartialData) GRPCStatusDetails() []protov1.Message {
+	return []protov1.Message{&errorxpb.ErrorDetails{
+		Type:           errorxpb.ErrorxType_PARTIAL_DATA,
+		UserMessage:    e.UserMsg,
+		PartialStartMs: unixMilli(e.Start),
+		PartialEndMs:   unixMilli(e.End),
+	}}
+}
+
+var _ Error = Parse{}
+
+// Parse signifies the request, eg. its query, couldn't be parsed. Input is
+// what couldn't be parsed, and Position, if known, the 1-based position of
+// the syntax error in it, so clients can point at it. errors.As finds it as a
+// BadRequest.
+type Parse struct {
+	Msg      string
+	UserMsg  string
+	Err      error
+	Input    string
+	Position int
+}
+
+func (e Parse) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Msg, e.Err)
+	}
+	return e.Msg
+}
+
+func (e Parse) Message() string {
+	return e.Msg
+}
+
+func (e Parse) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
+func (e Parse) Unwrap() error {
+	return e.Err
+}
+
+// As makes errors.As find the error as a BadRequest.
+func (e Parse) As(target any) bool {
+	return asBadRequest(target, e.Msg, e.UserMsg, e.Err)
+}
+
+func (e Parse) HTTPStatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (e Parse) GRPCStatus() *grpcStatus.Status {
+	return WithErrorxTypeDetail(grpcStatus.New(codes.InvalidArgument, e.Error()), e.GRPCStatusDetails()...)
+}
+
+func (e Parse) GRPCStatusDetails() []protov1.Message {
+	return []protov1.Message{&errorxpb.ErrorDetails{
+		Type:          errorxpb.ErrorxType_PARSE,
+		UserMessage:   e.UserMsg,
+		ParseInput:    e.Input,
+		ParsePosition: int32(e.Position),
 	}}
 }
 
+var _ Error = LimitExceeded{}
+
+// LimitExceeded signifies the request is beyond one of the limits of the
+// tenant, eg. its max query range, rather than rate limited. Limit names the
+// limit, Value is the one of the request and Max the one the limit allows,
+// in the unit of the limit, so clients can fix the request. errors.As finds
+// it as a BadRequest.
+type LimitExceeded struct {
+	Msg     string
+	UserMsg string
+	Err     error
+	Limit   string
+	Value   int64
+	Max     int64
+}
+
+func (e LimitExceeded) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Msg, e.Err)
+	}
+	return e.Msg
+}
+
+func (e LimitExceeded) Message() string {
+	return e.Msg
+}
+
+func (e LimitExceeded) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
+func (e LimitExceeded) Unwrap() error {
+	return e.Err
+}
+
+// As makes errors.As find the error as a BadRequest.
+func (e LimitExceeded) As(target any) bool {
+	return asBadRequest(target, e.Msg, e.UserMsg, e.Err)
+}
+
+func (e LimitExceeded) HTTPStatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (e LimitExceeded) GRPCStatus() *grpcStatus.Status {
+	return WithErrorxTypeDetail(grpcStatus.New(codes.InvalidArgument, e.Error()), e.GRPCStatusDetails()...)
+}
+
+func (e LimitExceeded) GRPCStatusDetails() []protov1.Message {
+	return []protov1.Message{&errorxpb.ErrorDetails{
+		Type:        errorxpb.ErrorxType_LIMIT_EXCEEDED,
+		UserMessage: e.UserMsg,
+		Limit:       e.Limit,
+		LimitValue:  e.Value,
+		LimitMax:    e.Max,
+	}}
+}
+
+var _ Error = Unsupported{}
+
+// Unsupported signifies the request uses something this service doesn't
+// support and never will, eg. a query function, unlike Unimplemented.
+// Feature names it. errors.As finds it as a BadRequest.
+type Unsupported struct {
+	Msg     string
+	UserMsg string
+	Err     error
+	Feature string
+}
+
+func (e Unsupported) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Msg, e.Err)
+	}
+	return e.Msg
+}
+
+func (e Unsupported) Message() string {
+	return e.Msg
+}
+
+func (e Unsupported) UserMessage() string {
+	return userMessage(e.UserMsg, e.Msg)
+}
+
+func (e Unsupported) Unwrap() error {
+	return e.Err
+}
+
+// As makes errors.As find the error as a BadRequest.
+func (e Unsupported) As(target any) bool {
+	return asBadRequest(target, e.Msg, e.UserMsg, e.Err)
+}
+
+func (e Unsupported) HTTPStatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (e Unsupported) GRPCStatus() *grpcStatus.Status {
+	return WithErrorxTypeDetail(grpcStatus.New(codes.InvalidArgument, e.Error()), e.GRPCStatusDetails()...)
+}
+
+func (e Unsupported) GRPCStatusDetails() []protov1.Message {
+	return []protov1.Message{&errorxpb.ErrorDetails{
+		Type:        errorxpb.ErrorxType_UNSUPPORTED,
+		UserMessage: e.UserMsg,
+		Feature:     e.Feature,
+	}}
+}
+
+// asBadRequest sets target to a BadRequest of the given messages and error,
+// if it's a *BadRequest, for the BadRequest subtypes.
+func asBadRequest(target any, msg, userMsg string, err error) bool {
+	if t, ok := target.(*BadRequest); ok {
+		*t = BadRequest{Msg: msg, UserMsg: userMsg, Err: err}
+		return true
+	}
+	return false
+}
+
+// unixMilli returns the milliseconds since the epoch of t, or 0 if t is zero.
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// fromUnixMilli is the reverse of unixMilli.
+func fromUnixMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+func violationsFromDetails(d *errorxpb.ErrorDetails) []FieldViolation {
+	violations := make([]FieldViolation, 0, len(d.FieldViolations))
+	for _, v := range d.FieldViolations {
+		violations = append(violations, FieldViolation{Field: v.Field, Message: v.Message})
+	}
+	return violations
+}
+
+func retryAfterFromDetails(d *errorxpb.ErrorDetails) time.Duration {
+	return time.Duration(d.RetryAfterMs) * time.Millisecond
+}
+
 func TryUnwrap(err error) error {
 	if wrapped, ok := err.(interface{ Unwrap() error }); ok {
 		return wrapped.Unwrap()
 	}
 	return err
 }
+
+// userMessage returns userMsg, or msg when it's empty.
+func userMessage(userMsg, msg string) string {
+	if userMsg != "" {
+		return userMsg
+	}
+	return msg
+}
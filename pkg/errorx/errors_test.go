@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
+	protov1 "github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
 
@@ -75,6 +78,46 @@ func TestGRPCStatusRoundTrip(t *testing.T) {
 			err:     RequestTimeout{Msg: "client timeout"},
 			wantErr: RequestTimeout{Msg: "grpc DeadlineExceeded: client timeout"},
 		},
+		{
+			name:    "Unavailable",
+			err:     Unavailable{Msg: "try later", RetryAfter: 5 * time.Second},
+			wantErr: Unavailable{Msg: "grpc Unavailable: try later", RetryAfter: 5 * time.Second},
+		},
+		{
+			name:    "Validation",
+			err:     Validation{Msg: "invalid rule", Violations: []FieldViolation{{Field: "pattern", Message: "can't be empty"}}},
+			wantErr: Validation{Msg: "invalid rule"},
+		},
+		{
+			name:    "Unauthorized",
+			err:     Unauthorized{Msg: "no org ID"},
+			wantErr: Unauthorized{Msg: "grpc Unauthenticated: no org ID"},
+		},
+		{
+			name:    "PartialData",
+			err:     PartialData{Msg: "store-gateways unavailable", Start: time.UnixMilli(1000), End: time.UnixMilli(2000)},
+			wantErr: PartialData{Msg: "grpc Unavailable: store-gateways unavailable", Start: time.UnixMilli(1000), End: time.UnixMilli(2000)},
+		},
+		{
+			name:    "PartialData without range",
+			err:     PartialData{Msg: "store-gateways unavailable"},
+			wantErr: PartialData{Msg: "grpc Unavailable: store-gateways unavailable"},
+		},
+		{
+			name:    "Parse",
+			err:     Parse{Msg: "unexpected character", Input: "sum(up", Position: 7},
+			wantErr: Parse{Msg: "grpc InvalidArgument: unexpected character", Input: "sum(up", Position: 7},
+		},
+		{
+			name:    "LimitExceeded",
+			err:     LimitExceeded{Msg: "query range too long", Limit: "max-query-range", Value: 2000, Max: 1000},
+			wantErr: LimitExceeded{Msg: "grpc InvalidArgument: query range too long", Limit: "max-query-range", Value: 2000, Max: 1000},
+		},
+		{
+			name:    "Unsupported",
+			err:     Unsupported{Msg: "unsupported function", Feature: "holtWintersForecast"},
+			wantErr: Unsupported{Msg: "grpc InvalidArgument: unsupported function", Feature: "holtWintersForecast"},
+		},
 	}
 
 	for _, tc := range tests {
@@ -92,6 +135,91 @@ func TestGRPCStatusRoundTrip(t *testing.T) {
 	}
 }
 
+func TestGRPCStatusRoundTripRetryAfter(t *testing.T) {
+	err := TooManyRequests{Msg: "slow down", RetryAfter: 1500 * time.Millisecond, Limit: "err-mimir-tenant-max-request-rate"}
+
+	got := FromGRPCStatus(err.GRPCStatus())
+
+	var tooManyRequests TooManyRequests
+	require.ErrorAs(t, got, &tooManyRequests)
+	require.Equal(t, 1500*time.Millisecond, tooManyRequests.RetryAfter)
+	require.Equal(t, "err-mimir-tenant-max-request-rate", tooManyRequests.Limit)
+}
+
+func TestGRPCStatusRoundTripUserMessage(t *testing.T) {
+	err := Unavailable{Msg: "can't read bucket mimir-blocks-prod", UserMsg: "storage is unavailable, try again later"}
+	require.Equal(t, "can't read bucket mimir-blocks-prod", err.Message())
+	require.Equal(t, "storage is unavailable, try again later", err.UserMessage())
+
+	got := FromGRPCStatus(err.GRPCStatus())
+
+	var unavailable Unavailable
+	require.ErrorAs(t, got, &unavailable)
+	require.Equal(t, "grpc Unavailable: can't read bucket mimir-blocks-prod", unavailable.Message())
+	require.Equal(t, "storage is unavailable, try again later", unavailable.UserMessage())
+}
+
+// plainError implements Error, but not UserMessager, like the Errors
+// implemented outside of this package.
+type plainError struct{}
+
+func (plainError) Error() string                        { return "plain: details" }
+func (plainError) HTTPStatusCode() int                  { return http.StatusInternalServerError }
+func (plainError) Message() string                      { return "plain" }
+func (plainError) GRPCStatus() *grpcStatus.Status       { return grpcStatus.New(codes.Internal, "plain") }
+func (plainError) GRPCStatusDetails() []protov1.Message { return nil }
+
+func TestUserMessage(t *testing.T) {
+	err := Unavailable{Msg: "can't read bucket mimir-blocks-prod", UserMsg: "storage is unavailable, try again later"}
+	require.Equal(t, "storage is unavailable, try again later", UserMessage(err))
+	require.Equal(t, "can't read bucket mimir-blocks-prod", UserMessage(Unavailable{Msg: "can't read bucket mimir-blocks-prod"}))
+	require.Equal(t, "plain", UserMessage(plainError{}))
+}
+
+func TestGRPCStatusRoundTripValidation(t *testing.T) {
+	violations := []FieldViolation{
+		{Field: "rules[0].pattern", Message: "can't be empty"},
+		{Field: "rules[1].method", Message: "unknown method \"median\""},
+	}
+	err := Validation{Msg: "invalid rules", Violations: violations}
+	require.Equal(t, `invalid rules: rules[0].pattern: can't be empty; rules[1].method: unknown method "median"`, err.Error())
+
+	got := FromGRPCStatus(err.GRPCStatus())
+
+	var validation Validation
+	require.ErrorAs(t, got, &validation)
+	require.Equal(t, violations, validation.Violations)
+	require.Equal(t, "invalid rules", validation.Message())
+	require.Equal(t, err.Error(), validation.Error())
+
+	// The message of a wrapping error is kept.
+	got = FromGRPCStatus(ErrorAsGRPCStatus(fmt.Errorf("importing rules: %w", err)))
+	require.ErrorAs(t, got, &validation)
+	require.Equal(t, "importing rules: invalid rules", validation.Message())
+	require.Equal(t, violations, validation.Violations)
+}
+
+func TestBadRequestSubtypes(t *testing.T) {
+	wrapped := errors.New("wrapped")
+	for _, err := range []Error{
+		Parse{Msg: "can't parse", UserMsg: "invalid query", Err: wrapped, Input: "sum(up", Position: 7},
+		LimitExceeded{Msg: "too long", UserMsg: "invalid range", Err: wrapped, Limit: "max-query-range", Value: 2, Max: 1},
+		Unsupported{Msg: "unsupported", UserMsg: "unsupported function", Err: wrapped, Feature: "holtWintersForecast"},
+	} {
+		t.Run(err.Error(), func(t *testing.T) {
+			var badRequest BadRequest
+			require.ErrorAs(t, fmt.Errorf("reading: %w", err), &badRequest)
+			require.Equal(t, err.Message(), badRequest.Message())
+			require.Equal(t, UserMessage(err), badRequest.UserMessage())
+			require.ErrorIs(t, badRequest, wrapped)
+			require.Equal(t, http.StatusBadRequest, err.HTTPStatusCode())
+
+			// The subtypes aren't found as the other ones.
+			require.False(t, errors.As(err, &Validation{}))
+		})
+	}
+}
+
 func TestFromGRPCStatusErrors(t *testing.T) {
 	tests := []struct {
 		name    string
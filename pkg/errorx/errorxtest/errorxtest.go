@@ -0,0 +1,101 @@
+// Package errorxtest provides test helpers checking that errorx errors keep
+// their type, status codes and details across the gRPC and HTTP boundaries.
+package errorxtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/errorxpb"
+)
+
+// AssertRoundTrip fails the test unless err converts back to an error of the
+// same Go type, HTTP status, gRPC code and details:
+//
+//   - from its gRPC status, and from the one of an error wrapping it,
+//   - from the protobuf and JSON HTTP error bodies LogAndSetHTTPError writes,
+//     except for the proxy reason, which the JSON bodies don't carry.
+//
+// The default HTTP error bodies don't name the errorx type, so only their
+// status code is checked to convert back to an error with that status.
+func AssertRoundTrip(t testing.TB, err errorx.Error) {
+	t.Helper()
+	want := details(t, err)
+
+	assertSameError(t, "gRPC status", err, errorx.FromGRPCStatus(err.GRPCStatus()), want)
+	wrapped := fmt.Errorf("wrapped: %w", err)
+	assertSameError(t, "wrapped gRPC status", err, errorx.FromGRPCStatus(errorx.ErrorAsGRPCStatus(wrapped)), want)
+
+	// The HTTP error bodies have the user message, rather than the one of
+	// the details, so only the user messages are compared.
+	got := fromHTTPResponse(t, errorx.ContextWithResponseFormat(context.Background(), errorx.ResponseFormatProtobuf), err)
+	require.Equal(t, errorx.UserMessage(err), errorx.UserMessage(got), "protobuf HTTP response: user message")
+	assertSameError(t, "protobuf HTTP response", err, got, withoutUserMessage(want), withoutUserMessage)
+
+	got = fromHTTPResponse(t, errorx.ContextWithResponseFormat(context.Background(), errorx.ResponseFormatJSON), err)
+	require.Equal(t, errorx.UserMessage(err), errorx.UserMessage(got), "JSON HTTP response: user message")
+	withoutReason := func(d *errorxpb.ErrorDetails) *errorxpb.ErrorDetails {
+		d = withoutUserMessage(d)
+		d.Reason = ""
+		return d
+	}
+	assertSameError(t, "JSON HTTP response", err, got, withoutReason(want), withoutReason)
+
+	got = fromHTTPResponse(t, context.Background(), err)
+	require.Equal(t, err.HTTPStatusCode(), got.HTTPStatusCode(), "plain HTTP response: %T converted back to %T", err, got)
+}
+
+// assertSameError checks got is an error of the type, status codes and
+// details of want, after applying normalize to the details of got.
+func assertSameError(t testing.TB, via string, want errorx.Error, got error, wantDetails *errorxpb.ErrorDetails, normalize ...func(*errorxpb.ErrorDetails) *errorxpb.ErrorDetails) {
+	t.Helper()
+	var gotErrx errorx.Error
+	require.True(t, errors.As(got, &gotErrx), "%s: %v isn't an errorx.Error", via, got)
+	require.Equal(t, reflect.TypeOf(want), reflect.TypeOf(gotErrx), "%s: Go type", via)
+	require.Equal(t, want.HTTPStatusCode(), gotErrx.HTTPStatusCode(), "%s: HTTP status", via)
+	require.Equal(t, want.GRPCStatus().Code(), gotErrx.GRPCStatus().Code(), "%s: gRPC code", via)
+
+	gotDetails := details(t, gotErrx)
+	for _, n := range normalize {
+		gotDetails = n(gotDetails)
+	}
+	require.True(t, proto.Equal(wantDetails, gotDetails), "%s: details: want %v, got %v", via, wantDetails, gotDetails)
+}
+
+// fromHTTPResponse writes err with LogAndSetHTTPError, in the format set in
+// ctx if any, and converts the response back with FromHTTPResponse.
+func fromHTTPResponse(t testing.TB, ctx context.Context, err errorx.Error) errorx.Error {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	errorx.LogAndSetHTTPError(ctx, recorder, log.NewNopLogger(), err)
+	resp := recorder.Result()
+	require.Equal(t, err.HTTPStatusCode(), resp.StatusCode, "HTTP status written")
+	return errorx.FromHTTPResponse(resp, recorder.Body.String(), "http response", nil)
+}
+
+// details returns a copy of the errorxpb.ErrorDetails of err.
+func details(t testing.TB, err errorx.Error) *errorxpb.ErrorDetails {
+	t.Helper()
+	for _, d := range err.GRPCStatusDetails() {
+		if d, ok := d.(*errorxpb.ErrorDetails); ok {
+			return proto.Clone(d).(*errorxpb.ErrorDetails)
+		}
+	}
+	require.Fail(t, "no errorxpb.ErrorDetails", "%T has no errorxpb.ErrorDetails in its gRPC status details", err)
+	return nil
+}
+
+func withoutUserMessage(d *errorxpb.ErrorDetails) *errorxpb.ErrorDetails {
+	d = proto.Clone(d).(*errorxpb.ErrorDetails)
+	d.UserMessage = ""
+	return d
+}
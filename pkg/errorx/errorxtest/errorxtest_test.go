@@ -0,0 +1,64 @@
+package errorxtest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/errorxpb"
+)
+
+// TestAssertRoundTrip is the conversion matrix of the errorx types: each of
+// them must convert between its Go type, HTTP status and gRPC status.
+func TestAssertRoundTrip(t *testing.T) {
+	wrapped := errors.New("wrapped")
+	for _, err := range []errorx.Error{
+		errorx.Internal{Msg: "internal", Err: wrapped},
+		errorx.Internal{Msg: "can't read bucket mimir-blocks-prod", UserMsg: "storage error"},
+		errorx.BadRequest{Msg: "bad request", Err: wrapped},
+		errorx.RequiresProxyRequest{Msg: "requires proxy request", Reason: "unsupported_function"},
+		errorx.Disabled{},
+		errorx.Unimplemented{Msg: "unimplemented"},
+		errorx.UnprocessableEntity{Msg: "unprocessable"},
+		errorx.Conflict{Msg: "conflict", UserMsg: "already exists"},
+		errorx.UnsupportedMediaType{Msg: "unsupported media type"},
+		errorx.TooManyRequests{Msg: "too many requests", RetryAfter: 1500 * time.Millisecond, Limit: "err-mimir-tenant-max-request-rate"},
+		errorx.RequestTimeout{Msg: "request timeout"},
+		errorx.Unavailable{Msg: "unavailable", RetryAfter: 5 * time.Second},
+		errorx.Validation{Msg: "invalid rules", Violations: []errorx.FieldViolation{{Field: "rules[0].pattern", Message: "can't be empty"}}},
+		errorx.Unauthorized{Msg: "no org ID"},
+		errorx.PartialData{Msg: "store-gateways unavailable", Start: time.UnixMilli(1000), End: time.UnixMilli(2000)},
+		errorx.Parse{Msg: "unexpected end of input", Input: "sumSeries(a.b", Position: 14},
+		errorx.LimitExceeded{Msg: "too many series", Limit: "max-series", Value: 20000, Max: 10000},
+		errorx.Unsupported{Msg: "unsupported function", Feature: "holtWintersForecast"},
+	} {
+		t.Run(err.Error(), func(t *testing.T) {
+			AssertRoundTrip(t, err)
+		})
+	}
+}
+
+// TestAssertRoundTrip_AllTypes makes sure the conversion matrix is extended
+// along with the errorxpb types.
+func TestAssertRoundTrip_AllTypes(t *testing.T) {
+	covered := map[errorxpb.ErrorxType]bool{
+		// UNKNOWN is never sent, and RATE_LIMITED is only read, as
+		// TooManyRequests, for compatibility.
+		errorxpb.ErrorxType_UNKNOWN:      true,
+		errorxpb.ErrorxType_RATE_LIMITED: true,
+	}
+	for _, err := range []errorx.Error{
+		errorx.Internal{}, errorx.BadRequest{}, errorx.RequiresProxyRequest{}, errorx.Disabled{},
+		errorx.Unimplemented{}, errorx.UnprocessableEntity{}, errorx.Conflict{}, errorx.UnsupportedMediaType{},
+		errorx.TooManyRequests{}, errorx.RequestTimeout{}, errorx.Unavailable{}, errorx.Validation{},
+		errorx.Unauthorized{}, errorx.PartialData{}, errorx.Parse{}, errorx.LimitExceeded{}, errorx.Unsupported{},
+	} {
+		covered[details(t, err).Type] = true
+	}
+	for value, name := range errorxpb.ErrorxType_name {
+		require.True(t, covered[errorxpb.ErrorxType(value)], "no errorx type with the %s errorxpb type in the conversion matrix", name)
+	}
+}
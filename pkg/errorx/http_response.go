@@ -0,0 +1,129 @@
+package errorx
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorxpb"
+)
+
+// mimirErrorIDRegexp matches the error IDs Mimir embeds in its error messages,
+// eg. "(err-mimir-tenant-max-ingestion-rate)".
+var mimirErrorIDRegexp = regexp.MustCompile(`err-mimir-[a-z0-9-]+`)
+
+// FromHTTPResponse translates a non-2xx downstream response into the errorx
+// type matching its status code. body should be the (possibly truncated)
+// response body, which is used to find the downstream limit name. Statuses
+// without a dedicated type are returned as Internal errors.
+//
+// Error bodies written by LogAndSetHTTPError in the JSON or protobuf format
+// name their errorx type, which is used instead of the status code, so the
+// errors of another mimir-graphite convert back to the same type.
+func FromHTTPResponse(resp *http.Response, body, msg string, err error) Error {
+	if d, ok := detailsFromHTTPBody(resp.Header, body); ok {
+		return fromDetails(d, msg, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadRequest:
+		return BadRequest{Msg: msg, Err: err}
+	case http.StatusUnauthorized:
+		return Unauthorized{Msg: msg, Err: err}
+	case http.StatusRequestTimeout:
+		return RequestTimeout{Msg: msg, Err: err}
+	case http.StatusConflict:
+		return Conflict{Msg: msg, Err: err}
+	case http.StatusUnsupportedMediaType:
+		return UnsupportedMediaType{Msg: msg, Err: err}
+	case http.StatusUnprocessableEntity:
+		return UnprocessableEntity{Msg: msg}
+	case http.StatusTooManyRequests:
+		return TooManyRequests{
+			Msg:        msg,
+			Err:        err,
+			RetryAfter: ParseRetryAfter(resp.Header, time.Now()),
+			Limit:      ParseMimirErrorID(body),
+		}
+	case http.StatusNotImplemented:
+		return Unimplemented{Msg: msg}
+	}
+	if IsUnavailableStatus(resp.StatusCode) {
+		return Unavailable{Msg: msg, Err: err, RetryAfter: ParseRetryAfter(resp.Header, time.Now())}
+	}
+	return Internal{Msg: msg, Err: err}
+}
+
+// detailsFromHTTPBody returns the ErrorDetails of an error body written in
+// the JSON or protobuf format, and false if body isn't one.
+func detailsFromHTTPBody(h http.Header, body string) (*errorxpb.ErrorDetails, bool) {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return nil, false
+	}
+	switch mediaType {
+	case contentTypeJSON:
+		var e jsonError
+		if err := json.Unmarshal([]byte(body), &e); err != nil {
+			return nil, false
+		}
+		return e.details()
+	case contentTypeProtobuf:
+		var s spb.Status
+		if err := proto.Unmarshal([]byte(body), &s); err != nil {
+			return nil, false
+		}
+		status := grpcStatus.FromProto(&s)
+		for _, di := range status.Details() {
+			if d, ok := di.(*errorxpb.ErrorDetails); ok {
+				// The message of the status is the user message.
+				if d.UserMessage == "" {
+					d.UserMessage = status.Message()
+				}
+				return d, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// IsUnavailableStatus reports whether the given HTTP status code means the
+// downstream is temporarily unavailable and the request can be retried.
+func IsUnavailableStatus(code int) bool {
+	return code == http.StatusBadGateway ||
+		code == http.StatusServiceUnavailable ||
+		code == http.StatusGatewayTimeout
+}
+
+// ParseRetryAfter reads the Retry-After header, which can either be a number
+// of seconds or an HTTP date. It returns zero if the header is missing, can't
+// be parsed or is in the past.
+func ParseRetryAfter(h http.Header, now time.Time) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// ParseMimirErrorID returns the first Mimir error ID found in the given
+// message, or an empty string if there is none.
+func ParseMimirErrorID(msg string) string {
+	return mimirErrorIDRegexp.FindString(msg)
+}
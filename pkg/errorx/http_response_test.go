@@ -0,0 +1,97 @@
+package errorx
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromHTTPResponse(t *testing.T) {
+	downstreamErr := errors.New("downstream said no")
+
+	for name, tc := range map[string]struct {
+		status  int
+		header  http.Header
+		body    string
+		wantErr Error
+	}{
+		"bad request": {
+			status:  http.StatusBadRequest,
+			wantErr: BadRequest{Msg: "msg", Err: downstreamErr},
+		},
+		"too many requests with retry after and limit": {
+			status:  http.StatusTooManyRequests,
+			header:  http.Header{"Retry-After": []string{"7"}},
+			body:    "the request has been rejected because the tenant exceeded the ingestion rate limit (err-mimir-tenant-max-ingestion-rate)",
+			wantErr: TooManyRequests{Msg: "msg", Err: downstreamErr, RetryAfter: 7 * time.Second, Limit: "err-mimir-tenant-max-ingestion-rate"},
+		},
+		"service unavailable": {
+			status:  http.StatusServiceUnavailable,
+			header:  http.Header{"Retry-After": []string{"1"}},
+			wantErr: Unavailable{Msg: "msg", Err: downstreamErr, RetryAfter: time.Second},
+		},
+		"bad gateway": {
+			status:  http.StatusBadGateway,
+			wantErr: Unavailable{Msg: "msg", Err: downstreamErr},
+		},
+		"unauthorized": {
+			status:  http.StatusUnauthorized,
+			wantErr: Unauthorized{Msg: "msg", Err: downstreamErr},
+		},
+		"JSON body naming the errorx type": {
+			status:  http.StatusServiceUnavailable,
+			header:  http.Header{"Content-Type": []string{"application/json"}},
+			body:    `{"message": "some data is missing", "type": "PARTIAL_DATA", "partial_start_ms": 1000, "partial_end_ms": 2000}`,
+			wantErr: PartialData{Msg: "msg", UserMsg: "some data is missing", Err: downstreamErr, Start: time.UnixMilli(1000), End: time.UnixMilli(2000)},
+		},
+		"JSON body without errorx type": {
+			status:  http.StatusBadRequest,
+			header:  http.Header{"Content-Type": []string{"application/json"}},
+			body:    `{"status": "error", "errorType": "bad_data", "error": "invalid query"}`,
+			wantErr: BadRequest{Msg: "msg", Err: downstreamErr},
+		},
+		"not implemented": {
+			status:  http.StatusNotImplemented,
+			wantErr: Unimplemented{Msg: "msg"},
+		},
+		"other 5xx is internal": {
+			status:  http.StatusInternalServerError,
+			wantErr: Internal{Msg: "msg", Err: downstreamErr},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tc.status, Header: tc.header}
+			if resp.Header == nil {
+				resp.Header = http.Header{}
+			}
+			require.Equal(t, tc.wantErr, FromHTTPResponse(resp, tc.body, "msg", downstreamErr))
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, tc := range map[string]struct {
+		value string
+		want  time.Duration
+	}{
+		"missing":     {value: "", want: 0},
+		"seconds":     {value: "120", want: 2 * time.Minute},
+		"negative":    {value: "-1", want: 0},
+		"http date":   {value: now.Add(30 * time.Second).Format(http.TimeFormat), want: 30 * time.Second},
+		"past date":   {value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0},
+		"unparseable": {value: "soon", want: 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := http.Header{}
+			if tc.value != "" {
+				h.Set("Retry-After", tc.value)
+			}
+			require.Equal(t, tc.want, ParseRetryAfter(h, now))
+		})
+	}
+}
@@ -2,11 +2,17 @@ package errorx
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	opentracing "github.com/opentracing/opentracing-go"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"google.golang.org/grpc/codes"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorxpb"
 )
 
 const (
@@ -15,32 +21,82 @@ const (
 
 // LogAndSetHTTPError logs the provided error and then translates the internal error into a http response.
 // The error message set in the response is conservative in an attempt to prevent internal details (e.g. GCS bucket
-// name) from leaking. If the error is from this errorx package, the top-level message is logged. Otherwise, hardcoded
-// messages are returned
+// name) from leaking. If the error is from this errorx package, the top-level message and the wrapped error are
+// logged, and its UserMessage is returned. Otherwise, hardcoded messages are returned. The body is written in the format set with
+// ContextWithResponseFormat, if any.
 func LogAndSetHTTPError(ctx context.Context, w http.ResponseWriter, log log.Logger, err error) {
 	code := http.StatusInternalServerError
+	grpcCode := codes.Unknown
 	message := "unknown error"
 
 	var errx Error
 	if errors.Is(err, context.Canceled) {
 		code = httpStatusCanceled
+		grpcCode = codes.Canceled
 		_ = level.Error(log).Log("msg", "canceled", "response_code", code, "err", err)
 		message = "request canceled"
 	} else if errors.As(err, &errx) {
 		switch code = errx.HTTPStatusCode(); code {
-		case http.StatusBadRequest:
+		case http.StatusBadRequest, http.StatusUnauthorized:
 			_ = level.Warn(log).Log("msg", errx.Message(), "response_code", code, "err", tryUnwrap(errx))
 		default:
 			_ = level.Error(log).Log("msg", errx.Message(), "response_code", code, "err", tryUnwrap(errx))
 		}
-		message = errx.Message()
+		message = UserMessage(errx)
+		grpcCode = errx.GRPCStatus().Code()
 	} else {
 		_ = level.Error(log).Log("msg", "unknown error", "response_code", code, "err", err)
 	}
+	if stack := StackTrace(err); stack != "" {
+		_ = level.Debug(log).Log("msg", "error stack", "err", err, "stack", stack)
+		if span := opentracing.SpanFromContext(ctx); span != nil {
+			span.LogFields(otlog.String("event", "error"), otlog.String("message", err.Error()), otlog.String("stack", stack))
+		}
+	}
+
+	if format := responseFormatFromContext(ctx); format != formatDefault {
+		var details *errorxpb.ErrorDetails
+		if errx != nil {
+			for _, d := range errx.GRPCStatusDetails() {
+				if d, ok := d.(*errorxpb.ErrorDetails); ok {
+					details = d
+				}
+			}
+		}
+		writeNegotiatedError(w, log, format, code, grpcCode, message, details)
+		return
+	}
+
+	var validation Validation
+	if errors.As(err, &validation) {
+		writeValidationError(w, log, validation)
+		return
+	}
 
 	http.Error(w, message, code)
 }
 
+// writeValidationError writes a Validation error as JSON, so clients can tell
+// which fields are invalid:
+//
+//	{"message": "invalid rules", "violations": [{"field": "rules[0].pattern", "message": "can't be empty"}]}
+func writeValidationError(w http.ResponseWriter, log log.Logger, err Validation) {
+	body := struct {
+		Message    string           `json:"message"`
+		Violations []FieldViolation `json:"violations"`
+	}{Message: err.UserMessage(), Violations: err.Violations}
+	if body.Violations == nil {
+		body.Violations = []FieldViolation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(err.HTTPStatusCode())
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		_ = level.Warn(log).Log("msg", "can't write validation error", "err", err)
+	}
+}
+
 func tryUnwrap(err error) error {
 	if wrapped, ok := err.(interface{ Unwrap() error }); ok {
 		return wrapped.Unwrap()
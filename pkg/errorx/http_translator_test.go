@@ -3,6 +3,7 @@ package errorx
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -67,6 +68,11 @@ func TestLogAndSetHttpError(t *testing.T) {
 			err:          RequestTimeout{},
 			expectedCode: http.StatusRequestTimeout,
 		},
+		"user message": {
+			err:             Internal{Msg: "can't read bucket mimir-blocks-prod", UserMsg: "storage error", Err: errors.New("403 forbidden")},
+			expectedCode:    http.StatusInternalServerError,
+			expectedMessage: "storage error",
+		},
 	} {
 		logger := log.NewNopLogger()
 		recorder := httptest.NewRecorder()
@@ -79,3 +85,26 @@ func TestLogAndSetHttpError(t *testing.T) {
 		assert.Equal(t, tc.expectedMessage+"\n", string(retrievedBody))
 	}
 }
+
+func TestLogAndSetHttpError_Validation(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	err := fmt.Errorf("importing config: %w", Validation{
+		Msg: "invalid rules",
+		Violations: []FieldViolation{
+			{Field: "rules[0].pattern", Message: "can't be empty"},
+			{Field: "rules[1].method", Message: "unknown method"},
+		},
+	})
+
+	LogAndSetHTTPError(context.TODO(), recorder, log.NewNopLogger(), err)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"message": "invalid rules",
+		"violations": [
+			{"field": "rules[0].pattern", "message": "can't be empty"},
+			{"field": "rules[1].method", "message": "unknown method"}
+		]
+	}`, recorder.Body.String())
+}
@@ -0,0 +1,56 @@
+package errorx
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorxpb"
+)
+
+// ClassificationLabels are the labels of the CounterVec passed to
+// CountError. Services should create their error counters with them, so the
+// error-budget dashboards can aggregate errors across services.
+var ClassificationLabels = []string{"type", "retryable", "source"}
+
+// CountError increments counter, which must have the ClassificationLabels,
+// for err, unless it's nil. source names where the error comes from, eg. the
+// downstream called.
+func CountError(counter *prometheus.CounterVec, source string, err error) {
+	if err == nil {
+		return
+	}
+	typ, retryable := Classify(err)
+	counter.WithLabelValues(typ, strconv.FormatBool(retryable), source).Inc()
+}
+
+// Classify returns the type of err, as the lower case name of its errorxpb
+// type, and whether the request failing with it may be retried. Errors that
+// aren't from this package are "canceled", "deadline_exceeded" or "unknown".
+func Classify(err error) (typ string, retryable bool) {
+	var errx Error
+	switch {
+	case errors.As(err, &errx):
+		typ = strings.ToLower(errorxpb.ErrorxType_UNKNOWN.String())
+		for _, d := range errx.GRPCStatusDetails() {
+			if d, ok := d.(*errorxpb.ErrorDetails); ok {
+				typ = strings.ToLower(d.Type.String())
+				break
+			}
+		}
+		switch errx.(type) {
+		case TooManyRequests, Unavailable, RequestTimeout, PartialData:
+			retryable = true
+		}
+		return typ, retryable
+	case errors.Is(err, context.Canceled):
+		return "canceled", false
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded", true
+	default:
+		return "unknown", false
+	}
+}
@@ -0,0 +1,63 @@
+package errorx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		err           error
+		wantType      string
+		wantRetryable bool
+	}{
+		{err: BadRequest{Msg: "bad"}, wantType: "bad_request"},
+		{err: Internal{Msg: "oops"}, wantType: "internal"},
+		{err: Disabled{}, wantType: "disabled"},
+		{err: Validation{Msg: "invalid"}, wantType: "validation"},
+		{err: Unauthorized{Msg: "no org ID"}, wantType: "unauthorized"},
+		{err: TooManyRequests{Msg: "slow down"}, wantType: "too_many_requests", wantRetryable: true},
+		{err: Unavailable{Msg: "down"}, wantType: "unavailable", wantRetryable: true},
+		{err: RequestTimeout{Msg: "timeout"}, wantType: "request_timeout", wantRetryable: true},
+		{err: PartialData{Msg: "partial"}, wantType: "partial_data", wantRetryable: true},
+		{err: Parse{Msg: "can't parse"}, wantType: "parse"},
+		{err: LimitExceeded{Msg: "too long"}, wantType: "limit_exceeded"},
+		{err: Unsupported{Msg: "unsupported"}, wantType: "unsupported"},
+		{err: fmt.Errorf("writing: %w", Unavailable{Msg: "down"}), wantType: "unavailable", wantRetryable: true},
+		{err: fmt.Errorf("writing: %w", context.Canceled), wantType: "canceled"},
+		{err: context.DeadlineExceeded, wantType: "deadline_exceeded", wantRetryable: true},
+		{err: errors.New("boom"), wantType: "unknown"},
+	} {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			typ, retryable := Classify(tc.err)
+			require.Equal(t, tc.wantType, typ)
+			require.Equal(t, tc.wantRetryable, retryable)
+		})
+	}
+}
+
+func TestCountError(t *testing.T) {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "errors_total",
+		Help: "Errors.",
+	}, ClassificationLabels)
+
+	CountError(counter, "mimir", nil)
+	CountError(counter, "mimir", TooManyRequests{Msg: "slow down"})
+	CountError(counter, "mimir", TooManyRequests{Msg: "slow down"})
+	CountError(counter, "graphite", BadRequest{Msg: "bad"})
+
+	require.NoError(t, testutil.CollectAndCompare(counter, strings.NewReader(`
+# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total{retryable="false",source="graphite",type="bad_request"} 1
+errors_total{retryable="true",source="mimir",type="too_many_requests"} 2
+`)))
+}
@@ -0,0 +1,191 @@
+package errorx
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorxpb"
+)
+
+// ResponseFormat is the format of the error bodies written by
+// LogAndSetHTTPError.
+type ResponseFormat int
+
+const (
+	// formatDefault keeps the plain text error bodies, and JSON for
+	// Validation errors, of the requests nobody negotiated a format for.
+	formatDefault ResponseFormat = iota
+	ResponseFormatJSON
+	ResponseFormatText
+	// ResponseFormatProtobuf writes the error as a google.rpc.Status with
+	// the errorxpb.ErrorDetails in its details, the way gRPC-gateway does,
+	// so clients can convert it back with FromGRPCStatus.
+	ResponseFormatProtobuf
+)
+
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeText     = "text/plain"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+type responseFormatKey struct{}
+
+// ContextWithResponseFormat returns a context making LogAndSetHTTPError write
+// the error bodies in the given format.
+func ContextWithResponseFormat(ctx context.Context, format ResponseFormat) context.Context {
+	return context.WithValue(ctx, responseFormatKey{}, format)
+}
+
+func responseFormatFromContext(ctx context.Context) ResponseFormat {
+	if ctx == nil {
+		return formatDefault
+	}
+	format, _ := ctx.Value(responseFormatKey{}).(ResponseFormat)
+	return format
+}
+
+// NegotiateResponseFormat returns the error body format preferred by the
+// given Accept header. JSON is preferred on ties, and is used when the header
+// is empty or accepts none of the formats.
+func NegotiateResponseFormat(accept string) ResponseFormat {
+	best, bestQ := ResponseFormatJSON, 0.0
+	for _, r := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(r))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var format ResponseFormat
+		switch mediaType {
+		case contentTypeJSON, "application/*", "*/*":
+			format = ResponseFormatJSON
+		case contentTypeProtobuf:
+			format = ResponseFormatProtobuf
+		case contentTypeText, "text/*":
+			format = ResponseFormatText
+		default:
+			continue
+		}
+		if q > bestQ || (q == bestQ && format == ResponseFormatJSON) {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// jsonError is the JSON error body:
+//
+//	{"message": "too many requests", "type": "TOO_MANY_REQUESTS", "retry_after_ms": 1000, "limit": "err-mimir-tenant-max-ingestion-rate"}
+type jsonError struct {
+	Message        string           `json:"message"`
+	Type           string           `json:"type"`
+	RetryAfterMs   int64            `json:"retry_after_ms,omitempty"`
+	Limit          string           `json:"limit,omitempty"`
+	Violations     []FieldViolation `json:"violations,omitempty"`
+	PartialStartMs int64            `json:"partial_start_ms,omitempty"`
+	PartialEndMs   int64            `json:"partial_end_ms,omitempty"`
+	ParseInput     string           `json:"parse_input,omitempty"`
+	ParsePosition  int32            `json:"parse_position,omitempty"`
+	LimitValue     int64            `json:"limit_value,omitempty"`
+	LimitMax       int64            `json:"limit_max,omitempty"`
+	Feature        string           `json:"feature,omitempty"`
+}
+
+// details returns the ErrorDetails of the JSON error body, and false if its
+// type isn't an errorx one.
+func (e jsonError) details() (*errorxpb.ErrorDetails, bool) {
+	t, ok := errorxpb.ErrorxType_value[e.Type]
+	if !ok || t == int32(errorxpb.ErrorxType_UNKNOWN) {
+		return nil, false
+	}
+	d := &errorxpb.ErrorDetails{
+		Type:           errorxpb.ErrorxType(t),
+		RetryAfterMs:   e.RetryAfterMs,
+		Limit:          e.Limit,
+		UserMessage:    e.Message,
+		PartialStartMs: e.PartialStartMs,
+		PartialEndMs:   e.PartialEndMs,
+		ParseInput:     e.ParseInput,
+		ParsePosition:  e.ParsePosition,
+		LimitValue:     e.LimitValue,
+		LimitMax:       e.LimitMax,
+		Feature:        e.Feature,
+	}
+	for _, v := range e.Violations {
+		d.FieldViolations = append(d.FieldViolations, &errorxpb.FieldViolation{Field: v.Field, Message: v.Message})
+	}
+	return d, true
+}
+
+// writeNegotiatedError writes the error in the format negotiated for the
+// request. details are the ones of the errorx error, if any.
+func writeNegotiatedError(w http.ResponseWriter, log log.Logger, format ResponseFormat, code int, grpcCode codes.Code, message string, details *errorxpb.ErrorDetails) {
+	if details == nil {
+		details = &errorxpb.ErrorDetails{}
+	}
+
+	var (
+		contentType string
+		body        []byte
+		err         error
+	)
+	switch format {
+	case ResponseFormatProtobuf:
+		contentType = contentTypeProtobuf
+		body, err = proto.Marshal(WithErrorxTypeDetail(grpcStatus.New(grpcCode, message), details).Proto())
+	case ResponseFormatText:
+		contentType = contentTypeText + "; charset=utf-8"
+		var sb strings.Builder
+		sb.WriteString(message)
+		for _, v := range details.FieldViolations {
+			sb.WriteString("\n" + v.Field + ": " + v.Message)
+		}
+		sb.WriteString("\n")
+		body = []byte(sb.String())
+	default:
+		contentType = contentTypeJSON
+		body, err = json.Marshal(jsonError{
+			Message:      message,
+			Type:         details.Type.String(),
+			RetryAfterMs: details.RetryAfterMs,
+			Limit:        details.Limit,
+			Violations:   violationsFromDetails(details),
+
+			PartialStartMs: details.PartialStartMs,
+			PartialEndMs:   details.PartialEndMs,
+			ParseInput:     details.ParseInput,
+			ParsePosition:  details.ParsePosition,
+			LimitValue:     details.LimitValue,
+			LimitMax:       details.LimitMax,
+			Feature:        details.Feature,
+		})
+	}
+	if err != nil {
+		_ = level.Warn(log).Log("msg", "can't encode error", "err", err)
+		http.Error(w, message, code)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	if _, err := w.Write(body); err != nil {
+		_ = level.Warn(log).Log("msg", "can't write error", "err", err)
+	}
+}
@@ -0,0 +1,107 @@
+package errorx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNegotiateResponseFormat(t *testing.T) {
+	for accept, expected := range map[string]ResponseFormat{
+		"":                                      ResponseFormatJSON,
+		"*/*":                                   ResponseFormatJSON,
+		"application/json":                      ResponseFormatJSON,
+		"text/html":                             ResponseFormatJSON,
+		"text/plain":                            ResponseFormatText,
+		"text/html, text/*;q=0.5":               ResponseFormatText,
+		"application/x-protobuf":                ResponseFormatProtobuf,
+		"application/json;q=0.5, text/plain":    ResponseFormatText,
+		"application/x-protobuf, */*;q=0.1":     ResponseFormatProtobuf,
+		"text/plain, application/json":          ResponseFormatJSON,
+		"application/x-protobuf;q=invalid, */*": ResponseFormatJSON,
+	} {
+		assert.Equal(t, expected, NegotiateResponseFormat(accept), accept)
+	}
+}
+
+func TestLogAndSetHTTPError_Negotiated(t *testing.T) {
+	err := TooManyRequests{Msg: "too many requests", RetryAfter: time.Second, Limit: "err-mimir-tenant-max-ingestion-rate"}
+
+	t.Run("json", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		LogAndSetHTTPError(ContextWithResponseFormat(context.Background(), ResponseFormatJSON), recorder, log.NewNopLogger(), err)
+
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{
+			"message": "too many requests",
+			"type": "TOO_MANY_REQUESTS",
+			"retry_after_ms": 1000,
+			"limit": "err-mimir-tenant-max-ingestion-rate"
+		}`, recorder.Body.String())
+	})
+
+	t.Run("json limit exceeded", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		LogAndSetHTTPError(ContextWithResponseFormat(context.Background(), ResponseFormatJSON), recorder, log.NewNopLogger(), LimitExceeded{
+			Msg:   "the query range of 2d is beyond the max of 1d",
+			Limit: "max-query-range",
+			Value: 172800000,
+			Max:   86400000,
+		})
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.JSONEq(t, `{
+			"message": "the query range of 2d is beyond the max of 1d",
+			"type": "LIMIT_EXCEEDED",
+			"limit": "max-query-range",
+			"limit_value": 172800000,
+			"limit_max": 86400000
+		}`, recorder.Body.String())
+	})
+
+	t.Run("json unknown error", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		LogAndSetHTTPError(ContextWithResponseFormat(context.Background(), ResponseFormatJSON), recorder, log.NewNopLogger(), context.DeadlineExceeded)
+
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.JSONEq(t, `{"message": "unknown error", "type": "UNKNOWN"}`, recorder.Body.String())
+	})
+
+	t.Run("text", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		LogAndSetHTTPError(ContextWithResponseFormat(context.Background(), ResponseFormatText), recorder, log.NewNopLogger(), Validation{
+			Msg:        "invalid rules",
+			Violations: []FieldViolation{{Field: "rules[0].pattern", Message: "can't be empty"}},
+		})
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, "invalid rules\nrules[0].pattern: can't be empty\n", recorder.Body.String())
+	})
+
+	t.Run("protobuf", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		LogAndSetHTTPError(ContextWithResponseFormat(context.Background(), ResponseFormatProtobuf), recorder, log.NewNopLogger(), err)
+
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, "application/x-protobuf", recorder.Header().Get("Content-Type"))
+		var s status.Status
+		require.NoError(t, proto.Unmarshal(recorder.Body.Bytes(), &s))
+		converted := FromGRPCStatus(grpcStatus.FromProto(&s))
+		var tooMany TooManyRequests
+		require.ErrorAs(t, converted, &tooMany)
+		assert.Equal(t, time.Second, tooMany.RetryAfter)
+		assert.Equal(t, "err-mimir-tenant-max-ingestion-rate", tooMany.Limit)
+		assert.Contains(t, tooMany.Msg, "too many requests")
+	})
+}
@@ -0,0 +1,110 @@
+package errorx
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// maxStackDepth is the max number of frames captured by Wrap and Internalf.
+const maxStackDepth = 32
+
+// stack is the call stack captured when an error was created.
+type stack []uintptr
+
+func callers() *stack {
+	var pcs [maxStackDepth]uintptr
+	// Skip runtime.Callers, callers and the constructor calling it.
+	n := runtime.Callers(3, pcs[:])
+	s := stack(pcs[:n])
+	return &s
+}
+
+// String formats the stack one frame per line, without the frames of the
+// runtime and with the file paths trimmed to their package directory.
+func (s *stack) String() string {
+	var sb strings.Builder
+	frames := runtime.CallersFrames(*s)
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" && !strings.HasPrefix(frame.Function, "runtime.") {
+			fmt.Fprintf(&sb, "%s\n\t%s:%d\n", frame.Function, filepath.Join(filepath.Base(filepath.Dir(frame.File)), filepath.Base(frame.File)), frame.Line)
+		}
+		if !more {
+			return sb.String()
+		}
+	}
+}
+
+// Internalf creates an Internal error with a formatted message, capturing the
+// stack of the call site. The stack is logged at debug level and added to the
+// request span by LogAndSetHTTPError, but isn't part of the error message.
+func Internalf(format string, args ...interface{}) error {
+	return Internal{Msg: fmt.Sprintf(format, args...), stack: callers()}
+}
+
+// Wrap adds msg and the stack of the call site to err, like Internalf. errorx
+// errors keep their type, so they are still translated to the same status
+// codes, and other errors are wrapped as Internal errors. Wrap returns nil if
+// err is nil.
+func Wrap(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	var errx Error
+	if errors.As(err, &errx) {
+		return &stackError{msg: msg, err: err, stack: callers()}
+	}
+	return Internal{Msg: msg, Err: err, stack: callers()}
+}
+
+// stackError wraps an errorx error with a message and a stack, without
+// hiding its type.
+type stackError struct {
+	msg   string
+	err   error
+	stack *stack
+}
+
+func (e *stackError) Error() string {
+	return e.msg + ": " + e.err.Error()
+}
+
+func (e *stackError) Unwrap() error {
+	return e.err
+}
+
+// StackTrace returns the stack captured by the outermost Wrap or Internalf
+// error in the chain of err, or an empty string if there is none.
+func StackTrace(err error) string {
+	for ; err != nil; err = errors.Unwrap(err) {
+		switch e := err.(type) {
+		case Internal:
+			if e.stack != nil {
+				return e.stack.String()
+			}
+		case *stackError:
+			return e.stack.String()
+		}
+	}
+	return ""
+}
+
+// FromPanic converts a value recovered from a panic into an Internal error
+// with the stack of the panic, so panics are logged and answered like the
+// other internal errors. Its UserMessage doesn't include the panic value,
+// which may hold internal details. It must be called by the deferred function
+// calling recover, for the stack to be the one of the panic. FromPanic
+// returns nil if recovered is nil.
+func FromPanic(recovered interface{}) error {
+	if recovered == nil {
+		return nil
+	}
+	e := Internal{Msg: fmt.Sprintf("panic: %v", recovered), UserMsg: "internal server error", stack: callers()}
+	if err, ok := recovered.(error); ok {
+		e.Msg, e.Err = "panic", err
+	}
+	return e
+}
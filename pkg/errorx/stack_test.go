@@ -0,0 +1,93 @@
+package errorx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInternalf(t *testing.T) {
+	err := Internalf("can't read %s", "block")
+	require.EqualError(t, err, "can't read block")
+	require.ErrorAs(t, err, &Internal{})
+	require.Contains(t, StackTrace(err), "errorx.TestInternalf\n\terrorx/stack_test.go:")
+	require.NotContains(t, StackTrace(err), "runtime.")
+}
+
+func TestWrap(t *testing.T) {
+	require.NoError(t, Wrap(nil, "msg"))
+
+	t.Run("other errors are wrapped as internal errors", func(t *testing.T) {
+		cause := errors.New("connection reset")
+		err := Wrap(cause, "can't fetch")
+		require.EqualError(t, err, "can't fetch: connection reset")
+		require.ErrorIs(t, err, cause)
+		var internal Internal
+		require.ErrorAs(t, err, &internal)
+		require.Equal(t, "can't fetch", internal.Message())
+		require.Contains(t, StackTrace(err), "errorx.TestWrap")
+	})
+
+	t.Run("errorx errors keep their type", func(t *testing.T) {
+		err := Wrap(BadRequest{Msg: "invalid target"}, "can't parse")
+		require.EqualError(t, err, "can't parse: invalid target")
+		var badRequest BadRequest
+		require.ErrorAs(t, err, &badRequest)
+		require.Equal(t, "invalid target", badRequest.Message())
+		require.Contains(t, StackTrace(err), "errorx.TestWrap")
+	})
+
+	require.Empty(t, StackTrace(Internal{Msg: "no stack"}))
+	require.Empty(t, StackTrace(errors.New("no stack")))
+}
+
+func TestLogAndSetHTTPError_Stack(t *testing.T) {
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("request")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+
+	var logs bytes.Buffer
+	rec := httptest.NewRecorder()
+	LogAndSetHTTPError(ctx, rec, log.NewLogfmtLogger(&logs), Internalf("boom"))
+	span.Finish()
+
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Contains(t, logs.String(), `msg="error stack"`)
+	require.Contains(t, logs.String(), "TestLogAndSetHTTPError_Stack")
+
+	spanLogs := tracer.FinishedSpans()[0].Logs()
+	require.Len(t, spanLogs, 1)
+	require.Equal(t, "stack", spanLogs[0].Fields[2].Key)
+	require.Contains(t, spanLogs[0].Fields[2].ValueString, "TestLogAndSetHTTPError_Stack")
+}
+
+func panicking(v interface{}) (err error) {
+	defer func() { err = FromPanic(recover()) }()
+	panic(v)
+}
+
+func TestFromPanic(t *testing.T) {
+	require.NoError(t, FromPanic(nil))
+
+	err := panicking("index out of range")
+	require.EqualError(t, err, "panic: index out of range")
+	var internal Internal
+	require.ErrorAs(t, err, &internal)
+	require.Equal(t, "internal server error", internal.UserMessage())
+	// The stack is the one of the panic.
+	require.Contains(t, StackTrace(err), "errorx.panicking")
+	require.Contains(t, StackTrace(err), "errorx.TestFromPanic")
+
+	cause := errors.New("nil map")
+	err = panicking(cause)
+	require.EqualError(t, err, "panic: nil map")
+	require.ErrorIs(t, err, cause)
+}
@@ -0,0 +1,124 @@
+package whisperconverter
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/kisielk/whisper-go/whisper"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/carbon"
+)
+
+// maxCacheResponseSize is the max size of a carbon-cache query response.
+const maxCacheResponseSize = 64 * 1024 * 1024
+
+// CarbonLink queries carbon-cache instances for the datapoints they haven't
+// written to whisper files yet, as graphite-web does. Merging them with the
+// whisper files keeps the last minutes of data when converting a live
+// cluster.
+type CarbonLink struct {
+	addrs   []string
+	timeout time.Duration
+	// idle holds the idle connections to each instance.
+	idle map[string]chan net.Conn
+}
+
+// NewCarbonLink creates a CarbonLink querying the carbon-cache instances
+// listening for queries on addrs, keeping up to maxIdleConns connections to
+// each of them.
+func NewCarbonLink(addrs []string, timeout time.Duration, maxIdleConns int) *CarbonLink {
+	idle := make(map[string]chan net.Conn, len(addrs))
+	for _, addr := range addrs {
+		idle[addr] = make(chan net.Conn, maxIdleConns)
+	}
+	return &CarbonLink{addrs: addrs, timeout: timeout, idle: idle}
+}
+
+// Query returns the datapoints of metric cached by all the instances, as the
+// instance holding a metric depends on the relay's configuration.
+func (l *CarbonLink) Query(metric string) ([]whisper.Point, error) {
+	var points []whisper.Point
+	for _, addr := range l.addrs {
+		cached, err := l.query(addr, metric)
+		if err != nil {
+			return nil, fmt.Errorf("querying carbon-cache %s: %w", addr, err)
+		}
+		for _, p := range cached {
+			points = append(points, whisper.Point{Timestamp: uint32(p.TimestampMs / 1000), Value: p.Value})
+		}
+	}
+	return points, nil
+}
+
+func (l *CarbonLink) query(addr, metric string) (_ []carbon.Point, err error) {
+	var conn net.Conn
+	select {
+	case conn = <-l.idle[addr]:
+	default:
+		if conn, err = net.DialTimeout("tcp", addr, l.timeout); err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		if err != nil {
+			_ = conn.Close()
+			return
+		}
+		select {
+		case l.idle[addr] <- conn:
+		default:
+			_ = conn.Close()
+		}
+	}()
+
+	if err := conn.SetDeadline(time.Now().Add(l.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(carbon.EncodeCacheQuery(metric)); err != nil {
+		return nil, err
+	}
+	payload, err := carbon.ReadPickleMessage(conn, maxCacheResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	return carbon.DecodeCacheQueryResponse(payload)
+}
+
+// Close closes the idle connections.
+func (l *CarbonLink) Close() {
+	for _, idle := range l.idle {
+		for len(idle) > 0 {
+			_ = (<-idle).Close()
+		}
+	}
+}
+
+// mergeCachedPoints adds the points cached by carbon-cache to the points read
+// from a whisper file, aligning them to the precision of its first archive as
+// carbon-cache does when writing them. Cached points replace the points of the
+// file with the same timestamp, and the last cached point of an interval wins.
+func mergeCachedPoints(points, cached []whisper.Point, secondsPerPoint uint32) []whisper.Point {
+	if len(cached) == 0 || secondsPerPoint == 0 {
+		return points
+	}
+	byTimestamp := make(map[uint32]float64, len(cached))
+	for _, p := range cached {
+		byTimestamp[p.Timestamp-p.Timestamp%secondsPerPoint] = p.Value
+	}
+
+	merged := make([]whisper.Point, 0, len(points)+len(byTimestamp))
+	for _, p := range points {
+		if _, ok := byTimestamp[p.Timestamp]; !ok {
+			merged = append(merged, p)
+		}
+	}
+	for ts, v := range byTimestamp {
+		merged = append(merged, whisper.Point{Timestamp: ts, Value: v})
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Timestamp < merged[j].Timestamp
+	})
+	return merged
+}
@@ -0,0 +1,118 @@
+package whisperconverter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/kisielk/whisper-go/whisper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/carbon"
+)
+
+// fakeCarbonCache answers the cache queries for metric with the pickled
+// {'datapoints': [(1650000000, 1.5), (1650000060, 2.0)]}, and with no
+// datapoints for other metrics.
+func fakeCarbonCache(t *testing.T, metric string) (addr string, conns *atomic.Int32) {
+	const datapoints = "\x80\x02}q\x00X\n\x00\x00\x00datapointsq\x01]q\x02(J\x80\x00YbG?\xf8\x00\x00\x00\x00\x00\x00\x86q\x03J\xbc\x00YbG@\x00\x00\x00\x00\x00\x00\x00\x86q\x04es."
+	const empty = "\x80\x02}q\x00X\n\x00\x00\x00datapointsq\x01]q\x02s."
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	conns = &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conn.Close()
+				for {
+					query, err := carbon.ReadPickleMessage(conn, 1024)
+					if err != nil {
+						return
+					}
+					resp := empty
+					if bytes.HasSuffix(query, append([]byte(metric), 'u', '.')) {
+						resp = datapoints
+					}
+					msg := binary.BigEndian.AppendUint32(nil, uint32(len(resp)))
+					if _, err := conn.Write(append(msg, resp...)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), conns
+}
+
+func TestCarbonLink(t *testing.T) {
+	addr1, conns1 := fakeCarbonCache(t, "servers.web-1.cpu")
+	addr2, _ := fakeCarbonCache(t, "servers.web-2.cpu")
+
+	link := NewCarbonLink([]string{addr1, addr2}, time.Second, 1)
+	defer link.Close()
+
+	for _, metric := range []string{"servers.web-1.cpu", "servers.web-2.cpu"} {
+		points, err := link.Query(metric)
+		require.NoError(t, err)
+		require.Equal(t, []whisper.Point{{Timestamp: 1650000000, Value: 1.5}, {Timestamp: 1650000060, Value: 2}}, points)
+	}
+
+	points, err := link.Query("servers.web-3.cpu")
+	require.NoError(t, err)
+	require.Empty(t, points)
+	// The connections are reused.
+	require.Equal(t, int32(1), conns1.Load())
+
+	t.Run("unreachable carbon-cache", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		require.NoError(t, l.Close())
+
+		_, err = NewCarbonLink([]string{addr}, time.Second, 1).Query("servers.web-1.cpu")
+		require.ErrorContains(t, err, "querying carbon-cache "+addr)
+	})
+}
+
+func TestMergeCachedPoints(t *testing.T) {
+	points := []whisper.Point{{Timestamp: 60, Value: 1}, {Timestamp: 120, Value: 2}, {Timestamp: 180, Value: 3}}
+	cached := []whisper.Point{{Timestamp: 185, Value: 30}, {Timestamp: 200, Value: 31}, {Timestamp: 250, Value: 4}}
+
+	require.Equal(t, points, mergeCachedPoints(points, nil, 60))
+	require.Equal(t, []whisper.Point{
+		{Timestamp: 60, Value: 1},
+		{Timestamp: 120, Value: 2},
+		{Timestamp: 180, Value: 31},
+		{Timestamp: 240, Value: 4},
+	}, mergeCachedPoints(points, cached, 60))
+}
+
+func TestWhisperToMimirSamples_Cached(t *testing.T) {
+	wantSamples, _, err := WhisperToMimirSamples("./testdata/test.wsp", "test")
+	require.NoError(t, err)
+
+	// The last point of the file is at 1650480728.
+	samples, report, err := whisperToMimirSamples("./testdata/test.wsp", "test", []whisper.Point{
+		{Timestamp: 1650480728, Value: 99},
+		{Timestamp: 1650480729, Value: 100},
+	})
+	require.NoError(t, err)
+	require.False(t, report.HasIssues())
+	want := append(wantSamples[:len(wantSamples)-1:len(wantSamples)-1],
+		mimirpb.Sample{TimestampMs: 1650480728000, Value: 99},
+		mimirpb.Sample{TimestampMs: 1650480729000, Value: 100},
+	)
+	require.Equal(t, want, samples)
+}
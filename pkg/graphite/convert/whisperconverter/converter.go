@@ -1,11 +1,16 @@
 package whisperconverter
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/grafana/mimir-graphite/v2/pkg/graphite/convert"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/kisielk/whisper-go/whisper"
 	"github.com/prometheus/prometheus/model/labels"
 )
 
@@ -31,8 +36,16 @@ type WhisperConverter struct {
 	// dates is the list of all dates to process.
 	dates []time.Time
 
+	// carbonLink, if set, is queried for the points not yet written to the
+	// whisper files.
+	carbonLink *CarbonLink
+	// overlaps, if set, checks the series against the data the destination
+	// tenant already has.
+	overlaps *OverlapChecker
+
 	logger   log.Logger
 	progress *convert.Progress
+	report   *Report
 }
 
 func NewWhisperConverter(
@@ -56,6 +69,7 @@ func NewWhisperConverter(
 		dates:            dates,
 		logger:           logger,
 		progress:         convert.NewProgress(logger),
+		report:           &Report{},
 	}
 }
 
@@ -66,3 +80,44 @@ func (c *WhisperConverter) GetProcessedCount() uint64 {
 func (c *WhisperConverter) GetSkippedCount() uint64 {
 	return c.progress.GetSkippedCount()
 }
+
+// SetCarbonLink makes the converter merge the points cached by carbon-cache
+// with the points of the whisper files.
+func (c *WhisperConverter) SetCarbonLink(l *CarbonLink) {
+	c.carbonLink = l
+}
+
+// GetReport returns the reports of the files that couldn't be fully
+// converted.
+func (c *WhisperConverter) GetReport() *Report {
+	return c.report
+}
+
+// readWhisperFile reads the samples of a whisper file, adding the problems
+// found to the report. A panic reading the file is returned as an error, so a
+// single corrupt file doesn't abort the conversion.
+func (c *WhisperConverter) readWhisperFile(fname, metricName string) (samples []mimirpb.Sample, err error) {
+	var report FileReport
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic reading whisper file: %v", r)
+			report = FileReport{File: fname, Metric: metricName}.withError(err)
+		}
+		c.report.Add(report)
+		if err == nil && report.HasIssues() {
+			level.Warn(c.logger).Log("file", fname, "metric", metricName, "msg", "whisper file partially converted", "skipped_points", report.SkippedPoints, "truncated_archives", len(report.TruncatedArchives), "carbonlink_err", report.CarbonLinkError)
+		}
+	}()
+	// The cache is queried before reading the file, so the points written by
+	// carbon-cache in between are in the file rather than lost.
+	var cached []whisper.Point
+	var carbonLinkErr error
+	if c.carbonLink != nil {
+		cached, carbonLinkErr = c.carbonLink.Query(strings.TrimPrefix(metricName, c.namePrefix))
+	}
+	samples, report, err = whisperToMimirSamples(fname, metricName, cached)
+	if carbonLinkErr != nil {
+		report.CarbonLinkError = carbonLinkErr.Error()
+	}
+	return samples, err
+}
@@ -41,7 +41,7 @@ func (c *WhisperConverter) getTimestampBounds(files chan string, tsChan chan<- i
 	for fname := range files {
 		metricName := c.getMetricName(fname)
 		level.Info(c.logger).Log("file", fname, "metric", metricName, "msg", "processing file")
-		samples, err := WhisperToMimirSamples(fname, metricName)
+		samples, err := c.readWhisperFile(fname, metricName)
 		if err != nil {
 			level.Warn(c.logger).Log("file", fname, "metric", metricName, "msg", "error converting whisper metric", "err", err)
 			c.progress.IncSkipped()
@@ -0,0 +1,199 @@
+package whisperconverter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/graphite/convert"
+)
+
+// SeriesOverlap is a series with samples at the same timestamps as samples
+// the destination tenant already has for it. Importing them would create
+// duplicates, deduplicated by Mimir keeping either value.
+type SeriesOverlap struct {
+	Series string `json:"series"`
+	// StartMs and EndMs are the range of the existing data of the series
+	// over the range of the samples.
+	StartMs int64 `json:"start_ms"`
+	EndMs   int64 `json:"end_ms"`
+	// Samples is the number of samples at the timestamp of an existing one.
+	Samples int `json:"samples"`
+	// Excluded is whether these samples were left out of the blocks.
+	Excluded bool `json:"excluded,omitempty"`
+}
+
+// OverlapChecker queries the destination tenant for the data it already has
+// for the series being converted, through its remote read API, and records
+// the series that overlap it.
+type OverlapChecker struct {
+	queryable storage.Queryable
+	// exclude drops the overlapping samples from the blocks.
+	exclude bool
+
+	mtx      sync.Mutex
+	overlaps []SeriesOverlap
+}
+
+// NewOverlapChecker returns an OverlapChecker reading the destination tenant
+// from queryable, eg. a remoteread.NewRemoteReadQueryable. If exclude is set,
+// the samples overlapping the existing data are left out of the blocks.
+func NewOverlapChecker(queryable storage.Queryable, exclude bool) *OverlapChecker {
+	return &OverlapChecker{queryable: queryable, exclude: exclude}
+}
+
+// check returns the samples of the series lbls to convert: all of them,
+// unless some have the timestamp of an existing sample of the series and the
+// checker excludes overlaps. The samples interleaved with the existing ones
+// at other timestamps aren't overlaps, Mimir merges them. The samples must be
+// sorted by timestamp.
+func (o *OverlapChecker) check(ctx context.Context, lbls labels.Labels, samples []mimirpb.Sample) ([]mimirpb.Sample, error) {
+	if len(samples) == 0 {
+		return samples, nil
+	}
+	mint, maxt := samples[0].TimestampMs, samples[len(samples)-1].TimestampMs
+	existing, start, end, err := o.existingTimestamps(ctx, lbls, mint, maxt)
+	if err != nil || len(existing) == 0 {
+		return samples, err
+	}
+
+	kept := samples[:0:0]
+	overlap := SeriesOverlap{Series: lbls.String(), StartMs: start, EndMs: end, Excluded: o.exclude}
+	for _, s := range samples {
+		if _, ok := existing[s.TimestampMs]; ok {
+			overlap.Samples++
+			if o.exclude {
+				continue
+			}
+		}
+		kept = append(kept, s)
+	}
+	if overlap.Samples == 0 {
+		return samples, nil
+	}
+	o.mtx.Lock()
+	o.overlaps = append(o.overlaps, overlap)
+	o.mtx.Unlock()
+	if !o.exclude {
+		return samples, nil
+	}
+	return kept, nil
+}
+
+// existingTimestamps returns the timestamps of the samples the destination
+// has for lbls between mint and maxt, and their range.
+func (o *OverlapChecker) existingTimestamps(ctx context.Context, lbls labels.Labels, mint, maxt int64) (timestamps map[int64]struct{}, start, end int64, err error) {
+	querier, err := o.queryable.Querier(mint, maxt)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer querier.Close()
+
+	matchers := make([]*labels.Matcher, 0, lbls.Len())
+	lbls.Range(func(l labels.Label) {
+		matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, l.Name, l.Value))
+	})
+	set := querier.Select(ctx, false, &storage.SelectHints{Start: mint, End: maxt}, matchers...)
+	var it chunkenc.Iterator
+	for set.Next() {
+		series := set.At()
+		// The matchers select the series with at least these labels, so
+		// the ones with more aren't the same series.
+		if labels.Compare(series.Labels(), lbls) != 0 {
+			continue
+		}
+		it = series.Iterator(it)
+		for typ := it.Next(); typ != chunkenc.ValNone; typ = it.Next() {
+			ts := it.AtT()
+			if ts < mint || ts > maxt {
+				continue
+			}
+			if len(timestamps) == 0 || ts < start {
+				start = ts
+			}
+			if len(timestamps) == 0 || ts > end {
+				end = ts
+			}
+			if timestamps == nil {
+				timestamps = map[int64]struct{}{}
+			}
+			timestamps[ts] = struct{}{}
+		}
+		if err := it.Err(); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	if err := set.Err(); err != nil {
+		return nil, 0, 0, fmt.Errorf("reading the existing data of %s: %w", lbls, err)
+	}
+	return timestamps, start, end, nil
+}
+
+// Overlaps returns the overlaps found, sorted by series.
+func (o *OverlapChecker) Overlaps() []SeriesOverlap {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	overlaps := append([]SeriesOverlap(nil), o.overlaps...)
+	sort.Slice(overlaps, func(i, j int) bool {
+		if overlaps[i].Series != overlaps[j].Series {
+			return overlaps[i].Series < overlaps[j].Series
+		}
+		return overlaps[i].StartMs < overlaps[j].StartMs
+	})
+	return overlaps
+}
+
+// WriteFile writes the overlaps found to path as JSON.
+func (o *OverlapChecker) WriteFile(path string) error {
+	content, err := json.MarshalIndent(struct {
+		Overlaps []SeriesOverlap `json:"overlaps"`
+	}{o.Overlaps()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return fmt.Errorf("could not write overlap report: %w", err)
+	}
+	return nil
+}
+
+// SetOverlapChecker makes pass2 check the series against the data the
+// destination tenant already has, and CommandOverlaps possible.
+func (c *WhisperConverter) SetOverlapChecker(o *OverlapChecker) {
+	c.overlaps = o
+}
+
+// CommandOverlaps checks the series of the intermediate files of the dates
+// against the data the destination tenant already has, without generating
+// any block, so the conflicts can be reviewed before the import.
+func (c *WhisperConverter) CommandOverlaps(intermediateDir string) error {
+	if c.overlaps == nil {
+		return fmt.Errorf("no overlap checker set")
+	}
+	var paths []string
+	for _, d := range c.dates {
+		paths = append(paths, filepath.Join(intermediateDir, d.Format("2006-01-02.intermediate")))
+	}
+	for _, path := range convert.PathsForWorker(paths, c.workerCount, c.workerID) {
+		level.Info(c.logger).Log("file", path, "msg", "checking intermediate file for overlaps")
+		err := c.forEachIntermediateSeries(path, func(lbls labels.Labels, samples []mimirpb.Sample) error {
+			_, err := c.overlaps.check(context.Background(), lbls, samples)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("checking %s: %w", path, err)
+		}
+		c.progress.IncProcessed()
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package whisperconverter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/util/annotations"
+	"github.com/stretchr/testify/require"
+)
+
+type existingSample struct {
+	t int64
+	f float64
+}
+
+func (s existingSample) T() int64                      { return s.t }
+func (s existingSample) F() float64                    { return s.f }
+func (s existingSample) H() *histogram.Histogram       { return nil }
+func (s existingSample) FH() *histogram.FloatHistogram { return nil }
+func (s existingSample) Type() chunkenc.ValueType      { return chunkenc.ValFloat }
+func (s existingSample) Copy() chunks.Sample           { return s }
+
+// existingQueryable has a series with a sample at each of the timestamps of
+// the labels.
+type existingQueryable map[string][]int64
+
+func (q existingQueryable) Querier(int64, int64) (storage.Querier, error) {
+	return existingQuerier{Querier: storage.NoopQuerier(), series: q}, nil
+}
+
+type existingQuerier struct {
+	storage.Querier
+	series existingQueryable
+}
+
+func (q existingQuerier) Select(_ context.Context, _ bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	var series []storage.Series
+	for lbls, timestamps := range q.series {
+		lbls, err := parser.ParseMetric(lbls)
+		if err != nil {
+			return storage.ErrSeriesSet(err)
+		}
+		matches := true
+		for _, m := range matchers {
+			matches = matches && m.Matches(lbls.Get(m.Name))
+		}
+		if !matches {
+			continue
+		}
+		var samples []chunks.Sample
+		for _, ts := range timestamps {
+			if hints == nil || ts >= hints.Start && ts <= hints.End {
+				samples = append(samples, existingSample{t: ts, f: 1})
+			}
+		}
+		series = append(series, storage.NewListSeries(lbls, samples))
+	}
+	return &existingSeriesSet{series: series, i: -1}
+}
+
+type existingSeriesSet struct {
+	series []storage.Series
+	i      int
+}
+
+func (s *existingSeriesSet) Next() bool                        { s.i++; return s.i < len(s.series) }
+func (s *existingSeriesSet) At() storage.Series                { return s.series[s.i] }
+func (s *existingSeriesSet) Err() error                        { return nil }
+func (s *existingSeriesSet) Warnings() annotations.Annotations { return nil }
+
+func TestOverlapChecker(t *testing.T) {
+	samples := []mimirpb.Sample{{TimestampMs: 1000, Value: 1}, {TimestampMs: 2000, Value: 2}, {TimestampMs: 3000, Value: 3}, {TimestampMs: 4000, Value: 4}}
+	queryable := existingQueryable{
+		`{__name__="overlapping"}`:              {2000, 3000},
+		`{__name__="extra", customlabel="val"}`: {2000},
+		`{__name__="interleaved"}`:              {1500, 2500},
+	}
+
+	for _, exclude := range []bool{false, true} {
+		o := NewOverlapChecker(queryable, exclude)
+
+		kept, err := o.check(context.Background(), labels.FromStrings(labels.MetricName, "new"), samples)
+		require.NoError(t, err)
+		require.Equal(t, samples, kept)
+
+		kept, err = o.check(context.Background(), labels.FromStrings(labels.MetricName, "overlapping"), samples)
+		require.NoError(t, err)
+		if exclude {
+			require.Equal(t, []mimirpb.Sample{{TimestampMs: 1000, Value: 1}, {TimestampMs: 4000, Value: 4}}, kept)
+		} else {
+			require.Equal(t, samples, kept)
+		}
+
+		// Samples at other timestamps than the existing ones are merged.
+		kept, err = o.check(context.Background(), labels.FromStrings(labels.MetricName, "interleaved"), samples)
+		require.NoError(t, err)
+		require.Equal(t, samples, kept)
+
+		// An existing series with more labels isn't the same series.
+		kept, err = o.check(context.Background(), labels.FromStrings(labels.MetricName, "extra"), samples)
+		require.NoError(t, err)
+		require.Equal(t, samples, kept)
+
+		require.Equal(t, []SeriesOverlap{{Series: `{__name__="overlapping"}`, StartMs: 2000, EndMs: 3000, Samples: 2, Excluded: exclude}}, o.Overlaps())
+	}
+}
+
+func TestCommandOverlaps(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, createIntermediate(filepath.Join(dir, "2022-08-01.intermediate"), createData([]string{"my.cool.metric", "something.else"})))
+
+	date, err := ToTime("2022-08-01")
+	require.NoError(t, err)
+	c := NewWhisperConverter("", "", nil, 1, 1, 0, nil, []time.Time{date}, log.NewNopLogger())
+	require.Error(t, c.CommandOverlaps(dir))
+
+	o := NewOverlapChecker(existingQueryable{`{__name__="graphite_untagged", __n000__="my", __n001__="cool", __n002__="metric"}`: {1_000_500_000}}, false)
+	c.SetOverlapChecker(o)
+	require.NoError(t, c.CommandOverlaps(dir))
+	require.Len(t, o.Overlaps(), 1)
+	require.Equal(t, 1, o.Overlaps()[0].Samples)
+
+	report := filepath.Join(dir, "overlaps.json")
+	require.NoError(t, o.WriteFile(report))
+	content, err := os.ReadFile(report)
+	require.NoError(t, err)
+	require.Contains(t, string(content), `"start_ms": 1000500000`)
+}
+
+func TestCreateOneBlock_AllSamplesExcluded(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "2022-08-01.intermediate")
+	require.NoError(t, createIntermediate(fname, createData([]string{"my.cool.metric"})))
+	blocksDir := filepath.Join(dir, "blocks")
+	require.NoError(t, os.Mkdir(blocksDir, 0o755))
+
+	c := NewWhisperConverter("", "", nil, 1, 1, 0, nil, nil, log.NewNopLogger())
+	existing := existingQueryable{}
+	require.NoError(t, c.forEachIntermediateSeries(fname, func(lbls labels.Labels, samples []mimirpb.Sample) error {
+		for _, s := range samples {
+			existing[lbls.String()] = append(existing[lbls.String()], s.TimestampMs)
+		}
+		return nil
+	}))
+	c.SetOverlapChecker(NewOverlapChecker(existing, true))
+
+	// No empty block is written when all the samples are excluded.
+	require.NoError(t, c.createOneBlock(fname, blocksDir))
+	entries, err := os.ReadDir(blocksDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
@@ -21,7 +21,8 @@ import (
 // written to the intermediate files.  If this stage crashes, rerunning the
 // stage will automatically resume. targetWhisperFiles is a filename containing
 // the list of files to process, or if blank, files will be walked using
-// c.whisperDirectory.
+// c.whisperDirectory. The files that couldn't be fully converted are listed in
+// the pass1-report.json file of the intermediate directory.
 func (c *WhisperConverter) CommandPass1(targetWhisperFiles, intermediateDir string, resumeIntermediate bool) error {
 	err := os.MkdirAll(intermediateDir, os.ModePerm)
 	if err != nil {
@@ -29,6 +30,7 @@ func (c *WhisperConverter) CommandPass1(targetWhisperFiles, intermediateDir stri
 	}
 
 	fileChan := make(chan string)
+	reportFName := filepath.Join(intermediateDir, "pass1-report.json")
 
 	wgReads := &sync.WaitGroup{}
 	wgReads.Add(c.threads)
@@ -55,7 +57,12 @@ func (c *WhisperConverter) CommandPass1(targetWhisperFiles, intermediateDir stri
 	c.getWhisperListIntoChan(targetWhisperFiles, fileChan)
 
 	wgReads.Wait()
-	return nil
+
+	summary := c.report.Summary()
+	if summary.FailedFiles > 0 || summary.PartialFiles > 0 {
+		level.Warn(c.logger).Log("msg", "some whisper files couldn't be fully converted", "failed_files", summary.FailedFiles, "partial_files", summary.PartialFiles, "skipped_points", summary.SkippedPoints, "report", reportFName)
+	}
+	return c.report.WriteFile(reportFName)
 }
 
 // openIntermediateFiles creates or opens intermediate files for appending as
@@ -111,7 +118,7 @@ func (c *WhisperConverter) createIntermediateFromChan(files chan string, interme
 		}
 		level.Info(c.logger).Log("file", fname, "metric", metricName, "msg", "processing file")
 
-		samples, err := WhisperToMimirSamples(fname, metricName)
+		samples, err := c.readWhisperFile(fname, metricName)
 		if err != nil {
 			level.Warn(c.logger).Log("file", fname, "metric", metricName, "msg", "error converting whisper metric", "err", err)
 			c.progress.IncSkipped()
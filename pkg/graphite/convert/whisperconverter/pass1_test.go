@@ -1,7 +1,9 @@
 package whisperconverter
 
 import (
+	"encoding/json"
 	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 	"time"
@@ -30,6 +32,7 @@ func TestCommandPass1(t *testing.T) {
 				"2022-05-02.intermediate",
 				"2022-05-03.intermediate",
 				"2022-05-04.intermediate",
+				"pass1-report.json",
 				"processedMetrics.intermediate",
 			},
 		},
@@ -41,6 +44,7 @@ func TestCommandPass1(t *testing.T) {
 				"2022-05-02.intermediate",
 				"2022-05-03.intermediate",
 				"2022-05-04.intermediate",
+				"pass1-report.json",
 				"processedMetrics.intermediate",
 			},
 		},
@@ -111,3 +115,37 @@ func TestCommandPass1(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandPass1_CorruptFiles(t *testing.T) {
+	inDir := t.TempDir()
+	intermediateDir := t.TempDir()
+
+	times, err := ToTimes([]string{"2022-05-01", "2022-05-02"})
+	require.NoError(t, err)
+	require.NoError(t, CreateWhisperFile(filepath.Join(inDir, "good.wsp"), times))
+	require.NoError(t, CreateWhisperFile(filepath.Join(inDir, "truncated.wsp"), times))
+	// Drops the last point of the lowest resolution archive.
+	info, err := os.Stat(filepath.Join(inDir, "truncated.wsp"))
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(filepath.Join(inDir, "truncated.wsp"), info.Size()-10))
+	require.NoError(t, os.WriteFile(filepath.Join(inDir, "garbage.wsp"), []byte("not a whisper file"), 0o644))
+
+	dates, err := ToTimes([]string{"2022-05-01", "2022-05-02"})
+	require.NoError(t, err)
+	c := NewWhisperConverter("", inDir, regexp.MustCompile(`\.wsp$`), 2, 1, 0, labels.FromStrings(), []time.Time{*dates[0], *dates[1]}, log.NewNopLogger())
+	require.NoError(t, c.CommandPass1("", intermediateDir, true))
+	require.Equal(t, uint64(2), c.GetProcessedCount())
+	require.Equal(t, uint64(1), c.GetSkippedCount())
+
+	content, err := os.ReadFile(filepath.Join(intermediateDir, "pass1-report.json"))
+	require.NoError(t, err)
+	var summary ReportSummary
+	require.NoError(t, json.Unmarshal(content, &summary))
+	require.Equal(t, 1, summary.FailedFiles)
+	require.Equal(t, 1, summary.PartialFiles)
+	require.Len(t, summary.Files, 2)
+	require.Equal(t, filepath.Join(inDir, "garbage.wsp"), summary.Files[0].File)
+	require.Contains(t, summary.Files[0].Error, "corrupt header")
+	require.Equal(t, filepath.Join(inDir, "truncated.wsp"), summary.Files[1].File)
+	require.Equal(t, []int{2}, summary.Files[1].TruncatedArchives)
+}
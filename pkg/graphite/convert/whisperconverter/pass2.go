@@ -86,6 +86,43 @@ func (c *WhisperConverter) createBlocksFromChan(blocksDir string, files chan str
 // createOneBlock converts one intermediate file to a Mimir block.
 func (c *WhisperConverter) createOneBlock(fname, blocksDir string) error {
 	level.Info(c.logger).Log("file", fname, "msg", "creating block from intermediate file")
+	// The builder is only created with the first series kept, so the files
+	// whose samples are all excluded don't create an empty block.
+	var builder *tsdb.Builder
+	err := c.forEachIntermediateSeries(fname, func(lbls labels.Labels, samples []mimirpb.Sample) error {
+		if c.overlaps != nil {
+			var err error
+			if samples, err = c.overlaps.check(context.Background(), lbls, samples); err != nil {
+				return err
+			}
+			if len(samples) == 0 {
+				return nil
+			}
+		}
+		if builder == nil {
+			var err error
+			if builder, err = tsdb.NewBuilder(blocksDir, tsdb.DefaultOptions()); err != nil {
+				return err
+			}
+		}
+		s := convert.NewMimirSeries(lbls, samples)
+		return builder.AddSeriesWithSamples(s.Labels(), s.Iterator(nil))
+	})
+	if err != nil {
+		return err
+	}
+	if builder == nil {
+		level.Warn(c.logger).Log("msg", "no series for intermediate file", "file", fname)
+		return nil
+	}
+	_, err = builder.FinishBlock(context.Background(), func(meta promtsdb.BlockMeta) interface{} { return meta })
+	return err
+}
+
+// forEachIntermediateSeries calls f with the labels, including the custom
+// labels, and the samples of each series of an intermediate file, sorted by
+// labels.
+func (c *WhisperConverter) forEachIntermediateSeries(fname string, f func(labels.Labels, []mimirpb.Sample) error) error {
 	i, err := convert.NewUSTableForRead(fname, convert.NewMimirSeriesProto, c.logger)
 	if err != nil {
 		return err
@@ -98,16 +135,6 @@ func (c *WhisperConverter) createOneBlock(fname, blocksDir string) error {
 		return err
 	}
 
-	if len(index) == 0 {
-		level.Warn(c.logger).Log("msg", "no series for intermediate file", "file", fname)
-		return nil
-	}
-
-	builder, err := tsdb.NewBuilder(blocksDir, tsdb.DefaultOptions())
-	if err != nil {
-		return err
-	}
-
 	metricsIndex := buildMetricsIndex(index)
 	for _, info := range metricsIndex {
 		var value convert.ProtoUnmarshaler
@@ -126,15 +153,11 @@ func (c *WhisperConverter) createOneBlock(fname, blocksDir string) error {
 			labels = append(labels, c.customLabels...)
 			sort.Sort(labels)
 		}
-
-		s := convert.NewMimirSeries(labels, ms.Samples)
-		err = builder.AddSeriesWithSamples(s.Labels(), s.Iterator(nil))
-		if err != nil {
+		if err := f(labels, ms.Samples); err != nil {
 			return err
 		}
 	}
-	_, err = builder.FinishBlock(context.Background(), func(meta promtsdb.BlockMeta) interface{} { return meta })
-	return err
+	return nil
 }
 
 // getIntermediateListIntoChan feeds intermediate files that need to be
@@ -0,0 +1,308 @@
+package whisperconverter
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/util/annotations"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/graphite/convert"
+)
+
+// whisperExt is the extension of the whisper files served by
+// WhisperQueryable.
+const whisperExt = ".wsp"
+
+// WhisperQueryable is a read-only storage.Queryable serving the whisper files
+// of a directory as the untagged Graphite series the converter writes them
+// to, eg. so the data of a tree not converted yet can be served along with
+// the data already in Mimir during a migration.
+//
+// The whisper files of the tree, following the symlinked directories, are
+// indexed at the first select and again at the first one after each refresh
+// interval. The selects only read the files of the index they match.
+type WhisperQueryable struct {
+	directory       string
+	refreshInterval time.Duration
+	now             func() time.Time
+
+	mtx     sync.Mutex
+	files   []whisperFile
+	indexed time.Time
+}
+
+func NewWhisperQueryable(directory string, refreshInterval time.Duration) *WhisperQueryable {
+	return &WhisperQueryable{directory: directory, refreshInterval: refreshInterval, now: time.Now}
+}
+
+func (q *WhisperQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	return &whisperQuerier{queryable: q, mint: mint, maxt: maxt}, nil
+}
+
+// index returns the whisper files of the directory sorted by labels, walking
+// it again if the index is older than the refresh interval.
+func (q *WhisperQueryable) index(ctx context.Context) ([]whisperFile, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if !q.indexed.IsZero() && q.now().Sub(q.indexed) < q.refreshInterval {
+		return q.files, nil
+	}
+	var files []whisperFile
+	builder := labels.NewBuilder(labels.EmptyLabels())
+	err := walkWhisperFiles(ctx, q.directory, nil, map[string]bool{}, func(path string, nodes []string) {
+		name := strings.TrimSuffix(strings.Join(nodes, "."), whisperExt)
+		files = append(files, whisperFile{path: path, name: name, lbls: convert.LabelsFromUntaggedName(name, builder)})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return labels.Compare(files[i].lbls, files[j].lbls) < 0 })
+	q.files, q.indexed = files, q.now()
+	return files, nil
+}
+
+// walkWhisperFiles calls fn with the path and nodes of the whisper files under
+// dir, whose own nodes are parent, following the symlinked directories.
+// ancestors holds the resolved paths of the directories being walked, so the
+// symlinks looping back to them are skipped.
+func walkWhisperFiles(ctx context.Context, dir string, parent []string, ancestors map[string]bool, fn func(path string, nodes []string)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if ancestors[resolved] {
+		return nil
+	}
+	ancestors[resolved] = true
+	defer delete(ancestors, resolved)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		nodes := append(parent[:len(parent):len(parent)], e.Name())
+		isDir := e.IsDir()
+		if e.Type()&fs.ModeSymlink != 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				// Dangling symlinks are skipped.
+				continue
+			}
+			isDir = info.IsDir()
+		}
+		if isDir {
+			if err := walkWhisperFiles(ctx, path, nodes, ancestors, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if filepath.Ext(e.Name()) == whisperExt {
+			fn(path, nodes)
+		}
+	}
+	return nil
+}
+
+type whisperQuerier struct {
+	queryable  *WhisperQueryable
+	mint, maxt int64
+}
+
+// whisperFile is a whisper file with the labels of its series.
+type whisperFile struct {
+	path string
+	name string
+	lbls labels.Labels
+}
+
+// files returns the whisper files of the index whose series match matchers,
+// sorted by labels.
+func (q *whisperQuerier) files(ctx context.Context, matchers []*labels.Matcher) ([]whisperFile, error) {
+	index, err := q.queryable.index(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var files []whisperFile
+	for _, f := range index {
+		if matchesAll(matchers, f.lbls) {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+func matchesAll(matchers []*labels.Matcher, lbls labels.Labels) bool {
+	for _, m := range matchers {
+		if !m.Matches(lbls.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Select reads the samples of the matching whisper files. The files that
+// can't be read fail the select, the empty ones are skipped.
+func (q *whisperQuerier) Select(ctx context.Context, _ bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	mint, maxt := q.mint, q.maxt
+	if hints != nil {
+		mint, maxt = max(mint, hints.Start), min(maxt, hints.End)
+	}
+	files, err := q.files(ctx, matchers)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+	series := make([]storage.Series, 0, len(files))
+	for _, f := range files {
+		points, _, err := WhisperToMimirSamples(f.path, f.name)
+		if errors.Is(err, errNoPoints) {
+			continue
+		}
+		if err != nil {
+			return storage.ErrSeriesSet(err)
+		}
+		var samples []chunks.Sample
+		for _, p := range points {
+			if p.TimestampMs >= mint && p.TimestampMs <= maxt {
+				samples = append(samples, whisperSample{t: p.TimestampMs, f: p.Value})
+			}
+		}
+		if len(samples) > 0 {
+			series = append(series, storage.NewListSeries(f.lbls, samples))
+		}
+	}
+	return &listSeriesSet{series: series, i: -1}
+}
+
+func (q *whisperQuerier) LabelValues(ctx context.Context, name string, _ *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	files, err := q.files(ctx, matchers)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := map[string]struct{}{}
+	for _, f := range files {
+		if v := f.lbls.Get(name); v != "" {
+			seen[v] = struct{}{}
+		}
+	}
+	return sortedKeys(seen), nil, nil
+}
+
+func (q *whisperQuerier) LabelNames(ctx context.Context, _ *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	files, err := q.files(ctx, matchers)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := map[string]struct{}{}
+	for _, f := range files {
+		f.lbls.Range(func(l labels.Label) { seen[l.Name] = struct{}{} })
+	}
+	return sortedKeys(seen), nil, nil
+}
+
+func (q *whisperQuerier) Close() error {
+	return nil
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// listSeriesSet is a storage.SeriesSet of series sorted by labels.
+type listSeriesSet struct {
+	series []storage.Series
+	i      int
+}
+
+func (s *listSeriesSet) Next() bool {
+	s.i++
+	return s.i < len(s.series)
+}
+
+func (s *listSeriesSet) At() storage.Series                { return s.series[s.i] }
+func (s *listSeriesSet) Err() error                        { return nil }
+func (s *listSeriesSet) Warnings() annotations.Annotations { return nil }
+
+// whisperSample implements chunks.Sample for the points of whisper files.
+type whisperSample struct {
+	t int64
+	f float64
+}
+
+func (s whisperSample) T() int64                      { return s.t }
+func (s whisperSample) F() float64                    { return s.f }
+func (s whisperSample) H() *histogram.Histogram       { return nil }
+func (s whisperSample) FH() *histogram.FloatHistogram { return nil }
+func (s whisperSample) Type() chunkenc.ValueType      { return chunkenc.ValFloat }
+func (s whisperSample) Copy() chunks.Sample           { return s }
+
+// NewCutoverQueryable returns a Queryable serving the samples before cutover
+// from whisper, eg. a WhisperQueryable, and the samples from cutover on from
+// mimir, so the render path serves the old data still only in whisper along
+// with the fresh data written to Mimir. The series of both are merged.
+func NewCutoverQueryable(whisper, mimir storage.Queryable, cutover time.Time) storage.Queryable {
+	return cutoverQueryable{whisper: whisper, mimir: mimir, cutoverMs: cutover.UnixMilli()}
+}
+
+type cutoverQueryable struct {
+	whisper, mimir storage.Queryable
+	cutoverMs      int64
+}
+
+func (q cutoverQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	var queriers []storage.Querier
+	if mint < q.cutoverMs {
+		querier, err := q.whisper.Querier(mint, min(maxt, q.cutoverMs-1))
+		if err != nil {
+			return nil, err
+		}
+		queriers = append(queriers, cutoverQuerier{Querier: querier, mint: mint, maxt: min(maxt, q.cutoverMs-1)})
+	}
+	if maxt >= q.cutoverMs {
+		querier, err := q.mimir.Querier(max(mint, q.cutoverMs), maxt)
+		if err != nil {
+			for _, querier := range queriers {
+				_ = querier.Close()
+			}
+			return nil, err
+		}
+		queriers = append(queriers, cutoverQuerier{Querier: querier, mint: max(mint, q.cutoverMs), maxt: maxt})
+	}
+	return storage.NewMergeQuerier(queriers, nil, storage.ChainedSeriesMerge), nil
+}
+
+// cutoverQuerier limits the range of the selects to its side of the
+// cutover.
+type cutoverQuerier struct {
+	storage.Querier
+	mint, maxt int64
+}
+
+func (q cutoverQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	if hints != nil {
+		limited := *hints
+		limited.Start, limited.End = max(hints.Start, q.mint), min(hints.End, q.maxt)
+		hints = &limited
+	}
+	return q.Querier.Select(ctx, sortSeries, hints, matchers...)
+}
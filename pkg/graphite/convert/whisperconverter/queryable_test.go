@@ -0,0 +1,95 @@
+package whisperconverter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/stretchr/testify/require"
+)
+
+// selectSamples returns the timestamps of the samples selected from q, by
+// series.
+func selectSamples(t *testing.T, q storage.Queryable, mint, maxt int64, matchers ...*labels.Matcher) map[string][]int64 {
+	querier, err := q.Querier(mint, maxt)
+	require.NoError(t, err)
+	defer querier.Close()
+	set := querier.Select(context.Background(), true, &storage.SelectHints{Start: mint, End: maxt}, matchers...)
+	got := map[string][]int64{}
+	var it chunkenc.Iterator
+	for set.Next() {
+		it = set.At().Iterator(it)
+		for it.Next() != chunkenc.ValNone {
+			ts, _ := it.At()
+			got[set.At().Labels().String()] = append(got[set.At().Labels().String()], ts)
+		}
+	}
+	require.NoError(t, set.Err())
+	return got
+}
+
+func TestWhisperQueryable(t *testing.T) {
+	dir := t.TempDir()
+	times, err := ToTimes([]string{"2020-01-01", "2020-01-02", "2020-01-03"})
+	require.NoError(t, err)
+	for _, path := range []string{"team-a/cpu.wsp", "team-a/memory.wsp", "team-b/cpu.wsp"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0o755))
+		require.NoError(t, CreateWhisperFile(filepath.Join(dir, path), times))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "team-a", "README"), []byte("not a whisper file"), 0o644))
+	day := func(d int) int64 { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC).UnixMilli() }
+
+	q := NewWhisperQueryable(dir, time.Minute)
+	require.Equal(t, map[string][]int64{
+		`{__n000__="team-a", __n001__="cpu", __name__="graphite_untagged"}`:    {day(2), day(3)},
+		`{__n000__="team-a", __n001__="memory", __name__="graphite_untagged"}`: {day(2), day(3)},
+	}, selectSamples(t, q, day(2), day(4), labels.MustNewMatcher(labels.MatchEqual, "__n000__", "team-a")))
+
+	querier, err := q.Querier(day(1), day(4))
+	require.NoError(t, err)
+	values, _, err := querier.LabelValues(context.Background(), "__n000__", nil, labels.MustNewMatcher(labels.MatchEqual, "__n001__", "cpu"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"team-a", "team-b"}, values)
+
+	t.Run("index", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "team-a"), 0o755))
+		require.NoError(t, CreateWhisperFile(filepath.Join(dir, "team-a", "cpu.wsp"), times))
+		// Symlinked directories are followed, the loops aren't.
+		require.NoError(t, os.Symlink(filepath.Join(dir, "team-a"), filepath.Join(dir, "team-b")))
+		require.NoError(t, os.Symlink(dir, filepath.Join(dir, "team-a", "loop")))
+		require.NoError(t, os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "dangling")))
+
+		q := NewWhisperQueryable(dir, time.Minute)
+		now := time.Unix(0, 0)
+		q.now = func() time.Time { return now }
+		all := labels.MustNewMatcher(labels.MatchRegexp, "__n000__", ".+")
+		require.Equal(t, map[string][]int64{
+			`{__n000__="team-a", __n001__="cpu", __name__="graphite_untagged"}`: {day(2), day(3)},
+			`{__n000__="team-b", __n001__="cpu", __name__="graphite_untagged"}`: {day(2), day(3)},
+		}, selectSamples(t, q, day(2), day(4), all))
+
+		// The new files are served once the index is refreshed.
+		require.NoError(t, CreateWhisperFile(filepath.Join(dir, "team-a", "memory.wsp"), times))
+		require.Len(t, selectSamples(t, q, day(2), day(4), all), 2)
+		now = now.Add(time.Minute)
+		require.Len(t, selectSamples(t, q, day(2), day(4), all), 4)
+	})
+
+	t.Run("cutover", func(t *testing.T) {
+		mimir := existingQueryable{
+			`{__name__="graphite_untagged", __n000__="team-a", __n001__="cpu"}`: {day(2), day(3), day(4)},
+			`{__name__="graphite_untagged", __n000__="team-c", __n001__="cpu"}`: {day(4)},
+		}
+		q := NewCutoverQueryable(NewWhisperQueryable(dir, time.Minute), mimir, time.UnixMilli(day(3)))
+		require.Equal(t, map[string][]int64{
+			`{__n000__="team-a", __n001__="cpu", __name__="graphite_untagged"}`: {day(1), day(2), day(3), day(4)},
+			`{__n000__="team-c", __n001__="cpu", __name__="graphite_untagged"}`: {day(4)},
+		}, selectSamples(t, q, day(1), day(5), labels.MustNewMatcher(labels.MatchEqual, "__n001__", "cpu"), labels.MustNewMatcher(labels.MatchRegexp, "__n000__", "team-[ac]")))
+	})
+}
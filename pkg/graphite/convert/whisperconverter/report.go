@@ -0,0 +1,97 @@
+package whisperconverter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// FileReport records the problems found converting a whisper file.
+type FileReport struct {
+	File   string `json:"file"`
+	Metric string `json:"metric"`
+	// Error is why the file couldn't be converted, if it couldn't.
+	Error string `json:"error,omitempty"`
+	// TruncatedArchives are the archives extending past the end of the file,
+	// of which only the points in the file were converted.
+	TruncatedArchives []int `json:"truncated_archives,omitempty"`
+	// SkippedPoints is the number of corrupt points that weren't converted.
+	SkippedPoints int `json:"skipped_points,omitempty"`
+	// CarbonLinkError is why the points cached by carbon-cache couldn't be
+	// merged, in which case only the points of the file were converted.
+	CarbonLinkError string `json:"carbonlink_error,omitempty"`
+}
+
+// HasIssues returns whether the file couldn't be fully converted.
+func (r FileReport) HasIssues() bool {
+	return r.Error != "" || len(r.TruncatedArchives) > 0 || r.SkippedPoints > 0 || r.CarbonLinkError != ""
+}
+
+func (r FileReport) withError(err error) FileReport {
+	r.Error = err.Error()
+	return r
+}
+
+// Report is a concurrent-safe collection of the reports of the files that
+// couldn't be fully converted.
+type Report struct {
+	mtx   sync.Mutex
+	files []FileReport
+}
+
+// Add adds the report of a file if it has issues.
+func (r *Report) Add(file FileReport) {
+	if !file.HasIssues() {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.files = append(r.files, file)
+}
+
+// Files returns the reports added, sorted by file name.
+func (r *Report) Files() []FileReport {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	files := append([]FileReport(nil), r.files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].File < files[j].File })
+	return files
+}
+
+// ReportSummary is the content of the report file.
+type ReportSummary struct {
+	// FailedFiles is the number of files that couldn't be converted at all.
+	FailedFiles int `json:"failed_files"`
+	// PartialFiles is the number of files converted with points missing.
+	PartialFiles  int          `json:"partial_files"`
+	SkippedPoints int          `json:"skipped_points"`
+	Files         []FileReport `json:"files"`
+}
+
+// Summary summarizes the reports added.
+func (r *Report) Summary() ReportSummary {
+	summary := ReportSummary{Files: r.Files()}
+	for _, f := range summary.Files {
+		if f.Error != "" {
+			summary.FailedFiles++
+		} else {
+			summary.PartialFiles++
+		}
+		summary.SkippedPoints += f.SkippedPoints
+	}
+	return summary
+}
+
+// WriteFile writes the summary of the reports added to path as JSON.
+func (r *Report) WriteFile(path string) error {
+	content, err := json.MarshalIndent(r.Summary(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return fmt.Errorf("could not write report: %w", err)
+	}
+	return nil
+}
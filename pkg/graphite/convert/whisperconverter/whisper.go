@@ -1,8 +1,11 @@
 package whisperconverter
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"sort"
 	"time"
@@ -14,26 +17,48 @@ import (
 
 // WhisperToMimirSamples opens the given whisper file, applying the given metric
 // name, and writes it to the given block directory with blocks covering the
-// given duration.
-func WhisperToMimirSamples(whisperFile, name string) ([]mimirpb.Sample, error) {
+// given duration. Corrupt points and truncated archives are skipped, and
+// recorded in the returned FileReport along with the error, if any.
+func WhisperToMimirSamples(whisperFile, name string) ([]mimirpb.Sample, FileReport, error) {
+	return whisperToMimirSamples(whisperFile, name, nil)
+}
+
+// whisperToMimirSamples is WhisperToMimirSamples merging the points of the
+// whisper file with the given points cached by carbon-cache.
+func whisperToMimirSamples(whisperFile, name string, cached []whisper.Point) (_ []mimirpb.Sample, report FileReport, _ error) {
+	report = FileReport{File: whisperFile, Metric: name}
 	fd, err := os.Open(whisperFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open whisper file: %w", err)
+		err = fmt.Errorf("failed to open whisper file: %w", err)
+		return nil, report.withError(err), err
 	}
 	defer func() {
 		_ = fd.Close()
 	}()
-	w, err := newIOReaderArchive(fd)
+	info, err := fd.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open whisper archive: %w", err)
+		err = fmt.Errorf("failed to open whisper file: %w", err)
+		return nil, report.withError(err), err
+	}
+	w, err := newIOReaderArchive(fd, info.Size())
+	if err != nil {
+		err = fmt.Errorf("failed to open whisper archive: %w", err)
+		return nil, report.withError(err), err
 	}
 
 	points, err := ReadPoints(w, name)
+	report.TruncatedArchives, report.SkippedPoints = w.issues()
 	if err != nil {
-		return nil, fmt.Errorf("error dumping metric from whisper: %w", err)
+		err = fmt.Errorf("error dumping metric from whisper: %w", err)
+		return nil, report.withError(err), err
 	}
+	points = mergeCachedPoints(points, cached, w.Header.Archives[0].SecondsPerPoint)
 
-	return ToMimirSamples(points)
+	samples, err := ToMimirSamples(points)
+	if err != nil {
+		return nil, report.withError(err), err
+	}
+	return samples, report, nil
 }
 
 // Archive provides a testable interface for converting whisper databases.
@@ -148,11 +173,15 @@ func ReadPoints(w Archive, name string) ([]whisper.Point, error) {
 	return keptPoints, nil
 }
 
+// errNoPoints is returned by ToMimirSamples for the whisper files without any
+// point.
+var errNoPoints = errors.New("no points to convert for metric")
+
 // ToMimirSamples converts a Whisper metric with the given name to a slice of
 // labels and series of mimir samples.  Returns error if no points.
 func ToMimirSamples(points []whisper.Point) ([]mimirpb.Sample, error) {
 	if len(points) == 0 {
-		return nil, fmt.Errorf("no points to convert for metric")
+		return nil, errNoPoints
 	}
 
 	samples := make([]mimirpb.Sample, 0, len(points))
@@ -196,20 +225,109 @@ func SplitSamplesByDays(samples []mimirpb.Sample) [][]mimirpb.Sample {
 	return blocks
 }
 
+// whisperPointSize is the size of a point on disk: a uint32 timestamp and a
+// float64 value.
+const whisperPointSize = 12
+
+// ioReaderArchive reads whisper files tolerating corruption: the header is
+// checked against the size of the file before being read, archives extending
+// past the end of the file are read up to it, and points with a timestamp not
+// aligned to the archive's precision or a NaN value are skipped.
 type ioReaderArchive struct {
 	*whisper.Whisper
+
+	fd   io.ReadSeeker
+	size int64
+
+	truncated map[int]bool
+	skipped   map[int]int
 }
 
 // newIOReaderArchive opens a whisper archive and returns a new
 // Whisper Archive-compatible pointer.
-func newIOReaderArchive(fd io.ReadWriteSeeker) (*ioReaderArchive, error) {
+func newIOReaderArchive(fd io.ReadWriteSeeker, size int64) (*ioReaderArchive, error) {
+	// Check the number of archives before letting whisper read them, so a
+	// corrupt count doesn't make it allocate gigabytes.
+	var metadata whisper.Metadata
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(fd, binary.BigEndian, &metadata); err != nil {
+		return nil, fmt.Errorf("corrupt header: %w", err)
+	}
+	headerSize := int64(binary.Size(metadata)) + int64(metadata.ArchiveCount)*int64(binary.Size(whisper.ArchiveInfo{}))
+	if metadata.ArchiveCount == 0 || headerSize > size {
+		return nil, fmt.Errorf("corrupt header: %d archives in a %d bytes file", metadata.ArchiveCount, size)
+	}
+
 	w, err := whisper.OpenWhisper(fd)
 	if err != nil {
 		return nil, err
 	}
-	return &ioReaderArchive{w}, nil
+	for i, a := range w.Header.Archives {
+		if a.SecondsPerPoint == 0 || int64(a.Offset) < headerSize {
+			return nil, fmt.Errorf("corrupt header: invalid archive %d", i)
+		}
+	}
+	return &ioReaderArchive{
+		Whisper:   w,
+		fd:        fd,
+		size:      size,
+		truncated: map[int]bool{},
+		skipped:   map[int]int{},
+	}, nil
 }
 
 func (w *ioReaderArchive) GetArchives() []whisper.ArchiveInfo {
 	return w.Header.Archives
 }
+
+func (w *ioReaderArchive) DumpArchive(n int) ([]whisper.Point, error) {
+	if n < 0 || n >= len(w.Header.Archives) {
+		return nil, fmt.Errorf("database contains only %d archives", len(w.Header.Archives))
+	}
+	info := w.Header.Archives[n]
+
+	count := int64(info.Points)
+	if available := (w.size - int64(info.Offset)) / whisperPointSize; available < count {
+		w.truncated[n] = true
+		count = max(available, 0)
+	}
+	buf := make([]byte, count*whisperPointSize)
+	if _, err := w.fd.Seek(int64(info.Offset), io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(w.fd, buf); err != nil {
+		return nil, err
+	}
+
+	points := make([]whisper.Point, 0, count)
+	skipped := 0
+	for i := int64(0); i < count; i++ {
+		p := whisper.Point{
+			Timestamp: binary.BigEndian.Uint32(buf[i*whisperPointSize:]),
+			Value:     math.Float64frombits(binary.BigEndian.Uint64(buf[i*whisperPointSize+4:])),
+		}
+		// Zero timestamps are slots never written to, and are skipped by
+		// ReadPoints.
+		if p.Timestamp != 0 && (p.Timestamp%info.SecondsPerPoint != 0 || math.IsNaN(p.Value)) {
+			skipped++
+			continue
+		}
+		points = append(points, p)
+	}
+	w.skipped[n] = skipped
+	return points, nil
+}
+
+// issues returns the archives that were truncated and the number of points
+// skipped in the archives dumped.
+func (w *ioReaderArchive) issues() (truncated []int, skipped int) {
+	for n := range w.Header.Archives {
+		if w.truncated[n] {
+			truncated = append(truncated, n)
+		}
+		skipped += w.skipped[n]
+	}
+	return truncated, skipped
+}
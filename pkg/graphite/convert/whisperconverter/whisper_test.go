@@ -1,8 +1,11 @@
 package whisperconverter
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"testing"
@@ -623,7 +626,7 @@ func TestConvertToMimirBlocks(t *testing.T) {
 
 	metricName := "foo.bar.baz.test"
 
-	samples, err := WhisperToMimirSamples(testFilePath, metricName)
+	samples, _, err := WhisperToMimirSamples(testFilePath, metricName)
 	require.Nil(t, err)
 
 	labelsBuilder := labels.NewBuilder(nil)
@@ -679,3 +682,59 @@ func (a *testArchive) DumpArchive(n int) ([]whisper.Point, error) {
 	}
 	return a.points[n], nil
 }
+
+func TestWhisperToMimirSamples_Corrupt(t *testing.T) {
+	content, err := os.ReadFile("./testdata/test.wsp")
+	require.NoError(t, err)
+	// The file has a single archive of 60 points starting at byte 28, the
+	// first one being the latest sample, the second one empty and the others
+	// the samples from the earliest.
+	const pointsOffset = 28
+	wantSamples, report, err := WhisperToMimirSamples("./testdata/test.wsp", "test")
+	require.NoError(t, err)
+	require.False(t, report.HasIssues())
+	require.Len(t, wantSamples, 59)
+
+	writeFile := func(t *testing.T, content []byte) string {
+		path := filepath.Join(t.TempDir(), "test.wsp")
+		require.NoError(t, os.WriteFile(path, content, 0o644))
+		return path
+	}
+
+	t.Run("truncated archive", func(t *testing.T) {
+		path := writeFile(t, content[:len(content)-10*whisperPointSize-5])
+		samples, report, err := WhisperToMimirSamples(path, "test")
+		require.NoError(t, err)
+		require.Equal(t, []int{0}, report.TruncatedArchives)
+		require.Equal(t, append(wantSamples[:47:47], wantSamples[58]), samples)
+	})
+
+	t.Run("corrupt point", func(t *testing.T) {
+		corrupt := bytes.Clone(content)
+		binary.BigEndian.PutUint64(corrupt[pointsOffset+4:], math.Float64bits(math.NaN()))
+		samples, report, err := WhisperToMimirSamples(writeFile(t, corrupt), "test")
+		require.NoError(t, err)
+		require.Equal(t, 1, report.SkippedPoints)
+		require.Equal(t, wantSamples[:58], samples)
+	})
+
+	t.Run("corrupt archive count", func(t *testing.T) {
+		corrupt := bytes.Clone(content)
+		binary.BigEndian.PutUint32(corrupt[12:], math.MaxUint32)
+		_, report, err := WhisperToMimirSamples(writeFile(t, corrupt), "test")
+		require.EqualError(t, err, "failed to open whisper archive: corrupt header: 4294967295 archives in a 748 bytes file")
+		require.Equal(t, err.Error(), report.Error)
+	})
+
+	t.Run("corrupt archive offset", func(t *testing.T) {
+		corrupt := bytes.Clone(content)
+		binary.BigEndian.PutUint32(corrupt[16:], 4)
+		_, _, err := WhisperToMimirSamples(writeFile(t, corrupt), "test")
+		require.EqualError(t, err, "failed to open whisper archive: corrupt header: invalid archive 0")
+	})
+
+	t.Run("truncated header", func(t *testing.T) {
+		_, _, err := WhisperToMimirSamples(writeFile(t, content[:10]), "test")
+		require.ErrorContains(t, err, "corrupt header")
+	})
+}
@@ -0,0 +1,270 @@
+// Package timespec parses the Graphite time specifications used by the from,
+// until and tz parameters of the render and find APIs, following graphite-web's
+// parseATTime so existing dashboards keep working.
+package timespec
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultFrom and DefaultUntil are the values Graphite uses when the from
+	// and until parameters are missing.
+	DefaultFrom  = "-1d"
+	DefaultUntil = "now"
+)
+
+var (
+	months   = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// LoadLocation returns the time zone of a tz parameter, which is a name from
+// the IANA time zone database like "Europe/Madrid". An empty tz returns def.
+func LoadLocation(tz string, def *time.Location) (*time.Location, error) {
+	if tz == "" {
+		return def, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %w", tz, err)
+	}
+	return loc, nil
+}
+
+// Parse returns the time for a Graphite time specification, relative to now
+// and in the time zone loc. It accepts:
+//
+//   - unix timestamps, like "1700000000".
+//   - "HH:MM_YYYYMMDD", like "04:00_20110501".
+//   - a reference followed by an offset, like "now-1h", "noon yesterday",
+//     "6pm today+30min", "midnight 04/01/2023", "20230401", "january 1" or
+//     "monday". The reference defaults to now, so "-7d" is 7 days ago.
+//
+// Spaces in s are ignored, so URL query values must be decoded first: "+" in
+// "noon+yesterday" is an offset sign. Unlike graphite-web, "12am" is midnight
+// and "12pm" is noon.
+func Parse(s string, now time.Time, loc *time.Location) (time.Time, error) {
+	s = strings.NewReplacer("_", "", ",", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(s)))
+	now = now.In(loc)
+
+	if isDigits(s) {
+		// 8 digits that look like a date are YYYYMMDD, anything else a timestamp.
+		if !(len(s) == 8 && atoi(s[:4]) > 1900 && atoi(s[4:6]) < 13 && atoi(s[6:]) < 32) {
+			ts, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid timestamp %q: %w", s, err)
+			}
+			return time.Unix(ts, 0).In(loc), nil
+		}
+	} else if strings.Contains(s, ":") && len(s) == 13 {
+		// Otherwise parsed as a reference, like "17:45 04/01/23".
+		if t, err := time.ParseInLocation("15:0420060102", s, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	ref, offset := s, ""
+	if i := strings.IndexAny(s, "+-"); i >= 0 {
+		ref, offset = s[:i], s[i:]
+	}
+	t, err := parseReference(ref, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	d, err := ParseOffset(offset)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(d), nil
+}
+
+// parseReference parses an optional time of day followed by an optional day,
+// like "noon", "6:30pm", "yesterday", "04/01/2023" or "8amtomorrow".
+func parseReference(ref string, now time.Time) (time.Time, error) {
+	if ref == "" || ref == "now" {
+		return now, nil
+	}
+	rawRef := ref
+
+	hour, minute := 0, 0
+	if i := strings.Index(ref, ":"); i > 0 && i < 3 && len(ref) >= i+3 {
+		if !isDigits(ref[:i]) || !isDigits(ref[i+1:i+3]) {
+			return time.Time{}, fmt.Errorf("invalid time of day in %q", rawRef)
+		}
+		hour, minute = atoi(ref[:i]), atoi(ref[i+1:i+3])
+		ref = ref[i+3:]
+		if strings.HasPrefix(ref, "am") || strings.HasPrefix(ref, "pm") {
+			hour = to24h(hour, ref[:2])
+			ref = ref[2:]
+		}
+	} else if i := strings.IndexAny(ref, "ap"); i > 0 && i < 3 && strings.HasPrefix(ref[i:], ref[i:i+1]+"m") && isDigits(ref[:i]) {
+		hour = to24h(atoi(ref[:i]), ref[i:i+2])
+		ref = ref[i+2:]
+	}
+	switch {
+	case strings.HasPrefix(ref, "noon"):
+		hour, minute = 12, 0
+		ref = ref[len("noon"):]
+	case strings.HasPrefix(ref, "midnight"):
+		hour, minute = 0, 0
+		ref = ref[len("midnight"):]
+	case strings.HasPrefix(ref, "teatime"):
+		hour, minute = 16, 0
+		ref = ref[len("teatime"):]
+	}
+	if hour > 23 || minute > 59 {
+		return time.Time{}, fmt.Errorf("invalid time of day in %q", rawRef)
+	}
+
+	year, month, day := now.Date()
+	explicitDate := true
+	switch {
+	case ref == "" || ref == "today":
+		explicitDate = false
+	case ref == "yesterday":
+		day--
+		explicitDate = false
+	case ref == "tomorrow":
+		day++
+		explicitDate = false
+	case strings.Count(ref, "/") == 2:
+		// MM/DD/YY or MM/DD/YYYY
+		parts := strings.Split(ref, "/")
+		if !isDigits(parts[0]) || !isDigits(parts[1]) || !isDigits(parts[2]) {
+			return time.Time{}, fmt.Errorf("invalid date in %q, expected MM/DD/YY", rawRef)
+		}
+		month, day, year = time.Month(atoi(parts[0])), atoi(parts[1]), atoi(parts[2])
+		if year < 1900 {
+			year += 1900
+		}
+		if year < 1970 {
+			year += 100
+		}
+	case len(ref) == 8 && isDigits(ref):
+		year, month, day = atoi(ref[:4]), time.Month(atoi(ref[4:6])), atoi(ref[6:])
+	case len(ref) >= 3 && indexOf(months, ref[:3]) >= 0:
+		// Month name followed by the day of the month, like "january1".
+		digits := len(ref) - len(strings.TrimRight(ref, "0123456789"))
+		if digits == 0 {
+			return time.Time{}, fmt.Errorf("day of month required after month name in %q", rawRef)
+		}
+		month, day = time.Month(indexOf(months, ref[:3])+1), atoi(ref[len(ref)-min(digits, 2):])
+	case len(ref) >= 3 && indexOf(weekdays, ref[:3]) >= 0:
+		// The most recent such weekday, today included.
+		day -= (int(now.Weekday()) - indexOf(weekdays, ref[:3]) + 7) % 7
+		explicitDate = false
+	default:
+		return time.Time{}, fmt.Errorf("unknown day reference %q", rawRef)
+	}
+	t := time.Date(year, month, day, hour, minute, 0, 0, now.Location())
+	if explicitDate && (t.Month() != month || t.Day() != day) {
+		// time.Date normalized an out of range month or day.
+		return time.Time{}, fmt.Errorf("invalid date in %q", rawRef)
+	}
+	return t, nil
+}
+
+// ParseOffset parses a Graphite time offset, like "-1h", "+30min" or "1d12h",
+// one or more numbers with a unit: s, min, h, d, w, mon or y. Units can be
+// spelled out, like "minutes" or "days". Months are 30 days and years 365.
+func ParseOffset(offset string) (time.Duration, error) {
+	if offset == "" {
+		return 0, nil
+	}
+	rawOffset := offset
+	sign := time.Duration(1)
+	switch offset[0] {
+	case '+':
+		offset = offset[1:]
+	case '-':
+		sign = -1
+		offset = offset[1:]
+	}
+	if offset == "" {
+		return 0, fmt.Errorf("invalid offset %q", rawOffset)
+	}
+
+	var total time.Duration
+	for offset != "" {
+		i := len(offset) - len(strings.TrimLeft(offset, "0123456789"))
+		if i == 0 {
+			return 0, fmt.Errorf("invalid offset %q, expected a number", rawOffset)
+		}
+		num, err := strconv.Atoi(offset[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid offset %q: %w", rawOffset, err)
+		}
+		offset = offset[i:]
+		j := len(offset) - len(strings.TrimLeft(offset, "abcdefghijklmnopqrstuvwxyz"))
+		unit, err := unitDuration(offset[:j])
+		if err != nil {
+			return 0, fmt.Errorf("invalid offset %q: %w", rawOffset, err)
+		}
+		offset = offset[j:]
+		total += time.Duration(num) * unit
+	}
+	return sign * total, nil
+}
+
+func unitDuration(unit string) (time.Duration, error) {
+	switch {
+	case strings.HasPrefix(unit, "s"):
+		return time.Second, nil
+	case strings.HasPrefix(unit, "min"):
+		return time.Minute, nil
+	case strings.HasPrefix(unit, "h"):
+		return time.Hour, nil
+	case strings.HasPrefix(unit, "d"):
+		return 24 * time.Hour, nil
+	case strings.HasPrefix(unit, "w"):
+		return 7 * 24 * time.Hour, nil
+	case strings.HasPrefix(unit, "mon"):
+		return 30 * 24 * time.Hour, nil
+	case strings.HasPrefix(unit, "y"):
+		return 365 * 24 * time.Hour, nil
+	}
+	return 0, fmt.Errorf("unknown unit %q", unit)
+}
+
+func to24h(hour int, ampm string) int {
+	if hour > 12 {
+		// Invalid, rejected by the hour range check.
+		return 24
+	}
+	hour %= 12
+	if ampm == "pm" {
+		hour += 12
+	}
+	return hour
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// atoi converts strings already checked with isDigits.
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func indexOf(values []string, v string) int {
+	for i, value := range values {
+		if value == v {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,95 @@
+package timespec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	madrid, err := LoadLocation("Europe/Madrid", time.UTC)
+	require.NoError(t, err)
+	// A Wednesday.
+	now := time.Date(2023, time.March, 15, 10, 20, 30, 0, madrid)
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, madrid)
+	}
+
+	for spec, want := range map[string]time.Time{
+		"now":                   now,
+		"":                      now,
+		"-7d":                   now.Add(-7 * 24 * time.Hour),
+		"now-1h":                now.Add(-time.Hour),
+		"-1d12h":                now.Add(-36 * time.Hour),
+		"-30minutes":            now.Add(-30 * time.Minute),
+		"+2weeks":               now.Add(14 * 24 * time.Hour),
+		"-1mon":                 now.Add(-30 * 24 * time.Hour),
+		"-1y":                   now.Add(-365 * 24 * time.Hour),
+		"1678872000":            time.Unix(1678872000, 0),
+		"04:00_20110501":        at(2011, time.May, 1, 4, 0),
+		"20110501":              at(2011, time.May, 1, 0, 0),
+		"noon yesterday":        at(2023, time.March, 14, 12, 0),
+		"midnight":              at(2023, time.March, 15, 0, 0),
+		"teatime tomorrow":      at(2023, time.March, 16, 16, 0),
+		"6pm today":             at(2023, time.March, 15, 18, 0),
+		"8am":                   at(2023, time.March, 15, 8, 0),
+		"12am":                  at(2023, time.March, 15, 0, 0),
+		"12pm":                  at(2023, time.March, 15, 12, 0),
+		"6:30pm yesterday-1h":   at(2023, time.March, 14, 17, 30),
+		"17:45 04/01/23":        at(2023, time.April, 1, 17, 45),
+		"04/01/1999":            at(1999, time.April, 1, 0, 0),
+		"01/02/69":              at(2069, time.January, 2, 0, 0),
+		"January 1":             at(2023, time.January, 1, 0, 0),
+		"feb28":                 at(2023, time.February, 28, 0, 0),
+		"monday":                at(2023, time.March, 13, 0, 0),
+		"wednesday":             at(2023, time.March, 15, 0, 0),
+		"thursday":              at(2023, time.March, 9, 0, 0),
+		"noon, Dec 25":          at(2023, time.December, 25, 12, 0),
+		"midnight_20230301+12h": at(2023, time.March, 1, 12, 0),
+		" NOW-5S ":              now.Add(-5 * time.Second),
+		"noonyesterday-1d30min": at(2023, time.March, 13, 11, 30),
+	} {
+		t.Run(spec, func(t *testing.T) {
+			got, err := Parse(spec, now, madrid)
+			require.NoError(t, err)
+			require.True(t, want.Equal(got), "want %s, got %s", want, got)
+			require.Equal(t, madrid, got.Location())
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, spec := range []string{
+		"-",
+		"-5",
+		"-5m",
+		"-1h-",
+		"-1d+30min",
+		"noon+yesterday",
+		"foo",
+		"25:00_20110501",
+		"13pm",
+		"january",
+		"02/30/2023",
+		"99:99",
+	} {
+		t.Run(spec, func(t *testing.T) {
+			_, err := Parse(spec, time.Now(), time.UTC)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLoadLocation(t *testing.T) {
+	loc, err := LoadLocation("", time.UTC)
+	require.NoError(t, err)
+	require.Equal(t, time.UTC, loc)
+
+	loc, err = LoadLocation("America/New_York", time.UTC)
+	require.NoError(t, err)
+	require.Equal(t, "America/New_York", loc.String())
+
+	_, err = LoadLocation("Mars/Olympus_Mons", time.UTC)
+	require.Error(t, err)
+}
@@ -18,3 +18,8 @@ func (c *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 func (c *Config) RegisterFlags(flags *flag.FlagSet) {
 	c.RegisterFlagsWithPrefix("", flags)
 }
+
+// Validate checks the remote write config.
+func (c *Config) Validate() error {
+	return c.RemoteWriteConfig.Validate()
+}
@@ -2,6 +2,7 @@ package internalserver
 
 import (
 	"context"
+	"crypto/subtle"
 	"flag"
 	"fmt"
 	"net/http"
@@ -11,7 +12,10 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+	"github.com/grafana/mimir-graphite/v2/pkg/server"
 )
 
 const (
@@ -25,7 +29,27 @@ type Config struct {
 	HTTPListenPort                int           `yaml:"http_listen_port"`
 	ServerGracefulShutdownTimeout time.Duration `yaml:"graceful_shutdown_timeout"`
 
+	// AdminToken must be sent as a bearer token to the admin endpoints, like
+	// /debug/inflight, which shows the requests of all the tenants, or /ring,
+	// which can remove ring members. The admin endpoints aren't served if it
+	// isn't set.
+	AdminToken secrets.Secret `yaml:"admin_token"`
+
+	Metrics MetricsConfig `yaml:"metrics"`
+
 	ReadinessProvider ReadinessProvider `yaml:"-"`
+	// InflightRequests, if set, serves /debug/inflight, listing the requests
+	// being served by the app.
+	InflightRequests http.Handler `yaml:"-"`
+	// LogLevels, if set, serves /debug/log-levels, showing and changing the
+	// log levels of the app.
+	LogLevels http.Handler `yaml:"-"`
+	// Ring, if set, serves /ring, the status page of the ring the replicas
+	// of the app share out their background work with.
+	Ring http.Handler `yaml:"-"`
+	// Listen is how the listener is opened, set from the server config of
+	// the app.
+	Listen server.ListenConfig `yaml:"-"`
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet
@@ -43,20 +67,29 @@ func (cfg *Config) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
 	flags.StringVar(&cfg.HTTPListenAddress, prefix+"internalserver.http-listen-address", "", "Internal HTTP server listen address.")
 	flags.IntVar(&cfg.HTTPListenPort, prefix+"internalserver.http-listen-port", defaultListenPort, "Internal HTTP server listen port.")
 	flags.DurationVar(&cfg.ServerGracefulShutdownTimeout, prefix+"internalserver.graceful-shutdown-timeout", defaultGracefulShutdownTimeout, "Timeout for graceful shutdowns")
+	flags.Var(&cfg.AdminToken, prefix+"internalserver.admin-token", "Bearer token required by the admin endpoints of the internal server, like /debug/inflight, /debug/log-levels and /ring. Either the token, file:<path>, env:<name> or vault:<path>#<key>. If empty, the admin endpoints aren't served.")
+	cfg.Metrics.RegisterFlagsWithPrefix(prefix+"internalserver.", flags)
 }
 
-func Handler(logger log.Logger, cfg Config) (run func() error, stop func(error)) {
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
-
-	mux.Handle("/healthz", http.HandlerFunc(NewReadinessHandler(cfg.ReadinessProvider, logger)))
+// Validate checks the config for values the internal server can't start with.
+func (cfg *Config) Validate() error {
+	if err := server.ValidateListenAddress(cfg.HTTPListenAddress); err != nil {
+		return fmt.Errorf("invalid internal server listen address: %w", err)
+	}
+	if cfg.HTTPListenPort < 0 || cfg.HTTPListenPort > 65535 {
+		return fmt.Errorf("internal server listen port %d is out of range", cfg.HTTPListenPort)
+	}
+	if cfg.ServerGracefulShutdownTimeout < 0 {
+		return fmt.Errorf("internal server graceful shutdown timeout can't be negative")
+	}
+	return cfg.Metrics.Validate()
+}
 
-	// Pprof.
-	mux.HandleFunc("/debug/pprof/", pprof.Index)
-	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+func Handler(logger log.Logger, cfg Config) (run func() error, stop func(error)) {
+	mux, err := newMux(logger, cfg)
+	if err != nil {
+		return func() error { return err }, func(error) {}
+	}
 
 	addr := fmt.Sprintf("%s:%d", cfg.HTTPListenAddress, cfg.HTTPListenPort)
 
@@ -66,8 +99,12 @@ func Handler(logger log.Logger, cfg Config) (run func() error, stop func(error))
 	}
 
 	return func() error {
-			_ = level.Info(logger).Log("msg", "Starting internal server", "addr", addr)
-			return internalServer.ListenAndServe()
+			l, err := server.Listen(cfg.Listen, "internal", "tcp", addr)
+			if err != nil {
+				return err
+			}
+			_ = level.Info(logger).Log("msg", "Starting internal server", "addr", l.Addr().String())
+			return internalServer.Serve(l)
 		},
 		func(_ error) {
 			ctx, cancel := context.WithTimeout(context.Background(), cfg.ServerGracefulShutdownTimeout)
@@ -80,3 +117,57 @@ func Handler(logger log.Logger, cfg Config) (run func() error, stop func(error))
 			level.Info(logger).Log("msg", "Server shut down correctly")
 		}
 }
+
+// newMux returns the handler of the endpoints of the internal server.
+func newMux(logger log.Logger, cfg Config) (*http.ServeMux, error) {
+	metrics, err := metricsHandler(cfg.Metrics, prometheus.DefaultGatherer)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+
+	mux.Handle("/healthz", http.HandlerFunc(NewReadinessHandler(cfg.ReadinessProvider, logger)))
+
+	// Pprof.
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	admin := map[string]http.Handler{
+		"/debug/inflight":   cfg.InflightRequests,
+		"/debug/log-levels": cfg.LogLevels,
+		"/ring":             cfg.Ring,
+	}
+	for path, handler := range admin {
+		if handler == nil {
+			continue
+		}
+		if !cfg.AdminToken.IsSet() {
+			level.Info(logger).Log("msg", "Not serving admin endpoint without an admin token", "path", path)
+			continue
+		}
+		mux.Handle(path, requireAdminToken(cfg.AdminToken, handler))
+	}
+	return mux, nil
+}
+
+// requireAdminToken rejects the requests without token as bearer token.
+func requireAdminToken(token secrets.Secret, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want, err := token.Get(r.Context())
+		if err != nil {
+			http.Error(w, "can't read admin token", http.StatusInternalServerError)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if want == "" || !ok || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing admin token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
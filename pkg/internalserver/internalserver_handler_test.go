@@ -0,0 +1,66 @@
+package internalserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+)
+
+func TestRequireAdminToken(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	var token secrets.Secret
+	require.NoError(t, token.Set("secret"))
+
+	for name, tc := range map[string]struct {
+		authorization string
+		expected      int
+	}{
+		"valid token":   {authorization: "Bearer secret", expected: http.StatusNoContent},
+		"invalid token": {authorization: "Bearer other", expected: http.StatusUnauthorized},
+		"missing token": {expected: http.StatusUnauthorized},
+		"basic auth":    {authorization: "Basic c2VjcmV0", expected: http.StatusUnauthorized},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/debug/inflight", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			requireAdminToken(token, next).ServeHTTP(rec, req)
+			require.Equal(t, tc.expected, rec.Code)
+		})
+	}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	admin := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	serve := func(cfg Config, path, authorization string) int {
+		mux, err := newMux(log.NewNopLogger(), cfg)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	cfg := Config{InflightRequests: admin, LogLevels: admin, Ring: admin}
+	for _, path := range []string{"/debug/inflight", "/debug/log-levels", "/ring"} {
+		require.Equal(t, http.StatusNotFound, serve(cfg, path, ""), "%s is served without admin token", path)
+	}
+
+	require.NoError(t, cfg.AdminToken.Set("secret"))
+	for _, path := range []string{"/debug/inflight", "/debug/log-levels", "/ring"} {
+		require.Equal(t, http.StatusUnauthorized, serve(cfg, path, ""), path)
+		require.Equal(t, http.StatusNoContent, serve(cfg, path, "Bearer secret"), path)
+	}
+}
@@ -0,0 +1,167 @@
+package internalserver
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+)
+
+// MetricsConfig protects /metrics, for deployments exposing the internal
+// server to networks they don't trust, and filters the metric families it
+// serves.
+type MetricsConfig struct {
+	BasicAuthUsername string                 `yaml:"basic_auth_username"`
+	BasicAuthPassword secrets.Secret         `yaml:"basic_auth_password"`
+	AllowedNetworks   flagext.StringSliceCSV `yaml:"allowed_networks"`
+	// ExcludedFamilies are regular expressions of the names of the metric
+	// families left out of /metrics, eg. high cardinality ones.
+	ExcludedFamilies flagext.StringSliceCSV `yaml:"excluded_families"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *MetricsConfig) RegisterFlags(flags *flag.FlagSet) {
+	cfg.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (cfg *MetricsConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.StringVar(&cfg.BasicAuthUsername, prefix+"metrics.basic-auth-username", "", "Username required with basic auth by /metrics. If empty, /metrics is served without authentication.")
+	flags.Var(&cfg.BasicAuthPassword, prefix+"metrics.basic-auth-password", "Password required with basic auth by /metrics, along with the username. Either the password, or file:<path>, env:<name> or vault:<path>#<key> to read it from.")
+	flags.Var(&cfg.AllowedNetworks, prefix+"metrics.allowed-networks", "Comma separated list of the networks, in CIDR notation, /metrics is served to. If empty, it's served to any address.")
+	flags.Var(&cfg.ExcludedFamilies, prefix+"metrics.excluded-families", "Comma separated list of regular expressions of the names of the metric families left out of /metrics, eg. high cardinality ones. They are anchored to the whole name.")
+}
+
+// Validate checks the networks and regular expressions can be parsed.
+func (cfg *MetricsConfig) Validate() error {
+	if (cfg.BasicAuthUsername != "") != cfg.BasicAuthPassword.IsSet() {
+		return errors.New("metrics basic auth requires both a username and a password")
+	}
+	if _, err := cfg.networks(); err != nil {
+		return err
+	}
+	if _, err := cfg.excluded(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cfg *MetricsConfig) networks() ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, 0, len(cfg.AllowedNetworks))
+	for _, s := range cfg.AllowedNetworks {
+		network, err := netip.ParsePrefix(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid metrics allowed network %q: %w", s, err)
+		}
+		networks = append(networks, network.Masked())
+	}
+	return networks, nil
+}
+
+// excluded returns the regular expression matching the excluded families, or
+// nil if none is.
+func (cfg *MetricsConfig) excluded() (*regexp.Regexp, error) {
+	if len(cfg.ExcludedFamilies) == 0 {
+		return nil, nil
+	}
+	for _, s := range cfg.ExcludedFamilies {
+		if _, err := regexp.Compile(s); err != nil {
+			return nil, fmt.Errorf("invalid metrics excluded family %q: %w", s, err)
+		}
+	}
+	return regexp.Compile("^(?:" + strings.Join(cfg.ExcludedFamilies, "|") + ")$")
+}
+
+// metricsHandler serves the metrics of gatherer, protected and filtered as
+// configured in cfg.
+func metricsHandler(cfg MetricsConfig, gatherer prometheus.Gatherer) (http.Handler, error) {
+	networks, err := cfg.networks()
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := cfg.excluded()
+	if err != nil {
+		return nil, err
+	}
+	if excluded != nil {
+		gatherer = filteringGatherer{Gatherer: gatherer, excluded: excluded}
+	}
+	next := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+
+	username, password := cfg.BasicAuthUsername, cfg.BasicAuthPassword
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(networks) > 0 && !allowedAddr(networks, r.RemoteAddr) {
+			http.Error(w, "address not allowed", http.StatusForbidden)
+			return
+		}
+		if username != "" {
+			// The password is read for every request, to pick up rotations.
+			password, err := password.Get(r.Context())
+			if err != nil {
+				http.Error(w, "can't read metrics password", http.StatusInternalServerError)
+				return
+			}
+			u, p, ok := r.BasicAuth()
+			if !ok || subtle.ConstantTimeCompare([]byte(u), []byte(username)) != 1 || subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+				http.Error(w, "invalid or missing credentials", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+// allowedAddr returns whether the host of addr is in one of networks.
+func allowedAddr(networks []netip.Prefix, addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// filteringGatherer leaves out the metric families whose name matches
+// excluded.
+type filteringGatherer struct {
+	prometheus.Gatherer
+	excluded *regexp.Regexp
+}
+
+func (g filteringGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	filtered := families[:0]
+	for _, f := range families {
+		if !g.excluded.MatchString(f.GetName()) {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered, err
+}
@@ -0,0 +1,114 @@
+package internalserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_total", Help: "Requests."}),
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "tenant_requests_total", Help: "Requests by tenant."}),
+	)
+
+	for name, tc := range map[string]struct {
+		cfg        MetricsConfig
+		remoteAddr string
+		username   string
+		password   string
+		expected   int
+		excluded   bool
+	}{
+		"open": {
+			expected: http.StatusOK,
+		},
+		"excluded families": {
+			cfg:      MetricsConfig{ExcludedFamilies: flagext.StringSliceCSV{"tenant_.*"}},
+			expected: http.StatusOK,
+			excluded: true,
+		},
+		"valid credentials": {
+			cfg:      MetricsConfig{BasicAuthUsername: "prometheus", BasicAuthPassword: secretWithValue(t, "secret")},
+			username: "prometheus",
+			password: "secret",
+			expected: http.StatusOK,
+		},
+		"invalid credentials": {
+			cfg:      MetricsConfig{BasicAuthUsername: "prometheus", BasicAuthPassword: secretWithValue(t, "secret")},
+			username: "prometheus",
+			password: "other",
+			expected: http.StatusUnauthorized,
+		},
+		"missing credentials": {
+			cfg:      MetricsConfig{BasicAuthUsername: "prometheus", BasicAuthPassword: secretWithValue(t, "secret")},
+			expected: http.StatusUnauthorized,
+		},
+		"allowed network": {
+			cfg:        MetricsConfig{AllowedNetworks: flagext.StringSliceCSV{"10.0.0.0/8", "::1/128"}},
+			remoteAddr: "10.1.2.3:1234",
+			expected:   http.StatusOK,
+		},
+		"allowed ipv6 address": {
+			cfg:        MetricsConfig{AllowedNetworks: flagext.StringSliceCSV{"10.0.0.0/8", "::1/128"}},
+			remoteAddr: "[::1]:1234",
+			expected:   http.StatusOK,
+		},
+		"other network": {
+			cfg:        MetricsConfig{AllowedNetworks: flagext.StringSliceCSV{"10.0.0.0/8"}},
+			remoteAddr: "192.168.1.1:1234",
+			expected:   http.StatusForbidden,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, tc.cfg.Validate())
+			handler, err := metricsHandler(tc.cfg, reg)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tc.remoteAddr != "" {
+				req.RemoteAddr = tc.remoteAddr
+			}
+			if tc.username != "" {
+				req.SetBasicAuth(tc.username, tc.password)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tc.expected, rec.Code)
+			if tc.expected != http.StatusOK {
+				return
+			}
+
+			body, err := io.ReadAll(rec.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), "\nrequests_total 0")
+			if tc.excluded {
+				require.NotContains(t, string(body), "tenant_requests_total")
+			} else {
+				require.Contains(t, string(body), "tenant_requests_total")
+			}
+		})
+	}
+}
+
+func TestMetricsConfigValidate(t *testing.T) {
+	require.NoError(t, (&MetricsConfig{BasicAuthUsername: "prometheus", BasicAuthPassword: secretWithValue(t, "secret")}).Validate())
+	require.Error(t, (&MetricsConfig{BasicAuthPassword: secretWithValue(t, "secret")}).Validate())
+	require.Error(t, (&MetricsConfig{BasicAuthUsername: "prometheus"}).Validate())
+	require.Error(t, (&MetricsConfig{AllowedNetworks: flagext.StringSliceCSV{"10.0.0.1"}}).Validate())
+	require.Error(t, (&MetricsConfig{ExcludedFamilies: flagext.StringSliceCSV{"tenant_("}}).Validate())
+}
+
+func secretWithValue(t *testing.T, value string) secrets.Secret {
+	var secret secrets.Secret
+	require.NoError(t, secret.Set(value))
+	return secret
+}
@@ -0,0 +1,89 @@
+package remoteread
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+)
+
+const (
+	defaultAPIKeyParam = "api_key"
+
+	redactedAPIKey = "redacted"
+)
+
+// withAPIKey returns rt sending the API key of c in the c.APIKeyParam query
+// parameter of the requests, or rt itself if there's no API key.
+func (c *Config) withAPIKey(rt http.RoundTripper) http.RoundTripper {
+	if !c.APIKey.IsSet() {
+		return rt
+	}
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return apiKeyRoundTripper{next: rt, cfg: *c}
+}
+
+// apiKeyRoundTripper sets the API key of cfg as a query parameter of the
+// requests it sends, for the legacy gateways reading the credentials there
+// rather than in a header. The key is read for every request, to pick up
+// rotations, and is redacted from the errors.
+type apiKeyRoundTripper struct {
+	next http.RoundTripper
+	cfg  Config
+}
+
+func (t apiKeyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, err := t.cfg.APIKey.Get(req.Context())
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read the read API key")
+	}
+	req = req.Clone(req.Context())
+	query := req.URL.Query()
+	query.Set(t.cfg.APIKeyParam, key)
+	req.URL.RawQuery = query.Encode()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, redactedError{err: err, key: key}
+	}
+	return resp, nil
+}
+
+// basicAuthRoundTripper sends the requests with basic auth. The password is
+// read for every request, to pick up rotations.
+type basicAuthRoundTripper struct {
+	next     http.RoundTripper
+	username string
+	password secrets.Secret
+}
+
+func (t basicAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	password, err := t.password.Get(req.Context())
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read the read API key")
+	}
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(t.username, password)
+	return t.next.RoundTrip(req)
+}
+
+// redactedError is err with the occurrences of key redacted from its message,
+// eg. the URLs of the requests with the key as a query parameter.
+type redactedError struct {
+	err error
+	key string
+}
+
+func (e redactedError) Error() string {
+	if e.key == "" {
+		return e.err.Error()
+	}
+	return strings.ReplaceAll(e.err.Error(), e.key, redactedAPIKey)
+}
+
+func (e redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,66 @@
+package remoteread
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKey(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.URL.Query().Get("token"))
+		_, _ = w.Write([]byte(`{"status": "success", "data": ["job"]}`))
+	}))
+	defer srv.Close()
+
+	cfg := Config{Endpoint: srv.URL + "/prometheus", Timeout: time.Second, APIKeyParam: "token"}
+	require.NoError(t, cfg.APIKey.Set("s3cr3t"))
+	ctx := user.InjectOrgID(context.Background(), "12345")
+
+	client, err := NewLabelsClient(cfg)
+	require.NoError(t, err)
+	values, err := client.LabelValues(ctx, "job", time.UnixMilli(0), time.UnixMilli(1000))
+	require.NoError(t, err)
+	require.Equal(t, []string{"job"}, values)
+
+	q, _, err := NewQueryable(cfg, "test", prometheus.NewPedanticRegistry(), log.NewNopLogger())
+	require.NoError(t, err)
+	querier, err := q.Querier(0, 1000)
+	require.NoError(t, err)
+	defer querier.Close()
+	set := querier.Select(ctx, true, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up"))
+	for set.Next() {
+	}
+	require.Equal(t, []string{"s3cr3t", "s3cr3t"}, keys)
+
+	t.Run("redacted from errors", func(t *testing.T) {
+		cfg := cfg
+		cfg.HTTPClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, &url.Error{Op: "Get", URL: req.URL.String(), Err: errors.New("connection refused")}
+		})}
+		client, err := NewLabelsClient(cfg)
+		require.NoError(t, err)
+		_, err = client.LabelValues(ctx, "job", time.UnixMilli(0), time.UnixMilli(1000))
+		require.ErrorContains(t, err, "token=redacted")
+		require.NotContains(t, err.Error(), "s3cr3t")
+		var urlErr *url.Error
+		require.ErrorAs(t, err, &urlErr)
+	})
+
+	t.Run("requires a parameter", func(t *testing.T) {
+		cfg := cfg
+		cfg.APIKeyParam = ""
+		require.ErrorContains(t, cfg.Validate(), "query parameter")
+	})
+}
@@ -0,0 +1,60 @@
+package remoteread
+
+import (
+	"context"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// CancelableSeriesSet is the storage.SeriesSet of the selects of the
+// queryables of NewRemoteReadQueryable, and of NewNotFoundAsEmptyQueryable
+// and NewConnTracedQueryable wrapping them. Cancel stops reading the
+// response, eg. when the request it was selected for is cancelled while the series are
+// still streamed: the pending Next calls return false, and Err the
+// cancellation error.
+type CancelableSeriesSet interface {
+	storage.SeriesSet
+	Cancel()
+}
+
+// cancelableQueryable selects with a context cancelled by the Cancel method
+// of the series set, or once the series set has been read.
+type cancelableQueryable struct {
+	storage.Queryable
+}
+
+func (q cancelableQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	querier, err := q.Queryable.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	return cancelableQuerier{Querier: querier}, nil
+}
+
+type cancelableQuerier struct {
+	storage.Querier
+}
+
+func (q cancelableQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	ctx, cancel := context.WithCancel(ctx)
+	set := q.Querier.Select(ctx, sortSeries, hints, matchers...)
+	return &cancelableSeriesSet{SeriesSet: set, cancel: cancel}
+}
+
+type cancelableSeriesSet struct {
+	storage.SeriesSet
+	cancel context.CancelFunc
+}
+
+func (s *cancelableSeriesSet) Next() bool {
+	if s.SeriesSet.Next() {
+		return true
+	}
+	s.cancel()
+	return false
+}
+
+func (s *cancelableSeriesSet) Cancel() {
+	s.cancel()
+}
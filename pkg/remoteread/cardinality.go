@@ -0,0 +1,104 @@
+package remoteread
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// The count methods of the cardinality API.
+const (
+	// CountMethodInMemory counts the series in the ingesters' memory.
+	CountMethodInMemory = "inmemory"
+	// CountMethodActive counts only the series written recently.
+	CountMethodActive = "active"
+)
+
+// CardinalityRequest selects the series the cardinality is computed for.
+type CardinalityRequest struct {
+	// Selector is a series selector, eg. {job="graphite"}. Empty selects all
+	// the series of the tenant.
+	Selector string
+	// Limit is the max number of items returned, 0 for Mimir's default.
+	Limit int
+	// CountMethod is CountMethodInMemory or CountMethodActive, empty for
+	// Mimir's default.
+	CountMethod string
+}
+
+func (r CardinalityRequest) query() url.Values {
+	query := url.Values{}
+	if r.Selector != "" {
+		query.Set("selector", r.Selector)
+	}
+	if r.Limit > 0 {
+		query.Set("limit", strconv.Itoa(r.Limit))
+	}
+	if r.CountMethod != "" {
+		query.Set("count_method", r.CountMethod)
+	}
+	return query
+}
+
+type LabelNamesCardinality struct {
+	LabelValuesCountTotal int                    `json:"label_values_count_total"`
+	LabelNamesCount       int                    `json:"label_names_count"`
+	Cardinality           []LabelNameCardinality `json:"cardinality"`
+}
+
+type LabelNameCardinality struct {
+	LabelName        string `json:"label_name"`
+	LabelValuesCount int    `json:"label_values_count"`
+}
+
+type LabelValuesCardinality struct {
+	SeriesCountTotal int                `json:"series_count_total"`
+	Labels           []LabelCardinality `json:"labels"`
+}
+
+type LabelCardinality struct {
+	LabelName        string                  `json:"label_name"`
+	LabelValuesCount int                     `json:"label_values_count"`
+	SeriesCount      int                     `json:"series_count"`
+	Cardinality      []LabelValueCardinality `json:"cardinality"`
+}
+
+type LabelValueCardinality struct {
+	LabelValue  string `json:"label_value"`
+	SeriesCount int    `json:"series_count"`
+}
+
+// CardinalityClient queries Mimir's cardinality analysis API for the tenant
+// of the request context, eg. to estimate the cost of a query before running
+// it. Errors are errorx errors.
+type CardinalityClient struct {
+	client *client
+}
+
+func NewCardinalityClient(cfg Config) (*CardinalityClient, error) {
+	c, err := newClient(cfg, "Cardinality")
+	if err != nil {
+		return nil, err
+	}
+	return &CardinalityClient{client: c}, nil
+}
+
+// LabelNames returns the label names of the selected series, with their
+// number of values, from the ones with the most values.
+func (c *CardinalityClient) LabelNames(ctx context.Context, req CardinalityRequest) (LabelNamesCardinality, error) {
+	var resp LabelNamesCardinality
+	err := c.client.get(ctx, "/api/v1/cardinality/label_names", req.query(), &resp)
+	return resp, err
+}
+
+// LabelValues returns the number of selected series of each value of the
+// given labels.
+func (c *CardinalityClient) LabelValues(ctx context.Context, labelNames []string, req CardinalityRequest) (LabelValuesCardinality, error) {
+	query := req.query()
+	for _, name := range labelNames {
+		query.Add("label_names[]", name)
+	}
+	var resp LabelValuesCardinality
+	err := c.client.get(ctx, "/api/v1/cardinality/label_values", query, &resp)
+	return resp, err
+}
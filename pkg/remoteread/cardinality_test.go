@@ -0,0 +1,91 @@
+package remoteread
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+func TestCardinalityClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(user.OrgIDHeaderName) != "12345" {
+			http.Error(w, "no org id", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/prometheus/api/v1/cardinality/label_names":
+			require.Equal(t, `{job="graphite"}`, r.URL.Query().Get("selector"))
+			require.Equal(t, "10", r.URL.Query().Get("limit"))
+			_, _ = w.Write([]byte(`{"label_values_count_total": 3, "label_names_count": 2, "cardinality": [
+				{"label_name": "__name__", "label_values_count": 2},
+				{"label_name": "job", "label_values_count": 1}
+			]}`))
+		case "/prometheus/api/v1/cardinality/label_values":
+			require.Equal(t, []string{"job"}, r.URL.Query()["label_names[]"])
+			require.Equal(t, CountMethodActive, r.URL.Query().Get("count_method"))
+			_, _ = w.Write([]byte(`{"series_count_total": 5, "labels": [
+				{"label_name": "job", "label_values_count": 1, "series_count": 5, "cardinality": [{"label_value": "graphite", "series_count": 5}]}
+			]}`))
+		default:
+			w.Header().Set("Retry-After", "2")
+			http.Error(w, "too many requests (err-mimir-tenant-max-request-rate)", http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewCardinalityClient(Config{Endpoint: srv.URL + "/prometheus/", Timeout: time.Second})
+	require.NoError(t, err)
+	ctx := user.InjectOrgID(context.Background(), "12345")
+
+	names, err := client.LabelNames(ctx, CardinalityRequest{Selector: `{job="graphite"}`, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, LabelNamesCardinality{
+		LabelValuesCountTotal: 3,
+		LabelNamesCount:       2,
+		Cardinality: []LabelNameCardinality{
+			{LabelName: "__name__", LabelValuesCount: 2},
+			{LabelName: "job", LabelValuesCount: 1},
+		},
+	}, names)
+
+	values, err := client.LabelValues(ctx, []string{"job"}, CardinalityRequest{CountMethod: CountMethodActive})
+	require.NoError(t, err)
+	require.Equal(t, LabelValuesCardinality{
+		SeriesCountTotal: 5,
+		Labels: []LabelCardinality{{
+			LabelName:        "job",
+			LabelValuesCount: 1,
+			SeriesCount:      5,
+			Cardinality:      []LabelValueCardinality{{LabelValue: "graphite", SeriesCount: 5}},
+		}},
+	}, values)
+
+	t.Run("errors are translated", func(t *testing.T) {
+		client, err := NewCardinalityClient(Config{Endpoint: srv.URL, Timeout: time.Second})
+		require.NoError(t, err)
+
+		_, err = client.LabelNames(ctx, CardinalityRequest{})
+		var tooMany errorx.TooManyRequests
+		require.ErrorAs(t, err, &tooMany)
+		require.Equal(t, 2*time.Second, tooMany.RetryAfter)
+		require.Equal(t, "err-mimir-tenant-max-request-rate", tooMany.Limit)
+	})
+
+	t.Run("requests without a tenant are rejected", func(t *testing.T) {
+		_, err := client.LabelNames(context.Background(), CardinalityRequest{})
+		require.ErrorAs(t, err, &errorx.BadRequest{})
+	})
+}
+
+func TestConfigValidate(t *testing.T) {
+	require.NoError(t, (&Config{Endpoint: "http://mimir/prometheus", Timeout: time.Second}).Validate())
+	require.ErrorContains(t, (&Config{Endpoint: "/prometheus", Timeout: time.Second}).Validate(), "must be an absolute http or https URL")
+	require.ErrorContains(t, (&Config{Endpoint: "http://mimir/prometheus"}).Validate(), "read timeout must be positive")
+}
@@ -0,0 +1,288 @@
+package remoteread
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	"github.com/pkg/errors"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/storage/remote"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon"
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+const (
+	defaultReadTimeout = 30 * time.Second
+
+	// maxErrBodyLen is how much of the body of error responses is read.
+	maxErrBodyLen = 512
+)
+
+type Config struct {
+	// Endpoint is the base URL of Mimir's Prometheus API, eg.
+	// http://mimir/prometheus. With a dns+ prefix, eg.
+	// dns+http://query-frontend:8080/prometheus, the connections are balanced
+	// over the addresses of its host, resolved every DNSRefreshInterval.
+	Endpoint           string        `yaml:"endpoint"`
+	Timeout            time.Duration `yaml:"timeout"`
+	DNSRefreshInterval time.Duration `yaml:"dns_refresh_interval"`
+	// MaxChunkedFrameBytes is the max size of the frames of the streamed
+	// remote read responses, the STREAMED_XOR_CHUNKS ones NewQueryable asks
+	// for first so their series are read as they arrive. 0 is Prometheus'
+	// default.
+	MaxChunkedFrameBytes uint64 `yaml:"max_chunked_frame_bytes"`
+	// QueryShards, if more than 1, is the number of parallel remote read
+	// requests the selects of NewQueryable are split into, each reading a
+	// shard of the series with Mimir's query sharding label.
+	QueryShards int `yaml:"query_shards"`
+
+	StepAlignment StepAlignmentConfig `yaml:"step_alignment"`
+	Prefetch      PrefetchConfig      `yaml:"prefetch"`
+	QueryLimits   QueryLimitsConfig   `yaml:"query_limits"`
+	Retry         RetryConfig         `yaml:"retry"`
+	Spill         SpillConfig         `yaml:"spill"`
+	SlowReadLog   SlowReadLogConfig   `yaml:"slow_read_log"`
+
+	// LabelNameValidation is how the label names of the reads of NewQueryable
+	// are checked, one of the LabelNameValidation constants.
+	LabelNameValidation string `yaml:"label_name_validation"`
+
+	// LabelValuesFallbackLimit is the max number of values LabelsClient
+	// filters itself when Mimir rejects the matchers of a request.
+	LabelValuesFallbackLimit int `yaml:"label_values_fallback_limit"`
+
+	// APIKey, if set, is sent in the APIKeyParam query parameter of all the
+	// requests, for the legacy gateways reading the credentials there rather
+	// than in a header. It's redacted from the errors.
+	APIKey      secrets.Secret `yaml:"api_key"`
+	APIKeyParam string         `yaml:"api_key_param"`
+
+	// ClientName is sent in the X-Client-Name header of all the requests, so
+	// the downstream can attribute its load to this client.
+	ClientName string `yaml:"client_name"`
+	// QuerySourceHeader is the header the query source of the contexts of
+	// ContextWithQuerySource is sent in, eg. the UID of a dashboard.
+	QuerySourceHeader string `yaml:"query_source_header"`
+
+	// HTTPClient, if set, sends the requests instead of a traced client of
+	// the default transport, eg. to share the connections of the app's
+	// appcommon.HTTPClientFactory. It can't be used with a dns+ endpoint.
+	HTTPClient *http.Client `yaml:"-"`
+	// ConnTrace, if set, measures the phases of the requests, by the host of
+	// the endpoint.
+	ConnTrace *ConnTraceMetrics `yaml:"-"`
+	// QueryStats, if set, measures the query statistics Mimir reports in the
+	// responses. They're collected in the contexts of ContextWithQueryStats
+	// either way.
+	QueryStats *QueryStatsMetrics `yaml:"-"`
+	// ReadClient, if set, sends the remote read requests of NewQueryable
+	// instead of a client of the endpoint, eg. one resolving or pinning the
+	// connections its own way. It sends them with the org ID of their
+	// context itself; HTTPClient, Retry and QueryStats don't apply to it.
+	ReadClient remote.ReadClient `yaml:"-"`
+}
+
+// RegisterFlags implements flagext.Registerer
+func (c *Config) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *Config) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.StringVar(&c.Endpoint, prefix+"read-endpoint", "", "Base URL of the upstream Prometheus API of Mimir, e.g. http://mimir/prometheus. Prefix it with dns+, e.g. dns+http://query-frontend:8080/prometheus, to balance the connections over the addresses of its host, skipping the ones failing to connect.")
+	flags.DurationVar(&c.Timeout, prefix+"read-timeout", defaultReadTimeout, "Timeout for reads from the upstream Prometheus API of Mimir.")
+	flags.Uint64Var(&c.MaxChunkedFrameBytes, prefix+"read-max-chunked-frame-bytes", promconfig.DefaultChunkedReadLimit, "Max size of a frame of the streamed remote read responses. A series whose chunks don't fit in a frame fails the read.")
+	flags.IntVar(&c.QueryShards, prefix+"read-query-shards", 0, "Number of parallel remote read requests each read is split into, each reading a shard of the series with Mimir's __query_shard__ label. 0 or 1 to send a single request.")
+	flags.DurationVar(&c.DNSRefreshInterval, prefix+"read-dns-refresh-interval", defaultDNSRefreshInterval, "How often the addresses of the host of a dns+ read endpoint are resolved again.")
+	c.StepAlignment.RegisterFlagsWithPrefix(prefix, flags)
+	c.Prefetch.RegisterFlagsWithPrefix(prefix, flags)
+	c.QueryLimits.RegisterFlagsWithPrefix(prefix, flags)
+	c.Retry.RegisterFlagsWithPrefix(prefix, flags)
+	c.Spill.RegisterFlagsWithPrefix(prefix, flags)
+	c.SlowReadLog.RegisterFlagsWithPrefix(prefix, flags)
+	flags.StringVar(&c.LabelNameValidation, prefix+"read-label-name-validation", LabelNameValidationAuto, fmt.Sprintf("How the label names of the reads are checked before sending them: %q fails the reads of names that aren't valid legacy Prometheus label names, %q sends them as they are, for downstreams accepting UTF-8 label names, %q does as %q unless the build info of the downstream reports version 3 or later.", LabelNameValidationLegacy, LabelNameValidationNone, LabelNameValidationAuto, LabelNameValidationLegacy))
+	flags.IntVar(&c.LabelValuesFallbackLimit, prefix+"read-label-values-fallback-limit", defaultLabelValuesFallbackLimit, "Max number of label values read without matchers and filtered client-side, when Mimir rejects the matchers of a label values request. 0 for no limit.")
+	flags.Var(&c.APIKey, prefix+"read-api-key", "API key sent in a query parameter of the read requests, for legacy gateways reading it there rather than in a header. Either the key, or file:<path>, env:<name> or vault:<path>#<key> to read it from.")
+	flags.StringVar(&c.APIKeyParam, prefix+"read-api-key-param", defaultAPIKeyParam, "Name of the query parameter the read API key is sent in.")
+	flags.StringVar(&c.ClientName, prefix+"read-client-name", defaultClientName, "Name of this client sent in the X-Client-Name header of the read requests. Empty to not send it.")
+	flags.StringVar(&c.QuerySourceHeader, prefix+"read-query-source-header", defaultQuerySourceHeader, "Header of the read requests carrying the source of the query given by the caller, e.g. a dashboard UID or an alert rule ID. Empty to not send it.")
+}
+
+// Validate checks that the config describes a usable read endpoint.
+func (c *Config) Validate() error {
+	endpoint, err := c.endpointURL()
+	if err != nil {
+		return errors.Wrap(err, "invalid read endpoint")
+	}
+	if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return errors.Errorf("read endpoint %q must be an absolute http or https URL", c.Endpoint)
+	}
+	if _, dns := splitDNSEndpoint(c.Endpoint); dns {
+		if _, _, err := net.SplitHostPort(endpoint.Host); err != nil {
+			return errors.Errorf("dns+ read endpoint %q must have a port", c.Endpoint)
+		}
+		if c.DNSRefreshInterval <= 0 {
+			return errors.New("read DNS refresh interval must be positive")
+		}
+	}
+	if c.Timeout <= 0 {
+		return errors.New("read timeout must be positive")
+	}
+	if c.QueryShards < 0 {
+		return errors.New("read query shards can't be negative")
+	}
+	switch c.LabelNameValidation {
+	case "", LabelNameValidationAuto, LabelNameValidationLegacy, LabelNameValidationNone:
+	default:
+		return errors.Errorf("unknown read label name validation %q", c.LabelNameValidation)
+	}
+	if c.APIKey.IsSet() && c.APIKeyParam == "" {
+		return errors.New("read API key requires a query parameter name")
+	}
+	if c.LabelValuesFallbackLimit < 0 {
+		return errors.New("read label values fallback limit can't be negative")
+	}
+	if err := c.StepAlignment.Validate(); err != nil {
+		return err
+	}
+	if err := c.Prefetch.Validate(); err != nil {
+		return err
+	}
+	if err := c.QueryLimits.Validate(); err != nil {
+		return err
+	}
+	if err := c.Retry.Validate(); err != nil {
+		return err
+	}
+	if err := c.Spill.Validate(); err != nil {
+		return err
+	}
+	return c.SlowReadLog.Validate()
+}
+
+// client sends the requests of the tenant of their context to the endpoints
+// of Mimir's API.
+type client struct {
+	cfg        Config
+	endpoint   *url.URL
+	httpClient *http.Client
+}
+
+func newClient(cfg Config, name string) (*client, error) {
+	endpoint, err := cfg.endpointURL()
+	if err != nil {
+		return nil, err
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		transport, err := cfg.transport()
+		if err != nil {
+			return nil, err
+		}
+		httpClient = &http.Client{Transport: appcommon.NewTracedAuthRoundTripper(cfg.withAPIKey(transport), name)}
+	} else if _, dns := splitDNSEndpoint(cfg.Endpoint); dns {
+		return nil, errors.New("dns+ read endpoints can't be used with a custom HTTP client")
+	} else if cfg.APIKey.IsSet() {
+		withAPIKey := *httpClient
+		withAPIKey.Transport = cfg.withAPIKey(httpClient.Transport)
+		httpClient = &withAPIKey
+	}
+	return &client{cfg: cfg, endpoint: endpoint, httpClient: httpClient}, nil
+}
+
+// endpointURL returns the URL of c.Endpoint, without its dns+ prefix.
+func (c *Config) endpointURL() (*url.URL, error) {
+	endpoint, _ := splitDNSEndpoint(c.Endpoint)
+	return url.Parse(endpoint)
+}
+
+// transport returns the transport of the requests to c.Endpoint, balancing
+// the connections over the addresses of its host for dns+ endpoints.
+func (c *Config) transport() (http.RoundTripper, error) {
+	endpoint, dns := splitDNSEndpoint(c.Endpoint)
+	if !dns {
+		return http.DefaultTransport, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return newDNSTransport(u.Host, c.DNSRefreshInterval)
+}
+
+// get sends a GET request for the given API path and decodes the JSON
+// response into out. Non-2xx responses are translated into errorx errors,
+// errorx.PartialData for the blocks that couldn't be read. The requests
+// failing with network errors or transient 5xx responses are retried as
+// configured in cfg.Retry.
+func (c *client) get(ctx context.Context, apiPath string, query url.Values, out interface{}) error {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + apiPath
+	u.RawQuery = query.Encode()
+
+	return c.cfg.Retry.retry(ctx, func() (bool, error) {
+		return c.getOnce(ctx, u.String(), query, out)
+	})
+}
+
+// getOnce sends a single GET request to reqURL, reporting whether its error
+// is worth retrying.
+func (c *client) getOnce(ctx context.Context, reqURL string, query url.Values, out interface{}) (retryable bool, _ error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+	if c.cfg.ConnTrace != nil {
+		ctx = c.cfg.ConnTrace.withClientTrace(ctx, c.endpoint.Host)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return false, errorx.Internal{Msg: "can't create read request", Err: err}
+	}
+	appcommon.InjectDeadlineIntoHTTPRequest(ctx, req)
+	setAttributionHeaders(ctx, req, c.cfg)
+	if err := user.InjectOrgIDIntoHTTPRequest(ctx, req); err != nil {
+		return false, errorx.BadRequest{Msg: "can't set org ID on read request", Err: err}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return false, errorx.RequestTimeout{Msg: "read request timed out", Err: err}
+		}
+		return ctx.Err() == nil, errorx.Internal{Msg: "can't perform read request", Err: err}
+	}
+	recordQueryStats(ctx, resp.Header, c.cfg.QueryStats)
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrBodyLen))
+		err := errors.Errorf("read API returned HTTP status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		if isPartialData(string(body)) {
+			return false, asPartialData(err, parseAPITime(query.Get("start")), parseAPITime(query.Get("end")))
+		}
+		retryable := resp.StatusCode == http.StatusInternalServerError || errorx.IsUnavailableStatus(resp.StatusCode)
+		return retryable, errorx.FromHTTPResponse(resp, string(body), "failed reading from Mimir", err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return false, errorx.Internal{Msg: "can't decode read response", Err: err}
+	}
+	return false, nil
+}
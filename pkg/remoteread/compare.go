@@ -0,0 +1,193 @@
+package remoteread
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// The kinds of differences found by Compare.
+const (
+	// DiffMissingInA is a series only B has.
+	DiffMissingInA = "missing_in_a"
+	// DiffMissingInB is a series only A has.
+	DiffMissingInB = "missing_in_b"
+	// DiffMissingSample is a sample of a series only one side has.
+	DiffMissingSample = "missing_sample"
+	// DiffValue is a sample with values further apart than the tolerance.
+	DiffValue = "value"
+	// DiffTimestampDrift is a sample with different timestamps on each side,
+	// within the max timestamp drift.
+	DiffTimestampDrift = "timestamp_drift"
+)
+
+// CompareConfig sets how close two series must be to match.
+type CompareConfig struct {
+	// Tolerance is the max relative difference of two values considered
+	// equal, eg. 0.01 for 1%. 0 requires them to be equal.
+	Tolerance float64
+	// MaxTimestampDrift is how far apart the timestamps of the samples of
+	// each side can be to be compared with each other, eg. because the
+	// sides align the points to different intervals.
+	MaxTimestampDrift time.Duration
+	// MaxDiffsPerSeries bounds the sample diffs reported per series. 0 for
+	// no limit.
+	MaxDiffsPerSeries int
+}
+
+// SeriesDiff is a difference between the two sides of Compare. The sample
+// fields are set for the sample kinds only, the values are formatted as
+// strings so NaNs can be reported as JSON.
+type SeriesDiff struct {
+	Series     string `json:"series"`
+	Kind       string `json:"kind"`
+	TimestampA int64  `json:"timestamp_a,omitempty"`
+	TimestampB int64  `json:"timestamp_b,omitempty"`
+	ValueA     string `json:"value_a,omitempty"`
+	ValueB     string `json:"value_b,omitempty"`
+}
+
+// CompareReport lists the differences found by Compare.
+type CompareReport struct {
+	SeriesA int `json:"series_a"`
+	SeriesB int `json:"series_b"`
+	// MatchingSeries is the number of series identical on both sides,
+	// within the tolerance.
+	MatchingSeries int          `json:"matching_series"`
+	Diffs          []SeriesDiff `json:"diffs"`
+}
+
+type sample struct {
+	t int64
+	v float64
+}
+
+// Compare runs the same query on a and b, eg. a Graphite cluster bridge and
+// Mimir during a migration, and reports the series missing on either side
+// and the samples that differ. Only float samples are compared.
+func Compare(ctx context.Context, a, b storage.Queryable, mint, maxt int64, cfg CompareConfig, matchers ...*labels.Matcher) (CompareReport, error) {
+	seriesA, err := selectSeries(ctx, a, mint, maxt, matchers)
+	if err != nil {
+		return CompareReport{}, fmt.Errorf("querying a: %w", err)
+	}
+	seriesB, err := selectSeries(ctx, b, mint, maxt, matchers)
+	if err != nil {
+		return CompareReport{}, fmt.Errorf("querying b: %w", err)
+	}
+
+	names := make([]string, 0, len(seriesA)+len(seriesB))
+	for name := range seriesA {
+		names = append(names, name)
+	}
+	for name := range seriesB {
+		if _, ok := seriesA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	report := CompareReport{SeriesA: len(seriesA), SeriesB: len(seriesB), Diffs: []SeriesDiff{}}
+	for _, name := range names {
+		samplesA, okA := seriesA[name]
+		samplesB, okB := seriesB[name]
+		switch {
+		case !okA:
+			report.Diffs = append(report.Diffs, SeriesDiff{Series: name, Kind: DiffMissingInA})
+		case !okB:
+			report.Diffs = append(report.Diffs, SeriesDiff{Series: name, Kind: DiffMissingInB})
+		default:
+			diffs := compareSamples(name, samplesA, samplesB, cfg)
+			if len(diffs) == 0 {
+				report.MatchingSeries++
+			}
+			report.Diffs = append(report.Diffs, diffs...)
+		}
+	}
+	return report, nil
+}
+
+func selectSeries(ctx context.Context, q storage.Queryable, mint, maxt int64, matchers []*labels.Matcher) (map[string][]sample, error) {
+	querier, err := q.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	defer querier.Close()
+
+	series := map[string][]sample{}
+	set := querier.Select(ctx, false, &storage.SelectHints{Start: mint, End: maxt}, matchers...)
+	var it chunkenc.Iterator
+	for set.Next() {
+		s := set.At()
+		it = s.Iterator(it)
+		var samples []sample
+		for typ := it.Next(); typ != chunkenc.ValNone; typ = it.Next() {
+			if typ == chunkenc.ValFloat {
+				t, v := it.At()
+				samples = append(samples, sample{t: t, v: v})
+			}
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+		series[s.Labels().String()] = samples
+	}
+	return series, set.Err()
+}
+
+// compareSamples pairs the samples of each side, both sorted by timestamp,
+// with the first sample of the other side within the max timestamp drift.
+func compareSamples(series string, a, b []sample, cfg CompareConfig) []SeriesDiff {
+	drift := cfg.MaxTimestampDrift.Milliseconds()
+	var diffs []SeriesDiff
+	add := func(d SeriesDiff) {
+		if cfg.MaxDiffsPerSeries <= 0 || len(diffs) < cfg.MaxDiffsPerSeries {
+			d.Series = series
+			diffs = append(diffs, d)
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i].t < b[j].t-drift):
+			add(SeriesDiff{Kind: DiffMissingSample, TimestampA: a[i].t, ValueA: formatValue(a[i].v)})
+			i++
+		case i == len(a) || b[j].t < a[i].t-drift:
+			add(SeriesDiff{Kind: DiffMissingSample, TimestampB: b[j].t, ValueB: formatValue(b[j].v)})
+			j++
+		default:
+			diff := SeriesDiff{TimestampA: a[i].t, TimestampB: b[j].t, ValueA: formatValue(a[i].v), ValueB: formatValue(b[j].v)}
+			if !valuesMatch(a[i].v, b[j].v, cfg.Tolerance) {
+				diff.Kind = DiffValue
+				add(diff)
+			} else if a[i].t != b[j].t {
+				diff.Kind = DiffTimestampDrift
+				add(diff)
+			}
+			i++
+			j++
+		}
+	}
+	return diffs
+}
+
+func valuesMatch(a, b, tolerance float64) bool {
+	if a == b {
+		return true
+	}
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return math.Abs(a-b) <= tolerance*math.Max(math.Abs(a), math.Abs(b))
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
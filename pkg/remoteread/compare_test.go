@@ -0,0 +1,72 @@
+package remoteread
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/util/teststorage"
+	"github.com/stretchr/testify/require"
+)
+
+func appendSamples(t *testing.T, s *teststorage.TestStorage, series map[string][]sample) {
+	app := s.Appender(context.Background())
+	for name, samples := range series {
+		for _, smpl := range samples {
+			_, err := app.Append(0, labels.FromStrings(labels.MetricName, name), smpl.t, smpl.v)
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, app.Commit())
+}
+
+func TestCompare(t *testing.T) {
+	a := teststorage.New(t)
+	defer a.Close()
+	b := teststorage.New(t)
+	defer b.Close()
+
+	appendSamples(t, a, map[string][]sample{
+		"same":          {{t: 1000, v: 1}, {t: 2000, v: math.NaN()}},
+		"only_in_a":     {{t: 1000, v: 1}},
+		"within_tol":    {{t: 1000, v: 100}},
+		"value":         {{t: 1000, v: 1}, {t: 2000, v: 2}},
+		"drift":         {{t: 1000, v: 1}},
+		"missing_point": {{t: 1000, v: 1}, {t: 2000, v: 2}},
+	})
+	appendSamples(t, b, map[string][]sample{
+		"same":          {{t: 1000, v: 1}, {t: 2000, v: math.NaN()}},
+		"only_in_b":     {{t: 1000, v: 1}},
+		"within_tol":    {{t: 1000, v: 100.5}},
+		"value":         {{t: 1000, v: 1}, {t: 2000, v: 3}},
+		"drift":         {{t: 1500, v: 1}},
+		"missing_point": {{t: 1000, v: 1}},
+	})
+
+	cfg := CompareConfig{Tolerance: 0.01, MaxTimestampDrift: 500 * time.Millisecond}
+	report, err := Compare(context.Background(), a, b, 0, 10000, cfg, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	require.NoError(t, err)
+
+	require.Equal(t, CompareReport{
+		SeriesA:        6,
+		SeriesB:        6,
+		MatchingSeries: 2,
+		Diffs: []SeriesDiff{
+			{Series: `{__name__="drift"}`, Kind: DiffTimestampDrift, TimestampA: 1000, TimestampB: 1500, ValueA: "1", ValueB: "1"},
+			{Series: `{__name__="missing_point"}`, Kind: DiffMissingSample, TimestampA: 2000, ValueA: "2"},
+			{Series: `{__name__="only_in_a"}`, Kind: DiffMissingInB},
+			{Series: `{__name__="only_in_b"}`, Kind: DiffMissingInA},
+			{Series: `{__name__="value"}`, Kind: DiffValue, TimestampA: 2000, TimestampB: 2000, ValueA: "2", ValueB: "3"},
+		},
+	}, report)
+}
+
+func TestCompareSamples_MaxDiffsPerSeries(t *testing.T) {
+	a := []sample{{t: 1, v: 1}, {t: 2, v: 2}, {t: 3, v: 3}}
+	b := []sample{{t: 1, v: 0}, {t: 2, v: 0}, {t: 3, v: 0}}
+
+	require.Len(t, compareSamples("series", a, b, CompareConfig{}), 3)
+	require.Len(t, compareSamples("series", a, b, CompareConfig{MaxDiffsPerSeries: 2}), 2)
+}
@@ -0,0 +1,140 @@
+package remoteread
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// The phases of the read requests measured by ConnTraceMetrics.
+const (
+	phaseDNS     = "dns"
+	phaseConnect = "connect"
+	phaseTLS     = "tls"
+	// phaseTTFB is from the request written to the first byte of the
+	// response, the time the endpoint took to answer.
+	phaseTTFB = "ttfb"
+)
+
+// ConnTraceMetrics measures the DNS resolutions, connection establishments,
+// TLS handshakes and times to first byte of the read requests, by endpoint,
+// so network problems can be told apart from the endpoints being slow. The
+// requests reusing a connection only measure their time to first byte.
+type ConnTraceMetrics struct {
+	durations *prometheus.HistogramVec
+}
+
+func NewConnTraceMetrics(metricPrefix string, reg prometheus.Registerer) (*ConnTraceMetrics, error) {
+	m := &ConnTraceMetrics{
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricPrefix,
+			Name:      "read_request_phase_duration_seconds",
+			Help:      "Duration of the phases of the read requests, by endpoint and phase: dns, connect, tls or ttfb.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"endpoint", "phase"}),
+	}
+	if err := reg.Register(m.durations); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// withClientTrace returns ctx with a trace measuring the phases of the
+// requests sent with it to endpoint.
+func (m *ConnTraceMetrics) withClientTrace(ctx context.Context, endpoint string) context.Context {
+	var (
+		mtx                              sync.Mutex
+		dnsStart, tlsStart, wroteRequest time.Time
+		connectStarts                    = map[string]time.Time{}
+	)
+	observe := func(phase string, start time.Time) {
+		if !start.IsZero() {
+			m.durations.WithLabelValues(endpoint, phase).Observe(time.Since(start).Seconds())
+		}
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			observe(phaseDNS, dnsStart)
+		},
+		// Several connections can be attempted in parallel, eg. to IPv4 and
+		// IPv6 addresses.
+		ConnectStart: func(network, addr string) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			connectStarts[network+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err == nil {
+				observe(phaseConnect, connectStarts[network+addr])
+			}
+		},
+		TLSHandshakeStart: func() {
+			mtx.Lock()
+			defer mtx.Unlock()
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err == nil {
+				observe(phaseTLS, tlsStart)
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			mtx.Lock()
+			defer mtx.Unlock()
+			observe(phaseTTFB, wroteRequest)
+		},
+	})
+}
+
+// NewConnTracedQueryable returns a Queryable measuring the phases of the
+// read requests of the selects of q to endpoint with m. The series sets of
+// the selects of q stay CancelableSeriesSets.
+func NewConnTracedQueryable(q storage.Queryable, endpoint string, m *ConnTraceMetrics) storage.Queryable {
+	return connTracedQueryable{Queryable: q, endpoint: endpoint, metrics: m}
+}
+
+type connTracedQueryable struct {
+	storage.Queryable
+	endpoint string
+	metrics  *ConnTraceMetrics
+}
+
+func (q connTracedQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	querier, err := q.Queryable.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	return connTracedQuerier{Querier: querier, endpoint: q.endpoint, metrics: q.metrics}, nil
+}
+
+type connTracedQuerier struct {
+	storage.Querier
+	endpoint string
+	metrics  *ConnTraceMetrics
+}
+
+func (q connTracedQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	return q.Querier.Select(q.metrics.withClientTrace(ctx, q.endpoint), sortSeries, hints, matchers...)
+}
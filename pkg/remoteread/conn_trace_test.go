@@ -0,0 +1,69 @@
+package remoteread
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+)
+
+func phaseCount(t *testing.T, m *ConnTraceMetrics, endpoint, phase string) uint64 {
+	var metric dto.Metric
+	require.NoError(t, m.durations.WithLabelValues(endpoint, phase).(prometheus.Histogram).Write(&metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestConnTracedQueryable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+	metrics, err := NewConnTraceMetrics("test", prometheus.NewPedanticRegistry())
+	require.NoError(t, err)
+
+	q, err := NewRemoteReadQueryable("test", srv.URL, "tenant", secrets.Secret{}, time.Second)
+	require.NoError(t, err)
+	querier, err := NewConnTracedQueryable(q, "mimir", metrics).Querier(0, 2000)
+	require.NoError(t, err)
+	set := querier.Select(context.Background(), true, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up"))
+	require.False(t, set.Next())
+	require.Error(t, set.Err())
+	set.(CancelableSeriesSet).Cancel()
+
+	require.Equal(t, uint64(1), phaseCount(t, metrics, "mimir", phaseConnect))
+	require.Equal(t, uint64(1), phaseCount(t, metrics, "mimir", phaseTTFB))
+	// The test server is reached by IP, over plain HTTP.
+	require.Zero(t, phaseCount(t, metrics, "mimir", phaseDNS))
+	require.Zero(t, phaseCount(t, metrics, "mimir", phaseTLS))
+}
+
+func TestClientConnTrace(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"label_values_count_total":0,"label_names_count":0,"labels":[]}`))
+	}))
+	defer srv.Close()
+	metrics, err := NewConnTraceMetrics("test", prometheus.NewPedanticRegistry())
+	require.NoError(t, err)
+
+	client, err := NewCardinalityClient(Config{Endpoint: srv.URL, Timeout: time.Second, HTTPClient: srv.Client(), ConnTrace: metrics})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = client.LabelNames(user.InjectOrgID(context.Background(), "tenant"), CardinalityRequest{})
+		require.NoError(t, err)
+	}
+
+	endpoint := srv.Listener.Addr().String()
+	// The second request reuses the connection of the first.
+	require.Equal(t, uint64(1), phaseCount(t, metrics, endpoint, phaseConnect))
+	require.Equal(t, uint64(1), phaseCount(t, metrics, endpoint, phaseTLS))
+	require.Equal(t, uint64(2), phaseCount(t, metrics, endpoint, phaseTTFB))
+}
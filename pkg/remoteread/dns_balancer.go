@@ -0,0 +1,130 @@
+package remoteread
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// dnsEndpointPrefix prefixes the endpoints whose host is resolved to the
+	// addresses the connections are balanced over, eg.
+	// dns+http://query-frontend:8080/prometheus.
+	dnsEndpointPrefix = "dns+"
+
+	defaultDNSRefreshInterval = 30 * time.Second
+)
+
+// splitDNSEndpoint returns endpoint without its dns+ prefix, and whether it
+// had one.
+func splitDNSEndpoint(endpoint string) (string, bool) {
+	trimmed := strings.TrimPrefix(endpoint, dnsEndpointPrefix)
+	return trimmed, trimmed != endpoint
+}
+
+// dnsBalancer balances the connections to a host over its addresses, in turn.
+// The addresses are resolved again every refreshInterval, and the ones
+// failing to connect are skipped until then.
+type dnsBalancer struct {
+	host, port      string
+	refreshInterval time.Duration
+	lookupHost      func(ctx context.Context, host string) ([]string, error)
+	dial            func(ctx context.Context, network, address string) (net.Conn, error)
+	now             func() time.Time
+
+	mtx      sync.Mutex
+	addrs    []string
+	ejected  map[string]bool
+	next     int
+	resolved time.Time
+}
+
+func newDNSBalancer(hostport string, refreshInterval time.Duration) (*dnsBalancer, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, errors.Wrapf(err, "the host of dns+ read endpoints needs a port")
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return &dnsBalancer{
+		host:            host,
+		port:            port,
+		refreshInterval: refreshInterval,
+		lookupHost:      net.DefaultResolver.LookupHost,
+		dial:            dialer.DialContext,
+		now:             time.Now,
+	}, nil
+}
+
+// addresses returns the addresses to try connecting to, in order, resolving
+// them again if they're older than refreshInterval. The ones failing to
+// connect since are last, so they're only tried if all the others fail.
+func (b *dnsBalancer) addresses(ctx context.Context) ([]string, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if len(b.addrs) == 0 || b.now().Sub(b.resolved) >= b.refreshInterval {
+		addrs, err := b.lookupHost(ctx, b.host)
+		switch {
+		case err == nil && len(addrs) > 0:
+			b.addrs, b.ejected, b.resolved = addrs, map[string]bool{}, b.now()
+		case len(b.addrs) == 0:
+			return nil, errors.Wrapf(err, "can't resolve %s", b.host)
+		}
+		// The previous addresses are kept when the resolution fails.
+	}
+
+	var healthy, ejected []string
+	for i := range b.addrs {
+		addr := b.addrs[(b.next+i)%len(b.addrs)]
+		if b.ejected[addr] {
+			ejected = append(ejected, addr)
+		} else {
+			healthy = append(healthy, addr)
+		}
+	}
+	b.next = (b.next + 1) % len(b.addrs)
+	return append(healthy, ejected...), nil
+}
+
+func (b *dnsBalancer) eject(addr string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.ejected[addr] = true
+}
+
+// DialContext connects to the next address of the host, whatever the address
+// asked for.
+func (b *dnsBalancer) DialContext(ctx context.Context, network, _ string) (net.Conn, error) {
+	addrs, err := b.addresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = b.dial(ctx, network, net.JoinHostPort(addr, b.port)); err == nil {
+			return conn, nil
+		}
+		b.eject(addr)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// newDNSTransport returns a transport like http.DefaultTransport, connecting
+// to the addresses of the host of hostport in turn. The requests are balanced
+// as they open new connections, the idle ones being reused first.
+func newDNSTransport(hostport string, refreshInterval time.Duration) (http.RoundTripper, error) {
+	balancer, err := newDNSBalancer(hostport, refreshInterval)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = balancer.DialContext
+	return transport, nil
+}
@@ -0,0 +1,105 @@
+package remoteread
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSBalancer(t *testing.T) {
+	b, err := newDNSBalancer("mimir:8080", time.Minute)
+	require.NoError(t, err)
+	now := time.Unix(0, 0)
+	b.now = func() time.Time { return now }
+	var (
+		lookups    int
+		lookupAddr = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+		lookupErr  error
+		down       = map[string]bool{}
+		dialed     []string
+	)
+	b.lookupHost = func(_ context.Context, host string) ([]string, error) {
+		require.Equal(t, "mimir", host)
+		lookups++
+		return lookupAddr, lookupErr
+	}
+	b.dial = func(_ context.Context, _, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if down[address] {
+			return nil, errors.New("connection refused")
+		}
+		return &net.TCPConn{}, nil
+	}
+	dial := func() {
+		t.Helper()
+		_, err := b.DialContext(context.Background(), "tcp", "mimir:8080")
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 4; i++ {
+		dial()
+	}
+	require.Equal(t, []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080", "10.0.0.1:8080"}, dialed)
+	require.Equal(t, 1, lookups)
+
+	// The addresses failing to connect are skipped until resolved again.
+	dialed, down["10.0.0.2:8080"] = nil, true
+	dial()
+	dial()
+	require.Equal(t, []string{"10.0.0.2:8080", "10.0.0.3:8080", "10.0.0.3:8080"}, dialed)
+
+	// They're still tried when all the others fail.
+	dialed, down["10.0.0.1:8080"], down["10.0.0.3:8080"] = nil, true, true
+	_, err = b.DialContext(context.Background(), "tcp", "mimir:8080")
+	require.Error(t, err)
+	down["10.0.0.2:8080"] = false
+	dialed = nil
+	dial()
+	require.Equal(t, "10.0.0.2:8080", dialed[len(dialed)-1])
+
+	// The addresses are resolved again, and kept if the resolution fails.
+	now = now.Add(time.Minute)
+	lookupAddr, lookupErr = nil, errors.New("no such host")
+	dialed = nil
+	dial()
+	require.Equal(t, 2, lookups)
+	require.Equal(t, "10.0.0.2:8080", dialed[len(dialed)-1])
+	lookupAddr, lookupErr = []string{"10.0.0.4"}, nil
+	dialed = nil
+	dial()
+	require.Equal(t, 3, lookups)
+	require.Equal(t, []string{"10.0.0.4:8080"}, dialed)
+}
+
+func TestDNSEndpoint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/prometheus/api/v1/cardinality/label_names", r.URL.Path)
+		_, _ = w.Write([]byte(`{"label_values_count_total":0,"label_names_count":0,"labels":[]}`))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	_, port, err := net.SplitHostPort(u.Host)
+	require.NoError(t, err)
+
+	cfg := Config{Endpoint: "dns+http://localhost:" + port + "/prometheus", Timeout: time.Second, DNSRefreshInterval: time.Minute}
+	require.NoError(t, cfg.Validate())
+	client, err := NewCardinalityClient(cfg)
+	require.NoError(t, err)
+	_, err = client.LabelNames(user.InjectOrgID(context.Background(), "tenant"), CardinalityRequest{})
+	require.NoError(t, err)
+
+	cfg.Endpoint = "dns+http://localhost/prometheus"
+	require.ErrorContains(t, cfg.Validate(), "must have a port")
+	cfg.Endpoint, cfg.HTTPClient = "dns+http://localhost:"+port+"/prometheus", srv.Client()
+	_, err = NewCardinalityClient(cfg)
+	require.Error(t, err)
+}
@@ -0,0 +1,56 @@
+package remoteread
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	// clientNameHeader is the header naming the client of the reads, so the
+	// downstream can attribute its load to it.
+	clientNameHeader = "X-Client-Name"
+
+	defaultClientName        = "mimir-graphite"
+	defaultQuerySourceHeader = "X-Query-Source"
+)
+
+type querySourceContextKey int
+
+const querySourceKey querySourceContextKey = 0
+
+// ContextWithQuerySource returns ctx tagging the read requests sent with it
+// with source, eg. the UID of a dashboard or the ID of an alert rule, in the
+// Config.QuerySourceHeader header.
+func ContextWithQuerySource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, querySourceKey, source)
+}
+
+// QuerySourceFromContext returns the query source of ctx, if any.
+func QuerySourceFromContext(ctx context.Context) string {
+	source, _ := ctx.Value(querySourceKey).(string)
+	return source
+}
+
+// setAttributionHeaders sets the headers attributing req to the client of cfg
+// and to the query source of ctx, if any.
+func setAttributionHeaders(ctx context.Context, req *http.Request, cfg Config) {
+	if cfg.ClientName != "" {
+		req.Header.Set(clientNameHeader, cfg.ClientName)
+	}
+	if source := QuerySourceFromContext(ctx); source != "" && cfg.QuerySourceHeader != "" {
+		req.Header.Set(cfg.QuerySourceHeader, source)
+	}
+}
+
+// attributionRoundTripper sets the attribution headers of cfg on the requests
+// it sends.
+type attributionRoundTripper struct {
+	next http.RoundTripper
+	cfg  Config
+}
+
+func (t attributionRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	setAttributionHeaders(req.Context(), req, t.cfg)
+	return t.next.RoundTrip(req)
+}
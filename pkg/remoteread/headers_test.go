@@ -0,0 +1,61 @@
+package remoteread
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttributionHeaders(t *testing.T) {
+	headers := map[string]http.Header{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers[r.URL.Path] = r.Header.Clone()
+		if r.URL.Path == "/prometheus/api/v1/read" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status": "success", "data": ["job"]}`))
+	}))
+	defer srv.Close()
+
+	cfg := Config{Endpoint: srv.URL + "/prometheus", Timeout: time.Second, ClientName: "graphite-querier", QuerySourceHeader: "X-Dashboard-UID"}
+	ctx := ContextWithQuerySource(user.InjectOrgID(context.Background(), "12345"), "dash-1")
+
+	labelsClient, err := NewLabelsClient(cfg)
+	require.NoError(t, err)
+	_, err = labelsClient.LabelNames(ctx, time.UnixMilli(0), time.UnixMilli(1000))
+	require.NoError(t, err)
+	_, err = labelsClient.LabelValues(ctx, "job", time.UnixMilli(0), time.UnixMilli(1000))
+	require.NoError(t, err)
+
+	q, _, err := NewQueryable(cfg, "test", prometheus.NewPedanticRegistry(), log.NewNopLogger())
+	require.NoError(t, err)
+	querier, err := q.Querier(0, 1000)
+	require.NoError(t, err)
+	defer querier.Close()
+	set := querier.Select(ctx, true, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up"))
+	for set.Next() {
+	}
+
+	for _, path := range []string{"/prometheus/api/v1/labels", "/prometheus/api/v1/label/job/values", "/prometheus/api/v1/read"} {
+		require.Contains(t, headers, path)
+		require.Equal(t, "12345", headers[path].Get(user.OrgIDHeaderName), path)
+		require.Equal(t, "graphite-querier", headers[path].Get("X-Client-Name"), path)
+		require.Equal(t, "dash-1", headers[path].Get("X-Dashboard-UID"), path)
+	}
+
+	t.Run("without query source", func(t *testing.T) {
+		_, err := labelsClient.LabelNames(user.InjectOrgID(context.Background(), "12345"), time.UnixMilli(0), time.UnixMilli(1000))
+		require.NoError(t, err)
+		require.Equal(t, "graphite-querier", headers["/prometheus/api/v1/labels"].Get("X-Client-Name"))
+		require.Empty(t, headers["/prometheus/api/v1/labels"].Get("X-Dashboard-UID"))
+	})
+}
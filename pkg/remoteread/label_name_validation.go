@@ -0,0 +1,154 @@
+package remoteread
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/util/annotations"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+const (
+	// LabelNameValidationAuto validates the label names as
+	// LabelNameValidationLegacy does, unless the build info of the downstream
+	// reports a version accepting UTF-8 label names, 3.0 or later.
+	LabelNameValidationAuto = "auto"
+	// LabelNameValidationLegacy fails the reads of the label names that
+	// aren't valid legacy Prometheus label names, eg. with dots, with a bad
+	// request error, rather than sending them.
+	LabelNameValidationLegacy = "legacy"
+	// LabelNameValidationNone sends the label names as they are, eg. to the
+	// downstreams accepting UTF-8 label names whatever their version.
+	LabelNameValidationNone = "none"
+
+	buildInfoPath = "/api/v1/status/buildinfo"
+)
+
+// utf8LabelNamesMajorVersion is the first major version of Prometheus
+// accepting UTF-8 label names.
+const utf8LabelNamesMajorVersion = 3
+
+// NewLabelNameValidatingQueryable returns a Queryable checking the label names
+// of the matchers of the calls of its queriers as configured by mode, one of
+// the LabelNameValidation constants, before calling the ones of q. An empty
+// mode is LabelNameValidationNone. With LabelNameValidationAuto, the version
+// of the downstream is read from the build info API under cfg.Endpoint once,
+// with the tenant of the first call; the names are validated until it's
+// known. The series sets of the selects of q stay CancelableSeriesSets.
+func NewLabelNameValidatingQueryable(q storage.Queryable, mode string, cfg Config) (storage.Queryable, error) {
+	if mode == LabelNameValidationNone || mode == "" {
+		return q, nil
+	}
+	v := &labelNameValidator{legacy: true, checked: mode == LabelNameValidationLegacy}
+	if !v.checked {
+		client, err := newClient(cfg, "buildinfo")
+		if err != nil {
+			return nil, err
+		}
+		v.client = client
+	}
+	return labelNameValidatingQueryable{Queryable: q, validator: v}, nil
+}
+
+// labelNameValidator validates the label names as legacy ones, if the
+// downstream doesn't accept UTF-8 ones.
+type labelNameValidator struct {
+	client *client
+
+	mtx     sync.Mutex
+	checked bool
+	legacy  bool
+}
+
+// validate returns an errorx.BadRequest error for the first label name of
+// matchers the downstream doesn't accept.
+func (v *labelNameValidator) validate(ctx context.Context, names ...string) error {
+	if !v.requiresLegacy(ctx) {
+		return nil
+	}
+	for _, name := range names {
+		if !model.LabelName(name).IsValidLegacy() {
+			msg := fmt.Sprintf("label name %q isn't supported by the downstream", name)
+			return errorx.BadRequest{Msg: msg, UserMsg: msg}
+		}
+	}
+	return nil
+}
+
+// requiresLegacy returns whether the label names need to be legacy ones,
+// reading the build info of the downstream if it wasn't yet.
+func (v *labelNameValidator) requiresLegacy(ctx context.Context) bool {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	if v.checked {
+		return v.legacy
+	}
+	var resp struct {
+		Data struct {
+			Version string `json:"version"`
+		} `json:"data"`
+	}
+	// The check is done again on the next call if it fails.
+	if err := v.client.get(ctx, buildInfoPath, nil, &resp); err != nil {
+		return true
+	}
+	v.checked = true
+	major, _, _ := strings.Cut(strings.TrimPrefix(resp.Data.Version, "v"), ".")
+	n, err := strconv.Atoi(major)
+	v.legacy = err != nil || n < utf8LabelNamesMajorVersion
+	return v.legacy
+}
+
+func matcherNames(matchers []*labels.Matcher) []string {
+	names := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+type labelNameValidatingQueryable struct {
+	storage.Queryable
+	validator *labelNameValidator
+}
+
+func (q labelNameValidatingQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	querier, err := q.Queryable.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	return labelNameValidatingQuerier{Querier: querier, validator: q.validator}, nil
+}
+
+type labelNameValidatingQuerier struct {
+	storage.Querier
+	validator *labelNameValidator
+}
+
+func (q labelNameValidatingQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	if err := q.validator.validate(ctx, matcherNames(matchers)...); err != nil {
+		return &cancelableSeriesSet{SeriesSet: storage.ErrSeriesSet(err), cancel: func() {}}
+	}
+	return q.Querier.Select(ctx, sortSeries, hints, matchers...)
+}
+
+func (q labelNameValidatingQuerier) LabelValues(ctx context.Context, name string, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	if err := q.validator.validate(ctx, append(matcherNames(matchers), name)...); err != nil {
+		return nil, nil, err
+	}
+	return q.Querier.LabelValues(ctx, name, hints, matchers...)
+}
+
+func (q labelNameValidatingQuerier) LabelNames(ctx context.Context, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	if err := q.validator.validate(ctx, matcherNames(matchers)...); err != nil {
+		return nil, nil, err
+	}
+	return q.Querier.LabelNames(ctx, hints, matchers...)
+}
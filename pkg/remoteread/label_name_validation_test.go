@@ -0,0 +1,89 @@
+package remoteread
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+func TestLabelNameValidatingQueryable(t *testing.T) {
+	var (
+		version    string
+		buildInfos int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/prometheus/api/v1/status/buildinfo", r.URL.Path)
+		require.Equal(t, "12345", r.Header.Get(user.OrgIDHeaderName))
+		buildInfos++
+		if version == "" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status": "success", "data": {"version": "` + version + `"}}`))
+	}))
+	defer srv.Close()
+	cfg := Config{Endpoint: srv.URL + "/prometheus", Timeout: time.Second}
+	ctx := user.InjectOrgID(context.Background(), "12345")
+	utf8 := labels.MustNewMatcher(labels.MatchEqual, "service.name", "graphite")
+	legacy := labels.MustNewMatcher(labels.MatchEqual, "job", "graphite")
+	noop := storage.QueryableFunc(func(int64, int64) (storage.Querier, error) { return storage.NoopQuerier(), nil })
+
+	selectErr := func(t *testing.T, q storage.Queryable, matcher *labels.Matcher) error {
+		querier, err := q.Querier(0, 1000)
+		require.NoError(t, err)
+		defer querier.Close()
+		set := querier.Select(ctx, true, nil, matcher)
+		require.False(t, set.Next())
+		return set.Err()
+	}
+
+	t.Run("none", func(t *testing.T) {
+		q, err := NewLabelNameValidatingQueryable(noop, LabelNameValidationNone, cfg)
+		require.NoError(t, err)
+		require.NoError(t, selectErr(t, q, utf8))
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		buildInfos = 0
+		q, err := NewLabelNameValidatingQueryable(noop, LabelNameValidationLegacy, cfg)
+		require.NoError(t, err)
+		require.NoError(t, selectErr(t, q, legacy))
+		require.ErrorAs(t, selectErr(t, q, utf8), &errorx.BadRequest{})
+		require.Zero(t, buildInfos)
+	})
+
+	t.Run("auto", func(t *testing.T) {
+		for _, tc := range []struct {
+			version string
+			legacy  bool
+		}{
+			{version: "2.14.0", legacy: true},
+			{version: "3.1.0", legacy: false},
+			{version: "v3.0.0-rc.0", legacy: false},
+		} {
+			buildInfos, version = 0, ""
+			q, err := NewLabelNameValidatingQueryable(noop, LabelNameValidationAuto, cfg)
+			require.NoError(t, err)
+			// The names are validated until the version is known.
+			require.ErrorAs(t, selectErr(t, q, utf8), &errorx.BadRequest{})
+			version = tc.version
+			err = selectErr(t, q, utf8)
+			if tc.legacy {
+				require.ErrorAs(t, err, &errorx.BadRequest{}, tc.version)
+			} else {
+				require.NoError(t, err, tc.version)
+			}
+			require.NoError(t, selectErr(t, q, legacy))
+			require.Equal(t, 2, buildInfos, tc.version)
+		}
+	})
+}
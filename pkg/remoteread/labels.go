@@ -0,0 +1,126 @@
+package remoteread
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+const defaultLabelValuesFallbackLimit = 10000
+
+// LabelsClient queries the labels API of Mimir's Prometheus API for the
+// tenant of the request context. Errors are errorx errors.
+type LabelsClient struct {
+	client        *client
+	fallbackLimit int
+}
+
+func NewLabelsClient(cfg Config) (*LabelsClient, error) {
+	c, err := newClient(cfg, "Labels")
+	if err != nil {
+		return nil, err
+	}
+	return &LabelsClient{client: c, fallbackLimit: cfg.LabelValuesFallbackLimit}, nil
+}
+
+// LabelValues returns the values of the label name of the series matching
+// matchers between start and end.
+//
+// Some Mimir and Prometheus versions reject some selectors, like the ones
+// whose matchers all match the empty string. When the matchers are rejected
+// as a bad request, other than an exceeded limit, the values are read without
+// matchers, and the matchers on name are applied here: the values of the
+// series the matchers on the other labels don't select are kept too. The
+// fallback fails with an errorx.LimitExceeded error when it reads more values
+// than Config.LabelValuesFallbackLimit.
+func (c *LabelsClient) LabelValues(ctx context.Context, name string, start, end time.Time, matchers ...*labels.Matcher) ([]string, error) {
+	query := url.Values{}
+	query.Set("start", formatAPITime(start))
+	query.Set("end", formatAPITime(end))
+	apiPath := "/api/v1/label/" + url.PathEscape(name) + "/values"
+	if len(matchers) == 0 {
+		return c.labelValues(ctx, apiPath, query)
+	}
+
+	filtered := url.Values{}
+	for k, v := range query {
+		filtered[k] = v
+	}
+	filtered.Set("match[]", selector(matchers))
+	values, err := c.labelValues(ctx, apiPath, filtered)
+	if !errors.As(err, &errorx.BadRequest{}) || errors.As(err, &errorx.LimitExceeded{}) {
+		return values, err
+	}
+
+	values, err = c.labelValues(ctx, apiPath, query)
+	if err != nil {
+		return nil, err
+	}
+	if c.fallbackLimit > 0 && len(values) > c.fallbackLimit {
+		return nil, errorx.LimitExceeded{
+			Msg:   "too many label values to filter",
+			Limit: "label-values-fallback-limit",
+			Value: int64(len(values)),
+			Max:   int64(c.fallbackLimit),
+		}
+	}
+	kept := values[:0]
+	for _, v := range values {
+		if matchesValue(matchers, name, v) {
+			kept = append(kept, v)
+		}
+	}
+	return kept, nil
+}
+
+// LabelNames returns the label names of the series matching matchers between
+// start and end, or of all the series without matchers.
+func (c *LabelsClient) LabelNames(ctx context.Context, start, end time.Time, matchers ...*labels.Matcher) ([]string, error) {
+	query := url.Values{}
+	query.Set("start", formatAPITime(start))
+	query.Set("end", formatAPITime(end))
+	if len(matchers) > 0 {
+		query.Set("match[]", selector(matchers))
+	}
+	return c.labelValues(ctx, "/api/v1/labels", query)
+}
+
+func (c *LabelsClient) labelValues(ctx context.Context, apiPath string, query url.Values) ([]string, error) {
+	var resp struct {
+		Data []string `json:"data"`
+	}
+	err := c.client.get(ctx, apiPath, query, &resp)
+	return resp.Data, err
+}
+
+// matchesValue returns whether the value v of the label name matches the
+// matchers of name.
+func matchesValue(matchers []*labels.Matcher, name, v string) bool {
+	for _, m := range matchers {
+		if m.Name == name && !m.Matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// selector returns the series selector of matchers, eg. {job="graphite"}.
+func selector(matchers []*labels.Matcher) string {
+	strs := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		strs = append(strs, m.String())
+	}
+	return "{" + strings.Join(strs, ",") + "}"
+}
+
+// formatAPITime formats t as the unix timestamps of the API parameters.
+func formatAPITime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', -1, 64)
+}
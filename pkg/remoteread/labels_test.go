@@ -0,0 +1,80 @@
+package remoteread
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+func TestLabelsClientLabelValues(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/prometheus/api/v1/label/job/values", r.URL.Path)
+		require.Equal(t, "1", r.URL.Query().Get("start"))
+		require.Equal(t, "2.5", r.URL.Query().Get("end"))
+		match := r.URL.Query().Get("match[]")
+		requests = append(requests, match)
+		switch match {
+		case "":
+			_, _ = w.Write([]byte(`{"status": "success", "data": ["graphite", "node", "prometheus"]}`))
+		case `{job="graphite"}`:
+			_, _ = w.Write([]byte(`{"status": "success", "data": ["graphite"]}`))
+		case `{job=~"limited.*"}`:
+			http.Error(w, "the query exceeded the maximum number of series (limit: 1) (err-mimir-max-series-per-query)", http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, "match[] must contain at least one non-empty matcher", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewLabelsClient(Config{Endpoint: srv.URL + "/prometheus/", Timeout: time.Second, LabelValuesFallbackLimit: 3})
+	require.NoError(t, err)
+	ctx := user.InjectOrgID(context.Background(), "12345")
+	start, end := time.UnixMilli(1000), time.UnixMilli(2500)
+
+	values, err := client.LabelValues(ctx, "job", start, end)
+	require.NoError(t, err)
+	require.Equal(t, []string{"graphite", "node", "prometheus"}, values)
+	require.Equal(t, []string{""}, requests)
+
+	requests = nil
+	values, err = client.LabelValues(ctx, "job", start, end, labels.MustNewMatcher(labels.MatchEqual, "job", "graphite"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"graphite"}, values)
+	require.Equal(t, []string{`{job="graphite"}`}, requests)
+
+	t.Run("fallback", func(t *testing.T) {
+		requests = nil
+		values, err := client.LabelValues(ctx, "job", start, end,
+			labels.MustNewMatcher(labels.MatchRegexp, "job", "(graphite|node|)"),
+			labels.MustNewMatcher(labels.MatchEqual, "env", ""),
+		)
+		require.NoError(t, err)
+		require.Equal(t, []string{"graphite", "node"}, values)
+		require.Equal(t, []string{`{job=~"(graphite|node|)",env=""}`, ""}, requests)
+	})
+
+	t.Run("fallback limit", func(t *testing.T) {
+		client, err := NewLabelsClient(Config{Endpoint: srv.URL + "/prometheus/", Timeout: time.Second, LabelValuesFallbackLimit: 2})
+		require.NoError(t, err)
+		_, err = client.LabelValues(ctx, "job", start, end, labels.MustNewMatcher(labels.MatchEqual, "job", ""))
+		var limitErr errorx.LimitExceeded
+		require.ErrorAs(t, err, &limitErr)
+		require.Equal(t, int64(3), limitErr.Value)
+	})
+
+	t.Run("no fallback on limits", func(t *testing.T) {
+		requests = nil
+		_, err := client.LabelValues(ctx, "job", start, end, labels.MustNewMatcher(labels.MatchRegexp, "job", "limited.*"))
+		require.Error(t, err)
+		require.Equal(t, []string{`{job=~"limited.*"}`}, requests)
+	})
+}
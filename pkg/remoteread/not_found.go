@@ -0,0 +1,85 @@
+package remoteread
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// notFoundStatus is in the errors of Prometheus' remote read client for the
+// 404 responses, followed by their body.
+const notFoundStatus = "returned http status 404"
+
+// tenantNotFoundMarkers are found in the bodies of the 404 responses of the
+// remote read APIs for tenants they don't know, eg. because they have no data
+// yet. The other 404 responses, eg. of a wrong endpoint, are errors.
+var tenantNotFoundMarkers = []string{
+	"tenant not found",
+	"unknown tenant",
+	"org not found",
+}
+
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	_, body, ok := strings.Cut(msg, notFoundStatus)
+	if !ok {
+		return false
+	}
+	for _, marker := range tenantNotFoundMarkers {
+		if strings.Contains(body, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewNotFoundAsEmptyQueryable returns a Queryable whose selects are empty
+// rather than failing when q returns not found for the tenant, eg. to query
+// the union of the data of several environments when some tenants
+// legitimately have no data yet. The series sets of the selects of q stay
+// CancelableSeriesSets.
+func NewNotFoundAsEmptyQueryable(q storage.Queryable) storage.Queryable {
+	return notFoundQueryable{Queryable: q}
+}
+
+type notFoundQueryable struct {
+	storage.Queryable
+}
+
+func (q notFoundQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	querier, err := q.Queryable.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	return notFoundQuerier{Querier: querier}, nil
+}
+
+type notFoundQuerier struct {
+	storage.Querier
+}
+
+func (q notFoundQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	return &notFoundSeriesSet{SeriesSet: q.Querier.Select(ctx, sortSeries, hints, matchers...)}
+}
+
+type notFoundSeriesSet struct {
+	storage.SeriesSet
+}
+
+func (s *notFoundSeriesSet) Err() error {
+	if err := s.SeriesSet.Err(); !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *notFoundSeriesSet) Cancel() {
+	if set, ok := s.SeriesSet.(CancelableSeriesSet); ok {
+		set.Cancel()
+	}
+}
@@ -0,0 +1,52 @@
+package remoteread
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+)
+
+func TestNotFoundAsEmptyQueryable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Scope-OrgID") {
+		case "unknown":
+			http.Error(w, "tenant not found", http.StatusNotFound)
+		case "wrong-path":
+			http.Error(w, "404 page not found", http.StatusNotFound)
+		default:
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")
+
+	for tenant, wantErr := range map[string]bool{"unknown": false, "wrong-path": true, "failing": true} {
+		t.Run(tenant, func(t *testing.T) {
+			q, err := NewRemoteReadQueryable("test", srv.URL, tenant, secrets.Secret{}, time.Second)
+			require.NoError(t, err)
+			querier, err := q.Querier(0, 2000)
+			require.NoError(t, err)
+			set := querier.Select(context.Background(), true, nil, matcher)
+			require.False(t, set.Next())
+			require.Error(t, set.Err())
+
+			querier, err = NewNotFoundAsEmptyQueryable(q).Querier(0, 2000)
+			require.NoError(t, err)
+			set = querier.Select(context.Background(), true, nil, matcher)
+			require.False(t, set.Next())
+			if wantErr {
+				require.Error(t, set.Err())
+			} else {
+				require.NoError(t, set.Err())
+			}
+			set.(CancelableSeriesSet).Cancel()
+		})
+	}
+}
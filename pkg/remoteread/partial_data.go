@@ -0,0 +1,95 @@
+package remoteread
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// partialDataMarkers are found in the errors of Mimir's queriers failing
+// because some blocks of the range couldn't be read from the
+// store-gateways.
+var partialDataMarkers = []string{
+	"err-mimir-store-consistency-check-failed",
+	"failed to fetch some blocks",
+	"some blocks were not queried",
+}
+
+func isPartialData(msg string) bool {
+	for _, marker := range partialDataMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// asPartialData returns err as an errorx.PartialData for the range [start,
+// end] if it's one of Mimir's errors for blocks that couldn't be read, or err
+// as is.
+func asPartialData(err error, start, end time.Time) error {
+	if err == nil || !isPartialData(err.Error()) {
+		return err
+	}
+	return errorx.PartialData{Msg: "some of the data couldn't be read from Mimir's store-gateways", Err: err, Start: start, End: end}
+}
+
+// parseAPITime parses the start and end parameters of Prometheus' API, unix
+// timestamps or RFC3339 times. It returns the zero time if s is neither.
+func parseAPITime(s string) time.Time {
+	if ts, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(ts)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC()
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// partialDataQueryable returns the errors of its selects for blocks that
+// couldn't be read as errorx.PartialData errors.
+type partialDataQueryable struct {
+	storage.Queryable
+}
+
+func (q partialDataQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	querier, err := q.Queryable.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	return partialDataQuerier{Querier: querier, mint: mint, maxt: maxt}, nil
+}
+
+type partialDataQuerier struct {
+	storage.Querier
+	mint, maxt int64
+}
+
+func (q partialDataQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	start, end := q.mint, q.maxt
+	if hints != nil {
+		start, end = hints.Start, hints.End
+	}
+	return partialDataSeriesSet{
+		SeriesSet: q.Querier.Select(ctx, sortSeries, hints, matchers...),
+		start:     time.UnixMilli(start).UTC(),
+		end:       time.UnixMilli(end).UTC(),
+	}
+}
+
+type partialDataSeriesSet struct {
+	storage.SeriesSet
+	start, end time.Time
+}
+
+func (s partialDataSeriesSet) Err() error {
+	return asPartialData(s.SeriesSet.Err(), s.start, s.end)
+}
@@ -0,0 +1,77 @@
+package remoteread
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+const consistencyCheckFailed = "failed to fetch some blocks (err-mimir-store-consistency-check-failed). The failed blocks are: 01HXYZ"
+
+func TestClient_PartialData(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/prometheus/api/v1/query_range" {
+			http.Error(w, consistencyCheckFailed, http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c, err := newClient(Config{Endpoint: srv.URL + "/prometheus", Timeout: time.Second}, "test")
+	require.NoError(t, err)
+	ctx := user.InjectOrgID(context.Background(), "12345")
+
+	err = c.get(ctx, "/api/v1/query_range", url.Values{"start": {"1700000000"}, "end": {"2023-11-14T23:13:20.5Z"}}, nil)
+	var partial errorx.PartialData
+	require.ErrorAs(t, err, &partial)
+	require.Equal(t, time.Unix(1700000000, 0).UTC(), partial.Start)
+	require.Equal(t, time.Date(2023, time.November, 14, 23, 13, 20, 500_000_000, time.UTC), partial.End)
+	require.Contains(t, err.Error(), "err-mimir-store-consistency-check-failed")
+
+	// Other errors keep their type.
+	err = c.get(ctx, "/api/v1/query", nil, nil)
+	require.False(t, errors.As(err, &partial))
+	require.ErrorAs(t, err, &errorx.Internal{})
+}
+
+func TestPartialDataQueryable(t *testing.T) {
+	q := partialDataQueryable{Queryable: storage.QueryableFunc(func(int64, int64) (storage.Querier, error) {
+		return erroringQuerier{Querier: storage.NoopQuerier()}, nil
+	})}
+	querier, err := q.Querier(1000, 5000)
+	require.NoError(t, err)
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")
+
+	set := querier.Select(context.Background(), false, &storage.SelectHints{Start: 2000, End: 3000}, matcher)
+	require.False(t, set.Next())
+	var partial errorx.PartialData
+	require.ErrorAs(t, set.Err(), &partial)
+	require.Equal(t, time.UnixMilli(2000).UTC(), partial.Start)
+	require.Equal(t, time.UnixMilli(3000).UTC(), partial.End)
+
+	// Without hints, the range is the one of the querier.
+	set = querier.Select(context.Background(), false, nil, matcher)
+	require.ErrorAs(t, set.Err(), &partial)
+	require.Equal(t, time.UnixMilli(1000).UTC(), partial.Start)
+	require.Equal(t, time.UnixMilli(5000).UTC(), partial.End)
+}
+
+type erroringQuerier struct {
+	storage.Querier
+}
+
+func (erroringQuerier) Select(context.Context, bool, *storage.SelectHints, ...*labels.Matcher) storage.SeriesSet {
+	return storage.ErrSeriesSet(errors.New("remote_read: server returned HTTP status 500: " + consistencyCheckFailed))
+}
@@ -0,0 +1,506 @@
+package remoteread
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/user"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/util/annotations"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon"
+)
+
+const (
+	defaultPrefetchLead        = 5 * time.Second
+	defaultPrefetchMaxInterval = 5 * time.Minute
+	defaultPrefetchMaxQueries  = 1000
+	defaultPrefetchOverlap     = time.Minute
+	defaultPrefetchMaxBytes    = 10 * 1024 * 1024
+)
+
+// errPrefetchTooLarge is returned by the prefetches whose series would take
+// more than PrefetchConfig.MaxBytes.
+var errPrefetchTooLarge = errors.New("prefetched series too large")
+
+// PrefetchConfig configures the prefetching of the next range of the
+// queries repeated at a regular interval, like the ones of auto-refreshing
+// dashboards.
+type PrefetchConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Lead is how long before the expected refresh the next range is read.
+	Lead time.Duration `yaml:"lead"`
+	// MaxInterval is the longest refresh interval prefetched, the queries
+	// repeated less often aren't.
+	MaxInterval time.Duration `yaml:"max_interval"`
+	MaxQueries  int           `yaml:"max_queries"`
+	// Overlap is how much of the end of a prefetch is read again with the
+	// most recent samples, for the samples ingested late.
+	Overlap time.Duration `yaml:"overlap"`
+	// MaxBytes is the max size of the series of a prefetch, the larger ones
+	// aren't kept.
+	MaxBytes int `yaml:"max_bytes"`
+	// Cache keeps the prefetched series until the next select of their
+	// query.
+	Cache appcommon.CacheConfig `yaml:"cache"`
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *PrefetchConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.BoolVar(&c.Enabled, prefix+"read-prefetch.enabled", false, "Read the next range of the queries repeated at a regular interval, like the ones of auto-refreshing dashboards, just before they're expected, so only the most recent samples are read when they come.")
+	flags.DurationVar(&c.Lead, prefix+"read-prefetch.lead", defaultPrefetchLead, "How long before the expected refresh of a query its next range is read. Prefetches taking longer are abandoned.")
+	flags.DurationVar(&c.MaxInterval, prefix+"read-prefetch.max-interval", defaultPrefetchMaxInterval, "Longest refresh interval prefetched. The queries repeated less often aren't.")
+	flags.IntVar(&c.MaxQueries, prefix+"read-prefetch.max-queries", defaultPrefetchMaxQueries, "Max number of repeated queries tracked, the least recently seen are forgotten.")
+	flags.DurationVar(&c.Overlap, prefix+"read-prefetch.overlap", defaultPrefetchOverlap, "How much of the end of a prefetch is read again with the most recent samples, so the samples ingested late aren't missed. Set it to the ingestion delay or the lookback delta.")
+	flags.IntVar(&c.MaxBytes, prefix+"read-prefetch.max-bytes", defaultPrefetchMaxBytes, "Max size in bytes of the series of a prefetch. The prefetches reading more are abandoned.")
+	c.Cache.RegisterFlagsWithPrefix(prefix+"read-prefetch.", flags)
+}
+
+func (c *PrefetchConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Lead <= 0 {
+		return errors.New("read prefetch lead must be positive")
+	}
+	if c.MaxInterval <= c.Lead {
+		return errors.New("read prefetch max interval must be longer than the lead")
+	}
+	if c.MaxQueries <= 0 {
+		return errors.New("read prefetch max queries must be positive")
+	}
+	if c.Overlap < 0 {
+		return errors.New("read prefetch overlap can't be negative")
+	}
+	if c.MaxBytes <= 0 {
+		return errors.New("read prefetch max bytes must be positive")
+	}
+	if c.Cache.Backend == "" {
+		return errors.New("read prefetch requires a cache backend")
+	}
+	return c.Cache.Validate()
+}
+
+// Prefetcher is a Queryable tracking the selects with a step of each tenant.
+// When the same select, with the same matchers, step and range length, comes
+// again with its range moved forward, the next one is expected after the
+// same interval: its range is read PrefetchConfig.Lead before and kept in the
+// cache, and the next select only reads the samples more recent than the
+// prefetch, and the last PrefetchConfig.Overlap of it again.
+//
+// Only float samples are prefetched. Run its Handler to cancel the pending
+// prefetches when the app stops.
+type Prefetcher struct {
+	queryable storage.Queryable
+	cfg       PrefetchConfig
+	logger    log.Logger
+
+	cache      appcommon.Cache
+	stopCache  func()
+	prefetches *prometheus.CounterVec
+	hits       prometheus.Counter
+
+	now       func() time.Time
+	afterFunc func(time.Duration, func()) *time.Timer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// queries only tracks the selects seen and their pending prefetches,
+	// the prefetched series are in the cache.
+	mtx     sync.Mutex
+	queries *lru.Cache[string, *prefetchedQuery]
+}
+
+// prefetchedQuery is a select seen at least once, with the range of its
+// prefetch, if any.
+type prefetchedQuery struct {
+	hints storage.SelectHints
+	seen  time.Time
+	timer *time.Timer
+
+	prefetched *prefetchRange
+}
+
+// prefetchRange is the range read by a prefetch, and the key of its series in
+// the cache.
+type prefetchRange struct {
+	start, end int64
+	cacheKey   string
+}
+
+// prefetchResult are the series read by a prefetch between start and end.
+type prefetchResult struct {
+	start, end int64
+	series     []prefetchedSeries
+}
+
+type prefetchedSeries struct {
+	lbls    labels.Labels
+	samples []floatSample
+}
+
+func NewPrefetcher(q storage.Queryable, cfg PrefetchConfig, metricPrefix string, reg prometheus.Registerer, logger log.Logger) (*Prefetcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Prefetcher{
+		queryable: q,
+		cfg:       cfg,
+		logger:    log.With(logger, "component", "read-prefetcher"),
+		prefetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricPrefix,
+			Name:      "read_prefetches_total",
+			Help:      "The total number of reads of the next range of repeated queries, by outcome: success, failed or too_large.",
+		}, []string{"outcome"}),
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricPrefix,
+			Name:      "read_prefetch_hits_total",
+			Help:      "The total number of selects answered from a prefetch.",
+		}),
+		now:       time.Now,
+		afterFunc: time.AfterFunc,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	queries, err := lru.NewWithEvict(cfg.MaxQueries, func(_ string, query *prefetchedQuery) {
+		if query.timer != nil {
+			query.timer.Stop()
+		}
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	p.queries = queries
+	if err := reg.Register(p.prefetches); err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := reg.Register(p.hits); err != nil {
+		cancel()
+		return nil, err
+	}
+	if p.cache, p.stopCache, err = appcommon.NewCache("read-prefetch", cfg.Cache, logger, metricPrefix, reg); err != nil {
+		cancel()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Handler returns two functions to run the prefetcher, and to stop it and
+// cancel the pending prefetches.
+func (p *Prefetcher) Handler() (run func() error, stop func(error)) {
+	return p.run, p.stop
+}
+
+func (p *Prefetcher) run() error {
+	<-p.ctx.Done()
+	return nil
+}
+
+func (p *Prefetcher) stop(error) {
+	p.cancel()
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.queries.Purge()
+	p.stopCache()
+}
+
+// Querier implements storage.Queryable.
+func (p *Prefetcher) Querier(mint, maxt int64) (storage.Querier, error) {
+	inner, err := p.queryable.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	return &prefetchQuerier{Querier: inner, p: p}, nil
+}
+
+type prefetchQuerier struct {
+	storage.Querier
+	p *Prefetcher
+
+	mtx sync.Mutex
+	// recent are the queriers of the samples more recent than the
+	// prefetches, closed with the querier.
+	recent []storage.Querier
+}
+
+func (q *prefetchQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	if hints == nil || hints.Step <= 0 {
+		return q.Querier.Select(ctx, sortSeries, hints, matchers...)
+	}
+	tenant, _ := user.ExtractOrgID(ctx)
+	result := q.p.load(ctx, q.p.observe(tenant, *hints, matchers))
+	if result == nil {
+		return q.Querier.Select(ctx, sortSeries, hints, matchers...)
+	}
+	q.p.hits.Inc()
+
+	// The end of the prefetch is read again, for the samples ingested after
+	// it.
+	recent := *hints
+	recent.Start = min(result.end, hints.End) + 1
+	if overlap := q.p.cfg.Overlap.Milliseconds(); overlap > 0 {
+		recent.Start = max(hints.Start, recent.Start-overlap)
+	}
+	if recent.Start > hints.End {
+		return result.seriesSet(hints.Start, hints.End)
+	}
+	querier, err := q.p.queryable.Querier(recent.Start, recent.End)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+	q.mtx.Lock()
+	q.recent = append(q.recent, querier)
+	q.mtx.Unlock()
+	return storage.NewMergeSeriesSet([]storage.SeriesSet{
+		result.seriesSet(hints.Start, recent.Start-1),
+		querier.Select(ctx, true, &recent, matchers...),
+	}, 0, storage.ChainedSeriesMerge)
+}
+
+func (q *prefetchQuerier) Close() error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	err := q.Querier.Close()
+	for _, querier := range q.recent {
+		if cerr := querier.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// observe records a select of tenant, schedules the prefetch of its next
+// range if it repeats, and returns the prefetched range covering the start
+// of hints, if any.
+func (p *Prefetcher) observe(tenant string, hints storage.SelectHints, matchers []*labels.Matcher) *prefetchRange {
+	key := prefetchKey(tenant, hints, matchers)
+	now := p.now()
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	query, ok := p.queries.Get(key)
+	if !ok {
+		p.queries.Add(key, &prefetchedQuery{hints: hints, seen: now})
+		return nil
+	}
+
+	prefetched := query.prefetched
+	query.prefetched = nil
+	if prefetched != nil && (hints.Start < prefetched.start || hints.Start > prefetched.end) {
+		prefetched = nil
+	}
+
+	advance := hints.Start - query.hints.Start
+	interval := now.Sub(query.seen)
+	query.hints, query.seen = hints, now
+	if query.timer != nil {
+		query.timer.Stop()
+		query.timer = nil
+	}
+	if advance > 0 && interval > p.cfg.Lead && interval <= p.cfg.MaxInterval {
+		next := hints
+		next.Start += advance
+		next.End += advance
+		query.timer = p.afterFunc(interval-p.cfg.Lead, func() {
+			p.prefetch(key, query, tenant, next, matchers)
+		})
+	}
+	return prefetched
+}
+
+// load returns the prefetched series of r from the cache, or nil if r is nil
+// or they aren't cached anymore.
+func (p *Prefetcher) load(ctx context.Context, r *prefetchRange) *prefetchResult {
+	if r == nil {
+		return nil
+	}
+	data, ok := p.cache.Get(ctx, r.cacheKey)
+	if !ok {
+		return nil
+	}
+	var qr prompb.QueryResult
+	if err := qr.Unmarshal(data); err != nil {
+		level.Warn(p.logger).Log("msg", "can't decode prefetched series", "err", err)
+		return nil
+	}
+	result := &prefetchResult{start: r.start, end: r.end, series: make([]prefetchedSeries, 0, len(qr.Timeseries))}
+	for _, ts := range qr.Timeseries {
+		b := labels.NewScratchBuilder(len(ts.Labels))
+		for _, l := range ts.Labels {
+			b.Add(l.Name, l.Value)
+		}
+		s := prefetchedSeries{lbls: b.Labels(), samples: make([]floatSample, 0, len(ts.Samples))}
+		for _, sample := range ts.Samples {
+			s.samples = append(s.samples, floatSample{t: sample.Timestamp, f: sample.Value})
+		}
+		result.series = append(result.series, s)
+	}
+	return result
+}
+
+// prefetch reads the range of next up to now, and keeps the series in the
+// cache for the next select of query.
+func (p *Prefetcher) prefetch(key string, query *prefetchedQuery, tenant string, next storage.SelectHints, matchers []*labels.Matcher) {
+	ctx, cancel := context.WithTimeout(p.ctx, p.cfg.Lead)
+	defer cancel()
+	if tenant != "" {
+		ctx = user.InjectOrgID(ctx, tenant)
+	}
+	next.End = min(next.End, p.now().UnixMilli())
+	if next.End < next.Start {
+		return
+	}
+
+	qr, err := p.read(ctx, next, matchers)
+	if errors.Is(err, errPrefetchTooLarge) {
+		p.prefetches.WithLabelValues("too_large").Inc()
+		return
+	}
+	if err != nil {
+		p.prefetches.WithLabelValues("failed").Inc()
+		level.Debug(p.logger).Log("msg", "can't prefetch query", "tenant", tenant, "err", err)
+		return
+	}
+	data, err := qr.Marshal()
+	if err != nil {
+		p.prefetches.WithLabelValues("failed").Inc()
+		level.Warn(p.logger).Log("msg", "can't encode prefetched series", "err", err)
+		return
+	}
+	p.prefetches.WithLabelValues("success").Inc()
+
+	prefetched := &prefetchRange{start: next.Start, end: next.End, cacheKey: prefetchCacheKey(key, next)}
+	p.cache.Set(ctx, prefetched.cacheKey, data, p.cfg.MaxInterval)
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if current, ok := p.queries.Peek(key); ok && current == query {
+		query.prefetched = prefetched
+	}
+}
+
+// read reads the series of hints, failing with errPrefetchTooLarge as soon
+// as they take more than PrefetchConfig.MaxBytes.
+func (p *Prefetcher) read(ctx context.Context, hints storage.SelectHints, matchers []*labels.Matcher) (*prompb.QueryResult, error) {
+	querier, err := p.queryable.Querier(hints.Start, hints.End)
+	if err != nil {
+		return nil, err
+	}
+	defer querier.Close()
+
+	qr := &prompb.QueryResult{}
+	size := 0
+	set := querier.Select(ctx, true, &hints, matchers...)
+	var it chunkenc.Iterator
+	for set.Next() {
+		s := set.At()
+		it = s.Iterator(it)
+		ts := &prompb.TimeSeries{}
+		s.Labels().Range(func(l labels.Label) {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+			size += len(l.Name) + len(l.Value)
+		})
+		for typ := it.Next(); typ != chunkenc.ValNone; typ = it.Next() {
+			if typ != chunkenc.ValFloat {
+				return nil, errors.Errorf("can't prefetch %s samples", typ)
+			}
+			t, v := it.At()
+			ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: t, Value: v})
+			if size += 16; size > p.cfg.MaxBytes {
+				return nil, errPrefetchTooLarge
+			}
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+		qr.Timeseries = append(qr.Timeseries, ts)
+	}
+	return qr, set.Err()
+}
+
+// prefetchKey identifies the repeats of a select: the same matchers and step
+// over a range of the same length, of the same tenant.
+func prefetchKey(tenant string, hints storage.SelectHints, matchers []*labels.Matcher) string {
+	strs := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		strs = append(strs, m.String())
+	}
+	sort.Strings(strs)
+	return tenant + "\x00" + strconv.FormatInt(hints.Step, 10) + "\x00" + strconv.FormatInt(hints.End-hints.Start, 10) + "\x00" + hints.Func + "\x00" + strings.Join(strs, ",")
+}
+
+// prefetchCacheKey returns the cache key of the series prefetched for hints
+// of the query key. The key is hashed, as the matchers can be longer than the
+// keys of the remote caches.
+func prefetchCacheKey(key string, hints storage.SelectHints) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + strconv.FormatInt(hints.Start, 10) + "\x00" + strconv.FormatInt(hints.End, 10)))
+	return "read-prefetch:" + hex.EncodeToString(sum[:])
+}
+
+// seriesSet returns the prefetched samples between start and end.
+func (r *prefetchResult) seriesSet(start, end int64) storage.SeriesSet {
+	series := make([]storage.Series, 0, len(r.series))
+	for _, s := range r.series {
+		var samples []chunks.Sample
+		for _, sample := range s.samples {
+			if sample.t >= start && sample.t <= end {
+				samples = append(samples, sample)
+			}
+		}
+		if len(samples) > 0 {
+			series = append(series, storage.NewListSeries(s.lbls, samples))
+		}
+	}
+	return &listSeriesSet{series: series, i: -1}
+}
+
+// listSeriesSet is a storage.SeriesSet of series sorted by labels.
+type listSeriesSet struct {
+	series []storage.Series
+	i      int
+}
+
+func (s *listSeriesSet) Next() bool {
+	s.i++
+	return s.i < len(s.series)
+}
+
+func (s *listSeriesSet) At() storage.Series                { return s.series[s.i] }
+func (s *listSeriesSet) Err() error                        { return nil }
+func (s *listSeriesSet) Warnings() annotations.Annotations { return nil }
+
+// floatSample implements chunks.Sample for the prefetched float samples.
+type floatSample struct {
+	t int64
+	f float64
+}
+
+func (s floatSample) T() int64                      { return s.t }
+func (s floatSample) F() float64                    { return s.f }
+func (s floatSample) H() *histogram.Histogram       { return nil }
+func (s floatSample) FH() *histogram.FloatHistogram { return nil }
+func (s floatSample) Type() chunkenc.ValueType      { return chunkenc.ValFloat }
+func (s floatSample) Copy() chunks.Sample           { return s }
@@ -0,0 +1,170 @@
+package remoteread
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon"
+)
+
+// samplesQueryable has a series with a sample every 15s, and records the
+// ranges of its queriers.
+type samplesQueryable struct {
+	mtx    sync.Mutex
+	ranges [][2]int64
+}
+
+func (q *samplesQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	q.mtx.Lock()
+	q.ranges = append(q.ranges, [2]int64{mint, maxt})
+	q.mtx.Unlock()
+	return samplesQuerier{Querier: storage.NoopQuerier(), mint: mint, maxt: maxt}, nil
+}
+
+type samplesQuerier struct {
+	storage.Querier
+	mint, maxt int64
+}
+
+func (q samplesQuerier) Select(context.Context, bool, *storage.SelectHints, ...*labels.Matcher) storage.SeriesSet {
+	var samples []chunks.Sample
+	for t := (q.mint + 14_999) / 15_000 * 15_000; t <= q.maxt; t += 15_000 {
+		samples = append(samples, floatSample{t: t, f: float64(t)})
+	}
+	return &listSeriesSet{series: []storage.Series{storage.NewListSeries(labels.FromStrings("__name__", "up"), samples)}, i: -1}
+}
+
+func TestPrefetcher(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		overlap time.Duration
+		// recentStart is where the read of the most recent samples of the
+		// third select starts.
+		recentStart int64
+	}{
+		{name: "without overlap", recentStart: 655_001},
+		{name: "with overlap", overlap: 20 * time.Second, recentStart: 635_001},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testPrefetcher(t, tc.overlap, tc.recentStart)
+		})
+	}
+}
+
+func testPrefetcher(t *testing.T, overlap time.Duration, recentStart int64) {
+	inner := &samplesQueryable{}
+	reg := prometheus.NewPedanticRegistry()
+	p, err := NewPrefetcher(inner, PrefetchConfig{
+		Enabled:     true,
+		Lead:        5 * time.Second,
+		MaxInterval: time.Minute,
+		MaxQueries:  10,
+		Overlap:     overlap,
+		MaxBytes:    1024 * 1024,
+		Cache:       appcommon.CacheConfig{Backend: appcommon.CacheBackendInMemory, MaxEntries: 10},
+	}, "test", reg, log.NewNopLogger())
+	require.NoError(t, err)
+
+	now := time.UnixMilli(600_000)
+	p.now = func() time.Time { return now }
+	var scheduled []time.Duration
+	var prefetch func()
+	p.afterFunc = func(d time.Duration, f func()) *time.Timer {
+		scheduled = append(scheduled, d)
+		prefetch = f
+		return time.NewTimer(time.Hour)
+	}
+
+	ctx := user.InjectOrgID(context.Background(), "12345")
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")
+	selectRange := func(start, end int64) []int64 {
+		querier, err := p.Querier(start, end)
+		require.NoError(t, err)
+		defer querier.Close()
+		set := querier.Select(ctx, true, &storage.SelectHints{Start: start, End: end, Step: 30_000}, matcher)
+		var timestamps []int64
+		for set.Next() {
+			it := set.At().Iterator(nil)
+			for it.Next() == chunkenc.ValFloat {
+				ts, _ := it.At()
+				timestamps = append(timestamps, ts)
+			}
+		}
+		require.NoError(t, set.Err())
+		return timestamps
+	}
+
+	// The second select of the query, 30s later, schedules the prefetch of
+	// the third 25s later.
+	selectRange(0, 600_000)
+	require.Empty(t, scheduled)
+	now = now.Add(30 * time.Second)
+	selectRange(30_000, 630_000)
+	require.Equal(t, []time.Duration{25 * time.Second}, scheduled)
+
+	now = now.Add(25 * time.Second)
+	inner.ranges = nil
+	prefetch()
+	require.Equal(t, [][2]int64{{60_000, 655_000}}, inner.ranges)
+
+	// The third select only reads the samples more recent than the prefetch,
+	// and the overlap again.
+	now = now.Add(5 * time.Second)
+	inner.ranges = nil
+	timestamps := selectRange(60_000, 660_000)
+	require.Equal(t, [][2]int64{{60_000, 660_000}, {recentStart, 660_000}}, inner.ranges)
+	require.Len(t, timestamps, 41)
+	require.Equal(t, int64(60_000), timestamps[0])
+	require.Equal(t, int64(660_000), timestamps[40])
+
+	// Another tenant's select isn't answered from the prefetch.
+	require.Nil(t, p.observe("other", storage.SelectHints{Start: 60_000, End: 660_000, Step: 30_000}, []*labels.Matcher{matcher}))
+
+	require.Equal(t, 1.0, testutil.ToFloat64(p.hits))
+	require.Equal(t, 1.0, testutil.ToFloat64(p.prefetches.WithLabelValues("success")))
+}
+
+func TestPrefetcher_MaxBytes(t *testing.T) {
+	inner := &samplesQueryable{}
+	reg := prometheus.NewPedanticRegistry()
+	p, err := NewPrefetcher(inner, PrefetchConfig{
+		Enabled:     true,
+		Lead:        5 * time.Second,
+		MaxInterval: time.Minute,
+		MaxQueries:  10,
+		MaxBytes:    100,
+		Cache:       appcommon.CacheConfig{Backend: appcommon.CacheBackendInMemory, MaxEntries: 10},
+	}, "test", reg, log.NewNopLogger())
+	require.NoError(t, err)
+	now := time.UnixMilli(600_000)
+	p.now = func() time.Time { return now }
+	var prefetch func()
+	p.afterFunc = func(_ time.Duration, f func()) *time.Timer {
+		prefetch = f
+		return time.NewTimer(time.Hour)
+	}
+
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")}
+	require.Nil(t, p.observe("12345", storage.SelectHints{Start: 0, End: 600_000, Step: 30_000}, matchers))
+	now = now.Add(30 * time.Second)
+	require.Nil(t, p.observe("12345", storage.SelectHints{Start: 30_000, End: 630_000, Step: 30_000}, matchers))
+	now = now.Add(25 * time.Second)
+	prefetch()
+
+	// The prefetch reads more than 100 bytes of samples, it isn't kept.
+	require.Equal(t, 1.0, testutil.ToFloat64(p.prefetches.WithLabelValues("too_large")))
+	now = now.Add(5 * time.Second)
+	require.Nil(t, p.observe("12345", storage.SelectHints{Start: 60_000, End: 660_000, Step: 30_000}, matchers))
+}
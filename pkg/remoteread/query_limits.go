@@ -0,0 +1,218 @@
+package remoteread
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/util/annotations"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+const (
+	// QueryLimitModeReject fails the selects beyond the limits with a bad
+	// request error naming the limit.
+	QueryLimitModeReject = "reject"
+	// QueryLimitModeClamp reads the part of the range within the limits, and
+	// adds a warning annotation to the result.
+	QueryLimitModeClamp = "clamp"
+)
+
+// QueryLimitsConfig configures the max range and look-back of the selects,
+// enforced before reading from Mimir rather than by Mimir after reading the
+// blocks. The per-tenant overrides take precedence over the defaults.
+type QueryLimitsConfig struct {
+	MaxQueryRange       time.Duration             `yaml:"max_query_range"`
+	MaxLookBack         time.Duration             `yaml:"max_look_back"`
+	Mode                string                    `yaml:"mode"`
+	TenantMaxQueryRange flagext.LimitsMap[string] `yaml:"tenant_max_query_range"`
+	TenantMaxLookBack   flagext.LimitsMap[string] `yaml:"tenant_max_look_back"`
+	TenantMode          flagext.LimitsMap[string] `yaml:"tenant_mode"`
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *QueryLimitsConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	c.TenantMaxQueryRange = flagext.NewLimitsMap[string](validateTenantDuration)
+	c.TenantMaxLookBack = flagext.NewLimitsMap[string](validateTenantDuration)
+	c.TenantMode = flagext.NewLimitsMap[string](validateTenantMode)
+	flags.DurationVar(&c.MaxQueryRange, prefix+"read-limits.max-query-range", 0, "Max time range of a read. 0 to disable.")
+	flags.DurationVar(&c.MaxLookBack, prefix+"read-limits.max-look-back", 0, "Max age of the data read. 0 to disable.")
+	flags.StringVar(&c.Mode, prefix+"read-limits.mode", QueryLimitModeReject, fmt.Sprintf("What to do with reads beyond the limits: %q fails them with a bad request error, %q reads the most recent part of the range within the limits and adds a warning to the result.", QueryLimitModeReject, QueryLimitModeClamp))
+	flags.Var(&c.TenantMaxQueryRange, prefix+"read-limits.tenant-max-query-range", "Per-tenant overrides of the max time range of a read, as a JSON object of tenant to duration, e.g. {\"tenant-1\": \"30d\"}. \"0s\" disables the limit.")
+	flags.Var(&c.TenantMaxLookBack, prefix+"read-limits.tenant-max-look-back", "Per-tenant overrides of the max age of the data read, as a JSON object of tenant to duration, e.g. {\"tenant-1\": \"1y\"}. \"0s\" disables the limit.")
+	flags.Var(&c.TenantMode, prefix+"read-limits.tenant-mode", "Per-tenant overrides of read-limits.mode, as a JSON object of tenant to mode, e.g. {\"tenant-1\": \"clamp\"}.")
+}
+
+func validateTenantDuration(tenant, v string) error {
+	if _, err := model.ParseDuration(v); err != nil {
+		return fmt.Errorf("invalid duration %q for tenant %q", v, tenant)
+	}
+	return nil
+}
+
+func validateTenantMode(tenant, mode string) error {
+	if mode != QueryLimitModeReject && mode != QueryLimitModeClamp {
+		return fmt.Errorf("unknown read limits mode %q for tenant %q", mode, tenant)
+	}
+	return nil
+}
+
+// Validate checks the limits and modes.
+func (c *QueryLimitsConfig) Validate() error {
+	if c.MaxQueryRange < 0 {
+		return errors.New("read max query range can't be negative")
+	}
+	if c.MaxLookBack < 0 {
+		return errors.New("read max look-back can't be negative")
+	}
+	if c.Mode != "" && c.Mode != QueryLimitModeReject && c.Mode != QueryLimitModeClamp {
+		return errors.Errorf("unknown read limits mode %q", c.Mode)
+	}
+	for _, limits := range []flagext.LimitsMap[string]{c.TenantMaxQueryRange, c.TenantMaxLookBack} {
+		for tenant, v := range limits.Read() {
+			if err := validateTenantDuration(tenant, v); err != nil {
+				return err
+			}
+		}
+	}
+	for tenant, mode := range c.TenantMode.Read() {
+		if err := validateTenantMode(tenant, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c QueryLimitsConfig) enabled() bool {
+	return c.MaxQueryRange > 0 || c.MaxLookBack > 0 ||
+		len(c.TenantMaxQueryRange.Read()) > 0 || len(c.TenantMaxLookBack.Read()) > 0
+}
+
+// limits returns the max query range, max look-back and mode of tenant.
+func (c QueryLimitsConfig) limits(tenant string) (maxRange, maxLookBack time.Duration, mode string) {
+	maxRange, maxLookBack, mode = c.MaxQueryRange, c.MaxLookBack, c.Mode
+	if v, ok := c.TenantMaxQueryRange.Read()[tenant]; ok {
+		d, _ := model.ParseDuration(v)
+		maxRange = time.Duration(d)
+	}
+	if v, ok := c.TenantMaxLookBack.Read()[tenant]; ok {
+		d, _ := model.ParseDuration(v)
+		maxLookBack = time.Duration(d)
+	}
+	if v, ok := c.TenantMode.Read()[tenant]; ok {
+		mode = v
+	}
+	return maxRange, maxLookBack, mode
+}
+
+// NewQueryLimitedQueryable returns q, with the range of each select checked
+// against the limits of the tenant of its context before it's read.
+func NewQueryLimitedQueryable(q storage.Queryable, cfg QueryLimitsConfig) storage.Queryable {
+	if !cfg.enabled() {
+		return q
+	}
+	return storage.QueryableFunc(func(mint, maxt int64) (storage.Querier, error) {
+		inner, err := q.Querier(mint, maxt)
+		if err != nil {
+			return nil, err
+		}
+		return &queryLimitedQuerier{Querier: inner, queryable: q, cfg: cfg, mint: mint, maxt: maxt, now: time.Now}, nil
+	})
+}
+
+type queryLimitedQuerier struct {
+	storage.Querier
+	queryable  storage.Queryable
+	cfg        QueryLimitsConfig
+	mint, maxt int64
+	now        func() time.Time
+
+	mtx sync.Mutex
+	// clamped are the queriers of the clamped ranges, closed with the
+	// querier.
+	clamped []storage.Querier
+}
+
+func (q *queryLimitedQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	start, end := q.mint, q.maxt
+	if hints != nil {
+		start, end = hints.Start, hints.End
+	}
+	tenant, _ := user.ExtractOrgID(ctx)
+	maxRange, maxLookBack, mode := q.cfg.limits(tenant)
+
+	var warnings annotations.Annotations
+	if minStart := q.now().Add(-maxLookBack).UnixMilli(); maxLookBack > 0 && start < minStart {
+		msg := fmt.Sprintf("the read starts at %s, beyond the max look-back of %s", time.UnixMilli(start).UTC().Format(time.RFC3339), model.Duration(maxLookBack))
+		if mode != QueryLimitModeClamp {
+			return storage.ErrSeriesSet(errorx.LimitExceeded{Msg: msg, Limit: "max-look-back", Value: q.now().UnixMilli() - start, Max: maxLookBack.Milliseconds()})
+		}
+		warnings.Add(errors.New(msg + ", only the data since " + time.UnixMilli(minStart).UTC().Format(time.RFC3339) + " was read"))
+		start = minStart
+	}
+	if maxRange > 0 && end-start > maxRange.Milliseconds() {
+		msg := fmt.Sprintf("the read range of %s is beyond the max query range of %s", model.Duration(time.Duration(end-start)*time.Millisecond), model.Duration(maxRange))
+		if mode != QueryLimitModeClamp {
+			return storage.ErrSeriesSet(errorx.LimitExceeded{Msg: msg, Limit: "max-query-range", Value: end - start, Max: maxRange.Milliseconds()})
+		}
+		warnings.Add(errors.New(msg + ", only its most recent " + model.Duration(maxRange).String() + " was read"))
+		start = end - maxRange.Milliseconds()
+	}
+	if len(warnings) == 0 {
+		return q.Querier.Select(ctx, sortSeries, hints, matchers...)
+	}
+	if start > end {
+		return warningsSeriesSet{SeriesSet: storage.EmptySeriesSet(), warnings: warnings}
+	}
+
+	clamped := storage.SelectHints{Start: start, End: end}
+	if hints != nil {
+		clamped = *hints
+		clamped.Start = start
+	}
+	querier, err := q.queryable.Querier(start, end)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+	q.mtx.Lock()
+	q.clamped = append(q.clamped, querier)
+	q.mtx.Unlock()
+	return warningsSeriesSet{SeriesSet: querier.Select(ctx, sortSeries, &clamped, matchers...), warnings: warnings}
+}
+
+func (q *queryLimitedQuerier) Close() error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	err := q.Querier.Close()
+	for _, querier := range q.clamped {
+		if cerr := querier.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// warningsSeriesSet adds warnings to the ones of its SeriesSet.
+type warningsSeriesSet struct {
+	storage.SeriesSet
+	warnings annotations.Annotations
+}
+
+func (s warningsSeriesSet) Warnings() annotations.Annotations {
+	return s.warnings.Merge(s.SeriesSet.Warnings())
+}
@@ -0,0 +1,89 @@
+package remoteread
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+func TestQueryLimitedQueryable(t *testing.T) {
+	cfg := QueryLimitsConfig{
+		MaxQueryRange:       time.Hour,
+		MaxLookBack:         24 * time.Hour,
+		Mode:                QueryLimitModeReject,
+		TenantMaxQueryRange: flagext.NewLimitsMapWithData(map[string]string{"unlimited": "0s"}, nil),
+		TenantMaxLookBack:   flagext.NewLimitsMapWithData(map[string]string{"unlimited": "0s"}, nil),
+		TenantMode:          flagext.NewLimitsMapWithData(map[string]string{"clamped": QueryLimitModeClamp}, nil),
+	}
+	require.NoError(t, cfg.Validate())
+
+	now := time.UnixMilli(100 * 3_600_000)
+	inner := &samplesQueryable{}
+	q := NewQueryLimitedQueryable(inner, cfg)
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")
+	selectRange := func(tenant string, start, end int64) storage.SeriesSet {
+		inner.ranges = nil
+		querier, err := q.Querier(start, end)
+		require.NoError(t, err)
+		querier.(*queryLimitedQuerier).now = func() time.Time { return now }
+		t.Cleanup(func() { require.NoError(t, querier.Close()) })
+		set := querier.Select(user.InjectOrgID(context.Background(), tenant), true, &storage.SelectHints{Start: start, End: end}, matcher)
+		for set.Next() {
+		}
+		return set
+	}
+	hour := int64(3_600_000)
+
+	// Within the limits.
+	set := selectRange("tenant", now.UnixMilli()-hour, now.UnixMilli())
+	require.NoError(t, set.Err())
+	require.Empty(t, set.Warnings())
+
+	// Beyond the max range and max look-back, rejected before reading.
+	set = selectRange("tenant", now.UnixMilli()-2*hour, now.UnixMilli())
+	var badRequest errorx.BadRequest
+	require.ErrorAs(t, set.Err(), &badRequest)
+	require.Contains(t, badRequest.Message(), "max query range of 1h")
+	var limitExceeded errorx.LimitExceeded
+	require.ErrorAs(t, set.Err(), &limitExceeded)
+	require.Equal(t, errorx.LimitExceeded{Msg: limitExceeded.Msg, Limit: "max-query-range", Value: 2 * hour, Max: hour}, limitExceeded)
+	require.Equal(t, [][2]int64{{now.UnixMilli() - 2*hour, now.UnixMilli()}}, inner.ranges)
+
+	set = selectRange("tenant", now.UnixMilli()-25*hour, now.UnixMilli()-24*hour-hour/2)
+	require.ErrorAs(t, set.Err(), &badRequest)
+	require.Contains(t, badRequest.Message(), "max look-back of 1d")
+
+	// The tenant overrides disable the limits.
+	set = selectRange("unlimited", now.UnixMilli()-48*hour, now.UnixMilli())
+	require.NoError(t, set.Err())
+	require.Empty(t, set.Warnings())
+
+	// Clamped to the most recent hour within the look-back, with warnings.
+	set = selectRange("clamped", now.UnixMilli()-48*hour, now.UnixMilli()-20*hour)
+	require.NoError(t, set.Err())
+	require.Len(t, set.Warnings(), 2)
+	require.Equal(t, [][2]int64{{now.UnixMilli() - 48*hour, now.UnixMilli() - 20*hour}, {now.UnixMilli() - 21*hour, now.UnixMilli() - 20*hour}}, inner.ranges)
+
+	// Entirely beyond the look-back, nothing is read.
+	set = selectRange("clamped", now.UnixMilli()-48*hour, now.UnixMilli()-47*hour)
+	require.NoError(t, set.Err())
+	require.False(t, set.Next())
+	require.Len(t, set.Warnings(), 1)
+	require.Equal(t, [][2]int64{{now.UnixMilli() - 48*hour, now.UnixMilli() - 47*hour}}, inner.ranges)
+}
+
+func TestQueryLimitsConfig_Validate(t *testing.T) {
+	require.NoError(t, (&QueryLimitsConfig{}).Validate())
+	require.ErrorContains(t, (&QueryLimitsConfig{MaxQueryRange: -time.Second}).Validate(), "can't be negative")
+	require.ErrorContains(t, (&QueryLimitsConfig{Mode: "drop"}).Validate(), "unknown read limits mode")
+	require.ErrorContains(t, (&QueryLimitsConfig{TenantMode: flagext.NewLimitsMapWithData(map[string]string{"a": "drop"}, nil)}).Validate(), `unknown read limits mode "drop" for tenant "a"`)
+	require.ErrorContains(t, (&QueryLimitsConfig{TenantMaxLookBack: flagext.NewLimitsMapWithData(map[string]string{"a": "forever"}, nil)}).Validate(), `invalid duration "forever" for tenant "a"`)
+}
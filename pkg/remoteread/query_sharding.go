@@ -0,0 +1,68 @@
+package remoteread
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// queryShardLabel is the label of the matcher selecting a shard of the series
+// of a read in Mimir, eg. __query_shard__="1_of_4".
+const queryShardLabel = "__query_shard__"
+
+// NewShardedQueryable returns a Queryable splitting each select of its
+// queriers into shards selects of q sent in parallel, each with a matcher on
+// Mimir's __query_shard__ label selecting a shard of the series by their
+// hash, and merging their series. It returns q itself if shards is 1 or less.
+// The series sets are CancelableSeriesSets, cancelling the selects of all the
+// shards.
+func NewShardedQueryable(q storage.Queryable, shards int) storage.Queryable {
+	if shards <= 1 {
+		return q
+	}
+	return shardedQueryable{Queryable: q, shards: shards}
+}
+
+type shardedQueryable struct {
+	storage.Queryable
+	shards int
+}
+
+func (q shardedQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	querier, err := q.Queryable.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	return shardedQuerier{Querier: querier, shards: q.shards}, nil
+}
+
+type shardedQuerier struct {
+	storage.Querier
+	shards int
+}
+
+// Select sorts the series of the shards, whatever sortSeries, to merge them.
+func (q shardedQuerier) Select(ctx context.Context, _ bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	ctx, cancel := context.WithCancel(ctx)
+	sets := make([]storage.SeriesSet, q.shards)
+	var wg sync.WaitGroup
+	for i := range sets {
+		shardMatchers := append(matchers[:len(matchers):len(matchers)], shardMatcher(i, q.shards))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sets[i] = q.Querier.Select(ctx, true, hints, shardMatchers...)
+		}(i)
+	}
+	wg.Wait()
+	return &cancelableSeriesSet{SeriesSet: storage.NewMergeSeriesSet(sets, 0, storage.ChainedSeriesMerge), cancel: cancel}
+}
+
+// shardMatcher returns the matcher of the i-th shard, from 0, out of shards.
+// Mimir numbers the shards from 1.
+func shardMatcher(i, shards int) *labels.Matcher {
+	return labels.MustNewMatcher(labels.MatchEqual, queryShardLabel, fmt.Sprintf("%d_of_%d", i+1, shards))
+}
@@ -0,0 +1,63 @@
+package remoteread
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// shardsQuerier has a series per shard named after the shard of the select,
+// and records the matchers of the selects.
+type shardsQuerier struct {
+	storage.Querier
+	mtx      *sync.Mutex
+	matchers *[][]*labels.Matcher
+}
+
+func (q shardsQuerier) Select(_ context.Context, sortSeries bool, _ *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	q.mtx.Lock()
+	*q.matchers = append(*q.matchers, matchers)
+	q.mtx.Unlock()
+	if !sortSeries {
+		return storage.ErrSeriesSet(errors.New("shards must be sorted to be merged"))
+	}
+	shard := matchers[len(matchers)-1]
+	if shard.Value == "2_of_2" {
+		return &listSeriesSet{series: []storage.Series{storage.NewListSeries(labels.FromStrings("__name__", "a"), nil)}, i: -1}
+	}
+	return &listSeriesSet{series: []storage.Series{storage.NewListSeries(labels.FromStrings("__name__", "b"), nil)}, i: -1}
+}
+
+func TestNewShardedQueryable(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		matchers [][]*labels.Matcher
+	)
+	q := storage.QueryableFunc(func(int64, int64) (storage.Querier, error) {
+		return shardsQuerier{Querier: storage.NoopQuerier(), mtx: &mtx, matchers: &matchers}, nil
+	})
+	require.IsType(t, storage.QueryableFunc(nil), NewShardedQueryable(q, 1))
+
+	querier, err := NewShardedQueryable(q, 2).Querier(0, 1000)
+	require.NoError(t, err)
+	matcher := labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, "a|b")
+	set := querier.Select(context.Background(), false, nil, matcher)
+	require.Implements(t, (*CancelableSeriesSet)(nil), set)
+
+	var names []string
+	for set.Next() {
+		names = append(names, set.At().Labels().Get(labels.MetricName))
+	}
+	require.NoError(t, set.Err())
+	require.Equal(t, []string{"a", "b"}, names)
+
+	require.ElementsMatch(t, [][]*labels.Matcher{
+		{matcher, labels.MustNewMatcher(labels.MatchEqual, "__query_shard__", "1_of_2")},
+		{matcher, labels.MustNewMatcher(labels.MatchEqual, "__query_shard__", "2_of_2")},
+	}, matchers)
+}
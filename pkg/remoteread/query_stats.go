@@ -0,0 +1,179 @@
+package remoteread
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const serverTimingHeader = "Server-Timing"
+
+// The Server-Timing metrics of Mimir's responses collected in QueryStats.
+// Durations are in milliseconds, as dur parameters, and counts are val
+// parameters.
+const (
+	statQueueTime         = "queue_time"
+	statQuerierWallTime   = "querier_wall_time"
+	statFetchedSeries     = "fetched_series"
+	statFetchedChunks     = "fetched_chunks"
+	statFetchedChunkBytes = "fetched_chunk_bytes"
+)
+
+// QueryStats are the query statistics Mimir reported in the Server-Timing
+// headers of the responses to the read requests of a request, added up, so
+// its latency can be attributed to queueing or to fetching the series.
+type QueryStats struct {
+	QueueTime         time.Duration
+	QuerierWallTime   time.Duration
+	FetchedSeries     int64
+	FetchedChunks     int64
+	FetchedChunkBytes int64
+}
+
+type queryStatsContextKey int
+
+const queryStatsKey queryStatsContextKey = 0
+
+type queryStatsRecorder struct {
+	// parent is the recorder of the context the one of the recorder comes
+	// from, if any, collecting its statistics too.
+	parent *queryStatsRecorder
+
+	mtx   sync.Mutex
+	stats QueryStats
+}
+
+// ContextWithQueryStats returns ctx collecting the query statistics of the
+// read requests sent with it, read with QueryStatsFromContext. They're
+// collected in the contexts of ContextWithQueryStats ctx comes from too.
+func ContextWithQueryStats(ctx context.Context) context.Context {
+	parent, _ := ctx.Value(queryStatsKey).(*queryStatsRecorder)
+	return context.WithValue(ctx, queryStatsKey, &queryStatsRecorder{parent: parent})
+}
+
+// QueryStatsFromContext returns the query statistics collected so far in ctx,
+// and false if ctx doesn't come from ContextWithQueryStats.
+func QueryStatsFromContext(ctx context.Context) (QueryStats, bool) {
+	r, ok := ctx.Value(queryStatsKey).(*queryStatsRecorder)
+	if !ok {
+		return QueryStats{}, false
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.stats, true
+}
+
+// QueryStatsMetrics measures the query statistics of the read responses.
+type QueryStatsMetrics struct {
+	durations *prometheus.HistogramVec
+	fetched   *prometheus.CounterVec
+}
+
+func NewQueryStatsMetrics(metricPrefix string, reg prometheus.Registerer) (*QueryStatsMetrics, error) {
+	m := &QueryStatsMetrics{
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricPrefix,
+			Name:      "read_downstream_duration_seconds",
+			Help:      "Durations reported by Mimir in the Server-Timing header of the read responses, by stat: queue_time or querier_wall_time.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"stat"}),
+		fetched: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricPrefix,
+			Name:      "read_downstream_fetched_total",
+			Help:      "Counts reported by Mimir in the Server-Timing header of the read responses, by stat: fetched_series, fetched_chunks or fetched_chunk_bytes.",
+		}, []string{"stat"}),
+	}
+	for _, c := range []prometheus.Collector{m.durations, m.fetched} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// recordQueryStats adds the query statistics of header to the ones of ctx and
+// of the contexts it comes from, if any, and measures them with m, if not
+// nil.
+func recordQueryStats(ctx context.Context, header http.Header, m *QueryStatsMetrics) {
+	stats, ok := parseServerTiming(header.Values(serverTimingHeader))
+	if !ok {
+		return
+	}
+	r, _ := ctx.Value(queryStatsKey).(*queryStatsRecorder)
+	for ; r != nil; r = r.parent {
+		r.mtx.Lock()
+		r.stats.QueueTime += stats.QueueTime
+		r.stats.QuerierWallTime += stats.QuerierWallTime
+		r.stats.FetchedSeries += stats.FetchedSeries
+		r.stats.FetchedChunks += stats.FetchedChunks
+		r.stats.FetchedChunkBytes += stats.FetchedChunkBytes
+		r.mtx.Unlock()
+	}
+	if m == nil {
+		return
+	}
+	m.durations.WithLabelValues(statQueueTime).Observe(stats.QueueTime.Seconds())
+	m.durations.WithLabelValues(statQuerierWallTime).Observe(stats.QuerierWallTime.Seconds())
+	m.fetched.WithLabelValues(statFetchedSeries).Add(float64(stats.FetchedSeries))
+	m.fetched.WithLabelValues(statFetchedChunks).Add(float64(stats.FetchedChunks))
+	m.fetched.WithLabelValues(statFetchedChunkBytes).Add(float64(stats.FetchedChunkBytes))
+}
+
+// parseServerTiming returns the query statistics of the Server-Timing header
+// values, eg. "queue_time;dur=1.5, fetched_series;val=10", and false if
+// there are none. The other metrics and the malformed ones are ignored.
+func parseServerTiming(values []string) (QueryStats, bool) {
+	var (
+		stats QueryStats
+		found bool
+	)
+	for _, value := range values {
+		for _, metric := range strings.Split(value, ",") {
+			params := strings.Split(metric, ";")
+			name := strings.TrimSpace(params[0])
+			for _, param := range params[1:] {
+				k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+				f, err := strconv.ParseFloat(strings.Trim(v, `"`), 64)
+				if err != nil {
+					continue
+				}
+				switch {
+				case k == "dur" && name == statQueueTime:
+					stats.QueueTime += time.Duration(f * float64(time.Millisecond))
+				case k == "dur" && name == statQuerierWallTime:
+					stats.QuerierWallTime += time.Duration(f * float64(time.Millisecond))
+				case k == "val" && name == statFetchedSeries:
+					stats.FetchedSeries += int64(f)
+				case k == "val" && name == statFetchedChunks:
+					stats.FetchedChunks += int64(f)
+				case k == "val" && name == statFetchedChunkBytes:
+					stats.FetchedChunkBytes += int64(f)
+				default:
+					continue
+				}
+				found = true
+			}
+		}
+	}
+	return stats, found
+}
+
+// queryStatsRoundTripper records the query statistics of the responses to
+// the requests it sends.
+type queryStatsRoundTripper struct {
+	next    http.RoundTripper
+	metrics *QueryStatsMetrics
+}
+
+func (t queryStatsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		recordQueryStats(req.Context(), resp.Header, t.metrics)
+	}
+	return resp, err
+}
@@ -0,0 +1,69 @@
+package remoteread
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServerTiming(t *testing.T) {
+	for _, tc := range []struct {
+		values   []string
+		expected QueryStats
+		found    bool
+	}{
+		{values: nil},
+		{values: []string{"total;dur=12.5, auth"}},
+		{
+			values:   []string{"queue_time;dur=1.5, querier_wall_time;dur=20", `fetched_series;val=10, fetched_chunks;val="30";desc="chunks"`},
+			expected: QueryStats{QueueTime: 1500 * time.Microsecond, QuerierWallTime: 20 * time.Millisecond, FetchedSeries: 10, FetchedChunks: 30},
+			found:    true,
+		},
+		{
+			values:   []string{"fetched_chunk_bytes;val=1024, queue_time;dur=oops"},
+			expected: QueryStats{FetchedChunkBytes: 1024},
+			found:    true,
+		},
+	} {
+		stats, found := parseServerTiming(tc.values)
+		require.Equal(t, tc.found, found, "%q", tc.values)
+		require.Equal(t, tc.expected, stats, "%q", tc.values)
+	}
+}
+
+func TestClientQueryStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server-Timing", "queue_time;dur=2, fetched_series;val=3")
+		_, _ = w.Write([]byte(`{"label_values_count_total":0,"label_names_count":0,"labels":[]}`))
+	}))
+	defer srv.Close()
+	metrics, err := NewQueryStatsMetrics("test", prometheus.NewPedanticRegistry())
+	require.NoError(t, err)
+
+	client, err := NewCardinalityClient(Config{Endpoint: srv.URL, Timeout: time.Second, QueryStats: metrics})
+	require.NoError(t, err)
+	ctx := ContextWithQueryStats(user.InjectOrgID(context.Background(), "tenant"))
+	_, err = client.LabelNames(ctx, CardinalityRequest{})
+	require.NoError(t, err)
+	// The stats of nested contexts are collected in the outer ones too.
+	nested := ContextWithQueryStats(ctx)
+	_, err = client.LabelNames(nested, CardinalityRequest{})
+	require.NoError(t, err)
+
+	stats, ok := QueryStatsFromContext(ctx)
+	require.True(t, ok)
+	require.Equal(t, QueryStats{QueueTime: 4 * time.Millisecond, FetchedSeries: 6}, stats)
+	stats, _ = QueryStatsFromContext(nested)
+	require.Equal(t, QueryStats{QueueTime: 2 * time.Millisecond, FetchedSeries: 3}, stats)
+	require.Equal(t, float64(6), testutil.ToFloat64(metrics.fetched.WithLabelValues(statFetchedSeries)))
+
+	_, ok = QueryStatsFromContext(context.Background())
+	require.False(t, ok)
+}
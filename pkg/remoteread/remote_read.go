@@ -0,0 +1,157 @@
+package remoteread
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon"
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+	"github.com/grafana/mimir-graphite/v2/pkg/ctxlog"
+)
+
+// remoteReadPath is the path of the remote read API under the base URL of
+// Mimir's Prometheus API.
+const remoteReadPath = "/api/v1/read"
+
+// NewRemoteReadQueryable returns a Queryable reading the series of tenantID
+// from the Prometheus remote read API at endpoint, eg. Mimir's
+// /prometheus/api/v1/read, sending apiKey with basic auth if set. The key is
+// read for every request, to pick up rotations. Selects
+// failing because some blocks couldn't be read from the store-gateways fail
+// with an errorx.PartialData error. Their series sets are
+// CancelableSeriesSets.
+func NewRemoteReadQueryable(name, endpoint, tenantID string, apiKey secrets.Secret, timeout time.Duration) (storage.Queryable, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &remote.ClientConfig{
+		URL:     &config_util.URL{URL: u},
+		Timeout: model.Duration(timeout),
+		Headers: map[string]string{user.OrgIDHeaderName: tenantID},
+		// The frames of streamed responses are limited to the size of
+		// Prometheus' default rather than to 0, failing every stream.
+		ChunkedReadLimit: promconfig.DefaultChunkedReadLimit,
+	}
+	client, err := remote.NewReadClient(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey.IsSet() {
+		c := client.(*remote.Client)
+		httpClient := *c.Client
+		httpClient.Transport = basicAuthRoundTripper{next: httpClient.Transport, username: tenantID, password: apiKey}
+		c.Client = &httpClient
+	}
+	return newReadClientQueryable(client), nil
+}
+
+// NewQueryable returns a Queryable reading the series of the tenant of the
+// context of the selects from the remote read API under cfg.Endpoint. The
+// selects are checked against cfg.QueryLimits first, then their range is
+// aligned to their step as configured in cfg.StepAlignment, and the aligned
+// ranges are prefetched as configured in cfg.Prefetch. The read requests are
+// sent by cfg.ReadClient if set. Otherwise, the ones failing with network
+// errors or transient 5xx responses are retried as configured in cfg.Retry,
+// and the query statistics of the responses are collected as configured in
+// cfg.QueryStats. The selects are split into cfg.QueryShards parallel reads of
+// a shard of the series each, if more than 1. The large responses are spilled
+// to disk as configured in cfg.Spill. The label names of the matchers are
+// checked as configured in cfg.LabelNameValidation, the slow reads are logged
+// as configured in cfg.SlowReadLog, and the selects whose context has no org
+// ID fail with an errorx.BadRequest error rather than being sent; see
+// QuerierForTenant to read the series of a given tenant.
+//
+// The Prefetcher is nil if prefetching is disabled. Otherwise, run its
+// Handler to cancel the pending prefetches when the app stops.
+func NewQueryable(cfg Config, metricPrefix string, reg prometheus.Registerer, logger log.Logger) (storage.Queryable, *Prefetcher, error) {
+	endpoint, err := cfg.endpointURL()
+	if err != nil {
+		return nil, nil, err
+	}
+	endpoint = endpoint.JoinPath(remoteReadPath)
+	client := cfg.ReadClient
+	if client == nil {
+		if client, err = newReadClient(endpoint, cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	q := newReadClientQueryable(client)
+	if cfg.ConnTrace != nil {
+		q = NewConnTracedQueryable(q, endpoint.Host, cfg.ConnTrace)
+	}
+	q = NewShardedQueryable(q, cfg.QueryShards)
+	q = NewSpillingQueryable(q, cfg.Spill)
+	var prefetcher *Prefetcher
+	if cfg.Prefetch.Enabled {
+		if prefetcher, err = NewPrefetcher(q, cfg.Prefetch, metricPrefix, reg, logger); err != nil {
+			return nil, nil, err
+		}
+		q = prefetcher
+	}
+	q = NewStepAlignedQueryable(q, cfg.StepAlignment)
+	q = NewQueryLimitedQueryable(q, cfg.QueryLimits)
+	if q, err = NewLabelNameValidatingQueryable(q, cfg.LabelNameValidation, cfg); err != nil {
+		return nil, nil, err
+	}
+	q = NewSlowReadLoggingQueryable(q, cfg.SlowReadLog, ctxlog.NewProvider(logger))
+	return orgRequiredQueryable{Queryable: q}, prefetcher, nil
+}
+
+// newReadClient returns a client of the remote read API at endpoint. The
+// requests are sent with the org ID and the query source of their context and
+// the client name and API key of cfg, through the transport of cfg.HTTPClient
+// if set, and retried as configured in cfg.Retry. The query statistics of
+// their responses are recorded.
+func newReadClient(endpoint *url.URL, cfg Config) (remote.ReadClient, error) {
+	chunkedReadLimit := cfg.MaxChunkedFrameBytes
+	if chunkedReadLimit == 0 {
+		chunkedReadLimit = promconfig.DefaultChunkedReadLimit
+	}
+	client, err := remote.NewReadClient("remote-read", &remote.ClientConfig{
+		URL:              &config_util.URL{URL: endpoint},
+		Timeout:          model.Duration(cfg.Timeout),
+		ChunkedReadLimit: chunkedReadLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	base, err := cfg.transport()
+	if err != nil {
+		return nil, err
+	}
+	transport := appcommon.NewTracedAuthRoundTripper(newRetryingRoundTripper(cfg.withAPIKey(base), cfg.Retry), "remote-read")
+	if cfg.HTTPClient != nil && cfg.HTTPClient.Transport != nil {
+		if _, dns := splitDNSEndpoint(cfg.Endpoint); dns {
+			return nil, errors.New("dns+ read endpoints can't be used with a custom HTTP client")
+		}
+		transport = &appcommon.AuthTransport{RoundTripper: newRetryingRoundTripper(cfg.withAPIKey(cfg.HTTPClient.Transport), cfg.Retry)}
+	}
+	transport = attributionRoundTripper{next: transport, cfg: cfg}
+	client.(*remote.Client).Client = &http.Client{Transport: queryStatsRoundTripper{next: transport, metrics: cfg.QueryStats}}
+	return client, nil
+}
+
+// newReadClientQueryable returns a Queryable reading from client, whose
+// selects fail with an errorx.PartialData error when some blocks couldn't be
+// read, and whose series sets are CancelableSeriesSets.
+func newReadClientQueryable(client remote.ReadClient) storage.Queryable {
+	return cancelableQueryable{
+		Queryable: partialDataQueryable{
+			Queryable: remote.NewSampleAndChunkQueryableClient(client, labels.EmptyLabels(), nil, true, func() (int64, error) { return 0, nil }),
+		},
+	}
+}
@@ -0,0 +1,270 @@
+package remoteread
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// streamingServer streams a series as a chunked remote read response, then
+// blocks until the request is cancelled, like a slow read of Mimir. It
+// closes the returned channel once it noticed the cancellation.
+func streamingServer(t *testing.T) (*httptest.Server, <-chan struct{}) {
+	cancelled := make(chan struct{})
+	chunk := chunkenc.NewXORChunk()
+	app, err := chunk.Appender()
+	require.NoError(t, err)
+	app.Append(1000, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse")
+		frame, err := proto.Marshal(&prompb.ChunkedReadResponse{ChunkedSeries: []*prompb.ChunkedSeries{{
+			Labels: []prompb.Label{{Name: labels.MetricName, Value: "up"}},
+			Chunks: []prompb.Chunk{{MinTimeMs: 1000, MaxTimeMs: 1000, Type: prompb.Chunk_XOR, Data: chunk.Bytes()}},
+		}}})
+		require.NoError(t, err)
+		_, err = remote.NewChunkedWriter(w, w.(http.Flusher)).Write(frame)
+		require.NoError(t, err)
+
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, cancelled
+}
+
+func TestRemoteReadCancellation(t *testing.T) {
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")
+
+	for name, cancel := range map[string]func(context.CancelFunc, storage.SeriesSet){
+		"context": func(cancel context.CancelFunc, _ storage.SeriesSet) { cancel() },
+		"series set": func(_ context.CancelFunc, set storage.SeriesSet) {
+			set.(CancelableSeriesSet).Cancel()
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv, cancelled := streamingServer(t)
+			q, err := NewRemoteReadQueryable("test", srv.URL, "12345", secrets.Secret{}, time.Minute)
+			require.NoError(t, err)
+			querier, err := q.Querier(0, 2000)
+			require.NoError(t, err)
+			defer querier.Close()
+
+			ctx, cancelCtx := context.WithCancel(context.Background())
+			defer cancelCtx()
+			set := querier.Select(ctx, true, nil, matcher)
+			require.True(t, set.Next(), "%v", set.Err())
+			require.Equal(t, labels.FromStrings(labels.MetricName, "up"), set.At().Labels())
+
+			start := time.Now()
+			cancel(cancelCtx, set)
+			require.False(t, set.Next())
+			require.ErrorIs(t, set.Err(), context.Canceled)
+			select {
+			case <-cancelled:
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "the read request wasn't cancelled")
+			}
+			require.Less(t, time.Since(start), 5*time.Second)
+		})
+	}
+}
+
+func TestRemoteReadCancelAfterRead(t *testing.T) {
+	var called bool
+	set := &cancelableSeriesSet{SeriesSet: storage.EmptySeriesSet(), cancel: func() { called = true }}
+	require.False(t, set.Next())
+	require.True(t, called)
+}
+
+func TestRemoteReadAPIKeyRotation(t *testing.T) {
+	var auths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, key, _ := r.BasicAuth()
+		auths = append(auths, tenant+":"+key)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "api-key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("first\n"), 0o600))
+	var apiKey secrets.Secret
+	require.NoError(t, apiKey.Set("file:"+keyFile))
+	q, err := NewRemoteReadQueryable("test", srv.URL, "12345", apiKey, time.Second)
+	require.NoError(t, err)
+	querier, err := q.Querier(0, 2000)
+	require.NoError(t, err)
+	defer querier.Close()
+
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")
+	require.Error(t, querier.Select(context.Background(), true, nil, matcher).Err())
+	require.NoError(t, os.WriteFile(keyFile, []byte("rotated\n"), 0o600))
+	require.Error(t, querier.Select(context.Background(), true, nil, matcher).Err())
+	require.Equal(t, []string{"12345:first", "12345:rotated"}, auths)
+}
+
+func TestNewQueryable(t *testing.T) {
+	chunk := chunkenc.NewXORChunk()
+	app, err := chunk.Appender()
+	require.NoError(t, err)
+	app.Append(1000, 1)
+
+	var (
+		tenants []string
+		queries []*prompb.Query
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/prometheus/api/v1/read", r.URL.Path)
+		req, err := remote.DecodeReadRequest(r)
+		require.NoError(t, err)
+		require.Equal(t, prompb.ReadRequest_STREAMED_XOR_CHUNKS, req.AcceptedResponseTypes[0])
+		tenants = append(tenants, r.Header.Get("X-Scope-OrgID"))
+		queries = append(queries, req.Queries...)
+
+		w.Header().Set("Content-Type", "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse")
+		w.Header().Set("Server-Timing", "fetched_chunks;val=1")
+		frame, err := proto.Marshal(&prompb.ChunkedReadResponse{ChunkedSeries: []*prompb.ChunkedSeries{{
+			Labels: []prompb.Label{{Name: labels.MetricName, Value: "up"}},
+			Chunks: []prompb.Chunk{{MinTimeMs: 1000, MaxTimeMs: 1000, Type: prompb.Chunk_XOR, Data: chunk.Bytes()}},
+		}}})
+		require.NoError(t, err)
+		_, err = remote.NewChunkedWriter(w, w.(http.Flusher)).Write(frame)
+		require.NoError(t, err)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := Config{
+		Endpoint:      srv.URL + "/prometheus/",
+		Timeout:       time.Minute,
+		StepAlignment: StepAlignmentConfig{Enabled: true},
+		QueryLimits:   QueryLimitsConfig{MaxQueryRange: time.Minute, Mode: QueryLimitModeReject},
+	}
+	q, prefetcher, err := NewQueryable(cfg, "test", prometheus.NewPedanticRegistry(), log.NewNopLogger())
+	require.NoError(t, err)
+	require.Nil(t, prefetcher)
+
+	ctx := user.InjectOrgID(context.Background(), "12345")
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")
+
+	t.Run("selects are aligned and sent with the tenant of the context", func(t *testing.T) {
+		querier, err := q.Querier(1500, 9500)
+		require.NoError(t, err)
+		defer querier.Close()
+
+		ctx := ContextWithQueryStats(ctx)
+		set := querier.Select(ctx, true, &storage.SelectHints{Start: 1500, End: 9500, Step: 1000}, matcher)
+		require.True(t, set.Next(), "%v", set.Err())
+		require.Equal(t, labels.FromStrings(labels.MetricName, "up"), set.At().Labels())
+		require.False(t, set.Next())
+		require.NoError(t, set.Err())
+		stats, _ := QueryStatsFromContext(ctx)
+		require.Equal(t, QueryStats{FetchedChunks: 1}, stats)
+
+		require.Equal(t, []string{"12345"}, tenants)
+		require.Len(t, queries, 1)
+		require.Equal(t, int64(1000), queries[0].StartTimestampMs)
+		require.Equal(t, int64(10000), queries[0].EndTimestampMs)
+	})
+
+	t.Run("selects beyond the limits aren't sent", func(t *testing.T) {
+		tenants, queries = nil, nil
+		end := time.Hour.Milliseconds()
+		querier, err := q.Querier(0, end)
+		require.NoError(t, err)
+		defer querier.Close()
+
+		set := querier.Select(ctx, true, &storage.SelectHints{Start: 0, End: end}, matcher)
+		require.False(t, set.Next())
+		require.ErrorAs(t, set.Err(), &errorx.LimitExceeded{})
+		require.Empty(t, queries)
+	})
+
+	t.Run("frames are limited", func(t *testing.T) {
+		cfg := cfg
+		cfg.MaxChunkedFrameBytes = 8
+		q, _, err := NewQueryable(cfg, "test", prometheus.NewPedanticRegistry(), log.NewNopLogger())
+		require.NoError(t, err)
+		querier, err := q.Querier(1500, 9500)
+		require.NoError(t, err)
+		defer querier.Close()
+
+		set := querier.Select(ctx, true, &storage.SelectHints{Start: 1500, End: 9500}, matcher)
+		require.False(t, set.Next())
+		require.ErrorContains(t, set.Err(), "limit")
+	})
+
+	t.Run("selects without a tenant aren't sent", func(t *testing.T) {
+		tenants, queries = nil, nil
+		querier, err := q.Querier(1500, 9500)
+		require.NoError(t, err)
+		defer querier.Close()
+
+		set := querier.Select(context.Background(), true, &storage.SelectHints{Start: 1500, End: 9500}, matcher)
+		require.False(t, set.Next())
+		require.ErrorAs(t, set.Err(), &errorx.BadRequest{})
+		require.Empty(t, queries)
+	})
+
+	t.Run("queriers for a tenant send it", func(t *testing.T) {
+		tenants, queries = nil, nil
+		_, err := QuerierForTenant(q, "../12345", 1500, 9500)
+		require.ErrorAs(t, err, &errorx.BadRequest{})
+
+		querier, err := QuerierForTenant(q, "67890", 1500, 9500)
+		require.NoError(t, err)
+		defer querier.Close()
+
+		set := querier.Select(ctx, true, &storage.SelectHints{Start: 1500, End: 9500}, matcher)
+		require.True(t, set.Next(), "%v", set.Err())
+		require.False(t, set.Next())
+		require.NoError(t, set.Err())
+		require.Equal(t, []string{"67890"}, tenants)
+	})
+}
+
+// readClientFunc is a remote.ReadClient calling the function.
+type readClientFunc func(ctx context.Context, query *prompb.Query, sortSeries bool) (storage.SeriesSet, error)
+
+func (f readClientFunc) Read(ctx context.Context, query *prompb.Query, sortSeries bool) (storage.SeriesSet, error) {
+	return f(ctx, query, sortSeries)
+}
+
+func TestNewQueryableReadClient(t *testing.T) {
+	var tenants []string
+	client := readClientFunc(func(ctx context.Context, _ *prompb.Query, _ bool) (storage.SeriesSet, error) {
+		tenant, _ := user.ExtractOrgID(ctx)
+		tenants = append(tenants, tenant)
+		return storage.EmptySeriesSet(), nil
+	})
+	q, _, err := NewQueryable(Config{Endpoint: "http://mimir/prometheus", Timeout: time.Minute, ReadClient: client}, "test", prometheus.NewPedanticRegistry(), log.NewNopLogger())
+	require.NoError(t, err)
+
+	querier, err := q.Querier(0, 1000)
+	require.NoError(t, err)
+	defer querier.Close()
+	set := querier.Select(user.InjectOrgID(context.Background(), "12345"), true, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up"))
+	require.False(t, set.Next())
+	require.NoError(t, set.Err())
+	require.Equal(t, []string{"12345"}, tenants)
+}
@@ -0,0 +1,143 @@
+package remoteread
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/pkg/errors"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+const (
+	defaultReadMaxRetries = 2
+	defaultReadMinBackoff = 100 * time.Millisecond
+	defaultReadMaxBackoff = 2 * time.Second
+)
+
+// RetryConfig configures the retries of the read requests failing with
+// network errors or transient 5xx responses. Only the idempotent requests
+// are retried: the GET requests of the API, and the POST requests of the
+// remote read API, which only read.
+type RetryConfig struct {
+	// MaxRetries is how many times a request is retried, 0 disables the
+	// retries.
+	MaxRetries int           `yaml:"max_retries"`
+	MinBackoff time.Duration `yaml:"min_backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *RetryConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.IntVar(&c.MaxRetries, prefix+"read-retry.max-retries", defaultReadMaxRetries, "How many times the read requests failing with network errors or 5xx responses are retried. 0 disables the retries.")
+	flags.DurationVar(&c.MinBackoff, prefix+"read-retry.min-backoff", defaultReadMinBackoff, "Min time to wait before retrying a failed read request. The backoff doubles at every retry, with jitter.")
+	flags.DurationVar(&c.MaxBackoff, prefix+"read-retry.max-backoff", defaultReadMaxBackoff, "Max time to wait before retrying a failed read request. Requests whose context would expire before the end of the backoff aren't retried.")
+}
+
+func (c *RetryConfig) Validate() error {
+	if c.MaxRetries < 0 {
+		return errors.New("read max retries can't be negative")
+	}
+	if c.MaxRetries > 0 && (c.MinBackoff <= 0 || c.MaxBackoff < c.MinBackoff) {
+		return errors.New("read min backoff must be positive and not above the max backoff")
+	}
+	return nil
+}
+
+// retry calls attempt until it succeeds, fails with an error it doesn't
+// report as retryable, or the retries are exhausted, and returns its last
+// error. The retries stop early rather than waiting past the deadline of
+// ctx.
+func (c RetryConfig) retry(ctx context.Context, attempt func() (retryable bool, _ error)) error {
+	retries := backoff.New(ctx, backoff.Config{
+		MinBackoff: c.MinBackoff,
+		MaxBackoff: c.MaxBackoff,
+	})
+	for {
+		retryable, err := attempt()
+		if err == nil || !retryable || retries.NumRetries() >= c.MaxRetries {
+			return err
+		}
+		delay := retries.NextDelay()
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// errRetryableStatus is returned by the attempts of retryingRoundTripper for
+// the transient 5xx responses.
+var errRetryableStatus = errors.New("retryable response status")
+
+// retryingRoundTripper retries the requests failing with network errors or
+// transient 5xx responses as configured, eg. the remote read requests sent by
+// Prometheus' remote read client, which doesn't retry them. The last
+// response is returned once the retries are exhausted.
+type retryingRoundTripper struct {
+	next http.RoundTripper
+	cfg  RetryConfig
+}
+
+func newRetryingRoundTripper(next http.RoundTripper, cfg RetryConfig) http.RoundTripper {
+	if cfg.MaxRetries <= 0 {
+		return next
+	}
+	return retryingRoundTripper{next: next, cfg: cfg}
+}
+
+func (t retryingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// The body can't be sent again.
+		return t.next.RoundTrip(req)
+	}
+	var resp *http.Response
+	attempts := 0
+	err := t.cfg.retry(req.Context(), func() (bool, error) {
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrBodyLen))
+			_ = resp.Body.Close()
+			resp = nil
+		}
+		attempt := req
+		if attempts > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return false, err
+			}
+			attempt = req.Clone(req.Context())
+			attempt.Body = body
+		}
+		attempts++
+
+		var err error
+		resp, err = t.next.RoundTrip(attempt)
+		if err != nil {
+			return req.Context().Err() == nil, err
+		}
+		if resp.StatusCode == http.StatusInternalServerError || errorx.IsUnavailableStatus(resp.StatusCode) {
+			return true, errRetryableStatus
+		}
+		return false, nil
+	})
+	if errors.Is(err, errRetryableStatus) {
+		return resp, nil
+	}
+	return resp, err
+}
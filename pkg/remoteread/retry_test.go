@@ -0,0 +1,160 @@
+package remoteread
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+func TestClientRetries(t *testing.T) {
+	retry := RetryConfig{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	ctx := user.InjectOrgID(context.Background(), "12345")
+
+	newServer := func(statuses ...int) (*httptest.Server, *atomic.Int32) {
+		calls := &atomic.Int32{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			i := int(calls.Add(1)) - 1
+			if i < len(statuses) {
+				http.Error(w, "failed", statuses[i])
+				return
+			}
+			_, _ = w.Write([]byte(`{"label_values_count_total": 3}`))
+		}))
+		t.Cleanup(srv.Close)
+		return srv, calls
+	}
+
+	t.Run("transient errors are retried", func(t *testing.T) {
+		srv, calls := newServer(http.StatusServiceUnavailable, http.StatusInternalServerError)
+		client, err := NewCardinalityClient(Config{Endpoint: srv.URL, Timeout: time.Second, Retry: retry})
+		require.NoError(t, err)
+
+		names, err := client.LabelNames(ctx, CardinalityRequest{})
+		require.NoError(t, err)
+		require.Equal(t, 3, names.LabelValuesCountTotal)
+		require.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("retries are limited", func(t *testing.T) {
+		srv, calls := newServer(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+		client, err := NewCardinalityClient(Config{Endpoint: srv.URL, Timeout: time.Second, Retry: retry})
+		require.NoError(t, err)
+
+		_, err = client.LabelNames(ctx, CardinalityRequest{})
+		require.ErrorAs(t, err, &errorx.Unavailable{})
+		require.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("client errors aren't retried", func(t *testing.T) {
+		srv, calls := newServer(http.StatusBadRequest, http.StatusNotImplemented)
+		client, err := NewCardinalityClient(Config{Endpoint: srv.URL, Timeout: time.Second, Retry: retry})
+		require.NoError(t, err)
+
+		_, err = client.LabelNames(ctx, CardinalityRequest{})
+		require.ErrorAs(t, err, &errorx.BadRequest{})
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("retries don't wait past the deadline", func(t *testing.T) {
+		srv, calls := newServer(http.StatusServiceUnavailable)
+		slow := RetryConfig{MaxRetries: 2, MinBackoff: time.Minute, MaxBackoff: time.Minute}
+		client, err := NewCardinalityClient(Config{Endpoint: srv.URL, Timeout: time.Second, Retry: slow})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		start := time.Now()
+		_, err = client.LabelNames(ctx, CardinalityRequest{})
+		require.ErrorAs(t, err, &errorx.Unavailable{})
+		require.Equal(t, int32(1), calls.Load())
+		require.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("network errors are retried", func(t *testing.T) {
+		calls := 0
+		httpClient := &http.Client{Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			calls++
+			return nil, errors.New("connection refused")
+		})}
+		client, err := NewCardinalityClient(Config{Endpoint: "http://mimir", Timeout: time.Second, Retry: retry, HTTPClient: httpClient})
+		require.NoError(t, err)
+
+		_, err = client.LabelNames(ctx, CardinalityRequest{})
+		require.ErrorAs(t, err, &errorx.Internal{})
+		require.Equal(t, 3, calls)
+	})
+}
+
+func TestRemoteReadRetries(t *testing.T) {
+	retry := RetryConfig{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	ctx := user.InjectOrgID(context.Background(), "12345")
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")
+
+	for name, tc := range map[string]struct {
+		statuses      []int
+		expectedCalls int32
+		expectedErr   string
+	}{
+		"transient errors are retried": {statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError}, expectedCalls: 3},
+		"retries are limited":          {statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, expectedCalls: 3, expectedErr: "502"},
+		"client errors aren't retried": {statuses: []int{http.StatusBadRequest}, expectedCalls: 1, expectedErr: "400"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			calls := &atomic.Int32{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The body is sent again with every attempt.
+				_, err := remote.DecodeReadRequest(r)
+				require.NoError(t, err)
+				if i := int(calls.Add(1)) - 1; i < len(tc.statuses) {
+					http.Error(w, "failed", tc.statuses[i])
+					return
+				}
+				w.Header().Set("Content-Type", "application/x-protobuf")
+				require.NoError(t, remote.EncodeReadResponse(&prompb.ReadResponse{Results: []*prompb.QueryResult{{}}}, w))
+			}))
+			t.Cleanup(srv.Close)
+
+			q, _, err := NewQueryable(Config{Endpoint: srv.URL, Timeout: time.Minute, Retry: retry}, "test", prometheus.NewPedanticRegistry(), log.NewNopLogger())
+			require.NoError(t, err)
+			querier, err := q.Querier(0, 1000)
+			require.NoError(t, err)
+			defer querier.Close()
+
+			set := querier.Select(ctx, true, nil, matcher)
+			require.False(t, set.Next())
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, set.Err(), tc.expectedErr)
+			} else {
+				require.NoError(t, set.Err())
+			}
+			require.Equal(t, tc.expectedCalls, calls.Load())
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRetryConfig_Validate(t *testing.T) {
+	require.NoError(t, (&RetryConfig{}).Validate())
+	require.NoError(t, (&RetryConfig{MaxRetries: 1, MinBackoff: time.Second, MaxBackoff: time.Second}).Validate())
+	require.ErrorContains(t, (&RetryConfig{MaxRetries: -1}).Validate(), "can't be negative")
+	require.ErrorContains(t, (&RetryConfig{MaxRetries: 1, MinBackoff: time.Second}).Validate(), "not above the max backoff")
+}
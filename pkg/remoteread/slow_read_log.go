@@ -0,0 +1,195 @@
+package remoteread
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/util/annotations"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/ctxlog"
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
+)
+
+// SlowReadLogConfig configures the log of the reads taking longer than a
+// threshold, like the slow query log of databases. The per-tenant overrides
+// take precedence over the default.
+type SlowReadLogConfig struct {
+	Threshold       time.Duration             `yaml:"threshold"`
+	TenantThreshold flagext.LimitsMap[string] `yaml:"tenant_threshold"`
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *SlowReadLogConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	c.TenantThreshold = flagext.NewLimitsMap[string](validateTenantDuration)
+	flags.DurationVar(&c.Threshold, prefix+"read-slow-log.threshold", 0, "Reads taking longer than this are logged with their matchers, time range, tenant, fetched bytes and trace ID. 0 to disable.")
+	flags.Var(&c.TenantThreshold, prefix+"read-slow-log.tenant-threshold", "Per-tenant overrides of read-slow-log.threshold, as a JSON object of tenant to duration, e.g. {\"tenant-1\": \"10s\"}. \"0s\" disables the log.")
+}
+
+// Validate checks the thresholds.
+func (c *SlowReadLogConfig) Validate() error {
+	if c.Threshold < 0 {
+		return errors.New("read slow log threshold can't be negative")
+	}
+	for tenant, v := range c.TenantThreshold.Read() {
+		if err := validateTenantDuration(tenant, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c SlowReadLogConfig) enabled() bool {
+	return c.Threshold > 0 || len(c.TenantThreshold.Read()) > 0
+}
+
+// threshold returns the threshold of tenant, 0 if its reads aren't logged.
+func (c SlowReadLogConfig) threshold(tenant string) time.Duration {
+	if v, ok := c.TenantThreshold.Read()[tenant]; ok {
+		d, _ := model.ParseDuration(v)
+		return time.Duration(d)
+	}
+	return c.Threshold
+}
+
+// NewSlowReadLoggingQueryable returns q, logging the calls of its queriers
+// taking longer than the threshold of the tenant of their context with
+// logs. Selects are timed until their series set is read or cancelled, and
+// are logged with the chunk bytes Mimir reported fetching for them. The
+// series sets of the selects of q stay CancelableSeriesSets.
+func NewSlowReadLoggingQueryable(q storage.Queryable, cfg SlowReadLogConfig, logs ctxlog.Provider) storage.Queryable {
+	if !cfg.enabled() {
+		return q
+	}
+	return storage.QueryableFunc(func(mint, maxt int64) (storage.Querier, error) {
+		querier, err := q.Querier(mint, maxt)
+		if err != nil {
+			return nil, err
+		}
+		return slowReadLoggingQuerier{Querier: querier, cfg: cfg, logs: logs, mint: mint, maxt: maxt, now: time.Now}, nil
+	})
+}
+
+type slowReadLoggingQuerier struct {
+	storage.Querier
+	cfg        SlowReadLogConfig
+	logs       ctxlog.Provider
+	mint, maxt int64
+	now        func() time.Time
+}
+
+func (q slowReadLoggingQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	tenant, _ := user.ExtractOrgID(ctx)
+	threshold := q.cfg.threshold(tenant)
+	if threshold <= 0 {
+		return q.Querier.Select(ctx, sortSeries, hints, matchers...)
+	}
+	start, end := q.mint, q.maxt
+	if hints != nil {
+		start, end = hints.Start, hints.End
+	}
+	ctx = ContextWithQueryStats(ctx)
+	set := &slowReadSeriesSet{
+		querier:   q,
+		ctx:       ctx,
+		threshold: threshold,
+		started:   q.now(),
+		keyvals:   []interface{}{"call", "select", "tenant", tenant, "matchers", selector(matchers), "start", formatLogTime(start), "end", formatLogTime(end)},
+	}
+	set.SeriesSet = q.Querier.Select(ctx, sortSeries, hints, matchers...)
+	return set
+}
+
+func (q slowReadLoggingQuerier) LabelValues(ctx context.Context, name string, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	started := q.now()
+	values, warnings, err := q.Querier.LabelValues(ctx, name, hints, matchers...)
+	q.logIfSlow(ctx, started, err, "call", "label_values", "name", name, "matchers", selector(matchers), "values", len(values))
+	return values, warnings, err
+}
+
+func (q slowReadLoggingQuerier) LabelNames(ctx context.Context, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	started := q.now()
+	names, warnings, err := q.Querier.LabelNames(ctx, hints, matchers...)
+	q.logIfSlow(ctx, started, err, "call", "label_names", "matchers", selector(matchers), "names", len(names))
+	return names, warnings, err
+}
+
+// logIfSlow logs the call started at started with keyvals if it took longer
+// than the threshold of the tenant of ctx.
+func (q slowReadLoggingQuerier) logIfSlow(ctx context.Context, started time.Time, err error, keyvals ...interface{}) {
+	tenant, _ := user.ExtractOrgID(ctx)
+	if threshold := q.cfg.threshold(tenant); threshold > 0 {
+		q.log(ctx, threshold, started, err, append(keyvals, "tenant", tenant, "start", formatLogTime(q.mint), "end", formatLogTime(q.maxt))...)
+	}
+}
+
+func (q slowReadLoggingQuerier) log(ctx context.Context, threshold time.Duration, started time.Time, err error, keyvals ...interface{}) {
+	duration := q.now().Sub(started)
+	if duration < threshold {
+		return
+	}
+	keyvals = append([]interface{}{"msg", "slow read", "duration", duration}, keyvals...)
+	if traceID, ok := middleware.ExtractTraceID(ctx); ok {
+		keyvals = append(keyvals, "traceID", traceID)
+	}
+	if err != nil {
+		keyvals = append(keyvals, "err", err)
+	}
+	q.logs.For(ctx).Warn(keyvals...)
+}
+
+// slowReadSeriesSet logs the select of querier once its series are read or
+// cancelled, if it took longer than threshold.
+type slowReadSeriesSet struct {
+	storage.SeriesSet
+	querier   slowReadLoggingQuerier
+	ctx       context.Context
+	threshold time.Duration
+	started   time.Time
+	keyvals   []interface{}
+
+	series int
+	logged bool
+}
+
+func (s *slowReadSeriesSet) Next() bool {
+	if s.SeriesSet.Next() {
+		s.series++
+		return true
+	}
+	s.done(s.SeriesSet.Err())
+	return false
+}
+
+func (s *slowReadSeriesSet) Cancel() {
+	if c, ok := s.SeriesSet.(CancelableSeriesSet); ok {
+		c.Cancel()
+	}
+	s.done(context.Canceled)
+}
+
+func (s *slowReadSeriesSet) done(err error) {
+	if s.logged {
+		return
+	}
+	s.logged = true
+	stats, _ := QueryStatsFromContext(s.ctx)
+	s.querier.log(s.ctx, s.threshold, s.started, err, append(s.keyvals, "series", s.series, "fetched_chunk_bytes", stats.FetchedChunkBytes)...)
+}
+
+// formatLogTime formats the timestamp t, in milliseconds, for the logs.
+func formatLogTime(t int64) string {
+	return time.UnixMilli(t).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,81 @@
+package remoteread
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/ctxlog"
+)
+
+// fetchingQuerier reports fetching 512 chunk bytes for every select, as
+// Mimir does in the Server-Timing header of its responses.
+type fetchingQuerier struct {
+	storage.Querier
+}
+
+func (q fetchingQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	recordQueryStats(ctx, http.Header{serverTimingHeader: []string{"fetched_chunk_bytes;val=512"}}, nil)
+	return q.Querier.Select(ctx, sortSeries, hints, matchers...)
+}
+
+func TestNewSlowReadLoggingQueryable(t *testing.T) {
+	cfg := SlowReadLogConfig{Threshold: time.Hour, TenantThreshold: flagext.NewLimitsMap[string](validateTenantDuration)}
+	require.NoError(t, cfg.TenantThreshold.Set(`{"slow": "1s"}`))
+	var logs bytes.Buffer
+	provider := ctxlog.NewProvider(log.NewLogfmtLogger(&logs))
+
+	samples := &samplesQueryable{}
+	q := NewSlowReadLoggingQueryable(storage.QueryableFunc(func(mint, maxt int64) (storage.Querier, error) {
+		querier, err := samples.Querier(mint, maxt)
+		return fetchingQuerier{Querier: querier}, err
+	}), cfg, provider)
+	inner, err := q.Querier(0, 60_000)
+	require.NoError(t, err)
+	defer inner.Close()
+	// Every call takes a second.
+	querier := inner.(slowReadLoggingQuerier)
+	clock := time.Unix(0, 0)
+	querier.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")
+
+	read := func(ctx context.Context) {
+		set := querier.Select(ctx, true, &storage.SelectHints{Start: 0, End: 60_000}, matcher)
+		for set.Next() {
+		}
+		require.NoError(t, set.Err())
+	}
+
+	read(user.InjectOrgID(context.Background(), "fast"))
+	require.Empty(t, logs.String())
+
+	ctx := provider.ContextWith(user.InjectOrgID(context.Background(), "slow"), "request_uri", "/render")
+	read(ctx)
+	require.Contains(t, logs.String(), `msg="slow read" duration=1s`)
+	require.Contains(t, logs.String(), `request_uri=/render`)
+	require.Contains(t, logs.String(), `call=select tenant=slow matchers="{__name__=\"up\"}" start=1970-01-01T00:00:00Z end=1970-01-01T00:01:00Z series=1 fetched_chunk_bytes=512`)
+
+	t.Run("label calls", func(t *testing.T) {
+		logs.Reset()
+		_, _, err := querier.LabelNames(ctx, nil, matcher)
+		require.NoError(t, err)
+		require.Contains(t, logs.String(), `call=label_names matchers="{__name__=\"up\"}" names=0 tenant=slow`)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		inner := &samplesQueryable{}
+		require.Same(t, inner, NewSlowReadLoggingQueryable(inner, SlowReadLogConfig{}, provider))
+	})
+}
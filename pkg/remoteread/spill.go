@@ -0,0 +1,311 @@
+package remoteread
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"flag"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/util/annotations"
+)
+
+const defaultSpillMemorySamples = 1_000_000
+
+// SpillConfig configures staging the series of the large selects, eg. of
+// giant one-off exports, in a temporary file: once a select has read
+// MemorySamples samples, its next series are written to the file and
+// iterated from there, trading latency for bounded memory.
+type SpillConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	MemorySamples int    `yaml:"memory_samples"`
+	Dir           string `yaml:"dir"`
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *SpillConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.BoolVar(&c.Enabled, prefix+"read-spill.enabled", false, "Read the whole response of the selects first, keeping the series past read-spill.memory-samples in a temporary file rather than in memory, eg. for large exports.")
+	flags.IntVar(&c.MemorySamples, prefix+"read-spill.memory-samples", defaultSpillMemorySamples, "Number of samples of a select kept in memory before the next series are written to a temporary file.")
+	flags.StringVar(&c.Dir, prefix+"read-spill.dir", "", "Directory of the temporary files of read-spill.enabled. Empty for the default temporary directory.")
+}
+
+func (c *SpillConfig) Validate() error {
+	if c.Enabled && c.MemorySamples <= 0 {
+		return errors.New("read spill memory samples must be positive")
+	}
+	return nil
+}
+
+// NewSpillingQueryable returns a Queryable whose selects read the whole series
+// set of the selects of q on the first call to Next, writing the series past
+// cfg.MemorySamples to a temporary file, or q as is if spilling is disabled.
+// The file is removed as soon as it's created, so it's gone once the series
+// set is read or cancelled, even if the process crashes. The series sets are
+// CancelableSeriesSets.
+func NewSpillingQueryable(q storage.Queryable, cfg SpillConfig) storage.Queryable {
+	if !cfg.Enabled {
+		return q
+	}
+	return spillingQueryable{Queryable: q, cfg: cfg}
+}
+
+type spillingQueryable struct {
+	storage.Queryable
+	cfg SpillConfig
+}
+
+func (q spillingQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	querier, err := q.Queryable.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	return spillingQuerier{Querier: querier, cfg: q.cfg}, nil
+}
+
+type spillingQuerier struct {
+	storage.Querier
+	cfg SpillConfig
+}
+
+func (q spillingQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	return &spillingSeriesSet{set: q.Querier.Select(ctx, sortSeries, hints, matchers...), cfg: q.cfg}
+}
+
+// spillingSeriesSet iterates the series kept in memory, then the ones written
+// to file.
+type spillingSeriesSet struct {
+	set storage.SeriesSet
+	cfg SpillConfig
+
+	loaded   bool
+	memory   []storage.Series
+	file     *os.File
+	reader   *bufio.Reader
+	current  storage.Series
+	err      error
+	warnings annotations.Annotations
+	builder  labels.ScratchBuilder
+}
+
+func (s *spillingSeriesSet) Next() bool {
+	if !s.loaded {
+		s.loaded = true
+		s.err = s.load()
+	}
+	if s.err != nil {
+		s.close()
+		return false
+	}
+	if len(s.memory) > 0 {
+		s.current, s.memory = s.memory[0], s.memory[1:]
+		return true
+	}
+	if s.file == nil {
+		return false
+	}
+	series, err := s.readSeries()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			s.err = errors.Wrap(err, "can't read spilled series")
+		}
+		s.close()
+		return false
+	}
+	s.current = series
+	return true
+}
+
+func (s *spillingSeriesSet) At() storage.Series                { return s.current }
+func (s *spillingSeriesSet) Err() error                        { return s.err }
+func (s *spillingSeriesSet) Warnings() annotations.Annotations { return s.warnings }
+
+func (s *spillingSeriesSet) Cancel() {
+	if set, ok := s.set.(CancelableSeriesSet); ok {
+		set.Cancel()
+	}
+	s.memory = nil
+	s.close()
+}
+
+// load reads the series of the wrapped set, keeping them in memory until
+// there are more than cfg.MemorySamples samples, and writing the next ones to
+// file.
+func (s *spillingSeriesSet) load() error {
+	var (
+		w       *bufio.Writer
+		samples int
+		it      chunkenc.Iterator
+	)
+	for s.set.Next() {
+		series := s.set.At()
+		it = series.Iterator(it)
+		seriesSamples, err := readSamples(it)
+		if err != nil {
+			return err
+		}
+		samples += len(seriesSamples)
+		if w == nil && samples <= s.cfg.MemorySamples {
+			s.memory = append(s.memory, storage.NewListSeries(series.Labels(), seriesSamples))
+			continue
+		}
+		if w == nil {
+			f, err := os.CreateTemp(s.cfg.Dir, "remoteread-spill-")
+			if err != nil {
+				return errors.Wrap(err, "can't create spill file")
+			}
+			s.file = f
+			// The file is only read through its descriptor, so it's removed
+			// once closed, whatever happens to the process.
+			_ = os.Remove(f.Name())
+			w = bufio.NewWriter(f)
+		}
+		if err := writeSeries(w, series.Labels(), seriesSamples); err != nil {
+			return errors.Wrap(err, "can't write spilled series")
+		}
+	}
+	if err := s.set.Err(); err != nil {
+		return err
+	}
+	s.warnings = s.set.Warnings()
+	if w == nil {
+		return nil
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "can't write spilled series")
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "can't read spilled series")
+	}
+	s.reader = bufio.NewReader(s.file)
+	return nil
+}
+
+func (s *spillingSeriesSet) close() {
+	if s.file != nil {
+		_ = s.file.Close()
+		s.file, s.reader = nil, nil
+	}
+}
+
+// readSamples returns the samples of it, floats and histograms.
+func readSamples(it chunkenc.Iterator) ([]chunks.Sample, error) {
+	var samples []chunks.Sample
+	for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+		switch vt {
+		case chunkenc.ValFloat:
+			t, f := it.At()
+			samples = append(samples, spillSample{t: t, f: f})
+		case chunkenc.ValHistogram:
+			t, h := it.AtHistogram(nil)
+			samples = append(samples, spillSample{t: t, h: h})
+		case chunkenc.ValFloatHistogram:
+			t, fh := it.AtFloatHistogram(nil)
+			samples = append(samples, spillSample{t: t, fh: fh})
+		}
+	}
+	return samples, it.Err()
+}
+
+// writeSeries writes a series as a prompb.TimeSeries, prefixed by its size.
+func writeSeries(w *bufio.Writer, lbls labels.Labels, samples []chunks.Sample) error {
+	ts := prompb.TimeSeries{Labels: prompb.FromLabels(lbls, nil)}
+	for _, s := range samples {
+		switch s.Type() {
+		case chunkenc.ValFloat:
+			ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: s.T(), Value: s.F()})
+		case chunkenc.ValHistogram:
+			ts.Histograms = append(ts.Histograms, prompb.FromIntHistogram(s.T(), s.H()))
+		case chunkenc.ValFloatHistogram:
+			ts.Histograms = append(ts.Histograms, prompb.FromFloatHistogram(s.T(), s.FH()))
+		}
+	}
+	data, err := ts.Marshal()
+	if err != nil {
+		return err
+	}
+	var size [binary.MaxVarintLen64]byte
+	if _, err := w.Write(size[:binary.PutUvarint(size[:], uint64(len(data)))]); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readSeries reads the next series written by writeSeries.
+func (s *spillingSeriesSet) readSeries() (storage.Series, error) {
+	size, err := binary.ReadUvarint(s.reader)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(s.reader, data); err != nil {
+		return nil, err
+	}
+	var ts prompb.TimeSeries
+	if err := ts.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	samples := make([]chunks.Sample, 0, len(ts.Samples)+len(ts.Histograms))
+	for _, sample := range ts.Samples {
+		samples = append(samples, spillSample{t: sample.Timestamp, f: sample.Value})
+	}
+	for _, h := range ts.Histograms {
+		if h.IsFloatHistogram() {
+			samples = append(samples, spillSample{t: h.Timestamp, fh: h.ToFloatHistogram()})
+		} else {
+			samples = append(samples, spillSample{t: h.Timestamp, h: h.ToIntHistogram()})
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].T() < samples[j].T() })
+	return storage.NewListSeries(ts.ToLabels(&s.builder, nil), samples), nil
+}
+
+// spillSample implements chunks.Sample for the float and histogram samples of
+// the spilled series.
+type spillSample struct {
+	t  int64
+	f  float64
+	h  *histogram.Histogram
+	fh *histogram.FloatHistogram
+}
+
+func (s spillSample) T() int64                      { return s.t }
+func (s spillSample) F() float64                    { return s.f }
+func (s spillSample) H() *histogram.Histogram       { return s.h }
+func (s spillSample) FH() *histogram.FloatHistogram { return s.fh }
+
+func (s spillSample) Type() chunkenc.ValueType {
+	switch {
+	case s.h != nil:
+		return chunkenc.ValHistogram
+	case s.fh != nil:
+		return chunkenc.ValFloatHistogram
+	}
+	return chunkenc.ValFloat
+}
+
+func (s spillSample) Copy() chunks.Sample {
+	c := s
+	if s.h != nil {
+		c.h = s.h.Copy()
+	}
+	if s.fh != nil {
+		c.fh = s.fh.Copy()
+	}
+	return c
+}
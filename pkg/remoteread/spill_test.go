@@ -0,0 +1,91 @@
+package remoteread
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+	"github.com/prometheus/prometheus/util/teststorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpillingQueryable(t *testing.T) {
+	db := teststorage.New(t)
+	defer db.Close()
+	app := db.Appender(context.Background())
+	for _, name := range []string{"a", "b", "c"} {
+		for ts := int64(0); ts < 3; ts++ {
+			_, err := app.Append(0, labels.FromStrings(labels.MetricName, name), ts*1000, float64(ts))
+			require.NoError(t, err)
+		}
+	}
+	_, err := app.AppendHistogram(0, labels.FromStrings(labels.MetricName, "d"), 1000, tsdbutil.GenerateTestHistogram(1), nil)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	selectAll := func(q storage.Queryable) storage.SeriesSet {
+		querier, err := q.Querier(0, 10_000)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = querier.Close() })
+		return querier.Select(context.Background(), true, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	}
+	expected, err := readSeriesSet(selectAll(db))
+	require.NoError(t, err)
+	require.Len(t, expected, 4)
+
+	require.Same(t, storage.Queryable(db), NewSpillingQueryable(db, SpillConfig{}))
+
+	dir := t.TempDir()
+	set := selectAll(NewSpillingQueryable(db, SpillConfig{Enabled: true, MemorySamples: 4, Dir: dir}))
+	got, err := readSeriesSet(set)
+	require.NoError(t, err)
+	require.Equal(t, expected, got)
+	// The series past the first are read from a file, removed already.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	t.Run("cancel", func(t *testing.T) {
+		set := selectAll(NewSpillingQueryable(db, SpillConfig{Enabled: true, MemorySamples: 1, Dir: dir})).(CancelableSeriesSet)
+		require.True(t, set.Next())
+		require.NotNil(t, set.(*spillingSeriesSet).file)
+		set.Cancel()
+		require.False(t, set.Next())
+		require.Nil(t, set.(*spillingSeriesSet).file)
+	})
+
+	t.Run("spill dir must exist", func(t *testing.T) {
+		set := selectAll(NewSpillingQueryable(db, SpillConfig{Enabled: true, MemorySamples: 1, Dir: filepath.Join(dir, "missing")}))
+		require.False(t, set.Next())
+		require.ErrorContains(t, set.Err(), "can't create spill file")
+	})
+}
+
+type readSeries struct {
+	lbls    string
+	samples []spillSample
+}
+
+// readSeriesSet returns the labels and samples of the series of set.
+func readSeriesSet(set storage.SeriesSet) ([]readSeries, error) {
+	var series []readSeries
+	var it chunkenc.Iterator
+	for set.Next() {
+		it = set.At().Iterator(it)
+		samples, err := readSamples(it)
+		if err != nil {
+			return nil, err
+		}
+		s := readSeries{lbls: set.At().Labels().String()}
+		for _, sample := range samples {
+			s.samples = append(s.samples, sample.(spillSample))
+		}
+		series = append(series, s)
+	}
+	return series, set.Err()
+}
@@ -0,0 +1,141 @@
+package remoteread
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+const defaultMaxAlignmentExpansion = 0.1
+
+// StepAlignmentConfig configures the alignment of the time range of the
+// selects having a step to step boundaries, so the same query sent at
+// different times reads the same range, and the query-frontend can answer it
+// from its results cache.
+type StepAlignmentConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CacheBoundary, if set, further widens the range to multiples of it, eg.
+	// the split interval of the query-frontend, when that reads at most
+	// MaxExpansion times the requested range more.
+	CacheBoundary time.Duration `yaml:"cache_boundary"`
+	MaxExpansion  float64       `yaml:"max_expansion"`
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *StepAlignmentConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.BoolVar(&c.Enabled, prefix+"read-step-alignment.enabled", false, "Align the start and end of the reads with a step to multiples of the step, so the results cache of the query-frontend can answer repeated queries.")
+	flags.DurationVar(&c.CacheBoundary, prefix+"read-step-alignment.cache-boundary", 0, "If set, widen the aligned reads to multiples of this duration, e.g. the split interval of the query-frontend. Only applies when it's a multiple of the step. 0 to disable.")
+	flags.Float64Var(&c.MaxExpansion, prefix+"read-step-alignment.max-expansion", defaultMaxAlignmentExpansion, "Max fraction of the requested range that widening a read to read-step-alignment.cache-boundary may add. Reads that would grow more are only aligned to their step.")
+}
+
+func (c *StepAlignmentConfig) Validate() error {
+	if c.CacheBoundary < 0 {
+		return errors.New("read step alignment cache boundary can't be negative")
+	}
+	if c.MaxExpansion < 0 {
+		return errors.New("read step alignment max expansion can't be negative")
+	}
+	return nil
+}
+
+// alignRange returns the range [start, end] widened to multiples of step,
+// and then of the cache boundary if it's within the expansion budget.
+// Timestamps are in milliseconds.
+func (c StepAlignmentConfig) alignRange(start, end, step int64) (int64, int64) {
+	if step <= 0 || end < start {
+		return start, end
+	}
+	alignedStart, alignedEnd := floorTo(start, step), ceilTo(end, step)
+
+	boundary := c.CacheBoundary.Milliseconds()
+	if boundary <= 0 || boundary%step != 0 {
+		return alignedStart, alignedEnd
+	}
+	cacheStart, cacheEnd := floorTo(alignedStart, boundary), ceilTo(alignedEnd, boundary)
+	if float64((start-cacheStart)+(cacheEnd-end)) > c.MaxExpansion*float64(end-start) {
+		return alignedStart, alignedEnd
+	}
+	return cacheStart, cacheEnd
+}
+
+func floorTo(t, d int64) int64 {
+	r := t % d
+	if r < 0 {
+		r += d
+	}
+	return t - r
+}
+
+func ceilTo(t, d int64) int64 {
+	if f := floorTo(t, d); f != t {
+		return f + d
+	}
+	return t
+}
+
+// NewStepAlignedQueryable returns q, reading the range of each select with a
+// step in its hints aligned as configured. The series read outside the range
+// asked for are left for the PromQL engine to skip, as it does with the
+// lookback.
+func NewStepAlignedQueryable(q storage.Queryable, cfg StepAlignmentConfig) storage.Queryable {
+	if !cfg.Enabled {
+		return q
+	}
+	return storage.QueryableFunc(func(mint, maxt int64) (storage.Querier, error) {
+		inner, err := q.Querier(mint, maxt)
+		if err != nil {
+			return nil, err
+		}
+		return &stepAlignedQuerier{Querier: inner, queryable: q, cfg: cfg}, nil
+	})
+}
+
+type stepAlignedQuerier struct {
+	storage.Querier
+	queryable storage.Queryable
+	cfg       StepAlignmentConfig
+
+	mtx sync.Mutex
+	// aligned are the queriers of the aligned ranges, closed with the
+	// querier.
+	aligned []storage.Querier
+}
+
+func (q *stepAlignedQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	if hints == nil || hints.Step <= 0 {
+		return q.Querier.Select(ctx, sortSeries, hints, matchers...)
+	}
+	aligned := *hints
+	aligned.Start, aligned.End = q.cfg.alignRange(hints.Start, hints.End, hints.Step)
+	querier, err := q.queryable.Querier(aligned.Start, aligned.End)
+	if err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+	q.mtx.Lock()
+	q.aligned = append(q.aligned, querier)
+	q.mtx.Unlock()
+	return querier.Select(ctx, sortSeries, &aligned, matchers...)
+}
+
+func (q *stepAlignedQuerier) Close() error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	err := q.Querier.Close()
+	for _, querier := range q.aligned {
+		if cerr := querier.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
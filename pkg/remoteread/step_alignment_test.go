@@ -0,0 +1,85 @@
+package remoteread
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStepAlignmentConfig_alignRange(t *testing.T) {
+	const minute = int64(time.Minute / time.Millisecond)
+	cfg := StepAlignmentConfig{Enabled: true, CacheBoundary: 10 * time.Minute, MaxExpansion: 0.1}
+
+	for name, tc := range map[string]struct {
+		cfg                StepAlignmentConfig
+		start, end, step   int64
+		wantStart, wantEnd int64
+	}{
+		"aligned to step": {
+			cfg:   StepAlignmentConfig{Enabled: true},
+			start: 61_500, end: 3*minute + 1, step: 15_000,
+			wantStart: 60_000, wantEnd: 3*minute + 15_000,
+		},
+		"already aligned": {
+			cfg:   StepAlignmentConfig{Enabled: true},
+			start: minute, end: 2 * minute, step: minute,
+			wantStart: minute, wantEnd: 2 * minute,
+		},
+		"negative timestamps": {
+			cfg:   StepAlignmentConfig{Enabled: true},
+			start: -90_000, end: -1, step: minute,
+			wantStart: -2 * minute, wantEnd: 0,
+		},
+		"snapped to cache boundary within budget": {
+			cfg:   cfg,
+			start: 2 * minute, end: 6*60*minute + 3*minute, step: minute,
+			wantStart: 0, wantEnd: 6*60*minute + 10*minute,
+		},
+		"cache boundary over budget": {
+			cfg:   cfg,
+			start: 5 * minute, end: 30 * minute, step: minute,
+			wantStart: 5 * minute, wantEnd: 30 * minute,
+		},
+		"cache boundary not a multiple of the step": {
+			cfg:   cfg,
+			start: 2 * minute, end: 6 * 60 * minute, step: 7 * minute,
+			wantStart: 0, wantEnd: 6*60*minute + 4*minute,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			start, end := tc.cfg.alignRange(tc.start, tc.end, tc.step)
+			require.Equal(t, tc.wantStart, start)
+			require.Equal(t, tc.wantEnd, end)
+		})
+	}
+}
+
+func TestNewStepAlignedQueryable(t *testing.T) {
+	type call struct{ mint, maxt int64 }
+	var calls []call
+	q := storage.QueryableFunc(func(mint, maxt int64) (storage.Querier, error) {
+		calls = append(calls, call{mint, maxt})
+		return storage.NoopQuerier(), nil
+	})
+
+	selectAll := func(cfg StepAlignmentConfig) {
+		querier, err := NewStepAlignedQueryable(q, cfg).Querier(1_500, 9_500)
+		require.NoError(t, err)
+		matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "up")
+		querier.Select(context.Background(), false, &storage.SelectHints{Start: 1_500, End: 9_500, Step: 1_000}, matcher)
+		querier.Select(context.Background(), false, &storage.SelectHints{Start: 1_500, End: 9_500}, matcher)
+		require.NoError(t, querier.Close())
+	}
+
+	selectAll(StepAlignmentConfig{})
+	require.Equal(t, []call{{1_500, 9_500}}, calls)
+
+	// Selects without a step read the range of the querier.
+	calls = nil
+	selectAll(StepAlignmentConfig{Enabled: true})
+	require.Equal(t, []call{{1_500, 9_500}, {1_000, 10_000}}, calls)
+}
@@ -0,0 +1,90 @@
+package remoteread
+
+import (
+	"context"
+
+	"github.com/grafana/dskit/tenant"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/util/annotations"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// errNoOrgID fails the reads whose context has no tenant, which Mimir would
+// reject with a 401 otherwise.
+var errNoOrgID = errorx.BadRequest{Msg: "no tenant to read the series of", UserMsg: "missing tenant ID"}
+
+// QuerierForTenant returns a Querier of q reading the series of tenantID
+// between mint and maxt, whatever the tenant of the contexts of its calls, eg.
+// for the background jobs reading the series of a tenant they're configured
+// with. It fails with an errorx.BadRequest error if tenantID isn't a valid
+// tenant ID.
+func QuerierForTenant(q storage.Queryable, tenantID string, mint, maxt int64) (storage.Querier, error) {
+	if err := tenant.ValidTenantID(tenantID); err != nil {
+		return nil, errorx.BadRequest{Msg: "invalid tenant to read the series of", Err: err}
+	}
+	querier, err := q.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	return tenantQuerier{Querier: querier, tenant: tenantID}, nil
+}
+
+// tenantQuerier sends the calls of Querier with the org ID of tenant.
+type tenantQuerier struct {
+	storage.Querier
+	tenant string
+}
+
+func (q tenantQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	return q.Querier.Select(user.InjectOrgID(ctx, q.tenant), sortSeries, hints, matchers...)
+}
+
+func (q tenantQuerier) LabelValues(ctx context.Context, name string, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	return q.Querier.LabelValues(user.InjectOrgID(ctx, q.tenant), name, hints, matchers...)
+}
+
+func (q tenantQuerier) LabelNames(ctx context.Context, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	return q.Querier.LabelNames(user.InjectOrgID(ctx, q.tenant), hints, matchers...)
+}
+
+// orgRequiredQueryable fails the calls of its queriers whose context has no
+// org ID with errNoOrgID, before sending them.
+type orgRequiredQueryable struct {
+	storage.Queryable
+}
+
+func (q orgRequiredQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	querier, err := q.Queryable.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	return orgRequiredQuerier{Querier: querier}, nil
+}
+
+type orgRequiredQuerier struct {
+	storage.Querier
+}
+
+func (q orgRequiredQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	if _, err := user.ExtractOrgID(ctx); err != nil {
+		return &cancelableSeriesSet{SeriesSet: storage.ErrSeriesSet(errNoOrgID), cancel: func() {}}
+	}
+	return q.Querier.Select(ctx, sortSeries, hints, matchers...)
+}
+
+func (q orgRequiredQuerier) LabelValues(ctx context.Context, name string, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	if _, err := user.ExtractOrgID(ctx); err != nil {
+		return nil, nil, errNoOrgID
+	}
+	return q.Querier.LabelValues(ctx, name, hints, matchers...)
+}
+
+func (q orgRequiredQuerier) LabelNames(ctx context.Context, hints *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	if _, err := user.ExtractOrgID(ctx); err != nil {
+		return nil, nil, errNoOrgID
+	}
+	return q.Querier.LabelNames(ctx, hints, matchers...)
+}
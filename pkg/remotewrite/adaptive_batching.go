@@ -0,0 +1,204 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+const (
+	// latencyWindow is how many of the latest write latencies the p99 is
+	// computed from.
+	latencyWindow = 100
+	// minLatencyObservations is how many writes at the current batch size
+	// are measured before growing it.
+	minLatencyObservations = 10
+)
+
+// AdaptiveBatchingConfig configures splitting the writes in batches whose
+// size adapts to the latency of Mimir, instead of sending each write as is.
+type AdaptiveBatchingConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	MinSamples    int           `yaml:"min_samples"`
+	MaxSamples    int           `yaml:"max_samples"`
+	TargetLatency time.Duration `yaml:"target_latency"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *AdaptiveBatchingConfig) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *AdaptiveBatchingConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.BoolVar(&c.Enabled, prefix+"adaptive-batching.enabled", false, "Split the write requests in batches whose size adapts to the latency of Mimir: growing while the p99 latency of the writes is under the target, shrinking when it's above or on 429 and 5xx responses. Requests under the batch size are written as they are, they aren't merged with each other.")
+	flags.IntVar(&c.MinSamples, prefix+"adaptive-batching.min-samples", 500, "Min number of samples per batch, and the size of the first batches.")
+	flags.IntVar(&c.MaxSamples, prefix+"adaptive-batching.max-samples", 50000, "Max number of samples per batch. The series with more samples are written in a batch of their own.")
+	flags.DurationVar(&c.TargetLatency, prefix+"adaptive-batching.target-latency", time.Second, "p99 latency of the writes the batch size adapts to.")
+}
+
+// Validate checks the batch sizes and target latency.
+func (c *AdaptiveBatchingConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MinSamples <= 0 || c.MaxSamples < c.MinSamples {
+		return errors.New("adaptive batching min samples must be positive and not above the max samples")
+	}
+	if c.TargetLatency <= 0 {
+		return errors.New("adaptive batching target latency must be positive")
+	}
+	return nil
+}
+
+// AdaptiveBatchingClient splits the series of each request in batches of at
+// most the current batch size, in samples, written one after the other. It
+// doesn't buffer: requests under the batch size are written as they are,
+// rather than merged up to it. The batch size grows by a quarter while the
+// p99 latency of the writes is under the target, shrinks by a quarter when
+// it's above, and is halved when Mimir answers 429 or 5xx, so the writes back
+// off before Mimir starts failing them.
+type AdaptiveBatchingClient struct {
+	cfg      AdaptiveBatchingConfig
+	client   Client
+	recorder Recorder
+	timeNow  func() time.Time
+
+	mtx  sync.Mutex
+	size int
+	// latencies are the latest latencies of the writes at the current size.
+	latencies []time.Duration
+}
+
+// NewAdaptiveBatchingClient wraps client to write in batches adapting to its
+// latency, or returns client as is if adaptive batching is disabled.
+func NewAdaptiveBatchingClient(client Client, cfg AdaptiveBatchingConfig, recorder Recorder, timeNow func() time.Time) Client {
+	if !cfg.Enabled {
+		return client
+	}
+	recorder.measureBatchSize(cfg.MinSamples)
+	return &AdaptiveBatchingClient{cfg: cfg, client: client, recorder: recorder, timeNow: timeNow, size: cfg.MinSamples}
+}
+
+// Write writes the batches of req, stopping at the first failing. The
+// metadata are written with the first batch.
+//
+// When a batch fails, the batches before it are already written, and a retry
+// of req writes them again. Mimir accepts samples sent again with the same
+// timestamp and value as they are, so only the failed batch and the ones
+// after it are actually ingested by the retry.
+func (c *AdaptiveBatchingClient) Write(ctx context.Context, req *mimirpb.WriteRequest) error {
+	for start := 0; ; {
+		end, samples := start, 0
+		size := c.batchSize()
+		for end < len(req.Timeseries) {
+			n := seriesSamples(req.Timeseries[end])
+			if end > start && samples+n > size {
+				break
+			}
+			samples += n
+			end++
+		}
+
+		batch := req
+		if start > 0 || end < len(req.Timeseries) {
+			batch = &mimirpb.WriteRequest{Timeseries: req.Timeseries[start:end], Source: req.Source, SkipLabelValidation: req.SkipLabelValidation, SkipLabelCountValidation: req.SkipLabelCountValidation}
+			if start == 0 {
+				batch.Metadata = req.Metadata
+			}
+		}
+		t0 := c.timeNow()
+		err := c.client.Write(ctx, batch)
+		c.adapt(c.timeNow().Sub(t0), err)
+		if err != nil {
+			return err
+		}
+		if end == len(req.Timeseries) {
+			return nil
+		}
+		start = end
+	}
+}
+
+func (c *AdaptiveBatchingClient) batchSize() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.size
+}
+
+// adapt adapts the batch size to the latency and error of a write.
+func (c *AdaptiveBatchingClient) adapt(latency time.Duration, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	size := c.size
+	switch {
+	case isOverloaded(err):
+		size /= 2
+	case err != nil:
+		// The other errors, eg. bad requests, say nothing about the load.
+		return
+	default:
+		c.latencies = append(c.latencies, latency)
+		if len(c.latencies) > latencyWindow {
+			c.latencies = c.latencies[1:]
+		}
+		p99 := percentile(c.latencies, 0.99)
+		switch {
+		case p99 > c.cfg.TargetLatency:
+			size -= size / 4
+		case len(c.latencies) >= minLatencyObservations:
+			size += max(size/4, 1)
+		default:
+			return
+		}
+	}
+	size = min(max(size, c.cfg.MinSamples), c.cfg.MaxSamples)
+	if size != c.size {
+		c.size = size
+		c.latencies = c.latencies[:0]
+		c.recorder.measureBatchSize(size)
+	}
+}
+
+// isOverloaded returns whether err is Mimir asking to slow down, with a 429
+// or 5xx response, or failing to answer.
+func isOverloaded(err error) bool {
+	var errx errorx.Error
+	if !errors.As(err, &errx) {
+		return false
+	}
+	code := errx.HTTPStatusCode()
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// percentile returns the q-quantile of latencies, using the nearest rank.
+func percentile(latencies []time.Duration, q float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(math.Ceil(q*float64(len(sorted))))-1]
+}
+
+// seriesSamples returns the number of float and histogram samples of ts,
+// at least 1 so the series without samples, eg. only with exemplars, count.
+func seriesSamples(ts mimirpb.PreallocTimeseries) int {
+	return max(len(ts.Samples)+len(ts.Histograms), 1)
+}
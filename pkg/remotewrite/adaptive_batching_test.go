@@ -0,0 +1,136 @@
+package remotewrite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+)
+
+func TestAdaptiveBatchingClient(t *testing.T) {
+	cfg := AdaptiveBatchingConfig{Enabled: true, MinSamples: 4, MaxSamples: 12, TargetLatency: time.Second}
+	newRequest := func(series, samplesPerSeries int) *mimirpb.WriteRequest {
+		req := &mimirpb.WriteRequest{Metadata: []*mimirpb.MetricMetadata{{MetricFamilyName: "up"}}}
+		for i := 0; i < series; i++ {
+			ts := &mimirpb.TimeSeries{Labels: []mimirpb.LabelAdapter{{Name: "__name__", Value: "up"}}}
+			for j := 0; j < samplesPerSeries; j++ {
+				ts.Samples = append(ts.Samples, mimirpb.Sample{TimestampMs: int64(j), Value: float64(i)})
+			}
+			req.Timeseries = append(req.Timeseries, mimirpb.PreallocTimeseries{TimeSeries: ts})
+		}
+		return req
+	}
+
+	// setup returns the client, writing with the given latency and error,
+	// the sizes in samples of the batches written and the number of
+	// metadata written with them.
+	setup := func(latency *time.Duration, err *error) (Client, *[]int, *[]int, *MockRecorder) {
+		now := time.Unix(0, 0)
+		var batches, metadata []int
+		client := &remotewritemock.Client{}
+		client.On("Write", mock.Anything, mock.Anything).Return(func(_ context.Context, req *mimirpb.WriteRequest) error {
+			samples := 0
+			for _, ts := range req.Timeseries {
+				samples += seriesSamples(ts)
+			}
+			batches = append(batches, samples)
+			metadata = append(metadata, len(req.Metadata))
+			now = now.Add(*latency)
+			return *err
+		})
+		recorder := &MockRecorder{}
+		recorder.On("measureBatchSize", mock.Anything).Return()
+		return NewAdaptiveBatchingClient(client, cfg, recorder, func() time.Time { return now }), &batches, &metadata, recorder
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		client := &remotewritemock.Client{}
+		require.Same(t, client, NewAdaptiveBatchingClient(client, AdaptiveBatchingConfig{}, &MockRecorder{}, time.Now))
+	})
+
+	t.Run("requests are split in batches", func(t *testing.T) {
+		latency, err := 10*time.Millisecond, error(nil)
+		c, batches, metadata, recorder := setup(&latency, &err)
+
+		require.NoError(t, c.Write(context.Background(), newRequest(5, 2)))
+		require.Equal(t, []int{4, 4, 2}, *batches)
+		require.Equal(t, []int{1, 0, 0}, *metadata)
+		recorder.AssertCalled(t, "measureBatchSize", 4)
+
+		// Series with more samples than the batch size are written alone.
+		*batches = nil
+		require.NoError(t, c.Write(context.Background(), newRequest(2, 6)))
+		require.Equal(t, []int{6, 6}, *batches)
+	})
+
+	t.Run("batch size grows under the target latency", func(t *testing.T) {
+		latency, err := 10*time.Millisecond, error(nil)
+		c, batches, _, recorder := setup(&latency, &err)
+
+		for i := 0; i < minLatencyObservations; i++ {
+			require.NoError(t, c.Write(context.Background(), newRequest(1, 1)))
+		}
+		recorder.AssertCalled(t, "measureBatchSize", 5)
+
+		*batches = nil
+		require.NoError(t, c.Write(context.Background(), newRequest(10, 1)))
+		require.Equal(t, []int{5, 5}, *batches)
+
+		for i := 0; i < 10*minLatencyObservations; i++ {
+			require.NoError(t, c.Write(context.Background(), newRequest(1, 1)))
+		}
+		recorder.AssertCalled(t, "measureBatchSize", 12)
+		*batches = nil
+		require.NoError(t, c.Write(context.Background(), newRequest(20, 1)))
+		require.Equal(t, []int{12, 8}, *batches)
+	})
+
+	t.Run("batch size shrinks above the target latency and on overload", func(t *testing.T) {
+		latency, err := 10*time.Millisecond, error(nil)
+		c, batches, _, recorder := setup(&latency, &err)
+		for i := 0; i < 10*minLatencyObservations; i++ {
+			require.NoError(t, c.Write(context.Background(), newRequest(1, 1)))
+		}
+
+		latency = 2 * time.Second
+		*batches = nil
+		require.NoError(t, c.Write(context.Background(), newRequest(24, 1)))
+		require.Equal(t, []int{12, 9, 3}, *batches)
+		recorder.AssertCalled(t, "measureBatchSize", 9)
+		recorder.AssertCalled(t, "measureBatchSize", 6)
+
+		latency, err = 10*time.Millisecond, errorx.TooManyRequests{Msg: "too many write requests"}
+		*batches = nil
+		require.ErrorAs(t, c.Write(context.Background(), newRequest(24, 1)), &errorx.TooManyRequests{})
+		require.Equal(t, []int{6}, *batches)
+
+		// The size doesn't go below the min.
+		err = errorx.Unavailable{Msg: "failed writing metrics"}
+		require.Error(t, c.Write(context.Background(), newRequest(24, 1)))
+		*batches = nil
+		err = nil
+		require.NoError(t, c.Write(context.Background(), newRequest(8, 1)))
+		require.Equal(t, []int{4, 4}, *batches)
+
+		// Bad requests say nothing about the load.
+		err = errorx.BadRequest{Msg: "bad metrics write request"}
+		require.Error(t, c.Write(context.Background(), newRequest(8, 1)))
+		*batches = nil
+		err = nil
+		require.NoError(t, c.Write(context.Background(), newRequest(8, 1)))
+		require.Equal(t, []int{4, 4}, *batches)
+	})
+}
+
+func TestAdaptiveBatchingConfigValidate(t *testing.T) {
+	require.NoError(t, (&AdaptiveBatchingConfig{}).Validate())
+	require.NoError(t, (&AdaptiveBatchingConfig{Enabled: true, MinSamples: 1, MaxSamples: 1, TargetLatency: time.Second}).Validate())
+	require.Error(t, (&AdaptiveBatchingConfig{Enabled: true, MinSamples: 2, MaxSamples: 1, TargetLatency: time.Second}).Validate())
+	require.Error(t, (&AdaptiveBatchingConfig{Enabled: true, MinSamples: 1, MaxSamples: 1}).Validate())
+}
@@ -0,0 +1,42 @@
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
+)
+
+// ReadBody reads the body of the write request r, decompressing it with the
+// shared limited decompressor if it's gzip or deflate encoded, up to maxBytes
+// once decompressed. Bodies larger than that, like the ones rejected by the
+// decompression middleware, fail with an errorx.LimitExceeded error. The
+// other errors reading the body are errorx.BadRequest errors, unless they
+// already are errorx errors.
+func ReadBody(r *http.Request, maxBytes int64) ([]byte, error) {
+	var reader io.Reader = r.Body
+	decompressor, err := middleware.NewLimitedDecompressor(r.Body, r.Header.Get("Content-Encoding"), maxBytes, 0)
+	if err != nil {
+		return nil, errorx.BadRequest{Msg: "can't decompress body", Err: err}
+	}
+	if decompressor != nil {
+		defer decompressor.Close()
+		reader = decompressor
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, maxBytes+1))
+	if err != nil {
+		var errx errorx.Error
+		if errors.As(err, &errx) {
+			return nil, err
+		}
+		return nil, errorx.BadRequest{Msg: "can't read body", Err: err}
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, errorx.LimitExceeded{Msg: fmt.Sprintf("request is larger than the max of %d bytes", maxBytes), Limit: "max-request-size", Value: int64(len(body)), Max: maxBytes}
+	}
+	return body, nil
+}
@@ -0,0 +1,103 @@
+package remotewrite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
+)
+
+// errReader fails every read with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestReadBody(t *testing.T) {
+	compressed := func(encoding, s string) io.Reader {
+		var buf bytes.Buffer
+		var w io.WriteCloser = gzip.NewWriter(&buf)
+		if encoding == "deflate" {
+			w = zlib.NewWriter(&buf)
+		}
+		_, _ = w.Write([]byte(s))
+		_ = w.Close()
+		return &buf
+	}
+	rejected := errorx.LimitExceeded{Msg: "request body too large", Err: middleware.DecompressionLimitError{MaxRatio: 10}}
+
+	for name, tc := range map[string]struct {
+		body     io.Reader
+		encoding string
+		want     string
+		wantErr  error
+	}{
+		"plain": {
+			body: strings.NewReader("0123456789"),
+			want: "0123456789",
+		},
+		"gzip": {
+			body:     compressed("gzip", "0123456789"),
+			encoding: "gzip",
+			want:     "0123456789",
+		},
+		"deflate": {
+			body:     compressed("deflate", "0123456789"),
+			encoding: "deflate",
+			want:     "0123456789",
+		},
+		"plain too large": {
+			body:    strings.NewReader("0123456789a"),
+			wantErr: errorx.LimitExceeded{},
+		},
+		"too large once decompressed": {
+			body:     compressed("gzip", strings.Repeat("0", 1000)),
+			encoding: "gzip",
+			wantErr:  errorx.LimitExceeded{},
+		},
+		"invalid gzip": {
+			body:     strings.NewReader("not gzip"),
+			encoding: "gzip",
+			wantErr:  errorx.BadRequest{},
+		},
+		"rejected by the decompression middleware": {
+			body:    errReader{err: rejected},
+			wantErr: rejected,
+		},
+		"read error": {
+			body:    errReader{err: errors.New("connection reset")},
+			wantErr: errorx.BadRequest{},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", tc.body)
+			if tc.encoding != "" {
+				req.Header.Set("Content-Encoding", tc.encoding)
+			}
+			body, err := ReadBody(req, 10)
+			switch want := tc.wantErr.(type) {
+			case nil:
+				require.NoError(t, err)
+				require.Equal(t, tc.want, string(body))
+			case errorx.LimitExceeded:
+				var got errorx.LimitExceeded
+				require.ErrorAs(t, err, &got)
+				if want.Err != nil {
+					require.Equal(t, want, got)
+				}
+			case errorx.BadRequest:
+				require.ErrorAs(t, err, &errorx.BadRequest{})
+				require.False(t, errors.As(err, &errorx.LimitExceeded{}))
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package carbon
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aggregationRuleLine matches a carbon-aggregator rule,
+// "output_template (frequency) = method input_pattern".
+var aggregationRuleLine = regexp.MustCompile(`^(\S+)\s+\((\d+)\)\s*=\s*(\S+)\s+(\S+)$`)
+
+// outputField matches the fields of an output template, like "<app>" or
+// "<<path>>".
+var outputField = regexp.MustCompile(`<<\w+>>|<\w+>`)
+
+var aggregationMethods = map[string]func([]float64) float64{
+	"sum":   sum,
+	"avg":   func(values []float64) float64 { return sum(values) / float64(len(values)) },
+	"min":   func(values []float64) float64 { return sortedValues(values)[0] },
+	"max":   func(values []float64) float64 { return sortedValues(values)[len(values)-1] },
+	"count": func(values []float64) float64 { return float64(len(values)) },
+	"p50":   percentile(0.5),
+	"p75":   percentile(0.75),
+	"p80":   percentile(0.8),
+	"p90":   percentile(0.9),
+	"p95":   percentile(0.95),
+	"p99":   percentile(0.99),
+	"p999":  percentile(0.999),
+}
+
+// AggregationRule is a carbon-aggregator rule, as found in
+// aggregation-rules.conf:
+//
+//	<env>.applications.<app>.all.requests (60) = sum <env>.applications.<app>.*.requests
+//
+// Points whose name matches the input pattern are aggregated with the method
+// over each interval of the given frequency, into a point named after the
+// output template. In the input pattern, "<field>" captures a path node,
+// "<<field>>" captures one or more nodes and "*" matches any characters
+// within a node; captured fields are substituted in the output template.
+type AggregationRule struct {
+	OutputTemplate string
+	Frequency      time.Duration
+	Method         string
+	InputPattern   string
+
+	regex     *regexp.Regexp
+	aggregate func([]float64) float64
+}
+
+// NewAggregationRule checks and compiles a rule.
+func NewAggregationRule(outputTemplate string, frequency time.Duration, method, inputPattern string) (AggregationRule, error) {
+	aggregate, ok := aggregationMethods[method]
+	if !ok {
+		return AggregationRule{}, fmt.Errorf("unknown aggregation method %q", method)
+	}
+	if frequency < time.Second {
+		return AggregationRule{}, fmt.Errorf("aggregation frequency must be at least 1s, got %s", frequency)
+	}
+	regex, err := inputPatternRegex(inputPattern)
+	if err != nil {
+		return AggregationRule{}, fmt.Errorf("invalid input pattern %q: %w", inputPattern, err)
+	}
+	return AggregationRule{
+		OutputTemplate: outputTemplate,
+		Frequency:      frequency,
+		Method:         method,
+		InputPattern:   inputPattern,
+		regex:          regex,
+		aggregate:      aggregate,
+	}, nil
+}
+
+// ParseAggregationRules parses rules in the aggregation-rules.conf format, one
+// per line. Blank lines and lines starting with "#" are ignored.
+func ParseAggregationRules(r io.Reader) ([]AggregationRule, error) {
+	var rules []AggregationRule
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := aggregationRuleLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: expected \"output_template (frequency) = method input_pattern\", got %q", lineNum, line)
+		}
+		seconds, err := strconv.Atoi(m[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid frequency %q: %w", lineNum, m[2], err)
+		}
+		rule, err := NewAggregationRule(m[1], time.Duration(seconds)*time.Second, m[3], m[4])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// LoadAggregationRules reads the rules from an aggregation-rules.conf file.
+func LoadAggregationRules(path string) ([]AggregationRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseAggregationRules(f)
+}
+
+// Output returns the name of the aggregated point for name, and whether name
+// matches the rule at all.
+func (r AggregationRule) Output(name string) (string, bool) {
+	m := r.regex.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+	output := outputField.ReplaceAllStringFunc(r.OutputTemplate, func(field string) string {
+		if i := r.regex.SubexpIndex(strings.Trim(field, "<>")); i > 0 {
+			return m[i]
+		}
+		return field
+	})
+	return output, true
+}
+
+func inputPatternRegex(pattern string) (*regexp.Regexp, error) {
+	nodes := strings.Split(pattern, ".")
+	parts := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		parts = append(parts, inputNodeRegex(node))
+	}
+	return regexp.Compile("^" + strings.Join(parts, `\.`) + "$")
+}
+
+func inputNodeRegex(node string) string {
+	if i, j := strings.Index(node, "<<"), strings.Index(node, ">>"); i >= 0 && j > i {
+		return inputNodeRegex(node[:i]) + fmt.Sprintf("(?P<%s>.+?)", node[i+2:j]) + inputNodeRegex(node[j+2:])
+	}
+	if i, j := strings.IndexByte(node, '<'), strings.IndexByte(node, '>'); i >= 0 && j > i {
+		return inputNodeRegex(node[:i]) + fmt.Sprintf("(?P<%s>[^.]+?)", node[i+1:j]) + inputNodeRegex(node[j+1:])
+	}
+	if node == "*" {
+		return `[^.]+`
+	}
+	return strings.ReplaceAll(regexp.QuoteMeta(node), `\*`, `[^.]*`)
+}
+
+// Aggregator aggregates points over the intervals of the aggregation rules
+// they match, like carbon-aggregator. The aggregated point of an interval is
+// emitted once the interval has ended and the delay for late points has
+// passed; points arriving after that are dropped.
+type Aggregator struct {
+	rules []AggregationRule
+	delay time.Duration
+
+	mtx       sync.Mutex
+	intervals map[intervalKey]*interval
+}
+
+type intervalKey struct {
+	rule    int
+	output  string
+	startMs int64
+}
+
+type interval struct {
+	endMs  int64
+	values []float64
+}
+
+// NewAggregator creates an Aggregator for rules, waiting for delay after the
+// end of an interval before emitting its aggregated point.
+func NewAggregator(rules []AggregationRule, delay time.Duration) *Aggregator {
+	return &Aggregator{rules: rules, delay: delay, intervals: map[intervalKey]*interval{}}
+}
+
+// Add adds the point to the intervals of all the rules it matches. It returns
+// whether any rule matched, and for how many rules the point was dropped
+// because their interval has already been emitted.
+func (a *Aggregator) Add(p Point, now time.Time) (matched bool, late int) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	for i, rule := range a.rules {
+		output, ok := rule.Output(p.Name)
+		if !ok {
+			continue
+		}
+		matched = true
+
+		frequencyMs := rule.Frequency.Milliseconds()
+		startMs := p.TimestampMs - mod(p.TimestampMs, frequencyMs)
+		endMs := startMs + frequencyMs
+		if a.expired(endMs, now) {
+			late++
+			continue
+		}
+		key := intervalKey{rule: i, output: output, startMs: startMs}
+		iv, ok := a.intervals[key]
+		if !ok {
+			iv = &interval{endMs: endMs}
+			a.intervals[key] = iv
+		}
+		iv.values = append(iv.values, p.Value)
+	}
+	return matched, late
+}
+
+// Flush returns the aggregated points of the intervals that are done at now,
+// timestamped at the start of their interval. If all is set, all the
+// intervals are flushed, even those still open.
+func (a *Aggregator) Flush(now time.Time, all bool) []Point {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var points []Point
+	for key, iv := range a.intervals {
+		if !all && !a.expired(iv.endMs, now) {
+			continue
+		}
+		points = append(points, Point{
+			Name:        key.output,
+			Value:       a.rules[key.rule].aggregate(iv.values),
+			TimestampMs: key.startMs,
+		})
+		delete(a.intervals, key)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].TimestampMs != points[j].TimestampMs {
+			return points[i].TimestampMs < points[j].TimestampMs
+		}
+		return points[i].Name < points[j].Name
+	})
+	return points
+}
+
+func (a *Aggregator) expired(endMs int64, now time.Time) bool {
+	return now.Add(-a.delay).UnixMilli() >= endMs
+}
+
+func mod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// sortedValues sorts values in place and returns them.
+func sortedValues(values []float64) []float64 {
+	sort.Float64s(values)
+	return values
+}
+
+// percentile returns an aggregation computing the given percentile with
+// linear interpolation between the closest ranks, like carbon-aggregator.
+func percentile(factor float64) func([]float64) float64 {
+	return func(values []float64) float64 {
+		values = sortedValues(values)
+		rank := factor * float64(len(values)-1)
+		left, right := int(math.Floor(rank)), int(math.Ceil(rank))
+		if left == right {
+			return values[left]
+		}
+		return values[left]*(float64(right)-rank) + values[right]*(rank-float64(left))
+	}
+}
@@ -0,0 +1,142 @@
+package carbon
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAggregationRules(t *testing.T) {
+	rules, err := ParseAggregationRules(strings.NewReader(`
+# Comments and blank lines are ignored.
+
+<env>.applications.<app>.all.requests (60) = sum <env>.applications.<app>.*.requests
+<env>.all.<<path>>.latency (10) = p90 <env>.hosts.*.<<path>>.latency
+`))
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "<env>.applications.<app>.all.requests", rules[0].OutputTemplate)
+	assert.Equal(t, time.Minute, rules[0].Frequency)
+	assert.Equal(t, "sum", rules[0].Method)
+	assert.Equal(t, "<env>.applications.<app>.*.requests", rules[0].InputPattern)
+	assert.Equal(t, 10*time.Second, rules[1].Frequency)
+
+	for name, tc := range map[string]string{
+		"invalid syntax":      "a.b = sum a.*",
+		"unknown method":      "a.b (60) = median a.*",
+		"zero frequency":      "a.b (0) = sum a.*",
+		"invalid frequency":   "a.b (99999999999999999999) = sum a.*",
+		"missing input field": "a.b (60) = sum",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseAggregationRules(strings.NewReader(tc))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestAggregationRule_Output(t *testing.T) {
+	for name, tc := range map[string]struct {
+		output, input string
+		name          string
+		want          string
+		wantMatch     bool
+	}{
+		"node fields": {
+			output:    "<env>.applications.<app>.all.requests",
+			input:     "<env>.applications.<app>.*.requests",
+			name:      "prod.applications.shop.host1.requests",
+			want:      "prod.applications.shop.all.requests",
+			wantMatch: true,
+		},
+		"multi node field": {
+			output:    "all.<<path>>",
+			input:     "hosts.*.<<path>>",
+			name:      "hosts.a.cpu.user.total",
+			want:      "all.cpu.user.total",
+			wantMatch: true,
+		},
+		"partial node wildcard": {
+			output:    "web.all.cpu",
+			input:     "web.host-*.cpu",
+			name:      "web.host-12.cpu",
+			want:      "web.all.cpu",
+			wantMatch: true,
+		},
+		"wildcard doesn't match across nodes": {
+			output: "a.all",
+			input:  "a.*",
+			name:   "a.b.c",
+		},
+		"dots are literal": {
+			output: "a.all",
+			input:  "a.b",
+			name:   "aXb",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rule, err := NewAggregationRule(tc.output, time.Minute, "sum", tc.input)
+			require.NoError(t, err)
+			got, ok := rule.Output(tc.name)
+			require.Equal(t, tc.wantMatch, ok)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	rules, err := ParseAggregationRules(strings.NewReader(`
+a.sum (60) = sum a.*
+a.avg (60) = avg a.*
+a.min (60) = min a.*
+a.max (60) = max a.*
+a.count (60) = count a.*
+a.p50 (60) = p50 a.*
+a.p90 (60) = p90 a.*
+`))
+	require.NoError(t, err)
+	aggregator := NewAggregator(rules, 10*time.Second)
+
+	start := time.Unix(1700000040, 0)
+	for i, v := range []float64{4, 1, 3, 2} {
+		matched, late := aggregator.Add(Point{Name: "a.b", Value: v, TimestampMs: start.Add(time.Duration(i) * time.Second).UnixMilli()}, start)
+		require.True(t, matched)
+		require.Zero(t, late)
+	}
+	matched, _ := aggregator.Add(Point{Name: "b.c", Value: 1, TimestampMs: start.UnixMilli()}, start)
+	require.False(t, matched)
+
+	// The interval is still open, or waiting for late points.
+	require.Empty(t, aggregator.Flush(start.Add(time.Minute), false))
+
+	points := aggregator.Flush(start.Add(time.Minute+10*time.Second), false)
+	values := map[string]float64{}
+	for _, p := range points {
+		require.Equal(t, start.UnixMilli()-start.UnixMilli()%60000, p.TimestampMs)
+		values[p.Name] = p.Value
+	}
+	require.Equal(t, map[string]float64{
+		"a.sum":   10,
+		"a.avg":   2.5,
+		"a.min":   1,
+		"a.max":   4,
+		"a.count": 4,
+		"a.p50":   2.5,
+		"a.p90":   3.7,
+	}, roundValues(values))
+
+	// Points for an interval that was already flushed are dropped.
+	_, late := aggregator.Add(Point{Name: "a.b", Value: 1, TimestampMs: start.UnixMilli()}, start.Add(2*time.Minute))
+	require.Equal(t, len(rules), late)
+	require.Empty(t, aggregator.Flush(start.Add(time.Hour), true))
+}
+
+func roundValues(values map[string]float64) map[string]float64 {
+	for k, v := range values {
+		values[k] = float64(int64(v*1000+0.5)) / 1000
+	}
+	return values
+}
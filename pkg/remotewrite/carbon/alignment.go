@@ -0,0 +1,360 @@
+package carbon
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DuplicateTimestampKeep writes all the points, leaving the duplicates
+	// for Mimir to reject.
+	DuplicateTimestampKeep = "keep"
+	// DuplicateTimestampFirst writes the first point of each series and
+	// timestamp, and drops the others, as well as the points older than the
+	// last one written, that Mimir would reject as out of order.
+	DuplicateTimestampFirst = "first"
+	// DuplicateTimestampLast holds the point of each series until a point
+	// with a later timestamp arrives, or the interval of the point and the
+	// aggregation delay have passed, and writes the last one received. The
+	// points older than the one held or the last one written are dropped.
+	DuplicateTimestampLast = "last"
+
+	// inferredDeltas is how many of the last intervals between the points of
+	// a series are kept to infer its interval, and minInferredDeltas how many
+	// are needed.
+	inferredDeltas    = 5
+	minInferredDeltas = 3
+
+	// alignmentSeriesIdleTimeout is how long the timestamps of a series that
+	// isn't written are remembered.
+	alignmentSeriesIdleTimeout = time.Hour
+
+	// alignerShards is how many shards the series of the aligner are split
+	// in, each with its own lock, so the connections writing different
+	// series don't wait for each other.
+	alignerShards = 64
+)
+
+// storageSchemaRetention matches the precision of a retention, like "10s" or
+// "60", in the "precision:duration" retentions of storage-schemas.conf.
+var storageSchemaRetention = regexp.MustCompile(`^(\d+)([smhdwy]?)$`)
+
+var precisionUnits = map[string]time.Duration{
+	"":  time.Second,
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour,
+}
+
+// StorageSchema is a carbon storage schema, as found in storage-schemas.conf:
+//
+//	[default_1min_for_1day]
+//	pattern = .*
+//	retentions = 60s:1d,10m:1y
+//
+// Points whose name matches the pattern are stored at the precision of the
+// first retention, their interval, 60s here.
+type StorageSchema struct {
+	Name     string
+	Pattern  *regexp.Regexp
+	Interval time.Duration
+}
+
+// ParseStorageSchemas parses schemas in the storage-schemas.conf format, in
+// the order carbon matches them. Blank lines and lines starting with "#" or
+// ";" are ignored, as are the keys other than pattern and retentions.
+func ParseStorageSchemas(r io.Reader) ([]StorageSchema, error) {
+	var (
+		schemas []StorageSchema
+		current *StorageSchema
+	)
+	done := func() error {
+		if current == nil {
+			return nil
+		}
+		if current.Pattern == nil || current.Interval == 0 {
+			return fmt.Errorf("schema %q needs a pattern and retentions", current.Name)
+		}
+		schemas = append(schemas, *current)
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if err := done(); err != nil {
+				return nil, err
+			}
+			current = &StorageSchema{Name: strings.TrimSpace(line[1 : len(line)-1])}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || current == nil {
+			return nil, fmt.Errorf("line %d: expected \"[name]\" or \"key = value\" in a schema, got %q", lineNum, line)
+		}
+		switch key, value = strings.TrimSpace(key), strings.TrimSpace(value); key {
+		case "pattern":
+			pattern, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid pattern %q: %w", lineNum, value, err)
+			}
+			current.Pattern = pattern
+		case "retentions":
+			precision, _, _ := strings.Cut(strings.Split(value, ",")[0], ":")
+			m := storageSchemaRetention.FindStringSubmatch(strings.TrimSpace(precision))
+			if m == nil {
+				return nil, fmt.Errorf("line %d: invalid retention precision %q", lineNum, precision)
+			}
+			n, err := strconv.Atoi(m[1])
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("line %d: invalid retention precision %q", lineNum, precision)
+			}
+			current.Interval = time.Duration(n) * precisionUnits[m[2]]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := done(); err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+// LoadStorageSchemas reads the schemas from a storage-schemas.conf file.
+func LoadStorageSchemas(path string) ([]StorageSchema, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseStorageSchemas(f)
+}
+
+// aligner floors the timestamps of the points to the interval of their
+// series, from the first storage schema matching their name or else, if
+// enabled, inferred from the cadence of the series. That way the same point
+// sent through several carbon relays gets the same timestamp, and its
+// duplicates are resolved as configured rather than rejected by Mimir as
+// out of order.
+type aligner struct {
+	schemas []StorageSchema
+	infer   bool
+	policy  string
+	delay   time.Duration
+
+	shards [alignerShards]alignerShard
+}
+
+// alignerShard holds the series of the aligner whose key hashes to it.
+type alignerShard struct {
+	mtx       sync.Mutex
+	series    map[string]*seriesTimestamps
+	pending   map[string]*seriesTimestamps
+	lastPrune time.Time
+}
+
+// alignedPoint is a point and the protocol it was received with.
+type alignedPoint struct {
+	Point
+	protocol string
+}
+
+type seriesTimestamps struct {
+	// schemaInterval is the interval of the storage schema of the series, 0
+	// if none matches.
+	schemaInterval int64
+	lastTs         int64
+	hasLastTs      bool
+	deltas         [inferredDeltas]int64
+	numDeltas      int
+	lastSeen       time.Time
+
+	// lastSlot is the aligned timestamp of the last point written, for the
+	// first and last policies.
+	lastSlot    int64
+	hasLastSlot bool
+	// held is the point held, for the last policy, and heldInterval its
+	// interval.
+	held         *alignedPoint
+	heldInterval int64
+}
+
+func newAligner(schemas []StorageSchema, infer bool, policy string, delay time.Duration) *aligner {
+	a := &aligner{
+		schemas: schemas,
+		infer:   infer,
+		policy:  policy,
+		delay:   delay,
+	}
+	for i := range a.shards {
+		a.shards[i].series = map[string]*seriesTimestamps{}
+		a.shards[i].pending = map[string]*seriesTimestamps{}
+	}
+	return a
+}
+
+// seriesKey returns the key of the series of p. The tags are sorted, as
+// carbon doesn't care about their order.
+func seriesKey(p Point) string {
+	if len(p.Tags) == 0 {
+		return p.Name
+	}
+	tags := append([]string(nil), p.Tags...)
+	sort.Strings(tags)
+	return p.Name + ";" + strings.Join(tags, ";")
+}
+
+func (a *aligner) shard(key string) *alignerShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &a.shards[h.Sum32()%alignerShards]
+}
+
+// add aligns the timestamp of p and resolves its duplicates. It returns the
+// points to write now, and how many were dropped as duplicates.
+func (a *aligner) add(p alignedPoint, now time.Time) (points []alignedPoint, duplicates int) {
+	key := seriesKey(p.Point)
+	shard := a.shard(key)
+	shard.mtx.Lock()
+	defer shard.mtx.Unlock()
+
+	s, ok := shard.series[key]
+	if !ok {
+		s = &seriesTimestamps{schemaInterval: a.schemaInterval(p.Name)}
+		shard.series[key] = s
+	}
+	s.lastSeen = now
+
+	interval := a.interval(s, p.TimestampMs)
+	if interval > 0 {
+		p.TimestampMs -= mod(p.TimestampMs, interval)
+	}
+
+	switch a.policy {
+	case DuplicateTimestampFirst:
+		if s.hasLastSlot && p.TimestampMs <= s.lastSlot {
+			return nil, 1
+		}
+		s.lastSlot, s.hasLastSlot = p.TimestampMs, true
+		return []alignedPoint{p}, 0
+	case DuplicateTimestampLast:
+		held := s.held
+		if (s.hasLastSlot && p.TimestampMs <= s.lastSlot) || (held != nil && p.TimestampMs < held.TimestampMs) {
+			return nil, 1
+		}
+		s.held, s.heldInterval = &p, interval
+		shard.pending[key] = s
+		if held == nil {
+			return nil, 0
+		}
+		if held.TimestampMs == p.TimestampMs {
+			return nil, 1
+		}
+		s.lastSlot, s.hasLastSlot = held.TimestampMs, true
+		return []alignedPoint{*held}, 0
+	default:
+		return []alignedPoint{p}, 0
+	}
+}
+
+// schemaInterval returns the interval in milliseconds of the first storage
+// schema matching name, or 0.
+func (a *aligner) schemaInterval(name string) int64 {
+	for _, schema := range a.schemas {
+		if schema.Pattern.MatchString(name) {
+			return schema.Interval.Milliseconds()
+		}
+	}
+	return 0
+}
+
+// interval returns the interval in milliseconds of the series s, after
+// recording its point at ts, or 0 if it's unknown.
+func (a *aligner) interval(s *seriesTimestamps, ts int64) int64 {
+	if s.schemaInterval > 0 || !a.infer {
+		return s.schemaInterval
+	}
+	if s.hasLastTs && ts > s.lastTs {
+		// Carbon timestamps are in seconds, so the jitter of the senders
+		// is rounded away.
+		delta := (ts - s.lastTs + 500) / 1000 * 1000
+		if delta > 0 {
+			copy(s.deltas[1:], s.deltas[:inferredDeltas-1])
+			s.deltas[0] = delta
+			s.numDeltas = min(s.numDeltas+1, inferredDeltas)
+		}
+	}
+	if !s.hasLastTs || ts > s.lastTs {
+		s.lastTs, s.hasLastTs = ts, true
+	}
+	if s.numDeltas < minInferredDeltas {
+		return 0
+	}
+	// The median is the cadence of the series, even if some points were
+	// lost or sent twice.
+	deltas := make([]int64, s.numDeltas)
+	copy(deltas, s.deltas[:s.numDeltas])
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+	return deltas[len(deltas)/2]
+}
+
+// flush returns the points held whose interval and the delay have passed at
+// now, or all of them if all is set. It also forgets the series that haven't
+// been written for a while.
+func (a *aligner) flush(now time.Time, all bool) []alignedPoint {
+	var points []alignedPoint
+	for i := range a.shards {
+		points = a.shards[i].flush(points, now, a.delay, all)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].TimestampMs != points[j].TimestampMs {
+			return points[i].TimestampMs < points[j].TimestampMs
+		}
+		return points[i].Name < points[j].Name
+	})
+	return points
+}
+
+// flush appends the points held in the shard that are due to points, and
+// forgets its idle series.
+func (s *alignerShard) flush(points []alignedPoint, now time.Time, delay time.Duration, all bool) []alignedPoint {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for key, series := range s.pending {
+		if !all && now.Add(-delay).UnixMilli() < series.held.TimestampMs+series.heldInterval {
+			continue
+		}
+		points = append(points, *series.held)
+		series.lastSlot, series.hasLastSlot = series.held.TimestampMs, true
+		series.held = nil
+		delete(s.pending, key)
+	}
+
+	if now.Sub(s.lastPrune) >= alignmentSeriesIdleTimeout {
+		for key, series := range s.series {
+			if series.held == nil && now.Sub(series.lastSeen) >= alignmentSeriesIdleTimeout {
+				delete(s.series, key)
+			}
+		}
+		s.lastPrune = now
+	}
+	return points
+}
@@ -0,0 +1,144 @@
+package carbon
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStorageSchemas(t *testing.T) {
+	schemas, err := ParseStorageSchemas(strings.NewReader(`
+# Comments and blank lines are ignored.
+[carbon]
+pattern = ^carbon\.
+retentions = 60:90d
+
+[collectd]
+pattern = ^collectd\.
+retentions = 10s:1d,1m:30d
+xFilesFactor = 0.5
+
+[default]
+pattern = .*
+retentions = 1m:7d
+`))
+	require.NoError(t, err)
+	require.Len(t, schemas, 3)
+	assert.Equal(t, "carbon", schemas[0].Name)
+	assert.Equal(t, time.Minute, schemas[0].Interval)
+	assert.True(t, schemas[1].Pattern.MatchString("collectd.host.cpu"))
+	assert.Equal(t, 10*time.Second, schemas[1].Interval)
+	assert.Equal(t, time.Minute, schemas[2].Interval)
+
+	for name, tc := range map[string]string{
+		"key outside a schema": "pattern = .*",
+		"missing retentions":   "[a]\npattern = .*",
+		"invalid pattern":      "[a]\npattern = (\nretentions = 1m:1d",
+		"invalid precision":    "[a]\npattern = .*\nretentions = 1x:1d",
+		"zero precision":       "[a]\npattern = .*\nretentions = 0s:1d",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseStorageSchemas(strings.NewReader(tc))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestAligner(t *testing.T) {
+	schemas, err := ParseStorageSchemas(strings.NewReader("[a]\npattern = ^a\\.\nretentions = 10s:1d"))
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+	add := func(a *aligner, name string, ts int64, value float64) ([]int64, int) {
+		points, duplicates := a.add(alignedPoint{Point: Point{Name: name, TimestampMs: ts, Value: value}, protocol: protocolPlaintext}, now)
+		var timestamps []int64
+		for _, p := range points {
+			timestamps = append(timestamps, p.TimestampMs)
+		}
+		return timestamps, duplicates
+	}
+
+	t.Run("first", func(t *testing.T) {
+		a := newAligner(schemas, false, DuplicateTimestampFirst, time.Second)
+		timestamps, duplicates := add(a, "a.b", 12_000, 1)
+		assert.Equal(t, []int64{10_000}, timestamps)
+		assert.Zero(t, duplicates)
+		// The same point through another relay, a bit later.
+		timestamps, duplicates = add(a, "a.b", 13_000, 1)
+		assert.Empty(t, timestamps)
+		assert.Equal(t, 1, duplicates)
+		timestamps, _ = add(a, "a.b", 21_000, 2)
+		assert.Equal(t, []int64{20_000}, timestamps)
+		// A late duplicate of an earlier slot.
+		timestamps, duplicates = add(a, "a.b", 14_000, 1)
+		assert.Empty(t, timestamps)
+		assert.Equal(t, 1, duplicates)
+		// Series matching no schema are kept as they are.
+		timestamps, _ = add(a, "b.c", 12_345, 1)
+		assert.Equal(t, []int64{12_345}, timestamps)
+	})
+
+	t.Run("last", func(t *testing.T) {
+		a := newAligner(schemas, false, DuplicateTimestampLast, time.Second)
+		timestamps, _ := add(a, "a.b", 12_000, 1)
+		assert.Empty(t, timestamps)
+		_, duplicates := add(a, "a.b", 13_000, 2)
+		assert.Equal(t, 1, duplicates)
+		timestamps, _ = add(a, "a.b", 21_000, 3)
+		assert.Equal(t, []int64{10_000}, timestamps)
+
+		// The point of the interval [20s, 30s) is held until 31s.
+		assert.Empty(t, a.flush(time.UnixMilli(30_999), false))
+		points := a.flush(time.UnixMilli(31_000), false)
+		require.Len(t, points, 1)
+		assert.Equal(t, int64(20_000), points[0].TimestampMs)
+		assert.Equal(t, 3.0, points[0].Value)
+		assert.Equal(t, protocolPlaintext, points[0].protocol)
+		assert.Empty(t, a.flush(time.UnixMilli(31_000), true))
+
+		// Points older than the ones written are dropped.
+		timestamps, duplicates = add(a, "a.b", 15_000, 4)
+		assert.Empty(t, timestamps)
+		assert.Equal(t, 1, duplicates)
+		timestamps, duplicates = add(a, "a.b", 22_000, 4)
+		assert.Empty(t, timestamps)
+		assert.Equal(t, 1, duplicates)
+		assert.Empty(t, a.flush(time.UnixMilli(31_000), true))
+	})
+
+	t.Run("last drops points older than the one held", func(t *testing.T) {
+		a := newAligner(schemas, false, DuplicateTimestampLast, time.Second)
+		add(a, "a.b", 21_000, 1)
+		timestamps, duplicates := add(a, "a.b", 12_000, 2)
+		assert.Empty(t, timestamps)
+		assert.Equal(t, 1, duplicates)
+		points := a.flush(time.UnixMilli(31_000), false)
+		require.Len(t, points, 1)
+		assert.Equal(t, int64(20_000), points[0].TimestampMs)
+		assert.Equal(t, 1.0, points[0].Value)
+	})
+
+	t.Run("tags are in any order", func(t *testing.T) {
+		a := newAligner(schemas, false, DuplicateTimestampFirst, time.Second)
+		p := alignedPoint{Point: Point{Name: "a.b", Tags: []string{"x=1", "y=2"}, TimestampMs: 12_000}}
+		points, _ := a.add(p, now)
+		require.Len(t, points, 1)
+		p.Tags = []string{"y=2", "x=1"}
+		points, duplicates := a.add(p, now)
+		assert.Empty(t, points)
+		assert.Equal(t, 1, duplicates)
+	})
+
+	t.Run("inferred interval", func(t *testing.T) {
+		a := newAligner(nil, true, DuplicateTimestampKeep, time.Second)
+		var got []int64
+		// A 30s cadence with up to a second of jitter.
+		for _, ts := range []int64{0, 30_400, 59_800, 90_300, 120_700} {
+			timestamps, _ := add(a, "b.c", ts, 1)
+			got = append(got, timestamps...)
+		}
+		assert.Equal(t, []int64{0, 30_400, 59_800, 90_000, 120_000}, got)
+	})
+}
@@ -0,0 +1,82 @@
+package carbon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// EncodeCacheQuery encodes a carbon-cache query for the datapoints of metric
+// not yet written to its whisper file, as sent to carbon-cache's query port by
+// graphite-web's CarbonLink. The returned message is length prefixed.
+func EncodeCacheQuery(metric string) []byte {
+	var b bytes.Buffer
+	b.Write(make([]byte, pickleHeaderSize))
+	// {'type': 'cache-query', 'metric': metric}, with pickle protocol 2.
+	b.Write([]byte{opProto, 2, opEmptyDict, opMark})
+	for _, s := range []string{"type", "cache-query", "metric", metric} {
+		writePickleUnicode(&b, s)
+	}
+	b.Write([]byte{opSetItems, opStop})
+
+	msg := b.Bytes()
+	binary.BigEndian.PutUint32(msg, uint32(len(msg)-pickleHeaderSize))
+	return msg
+}
+
+func writePickleUnicode(b *bytes.Buffer, s string) {
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(s)))
+	b.WriteByte(opBinUnicode)
+	b.Write(size[:])
+	b.WriteString(s)
+}
+
+// DecodeCacheQueryResponse decodes the payload of carbon-cache's response to a
+// cache query: a pickled {'datapoints': [(timestamp, value), ...]} dict, or
+// {'error': message} if the query failed. The returned points only have their
+// timestamp and value set.
+func DecodeCacheQueryResponse(payload []byte) ([]Point, error) {
+	v, err := unpickle(payload)
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := v.(pickleDict)
+	if !ok {
+		return nil, fmt.Errorf("expected a dict, got %T", v)
+	}
+	if msg, ok := resp["error"]; ok {
+		return nil, fmt.Errorf("carbon-cache query failed: %v", msg)
+	}
+
+	var items []interface{}
+	switch v := resp["datapoints"].(type) {
+	case *pickleList:
+		items = v.items
+	case pickleTuple:
+		items = v
+	case nil:
+		return nil, errors.New("no datapoints in the response")
+	default:
+		return nil, fmt.Errorf("expected a list of datapoints, got %T", v)
+	}
+
+	points := make([]Point, 0, len(items))
+	for i, item := range items {
+		datapoint, ok := item.(pickleTuple)
+		if !ok || len(datapoint) != 2 {
+			return nil, fmt.Errorf("datapoint %d: expected a (timestamp, value) tuple, got %v", i, item)
+		}
+		ts, err := pickleFloat(datapoint[0])
+		if err != nil {
+			return nil, fmt.Errorf("datapoint %d: invalid timestamp: %w", i, err)
+		}
+		value, err := pickleFloat(datapoint[1])
+		if err != nil {
+			return nil, fmt.Errorf("datapoint %d: invalid value: %w", i, err)
+		}
+		points = append(points, Point{Value: value, TimestampMs: int64(ts * 1000)})
+	}
+	return points, nil
+}
@@ -0,0 +1,71 @@
+package carbon
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeCacheQuery(t *testing.T) {
+	msg := EncodeCacheQuery("servers.web-1.cpu")
+	payload, err := ReadPickleMessage(bytes.NewReader(msg), len(msg))
+	require.NoError(t, err)
+
+	v, err := unpickle(payload)
+	require.NoError(t, err)
+	require.Equal(t, pickleDict{"type": "cache-query", "metric": "servers.web-1.cpu"}, v)
+}
+
+func TestDecodeCacheQueryResponse(t *testing.T) {
+	wantPoints := []Point{
+		{Value: 1.5, TimestampMs: 1650000000000},
+		{Value: 2, TimestampMs: 1650000060000},
+	}
+
+	// The payloads are python's pickle.dumps of
+	// {'datapoints': [(1650000000, 1.5), (1650000060, 2.0)]}, unless noted.
+	for name, tc := range map[string]struct {
+		payload string
+		want    []Point
+		wantErr string
+	}{
+		"protocol 0": {
+			payload: "(dp0\nVdatapoints\np1\n(lp2\n(I1650000000\nF1.5\ntp3\na(I1650000060\nF2.0\ntp4\nas.",
+			want:    wantPoints,
+		},
+		"protocol 2": {
+			payload: "\x80\x02}q\x00X\n\x00\x00\x00datapointsq\x01]q\x02(J\x80\x00YbG?\xf8\x00\x00\x00\x00\x00\x00\x86q\x03J\xbc\x00YbG@\x00\x00\x00\x00\x00\x00\x00\x86q\x04es.",
+			want:    wantPoints,
+		},
+		"no datapoints": {
+			// {'datapoints': []}
+			payload: "\x80\x02}q\x00X\n\x00\x00\x00datapointsq\x01]q\x02s.",
+			want:    []Point{},
+		},
+		"error": {
+			// {'error': 'boom'}
+			payload: "\x80\x02}q\x00X\x05\x00\x00\x00errorq\x01X\x04\x00\x00\x00boomq\x02s.",
+			wantErr: "carbon-cache query failed: boom",
+		},
+		"not a dict": {
+			payload: "I1\n.",
+			wantErr: "expected a dict",
+		},
+		"not a datapoint": {
+			// {'datapoints': [1]}
+			payload: "(dp0\nVdatapoints\np1\n(lp2\nI1\nas.",
+			wantErr: "datapoint 0: expected a (timestamp, value) tuple",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := DecodeCacheQueryResponse([]byte(tc.payload))
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
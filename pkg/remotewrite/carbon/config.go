@@ -0,0 +1,134 @@
+package carbon
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/server"
+)
+
+const (
+	defaultMaxPickleMessageSize = 1024 * 1024
+	defaultMaxLineLength        = 16 * 1024
+	defaultBatchSize            = 1000
+	defaultIdleTimeout          = 2 * time.Minute
+	defaultAggregationDelay     = 5 * time.Second
+
+	// QueueFullBlock and QueueFullShed are the policies of the listeners
+	// when the write queue is full: stop reading until there's room, or drop
+	// the points.
+	QueueFullBlock = "block"
+	QueueFullShed  = "shed"
+)
+
+type Config struct {
+	PlaintextListenAddress    string        `yaml:"plaintext_listen_address"`
+	PlaintextUDPListenAddress string        `yaml:"plaintext_udp_listen_address"`
+	PickleListenAddress       string        `yaml:"pickle_listen_address"`
+	MaxPickleMessageSize      int           `yaml:"max_pickle_message_size"`
+	MaxLineLength             int           `yaml:"max_line_length"`
+	BatchSize                 int           `yaml:"batch_size"`
+	IdleTimeout               time.Duration `yaml:"idle_timeout"`
+	// Tenant, if set, is injected in the context passed to the Appendable.
+	Tenant string `yaml:"tenant"`
+
+	AggregationRulesFile     string        `yaml:"aggregation_rules_file"`
+	AggregationDelay         time.Duration `yaml:"aggregation_delay"`
+	AggregationForwardInputs bool          `yaml:"aggregation_forward_inputs"`
+
+	// WriteQueueSize bounds the batches of points committed at the same time
+	// by all the listeners. When it's full, each protocol blocks or sheds
+	// according to its policy.
+	WriteQueueSize              int    `yaml:"write_queue_size"`
+	PlaintextQueueFullPolicy    string `yaml:"plaintext_queue_full_policy"`
+	PlaintextUDPQueueFullPolicy string `yaml:"plaintext_udp_queue_full_policy"`
+	PickleQueueFullPolicy       string `yaml:"pickle_queue_full_policy"`
+
+	// StorageSchemasFile and InferInterval set the interval the timestamps
+	// of the points are floored to, DuplicateTimestampPolicy what happens to
+	// the points of a series with the same timestamp.
+	StorageSchemasFile       string `yaml:"storage_schemas_file"`
+	InferInterval            bool   `yaml:"infer_interval"`
+	DuplicateTimestampPolicy string `yaml:"duplicate_timestamp_policy"`
+
+	// Listen is how the listeners are opened, set from the server config of
+	// the app.
+	Listen server.ListenConfig `yaml:"-"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *Config) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *Config) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.StringVar(&c.PlaintextListenAddress, prefix+"carbon.plaintext-listen-address", ":2003", "TCP address to accept the carbon plaintext protocol on. Empty to disable.")
+	flags.StringVar(&c.PlaintextUDPListenAddress, prefix+"carbon.plaintext-udp-listen-address", "", "UDP address to accept the carbon plaintext protocol on. Empty to disable.")
+	flags.StringVar(&c.PickleListenAddress, prefix+"carbon.pickle-listen-address", ":2004", "TCP address to accept the carbon pickle protocol on. Empty to disable.")
+	flags.IntVar(&c.MaxPickleMessageSize, prefix+"carbon.max-pickle-message-size", defaultMaxPickleMessageSize, "Max size in bytes of a pickle message. Connections sending larger messages are closed.")
+	flags.IntVar(&c.MaxLineLength, prefix+"carbon.max-line-length", defaultMaxLineLength, "Max length in bytes of a line of the TCP plaintext protocol, including its newline. Connections sending longer lines are closed.")
+	flags.IntVar(&c.BatchSize, prefix+"carbon.batch-size", defaultBatchSize, "Max number of points appended before committing. Points are also committed whenever a connection has no more data buffered.")
+	flags.DurationVar(&c.IdleTimeout, prefix+"carbon.idle-timeout", defaultIdleTimeout, "Connections that don't send anything for this long are closed. 0 to disable.")
+	flags.StringVar(&c.Tenant, prefix+"carbon.tenant", "", "Tenant the received points are written for.")
+	flags.StringVar(&c.AggregationRulesFile, prefix+"carbon.aggregation-rules-file", "", "Path to a carbon-aggregator aggregation-rules.conf file. If set, matching points are aggregated into new series as carbon-aggregator does.")
+	flags.DurationVar(&c.AggregationDelay, prefix+"carbon.aggregation-delay", defaultAggregationDelay, "How long to wait for late points after an aggregation interval ends before writing its aggregated point.")
+	flags.BoolVar(&c.AggregationForwardInputs, prefix+"carbon.aggregation-forward-inputs", true, "Whether points matching an aggregation rule are also written as they are. Points not matching any rule are always written.")
+	flags.IntVar(&c.WriteQueueSize, prefix+"carbon.write-queue-size", 0, "Max number of batches of points committed at the same time by all the listeners. When it's full, each protocol blocks or sheds according to its policy. 0 for no limit.")
+	flags.StringVar(&c.PlaintextQueueFullPolicy, prefix+"carbon.plaintext-queue-full-policy", QueueFullBlock, "What the TCP plaintext listener does when the write queue is full: block stops reading from the connection until there's room, pushing back on the sender, shed drops the points.")
+	flags.StringVar(&c.PlaintextUDPQueueFullPolicy, prefix+"carbon.plaintext-udp-queue-full-policy", QueueFullShed, "What the UDP plaintext listener does when the write queue is full: block stops reading packets, which the kernel drops once its buffer is full, shed drops the points and counts them.")
+	flags.StringVar(&c.PickleQueueFullPolicy, prefix+"carbon.pickle-queue-full-policy", QueueFullBlock, "What the pickle listener does when the write queue is full: block or shed.")
+	flags.StringVar(&c.StorageSchemasFile, prefix+"carbon.storage-schemas-file", "", "Path to a carbon storage-schemas.conf file. If set, the timestamps of the points are floored to the precision of the first retention of the first schema matching their name.")
+	flags.BoolVar(&c.InferInterval, prefix+"carbon.infer-interval", false, "Floor the timestamps of the points matching no storage schema to the interval of their series, inferred from the timestamps of its last points.")
+	flags.StringVar(&c.DuplicateTimestampPolicy, prefix+"carbon.duplicate-timestamp-policy", DuplicateTimestampKeep, fmt.Sprintf("What to do with the points of a series with the same timestamp, e.g. sent by several carbon relays: %q writes them all, %q writes the first one, %q writes the last one received before a later point or the end of its interval and carbon.aggregation-delay.", DuplicateTimestampKeep, DuplicateTimestampFirst, DuplicateTimestampLast))
+}
+
+func (c *Config) alignmentEnabled() bool {
+	return c.StorageSchemasFile != "" || c.InferInterval ||
+		(c.DuplicateTimestampPolicy != "" && c.DuplicateTimestampPolicy != DuplicateTimestampKeep)
+}
+
+// Validate checks that at least one listener is enabled and that the limits
+// are usable.
+func (c *Config) Validate() error {
+	if c.PlaintextListenAddress == "" && c.PlaintextUDPListenAddress == "" && c.PickleListenAddress == "" {
+		return errors.New("at least one carbon listen address must be set")
+	}
+	if c.PickleListenAddress != "" && c.MaxPickleMessageSize <= 0 {
+		return errors.New("max pickle message size must be positive")
+	}
+	if c.PlaintextListenAddress != "" && c.MaxLineLength <= 0 {
+		return errors.New("carbon max line length must be positive")
+	}
+	if c.BatchSize <= 0 {
+		return errors.New("carbon batch size must be positive")
+	}
+	if c.IdleTimeout < 0 {
+		return errors.New("carbon idle timeout can't be negative")
+	}
+	if c.AggregationDelay < 0 {
+		return errors.New("carbon aggregation delay can't be negative")
+	}
+	if c.WriteQueueSize < 0 {
+		return errors.New("carbon write queue size can't be negative")
+	}
+	for _, policy := range []string{c.PlaintextQueueFullPolicy, c.PlaintextUDPQueueFullPolicy, c.PickleQueueFullPolicy} {
+		if policy != "" && policy != QueueFullBlock && policy != QueueFullShed {
+			return fmt.Errorf("unknown carbon queue full policy %q, must be %s or %s", policy, QueueFullBlock, QueueFullShed)
+		}
+	}
+	switch c.DuplicateTimestampPolicy {
+	case "", DuplicateTimestampKeep, DuplicateTimestampFirst, DuplicateTimestampLast:
+	default:
+		return fmt.Errorf("unknown carbon duplicate timestamp policy %q, must be %s, %s or %s", c.DuplicateTimestampPolicy, DuplicateTimestampKeep, DuplicateTimestampFirst, DuplicateTimestampLast)
+	}
+	return nil
+}
@@ -0,0 +1,588 @@
+package carbon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pickleHeaderSize is the size of the big endian length prefix of each
+// message of the carbon pickle protocol.
+const pickleHeaderSize = 4
+
+// ErrPickleMessageTooLarge is returned by ReadPickleMessage when the length
+// prefix of a message exceeds the allowed size. The stream can't be resumed
+// after it.
+var ErrPickleMessageTooLarge = errors.New("pickle message too large")
+
+// ReadPickleMessage reads one length prefixed message of the carbon pickle
+// protocol from r, and returns its payload.
+func ReadPickleMessage(r io.Reader, maxSize int) ([]byte, error) {
+	var header [pickleHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if uint64(size) > uint64(maxSize) {
+		return nil, fmt.Errorf("%w: %d bytes, max %d", ErrPickleMessageTooLarge, size, maxSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+// DecodePickle decodes the payload of a carbon pickle message: a pickled list
+// of (path, (timestamp, value)) tuples. Timestamps are in seconds; negative
+// ones mean now.
+//
+// Only the opcodes needed to pickle lists, tuples, dicts, strings and numbers
+// are supported. In particular, no class can be loaded, so it's safe to decode
+// untrusted payloads.
+func DecodePickle(payload []byte, now time.Time) ([]Point, error) {
+	v, err := unpickle(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []interface{}
+	switch v := v.(type) {
+	case *pickleList:
+		items = v.items
+	case pickleTuple:
+		items = v
+	default:
+		return nil, fmt.Errorf("expected a list of points, got %T", v)
+	}
+
+	points := make([]Point, 0, len(items))
+	for i, item := range items {
+		p, err := pointFromPickle(item, now)
+		if err != nil {
+			return nil, fmt.Errorf("point %d: %w", i, err)
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+func pointFromPickle(item interface{}, now time.Time) (Point, error) {
+	outer, ok := item.(pickleTuple)
+	if !ok || len(outer) != 2 {
+		return Point{}, fmt.Errorf("expected a (path, (timestamp, value)) tuple, got %v", item)
+	}
+	path, ok := outer[0].(string)
+	if !ok {
+		return Point{}, fmt.Errorf("expected a string path, got %T", outer[0])
+	}
+	datapoint, ok := outer[1].(pickleTuple)
+	if !ok || len(datapoint) != 2 {
+		return Point{}, fmt.Errorf("expected a (timestamp, value) tuple, got %v", outer[1])
+	}
+
+	name, tags, err := ParsePath(path)
+	if err != nil {
+		return Point{}, err
+	}
+	ts, err := pickleFloat(datapoint[0])
+	if err != nil {
+		return Point{}, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	value, err := pickleFloat(datapoint[1])
+	if err != nil {
+		return Point{}, fmt.Errorf("invalid value: %w", err)
+	}
+	return Point{Name: name, Tags: tags, Value: value, TimestampMs: timestampMs(ts, now)}, nil
+}
+
+func pickleFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", v)
+	}
+}
+
+// pickleList is a mutable list, since lists can be appended to after being
+// pushed to the stack or memoized.
+type pickleList struct {
+	items []interface{}
+}
+
+type pickleTuple []interface{}
+
+// pickleDict is a dict. Only string keys are supported.
+type pickleDict map[string]interface{}
+
+type unpickler struct {
+	data  []byte
+	pos   int
+	stack []interface{}
+	marks []int
+	memo  map[uint64]interface{}
+}
+
+// unpickle runs the subset of the pickle machine used by carbon clients,
+// pickle protocols 0 to 5, and returns the unpickled value.
+func unpickle(data []byte) (interface{}, error) {
+	u := &unpickler{data: data, memo: map[uint64]interface{}{}}
+	for {
+		op, err := u.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if op == opStop {
+			return u.pop()
+		}
+		if err := u.exec(op); err != nil {
+			return nil, fmt.Errorf("opcode %q at offset %d: %w", op, u.pos-1, err)
+		}
+	}
+}
+
+const (
+	opMark           = '('
+	opStop           = '.'
+	opPop            = '0'
+	opNone           = 'N'
+	opInt            = 'I'
+	opBinInt         = 'J'
+	opBinInt1        = 'K'
+	opBinInt2        = 'M'
+	opLong           = 'L'
+	opFloat          = 'F'
+	opBinFloat       = 'G'
+	opString         = 'S'
+	opBinString      = 'T'
+	opShortBinString = 'U'
+	opUnicode        = 'V'
+	opBinUnicode     = 'X'
+	opBinBytes       = 'B'
+	opShortBinBytes  = 'C'
+	opEmptyList      = ']'
+	opList           = 'l'
+	opAppend         = 'a'
+	opAppends        = 'e'
+	opEmptyTuple     = ')'
+	opEmptyDict      = '}'
+	opDict           = 'd'
+	opSetItem        = 's'
+	opSetItems       = 'u'
+	opTuple          = 't'
+	opPut            = 'p'
+	opBinPut         = 'q'
+	opLongBinPut     = 'r'
+	opGet            = 'g'
+	opBinGet         = 'h'
+	opLongBinGet     = 'j'
+
+	opProto           = 0x80
+	opTuple1          = 0x85
+	opTuple2          = 0x86
+	opTuple3          = 0x87
+	opNewTrue         = 0x88
+	opNewFalse        = 0x89
+	opLong1           = 0x8a
+	opShortBinUnicode = 0x8c
+	opBinUnicode8     = 0x8d
+	opBinBytes8       = 0x8e
+	opMemoize         = 0x94
+	opFrame           = 0x95
+)
+
+func (u *unpickler) exec(op byte) error {
+	switch op {
+	case opProto:
+		_, err := u.readN(1)
+		return err
+	case opFrame:
+		_, err := u.readN(8)
+		return err
+	case opMark:
+		u.marks = append(u.marks, len(u.stack))
+	case opPop:
+		_, err := u.pop()
+		return err
+	case opNone:
+		u.push(nil)
+	case opNewTrue:
+		u.push(true)
+	case opNewFalse:
+		u.push(false)
+
+	case opInt:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "01":
+			u.push(true)
+		case "00":
+			u.push(false)
+		default:
+			v, err := strconv.ParseInt(line, 10, 64)
+			if err != nil {
+				return err
+			}
+			u.push(v)
+		}
+	case opBinInt:
+		b, err := u.readN(4)
+		if err != nil {
+			return err
+		}
+		u.push(int64(int32(binary.LittleEndian.Uint32(b))))
+	case opBinInt1:
+		b, err := u.readN(1)
+		if err != nil {
+			return err
+		}
+		u.push(int64(b[0]))
+	case opBinInt2:
+		b, err := u.readN(2)
+		if err != nil {
+			return err
+		}
+		u.push(int64(binary.LittleEndian.Uint16(b)))
+	case opLong:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseInt(strings.TrimSuffix(line, "L"), 10, 64)
+		if err != nil {
+			return err
+		}
+		u.push(v)
+	case opLong1:
+		n, err := u.readByte()
+		if err != nil {
+			return err
+		}
+		b, err := u.readN(int(n))
+		if err != nil {
+			return err
+		}
+		v, err := decodeLong(b)
+		if err != nil {
+			return err
+		}
+		u.push(v)
+	case opFloat:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return err
+		}
+		u.push(v)
+	case opBinFloat:
+		b, err := u.readN(8)
+		if err != nil {
+			return err
+		}
+		u.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+
+	case opString:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		s, err := unquotePythonString(line)
+		if err != nil {
+			return err
+		}
+		u.push(s)
+	case opUnicode:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		u.push(line)
+	case opShortBinString, opShortBinBytes, opShortBinUnicode:
+		return u.pushCounted(1)
+	case opBinString, opBinBytes, opBinUnicode:
+		return u.pushCounted(4)
+	case opBinUnicode8, opBinBytes8:
+		return u.pushCounted(8)
+
+	case opEmptyList:
+		u.push(&pickleList{})
+	case opList:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		u.push(&pickleList{items: items})
+	case opAppend, opAppends:
+		var items []interface{}
+		if op == opAppend {
+			item, err := u.pop()
+			if err != nil {
+				return err
+			}
+			items = []interface{}{item}
+		} else {
+			var err error
+			if items, err = u.popMark(); err != nil {
+				return err
+			}
+		}
+		if len(u.stack) == 0 {
+			return errors.New("stack underflow")
+		}
+		list, ok := u.stack[len(u.stack)-1].(*pickleList)
+		if !ok {
+			return fmt.Errorf("can't append to %T", u.stack[len(u.stack)-1])
+		}
+		list.items = append(list.items, items...)
+
+	case opEmptyTuple:
+		u.push(pickleTuple{})
+	case opTuple:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		u.push(pickleTuple(items))
+	case opTuple1, opTuple2, opTuple3:
+		n := int(op-opTuple1) + 1
+		if len(u.stack) < n {
+			return errors.New("stack underflow")
+		}
+		items := make(pickleTuple, n)
+		copy(items, u.stack[len(u.stack)-n:])
+		u.stack = u.stack[:len(u.stack)-n]
+		u.push(items)
+
+	case opEmptyDict:
+		u.push(pickleDict{})
+	case opDict:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		dict := pickleDict{}
+		if err := dict.set(items); err != nil {
+			return err
+		}
+		u.push(dict)
+	case opSetItem, opSetItems:
+		var items []interface{}
+		if op == opSetItem {
+			if len(u.stack) < 2 {
+				return errors.New("stack underflow")
+			}
+			items = append(items, u.stack[len(u.stack)-2:]...)
+			u.stack = u.stack[:len(u.stack)-2]
+		} else {
+			var err error
+			if items, err = u.popMark(); err != nil {
+				return err
+			}
+		}
+		if len(u.stack) == 0 {
+			return errors.New("stack underflow")
+		}
+		dict, ok := u.stack[len(u.stack)-1].(pickleDict)
+		if !ok {
+			return fmt.Errorf("can't set items of %T", u.stack[len(u.stack)-1])
+		}
+		if err := dict.set(items); err != nil {
+			return err
+		}
+
+	case opPut, opBinPut, opLongBinPut, opMemoize:
+		idx := uint64(len(u.memo))
+		if op != opMemoize {
+			var err error
+			if idx, err = u.readMemoIndex(op == opPut, op == opBinPut); err != nil {
+				return err
+			}
+		}
+		if len(u.stack) == 0 {
+			return errors.New("stack underflow")
+		}
+		u.memo[idx] = u.stack[len(u.stack)-1]
+	case opGet, opBinGet, opLongBinGet:
+		idx, err := u.readMemoIndex(op == opGet, op == opBinGet)
+		if err != nil {
+			return err
+		}
+		v, ok := u.memo[idx]
+		if !ok {
+			return fmt.Errorf("memo key %d not found", idx)
+		}
+		u.push(v)
+
+	default:
+		return errors.New("unsupported opcode")
+	}
+	return nil
+}
+
+// set sets the keys and values alternating in items.
+func (d pickleDict) set(items []interface{}) error {
+	if len(items)%2 != 0 {
+		return errors.New("odd number of dict items")
+	}
+	for i := 0; i < len(items); i += 2 {
+		key, ok := items[i].(string)
+		if !ok {
+			return fmt.Errorf("unsupported dict key %T", items[i])
+		}
+		d[key] = items[i+1]
+	}
+	return nil
+}
+
+func (u *unpickler) readByte() (byte, error) {
+	if u.pos >= len(u.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	u.pos++
+	return u.data[u.pos-1], nil
+}
+
+func (u *unpickler) readN(n int) ([]byte, error) {
+	if n < 0 || n > len(u.data)-u.pos {
+		return nil, io.ErrUnexpectedEOF
+	}
+	u.pos += n
+	return u.data[u.pos-n : u.pos], nil
+}
+
+func (u *unpickler) readLine() (string, error) {
+	end := bytes.IndexByte(u.data[u.pos:], '\n')
+	if end < 0 {
+		return "", io.ErrUnexpectedEOF
+	}
+	line := string(u.data[u.pos : u.pos+end])
+	u.pos += end + 1
+	return line, nil
+}
+
+// pushCounted pushes a string prefixed by its little endian length, encoded
+// on size bytes.
+func (u *unpickler) pushCounted(size int) error {
+	b, err := u.readN(size)
+	if err != nil {
+		return err
+	}
+	var n uint64
+	for i := size - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	if n > uint64(len(u.data)) {
+		return io.ErrUnexpectedEOF
+	}
+	s, err := u.readN(int(n))
+	if err != nil {
+		return err
+	}
+	u.push(string(s))
+	return nil
+}
+
+func (u *unpickler) readMemoIndex(text, short bool) (uint64, error) {
+	if text {
+		line, err := u.readLine()
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseUint(line, 10, 64)
+	}
+	if short {
+		b, err := u.readByte()
+		return uint64(b), err
+	}
+	b, err := u.readN(4)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(binary.LittleEndian.Uint32(b)), nil
+}
+
+func (u *unpickler) push(v interface{}) {
+	u.stack = append(u.stack, v)
+}
+
+func (u *unpickler) pop() (interface{}, error) {
+	if len(u.stack) == 0 {
+		return nil, errors.New("stack underflow")
+	}
+	v := u.stack[len(u.stack)-1]
+	u.stack = u.stack[:len(u.stack)-1]
+	return v, nil
+}
+
+// popMark pops all the items pushed since the last mark.
+func (u *unpickler) popMark() ([]interface{}, error) {
+	if len(u.marks) == 0 {
+		return nil, errors.New("mark not found")
+	}
+	mark := u.marks[len(u.marks)-1]
+	u.marks = u.marks[:len(u.marks)-1]
+	if mark > len(u.stack) {
+		return nil, errors.New("stack underflow")
+	}
+	items := append([]interface{}(nil), u.stack[mark:]...)
+	u.stack = u.stack[:mark]
+	return items, nil
+}
+
+// decodeLong decodes a little endian two's complement integer.
+func decodeLong(b []byte) (int64, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	v := new(big.Int).SetBytes(be)
+	if b[len(b)-1]&0x80 != 0 {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	if !v.IsInt64() {
+		return 0, fmt.Errorf("integer %s out of range", v)
+	}
+	return v.Int64(), nil
+}
+
+// unquotePythonString unquotes the repr of a python 2 string, as written by
+// the STRING opcode.
+func unquotePythonString(s string) (string, error) {
+	if len(s) < 2 || s[0] != s[len(s)-1] || (s[0] != '\'' && s[0] != '"') {
+		return "", fmt.Errorf("invalid quoted string %q", s)
+	}
+	inner := s[1 : len(s)-1]
+	if !strings.Contains(inner, `\`) {
+		return inner, nil
+	}
+	return strconv.Unquote(`"` + strings.ReplaceAll(strings.ReplaceAll(inner, `\'`, `'`), `"`, `\"`) + `"`)
+}
@@ -0,0 +1,111 @@
+package carbon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodePickle(t *testing.T) {
+	now := time.Unix(1700000100, 0)
+	wantPoints := []Point{
+		{Name: "a.b.c", Value: 1.5, TimestampMs: 1700000000000},
+		{Name: "tagged", Tags: []string{"env=prod", "dc=eu"}, Value: -2, TimestampMs: 1700000000500},
+		{Name: "a.b.c", Value: 3, TimestampMs: 1700000060000},
+	}
+
+	// The payloads are python's pickle.dumps of
+	// [('a.b.c', (1700000000, 1.5)), ('tagged;env=prod;dc=eu', (1700000000.5, -2)), ('a.b.c', (1700000060, 3))]
+	for name, tc := range map[string]struct {
+		payload string
+		want    []Point
+		wantErr string
+	}{
+		"protocol 0": {
+			payload: "(lp0\n(Va.b.c\np1\n(I1700000000\nF1.5\ntp2\ntp3\na(Vtagged;env=prod;dc=eu\np4\n(F1700000000.5\nI-2\ntp5\ntp6\na(g1\n(I1700000060\nI3\ntp7\ntp8\na.",
+			want:    wantPoints,
+		},
+		"protocol 2": {
+			payload: "\x80\x02]q\x00(X\x05\x00\x00\x00a.b.cq\x01J\x00\xf1SeG?\xf8\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03X\x15\x00\x00\x00tagged;env=prod;dc=euq\x04GA\xd9T\xfc@ \x00\x00J\xfe\xff\xff\xff\x86q\x05\x86q\x06h\x01J<\xf1SeK\x03\x86q\x07\x86q\x08e.",
+			want:    wantPoints,
+		},
+		"protocol 4": {
+			payload: "\x80\x04\x95V\x00\x00\x00\x00\x00\x00\x00]\x94(\x8c\x05a.b.c\x94J\x00\xf1SeG?\xf8\x00\x00\x00\x00\x00\x00\x86\x94\x86\x94\x8c\x15tagged;env=prod;dc=eu\x94GA\xd9T\xfc@ \x00\x00J\xfe\xff\xff\xff\x86\x94\x86\x94h\x01J<\xf1SeK\x03\x86\x94\x86\x94e.",
+			want:    wantPoints,
+		},
+		"python 2 strings and longs": {
+			payload: "(lp0\n(S'a.b.c'\np1\n(L1700000000L\nF1.5\ntp2\ntp3\na.",
+			want:    wantPoints[:1],
+		},
+		"string timestamp and value": {
+			payload: "\x80\x02]q\x00X\x01\x00\x00\x00xq\x01X\n\x00\x00\x001700000000q\x02X\x01\x00\x00\x007q\x03\x86q\x04\x86q\x05a.",
+			want:    []Point{{Name: "x", Value: 7, TimestampMs: 1700000000000}},
+		},
+		"integer out of range": {
+			payload: "\x80\x02]q\x00X\x01\x00\x00\x00xq\x01J\x00\xf1Se\x8a\x09\x00\x00\x00\x00\x00\x00\x00\x00@\x86q\x02\x86q\x03a.",
+			wantErr: "out of range",
+		},
+		"classes are not loaded": {
+			payload: "cos\nsystem\n(S'true'\ntR.",
+			wantErr: "unsupported opcode",
+		},
+		"not a list": {
+			payload: "I1\n.",
+			wantErr: "expected a list of points",
+		},
+		"not a point": {
+			payload: "(lp0\nI1\na.",
+			wantErr: "point 0",
+		},
+		"truncated": {
+			payload: "\x80\x02]q\x00X\x05\x00\x00\x00a.b",
+			wantErr: io.ErrUnexpectedEOF.Error(),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := DecodePickle([]byte(tc.payload), now)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestReadPickleMessage(t *testing.T) {
+	var stream bytes.Buffer
+	for _, msg := range []string{"first", "second"} {
+		require.NoError(t, binary.Write(&stream, binary.BigEndian, uint32(len(msg))))
+		stream.WriteString(msg)
+	}
+
+	msg, err := ReadPickleMessage(&stream, 10)
+	require.NoError(t, err)
+	require.Equal(t, "first", string(msg))
+	msg, err = ReadPickleMessage(&stream, 10)
+	require.NoError(t, err)
+	require.Equal(t, "second", string(msg))
+	_, err = ReadPickleMessage(&stream, 10)
+	require.ErrorIs(t, err, io.EOF)
+
+	t.Run("too large", func(t *testing.T) {
+		stream.Reset()
+		require.NoError(t, binary.Write(&stream, binary.BigEndian, uint32(11)))
+		_, err := ReadPickleMessage(&stream, 10)
+		require.ErrorIs(t, err, ErrPickleMessageTooLarge)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		stream.Reset()
+		require.NoError(t, binary.Write(&stream, binary.BigEndian, uint32(5)))
+		stream.WriteString("abc")
+		_, err := ReadPickleMessage(&stream, 10)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
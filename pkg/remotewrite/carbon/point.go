@@ -0,0 +1,92 @@
+// Package carbon implements Graphite carbon ingestion: plaintext and pickle
+// protocol decoding, and listeners feeding the decoded points to a
+// storage.Appendable as labeled samples.
+package carbon
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/graphite/writeproxy"
+)
+
+// Point is a single Graphite data point.
+type Point struct {
+	// Name is the metric path, without tags.
+	Name string
+	// Tags are the Graphite tags of the point, as key=value strings.
+	Tags []string
+
+	Value       float64
+	TimestampMs int64
+}
+
+// Labels returns the Prometheus labels for the point, using the same mapping
+// as the write proxy: tagged points keep their tags and get their path in the
+// "name" label, while untagged points get one label per path node.
+func (p Point) Labels(builder *labels.Builder) (labels.Labels, error) {
+	if len(p.Tags) > 0 {
+		return writeproxy.LabelsFromTaggedName(p.Name, p.Tags, builder)
+	}
+	return writeproxy.LabelsFromUntaggedName(p.Name, builder), nil
+}
+
+// ParsePath splits a Graphite path like "some.metric;tag1=a;tag2=b" into its
+// name and tags.
+func ParsePath(path string) (name string, tags []string, err error) {
+	name, rest, tagged := strings.Cut(path, ";")
+	if name == "" {
+		return "", nil, fmt.Errorf("empty metric name in %q", path)
+	}
+	if !tagged {
+		return name, nil, nil
+	}
+	tags = strings.Split(rest, ";")
+	for _, tag := range tags {
+		if eq := strings.IndexByte(tag, '='); eq <= 0 || eq == len(tag)-1 {
+			return "", nil, fmt.Errorf("invalid tag %q in %q", tag, path)
+		}
+	}
+	return name, tags, nil
+}
+
+// ParseLine parses a line of the carbon plaintext protocol,
+// "<path> <value> <timestamp>". The timestamp is in seconds and may be
+// fractional; a missing or negative timestamp means now.
+func ParseLine(line string, now time.Time) (Point, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 3 {
+		return Point{}, fmt.Errorf("expected \"<path> <value> <timestamp>\", got %q", line)
+	}
+
+	name, tags, err := ParsePath(fields[0])
+	if err != nil {
+		return Point{}, err
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("invalid value %q: %w", fields[1], err)
+	}
+	ts := -1.0
+	if len(fields) == 3 {
+		if ts, err = strconv.ParseFloat(fields[2], 64); err != nil {
+			return Point{}, fmt.Errorf("invalid timestamp %q: %w", fields[2], err)
+		}
+	}
+
+	return Point{Name: name, Tags: tags, Value: value, TimestampMs: timestampMs(ts, now)}, nil
+}
+
+// timestampMs converts a Graphite timestamp in seconds to milliseconds,
+// replacing negative timestamps with now.
+func timestampMs(seconds float64, now time.Time) int64 {
+	if seconds < 0 || math.IsNaN(seconds) {
+		return now.UnixMilli()
+	}
+	return int64(seconds * 1000)
+}
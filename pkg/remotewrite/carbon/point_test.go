@@ -0,0 +1,86 @@
+package carbon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	now := time.Unix(1700000100, 0)
+
+	for name, tc := range map[string]struct {
+		line    string
+		want    Point
+		wantErr string
+	}{
+		"untagged": {
+			line: "some.metric.name 12.5 1700000000",
+			want: Point{Name: "some.metric.name", Value: 12.5, TimestampMs: 1700000000000},
+		},
+		"tagged": {
+			line: "some.metric;env=prod;dc=eu 1 1700000000",
+			want: Point{Name: "some.metric", Tags: []string{"env=prod", "dc=eu"}, Value: 1, TimestampMs: 1700000000000},
+		},
+		"fractional timestamp": {
+			line: "a.b 1 1700000000.25",
+			want: Point{Name: "a.b", Value: 1, TimestampMs: 1700000000250},
+		},
+		"negative timestamp means now": {
+			line: "a.b 1 -1",
+			want: Point{Name: "a.b", Value: 1, TimestampMs: now.UnixMilli()},
+		},
+		"missing timestamp means now": {
+			line: "a.b 1",
+			want: Point{Name: "a.b", Value: 1, TimestampMs: now.UnixMilli()},
+		},
+		"extra whitespace": {
+			line: "  a.b\t1   1700000000 ",
+			want: Point{Name: "a.b", Value: 1, TimestampMs: 1700000000000},
+		},
+		"too many fields": {
+			line:    "a.b 1 1700000000 extra",
+			wantErr: "expected",
+		},
+		"invalid value": {
+			line:    "a.b one 1700000000",
+			wantErr: "invalid value",
+		},
+		"invalid timestamp": {
+			line:    "a.b 1 yesterday",
+			wantErr: "invalid timestamp",
+		},
+		"invalid tag": {
+			line:    "a.b;env 1 1700000000",
+			wantErr: "invalid tag",
+		},
+		"empty name": {
+			line:    ";env=prod 1 1700000000",
+			wantErr: "empty metric name",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseLine(tc.line, now)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestPointLabels(t *testing.T) {
+	builder := labels.NewBuilder(nil)
+
+	lbls, err := Point{Name: "a.b"}.Labels(builder)
+	require.NoError(t, err)
+	require.Equal(t, labels.FromStrings("__name__", "graphite_untagged", "__n000__", "a", "__n001__", "b"), lbls)
+
+	lbls, err = Point{Name: "a.b", Tags: []string{"env=prod"}}.Labels(builder)
+	require.NoError(t, err)
+	require.Equal(t, labels.FromStrings("__name__", "graphite_tagged", "name", "a.b", "env", "prod"), lbls)
+}
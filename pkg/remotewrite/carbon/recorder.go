@@ -0,0 +1,55 @@
+package carbon
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	protocolPlaintext    = "plaintext"
+	protocolPlaintextUDP = "plaintext_udp"
+	protocolPickle       = "pickle"
+	// protocolAggregation is used for the points generated by the aggregation
+	// rules.
+	protocolAggregation = "aggregation"
+)
+
+//go:generate mockery --inpackage --testonly --case underscore --name Recorder
+type Recorder interface {
+	measureReceivedPoints(protocol string, count int)
+	measureRejectedPoints(protocol, reason string, count int)
+}
+
+// NewRecorder returns a new Prometheus metrics Recorder.
+// It ensures that the carbon listener metrics are properly registered.
+func NewRecorder(prefix string, reg prometheus.Registerer) Recorder {
+	r := &prometheusRecorder{
+		receivedPoints: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "carbon_received_points_total",
+			Help:      "The total number of carbon points appended, excluding rejected points.",
+		}, []string{"protocol"}),
+		rejectedPoints: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "carbon_rejected_points_total",
+			Help:      "The total number of carbon points that couldn't be decoded or appended.",
+		}, []string{"protocol", "reason"}),
+	}
+
+	reg.MustRegister(r.receivedPoints)
+	reg.MustRegister(r.rejectedPoints)
+
+	return r
+}
+
+type prometheusRecorder struct {
+	receivedPoints *prometheus.CounterVec
+	rejectedPoints *prometheus.CounterVec
+}
+
+func (r prometheusRecorder) measureReceivedPoints(protocol string, count int) {
+	r.receivedPoints.WithLabelValues(protocol).Add(float64(count))
+}
+
+func (r prometheusRecorder) measureRejectedPoints(protocol, reason string, count int) {
+	r.rejectedPoints.WithLabelValues(protocol, reason).Add(float64(count))
+}
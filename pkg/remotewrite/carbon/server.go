@@ -0,0 +1,560 @@
+package carbon
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/server"
+)
+
+const (
+	maxUDPPacketSize         = 64 * 1024
+	aggregationFlushInterval = time.Second
+)
+
+// Server accepts the carbon plaintext protocol over TCP and UDP, and the
+// carbon pickle protocol over TCP, and appends the received points to a
+// storage.Appendable.
+//
+// Points are committed in batches of up to Config.BatchSize, and whenever a
+// connection has no more data buffered, so a slow sender doesn't hold points
+// back. Each UDP packet and each pickle message is committed on its own.
+//
+// If Config.WriteQueueSize is set, at most that many batches are committed at
+// the same time. When the queue is full, the listeners of the protocols with
+// the block policy wait for room, so the TCP senders are pushed back on, and
+// the others drop their batch.
+//
+// If aggregation rules are configured, the points matching them are also fed
+// to an Aggregator, whose aggregated points are appended every second.
+//
+// If storage schemas, interval inference or a duplicate timestamp policy are
+// configured, the timestamps of the points written as they are are aligned
+// to the interval of their series, and their duplicates resolved, first.
+type Server struct {
+	cfg        Config
+	appendable storage.Appendable
+	aggregator *Aggregator
+	aligner    *aligner
+	recorder   Recorder
+	logger     log.Logger
+
+	plaintextListener net.Listener
+	pickleListener    net.Listener
+	udpConn           net.PacketConn
+
+	// appendCtx is passed to the Appendable. It isn't canceled on Stop, so
+	// the pending points can still be committed.
+	appendCtx context.Context
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	// aggregationWg tracks the aggregation flush loop, which must outlive
+	// the connections to flush their last points.
+	aggregationWg sync.WaitGroup
+
+	connsMtx sync.Mutex
+	conns    map[net.Conn]struct{}
+
+	// writeQueue holds a token per batch being committed, it's nil if the
+	// commits aren't limited.
+	writeQueue chan struct{}
+	// shedProtocols are the protocols dropping their points when the write
+	// queue is full.
+	shedProtocols map[string]bool
+}
+
+// NewServer opens the listeners enabled in cfg, so it fails early if an
+// address is in use. Call Run to start accepting points.
+func NewServer(cfg Config, appendable storage.Appendable, recorder Recorder, logger log.Logger) (_ *Server, err error) {
+	appendCtx := context.Background()
+	if cfg.Tenant != "" {
+		appendCtx = user.InjectOrgID(appendCtx, cfg.Tenant)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Server{
+		cfg:        cfg,
+		appendable: appendable,
+		recorder:   recorder,
+		logger:     logger,
+		appendCtx:  appendCtx,
+		ctx:        ctx,
+		cancel:     cancel,
+		conns:      map[net.Conn]struct{}{},
+		shedProtocols: map[string]bool{
+			protocolPlaintext:    cfg.PlaintextQueueFullPolicy == QueueFullShed,
+			protocolPlaintextUDP: cfg.PlaintextUDPQueueFullPolicy == QueueFullShed,
+			protocolPickle:       cfg.PickleQueueFullPolicy == QueueFullShed,
+		},
+	}
+	if cfg.WriteQueueSize > 0 {
+		s.writeQueue = make(chan struct{}, cfg.WriteQueueSize)
+	}
+	defer func() {
+		if err != nil {
+			s.close()
+		}
+	}()
+
+	if cfg.AggregationRulesFile != "" {
+		rules, err := LoadAggregationRules(cfg.AggregationRulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load carbon aggregation rules: %w", err)
+		}
+		s.aggregator = NewAggregator(rules, cfg.AggregationDelay)
+		level.Info(logger).Log("msg", "carbon aggregation rules loaded", "file", cfg.AggregationRulesFile, "rules", len(rules))
+	}
+
+	if cfg.alignmentEnabled() {
+		var schemas []StorageSchema
+		if cfg.StorageSchemasFile != "" {
+			if schemas, err = LoadStorageSchemas(cfg.StorageSchemasFile); err != nil {
+				return nil, fmt.Errorf("can't load carbon storage schemas: %w", err)
+			}
+			level.Info(logger).Log("msg", "carbon storage schemas loaded", "file", cfg.StorageSchemasFile, "schemas", len(schemas))
+		}
+		s.aligner = newAligner(schemas, cfg.InferInterval, cfg.DuplicateTimestampPolicy, cfg.AggregationDelay)
+	}
+
+	if cfg.PlaintextListenAddress != "" {
+		if s.plaintextListener, err = server.Listen(cfg.Listen, "carbon-plaintext", "tcp", cfg.PlaintextListenAddress); err != nil {
+			return nil, err
+		}
+		level.Info(logger).Log("msg", "carbon plaintext listening", "addr", s.plaintextListener.Addr().String())
+	}
+	if cfg.PlaintextUDPListenAddress != "" {
+		if s.udpConn, err = server.ListenPacket(cfg.Listen, "carbon-udp", "udp", cfg.PlaintextUDPListenAddress); err != nil {
+			return nil, err
+		}
+		level.Info(logger).Log("msg", "carbon plaintext listening", "addr", s.udpConn.LocalAddr().String(), "protocol", "udp")
+	}
+	if cfg.PickleListenAddress != "" {
+		if s.pickleListener, err = server.Listen(cfg.Listen, "carbon-pickle", "tcp", cfg.PickleListenAddress); err != nil {
+			return nil, err
+		}
+		level.Info(logger).Log("msg", "carbon pickle listening", "addr", s.pickleListener.Addr().String())
+	}
+	return s, nil
+}
+
+// PlaintextAddr returns the address of the TCP plaintext listener, or nil if
+// it's disabled.
+func (s *Server) PlaintextAddr() net.Addr {
+	if s.plaintextListener == nil {
+		return nil
+	}
+	return s.plaintextListener.Addr()
+}
+
+// PlaintextUDPAddr returns the address of the UDP plaintext listener, or nil
+// if it's disabled.
+func (s *Server) PlaintextUDPAddr() net.Addr {
+	if s.udpConn == nil {
+		return nil
+	}
+	return s.udpConn.LocalAddr()
+}
+
+// PickleAddr returns the address of the pickle listener, or nil if it's
+// disabled.
+func (s *Server) PickleAddr() net.Addr {
+	if s.pickleListener == nil {
+		return nil
+	}
+	return s.pickleListener.Addr()
+}
+
+// Handler returns two functions to run and stop the server.
+func (s *Server) Handler() (run func() error, stop func(error)) {
+	return s.Run, s.Stop
+}
+
+// Run accepts points until Stop is called.
+func (s *Server) Run() error {
+	if s.aggregator != nil || s.aligner != nil {
+		s.aggregationWg.Add(1)
+		go s.flushAggregations()
+	}
+	if s.plaintextListener != nil {
+		s.wg.Add(1)
+		go s.accept(s.plaintextListener, s.handlePlaintext)
+	}
+	if s.pickleListener != nil {
+		s.wg.Add(1)
+		go s.accept(s.pickleListener, s.handlePickle)
+	}
+	if s.udpConn != nil {
+		s.wg.Add(1)
+		go s.serveUDP()
+	}
+
+	<-s.ctx.Done()
+	s.wg.Wait()
+	s.aggregationWg.Wait()
+	return nil
+}
+
+// Stop closes the listeners and all open connections, and waits for the
+// pending points, including all the pending aggregations, to be committed.
+func (s *Server) Stop(_ error) {
+	s.close()
+	s.wg.Wait()
+	s.aggregationWg.Wait()
+}
+
+// flushAggregations appends the aggregated points of the intervals that are
+// done, and the points held by the aligner that are due, every second. Once
+// the server is stopping and all the connections are closed, all the
+// remaining intervals and points are flushed.
+func (s *Server) flushAggregations() {
+	defer s.aggregationWg.Done()
+
+	ticker := time.NewTicker(aggregationFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush(time.Now(), false)
+		case <-s.ctx.Done():
+			s.wg.Wait()
+			s.flush(time.Now(), true)
+			return
+		}
+	}
+}
+
+// flush commits the points that are due, or all of them if all is set. The
+// final flush, with all set, waits for room in the write queue whatever the
+// policy of the protocols and even though the server is stopping, so no
+// pending point is dropped.
+func (s *Server) flush(now time.Time, all bool) {
+	if s.aggregator != nil {
+		s.appendAggregations(s.aggregator.Flush(now, all), all)
+	}
+	if s.aligner != nil {
+		s.appendAligned(s.aligner.flush(now, all), all)
+	}
+}
+
+// appendAligned appends the points held by the aligner, in a batch per
+// protocol.
+func (s *Server) appendAligned(points []alignedPoint, final bool) {
+	batches := map[string]*batch{}
+	for _, p := range points {
+		b, ok := batches[p.protocol]
+		if !ok {
+			b = s.newBatch(p.protocol)
+			b.final = final
+			batches[p.protocol] = b
+		}
+		b.append(p.Point)
+	}
+	for _, b := range batches {
+		b.commit()
+	}
+}
+
+func (s *Server) appendAggregations(points []Point, final bool) {
+	if len(points) == 0 {
+		return
+	}
+	b := s.newBatch(protocolAggregation)
+	b.final = final
+	for _, p := range points {
+		b.add(p)
+	}
+	b.commit()
+}
+
+func (s *Server) close() {
+	s.cancel()
+	if s.plaintextListener != nil {
+		_ = s.plaintextListener.Close()
+	}
+	if s.pickleListener != nil {
+		_ = s.pickleListener.Close()
+	}
+	if s.udpConn != nil {
+		_ = s.udpConn.Close()
+	}
+
+	s.connsMtx.Lock()
+	defer s.connsMtx.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func (s *Server) accept(l net.Listener, handle func(net.Conn)) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.ctx.Err() == nil {
+				level.Error(s.logger).Log("msg", "carbon listener stopped accepting connections", "addr", l.Addr().String(), "err", err)
+			}
+			return
+		}
+		if !s.track(conn) {
+			_ = conn.Close()
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			handle(conn)
+		}()
+	}
+}
+
+// track registers conn so it's closed on Stop. It returns false if the server
+// is already stopping.
+func (s *Server) track(conn net.Conn) bool {
+	s.connsMtx.Lock()
+	defer s.connsMtx.Unlock()
+	if s.ctx.Err() != nil {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.connsMtx.Lock()
+	delete(s.conns, conn)
+	s.connsMtx.Unlock()
+	_ = conn.Close()
+}
+
+func (s *Server) setDeadline(conn net.Conn) {
+	if s.cfg.IdleTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(s.cfg.IdleTimeout))
+	}
+}
+
+func (s *Server) handlePlaintext(conn net.Conn) {
+	b := s.newBatch(protocolPlaintext)
+	defer b.commit()
+
+	// The lines must fit in the buffer of the reader, so a client that never
+	// sends a newline can't make it grow unbounded.
+	reader := bufio.NewReaderSize(conn, s.cfg.MaxLineLength)
+	for {
+		s.setDeadline(conn)
+		line, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			s.recorder.measureRejectedPoints(protocolPlaintext, "line_too_long", 1)
+			s.logConnError(conn, protocolPlaintext, fmt.Errorf("line longer than the max of %d bytes", s.cfg.MaxLineLength))
+			return
+		}
+		b.addLine(string(line))
+		if b.pending >= s.cfg.BatchSize || reader.Buffered() == 0 {
+			b.commit()
+		}
+		if err != nil {
+			s.logConnError(conn, protocolPlaintext, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handlePickle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		s.setDeadline(conn)
+		payload, err := ReadPickleMessage(reader, s.cfg.MaxPickleMessageSize)
+		if err != nil {
+			if errors.Is(err, ErrPickleMessageTooLarge) {
+				s.recorder.measureRejectedPoints(protocolPickle, "message_too_large", 1)
+			}
+			s.logConnError(conn, protocolPickle, err)
+			return
+		}
+
+		points, err := DecodePickle(payload, time.Now())
+		if err != nil {
+			s.recorder.measureRejectedPoints(protocolPickle, "invalid_message", 1)
+			level.Warn(s.logger).Log("msg", "can't decode carbon pickle message", "remote", conn.RemoteAddr().String(), "err", err)
+			continue
+		}
+
+		b := s.newBatch(protocolPickle)
+		for _, p := range points {
+			b.add(p)
+		}
+		b.commit()
+	}
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, _, err := s.udpConn.ReadFrom(buf)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				level.Error(s.logger).Log("msg", "carbon udp listener stopped reading", "err", err)
+			}
+			return
+		}
+
+		b := s.newBatch(protocolPlaintextUDP)
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			b.addLine(line)
+		}
+		b.commit()
+	}
+}
+
+func (s *Server) logConnError(conn net.Conn, protocol string, err error) {
+	if errors.Is(err, io.EOF) || s.ctx.Err() != nil {
+		return
+	}
+	level.Warn(s.logger).Log("msg", "closing carbon connection", "protocol", protocol, "remote", conn.RemoteAddr().String(), "err", err)
+}
+
+// batch appends points to a single storage.Appender until it's committed.
+type batch struct {
+	s        *Server
+	protocol string
+	builder  *labels.Builder
+	app      storage.Appender
+	pending  int
+	// final is set for the batches of the final flush, which must not be
+	// dropped.
+	final bool
+}
+
+func (s *Server) newBatch(protocol string) *batch {
+	return &batch{s: s, protocol: protocol, builder: labels.NewBuilder(nil)}
+}
+
+func (b *batch) addLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	p, err := ParseLine(line, time.Now())
+	if err != nil {
+		b.s.recorder.measureRejectedPoints(b.protocol, "invalid_line", 1)
+		level.Debug(b.s.logger).Log("msg", "can't parse carbon line", "line", line, "err", err)
+		return
+	}
+	b.add(p)
+}
+
+func (b *batch) add(p Point) {
+	if b.s.aggregator != nil && b.protocol != protocolAggregation {
+		matched, late := b.s.aggregator.Add(p, time.Now())
+		if late > 0 {
+			b.s.recorder.measureRejectedPoints(b.protocol, "too_late_for_aggregation", late)
+		}
+		if matched && !b.s.cfg.AggregationForwardInputs {
+			return
+		}
+	}
+
+	if b.s.aligner != nil && b.protocol != protocolAggregation {
+		points, duplicates := b.s.aligner.add(alignedPoint{Point: p, protocol: b.protocol}, time.Now())
+		if duplicates > 0 {
+			b.s.recorder.measureRejectedPoints(b.protocol, "duplicate_timestamp", duplicates)
+		}
+		for _, p := range points {
+			b.append(p.Point)
+		}
+		return
+	}
+	b.append(p)
+}
+
+// append appends p as it is.
+func (b *batch) append(p Point) {
+	lbls, err := p.Labels(b.builder)
+	if err != nil {
+		b.s.recorder.measureRejectedPoints(b.protocol, "invalid_labels", 1)
+		level.Debug(b.s.logger).Log("msg", "can't build labels for carbon point", "name", p.Name, "err", err)
+		return
+	}
+	if b.app == nil {
+		b.app = b.s.appendable.Appender(b.s.appendCtx)
+	}
+	if _, err := b.app.Append(0, lbls, p.TimestampMs, p.Value); err != nil {
+		b.s.recorder.measureRejectedPoints(b.protocol, "append_failed", 1)
+		level.Debug(b.s.logger).Log("msg", "can't append carbon point", "name", p.Name, "err", err)
+		return
+	}
+	b.pending++
+}
+
+func (b *batch) commit() {
+	if b.app == nil {
+		return
+	}
+	if !b.s.enqueueWrite(b.protocol, b.final) {
+		_ = b.app.Rollback()
+		b.s.recorder.measureRejectedPoints(b.protocol, "write_queue_full", b.pending)
+		b.app = nil
+		b.pending = 0
+		return
+	}
+	defer b.s.dequeueWrite()
+
+	if err := b.app.Commit(); err != nil {
+		b.s.recorder.measureRejectedPoints(b.protocol, "commit_failed", b.pending)
+		level.Warn(b.s.logger).Log("msg", "can't commit carbon points", "protocol", b.protocol, "count", b.pending, "err", err)
+	} else {
+		b.s.recorder.measureReceivedPoints(b.protocol, b.pending)
+	}
+	b.app = nil
+	b.pending = 0
+}
+
+// enqueueWrite takes a spot in the write queue for a batch of protocol,
+// waiting for one if the queue is full, unless the protocol sheds its points
+// or the server stops while waiting. The batches of the final flush always
+// wait: the connections are closed by then, so the queue drains. It returns
+// false if the batch must be dropped.
+func (s *Server) enqueueWrite(protocol string, final bool) bool {
+	if s.writeQueue == nil {
+		return true
+	}
+	if final {
+		s.writeQueue <- struct{}{}
+		return true
+	}
+	select {
+	case s.writeQueue <- struct{}{}:
+		return true
+	default:
+	}
+	if s.shedProtocols[protocol] {
+		return false
+	}
+	select {
+	case s.writeQueue <- struct{}{}:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+func (s *Server) dequeueWrite() {
+	if s.writeQueue != nil {
+		<-s.writeQueue
+	}
+}
@@ -0,0 +1,305 @@
+package carbon
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type sample struct {
+	tenant string
+	lbls   labels.Labels
+	t      int64
+	v      float64
+}
+
+// fakeAppendable collects the committed samples.
+type fakeAppendable struct {
+	mtx       sync.Mutex
+	committed []sample
+
+	// commitStarted, if set, gets a value on each commit, which then waits
+	// for unblockCommits to be closed.
+	commitStarted  chan struct{}
+	unblockCommits chan struct{}
+}
+
+func (f *fakeAppendable) Appender(ctx context.Context) storage.Appender {
+	tenant, _ := user.ExtractOrgID(ctx)
+	return &fakeAppender{appendable: f, tenant: tenant}
+}
+
+func (f *fakeAppendable) samples() []sample {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return append([]sample(nil), f.committed...)
+}
+
+type fakeAppender struct {
+	storage.Appender
+
+	appendable *fakeAppendable
+	tenant     string
+	pending    []sample
+}
+
+func (a *fakeAppender) Append(_ storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	a.pending = append(a.pending, sample{tenant: a.tenant, lbls: l.Copy(), t: t, v: v})
+	return 0, nil
+}
+
+func (a *fakeAppender) Commit() error {
+	if a.appendable.commitStarted != nil {
+		a.appendable.commitStarted <- struct{}{}
+		<-a.appendable.unblockCommits
+	}
+	a.appendable.mtx.Lock()
+	defer a.appendable.mtx.Unlock()
+	a.appendable.committed = append(a.appendable.committed, a.pending...)
+	return nil
+}
+
+func (a *fakeAppender) Rollback() error { return nil }
+
+func newTestServer(t *testing.T, recorder Recorder) (*Server, *fakeAppendable) {
+	appendable := &fakeAppendable{}
+	s, err := NewServer(Config{
+		PlaintextListenAddress:    "127.0.0.1:0",
+		PlaintextUDPListenAddress: "127.0.0.1:0",
+		PickleListenAddress:       "127.0.0.1:0",
+		MaxPickleMessageSize:      1024,
+		MaxLineLength:             64,
+		BatchSize:                 2,
+		IdleTimeout:               time.Minute,
+		Tenant:                    "tenant-1",
+	}, appendable, recorder, log.NewNopLogger())
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() { done <- s.Run() }()
+	t.Cleanup(func() {
+		s.Stop(nil)
+		require.NoError(t, <-done)
+	})
+	return s, appendable
+}
+
+func TestServerPlaintext(t *testing.T) {
+	recorder := &MockRecorder{}
+	recorder.On("measureReceivedPoints", mock.Anything, mock.Anything).Return()
+	recorder.On("measureRejectedPoints", protocolPlaintext, "invalid_line", 1).Return().Once()
+	s, appendable := newTestServer(t, recorder)
+
+	conn, err := net.Dial("tcp", s.PlaintextAddr().String())
+	require.NoError(t, err)
+	_, err = fmt.Fprint(conn, "a.b 1 1700000000\nnot a valid line\na.c;env=prod 2 1700000000\na.d 3 1700000000\n")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool { return len(appendable.samples()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []sample{
+		{tenant: "tenant-1", lbls: labels.FromStrings("__name__", "graphite_untagged", "__n000__", "a", "__n001__", "b"), t: 1700000000000, v: 1},
+		{tenant: "tenant-1", lbls: labels.FromStrings("__name__", "graphite_tagged", "name", "a.c", "env", "prod"), t: 1700000000000, v: 2},
+		{tenant: "tenant-1", lbls: labels.FromStrings("__name__", "graphite_untagged", "__n000__", "a", "__n001__", "d"), t: 1700000000000, v: 3},
+	}, appendable.samples())
+	recorder.AssertExpectations(t)
+}
+
+func TestServerPlaintextLineTooLong(t *testing.T) {
+	recorder := &MockRecorder{}
+	recorder.On("measureReceivedPoints", protocolPlaintext, 1).Return().Once()
+	recorder.On("measureRejectedPoints", protocolPlaintext, "line_too_long", 1).Return().Once()
+	s, appendable := newTestServer(t, recorder)
+
+	conn, err := net.Dial("tcp", s.PlaintextAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "a.b 1 1700000000\n"+strings.Repeat("a", 100))
+	require.NoError(t, err)
+
+	// The connection is closed once the line is longer than the max.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.Len(t, appendable.samples(), 1)
+	recorder.AssertExpectations(t)
+}
+
+func TestServerPlaintextUDP(t *testing.T) {
+	recorder := &MockRecorder{}
+	recorder.On("measureReceivedPoints", protocolPlaintextUDP, 2).Return().Once()
+	s, appendable := newTestServer(t, recorder)
+
+	conn, err := net.Dial("udp", s.PlaintextUDPAddr().String())
+	require.NoError(t, err)
+	_, err = fmt.Fprint(conn, "a.b 1 1700000000\na.c 2 1700000000")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool { return len(appendable.samples()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	recorder.AssertExpectations(t)
+}
+
+func TestServerPickle(t *testing.T) {
+	recorder := &MockRecorder{}
+	recorder.On("measureReceivedPoints", protocolPickle, 1).Return().Once()
+	recorder.On("measureRejectedPoints", protocolPickle, "invalid_message", 1).Return().Once()
+	recorder.On("measureRejectedPoints", protocolPickle, "message_too_large", 1).Return().Once()
+	s, appendable := newTestServer(t, recorder)
+
+	conn, err := net.Dial("tcp", s.PickleAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	writeMessage := func(payload string) {
+		require.NoError(t, binary.Write(conn, binary.BigEndian, uint32(len(payload))))
+		_, err := conn.Write([]byte(payload))
+		require.NoError(t, err)
+	}
+	writeMessage("I1\n.")
+	writeMessage("(lp0\n(S'a.b.c'\np1\n(L1700000000L\nF1.5\ntp2\ntp3\na.")
+	require.NoError(t, binary.Write(conn, binary.BigEndian, uint32(2048)))
+
+	require.Eventually(t, func() bool { return len(appendable.samples()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1.5, appendable.samples()[0].v)
+
+	// The connection is closed after a message that's too large.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	recorder.AssertExpectations(t)
+}
+
+func TestServerWriteQueue(t *testing.T) {
+	shed := make(chan struct{})
+	recorder := &MockRecorder{}
+	recorder.On("measureReceivedPoints", protocolPlaintext, 1).Return().Twice()
+	recorder.On("measureRejectedPoints", protocolPlaintextUDP, "write_queue_full", 2).Return().Once().Run(func(mock.Arguments) { close(shed) })
+	appendable := &fakeAppendable{commitStarted: make(chan struct{}, 10), unblockCommits: make(chan struct{})}
+	s, err := NewServer(Config{
+		PlaintextListenAddress:      "127.0.0.1:0",
+		PlaintextUDPListenAddress:   "127.0.0.1:0",
+		MaxLineLength:               64,
+		BatchSize:                   10,
+		WriteQueueSize:              1,
+		PlaintextQueueFullPolicy:    QueueFullBlock,
+		PlaintextUDPQueueFullPolicy: QueueFullShed,
+	}, appendable, recorder, log.NewNopLogger())
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() { done <- s.Run() }()
+	t.Cleanup(func() {
+		s.Stop(nil)
+		require.NoError(t, <-done)
+	})
+	unblock := sync.OnceFunc(func() { close(appendable.unblockCommits) })
+	t.Cleanup(unblock)
+
+	send := func(network, addr, lines string) {
+		conn, err := net.Dial(network, addr)
+		require.NoError(t, err)
+		_, err = fmt.Fprint(conn, lines)
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+	}
+
+	// The first TCP batch takes the only spot of the queue.
+	send("tcp", s.PlaintextAddr().String(), "a.b 1 1700000000\n")
+	<-appendable.commitStarted
+
+	// UDP sheds its points, TCP waits for room.
+	send("udp", s.PlaintextUDPAddr().String(), "a.c 2 1700000000\na.d 3 1700000000")
+	<-shed
+	send("tcp", s.PlaintextAddr().String(), "a.e 4 1700000000\n")
+
+	unblock()
+	require.Eventually(t, func() bool { return len(appendable.samples()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	recorder.AssertExpectations(t)
+}
+
+func TestServerEnqueueWriteStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{ctx: ctx, writeQueue: make(chan struct{}, 1)}
+	require.True(t, s.enqueueWrite(protocolPlaintext, false))
+
+	stopped := make(chan bool)
+	go func() { stopped <- s.enqueueWrite(protocolPlaintext, false) }()
+	cancel()
+	select {
+	case ok := <-stopped:
+		require.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("enqueueWrite didn't return on stop")
+	}
+
+	// The batches of the final flush wait for room instead.
+	go func() { stopped <- s.enqueueWrite(protocolPlaintextUDP, true) }()
+	select {
+	case <-stopped:
+		t.Fatal("final batch enqueued in a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.dequeueWrite()
+	select {
+	case ok := <-stopped:
+		require.True(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("final batch not enqueued once there was room")
+	}
+}
+
+func TestServerAggregation(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "aggregation-rules.conf")
+	require.NoError(t, os.WriteFile(rulesFile, []byte("app.all.requests (60) = sum app.*.requests\n"), 0o600))
+
+	recorder := &MockRecorder{}
+	recorder.On("measureReceivedPoints", mock.Anything, mock.Anything).Return()
+	appendable := &fakeAppendable{}
+	s, err := NewServer(Config{
+		PlaintextListenAddress:   "127.0.0.1:0",
+		MaxLineLength:            64,
+		BatchSize:                10,
+		Tenant:                   "tenant-1",
+		AggregationRulesFile:     rulesFile,
+		AggregationDelay:         time.Hour,
+		AggregationForwardInputs: true,
+	}, appendable, recorder, log.NewNopLogger())
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() { done <- s.Run() }()
+
+	now := time.Now().Unix()
+	conn, err := net.Dial("tcp", s.PlaintextAddr().String())
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(conn, "app.a.requests 1 %d\napp.b.requests 2 %d\n", now, now)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.Eventually(t, func() bool { return len(appendable.samples()) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	// Stopping flushes the interval that's still open.
+	s.Stop(nil)
+	require.NoError(t, <-done)
+	samples := appendable.samples()
+	require.Len(t, samples, 3)
+	require.Equal(t, sample{
+		tenant: "tenant-1",
+		lbls:   labels.FromStrings("__name__", "graphite_untagged", "__n000__", "app", "__n001__", "all", "__n002__", "requests"),
+		t:      (now - now%60) * 1000,
+		v:      3,
+	}, samples[2])
+	recorder.AssertCalled(t, "measureReceivedPoints", protocolAggregation, 1)
+}
@@ -0,0 +1,209 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+const (
+	// CardinalityModeReject writes the series within the limit and fails the
+	// request with a bad request error naming the limit.
+	CardinalityModeReject = "reject"
+	// CardinalityModeDrop silently drops the series beyond the limit.
+	CardinalityModeDrop = "drop"
+
+	defaultSeriesIdleTimeout       = 20 * time.Minute
+	defaultCardinalityWarningRatio = 0.8
+)
+
+type CardinalityConfig struct {
+	MaxSeriesPerTenant int                    `yaml:"max_series_per_tenant"`
+	TenantMaxSeries    flagext.LimitsMap[int] `yaml:"tenant_max_series"`
+	Mode               string                 `yaml:"mode"`
+	SeriesIdleTimeout  time.Duration          `yaml:"series_idle_timeout"`
+	WarningRatio       float64                `yaml:"warning_ratio"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *CardinalityConfig) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *CardinalityConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	c.TenantMaxSeries = flagext.NewLimitsMap[int](nil)
+	flags.IntVar(&c.MaxSeriesPerTenant, prefix+"cardinality.max-series-per-tenant", 0, "Max number of active series written per tenant. 0 to disable.")
+	flags.Var(&c.TenantMaxSeries, prefix+"cardinality.tenant-max-series", "Per-tenant overrides of the max number of active series, as a JSON object of tenant to limit, e.g. {\"tenant-1\": 100000}.")
+	flags.StringVar(&c.Mode, prefix+"cardinality.mode", CardinalityModeReject, fmt.Sprintf("What to do with new series beyond the limit: %q fails the request after writing the series within the limit, %q drops them silently.", CardinalityModeReject, CardinalityModeDrop))
+	flags.DurationVar(&c.SeriesIdleTimeout, prefix+"cardinality.series-idle-timeout", defaultSeriesIdleTimeout, "Series not written for this long stop counting towards the limit.")
+	flags.Float64Var(&c.WarningRatio, prefix+"cardinality.warning-ratio", defaultCardinalityWarningRatio, "A warning is logged when a tenant's active series reach this ratio of its limit.")
+}
+
+// Validate checks the limits and mode.
+func (c *CardinalityConfig) Validate() error {
+	if c.MaxSeriesPerTenant < 0 {
+		return errors.New("max series per tenant can't be negative")
+	}
+	for tenant, limit := range c.TenantMaxSeries.Read() {
+		if limit < 0 {
+			return fmt.Errorf("max series for tenant %q can't be negative", tenant)
+		}
+	}
+	if c.Mode != CardinalityModeReject && c.Mode != CardinalityModeDrop {
+		return fmt.Errorf("unknown cardinality mode %q", c.Mode)
+	}
+	if c.SeriesIdleTimeout <= 0 {
+		return errors.New("series idle timeout must be positive")
+	}
+	if c.WarningRatio <= 0 || c.WarningRatio > 1 {
+		return errors.New("cardinality warning ratio must be in (0, 1]")
+	}
+	return nil
+}
+
+func (c *CardinalityConfig) limit(tenant string) int {
+	if limit, ok := c.TenantMaxSeries.Read()[tenant]; ok {
+		return limit
+	}
+	return c.MaxSeriesPerTenant
+}
+
+// CardinalityLimitedClient tracks the series written by each tenant and keeps
+// the number of active series of a tenant within its limit. A series is
+// active until it hasn't been written for the configured idle timeout.
+type CardinalityLimitedClient struct {
+	cfg      CardinalityConfig
+	client   Client
+	recorder Recorder
+	logger   log.Logger
+	timeNow  func() time.Time
+
+	mtx     sync.Mutex
+	tenants map[string]*tenantSeries
+}
+
+type tenantSeries struct {
+	mtx       sync.Mutex
+	lastSeen  map[uint64]time.Time
+	lastPurge time.Time
+	warned    bool
+}
+
+func NewCardinalityLimitedClient(client Client, cfg CardinalityConfig, recorder Recorder, logger log.Logger, timeNow func() time.Time) Client {
+	return &CardinalityLimitedClient{
+		cfg:      cfg,
+		client:   client,
+		recorder: recorder,
+		logger:   logger,
+		timeNow:  timeNow,
+		tenants:  map[string]*tenantSeries{},
+	}
+}
+
+func (c *CardinalityLimitedClient) Write(ctx context.Context, req *mimirpb.WriteRequest) error {
+	tenant, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return c.client.Write(ctx, req)
+	}
+	limit := c.cfg.limit(tenant)
+	if limit <= 0 {
+		return c.client.Write(ctx, req)
+	}
+
+	accepted, rejected := c.track(tenant, limit, req.Timeseries)
+	if rejected > 0 {
+		c.recorder.measureRejectedSeries(tenant, rejected)
+		level.Debug(c.logger).Log("msg", "series limit reached, dropping new series", "user", tenant, "limit", limit, "dropped", rejected)
+
+		filtered := *req
+		filtered.Timeseries = accepted
+		req = &filtered
+	}
+
+	if len(req.Timeseries) > 0 {
+		if err := c.client.Write(ctx, req); err != nil {
+			return err
+		}
+	} else if rejected > 0 {
+		markDropped(ctx, DropReasonSeriesLimit)
+	}
+	if rejected > 0 && c.cfg.Mode == CardinalityModeReject {
+		return errorx.BadRequest{Msg: fmt.Sprintf("per-tenant series limit of %d exceeded, %d new series rejected", limit, rejected)}
+	}
+	return nil
+}
+
+// track records the series as seen and returns the ones within the limit.
+// The given series are returned as they are if none is beyond the limit.
+func (c *CardinalityLimitedClient) track(tenant string, limit int, series []mimirpb.PreallocTimeseries) (accepted []mimirpb.PreallocTimeseries, rejected int) {
+	c.mtx.Lock()
+	ts, ok := c.tenants[tenant]
+	if !ok {
+		ts = &tenantSeries{lastSeen: map[uint64]time.Time{}, lastPurge: c.timeNow()}
+		c.tenants[tenant] = ts
+	}
+	c.mtx.Unlock()
+
+	now := c.timeNow()
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	if now.Sub(ts.lastPurge) >= c.cfg.SeriesIdleTimeout {
+		for hash, seen := range ts.lastSeen {
+			if now.Sub(seen) >= c.cfg.SeriesIdleTimeout {
+				delete(ts.lastSeen, hash)
+			}
+		}
+		ts.lastPurge = now
+	}
+
+	var rejectedIdx []int
+	for i, s := range series {
+		hash := mimirpb.FromLabelAdaptersToLabels(s.Labels).Hash()
+		if _, ok := ts.lastSeen[hash]; !ok && len(ts.lastSeen) >= limit {
+			rejectedIdx = append(rejectedIdx, i)
+			continue
+		}
+		ts.lastSeen[hash] = now
+	}
+
+	active := len(ts.lastSeen)
+	c.recorder.measureActiveSeries(tenant, active, limit)
+	nearLimit := float64(active) >= c.cfg.WarningRatio*float64(limit)
+	if nearLimit && !ts.warned {
+		level.Warn(c.logger).Log("msg", "tenant is close to its series limit", "user", tenant, "active_series", active, "limit", limit)
+	}
+	ts.warned = nearLimit
+
+	if len(rejectedIdx) == 0 {
+		return series, 0
+	}
+	accepted = make([]mimirpb.PreallocTimeseries, 0, len(series)-len(rejectedIdx))
+	next := 0
+	for i, s := range series {
+		if next < len(rejectedIdx) && rejectedIdx[next] == i {
+			next++
+			continue
+		}
+		accepted = append(accepted, s)
+	}
+	return accepted, len(rejectedIdx)
+}
@@ -0,0 +1,131 @@
+package remotewrite
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+)
+
+func cardinalityTestRequest(names ...string) *mimirpb.WriteRequest {
+	req := &mimirpb.WriteRequest{}
+	for _, name := range names {
+		req.Timeseries = append(req.Timeseries, mimirpb.PreallocTimeseries{TimeSeries: &mimirpb.TimeSeries{
+			Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: name}},
+			Samples: []mimirpb.Sample{{TimestampMs: 1, Value: 1}},
+		}})
+	}
+	return req
+}
+
+func seriesNames(req *mimirpb.WriteRequest) []string {
+	names := make([]string, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		names = append(names, ts.Labels[0].Value)
+	}
+	return names
+}
+
+func TestCardinalityLimitedClient(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	cfg := CardinalityConfig{
+		MaxSeriesPerTenant: 2,
+		TenantMaxSeries:    flagext.NewLimitsMapWithData(map[string]int{"unlimited": 0}, nil),
+		Mode:               CardinalityModeReject,
+		SeriesIdleTimeout:  time.Minute,
+		WarningRatio:       0.8,
+	}
+
+	var written [][]string
+	client := &remotewritemock.Client{}
+	defer client.AssertExpectations(t)
+	client.On("Write", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		written = append(written, seriesNames(args.Get(1).(*mimirpb.WriteRequest)))
+	})
+	recorder := &MockRecorder{}
+	defer recorder.AssertExpectations(t)
+	recorder.On("measureActiveSeries", "tenant-1", 2, 2).Return()
+	recorder.On("measureActiveSeries", "tenant-1", 1, 2).Return().Once()
+	recorder.On("measureRejectedSeries", "tenant-1", 1).Return().Twice()
+
+	c := NewCardinalityLimitedClient(client, cfg, recorder, log.NewNopLogger(), func() time.Time { return now })
+	ctx := user.InjectOrgID(context.Background(), "tenant-1")
+
+	// The new series beyond the limit are rejected, the others are written.
+	err := c.Write(ctx, cardinalityTestRequest("a", "b", "c"))
+	require.ErrorAs(t, err, &errorx.BadRequest{})
+	require.Equal(t, [][]string{{"a", "b"}}, written)
+
+	// Known series are still accepted.
+	require.NoError(t, c.Write(ctx, cardinalityTestRequest("b", "a")))
+	require.Equal(t, []string{"b", "a"}, written[1])
+
+	// Only new series are rejected; nothing is written if all of them are.
+	require.Error(t, c.Write(ctx, cardinalityTestRequest("d")))
+	require.Len(t, written, 2)
+
+	// Idle series stop counting towards the limit.
+	now = now.Add(time.Minute)
+	require.NoError(t, c.Write(ctx, cardinalityTestRequest("d")))
+	require.Equal(t, []string{"d"}, written[2])
+
+	// Tenants without a limit aren't tracked.
+	unlimited := user.InjectOrgID(context.Background(), "unlimited")
+	require.NoError(t, c.Write(unlimited, cardinalityTestRequest("a", "b", "c", "d")))
+	require.Equal(t, []string{"a", "b", "c", "d"}, written[3])
+}
+
+func TestCardinalityLimitedClient_DropMode(t *testing.T) {
+	client := &remotewritemock.Client{}
+	defer client.AssertExpectations(t)
+	client.On("Write", mock.Anything, mock.MatchedBy(func(req *mimirpb.WriteRequest) bool {
+		return fmt.Sprint(seriesNames(req)) == "[a]"
+	})).Return(nil).Once()
+	recorder := &MockRecorder{}
+	recorder.On("measureActiveSeries", mock.Anything, mock.Anything, mock.Anything).Return()
+	recorder.On("measureRejectedSeries", "tenant-1", 2).Return().Once()
+
+	c := NewCardinalityLimitedClient(client, CardinalityConfig{
+		MaxSeriesPerTenant: 1,
+		Mode:               CardinalityModeDrop,
+		SeriesIdleTimeout:  time.Minute,
+		WarningRatio:       1,
+	}, recorder, log.NewNopLogger(), time.Now)
+
+	require.NoError(t, c.Write(user.InjectOrgID(context.Background(), "tenant-1"), cardinalityTestRequest("a", "b", "c")))
+	recorder.AssertExpectations(t)
+}
+
+func TestCardinalityConfigValidate(t *testing.T) {
+	valid := func() CardinalityConfig {
+		return CardinalityConfig{Mode: CardinalityModeReject, SeriesIdleTimeout: time.Minute, WarningRatio: 0.8}
+	}
+	cfg := valid()
+	require.NoError(t, cfg.Validate())
+
+	for name, mutate := range map[string]func(*CardinalityConfig){
+		"negative limit": func(c *CardinalityConfig) { c.MaxSeriesPerTenant = -1 },
+		"negative override": func(c *CardinalityConfig) {
+			c.TenantMaxSeries = flagext.NewLimitsMapWithData(map[string]int{"a": -1}, nil)
+		},
+		"unknown mode":    func(c *CardinalityConfig) { c.Mode = "block" },
+		"no idle timeout": func(c *CardinalityConfig) { c.SeriesIdleTimeout = 0 },
+		"ratio over 1":    func(c *CardinalityConfig) { c.WarningRatio = 1.5 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := valid()
+			mutate(&cfg)
+			require.Error(t, cfg.Validate())
+		})
+	}
+}
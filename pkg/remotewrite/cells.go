@@ -0,0 +1,99 @@
+package remotewrite
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/frontend/querymiddleware"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// CellsClient is a Client writing the requests of each tenant to the Mimir
+// cell it's homed in, so a single fleet can serve the tenants of several
+// cells.
+type CellsClient struct {
+	cells       map[string]Client
+	tenantCells map[string]string
+	defaultCell string
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inflight *prometheus.GaugeVec
+}
+
+// NewCellsClient creates a CellsClient with a client per cell of
+// cfg.CellEndpoints, each configured as cfg but for the endpoint. It registers
+// the per cell metrics with reg.
+func NewCellsClient(cfg Config, metricsRecorder Recorder, tripperware querymiddleware.Tripperware, metricPrefix string, reg prometheus.Registerer) (*CellsClient, error) {
+	c := &CellsClient{
+		cells:       map[string]Client{},
+		tenantCells: cfg.TenantCells.Read(),
+		defaultCell: cfg.DefaultCell,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricPrefix,
+			Name:      "remote_write_cell_requests_total",
+			Help:      "The total number of writes to each Mimir cell, by result.",
+		}, []string{"cell", "result"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricPrefix,
+			Name:      "remote_write_cell_request_duration_seconds",
+			Help:      "Duration of the writes to each Mimir cell.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"cell"}),
+		inflight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricPrefix,
+			Name:      "remote_write_cell_inflight_requests",
+			Help:      "The number of writes to each Mimir cell in flight.",
+		}, []string{"cell"}),
+	}
+	for cell, endpoint := range cfg.CellEndpoints.Read() {
+		cellCfg := cfg
+		cellCfg.Endpoint = endpoint
+		client, err := NewClient(cellCfg, metricsRecorder, tripperware)
+		if err != nil {
+			return nil, fmt.Errorf("creating client of cell %q: %w", cell, err)
+		}
+		c.cells[cell] = client
+	}
+	for _, collector := range []prometheus.Collector{c.requests, c.duration, c.inflight} {
+		if err := reg.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Write writes req to the cell of the tenant in ctx. The writes of the
+// tenants without a cell are rejected if there's no default cell.
+func (c *CellsClient) Write(ctx context.Context, req *mimirpb.WriteRequest) error {
+	tenant, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return errorx.BadRequest{Msg: "can't route write request to a cell", Err: err}
+	}
+	cell, ok := c.tenantCells[tenant]
+	if !ok {
+		cell = c.defaultCell
+	}
+	client, ok := c.cells[cell]
+	if !ok {
+		return errorx.BadRequest{Msg: fmt.Sprintf("tenant %q isn't homed in any Mimir cell", tenant)}
+	}
+
+	inflight := c.inflight.WithLabelValues(cell)
+	inflight.Inc()
+	defer inflight.Dec()
+	start := time.Now()
+	err = client.Write(ctx, req)
+	c.duration.WithLabelValues(cell).Observe(time.Since(start).Seconds())
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	c.requests.WithLabelValues(cell, result).Inc()
+	return err
+}
@@ -0,0 +1,86 @@
+package remotewrite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+func TestCellsClient(t *testing.T) {
+	var mtx sync.Mutex
+	written := map[string][]string{}
+	newCell := func(name string, status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			written[name] = append(written[name], r.Header.Get(user.OrgIDHeaderName))
+			w.WriteHeader(status)
+		}))
+	}
+	cell1 := newCell("cell-1", http.StatusOK)
+	defer cell1.Close()
+	cell2 := newCell("cell-2", http.StatusServiceUnavailable)
+	defer cell2.Close()
+
+	cfg := Config{
+		Timeout:       time.Second,
+		CellEndpoints: flagext.NewLimitsMapWithData(map[string]string{"cell-1": cell1.URL, "cell-2": cell2.URL}, nil),
+		TenantCells:   flagext.NewLimitsMapWithData(map[string]string{"tenant-1": "cell-1"}, nil),
+		DefaultCell:   "cell-2",
+	}
+	client, err := NewCellsClient(cfg, NewRecorder("test", prometheus.NewRegistry()), nil, "test", prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	require.NoError(t, client.Write(user.InjectOrgID(context.Background(), "tenant-1"), &mimirpb.WriteRequest{}))
+	err = client.Write(user.InjectOrgID(context.Background(), "tenant-2"), &mimirpb.WriteRequest{})
+	require.ErrorAs(t, err, &errorx.Unavailable{})
+	require.Equal(t, map[string][]string{"cell-1": {"tenant-1"}, "cell-2": {"tenant-2"}}, written)
+
+	require.Equal(t, 1.0, testutil.ToFloat64(client.requests.WithLabelValues("cell-1", "success")))
+	require.Equal(t, 1.0, testutil.ToFloat64(client.requests.WithLabelValues("cell-2", "failure")))
+	require.Equal(t, 0.0, testutil.ToFloat64(client.inflight.WithLabelValues("cell-1")))
+
+	t.Run("tenants without a cell are rejected without a default cell", func(t *testing.T) {
+		cfg := cfg
+		cfg.DefaultCell = ""
+		client, err := NewCellsClient(cfg, NewRecorder("test", prometheus.NewRegistry()), nil, "test", prometheus.NewRegistry())
+		require.NoError(t, err)
+
+		err = client.Write(user.InjectOrgID(context.Background(), "tenant-2"), &mimirpb.WriteRequest{})
+		require.ErrorAs(t, err, &errorx.BadRequest{})
+		require.ErrorContains(t, err, `tenant "tenant-2" isn't homed in any Mimir cell`)
+	})
+
+	t.Run("writes without a tenant are rejected", func(t *testing.T) {
+		err := client.Write(context.Background(), &mimirpb.WriteRequest{})
+		require.ErrorAs(t, err, &errorx.BadRequest{})
+	})
+}
+
+func TestConfigCellsFromYAML(t *testing.T) {
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+endpoint: http://mimir/api/v1/push
+timeout: 1s
+cell_endpoints:
+  cell-1: http://mimir-1/api/v1/push
+tenant_cells:
+  tenant-1: cell-1
+`), &cfg))
+	require.Equal(t, map[string]string{"cell-1": "http://mimir-1/api/v1/push"}, cfg.CellEndpoints.Read())
+	require.Equal(t, map[string]string{"tenant-1": "cell-1"}, cfg.TenantCells.Read())
+	require.Equal(t, time.Second, cfg.Timeout)
+}
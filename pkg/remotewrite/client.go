@@ -19,14 +19,17 @@ import (
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
+	"github.com/grafana/dskit/flagext"
 	"github.com/grafana/dskit/user"
 	"github.com/grafana/mimir/pkg/mimirpb"
 	"github.com/mwitkow/go-conntrack"
 	"github.com/opentracing-contrib/go-stdlib/nethttp"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 
 	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
 )
 
 const (
@@ -51,6 +54,26 @@ type Config struct {
 	MaxConns            int           `yaml:"max_conns"`
 	SkipLabelValidation bool          `yaml:"skip_label_validation"`
 	UserAgent           string        `yaml:"user_agent"`
+
+	// CellEndpoints, if set, are the write endpoints of the Mimir cells by
+	// cell name, used by NewCellsClient instead of Endpoint. The writes of a
+	// tenant go to its cell in TenantCells, or to DefaultCell.
+	CellEndpoints flagext.LimitsMap[string] `yaml:"cell_endpoints"`
+	TenantCells   flagext.LimitsMap[string] `yaml:"tenant_cells"`
+	DefaultCell   string                    `yaml:"default_cell"`
+
+	// DeadLetter configures where the writes rejected by Mimir are kept, see
+	// NewFileDeadLetterClient.
+	DeadLetter DeadLetterConfig `yaml:"dead_letter"`
+	// AdaptiveBatching configures splitting the writes of NewClient in
+	// batches adapting to Mimir's latency.
+	AdaptiveBatching AdaptiveBatchingConfig `yaml:"adaptive_batching"`
+
+	// HTTPClient, if set, sends the writes instead of a client built from the
+	// connection settings above, eg. to share the connections of the app's
+	// appcommon.HTTPClientFactory. Its transport is expected to trace the
+	// requests.
+	HTTPClient *http.Client `yaml:"-"`
 }
 
 // RegisterFlags implements flagext.Registerer
@@ -74,6 +97,76 @@ func (c *Config) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
 	flags.IntVar(&c.MaxConns, prefix+"write-max-conns", 100, "Max open conns per host for writes to upstream Prometheus remote write API.")
 	flags.BoolVar(&c.SkipLabelValidation, prefix+"skip-label-validation", false, "If set to true sends requests with headers to skip label validation.")
 	flags.StringVar(&c.UserAgent, prefix+"user-agent", "", "User agent for proxy ingester")
+	c.CellEndpoints = flagext.NewLimitsMap[string](nil)
+	c.TenantCells = flagext.NewLimitsMap[string](nil)
+	flags.Var(&c.CellEndpoints, prefix+"write-cell-endpoints", "Write endpoints of the Mimir cells tenants are homed in, as a JSON object of cell name to URL, e.g. {\"cell-1\": \"http://mimir-1/api/v1/push\"}. Replaces write-endpoint when set.")
+	flags.Var(&c.TenantCells, prefix+"write-tenant-cells", "Cell each tenant is homed in, as a JSON object of tenant to cell name, e.g. {\"tenant-1\": \"cell-1\"}.")
+	flags.StringVar(&c.DefaultCell, prefix+"write-default-cell", "", "Cell of the tenants not in write-tenant-cells. If empty, their writes are rejected.")
+	c.DeadLetter.RegisterFlagsWithPrefix(prefix, flags)
+	c.AdaptiveBatching.RegisterFlagsWithPrefix(prefix, flags)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, creating the cell maps first if
+// RegisterFlags didn't, so a Config can be read from YAML alone.
+func (c *Config) UnmarshalYAML(value *yaml.Node) error {
+	if !c.CellEndpoints.IsInitialized() {
+		c.CellEndpoints = flagext.NewLimitsMap[string](nil)
+	}
+	if !c.TenantCells.IsInitialized() {
+		c.TenantCells = flagext.NewLimitsMap[string](nil)
+	}
+	type plain Config
+	return value.Decode((*plain)(c))
+}
+
+// Validate checks that the config describes a usable remote write endpoint.
+func (c *Config) Validate() error {
+	if cells := c.CellEndpoints.Read(); len(cells) > 0 {
+		for cell, endpoint := range cells {
+			if err := validateEndpoint(endpoint); err != nil {
+				return errors.Wrapf(err, "cell %q", cell)
+			}
+		}
+		for tenant, cell := range c.TenantCells.Read() {
+			if _, ok := cells[cell]; !ok {
+				return errors.Errorf("unknown cell %q of tenant %q", cell, tenant)
+			}
+		}
+		if _, ok := cells[c.DefaultCell]; c.DefaultCell != "" && !ok {
+			return errors.Errorf("unknown default cell %q", c.DefaultCell)
+		}
+	} else if err := validateEndpoint(c.Endpoint); err != nil {
+		return err
+	} else if len(c.TenantCells.Read()) > 0 || c.DefaultCell != "" {
+		return errors.New("tenant cells require cell endpoints")
+	}
+	if c.Timeout <= 0 {
+		return errors.New("write timeout must be positive")
+	}
+	if c.KeepAlive < 0 {
+		return errors.New("write keep alive can't be negative")
+	}
+	if c.MaxIdleConns < 0 || c.MaxConns < 0 {
+		return errors.New("write connection limits can't be negative")
+	}
+	if c.MaxConns > 0 && c.MaxIdleConns > c.MaxConns {
+		return errors.Errorf("write max idle conns (%d) can't be greater than write max conns (%d)", c.MaxIdleConns, c.MaxConns)
+	}
+	if err := c.AdaptiveBatching.Validate(); err != nil {
+		return err
+	}
+	return c.DeadLetter.Validate()
+}
+
+func validateEndpoint(rawURL string) error {
+	endpoint, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.Wrap(err, "invalid write endpoint")
+	}
+	if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return errors.Errorf("write endpoint %q must be an absolute http or https URL", rawURL)
+	}
+	return nil
 }
 
 // NewClient creates the default http implementation of the Client
@@ -83,32 +176,39 @@ func NewClient(cfg Config, metricsRecorder Recorder, tripperware querymiddleware
 		return nil, err
 	}
 
-	httpTransport := http.DefaultTransport.(*http.Transport).Clone()
-	httpTransport.MaxIdleConnsPerHost = cfg.MaxIdleConns
-	httpTransport.MaxIdleConns = cfg.MaxIdleConns
-	httpTransport.MaxConnsPerHost = cfg.MaxConns
-	httpTransport.DialContext = conntrack.NewDialContextFunc(
-		conntrack.DialWithName("remotewrite"),
-		conntrack.DialWithTracing(),
-		conntrack.DialWithDialer(&net.Dialer{
-			Timeout:   cfg.Timeout,
-			KeepAlive: cfg.KeepAlive,
-		}),
-	)
-
-	transport := appcommon.NewTracedAuthRoundTripper(httpTransport, "Remote Write")
+	var transport http.RoundTripper
+	if cfg.HTTPClient != nil {
+		transport = cfg.HTTPClient.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+	} else {
+		httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+		httpTransport.MaxIdleConnsPerHost = cfg.MaxIdleConns
+		httpTransport.MaxIdleConns = cfg.MaxIdleConns
+		httpTransport.MaxConnsPerHost = cfg.MaxConns
+		httpTransport.DialContext = conntrack.NewDialContextFunc(
+			conntrack.DialWithName("remotewrite"),
+			conntrack.DialWithTracing(),
+			conntrack.DialWithDialer(&net.Dialer{
+				Timeout:   cfg.Timeout,
+				KeepAlive: cfg.KeepAlive,
+			}),
+		)
+		transport = appcommon.NewTracedAuthRoundTripper(httpTransport, "Remote Write")
+	}
 	if tripperware != nil {
 		transport = tripperware(transport)
 	}
 
 	httpClient := &http.Client{Transport: transport}
 
-	return &client{
+	return NewAdaptiveBatchingClient(&client{
 		cfg:        cfg,
 		httpClient: httpClient,
 		endpoint:   endpoint.String(),
 		recorder:   metricsRecorder,
-	}, nil
+	}, cfg.AdaptiveBatching, metricsRecorder, time.Now), nil
 }
 
 type client struct {
@@ -149,6 +249,7 @@ func (c *client) Write(ctx context.Context, req *mimirpb.WriteRequest) error {
 	defer cancel()
 
 	httpReq = httpReq.WithContext(ctx)
+	appcommon.InjectDeadlineIntoHTTPRequest(ctx, httpReq)
 	if err := user.InjectOrgIDIntoHTTPRequest(ctx, httpReq); err != nil {
 		return errorx.BadRequest{Msg: "can't set org ID on write request", Err: err}
 	}
@@ -164,6 +265,7 @@ func (c *client) Write(ctx context.Context, req *mimirpb.WriteRequest) error {
 		defer ht.Finish()
 	}
 
+	defer middleware.StartServerTiming(ctx, "remote_write")()
 	return c.do(httpReq)
 }
 
@@ -193,16 +295,36 @@ func (c *client) errFromResp(resp *http.Response) error {
 	}
 	err := errors.Errorf("remote write API returned HTTP status %s: %s", resp.Status, line)
 
-	if resp.StatusCode == http.StatusBadRequest {
-		if strings.Contains(line, "out of order sample") {
+	// The client errors other than timeouts and rate limits are rejections:
+	// sending the write again would fail the same way.
+	switch code := resp.StatusCode; {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		c.recorder.measureRejectedWrite("unauthorized")
+		return errorx.Unauthorized{Msg: "metrics write request not authorized", Err: err}
+	case code == http.StatusRequestEntityTooLarge:
+		c.recorder.measureRejectedWrite("too-large")
+		return errorx.LimitExceeded{Msg: "metrics write request too large", Err: err}
+	case code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests:
+		reason := rejectionReason(line)
+		if reason == "sample-out-of-order" {
 			c.recorder.measureOutOfOrderSamples(1)
 		}
-		return errorx.BadRequest{Msg: "bad metrics write request", Err: err}
+		c.recorder.measureRejectedWrite(reason)
+		return errorx.BadRequest{Msg: "metrics write request rejected", Err: err}
 	}
+	return errorx.FromHTTPResponse(resp, line, "failed writing metrics", err)
+}
 
-	if resp.StatusCode == http.StatusTooManyRequests {
-		return errorx.TooManyRequests{Msg: "too many write requests", Err: err}
+// rejectionReason classifies a bad request error message from Mimir by its
+// error ID, without the "err-mimir-" prefix. Messages without an ID are
+// classified as "other", except out of order samples, which older Cortex and
+// Mimir versions report without an ID.
+func rejectionReason(msg string) string {
+	if id := errorx.ParseMimirErrorID(msg); id != "" {
+		return strings.TrimPrefix(id, "err-mimir-")
 	}
-
-	return errorx.Internal{Msg: "failed writing metrics", Err: err}
+	if strings.Contains(msg, "out of order sample") {
+		return "sample-out-of-order"
+	}
+	return "other"
 }
@@ -13,7 +13,9 @@ import (
 	"github.com/grafana/mimir/pkg/mimirpb"
 
 	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
 
+	"github.com/grafana/dskit/flagext"
 	"github.com/grafana/dskit/user"
 
 	"github.com/stretchr/testify/assert"
@@ -39,6 +41,7 @@ func TestHTTPRemoteWriteClient_Write(t *testing.T) {
 
 		recorderMock := &MockRecorder{}
 		recorderMock.On("measureOutOfOrderSamples", 1).Once().Return(nil)
+		recorderMock.On("measureRejectedWrite", "sample-out-of-order").Once().Return(nil)
 		defer recorderMock.AssertExpectations(t)
 
 		client, err := NewClient(cfg, recorderMock, nil)
@@ -120,6 +123,155 @@ func TestHTTPRemoteWriteClient_Write(t *testing.T) {
 		err = client.Write(ctx, &mimirpb.WriteRequest{})
 		assert.NoError(err)
 	})
+
+	t.Run("propagates the request deadline", func(t *testing.T) {
+		assert, require := assert.New(t), require.New(t)
+
+		deadline := time.Now().Add(500 * time.Millisecond)
+		mux := http.NewServeMux()
+		mux.Handle("/api/prom/push", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			got, ok := middleware.ParseDeadlineHeader(req.Header)
+			assert.True(ok)
+			assert.Equal(deadline.UnixMilli(), got.UnixMilli())
+			rw.WriteHeader(http.StatusOK)
+		}))
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		client, err := NewClient(Config{Endpoint: srv.URL + "/api/prom/push", Timeout: time.Minute}, &MockRecorder{}, nil)
+		require.NoError(err)
+
+		ctx, cancel := context.WithDeadline(user.InjectOrgID(context.Background(), "some-org-id"), deadline)
+		defer cancel()
+		assert.NoError(client.Write(ctx, &mimirpb.WriteRequest{}))
+	})
+
+	t.Run("splits the writes in batches if adaptive batching is enabled", func(t *testing.T) {
+		assert, require := assert.New(t), require.New(t)
+
+		writes := 0
+		mux := http.NewServeMux()
+		mux.Handle("/api/prom/push", http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			writes++
+			rw.WriteHeader(http.StatusOK)
+		}))
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		recorder := &MockRecorder{}
+		recorder.On("measureBatchSize", 1).Return()
+		cfg := Config{Endpoint: srv.URL + "/api/prom/push", Timeout: time.Minute, AdaptiveBatching: AdaptiveBatchingConfig{Enabled: true, MinSamples: 1, MaxSamples: 1, TargetLatency: time.Minute}}
+		client, err := NewClient(cfg, recorder, nil)
+		require.NoError(err)
+
+		series := func() mimirpb.PreallocTimeseries {
+			return mimirpb.PreallocTimeseries{TimeSeries: &mimirpb.TimeSeries{Labels: []mimirpb.LabelAdapter{{Name: "__name__", Value: "up"}}, Samples: []mimirpb.Sample{{TimestampMs: 1, Value: 1}}}}
+		}
+		ctx := user.InjectOrgID(context.Background(), "some-org-id")
+		assert.NoError(client.Write(ctx, &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{series(), series()}}))
+		assert.Equal(2, writes)
+	})
+
+	t.Run("maps rate limited responses with retry hints", func(t *testing.T) {
+		assert, require := assert.New(t), require.New(t)
+
+		mux := http.NewServeMux()
+		mux.Handle("/api/prom/push", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("Retry-After", "3")
+			rw.WriteHeader(http.StatusTooManyRequests)
+			_, _ = rw.Write([]byte("the request has been rejected because the tenant exceeded the request rate limit (err-mimir-tenant-max-request-rate)"))
+		}))
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		client, err := NewClient(Config{Endpoint: srv.URL + "/api/prom/push", Timeout: time.Second}, &MockRecorder{}, nil)
+		require.NoError(err)
+
+		ctx := user.InjectOrgID(context.Background(), "some-org-id")
+		err = client.Write(ctx, &mimirpb.WriteRequest{})
+
+		var tooManyRequests errorx.TooManyRequests
+		require.ErrorAs(err, &tooManyRequests)
+		assert.Equal(3*time.Second, tooManyRequests.RetryAfter)
+		assert.Equal("err-mimir-tenant-max-request-rate", tooManyRequests.Limit)
+	})
+
+	t.Run("maps 503 responses to unavailable", func(t *testing.T) {
+		require := require.New(t)
+
+		mux := http.NewServeMux()
+		mux.Handle("/api/prom/push", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		client, err := NewClient(Config{Endpoint: srv.URL + "/api/prom/push", Timeout: time.Second}, &MockRecorder{}, nil)
+		require.NoError(err)
+
+		ctx := user.InjectOrgID(context.Background(), "some-org-id")
+		err = client.Write(ctx, &mimirpb.WriteRequest{})
+		require.ErrorAs(err, &errorx.Unavailable{})
+	})
+
+	t.Run("maps the other 4xx responses to rejections", func(t *testing.T) {
+		for status, tc := range map[int]struct {
+			reason string
+			target error
+		}{
+			http.StatusUnauthorized:          {reason: "unauthorized", target: &errorx.Unauthorized{}},
+			http.StatusForbidden:             {reason: "unauthorized", target: &errorx.Unauthorized{}},
+			http.StatusNotFound:              {reason: "other", target: &errorx.BadRequest{}},
+			http.StatusRequestEntityTooLarge: {reason: "too-large", target: &errorx.LimitExceeded{}},
+		} {
+			t.Run(http.StatusText(status), func(t *testing.T) {
+				mux := http.NewServeMux()
+				mux.Handle("/api/prom/push", http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+					rw.WriteHeader(status)
+				}))
+				srv := httptest.NewServer(mux)
+				defer srv.Close()
+
+				recorder := &MockRecorder{}
+				recorder.On("measureRejectedWrite", tc.reason).Once().Return()
+				client, err := NewClient(Config{Endpoint: srv.URL + "/api/prom/push", Timeout: time.Second}, recorder, nil)
+				require.NoError(t, err)
+
+				err = client.Write(user.InjectOrgID(context.Background(), "some-org-id"), &mimirpb.WriteRequest{})
+				require.ErrorAs(t, err, tc.target)
+				recorder.AssertExpectations(t)
+			})
+		}
+	})
+
+	t.Run("uses the configured HTTP client", func(t *testing.T) {
+		require := require.New(t)
+
+		mux := http.NewServeMux()
+		mux.Handle("/api/prom/push", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(http.StatusOK)
+		}))
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		requests := 0
+		httpClient := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			requests++
+			return http.DefaultTransport.RoundTrip(req)
+		})}
+		client, err := NewClient(Config{Endpoint: srv.URL + "/api/prom/push", Timeout: time.Second, HTTPClient: httpClient}, &MockRecorder{}, nil)
+		require.NoError(err)
+
+		ctx := user.InjectOrgID(context.Background(), "some-org-id")
+		require.NoError(client.Write(ctx, &mimirpb.WriteRequest{}))
+		require.Equal(1, requests)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
 }
 
 const outOfOrderSampleResponseText = "user=41413: err: out of order sample. " +
@@ -128,3 +280,60 @@ const outOfOrderSampleResponseText = "user=41413: err: out of order sample. " +
 	`cluster__name=\"dev-cluster\", ` +
 	`host=\"cluster.dev.internal\", ` +
 	`kube__cluster__name=\"dev-cluster\"}`
+
+func TestRejectionReason(t *testing.T) {
+	for msg, want := range map[string]string{
+		outOfOrderSampleResponseText: "sample-out-of-order",
+		"send data to ingesters: failed pushing to ingester: user=1: the sample has been rejected because its timestamp is too old (err-mimir-sample-timestamp-too-old).": "sample-timestamp-too-old",
+		"received a series with an invalid label: 'a' (err-mimir-label-invalid)":                                                                                          "label-invalid",
+		"something went wrong": "other",
+	} {
+		require.Equal(t, want, rejectionReason(msg), msg)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{Endpoint: "http://mimir/api/v1/push", Timeout: time.Second, MaxIdleConns: 10, MaxConns: 100}
+
+	for name, tc := range map[string]struct {
+		mutate  func(cfg *Config)
+		wantErr string
+	}{
+		"valid":               {mutate: func(*Config) {}},
+		"missing endpoint":    {mutate: func(cfg *Config) { cfg.Endpoint = "" }, wantErr: "must be an absolute http or https URL"},
+		"relative endpoint":   {mutate: func(cfg *Config) { cfg.Endpoint = "/api/v1/push" }, wantErr: "must be an absolute http or https URL"},
+		"zero timeout":        {mutate: func(cfg *Config) { cfg.Timeout = 0 }, wantErr: "write timeout must be positive"},
+		"negative conns":      {mutate: func(cfg *Config) { cfg.MaxConns = -1 }, wantErr: "can't be negative"},
+		"idle above max":      {mutate: func(cfg *Config) { cfg.MaxIdleConns = 200 }, wantErr: "can't be greater than write max conns"},
+		"unlimited max conns": {mutate: func(cfg *Config) { cfg.MaxConns = 0 }},
+		"cells": {mutate: func(cfg *Config) {
+			cfg.Endpoint = ""
+			cfg.CellEndpoints = flagext.NewLimitsMapWithData(map[string]string{"cell-1": "http://mimir-1/api/v1/push"}, nil)
+			cfg.TenantCells = flagext.NewLimitsMapWithData(map[string]string{"tenant-1": "cell-1"}, nil)
+			cfg.DefaultCell = "cell-1"
+		}},
+		"relative cell endpoint": {mutate: func(cfg *Config) {
+			cfg.CellEndpoints = flagext.NewLimitsMapWithData(map[string]string{"cell-1": "/api/v1/push"}, nil)
+		}, wantErr: `cell "cell-1": write endpoint "/api/v1/push" must be an absolute http or https URL`},
+		"unknown tenant cell": {mutate: func(cfg *Config) {
+			cfg.CellEndpoints = flagext.NewLimitsMapWithData(map[string]string{"cell-1": "http://mimir-1/api/v1/push"}, nil)
+			cfg.TenantCells = flagext.NewLimitsMapWithData(map[string]string{"tenant-1": "cell-2"}, nil)
+		}, wantErr: `unknown cell "cell-2" of tenant "tenant-1"`},
+		"unknown default cell": {mutate: func(cfg *Config) {
+			cfg.CellEndpoints = flagext.NewLimitsMapWithData(map[string]string{"cell-1": "http://mimir-1/api/v1/push"}, nil)
+			cfg.DefaultCell = "cell-2"
+		}, wantErr: `unknown default cell "cell-2"`},
+		"tenant cells without cells": {mutate: func(cfg *Config) { cfg.DefaultCell = "cell-1" }, wantErr: "tenant cells require cell endpoints"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := valid
+			tc.mutate(&cfg)
+			err := cfg.Validate()
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
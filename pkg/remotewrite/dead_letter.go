@@ -0,0 +1,291 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	dskittenant "github.com/grafana/dskit/tenant"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+const defaultDeadLetterQueueSize = 1000
+
+// errDeadLetterQueueFull is returned by AsyncDeadLetterSink.Write when the
+// dead letter can't be queued.
+var errDeadLetterQueueFull = errors.New("dead letter queue is full")
+
+type DeadLetterConfig struct {
+	// Path is the file the rejected writes are appended to. Empty disables
+	// the file sink.
+	Path string `yaml:"path"`
+	// QueueSize is how many dead letters can wait to be written to the
+	// sink, the next ones are dropped.
+	QueueSize int `yaml:"queue_size"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *DeadLetterConfig) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *DeadLetterConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.StringVar(&c.Path, prefix+"dead-letter.path", "", "File the writes permanently rejected by Mimir are appended to, one JSON object per line, so they can be inspected and re-ingested. Empty to disable.")
+	flags.IntVar(&c.QueueSize, prefix+"dead-letter.queue-size", defaultDeadLetterQueueSize, "Max number of rejected writes waiting to be appended to dead-letter.path. The writes rejected while it's full aren't kept.")
+}
+
+// Validate checks the queue size.
+func (c *DeadLetterConfig) Validate() error {
+	if c.Path != "" && c.QueueSize <= 0 {
+		return errors.New("dead letter queue size must be positive")
+	}
+	return nil
+}
+
+// DeadLetter is a write permanently rejected by Mimir, with the reason why.
+type DeadLetter struct {
+	Time   time.Time `json:"time"`
+	Tenant string    `json:"tenant"`
+	// Reason classifies the rejection, like the reason label of the
+	// rejected writes metric.
+	Reason     string                       `json:"reason"`
+	Error      string                       `json:"error"`
+	Timeseries []mimirpb.PreallocTimeseries `json:"timeseries"`
+	Metadata   []*mimirpb.MetricMetadata    `json:"metadata,omitempty"`
+}
+
+// DeadLetterSink stores dead letters.
+type DeadLetterSink interface {
+	Write(ctx context.Context, letter DeadLetter) error
+}
+
+// DeadLetterClient writes the requests rejected by Mimir as bad requests to a
+// sink, instead of losing them silently. The error is still returned. It
+// should wrap the client writing to Mimir directly, so the dead letters hold
+// what Mimir rejected.
+type DeadLetterClient struct {
+	client  Client
+	sink    DeadLetterSink
+	logger  log.Logger
+	timeNow func() time.Time
+}
+
+func NewDeadLetterClient(client Client, sink DeadLetterSink, logger log.Logger, timeNow func() time.Time) Client {
+	return &DeadLetterClient{client: client, sink: sink, logger: logger, timeNow: timeNow}
+}
+
+// NewFileDeadLetterClient wraps client to append the writes rejected by Mimir
+// to the file of cfg in the background, or returns client as is if cfg has no
+// path. Close the returned io.Closer to write the queued dead letters and
+// close the file when the app stops.
+func NewFileDeadLetterClient(client Client, cfg DeadLetterConfig, logger log.Logger) (Client, io.Closer, error) {
+	if cfg.Path == "" {
+		return client, io.NopCloser(nil), nil
+	}
+	fileSink, err := NewFileDeadLetterSink(cfg.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	sink := NewAsyncDeadLetterSink(fileSink, cfg.QueueSize, logger)
+	return NewDeadLetterClient(client, sink, logger, time.Now), sink, nil
+}
+
+func (c *DeadLetterClient) Write(ctx context.Context, req *mimirpb.WriteRequest) error {
+	err := c.client.Write(ctx, req)
+	var badRequest errorx.BadRequest
+	if !errors.As(err, &badRequest) {
+		return err
+	}
+
+	tenant, _ := user.ExtractOrgID(ctx)
+	letter := DeadLetter{
+		Time:       c.timeNow(),
+		Tenant:     tenant,
+		Reason:     rejectionReason(err.Error()),
+		Error:      err.Error(),
+		Timeseries: req.Timeseries,
+		Metadata:   req.Metadata,
+	}
+	if sinkErr := c.sink.Write(ctx, letter); sinkErr != nil {
+		level.Error(c.logger).Log("msg", "can't write rejected write request to the dead letter sink", "tenant", tenant, "series", len(req.Timeseries), "err", sinkErr)
+	}
+	return err
+}
+
+// FileDeadLetterSink appends dead letters to a file, one JSON object per line.
+type FileDeadLetterSink struct {
+	mtx  sync.Mutex
+	file *os.File
+}
+
+func NewFileDeadLetterSink(path string) (*FileDeadLetterSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("can't open dead letter file: %w", err)
+	}
+	return &FileDeadLetterSink{file: file}, nil
+}
+
+func (s *FileDeadLetterSink) Write(_ context.Context, letter DeadLetter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	_, err = s.file.Write(line)
+	return err
+}
+
+func (s *FileDeadLetterSink) Close() error {
+	return s.file.Close()
+}
+
+// AsyncDeadLetterSink writes the dead letters to its sink in the background,
+// so the rejected writes don't wait for the sink. Write fails when queueSize
+// dead letters are already waiting, and the errors of the sink are logged.
+type AsyncDeadLetterSink struct {
+	sink   DeadLetterSink
+	logger log.Logger
+
+	mtx    sync.RWMutex
+	closed bool
+	queue  chan asyncDeadLetter
+	done   chan struct{}
+}
+
+type asyncDeadLetter struct {
+	ctx    context.Context
+	letter DeadLetter
+}
+
+func NewAsyncDeadLetterSink(sink DeadLetterSink, queueSize int, logger log.Logger) *AsyncDeadLetterSink {
+	s := &AsyncDeadLetterSink{
+		sink:   sink,
+		logger: logger,
+		queue:  make(chan asyncDeadLetter, queueSize),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write queues a copy of letter, as the series of the write request may be
+// reused once the write returns. The context keeps its values, but not its
+// cancellation.
+func (s *AsyncDeadLetterSink) Write(ctx context.Context, letter DeadLetter) error {
+	letter, err := copyDeadLetter(letter)
+	if err != nil {
+		return err
+	}
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if s.closed {
+		return errors.New("dead letter sink is closed")
+	}
+	select {
+	case s.queue <- asyncDeadLetter{ctx: context.WithoutCancel(ctx), letter: letter}:
+		return nil
+	default:
+		return errDeadLetterQueueFull
+	}
+}
+
+func (s *AsyncDeadLetterSink) run() {
+	defer close(s.done)
+	for l := range s.queue {
+		if err := s.sink.Write(l.ctx, l.letter); err != nil {
+			level.Error(s.logger).Log("msg", "can't write rejected write request to the dead letter sink", "tenant", l.letter.Tenant, "series", len(l.letter.Timeseries), "err", err)
+		}
+	}
+}
+
+// Close writes the queued dead letters, then closes the sink if it's an
+// io.Closer.
+func (s *AsyncDeadLetterSink) Close() error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.mtx.Unlock()
+
+	<-s.done
+	if closer, ok := s.sink.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// copyDeadLetter returns letter with a deep copy of its series and metadata.
+func copyDeadLetter(letter DeadLetter) (DeadLetter, error) {
+	data, err := (&mimirpb.WriteRequest{Timeseries: letter.Timeseries, Metadata: letter.Metadata}).Marshal()
+	if err != nil {
+		return letter, err
+	}
+	var req mimirpb.WriteRequest
+	if err := req.Unmarshal(data); err != nil {
+		return letter, err
+	}
+	letter.Timeseries, letter.Metadata = req.Timeseries, req.Metadata
+	return letter, nil
+}
+
+// ObjectUploader uploads objects to a bucket. It's implemented by the
+// objstore.Bucket of Thanos and Mimir.
+type ObjectUploader interface {
+	Upload(ctx context.Context, name string, r io.Reader) error
+}
+
+// BucketDeadLetterSink uploads each dead letter as a JSON object named
+// <prefix>/<tenant>/<unix nanoseconds>.json. The dead letters without a
+// tenant go under "unknown", and the ones whose tenant isn't a valid tenant
+// ID, eg. with path segments, under "invalid".
+type BucketDeadLetterSink struct {
+	bucket ObjectUploader
+	prefix string
+}
+
+func NewBucketDeadLetterSink(bucket ObjectUploader, prefix string) *BucketDeadLetterSink {
+	return &BucketDeadLetterSink{bucket: bucket, prefix: prefix}
+}
+
+func (s *BucketDeadLetterSink) Write(ctx context.Context, letter DeadLetter) error {
+	content, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	tenant := letter.Tenant
+	switch {
+	case tenant == "":
+		tenant = "unknown"
+	case dskittenant.ValidTenantID(tenant) != nil:
+		tenant = "invalid"
+	}
+	name := path.Join(s.prefix, tenant, fmt.Sprintf("%d.json", letter.Time.UnixNano()))
+	return s.bucket.Upload(ctx, name, bytes.NewReader(content))
+}
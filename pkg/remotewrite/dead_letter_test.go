@@ -0,0 +1,201 @@
+package remotewrite
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+)
+
+func TestDeadLetterClient(t *testing.T) {
+	now := time.Unix(1700000000, 0).UTC()
+	ctx := user.InjectOrgID(context.Background(), "tenant-1")
+	req := &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{
+		{TimeSeries: &mimirpb.TimeSeries{
+			Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "some_metric"}},
+			Samples: []mimirpb.Sample{{TimestampMs: 1000, Value: 1.5}},
+		}},
+	}}
+	badRequest := errorx.BadRequest{Msg: "bad metrics write request", Err: errors.New("remote write API returned HTTP status 400 Bad Request: received a series with an invalid label (err-mimir-label-invalid)")}
+
+	for name, tc := range map[string]struct {
+		writeErr    error
+		wantLetters int
+	}{
+		"success": {},
+		"bad request": {
+			writeErr:    badRequest,
+			wantLetters: 1,
+		},
+		"retryable error": {
+			writeErr: errorx.TooManyRequests{Msg: "too many write requests"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+			sink, err := NewFileDeadLetterSink(path)
+			require.NoError(t, err)
+
+			client := &remotewritemock.Client{}
+			defer client.AssertExpectations(t)
+			client.On("Write", ctx, req).Return(tc.writeErr)
+
+			dlc := NewDeadLetterClient(client, sink, log.NewNopLogger(), func() time.Time { return now })
+			require.Equal(t, tc.writeErr, dlc.Write(ctx, req))
+			require.NoError(t, sink.Close())
+
+			letters := readDeadLetters(t, path)
+			require.Len(t, letters, tc.wantLetters)
+			if tc.wantLetters == 0 {
+				return
+			}
+			letter := letters[0]
+			require.True(t, now.Equal(letter.Time))
+			require.Equal(t, "tenant-1", letter.Tenant)
+			require.Equal(t, "label-invalid", letter.Reason)
+			require.Equal(t, badRequest.Error(), letter.Error)
+			require.Equal(t, req.Timeseries[0].Labels, letter.Timeseries[0].Labels)
+			require.Equal(t, req.Timeseries[0].Samples, letter.Timeseries[0].Samples)
+		})
+	}
+}
+
+func TestBucketDeadLetterSink(t *testing.T) {
+	bucket := &fakeUploader{objects: map[string][]byte{}}
+	sink := NewBucketDeadLetterSink(bucket, "dead-letters")
+
+	letter := DeadLetter{Time: time.Unix(0, 1234), Tenant: "tenant-1", Reason: "other"}
+	require.NoError(t, sink.Write(context.Background(), letter))
+	letter.Tenant = ""
+	require.NoError(t, sink.Write(context.Background(), letter))
+	letter.Tenant = "../../other"
+	require.NoError(t, sink.Write(context.Background(), letter))
+
+	require.Len(t, bucket.objects, 3)
+	require.Contains(t, bucket.objects, "dead-letters/tenant-1/1234.json")
+	require.Contains(t, bucket.objects, "dead-letters/unknown/1234.json")
+	require.Contains(t, bucket.objects, "dead-letters/invalid/1234.json")
+	var got DeadLetter
+	require.NoError(t, json.Unmarshal(bucket.objects["dead-letters/tenant-1/1234.json"], &got))
+	require.Equal(t, "other", got.Reason)
+}
+
+func TestAsyncDeadLetterSink(t *testing.T) {
+	unblock := make(chan struct{})
+	sink := &blockingSink{unblock: unblock}
+	async := NewAsyncDeadLetterSink(sink, 1, log.NewNopLogger())
+
+	series := []mimirpb.PreallocTimeseries{{TimeSeries: &mimirpb.TimeSeries{
+		Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "some_metric"}},
+		Samples: []mimirpb.Sample{{TimestampMs: 1000, Value: 1.5}},
+	}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, async.Write(ctx, DeadLetter{Tenant: "tenant-1", Timeseries: series}))
+	// The series of the request can be reused once written.
+	series[0].Samples[0].Value = 2
+	cancel()
+
+	// The first dead letter is being written, and the second waits in the
+	// queue, so the third doesn't fit.
+	require.Eventually(t, func() bool {
+		return async.Write(context.Background(), DeadLetter{Tenant: "tenant-2"}) == nil
+	}, 5*time.Second, time.Millisecond)
+	require.ErrorIs(t, async.Write(context.Background(), DeadLetter{Tenant: "tenant-3"}), errDeadLetterQueueFull)
+
+	close(unblock)
+	require.NoError(t, async.Close())
+	require.True(t, sink.closed)
+	require.Len(t, sink.letters, 2)
+	require.Equal(t, "tenant-1", sink.letters[0].Tenant)
+	require.Equal(t, 1.5, sink.letters[0].Timeseries[0].Samples[0].Value)
+	require.Equal(t, "tenant-2", sink.letters[1].Tenant)
+	require.Error(t, async.Write(context.Background(), DeadLetter{}))
+}
+
+func TestNewFileDeadLetterClient(t *testing.T) {
+	client := &remotewritemock.Client{}
+	dlc, closer, err := NewFileDeadLetterClient(client, DeadLetterConfig{}, log.NewNopLogger())
+	require.NoError(t, err)
+	require.Same(t, client, dlc)
+	require.NoError(t, closer.Close())
+
+	ctx := user.InjectOrgID(context.Background(), "tenant-1")
+	req := &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{{TimeSeries: &mimirpb.TimeSeries{
+		Labels: []mimirpb.LabelAdapter{{Name: "__name__", Value: "some_metric"}},
+	}}}}
+	badRequest := errorx.BadRequest{Msg: "bad metrics write request"}
+	client.On("Write", ctx, req).Return(badRequest)
+
+	path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	dlc, closer, err = NewFileDeadLetterClient(client, DeadLetterConfig{Path: path, QueueSize: 10}, log.NewNopLogger())
+	require.NoError(t, err)
+	require.Equal(t, badRequest, dlc.Write(ctx, req))
+	require.NoError(t, closer.Close())
+
+	letters := readDeadLetters(t, path)
+	require.Len(t, letters, 1)
+	require.Equal(t, "tenant-1", letters[0].Tenant)
+}
+
+// blockingSink keeps the dead letters written once unblock is closed.
+type blockingSink struct {
+	unblock chan struct{}
+	letters []DeadLetter
+	closed  bool
+}
+
+func (s *blockingSink) Write(ctx context.Context, letter DeadLetter) error {
+	<-s.unblock
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.letters = append(s.letters, letter)
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func readDeadLetters(t *testing.T, path string) []DeadLetter {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var letter DeadLetter
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &letter))
+		letters = append(letters, letter)
+	}
+	require.NoError(t, scanner.Err())
+	return letters
+}
+
+type fakeUploader struct {
+	objects map[string][]byte
+}
+
+func (u *fakeUploader) Upload(_ context.Context, name string, r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	u.objects[name] = content
+	return nil
+}
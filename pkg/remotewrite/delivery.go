@@ -0,0 +1,100 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// The reasons writes are dropped, given to DeliveryHooks.OnDropped.
+const (
+	// DropReasonFailed is a write that failed, eg. because Mimir was
+	// unavailable or rate limited the tenant. It can be retried.
+	DropReasonFailed = "failed"
+	// DropReasonRejected is a write Mimir rejected with a client error other
+	// than a timeout or a rate limit, eg. a bad request, an authorization
+	// error or a too large write. Retrying it would fail again.
+	DropReasonRejected = "rejected"
+	// DropReasonTooOld is a write whose samples were all older than the max
+	// sample age, dropped by SampleAgeClient.
+	DropReasonTooOld = "too_old"
+	// DropReasonSeriesLimit is a write whose series were all new series of a
+	// tenant at its series limit, dropped by CardinalityLimitedClient.
+	DropReasonSeriesLimit = "series_limit"
+)
+
+type deliveryContextKey int
+
+const deliveryKey deliveryContextKey = 0
+
+// DeliveryHooks are told the outcome of a write, so ingestion adapters can
+// acknowledge what they received, eg. commit a Kafka offset, only once it was
+// delivered to Mimir. Exactly one of them is called per write, if set.
+type DeliveryHooks struct {
+	// OnDelivered is called once Mimir accepted the write. Some of its
+	// samples or series may have been dropped on purpose before, eg. by
+	// the too old samples policy.
+	OnDelivered func()
+	// OnDropped is called when the write wasn't delivered, with why and the
+	// error of the write, if it failed. Only the writes dropped with
+	// DropReasonFailed should be retried.
+	OnDropped func(reason string, err error)
+}
+
+// delivery records why a client of the chain dropped a write without
+// failing it.
+type delivery struct {
+	mtx    sync.Mutex
+	reason string
+}
+
+// markDropped records that the write of ctx, if it's written with
+// WriteWithDeliveryHooks, was dropped for reason.
+func markDropped(ctx context.Context, reason string) {
+	if d, ok := ctx.Value(deliveryKey).(*delivery); ok {
+		d.mtx.Lock()
+		d.reason = reason
+		d.mtx.Unlock()
+	}
+}
+
+// WriteWithDeliveryHooks writes req with client, and calls the hook of its
+// outcome. It returns the error of the write.
+func WriteWithDeliveryHooks(ctx context.Context, client Client, req *mimirpb.WriteRequest, hooks DeliveryHooks) error {
+	d := &delivery{}
+	err := client.Write(context.WithValue(ctx, deliveryKey, d), req)
+
+	d.mtx.Lock()
+	reason := d.reason
+	d.mtx.Unlock()
+	if err != nil && reason == "" {
+		reason = DropReasonFailed
+		if isRejected(err) {
+			reason = DropReasonRejected
+		}
+	}
+
+	switch {
+	case reason != "" && hooks.OnDropped != nil:
+		hooks.OnDropped(reason, err)
+	case reason == "" && hooks.OnDelivered != nil:
+		hooks.OnDelivered()
+	}
+	return err
+}
+
+// isRejected returns whether err is a client error that would fail again if
+// the write was retried, unlike timeouts and rate limits.
+func isRejected(err error) bool {
+	var errx errorx.Error
+	if !errors.As(err, &errx) {
+		return false
+	}
+	code := errx.HTTPStatusCode()
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
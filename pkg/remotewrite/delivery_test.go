@@ -0,0 +1,129 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+)
+
+func TestWriteWithDeliveryHooks(t *testing.T) {
+	now := time.UnixMilli(100_000)
+	recorder := &MockRecorder{}
+	recorder.On("measureTooOldSamples", mock.Anything, mock.Anything).Return()
+	recorder.On("measureRejectedSeries", mock.Anything, mock.Anything).Return()
+	recorder.On("measureActiveSeries", mock.Anything, mock.Anything, mock.Anything).Return()
+
+	for name, tc := range map[string]struct {
+		writeErr   error
+		client     func(Client) Client
+		req        *mimirpb.WriteRequest
+		wantReason string
+		wantErr    bool
+	}{
+		"delivered": {
+			req: cardinalityTestRequest("up"),
+		},
+		"failed": {
+			writeErr:   errorx.TooManyRequests{Msg: "rate limited"},
+			req:        cardinalityTestRequest("up"),
+			wantReason: DropReasonFailed,
+			wantErr:    true,
+		},
+		"rejected": {
+			writeErr:   errorx.BadRequest{Msg: "out of bounds"},
+			req:        cardinalityTestRequest("up"),
+			wantReason: DropReasonRejected,
+			wantErr:    true,
+		},
+		"unauthorized is rejected": {
+			writeErr:   errorx.Unauthorized{Msg: "invalid API key"},
+			req:        cardinalityTestRequest("up"),
+			wantReason: DropReasonRejected,
+			wantErr:    true,
+		},
+		"timeout failed": {
+			writeErr:   errorx.RequestTimeout{Msg: "timed out"},
+			req:        cardinalityTestRequest("up"),
+			wantReason: DropReasonFailed,
+			wantErr:    true,
+		},
+		"partly too old is delivered": {
+			client: func(c Client) Client {
+				return NewSampleAgeClient(c, SampleAgeConfig{MaxAge: time.Minute, TooOldPolicy: TooOldPolicyDrop}, recorder, func() time.Time { return now })
+			},
+			req: &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{{TimeSeries: &mimirpb.TimeSeries{
+				Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+				Samples: []mimirpb.Sample{{TimestampMs: 10_000, Value: 1}, {TimestampMs: 95_000, Value: 1}},
+			}}}},
+		},
+		"all too old": {
+			client: func(c Client) Client {
+				return NewSampleAgeClient(c, SampleAgeConfig{MaxAge: time.Minute, TooOldPolicy: TooOldPolicyDrop}, recorder, func() time.Time { return now })
+			},
+			req: &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{{TimeSeries: &mimirpb.TimeSeries{
+				Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+				Samples: []mimirpb.Sample{{TimestampMs: 10_000, Value: 1}},
+			}}}},
+			wantReason: DropReasonTooOld,
+		},
+		"all over the series limit": {
+			client: func(c Client) Client {
+				cfg := CardinalityConfig{
+					MaxSeriesPerTenant: 1,
+					TenantMaxSeries:    flagext.NewLimitsMapWithData(map[string]int{}, nil),
+					Mode:               CardinalityModeDrop,
+					SeriesIdleTimeout:  time.Minute,
+				}
+				limited := NewCardinalityLimitedClient(c, cfg, recorder, log.NewNopLogger(), func() time.Time { return now })
+				require.NoError(t, limited.Write(user.InjectOrgID(context.Background(), "tenant-1"), cardinalityTestRequest("first")))
+				return limited
+			},
+			req:        cardinalityTestRequest("second"),
+			wantReason: DropReasonSeriesLimit,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			inner := &remotewritemock.Client{}
+			inner.On("Write", mock.Anything, mock.Anything).Return(tc.writeErr).Maybe()
+			var client Client = inner
+			if tc.client != nil {
+				client = tc.client(inner)
+			}
+
+			var delivered int
+			var dropped []string
+			var droppedErr error
+			err := WriteWithDeliveryHooks(user.InjectOrgID(context.Background(), "tenant-1"), client, tc.req, DeliveryHooks{
+				OnDelivered: func() { delivered++ },
+				OnDropped: func(reason string, err error) {
+					dropped = append(dropped, reason)
+					droppedErr = err
+				},
+			})
+			if tc.wantErr {
+				require.Error(t, err)
+				require.True(t, errors.Is(droppedErr, tc.writeErr))
+			} else {
+				require.NoError(t, err)
+			}
+			if tc.wantReason == "" {
+				require.Equal(t, 1, delivered)
+				require.Empty(t, dropped)
+			} else {
+				require.Zero(t, delivered)
+				require.Equal(t, []string{tc.wantReason}, dropped)
+			}
+		})
+	}
+}
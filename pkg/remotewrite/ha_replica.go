@@ -0,0 +1,136 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+)
+
+type HAReplicaConfig struct {
+	// Cluster and Replica, if Cluster is set, are added to all the written
+	// series, so Mimir's HA tracker deduplicates the writes of the replicas
+	// of an active-active pair of write proxies.
+	Cluster string `yaml:"cluster"`
+	Replica string `yaml:"replica"`
+	// ReplicaFromPodName uses the pod name as Replica, read from the POD_NAME
+	// environment variable or the host name.
+	ReplicaFromPodName bool   `yaml:"replica_from_pod_name"`
+	ClusterLabel       string `yaml:"cluster_label"`
+	ReplicaLabel       string `yaml:"replica_label"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *HAReplicaConfig) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *HAReplicaConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.StringVar(&c.Cluster, prefix+"ha.cluster", "", "Name of the HA pair this instance belongs to, added to all the written series so Mimir's HA tracker deduplicates the writes of the pair. Empty to disable.")
+	flags.StringVar(&c.Replica, prefix+"ha.replica", "", "Name of this instance in its HA pair, added to all the written series.")
+	flags.BoolVar(&c.ReplicaFromPodName, prefix+"ha.replica-from-pod-name", false, "Use the pod name as ha.replica, read from the POD_NAME environment variable or the host name.")
+	flags.StringVar(&c.ClusterLabel, prefix+"ha.cluster-label", "cluster", "Label holding the HA cluster, as set in Mimir's -distributor.ha-tracker.cluster.")
+	flags.StringVar(&c.ReplicaLabel, prefix+"ha.replica-label", "__replica__", "Label holding the HA replica, as set in Mimir's -distributor.ha-tracker.replica.")
+}
+
+// Validate checks that the replica is set when the cluster is.
+func (c *HAReplicaConfig) Validate() error {
+	if c.Cluster == "" {
+		return nil
+	}
+	if c.Replica == "" && !c.ReplicaFromPodName {
+		return errors.New("ha replica must be set when the ha cluster is")
+	}
+	if c.ClusterLabel == "" || c.ReplicaLabel == "" {
+		return errors.New("ha cluster and replica labels can't be empty")
+	}
+	if c.ClusterLabel == c.ReplicaLabel {
+		return errors.New("ha cluster and replica labels must be different")
+	}
+	return nil
+}
+
+// replica returns the configured replica, or the pod name.
+func (c *HAReplicaConfig) replica() (string, error) {
+	if !c.ReplicaFromPodName {
+		return c.Replica, nil
+	}
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		return pod, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "", errors.New("can't read the pod name for the ha replica: POD_NAME isn't set and the host name is unknown")
+	}
+	return hostname, nil
+}
+
+// HAReplicaClient adds the HA cluster and replica labels to all the series
+// before writing them, replacing the values the series already have.
+type HAReplicaClient struct {
+	client Client
+	labels []mimirpb.LabelAdapter
+}
+
+// NewHAReplicaClient wraps client to add the HA labels of cfg, or returns
+// client as is if no HA cluster is set.
+func NewHAReplicaClient(client Client, cfg HAReplicaConfig) (Client, error) {
+	if cfg.Cluster == "" {
+		return client, nil
+	}
+	replica, err := cfg.replica()
+	if err != nil {
+		return nil, err
+	}
+	labels := []mimirpb.LabelAdapter{
+		{Name: cfg.ClusterLabel, Value: cfg.Cluster},
+		{Name: cfg.ReplicaLabel, Value: replica},
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return &HAReplicaClient{client: client, labels: labels}, nil
+}
+
+func (c *HAReplicaClient) Write(ctx context.Context, req *mimirpb.WriteRequest) error {
+	// Copy the series, so the caller's request isn't modified.
+	series := make([]mimirpb.PreallocTimeseries, len(req.Timeseries))
+	for i, ts := range req.Timeseries {
+		changed := *ts.TimeSeries
+		changed.Labels = mergeLabels(ts.Labels, c.labels)
+		series[i] = mimirpb.PreallocTimeseries{TimeSeries: &changed}
+	}
+	labeled := *req
+	labeled.Timeseries = series
+	return c.client.Write(ctx, &labeled)
+}
+
+// mergeLabels returns the sorted labels with the sorted extra labels added,
+// the extra labels winning over the labels with the same name.
+func mergeLabels(labels, extra []mimirpb.LabelAdapter) []mimirpb.LabelAdapter {
+	merged := make([]mimirpb.LabelAdapter, 0, len(labels)+len(extra))
+	i, j := 0, 0
+	for i < len(labels) || j < len(extra) {
+		switch {
+		case j == len(extra) || i < len(labels) && labels[i].Name < extra[j].Name:
+			merged = append(merged, labels[i])
+			i++
+		case i == len(labels) || extra[j].Name < labels[i].Name:
+			merged = append(merged, extra[j])
+			j++
+		default:
+			merged = append(merged, extra[j])
+			i++
+			j++
+		}
+	}
+	return merged
+}
@@ -0,0 +1,149 @@
+package remotewrite
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+)
+
+func TestHAReplicaClient(t *testing.T) {
+	cfg := HAReplicaConfig{Cluster: "graphite-write", Replica: "replica-1", ClusterLabel: "cluster", ReplicaLabel: "__replica__"}
+	require.NoError(t, cfg.Validate())
+
+	client := &remotewritemock.Client{}
+	defer client.AssertExpectations(t)
+	client.On("Write", mock.Anything, mock.Anything).Return(nil).Once().Run(func(args mock.Arguments) {
+		var got [][]mimirpb.LabelAdapter
+		for _, ts := range args.Get(1).(*mimirpb.WriteRequest).Timeseries {
+			got = append(got, ts.Labels)
+		}
+		require.Equal(t, [][]mimirpb.LabelAdapter{
+			{{Name: "__name__", Value: "a"}, {Name: "__replica__", Value: "replica-1"}, {Name: "cluster", Value: "graphite-write"}, {Name: "job", Value: "x"}},
+			// The HA labels the series already have are replaced.
+			{{Name: "__name__", Value: "b"}, {Name: "__replica__", Value: "replica-1"}, {Name: "cluster", Value: "graphite-write"}},
+		}, got)
+	})
+
+	c, err := NewHAReplicaClient(client, cfg)
+	require.NoError(t, err)
+	require.NoError(t, c.Write(context.Background(), &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{
+		{TimeSeries: &mimirpb.TimeSeries{
+			Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "a"}, {Name: "job", Value: "x"}},
+			Samples: []mimirpb.Sample{{TimestampMs: 1, Value: 1}},
+		}},
+		{TimeSeries: &mimirpb.TimeSeries{
+			Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "b"}, {Name: "__replica__", Value: "replica-0"}, {Name: "cluster", Value: "other"}},
+			Samples: []mimirpb.Sample{{TimestampMs: 1, Value: 1}},
+		}},
+	}}))
+
+	t.Run("disabled", func(t *testing.T) {
+		c, err := NewHAReplicaClient(client, HAReplicaConfig{})
+		require.NoError(t, err)
+		require.Same(t, client, c)
+	})
+}
+
+func TestHAReplicaConfig_replica(t *testing.T) {
+	cfg := HAReplicaConfig{Cluster: "graphite-write", ReplicaFromPodName: true, ClusterLabel: "cluster", ReplicaLabel: "__replica__"}
+	require.NoError(t, cfg.Validate())
+
+	t.Run("pod name", func(t *testing.T) {
+		t.Setenv("POD_NAME", "graphite-write-0")
+		replica, err := cfg.replica()
+		require.NoError(t, err)
+		require.Equal(t, "graphite-write-0", replica)
+	})
+
+	t.Run("host name without pod name", func(t *testing.T) {
+		t.Setenv("POD_NAME", "")
+		hostname, err := os.Hostname()
+		require.NoError(t, err)
+		replica, err := cfg.replica()
+		require.NoError(t, err)
+		require.Equal(t, hostname, replica)
+	})
+
+	t.Run("configured replica", func(t *testing.T) {
+		t.Setenv("POD_NAME", "graphite-write-0")
+		cfg := cfg
+		cfg.ReplicaFromPodName, cfg.Replica = false, "replica-1"
+		replica, err := cfg.replica()
+		require.NoError(t, err)
+		require.Equal(t, "replica-1", replica)
+	})
+}
+
+func TestHAReplicaConfigValidate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cfg     HAReplicaConfig
+		wantErr string
+	}{
+		"disabled": {
+			cfg: HAReplicaConfig{Replica: "ignored"},
+		},
+		"missing replica": {
+			cfg:     HAReplicaConfig{Cluster: "graphite-write", ClusterLabel: "cluster", ReplicaLabel: "__replica__"},
+			wantErr: "ha replica must be set when the ha cluster is",
+		},
+		"empty label": {
+			cfg:     HAReplicaConfig{Cluster: "graphite-write", Replica: "replica-1", ClusterLabel: "cluster"},
+			wantErr: "ha cluster and replica labels can't be empty",
+		},
+		"same labels": {
+			cfg:     HAReplicaConfig{Cluster: "graphite-write", Replica: "replica-1", ClusterLabel: "cluster", ReplicaLabel: "cluster"},
+			wantErr: "ha cluster and replica labels must be different",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestMergeLabels(t *testing.T) {
+	label := func(name, value string) mimirpb.LabelAdapter {
+		return mimirpb.LabelAdapter{Name: name, Value: value}
+	}
+	extra := []mimirpb.LabelAdapter{label("b", "extra"), label("d", "extra")}
+
+	for name, tc := range map[string]struct {
+		labels []mimirpb.LabelAdapter
+		want   []mimirpb.LabelAdapter
+	}{
+		"no labels": {
+			want: extra,
+		},
+		"interleaved": {
+			labels: []mimirpb.LabelAdapter{label("a", "1"), label("c", "1"), label("e", "1")},
+			want:   []mimirpb.LabelAdapter{label("a", "1"), label("b", "extra"), label("c", "1"), label("d", "extra"), label("e", "1")},
+		},
+		"all before": {
+			labels: []mimirpb.LabelAdapter{label("a", "1")},
+			want:   []mimirpb.LabelAdapter{label("a", "1"), label("b", "extra"), label("d", "extra")},
+		},
+		"all after": {
+			labels: []mimirpb.LabelAdapter{label("e", "1")},
+			want:   []mimirpb.LabelAdapter{label("b", "extra"), label("d", "extra"), label("e", "1")},
+		},
+		"extra labels win": {
+			labels: []mimirpb.LabelAdapter{label("b", "1"), label("d", "1")},
+			want:   extra,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.want, mergeLabels(tc.labels, extra))
+		})
+	}
+}
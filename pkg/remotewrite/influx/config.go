@@ -0,0 +1,65 @@
+package influx
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// NamingScheme controls how Influx measurements and fields are mapped to
+// Prometheus series.
+type NamingScheme string
+
+const (
+	// NamingSchemeUnderscore names series "<measurement>_<field>", or just
+	// "<measurement>" for the "value" field, like Mimir's own Influx endpoint.
+	NamingSchemeUnderscore NamingScheme = "underscore"
+	// NamingSchemeFieldLabel names series "<measurement>" and puts the field
+	// name in the "field" label.
+	NamingSchemeFieldLabel NamingScheme = "field-label"
+	// NamingSchemeGraphite maps points to Graphite series named
+	// "<measurement>.<field>", or just "<measurement>" for the "value" field,
+	// with the Influx tags as Graphite tags, so they can be queried alongside
+	// the metrics written by the Graphite write proxy.
+	NamingSchemeGraphite NamingScheme = "graphite"
+
+	// FieldLabel is the label holding the field name with
+	// NamingSchemeFieldLabel.
+	FieldLabel = "field"
+
+	defaultMaxRequestSize = 10 * 1024 * 1024
+)
+
+type Config struct {
+	NamingScheme   string `yaml:"naming_scheme"`
+	MaxRequestSize int    `yaml:"max_request_size"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *Config) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *Config) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.StringVar(&c.NamingScheme, prefix+"influx.naming-scheme", string(NamingSchemeUnderscore), fmt.Sprintf("How Influx measurements and fields are mapped to series names. One of %q, %q or %q.", NamingSchemeUnderscore, NamingSchemeFieldLabel, NamingSchemeGraphite))
+	flags.IntVar(&c.MaxRequestSize, prefix+"influx.max-request-size", defaultMaxRequestSize, "Max size in bytes of an uncompressed Influx write request.")
+}
+
+// Validate checks the naming scheme and request size limit.
+func (c *Config) Validate() error {
+	switch NamingScheme(c.NamingScheme) {
+	case NamingSchemeUnderscore, NamingSchemeFieldLabel, NamingSchemeGraphite:
+	default:
+		return fmt.Errorf("unknown influx naming scheme %q", c.NamingScheme)
+	}
+	if c.MaxRequestSize <= 0 {
+		return fmt.Errorf("influx max request size must be positive")
+	}
+	return nil
+}
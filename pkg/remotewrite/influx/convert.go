@@ -0,0 +1,98 @@
+// Package influx implements an HTTP handler accepting the InfluxDB v2 line
+// protocol write API, and writing the points through a remotewrite.Client.
+package influx
+
+import (
+	"fmt"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/influxdata/influxdb/v2/models"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/graphite/writeproxy"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite"
+)
+
+// valueField is the field name that isn't appended to the series name.
+const valueField = "value"
+
+// PointsToTimeseries converts Influx points to series using the given naming
+// scheme. Each numeric or boolean field of a point becomes its own series;
+// string fields are skipped.
+func PointsToTimeseries(points []models.Point, scheme NamingScheme) ([]mimirpb.PreallocTimeseries, error) {
+	series := make([]mimirpb.PreallocTimeseries, 0, len(points))
+	builder := labels.NewBuilder(nil)
+
+	for _, pt := range points {
+		fields, err := pt.Fields()
+		if err != nil {
+			return nil, fmt.Errorf("can't get fields of point %q: %w", pt.Name(), err)
+		}
+		for field, v := range fields {
+			value, ok := fieldValue(v)
+			if !ok {
+				continue
+			}
+			lbls, err := seriesLabels(string(pt.Name()), field, pt.Tags(), scheme, builder)
+			if err != nil {
+				return nil, err
+			}
+			series = append(series, mimirpb.PreallocTimeseries{TimeSeries: &mimirpb.TimeSeries{
+				Labels:  mimirpb.FromLabelsToLabelAdapters(lbls),
+				Samples: []mimirpb.Sample{{TimestampMs: pt.Time().UnixMilli(), Value: value}},
+			}})
+		}
+	}
+	return series, nil
+}
+
+func seriesLabels(measurement, field string, tags models.Tags, scheme NamingScheme, builder *labels.Builder) (labels.Labels, error) {
+	if scheme == NamingSchemeGraphite {
+		name := measurement
+		if field != valueField {
+			name += "." + field
+		}
+		if len(tags) == 0 {
+			return writeproxy.LabelsFromUntaggedName(name, builder), nil
+		}
+		graphiteTags := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			graphiteTags = append(graphiteTags, string(tag.Key)+"="+string(tag.Value))
+		}
+		return writeproxy.LabelsFromTaggedName(name, graphiteTags, builder)
+	}
+
+	builder.Reset(nil)
+	for _, tag := range tags {
+		if key := remotewrite.SanitizeName(string(tag.Key)); key != labels.MetricName {
+			builder.Set(key, string(tag.Value))
+		}
+	}
+	name := remotewrite.SanitizeName(measurement)
+	switch {
+	case scheme == NamingSchemeFieldLabel:
+		builder.Set(FieldLabel, field)
+	case field != valueField:
+		name += "_" + remotewrite.SanitizeName(field)
+	}
+	builder.Set(labels.MetricName, name)
+	return builder.Labels(), nil
+}
+
+func fieldValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
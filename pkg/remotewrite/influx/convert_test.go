@@ -0,0 +1,74 @@
+package influx
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/influxdata/influxdb/v2/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPointsToTimeseries(t *testing.T) {
+	points, err := models.ParsePointsWithPrecision([]byte(
+		"cpu,host=a,region=eu-west value=0.5,idle=90i,up=true,note=\"skipped\" 1700000000000000000\n"+
+			"disk.io,host=a read=12u 1700000000000000000\n",
+	), time.Now(), "ns")
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		scheme NamingScheme
+		want   []string
+	}{
+		"underscore": {
+			scheme: NamingSchemeUnderscore,
+			want: []string{
+				`{__name__="cpu", host="a", region="eu-west"} 0.5`,
+				`{__name__="cpu_idle", host="a", region="eu-west"} 90`,
+				`{__name__="cpu_up", host="a", region="eu-west"} 1`,
+				`{__name__="disk_io_read", host="a"} 12`,
+			},
+		},
+		"field label": {
+			scheme: NamingSchemeFieldLabel,
+			want: []string{
+				`{__name__="cpu", field="idle", host="a", region="eu-west"} 90`,
+				`{__name__="cpu", field="up", host="a", region="eu-west"} 1`,
+				`{__name__="cpu", field="value", host="a", region="eu-west"} 0.5`,
+				`{__name__="disk_io", field="read", host="a"} 12`,
+			},
+		},
+		"graphite": {
+			scheme: NamingSchemeGraphite,
+			want: []string{
+				`{__name__="graphite_tagged", host="a", name="cpu", region="eu-west"} 0.5`,
+				`{__name__="graphite_tagged", host="a", name="cpu.idle", region="eu-west"} 90`,
+				`{__name__="graphite_tagged", host="a", name="cpu.up", region="eu-west"} 1`,
+				`{__name__="graphite_tagged", host="a", name="disk.io.read"} 12`,
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			series, err := PointsToTimeseries(points, tc.scheme)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, formatSeries(t, series))
+		})
+	}
+}
+
+func formatSeries(t *testing.T, series []mimirpb.PreallocTimeseries) []string {
+	out := make([]string, 0, len(series))
+	for _, s := range series {
+		require.Len(t, s.Samples, 1)
+		require.Equal(t, int64(1700000000000), s.Samples[0].TimestampMs)
+		out = append(out, mimirpb.FromLabelAdaptersToLabels(s.Labels).String()+" "+formatFloat(s.Samples[0].Value))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,94 @@
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/grafana/mimir/pkg/util/spanlogger"
+	"github.com/influxdata/influxdb/v2/models"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	graphiteAuth "github.com/grafana/mimir-graphite/v2/pkg/graphite/authentication"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite"
+	"github.com/grafana/mimir-graphite/v2/pkg/route"
+)
+
+// WritePath is the path of the InfluxDB v2 write API.
+const WritePath = "/api/v2/write"
+
+// Handler accepts InfluxDB v2 line protocol writes and forwards them to the
+// remote write client. It expects the tenant to be set in the request context
+// by the app's auth middleware.
+type Handler struct {
+	cfg    Config
+	client remotewrite.Client
+	logger log.Logger
+}
+
+func NewHandler(cfg Config, client remotewrite.Client, logger log.Logger) (*Handler, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Handler{cfg: cfg, client: client, logger: logger}, nil
+}
+
+// RegisterRoutes registers the handler on the InfluxDB v2 write path.
+func (h *Handler) RegisterRoutes(r route.Registerer) {
+	r.RegisterRoute(WritePath, h, http.MethodPost)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log, ctx := spanlogger.NewWithLogger(r.Context(), h.logger, "influx.Handler.ServeHTTP")
+	defer log.Finish()
+	ctx, userID := graphiteAuth.ExtractOrgID(ctx)
+
+	precision := r.URL.Query().Get("precision")
+	if precision == "" {
+		precision = "ns"
+	}
+	if !models.ValidPrecision(precision) {
+		http.Error(w, fmt.Sprintf("invalid precision %q", precision), http.StatusBadRequest)
+		return
+	}
+
+	body, err := remotewrite.ReadBody(r, int64(h.cfg.MaxRequestSize))
+	var tooLarge errorx.LimitExceeded
+	if errors.As(err, &tooLarge) {
+		level.Warn(log).Log("msg", "influx write request too large", "user", userID, "err", err)
+		http.Error(w, tooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		errorx.LogAndSetHTTPError(ctx, w, log, err)
+		return
+	}
+
+	points, err := models.ParsePointsWithPrecision(body, time.Now().UTC(), precision)
+	if err != nil {
+		level.Warn(log).Log("msg", "can't parse influx points", "user", userID, "err", err)
+		http.Error(w, fmt.Sprintf("can't parse points: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	series, err := PointsToTimeseries(points, NamingScheme(h.cfg.NamingScheme))
+	if err != nil {
+		level.Warn(log).Log("msg", "can't convert influx points", "user", userID, "err", err)
+		http.Error(w, fmt.Sprintf("can't convert points: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if len(series) > 0 {
+		if err := h.client.Write(ctx, &mimirpb.WriteRequest{Timeseries: series, Source: mimirpb.API}); err != nil {
+			errorx.LogAndSetHTTPError(ctx, w, log, err)
+			return
+		}
+	}
+
+	level.Debug(log).Log("msg", "successful influx write", "user", userID, "points", len(points), "series", len(series))
+	w.WriteHeader(http.StatusNoContent)
+}
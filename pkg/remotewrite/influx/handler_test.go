@@ -0,0 +1,113 @@
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+)
+
+func TestHandler(t *testing.T) {
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write([]byte(s))
+		_ = gz.Close()
+		return buf.Bytes()
+	}
+
+	for name, tc := range map[string]struct {
+		query       string
+		body        []byte
+		gzip        bool
+		writeErr    error
+		wantWrite   bool
+		wantStatus  int
+		wantSamples []mimirpb.Sample
+	}{
+		"writes points": {
+			query:       "?precision=s",
+			body:        []byte("cpu,host=a value=1 1700000000"),
+			wantWrite:   true,
+			wantStatus:  http.StatusNoContent,
+			wantSamples: []mimirpb.Sample{{TimestampMs: 1700000000000, Value: 1}},
+		},
+		"gzipped body": {
+			body:        gzipped("cpu,host=a value=2 1700000000000000000"),
+			gzip:        true,
+			wantWrite:   true,
+			wantStatus:  http.StatusNoContent,
+			wantSamples: []mimirpb.Sample{{TimestampMs: 1700000000000, Value: 2}},
+		},
+		"only string fields": {
+			body:       []byte(`cpu,host=a note="nothing to write" 1700000000000000000`),
+			wantStatus: http.StatusNoContent,
+		},
+		"invalid precision": {
+			query:      "?precision=days",
+			body:       []byte("cpu value=1"),
+			wantStatus: http.StatusBadRequest,
+		},
+		"invalid line": {
+			body:       []byte("cpu"),
+			wantStatus: http.StatusBadRequest,
+		},
+		"too large": {
+			body:       []byte("cpu,host=a value=1 1700000000000000000\n" + strings.Repeat("#", 100)),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		"too large once decompressed": {
+			body:       gzipped("cpu,host=a value=1 1700000000000000000\n" + strings.Repeat("#", 100)),
+			gzip:       true,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		"downstream error": {
+			body:       []byte("cpu,host=a value=1 1700000000000000000"),
+			writeErr:   errorx.TooManyRequests{Msg: "slow down"},
+			wantWrite:  true,
+			wantStatus: http.StatusTooManyRequests,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &remotewritemock.Client{}
+			defer client.AssertExpectations(t)
+			if tc.wantWrite {
+				client.On("Write", mock.Anything, mock.Anything).Return(tc.writeErr).Run(func(args mock.Arguments) {
+					tenant, err := user.ExtractOrgID(args.Get(0).(context.Context))
+					require.NoError(t, err)
+					require.Equal(t, "tenant-1", tenant)
+					if tc.wantSamples != nil {
+						req := args.Get(1).(*mimirpb.WriteRequest)
+						require.Len(t, req.Timeseries, 1)
+						require.Equal(t, tc.wantSamples, req.Timeseries[0].Samples)
+					}
+				})
+			}
+
+			h, err := NewHandler(Config{NamingScheme: string(NamingSchemeUnderscore), MaxRequestSize: 100}, client, log.NewNopLogger())
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, WritePath+tc.query, bytes.NewReader(tc.body))
+			if tc.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			req = req.WithContext(user.InjectOrgID(req.Context(), "tenant-1"))
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, req)
+
+			require.Equal(t, tc.wantStatus, resp.Code, resp.Body.String())
+		})
+	}
+}
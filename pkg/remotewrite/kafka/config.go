@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/influxdata/influxdb/v2/models"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/influx"
+)
+
+// The payload formats of the records.
+const (
+	// FormatCarbon is lines of the carbon plaintext protocol.
+	FormatCarbon = "carbon"
+	// FormatInflux is the Influx line protocol.
+	FormatInflux = "influx"
+	// FormatRemoteWrite is a snappy compressed remote write protobuf
+	// request, like the body of the remote write HTTP API.
+	FormatRemoteWrite = "remote-write"
+
+	// TenantHeader is the record header holding the tenant of the record,
+	// which overrides the configured tenant.
+	TenantHeader = "X-Scope-OrgID"
+)
+
+type Config struct {
+	Brokers       flagext.StringSliceCSV `yaml:"brokers"`
+	Topic         string                 `yaml:"topic"`
+	ConsumerGroup string                 `yaml:"consumer_group"`
+	Format        string                 `yaml:"format"`
+	// Tenant, if set, is injected in the context of the remote writes of
+	// the records without a tenant header. The records without either are
+	// skipped and counted with the no_tenant outcome.
+	Tenant             string        `yaml:"tenant"`
+	InfluxNamingScheme string        `yaml:"influx_naming_scheme"`
+	InfluxPrecision    string        `yaml:"influx_precision"`
+	MaxPollRecords     int           `yaml:"max_poll_records"`
+	MinBackoff         time.Duration `yaml:"min_backoff"`
+	MaxBackoff         time.Duration `yaml:"max_backoff"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *Config) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *Config) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.Var(&c.Brokers, prefix+"kafka.brokers", "Comma separated list of the Kafka brokers to consume metrics from.")
+	flags.StringVar(&c.Topic, prefix+"kafka.topic", "metrics", "Kafka topic to consume metrics from.")
+	flags.StringVar(&c.ConsumerGroup, prefix+"kafka.consumer-group", "mimir-graphite", "Kafka consumer group sharing out the partitions of the topic between the replicas.")
+	flags.StringVar(&c.Format, prefix+"kafka.format", FormatCarbon, fmt.Sprintf("Payload format of the records. One of %q, %q or %q.", FormatCarbon, FormatInflux, FormatRemoteWrite))
+	flags.StringVar(&c.Tenant, prefix+"kafka.tenant", "", "Tenant the metrics are written for, unless the record has a "+TenantHeader+" header. If empty, the records without the header are skipped.")
+	flags.StringVar(&c.InfluxNamingScheme, prefix+"kafka.influx-naming-scheme", string(influx.NamingSchemeUnderscore), fmt.Sprintf("How Influx measurements and fields are mapped to series names, with the influx format. One of %q, %q or %q.", influx.NamingSchemeUnderscore, influx.NamingSchemeFieldLabel, influx.NamingSchemeGraphite))
+	flags.StringVar(&c.InfluxPrecision, prefix+"kafka.influx-precision", "ns", "Precision of the timestamps of the Influx points, with the influx format: ns, us, ms or s.")
+	flags.IntVar(&c.MaxPollRecords, prefix+"kafka.max-poll-records", 1000, "Max number of records consumed at a time. Their offsets are committed once they've all been written.")
+	flags.DurationVar(&c.MinBackoff, prefix+"kafka.min-backoff", 100*time.Millisecond, "Min time to wait before retrying a failed write.")
+	flags.DurationVar(&c.MaxBackoff, prefix+"kafka.max-backoff", 10*time.Second, "Max time to wait before retrying a failed write. Failed writes are retried until they succeed, to keep the order of the records of a partition.")
+}
+
+// Validate checks the brokers, topic and group are set and the format is
+// known.
+func (c *Config) Validate() error {
+	if len(c.Brokers) == 0 {
+		return errors.New("at least one kafka broker must be set")
+	}
+	if c.Topic == "" || c.ConsumerGroup == "" {
+		return errors.New("kafka topic and consumer group can't be empty")
+	}
+	switch c.Format {
+	case FormatCarbon, FormatRemoteWrite:
+	case FormatInflux:
+		switch influx.NamingScheme(c.InfluxNamingScheme) {
+		case influx.NamingSchemeUnderscore, influx.NamingSchemeFieldLabel, influx.NamingSchemeGraphite:
+		default:
+			return fmt.Errorf("unknown kafka influx naming scheme %q", c.InfluxNamingScheme)
+		}
+		if !models.ValidPrecision(c.InfluxPrecision) {
+			return fmt.Errorf("invalid kafka influx precision %q", c.InfluxPrecision)
+		}
+	default:
+		return fmt.Errorf("unknown kafka format %q", c.Format)
+	}
+	if c.MaxPollRecords <= 0 {
+		return errors.New("kafka max poll records must be positive")
+	}
+	if c.MinBackoff <= 0 || c.MaxBackoff < c.MinBackoff {
+		return errors.New("kafka min backoff must be positive and not above the max backoff")
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// Package kafka implements a Kafka consumer writing the metrics of the
+// records of a topic through a remotewrite.Client, for metrics that already
+// transit Kafka.
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite"
+)
+
+// Consumer consumes the records of a topic as a member of a consumer group,
+// and writes their metrics through a remotewrite.Client.
+//
+// The records of each partition are written in order, and the partitions in
+// parallel. The offsets are only committed once the records were delivered,
+// or dropped for good, eg. because Mimir rejected them: failed writes are
+// retried until they succeed or the consumer stops, in which case the
+// records are consumed again by the next owner of their partition. The
+// metrics are so written at least once.
+type Consumer struct {
+	cfg      Config
+	kafka    *kgo.Client
+	client   remotewrite.Client
+	decode   decoder
+	recorder Recorder
+	logger   log.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewConsumer returns a Consumer joining the consumer group once Run is
+// called.
+func NewConsumer(cfg Config, client remotewrite.Client, recorder Recorder, logger log.Logger) (*Consumer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	decode, err := newDecoder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	kafka, err := kgo.NewClient(
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.ConsumerGroup(cfg.ConsumerGroup),
+		kgo.ConsumeTopics(cfg.Topic),
+		kgo.DisableAutoCommit(),
+		// The partitions aren't revoked while the polled records are written,
+		// so their offsets are committed by the member that wrote them.
+		kgo.BlockRebalanceOnPoll(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't create kafka client: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Consumer{
+		cfg:      cfg,
+		kafka:    kafka,
+		client:   client,
+		decode:   decode,
+		recorder: recorder,
+		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// Handler returns two functions to run and stop the consumer.
+func (c *Consumer) Handler() (run func() error, stop func(error)) {
+	return c.Run, c.Stop
+}
+
+// Run consumes records until Stop is called, and leaves the consumer group
+// before returning.
+func (c *Consumer) Run() error {
+	defer c.kafka.Close()
+	for {
+		fetches := c.kafka.PollRecords(c.ctx, c.cfg.MaxPollRecords)
+		if c.ctx.Err() != nil {
+			c.kafka.AllowRebalance()
+			return nil
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			level.Error(c.logger).Log("msg", "can't fetch kafka records", "topic", topic, "partition", partition, "err", err)
+		})
+
+		var (
+			wg      sync.WaitGroup
+			mtx     sync.Mutex
+			written []*kgo.Record
+		)
+		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+			if len(p.Records) == 0 {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if last := c.writePartition(p.Records); last != nil {
+					mtx.Lock()
+					written = append(written, last)
+					mtx.Unlock()
+				}
+			}()
+		})
+		wg.Wait()
+
+		if len(written) > 0 {
+			if err := c.kafka.CommitRecords(context.Background(), written...); err != nil {
+				level.Error(c.logger).Log("msg", "can't commit kafka offsets", "err", err)
+			}
+		}
+		c.kafka.AllowRebalance()
+	}
+}
+
+// Stop stops consuming. The offsets of the records being written aren't
+// committed.
+func (c *Consumer) Stop(_ error) {
+	c.cancel()
+}
+
+// writePartition writes the records of a partition in order, and returns
+// the last one done with, or nil if none was.
+func (c *Consumer) writePartition(records []*kgo.Record) *kgo.Record {
+	var last *kgo.Record
+	for _, r := range records {
+		if !c.write(r) {
+			break
+		}
+		last = r
+	}
+	return last
+}
+
+// write writes the metrics of a record, retrying until the write isn't
+// failed. It returns false if the consumer stopped before that.
+func (c *Consumer) write(r *kgo.Record) bool {
+	series, err := c.decode(r.Value, time.Now())
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "can't decode kafka record", "partition", r.Partition, "offset", r.Offset, "err", err)
+		c.recorder.measureRecord(outcomeInvalid)
+		return true
+	}
+	if len(series) == 0 {
+		c.recorder.measureRecord(outcomeDelivered)
+		return true
+	}
+
+	tenant := c.tenant(r)
+	if tenant == "" {
+		level.Warn(c.logger).Log("msg", "kafka record has no tenant, set the "+TenantHeader+" header or kafka.tenant", "partition", r.Partition, "offset", r.Offset)
+		c.recorder.measureRecord(outcomeNoTenant)
+		return true
+	}
+	ctx := user.InjectOrgID(c.ctx, tenant)
+	req := &mimirpb.WriteRequest{Timeseries: series, Source: mimirpb.API}
+	retries := backoff.New(c.ctx, backoff.Config{MinBackoff: c.cfg.MinBackoff, MaxBackoff: c.cfg.MaxBackoff})
+	for retries.Ongoing() {
+		outcome := outcomeDelivered
+		err := remotewrite.WriteWithDeliveryHooks(ctx, c.client, req, remotewrite.DeliveryHooks{
+			OnDropped: func(reason string, _ error) { outcome = reason },
+		})
+		if outcome != remotewrite.DropReasonFailed {
+			if err != nil {
+				level.Warn(c.logger).Log("msg", "kafka record rejected", "partition", r.Partition, "offset", r.Offset, "err", err)
+			}
+			c.recorder.measureRecord(outcome)
+			return true
+		}
+		if c.ctx.Err() != nil {
+			return false
+		}
+		level.Warn(c.logger).Log("msg", "can't write kafka record, retrying", "partition", r.Partition, "offset", r.Offset, "attempt", retries.NumRetries()+1, "err", err)
+		c.recorder.measureRetry()
+		retries.Wait()
+	}
+	return false
+}
+
+// tenant returns the tenant of the record, from its header or the config, or
+// "" if it has none.
+func (c *Consumer) tenant(r *kgo.Record) string {
+	for _, h := range r.Headers {
+		if h.Key == TenantHeader && len(h.Value) > 0 {
+			return string(h.Value)
+		}
+	}
+	return c.cfg.Tenant
+}
@@ -0,0 +1,129 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+)
+
+type write struct {
+	tenant string
+	metric string
+}
+
+func TestConsumer(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "metrics"))
+	require.NoError(t, err)
+	t.Cleanup(cluster.Close)
+
+	producer, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.DefaultProduceTopic("metrics"))
+	require.NoError(t, err)
+	t.Cleanup(producer.Close)
+	produce := func(value string, headers ...kgo.RecordHeader) {
+		require.NoError(t, producer.ProduceSync(context.Background(), &kgo.Record{Value: []byte(value), Headers: headers}).FirstErr())
+	}
+
+	writes := make(chan write, 10)
+	failures := map[string]error{"retried": errorx.TooManyRequests{Msg: "rate limited"}}
+	client := &remotewritemock.Client{}
+	client.On("Write", mock.Anything, mock.Anything).Return(func(ctx context.Context, req *mimirpb.WriteRequest) error {
+		tenant, _ := user.ExtractOrgID(ctx)
+		metric := mimirpb.FromLabelAdaptersToLabels(req.Timeseries[0].Labels).Get("__n000__")
+		writes <- write{tenant: tenant, metric: metric}
+		if err, ok := failures[metric]; ok {
+			delete(failures, metric)
+			return err
+		}
+		if metric == "rejected" {
+			return errorx.BadRequest{Msg: "out of bounds"}
+		}
+		return nil
+	})
+
+	cfg := Config{
+		Brokers:            cluster.ListenAddrs(),
+		Topic:              "metrics",
+		ConsumerGroup:      "group",
+		Format:             FormatCarbon,
+		Tenant:             "tenant-1",
+		InfluxNamingScheme: "underscore",
+		InfluxPrecision:    "ns",
+		MaxPollRecords:     100,
+		MinBackoff:         time.Millisecond,
+		MaxBackoff:         time.Millisecond,
+	}
+	run := func(recorder Recorder) func() {
+		c, err := NewConsumer(cfg, client, recorder, log.NewNopLogger())
+		require.NoError(t, err)
+		done := make(chan error)
+		go func() { done <- c.Run() }()
+		return func() {
+			c.Stop(nil)
+			select {
+			case err := <-done:
+				require.NoError(t, err)
+			case <-time.After(10 * time.Second):
+				t.Fatal("consumer didn't stop")
+			}
+		}
+	}
+	next := func() write {
+		select {
+		case w := <-writes:
+			return w
+		case <-time.After(30 * time.Second):
+			t.Fatal("record wasn't written")
+			return write{}
+		}
+	}
+
+	produce("first.metric 1 100", kgo.RecordHeader{Key: TenantHeader, Value: []byte("tenant-2")})
+	produce("not a metric")
+	produce("retried.metric 1 100")
+	produce("rejected.metric 1 100")
+
+	measured := make(chan struct{}, 4)
+	recorder := NewMockRecorder(t)
+	recorder.On("measureRecord", outcomeDelivered).Return().Twice().Run(func(mock.Arguments) { measured <- struct{}{} })
+	recorder.On("measureRecord", outcomeInvalid).Return().Once().Run(func(mock.Arguments) { measured <- struct{}{} })
+	recorder.On("measureRecord", "rejected").Return().Once().Run(func(mock.Arguments) { measured <- struct{}{} })
+	recorder.On("measureRetry").Return().Once()
+	stop := run(recorder)
+	require.Equal(t, write{tenant: "tenant-2", metric: "first"}, next())
+	require.Equal(t, write{tenant: "tenant-1", metric: "retried"}, next())
+	require.Equal(t, write{tenant: "tenant-1", metric: "retried"}, next())
+	require.Equal(t, write{tenant: "tenant-1", metric: "rejected"}, next())
+	for i := 0; i < 4; i++ {
+		select {
+		case <-measured:
+		case <-time.After(10 * time.Second):
+			t.Fatal("record wasn't measured")
+		}
+	}
+	stop()
+
+	// The offsets were committed, so the next member of the group only
+	// consumes the new records. Without a tenant configured, the records
+	// without a tenant header are skipped.
+	cfg.Tenant = ""
+	produce("orphan.metric 1 100")
+	produce("last.metric 1 100", kgo.RecordHeader{Key: TenantHeader, Value: []byte("tenant-1")})
+	recorder = NewMockRecorder(t)
+	recorder.On("measureRecord", outcomeNoTenant).Return().Once()
+	recorder.On("measureRecord", outcomeDelivered).Return().Once()
+	stop = run(recorder)
+	require.Equal(t, write{tenant: "tenant-1", metric: "last"}, next())
+	stop()
+	require.Empty(t, writes)
+}
@@ -0,0 +1,79 @@
+package kafka
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/influxdata/influxdb/v2/models"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/carbon"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/influx"
+)
+
+// decoder decodes the value of a record to series. A record is decoded as a
+// whole: it's rejected if any of its metrics can't be decoded.
+type decoder func(value []byte, now time.Time) ([]mimirpb.PreallocTimeseries, error)
+
+func newDecoder(cfg Config) (decoder, error) {
+	switch cfg.Format {
+	case FormatCarbon:
+		return decodeCarbon, nil
+	case FormatInflux:
+		return func(value []byte, now time.Time) ([]mimirpb.PreallocTimeseries, error) {
+			return decodeInflux(value, now, cfg.InfluxPrecision, influx.NamingScheme(cfg.InfluxNamingScheme))
+		}, nil
+	case FormatRemoteWrite:
+		return decodeRemoteWrite, nil
+	default:
+		return nil, fmt.Errorf("unknown kafka format %q", cfg.Format)
+	}
+}
+
+// decodeCarbon decodes lines of the carbon plaintext protocol, with the same
+// labels as the carbon listeners.
+func decodeCarbon(value []byte, now time.Time) ([]mimirpb.PreallocTimeseries, error) {
+	var series []mimirpb.PreallocTimeseries
+	builder := labels.NewBuilder(labels.EmptyLabels())
+	for _, line := range strings.Split(string(value), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := carbon.ParseLine(line, now)
+		if err != nil {
+			return nil, err
+		}
+		lbls, err := p.Labels(builder)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, mimirpb.PreallocTimeseries{TimeSeries: &mimirpb.TimeSeries{
+			Labels:  mimirpb.FromLabelsToLabelAdapters(lbls),
+			Samples: []mimirpb.Sample{{TimestampMs: p.TimestampMs, Value: p.Value}},
+		}})
+	}
+	return series, nil
+}
+
+func decodeInflux(value []byte, now time.Time, precision string, scheme influx.NamingScheme) ([]mimirpb.PreallocTimeseries, error) {
+	points, err := models.ParsePointsWithPrecision(value, now.UTC(), precision)
+	if err != nil {
+		return nil, err
+	}
+	return influx.PointsToTimeseries(points, scheme)
+}
+
+func decodeRemoteWrite(value []byte, _ time.Time) ([]mimirpb.PreallocTimeseries, error) {
+	data, err := snappy.Decode(nil, value)
+	if err != nil {
+		return nil, fmt.Errorf("can't decompress remote write request: %w", err)
+	}
+	var req mimirpb.WriteRequest
+	if err := req.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("can't unmarshal remote write request: %w", err)
+	}
+	return req.Timeseries, nil
+}
@@ -0,0 +1,84 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/influx"
+)
+
+func TestDecoders(t *testing.T) {
+	now := time.UnixMilli(5_000)
+	rw := mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{{TimeSeries: &mimirpb.TimeSeries{
+		Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+		Samples: []mimirpb.Sample{{TimestampMs: 1_000, Value: 1}},
+	}}}}
+	rwData, err := rw.Marshal()
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		format string
+		value  []byte
+		want   []mimirpb.LabelAdapter
+		wantTs int64
+		err    bool
+	}{
+		"carbon": {
+			format: FormatCarbon,
+			value:  []byte("some.metric 1 2\n\n"),
+			want:   []mimirpb.LabelAdapter{{Name: "__n000__", Value: "some"}, {Name: "__n001__", Value: "metric"}, {Name: "__name__", Value: "graphite_untagged"}},
+			wantTs: 2_000,
+		},
+		"carbon without timestamp": {
+			format: FormatCarbon,
+			value:  []byte("some.metric;dc=eu 1"),
+			want:   []mimirpb.LabelAdapter{{Name: "__name__", Value: "graphite_tagged"}, {Name: "dc", Value: "eu"}, {Name: "name", Value: "some.metric"}},
+			wantTs: 5_000,
+		},
+		"invalid carbon": {
+			format: FormatCarbon,
+			value:  []byte("some.metric 1 2\nnot a metric"),
+			err:    true,
+		},
+		"influx": {
+			format: FormatInflux,
+			value:  []byte("cpu,host=a value=1 3000000000"),
+			want:   []mimirpb.LabelAdapter{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "a"}},
+			wantTs: 3_000,
+		},
+		"invalid influx": {
+			format: FormatInflux,
+			value:  []byte("cpu,host=a"),
+			err:    true,
+		},
+		"remote write": {
+			format: FormatRemoteWrite,
+			value:  snappy.Encode(nil, rwData),
+			want:   []mimirpb.LabelAdapter{{Name: "__name__", Value: "up"}},
+			wantTs: 1_000,
+		},
+		"uncompressed remote write": {
+			format: FormatRemoteWrite,
+			value:  rwData,
+			err:    true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			decode, err := newDecoder(Config{Format: tc.format, InfluxPrecision: "ns", InfluxNamingScheme: string(influx.NamingSchemeUnderscore)})
+			require.NoError(t, err)
+			series, err := decode(tc.value, now)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, series, 1)
+			require.Equal(t, tc.want, series[0].Labels)
+			require.Equal(t, tc.wantTs, series[0].Samples[0].TimestampMs)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package kafka
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The outcomes of the records that aren't a remotewrite drop reason.
+const (
+	outcomeDelivered = "delivered"
+	outcomeInvalid   = "invalid"
+	// outcomeNoTenant is a record without a tenant header, consumed with no
+	// tenant configured, which can't be written.
+	outcomeNoTenant = "no_tenant"
+)
+
+//go:generate mockery --inpackage --testonly --case underscore --name Recorder
+type Recorder interface {
+	measureRecord(outcome string)
+	measureRetry()
+}
+
+// NewRecorder returns a new Prometheus metrics Recorder.
+// It ensures that the Kafka consumer metrics are properly registered.
+func NewRecorder(prefix string, reg prometheus.Registerer) Recorder {
+	r := &prometheusRecorder{
+		records: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "kafka_consumed_records_total",
+			Help:      "The total number of Kafka records consumed, by outcome: delivered, invalid, no_tenant, or the reason the write was dropped.",
+		}, []string{"outcome"}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "kafka_write_retries_total",
+			Help:      "The total number of failed writes of Kafka records that were retried.",
+		}),
+	}
+
+	reg.MustRegister(r.records, r.retries)
+
+	return r
+}
+
+type prometheusRecorder struct {
+	records *prometheus.CounterVec
+	retries prometheus.Counter
+}
+
+func (r prometheusRecorder) measureRecord(outcome string) {
+	r.records.WithLabelValues(outcome).Inc()
+}
+
+func (r prometheusRecorder) measureRetry() {
+	r.retries.Inc()
+}
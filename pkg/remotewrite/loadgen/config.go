@@ -0,0 +1,96 @@
+package loadgen
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Value distributions of the generated samples.
+const (
+	DistributionConstant = "constant"
+	DistributionUniform  = "uniform"
+	DistributionNormal   = "normal"
+	DistributionCounter  = "counter"
+)
+
+var distributions = []string{DistributionConstant, DistributionUniform, DistributionNormal, DistributionCounter}
+
+type Config struct {
+	// Series is the number of active series, spread over Metrics metric
+	// names.
+	Series  int `yaml:"series"`
+	Metrics int `yaml:"metrics"`
+	// Interval is how often a sample of every active series is written.
+	Interval time.Duration `yaml:"interval"`
+	// BatchSize is the max number of series per write request.
+	BatchSize int `yaml:"batch_size"`
+	// ChurnPercent percent of the series are replaced by new ones every
+	// ChurnInterval. 0 disables churn.
+	ChurnPercent  float64       `yaml:"churn_percent"`
+	ChurnInterval time.Duration `yaml:"churn_interval"`
+	// Distribution is the distribution of the float sample values.
+	Distribution string `yaml:"distribution"`
+	// HistogramPercent percent of the metrics are native histograms.
+	HistogramPercent float64 `yaml:"histogram_percent"`
+	// Seed makes the generated values reproducible.
+	Seed int64 `yaml:"seed"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *Config) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+//
+//nolint:gomnd
+func (c *Config) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.IntVar(&c.Series, prefix+"loadgen.series", 1000, "Number of active series.")
+	flags.IntVar(&c.Metrics, prefix+"loadgen.metrics", 10, "Number of metric names the active series are spread over.")
+	flags.DurationVar(&c.Interval, prefix+"loadgen.interval", 15*time.Second, "How often a sample of every active series is written.")
+	flags.IntVar(&c.BatchSize, prefix+"loadgen.batch-size", 1000, "Max number of series per write request.")
+	flags.Float64Var(&c.ChurnPercent, prefix+"loadgen.churn-percent", 0, "Percentage of the active series replaced by new series every loadgen.churn-interval. 0 to disable churn.")
+	flags.DurationVar(&c.ChurnInterval, prefix+"loadgen.churn-interval", 10*time.Minute, "How often loadgen.churn-percent of the series are replaced.")
+	flags.StringVar(&c.Distribution, prefix+"loadgen.distribution", DistributionUniform, fmt.Sprintf("Distribution of the sample values, one of %s.", strings.Join(distributions, ", ")))
+	flags.Float64Var(&c.HistogramPercent, prefix+"loadgen.histogram-percent", 0, "Percentage of the metrics written as native histograms instead of floats.")
+	flags.Int64Var(&c.Seed, prefix+"loadgen.seed", 0, "Seed of the random values, for reproducible runs.")
+}
+
+// Validate checks that the generator can run with the config.
+func (c *Config) Validate() error {
+	if c.Series <= 0 {
+		return errors.New("series must be positive")
+	}
+	if c.Metrics <= 0 || c.Metrics > c.Series {
+		return errors.New("metrics must be positive and at most the number of series")
+	}
+	if c.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	if c.BatchSize <= 0 {
+		return errors.New("batch size must be positive")
+	}
+	if c.ChurnPercent < 0 || c.ChurnPercent > 100 {
+		return fmt.Errorf("churn percent %v must be between 0 and 100", c.ChurnPercent)
+	}
+	if c.ChurnPercent > 0 && c.ChurnInterval <= 0 {
+		return errors.New("churn interval must be positive")
+	}
+	if c.HistogramPercent < 0 || c.HistogramPercent > 100 {
+		return fmt.Errorf("histogram percent %v must be between 0 and 100", c.HistogramPercent)
+	}
+	for _, d := range distributions {
+		if c.Distribution == d {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown distribution %q, must be one of %s", c.Distribution, strings.Join(distributions, ", "))
+}
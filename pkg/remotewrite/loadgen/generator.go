@@ -0,0 +1,202 @@
+// Package loadgen generates synthetic series and writes them through a remote
+// write client, to soak test write proxies and Mimir tenants before
+// migrations.
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/prometheus/model/histogram"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite"
+)
+
+// histogramBuckets is the number of positive buckets of the generated native
+// histograms, at schema 0 covering observations from 0 to 2^histogramBuckets.
+const histogramBuckets = 8
+
+// Generator generates the samples of Config.Series active series, replacing
+// some of them with new series on Churn.
+type Generator struct {
+	cfg Config
+	rnd *rand.Rand
+
+	series []series
+	// churns is the number of times series were replaced, which tells apart
+	// the replacing series from the replaced ones.
+	churns int
+	// churnCursor is the next series to replace, so the oldest series are
+	// replaced first.
+	churnCursor int
+}
+
+type series struct {
+	labels    []mimirpb.LabelAdapter
+	histogram bool
+
+	// counter, buckets and sum are the cumulative values of counters and
+	// histograms.
+	counter float64
+	buckets [histogramBuckets]uint64
+	sum     float64
+}
+
+// NewGenerator creates a Generator for a valid cfg.
+func NewGenerator(cfg Config) *Generator {
+	g := &Generator{
+		cfg:    cfg,
+		rnd:    rand.New(rand.NewSource(cfg.Seed)), //nolint:gosec
+		series: make([]series, cfg.Series),
+	}
+	for i := range g.series {
+		g.series[i] = g.newSeries(i)
+	}
+	return g
+}
+
+func (g *Generator) newSeries(i int) series {
+	metric := i % g.cfg.Metrics
+	histogramMetrics := int(math.Round(float64(g.cfg.Metrics) * g.cfg.HistogramPercent / 100))
+	return series{
+		labels: []mimirpb.LabelAdapter{
+			{Name: "__name__", Value: fmt.Sprintf("loadgen_metric_%d", metric)},
+			{Name: "generation", Value: fmt.Sprint(g.churns)},
+			{Name: "series", Value: fmt.Sprint(i)},
+		},
+		histogram: metric < histogramMetrics,
+	}
+}
+
+// Churn replaces Config.ChurnPercent percent of the series with new series.
+func (g *Generator) Churn() {
+	n := int(math.Round(float64(len(g.series)) * g.cfg.ChurnPercent / 100))
+	if n == 0 {
+		return
+	}
+	g.churns++
+	for ; n > 0; n-- {
+		g.series[g.churnCursor] = g.newSeries(g.churnCursor)
+		g.churnCursor = (g.churnCursor + 1) % len(g.series)
+	}
+}
+
+// Next returns write requests of at most Config.BatchSize series with a
+// sample at ts of every active series.
+func (g *Generator) Next(ts time.Time) []*mimirpb.WriteRequest {
+	tsMs := ts.UnixMilli()
+	var reqs []*mimirpb.WriteRequest
+	for start := 0; start < len(g.series); start += g.cfg.BatchSize {
+		end := min(start+g.cfg.BatchSize, len(g.series))
+		req := &mimirpb.WriteRequest{
+			Timeseries: make([]mimirpb.PreallocTimeseries, 0, end-start),
+			Source:     mimirpb.API,
+		}
+		for i := start; i < end; i++ {
+			s := &g.series[i]
+			timeSeries := &mimirpb.TimeSeries{Labels: s.labels}
+			if s.histogram {
+				timeSeries.Histograms = []mimirpb.Histogram{mimirpb.FromHistogramToHistogramProto(tsMs, g.nextHistogram(s))}
+			} else {
+				timeSeries.Samples = []mimirpb.Sample{{TimestampMs: tsMs, Value: g.nextValue(s)}}
+			}
+			req.Timeseries = append(req.Timeseries, mimirpb.PreallocTimeseries{TimeSeries: timeSeries})
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+//nolint:gomnd
+func (g *Generator) nextValue(s *series) float64 {
+	switch g.cfg.Distribution {
+	case DistributionConstant:
+		return 1
+	case DistributionNormal:
+		return 50 + 10*g.rnd.NormFloat64()
+	case DistributionCounter:
+		s.counter += float64(g.rnd.Intn(100))
+		return s.counter
+	default:
+		return 100 * g.rnd.Float64()
+	}
+}
+
+// nextHistogram adds a few random observations to the histogram of s, and
+// returns it.
+func (g *Generator) nextHistogram(s *series) *histogram.Histogram {
+	for n := g.rnd.Intn(10); n > 0; n-- { //nolint:gomnd
+		bucket := g.rnd.Intn(histogramBuckets)
+		s.buckets[bucket]++
+		s.sum += math.Pow(2, float64(bucket)) * g.rnd.Float64()
+	}
+
+	h := &histogram.Histogram{
+		Schema:          0,
+		Sum:             s.sum,
+		PositiveSpans:   []histogram.Span{{Offset: 0, Length: histogramBuckets}},
+		PositiveBuckets: make([]int64, histogramBuckets),
+	}
+	var prev int64
+	for i, count := range s.buckets {
+		h.Count += count
+		h.PositiveBuckets[i] = int64(count) - prev
+		prev = int64(count)
+	}
+	return h
+}
+
+// Stats counts what a Run wrote.
+type Stats struct {
+	Requests       int
+	FailedRequests int
+	Series         int
+}
+
+// Run writes the samples of g with client every Config.Interval, churning the
+// series every Config.ChurnInterval, until ctx is done. Failed writes are
+// logged and counted, but don't stop the run.
+func (g *Generator) Run(ctx context.Context, client remotewrite.Client, logger log.Logger) Stats {
+	var stats Stats
+	var churn <-chan time.Time
+	if g.cfg.ChurnPercent > 0 {
+		churnTicker := time.NewTicker(g.cfg.ChurnInterval)
+		defer churnTicker.Stop()
+		churn = churnTicker.C
+	}
+	ticker := time.NewTicker(g.cfg.Interval)
+	defer ticker.Stop()
+
+	write := func(ts time.Time) {
+		for _, req := range g.Next(ts) {
+			stats.Requests++
+			if err := client.Write(ctx, req); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				stats.FailedRequests++
+				level.Warn(logger).Log("msg", "write failed", "series", len(req.Timeseries), "err", err)
+				continue
+			}
+			stats.Series += len(req.Timeseries)
+		}
+	}
+
+	write(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return stats
+		case <-churn:
+			g.Churn()
+		case ts := <-ticker.C:
+			write(ts)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() Config {
+	return Config{
+		Series:           10,
+		Metrics:          4,
+		Interval:         time.Millisecond,
+		BatchSize:        4,
+		ChurnPercent:     20,
+		ChurnInterval:    time.Minute,
+		Distribution:     DistributionCounter,
+		HistogramPercent: 25,
+	}
+}
+
+func seriesIDs(reqs []*mimirpb.WriteRequest) map[string]bool {
+	ids := map[string]bool{}
+	for _, req := range reqs {
+		for _, ts := range req.Timeseries {
+			ids[mimirpb.FromLabelAdaptersToLabels(ts.Labels).String()] = true
+		}
+	}
+	return ids
+}
+
+func TestGenerator(t *testing.T) {
+	cfg := testConfig()
+	require.NoError(t, cfg.Validate())
+	g := NewGenerator(cfg)
+
+	ts := time.Unix(1700000000, 0)
+	reqs := g.Next(ts)
+	require.Len(t, reqs, 3)
+	require.Len(t, reqs[2].Timeseries, 2)
+
+	var floats, histograms int
+	for _, req := range reqs {
+		for _, series := range req.Timeseries {
+			if series.Labels[0].Value == "loadgen_metric_0" {
+				require.Len(t, series.Histograms, 1)
+				require.Empty(t, series.Samples)
+				h := mimirpb.FromHistogramProtoToHistogram(&series.Histograms[0])
+				require.NoError(t, h.Validate())
+				histograms++
+				continue
+			}
+			require.Len(t, series.Samples, 1)
+			require.Equal(t, ts.UnixMilli(), series.Samples[0].TimestampMs)
+			floats++
+		}
+	}
+	require.Equal(t, 3, histograms)
+	require.Equal(t, 7, floats)
+
+	t.Run("counters don't decrease", func(t *testing.T) {
+		first := reqs[0].Timeseries[1].Samples[0].Value
+		second := g.Next(ts.Add(time.Minute))[0].Timeseries[1].Samples[0].Value
+		require.GreaterOrEqual(t, second, first)
+	})
+
+	t.Run("churn replaces the oldest series", func(t *testing.T) {
+		before := seriesIDs(g.Next(ts))
+		g.Churn()
+		after := seriesIDs(g.Next(ts))
+		require.Len(t, after, cfg.Series)
+
+		replaced := 0
+		for id := range before {
+			if !after[id] {
+				replaced++
+			}
+		}
+		require.Equal(t, 2, replaced)
+		require.True(t, after[`{__name__="loadgen_metric_0", generation="1", series="0"}`])
+		require.True(t, after[`{__name__="loadgen_metric_1", generation="1", series="1"}`])
+		require.True(t, after[`{__name__="loadgen_metric_2", generation="0", series="2"}`])
+	})
+}
+
+type fakeClient struct {
+	mtx    sync.Mutex
+	writes int
+}
+
+func (c *fakeClient) Write(_ context.Context, _ *mimirpb.WriteRequest) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.writes++
+	if c.writes%3 == 0 {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+func TestGenerator_Run(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	client := &fakeClient{}
+	stats := NewGenerator(testConfig()).Run(ctx, client, log.NewNopLogger())
+
+	require.Greater(t, stats.Requests, 3)
+	require.Equal(t, client.writes, stats.Requests)
+	// The last failed write isn't counted if it failed after ctx was done.
+	require.InDelta(t, stats.Requests/3, stats.FailedRequests, 1)
+	require.Positive(t, stats.Series)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		mutate  func(cfg *Config)
+		wantErr string
+	}{
+		"valid":                {mutate: func(*Config) {}},
+		"no series":            {mutate: func(cfg *Config) { cfg.Series = 0 }, wantErr: "series must be positive"},
+		"more metrics":         {mutate: func(cfg *Config) { cfg.Metrics = 11 }, wantErr: "metrics must be positive and at most the number of series"},
+		"churn out of range":   {mutate: func(cfg *Config) { cfg.ChurnPercent = 101 }, wantErr: "churn percent 101 must be between 0 and 100"},
+		"no churn interval":    {mutate: func(cfg *Config) { cfg.ChurnInterval = 0 }, wantErr: "churn interval must be positive"},
+		"no churn":             {mutate: func(cfg *Config) { cfg.ChurnPercent, cfg.ChurnInterval = 0, 0 }},
+		"unknown distribution": {mutate: func(cfg *Config) { cfg.Distribution = "zipf" }, wantErr: `unknown distribution "zipf"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := testConfig()
+			tc.mutate(&cfg)
+			err := cfg.Validate()
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
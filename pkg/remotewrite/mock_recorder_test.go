@@ -18,7 +18,32 @@ func (_m *MockRecorder) measure(_a0 string, _a1 time.Duration, _a2 error) {
 	_m.Called(_a0, _a1, _a2)
 }
 
+// measureActiveSeries provides a mock function with given fields: tenant, active, limit
+func (_m *MockRecorder) measureActiveSeries(tenant string, active int, limit int) {
+	_m.Called(tenant, active, limit)
+}
+
+// measureRejectedWrite provides a mock function with given fields: reason
+func (_m *MockRecorder) measureRejectedWrite(reason string) {
+	_m.Called(reason)
+}
+
+// measureBatchSize provides a mock function with given fields: samples
+func (_m *MockRecorder) measureBatchSize(samples int) {
+	_m.Called(samples)
+}
+
 // measureOutOfOrderSamples provides a mock function with given fields: count
 func (_m *MockRecorder) measureOutOfOrderSamples(count int) {
 	_m.Called(count)
 }
+
+// measureRejectedSeries provides a mock function with given fields: tenant, count
+func (_m *MockRecorder) measureRejectedSeries(tenant string, count int) {
+	_m.Called(tenant, count)
+}
+
+// measureTooOldSamples provides a mock function with given fields: policy, count
+func (_m *MockRecorder) measureTooOldSamples(policy string, count int) {
+	_m.Called(policy, count)
+}
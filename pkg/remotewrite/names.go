@@ -0,0 +1,17 @@
+package remotewrite
+
+import "regexp"
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// SanitizeName turns a name from another metrics protocol into a valid
+// Prometheus metric or label name, replacing dots and any other invalid
+// characters with underscores, and prefixing names starting with a digit with
+// an underscore.
+func SanitizeName(name string) string {
+	name = invalidNameChars.ReplaceAllString(name, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
@@ -0,0 +1,14 @@
+package remotewrite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeName(t *testing.T) {
+	require.Equal(t, "page_views", SanitizeName("page.views"))
+	require.Equal(t, "api_v1_req_s", SanitizeName("api-v1.req/s"))
+	require.Equal(t, "_5xx_errors", SanitizeName("5xx.errors"))
+	require.Equal(t, "host_name", SanitizeName("host:name"))
+}
@@ -0,0 +1,246 @@
+package remotewrite
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/prometheus/model/labels"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// NameCaseKeep keeps the case of the metric names.
+	NameCaseKeep = "keep"
+	// NameCaseLower lower cases the metric names.
+	NameCaseLower = "lower"
+	// NameCaseSnake turns camelCase metric names into snake_case.
+	NameCaseSnake = "snake"
+)
+
+var validReplacement = regexp.MustCompile(`^[a-zA-Z0-9_]*$`)
+
+type NormalizationConfig struct {
+	RulesFile string `yaml:"rules_file"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *NormalizationConfig) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *NormalizationConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.StringVar(&c.RulesFile, prefix+"normalization.rules-file", "", "Path to a YAML file of rules normalizing the names and units of the written metrics. Empty to write the metrics as they are.")
+}
+
+// NormalizationRules are the rules of the normalization rules file:
+//
+//	name_case: snake
+//	illegal_char_replacement: _
+//	units:
+//	  - suffix: _ms
+//	    replacement: _seconds
+//	    factor: 0.001
+//
+// The illegal characters of the metric names are replaced, then the case
+// policy applied, then the first unit rule whose suffix ends the name
+// replaces it and scales the values of the series.
+type NormalizationRules struct {
+	// NameCase is one of keep, lower or snake, keep by default.
+	NameCase string `yaml:"name_case"`
+	// IllegalCharReplacement replaces the characters not allowed in metric
+	// names, _ by default.
+	IllegalCharReplacement *string    `yaml:"illegal_char_replacement"`
+	Units                  []UnitRule `yaml:"units"`
+}
+
+// UnitRule converts the series whose metric name ends with Suffix to another
+// unit, replacing the suffix with Replacement and multiplying the values by
+// Factor.
+type UnitRule struct {
+	Suffix      string  `yaml:"suffix"`
+	Replacement string  `yaml:"replacement"`
+	Factor      float64 `yaml:"factor"`
+}
+
+// Validate checks the rules.
+func (r *NormalizationRules) Validate() error {
+	switch r.NameCase {
+	case "", NameCaseKeep, NameCaseLower, NameCaseSnake:
+	default:
+		return fmt.Errorf("unknown name case %q", r.NameCase)
+	}
+	if r.IllegalCharReplacement != nil && !validReplacement.MatchString(*r.IllegalCharReplacement) {
+		return fmt.Errorf("illegal char replacement %q must only contain letters, digits and underscores", *r.IllegalCharReplacement)
+	}
+	for i, u := range r.Units {
+		if u.Suffix == "" {
+			return fmt.Errorf("unit rule %d: suffix can't be empty", i)
+		}
+		if !validReplacement.MatchString(u.Replacement) {
+			return fmt.Errorf("unit rule %d: replacement %q must only contain letters, digits and underscores", i, u.Replacement)
+		}
+		if u.Factor == 0 {
+			return fmt.Errorf("unit rule %d: factor can't be 0", i)
+		}
+	}
+	return nil
+}
+
+// LoadNormalizationRules reads and checks the rules of a normalization rules
+// file.
+func LoadNormalizationRules(path string) (NormalizationRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return NormalizationRules{}, err
+	}
+	defer f.Close()
+
+	var rules NormalizationRules
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&rules); err != nil {
+		return NormalizationRules{}, fmt.Errorf("can't parse normalization rules %s: %w", path, err)
+	}
+	if err := rules.Validate(); err != nil {
+		return NormalizationRules{}, fmt.Errorf("invalid normalization rules %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// normalizeName replaces the illegal characters of the metric name and
+// applies the case policy.
+func (r *NormalizationRules) normalizeName(name string) string {
+	replacement := "_"
+	if r.IllegalCharReplacement != nil {
+		replacement = *r.IllegalCharReplacement
+	}
+	name = invalidNameChars.ReplaceAllString(name, replacement)
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+
+	switch r.NameCase {
+	case NameCaseLower:
+		name = strings.ToLower(name)
+	case NameCaseSnake:
+		name = snakeCase(name)
+	}
+	return name
+}
+
+// convertUnit returns the normalized name converted by the first unit rule
+// matching it, and the rule, or nil if none matches.
+func (r *NormalizationRules) convertUnit(name string) (string, *UnitRule) {
+	for i, u := range r.Units {
+		if strings.HasSuffix(name, u.Suffix) {
+			return strings.TrimSuffix(name, u.Suffix) + u.Replacement, &r.Units[i]
+		}
+	}
+	return name, nil
+}
+
+// snakeCase turns camelCase into camel_case, keeping acronyms together, so
+// responseTimeMs becomes response_time_ms and HTTPRequests http_requests.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var sb strings.Builder
+	for i, c := range runes {
+		if unicode.IsUpper(c) && i > 0 && runes[i-1] != '_' {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(c))
+	}
+	return sb.String()
+}
+
+// NormalizationClient normalizes the metric names and units of the series
+// before writing them. Unit rules don't apply to the series with native
+// histograms, whose buckets can't be scaled, so their names are only
+// normalized.
+type NormalizationClient struct {
+	client Client
+	rules  NormalizationRules
+}
+
+// NewNormalizationClient wraps client to normalize the series with the rules
+// of cfg, or returns client as is if no rules file is set.
+func NewNormalizationClient(client Client, cfg NormalizationConfig) (Client, error) {
+	if cfg.RulesFile == "" {
+		return client, nil
+	}
+	rules, err := LoadNormalizationRules(cfg.RulesFile)
+	if err != nil {
+		return nil, err
+	}
+	return &NormalizationClient{client: client, rules: rules}, nil
+}
+
+func (c *NormalizationClient) Write(ctx context.Context, req *mimirpb.WriteRequest) error {
+	// Copy the series, so the caller's request isn't modified.
+	series := make([]mimirpb.PreallocTimeseries, len(req.Timeseries))
+	for i, ts := range req.Timeseries {
+		series[i] = mimirpb.PreallocTimeseries{TimeSeries: c.normalize(ts.TimeSeries)}
+	}
+	normalized := *req
+	normalized.Timeseries = series
+	return c.client.Write(ctx, &normalized)
+}
+
+func (c *NormalizationClient) normalize(ts *mimirpb.TimeSeries) *mimirpb.TimeSeries {
+	changed := *ts
+	changed.Labels = make([]mimirpb.LabelAdapter, len(ts.Labels))
+	copy(changed.Labels, ts.Labels)
+
+	for i, l := range changed.Labels {
+		if l.Name != labels.MetricName {
+			continue
+		}
+		name := c.rules.normalizeName(l.Value)
+		if len(ts.Histograms) == 0 {
+			var unit *UnitRule
+			if name, unit = c.rules.convertUnit(name); unit != nil {
+				changed.Samples = scaleSamples(ts.Samples, unit.Factor)
+				changed.Exemplars = scaleExemplars(ts.Exemplars, unit.Factor)
+			}
+		}
+		changed.Labels[i].Value = name
+		break
+	}
+	return &changed
+}
+
+func scaleSamples(samples []mimirpb.Sample, factor float64) []mimirpb.Sample {
+	scaled := make([]mimirpb.Sample, len(samples))
+	for i, s := range samples {
+		scaled[i] = mimirpb.Sample{TimestampMs: s.TimestampMs, Value: s.Value * factor}
+	}
+	return scaled
+}
+
+func scaleExemplars(exemplars []mimirpb.Exemplar, factor float64) []mimirpb.Exemplar {
+	if len(exemplars) == 0 {
+		return exemplars
+	}
+	scaled := make([]mimirpb.Exemplar, len(exemplars))
+	for i, e := range exemplars {
+		scaled[i] = e
+		scaled[i].Value = e.Value * factor
+	}
+	return scaled
+}
@@ -0,0 +1,148 @@
+package remotewrite
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+)
+
+func writeNormalizationRules(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "normalization.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestNormalizationClient(t *testing.T) {
+	rules := writeNormalizationRules(t, `
+name_case: snake
+units:
+  - suffix: _ms
+    replacement: _seconds
+    factor: 0.001
+  - suffix: _seconds
+    replacement: _minutes
+    factor: 0.0166
+`)
+	// normalize returns the series written for series with the rules.
+	normalize := func(t *testing.T, series ...*mimirpb.TimeSeries) []mimirpb.PreallocTimeseries {
+		req := &mimirpb.WriteRequest{}
+		for _, ts := range series {
+			req.Timeseries = append(req.Timeseries, mimirpb.PreallocTimeseries{TimeSeries: ts})
+		}
+		var written []mimirpb.PreallocTimeseries
+		client := &remotewritemock.Client{}
+		defer client.AssertExpectations(t)
+		client.On("Write", mock.Anything, mock.Anything).Return(nil).Once().Run(func(args mock.Arguments) {
+			written = args.Get(1).(*mimirpb.WriteRequest).Timeseries
+		})
+		c, err := NewNormalizationClient(client, NormalizationConfig{RulesFile: rules})
+		require.NoError(t, err)
+		require.NoError(t, c.Write(context.Background(), req))
+		require.Len(t, written, len(series))
+		return written
+	}
+
+	t.Run("units are matched on the normalized name", func(t *testing.T) {
+		written := normalize(t, &mimirpb.TimeSeries{
+			Labels:    []mimirpb.LabelAdapter{{Name: "__name__", Value: "app.responseTime-ms"}, {Name: "job", Value: "web-1.x"}},
+			Samples:   []mimirpb.Sample{{TimestampMs: 1, Value: 250}},
+			Exemplars: []mimirpb.Exemplar{{TimestampMs: 1, Value: 500}},
+		})
+		// Only the metric name is normalized, not the other labels.
+		require.Equal(t, []mimirpb.LabelAdapter{{Name: "__name__", Value: "app_response_time_seconds"}, {Name: "job", Value: "web-1.x"}}, written[0].Labels)
+		require.Equal(t, []mimirpb.Sample{{TimestampMs: 1, Value: 0.25}}, written[0].Samples)
+		require.Equal(t, 0.5, written[0].Exemplars[0].Value)
+	})
+
+	t.Run("only the first matching unit rule applies", func(t *testing.T) {
+		written := normalize(t, &mimirpb.TimeSeries{
+			Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "latency_ms"}},
+			Samples: []mimirpb.Sample{{TimestampMs: 1, Value: 2000}},
+		})
+		require.Equal(t, "latency_seconds", written[0].Labels[0].Value)
+		require.Equal(t, 2.0, written[0].Samples[0].Value)
+	})
+
+	t.Run("native histograms keep their unit", func(t *testing.T) {
+		histograms := []mimirpb.Histogram{{Timestamp: 1, Sum: 250}}
+		written := normalize(t, &mimirpb.TimeSeries{
+			Labels:     []mimirpb.LabelAdapter{{Name: "__name__", Value: "app.latencyMs"}},
+			Histograms: histograms,
+		})
+		require.Equal(t, "app_latency_ms", written[0].Labels[0].Value)
+		require.Equal(t, histograms, written[0].Histograms)
+	})
+
+	t.Run("series without a metric name are written as they are", func(t *testing.T) {
+		series := &mimirpb.TimeSeries{
+			Labels:  []mimirpb.LabelAdapter{{Name: "job", Value: "responseTime-ms"}},
+			Samples: []mimirpb.Sample{{TimestampMs: 1, Value: 250}},
+		}
+		written := normalize(t, series)
+		require.Equal(t, series, written[0].TimeSeries)
+	})
+
+	t.Run("scaling doesn't change the caller's samples", func(t *testing.T) {
+		series := &mimirpb.TimeSeries{
+			Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "rtt_ms"}},
+			Samples: []mimirpb.Sample{{TimestampMs: 1, Value: 250}},
+		}
+		normalize(t, series)
+		require.Equal(t, "rtt_ms", series.Labels[0].Value)
+		require.Equal(t, 250.0, series.Samples[0].Value)
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		_, err := NewNormalizationClient(&remotewritemock.Client{}, NormalizationConfig{RulesFile: writeNormalizationRules(t, "name_case: upper")})
+		require.ErrorContains(t, err, `unknown name case "upper"`)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		client := &remotewritemock.Client{}
+		c, err := NewNormalizationClient(client, NormalizationConfig{})
+		require.NoError(t, err)
+		require.Equal(t, client, c)
+	})
+}
+
+func TestNormalizationRules_normalizeName(t *testing.T) {
+	empty := ""
+	for name, tc := range map[string]struct {
+		rules NormalizationRules
+		in    string
+		want  string
+	}{
+		"keep":        {rules: NormalizationRules{}, in: "servers.web-1.cpuUsage", want: "servers_web_1_cpuUsage"},
+		"lower":       {rules: NormalizationRules{NameCase: NameCaseLower}, in: "servers.web-1.cpuUsage", want: "servers_web_1_cpuusage"},
+		"snake":       {rules: NormalizationRules{NameCase: NameCaseSnake}, in: "servers.web1CPUUsage", want: "servers_web1_cpu_usage"},
+		"digit":       {rules: NormalizationRules{}, in: "5xx.count", want: "_5xx_count"},
+		"replacement": {rules: NormalizationRules{IllegalCharReplacement: &empty}, in: "a.b-c", want: "abc"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.want, tc.rules.normalizeName(tc.in))
+		})
+	}
+}
+
+func TestLoadNormalizationRules(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown case":         "name_case: upper",
+		"unknown field":        "name_cases: snake",
+		"invalid replacement":  "illegal_char_replacement: '.'",
+		"empty suffix":         "units: [{replacement: _seconds, factor: 0.001}]",
+		"zero factor":          "units: [{suffix: _ms, replacement: _seconds}]",
+		"invalid unit replace": "units: [{suffix: _ms, replacement: .s, factor: 0.001}]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadNormalizationRules(writeNormalizationRules(t, content))
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package otlp
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/dskit/flagext"
+)
+
+const defaultMaxRequestSize = 10 * 1024 * 1024
+
+type Config struct {
+	AddMetricSuffixes         bool                   `yaml:"add_metric_suffixes"`
+	PromoteResourceAttributes flagext.StringSliceCSV `yaml:"promote_resource_attributes"`
+	MaxRequestSize            int                    `yaml:"max_request_size"`
+	// RequireOrgID rejects the OTLP/gRPC requests without an org ID, which
+	// the auth middleware of the app doesn't see. It should be set when the
+	// app's auth.enable or auth.strict is.
+	RequireOrgID bool `yaml:"require_org_id"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *Config) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *Config) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.BoolVar(&c.AddMetricSuffixes, prefix+"otlp.add-metric-suffixes", true, "Whether to add type and unit suffixes, like _total or _seconds, to the names of converted OTLP metrics.")
+	flags.Var(&c.PromoteResourceAttributes, prefix+"otlp.promote-resource-attributes", "Comma separated list of OTel resource attributes to add as labels to every series of the resource.")
+	flags.IntVar(&c.MaxRequestSize, prefix+"otlp.max-request-size", defaultMaxRequestSize, "Max size in bytes of an uncompressed OTLP HTTP request.")
+	flags.BoolVar(&c.RequireOrgID, prefix+"otlp.require-org-id", true, "Reject OTLP/gRPC requests without an X-Scope-OrgID metadata as unauthenticated, instead of writing them to the \"fake\" tenant. Set it like auth.enable, OTLP/HTTP requests go through the auth middleware of the app.")
+}
+
+// Validate checks the request size limit.
+func (c *Config) Validate() error {
+	if c.MaxRequestSize <= 0 {
+		return fmt.Errorf("otlp max request size must be positive")
+	}
+	return nil
+}
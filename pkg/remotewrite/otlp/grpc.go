@@ -0,0 +1,46 @@
+package otlp
+
+import (
+	"context"
+
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/util/spanlogger"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"google.golang.org/grpc"
+
+	// Collectors compress OTLP/gRPC requests with gzip by default.
+	_ "google.golang.org/grpc/encoding/gzip"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	graphiteAuth "github.com/grafana/mimir-graphite/v2/pkg/graphite/authentication"
+)
+
+// RegisterGRPC registers the OTLP/gRPC metrics service.
+func (r *Receiver) RegisterGRPC(s *grpc.Server) {
+	pmetricotlp.RegisterGRPCServer(s, r)
+}
+
+// Export handles OTLP/gRPC export requests. The tenant is read from the
+// X-Scope-OrgID request metadata; without it the request is rejected as
+// unauthenticated if Config.RequireOrgID is set, or the "fake" tenant is used,
+// like for HTTP requests when auth is disabled.
+func (r *Receiver) Export(ctx context.Context, req pmetricotlp.ExportRequest) (pmetricotlp.ExportResponse, error) {
+	log, ctx := spanlogger.NewWithLogger(ctx, r.logger, "otlp.Receiver.Export")
+	defer log.Finish()
+
+	orgID, _, err := user.ExtractFromGRPCRequest(ctx)
+	switch {
+	case err == nil:
+		ctx = user.InjectOrgID(ctx, orgID)
+	case r.cfg.RequireOrgID:
+		return pmetricotlp.NewExportResponse(), errorx.ErrorAsGRPCStatus(errorx.Unauthorized{Msg: "no org ID: set the X-Scope-OrgID metadata to the tenant ID", Err: err}).Err()
+	default:
+		ctx, _ = graphiteAuth.ExtractOrgID(ctx)
+	}
+
+	resp, err := r.write(ctx, log, req.Metrics())
+	if err != nil {
+		return resp, errorx.ErrorAsGRPCStatus(err).Err()
+	}
+	return resp, nil
+}
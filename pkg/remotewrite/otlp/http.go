@@ -0,0 +1,89 @@
+package otlp
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/mimir/pkg/util/spanlogger"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	graphiteAuth "github.com/grafana/mimir-graphite/v2/pkg/graphite/authentication"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite"
+	"github.com/grafana/mimir-graphite/v2/pkg/route"
+)
+
+// MetricsPath is the path of the OTLP/HTTP metrics endpoint.
+const MetricsPath = "/v1/metrics"
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// RegisterRoutes registers the OTLP/HTTP metrics endpoint.
+func (r *Receiver) RegisterRoutes(reg route.Registerer) {
+	reg.RegisterRoute(MetricsPath, r, http.MethodPost)
+}
+
+// ServeHTTP handles OTLP/HTTP export requests, encoded as either protobuf or
+// JSON. It expects the tenant to be set in the request context by the app's
+// auth middleware.
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	log, ctx := spanlogger.NewWithLogger(req.Context(), r.logger, "otlp.Receiver.ServeHTTP")
+	defer log.Finish()
+	ctx, userID := graphiteAuth.ExtractOrgID(ctx)
+
+	contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if contentType != contentTypeProtobuf && contentType != contentTypeJSON {
+		http.Error(w, fmt.Sprintf("unsupported content type %q", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := remotewrite.ReadBody(req, int64(r.cfg.MaxRequestSize))
+	var tooLarge errorx.LimitExceeded
+	if errors.As(err, &tooLarge) {
+		level.Warn(log).Log("msg", "otlp write request too large", "user", userID, "err", err)
+		http.Error(w, tooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		errorx.LogAndSetHTTPError(ctx, w, log, err)
+		return
+	}
+
+	exportReq := pmetricotlp.NewExportRequest()
+	if contentType == contentTypeJSON {
+		err = exportReq.UnmarshalJSON(body)
+	} else {
+		err = exportReq.UnmarshalProto(body)
+	}
+	if err != nil {
+		level.Warn(log).Log("msg", "can't decode otlp request", "user", userID, "err", err)
+		http.Error(w, fmt.Sprintf("can't decode request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := r.write(ctx, log, exportReq.Metrics())
+	if err != nil {
+		errorx.LogAndSetHTTPError(ctx, w, log, err)
+		return
+	}
+
+	var out []byte
+	if contentType == contentTypeJSON {
+		out, err = resp.MarshalJSON()
+	} else {
+		out, err = resp.MarshalProto()
+	}
+	if err != nil {
+		errorx.LogAndSetHTTPError(ctx, w, log, errorx.Internal{Msg: "can't encode response", Err: err})
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
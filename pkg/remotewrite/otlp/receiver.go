@@ -0,0 +1,96 @@
+// Package otlp implements OTLP/HTTP and OTLP/gRPC metrics receivers that
+// convert OpenTelemetry metrics to Prometheus series, with exponential
+// histograms as native histograms, and write them through a
+// remotewrite.Client.
+package otlp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	mimirotlp "github.com/grafana/mimir/pkg/distributor/otlp"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	utillog "github.com/grafana/mimir/pkg/util/log"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite"
+)
+
+// Receiver accepts OTLP metrics over HTTP, see ServeHTTP, and gRPC, see
+// Export, and writes them through the remote write client.
+type Receiver struct {
+	pmetricotlp.UnimplementedGRPCServer
+
+	cfg    Config
+	client remotewrite.Client
+	logger log.Logger
+}
+
+func NewReceiver(cfg Config, client remotewrite.Client, logger log.Logger) (*Receiver, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Receiver{cfg: cfg, client: client, logger: logger}, nil
+}
+
+// write converts and writes the metrics. Metrics that can't be converted are
+// dropped and reported in the partial success of the returned response, as
+// the OTLP spec requires; the error is only set if the write itself fails.
+func (r *Receiver) write(ctx context.Context, logger log.Logger, md pmetric.Metrics) (pmetricotlp.ExportResponse, error) {
+	resp := pmetricotlp.NewExportResponse()
+
+	converter := mimirotlp.NewMimirConverter()
+	_, convErr := converter.FromMetrics(ctx, md, mimirotlp.Settings{
+		AddMetricSuffixes:         r.cfg.AddMetricSuffixes,
+		PromoteResourceAttributes: r.cfg.PromoteResourceAttributes,
+	}, utillog.SlogFromGoKit(logger))
+	if convErr != nil {
+		level.Warn(logger).Log("msg", "can't convert some otlp metrics", "err", convErr)
+		resp.PartialSuccess().SetErrorMessage(fmt.Sprintf("can't convert some metrics: %s", convErr))
+		resp.PartialSuccess().SetRejectedDataPoints(rejectedDataPoints(md))
+	}
+
+	series := converter.TimeSeries()
+	if len(series) == 0 {
+		return resp, nil
+	}
+	if err := r.client.Write(ctx, &mimirpb.WriteRequest{Timeseries: series, Source: mimirpb.API}); err != nil {
+		return resp, err
+	}
+	level.Debug(logger).Log("msg", "successful otlp write", "datapoints", md.DataPointCount(), "series", len(series))
+	return resp, nil
+}
+
+// rejectedDataPoints returns the number of data points of the metrics Mimir's
+// converter drops, the sums and histograms without cumulative temporality.
+func rejectedDataPoints(md pmetric.Metrics) int64 {
+	var rejected int64
+	resourceMetrics := md.ResourceMetrics()
+	for i := 0; i < resourceMetrics.Len(); i++ {
+		scopeMetrics := resourceMetrics.At(i).ScopeMetrics()
+		for j := 0; j < scopeMetrics.Len(); j++ {
+			metrics := scopeMetrics.At(j).Metrics()
+			for k := 0; k < metrics.Len(); k++ {
+				m := metrics.At(k)
+				switch m.Type() {
+				case pmetric.MetricTypeSum:
+					if m.Sum().AggregationTemporality() != pmetric.AggregationTemporalityCumulative {
+						rejected += int64(m.Sum().DataPoints().Len())
+					}
+				case pmetric.MetricTypeHistogram:
+					if m.Histogram().AggregationTemporality() != pmetric.AggregationTemporalityCumulative {
+						rejected += int64(m.Histogram().DataPoints().Len())
+					}
+				case pmetric.MetricTypeExponentialHistogram:
+					if m.ExponentialHistogram().AggregationTemporality() != pmetric.AggregationTemporalityCumulative {
+						rejected += int64(m.ExponentialHistogram().DataPoints().Len())
+					}
+				}
+			}
+		}
+	}
+	return rejected
+}
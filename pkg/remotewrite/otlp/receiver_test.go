@@ -0,0 +1,244 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+)
+
+var testTimestamp = time.Unix(1700000000, 0)
+
+func testMetrics() pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("service.name", "checkout")
+	metrics := rm.ScopeMetrics().AppendEmpty().Metrics()
+
+	gauge := metrics.AppendEmpty()
+	gauge.SetName("queue.size")
+	dp := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.SetTimestamp(pcommon.NewTimestampFromTime(testTimestamp))
+	dp.SetDoubleValue(3)
+
+	histogram := metrics.AppendEmpty()
+	histogram.SetName("request.duration")
+	histogram.SetUnit("s")
+	exp := histogram.SetEmptyExponentialHistogram()
+	exp.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	hdp := exp.DataPoints().AppendEmpty()
+	hdp.SetTimestamp(pcommon.NewTimestampFromTime(testTimestamp))
+	hdp.SetScale(0)
+	hdp.SetCount(3)
+	hdp.SetSum(6)
+	hdp.Positive().SetOffset(0)
+	hdp.Positive().BucketCounts().FromRaw([]uint64{1, 2})
+	return md
+}
+
+// requireWritten checks the write request has the gauge as a float sample and
+// the exponential histogram as a native histogram.
+func requireWritten(t *testing.T, req *mimirpb.WriteRequest) {
+	t.Helper()
+	var gauge, histogram *mimirpb.TimeSeries
+	for _, ts := range req.Timeseries {
+		switch mimirpb.FromLabelAdaptersToLabels(ts.Labels).Get("__name__") {
+		case "queue_size":
+			gauge = ts.TimeSeries
+		case "request_duration_seconds":
+			histogram = ts.TimeSeries
+		}
+	}
+	require.NotNil(t, gauge, "missing gauge series in %v", req.Timeseries)
+	require.Equal(t, []mimirpb.Sample{{TimestampMs: testTimestamp.UnixMilli(), Value: 3}}, gauge.Samples)
+	require.NotNil(t, histogram, "missing histogram series in %v", req.Timeseries)
+	require.Empty(t, histogram.Samples)
+	require.Len(t, histogram.Histograms, 1)
+	require.Equal(t, uint64(3), histogram.Histograms[0].GetCountInt())
+	require.Equal(t, 6.0, histogram.Histograms[0].Sum)
+}
+
+func TestReceiver_ServeHTTP(t *testing.T) {
+	protoBody, err := pmetricotlp.NewExportRequestFromMetrics(testMetrics()).MarshalProto()
+	require.NoError(t, err)
+	jsonBody, err := pmetricotlp.NewExportRequestFromMetrics(testMetrics()).MarshalJSON()
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		contentType string
+		body        []byte
+		writeErr    error
+		wantWrite   bool
+		wantStatus  int
+	}{
+		"protobuf": {
+			contentType: contentTypeProtobuf,
+			body:        protoBody,
+			wantWrite:   true,
+			wantStatus:  http.StatusOK,
+		},
+		"json": {
+			contentType: contentTypeJSON + "; charset=utf-8",
+			body:        jsonBody,
+			wantWrite:   true,
+			wantStatus:  http.StatusOK,
+		},
+		"unsupported content type": {
+			contentType: "text/plain",
+			body:        protoBody,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		"invalid body": {
+			contentType: contentTypeProtobuf,
+			body:        []byte("not a protobuf"),
+			wantStatus:  http.StatusBadRequest,
+		},
+		"too large": {
+			contentType: contentTypeProtobuf,
+			body:        bytes.Repeat(protoBody, 100),
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+		"downstream error": {
+			contentType: contentTypeProtobuf,
+			body:        protoBody,
+			writeErr:    errorx.TooManyRequests{Msg: "slow down"},
+			wantWrite:   true,
+			wantStatus:  http.StatusTooManyRequests,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &remotewritemock.Client{}
+			defer client.AssertExpectations(t)
+			if tc.wantWrite {
+				client.On("Write", mock.Anything, mock.Anything).Return(tc.writeErr).Run(func(args mock.Arguments) {
+					tenant, err := user.ExtractOrgID(args.Get(0).(context.Context))
+					require.NoError(t, err)
+					require.Equal(t, "tenant-1", tenant)
+					requireWritten(t, args.Get(1).(*mimirpb.WriteRequest))
+				})
+			}
+
+			r, err := NewReceiver(Config{AddMetricSuffixes: true, MaxRequestSize: len(protoBody) * 10}, client, log.NewNopLogger())
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, MetricsPath, bytes.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			req = req.WithContext(user.InjectOrgID(req.Context(), "tenant-1"))
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			require.Equal(t, tc.wantStatus, resp.Code, resp.Body.String())
+		})
+	}
+}
+
+func TestReceiver_Export(t *testing.T) {
+	for name, tc := range map[string]struct {
+		orgID        string
+		requireOrgID bool
+		writeErr     error
+		wantTenant   string
+		wantCode     codes.Code
+	}{
+		"with tenant": {
+			orgID:        "tenant-1",
+			requireOrgID: true,
+			wantTenant:   "tenant-1",
+			wantCode:     codes.OK,
+		},
+		"without tenant": {
+			wantTenant: "fake",
+			wantCode:   codes.OK,
+		},
+		"without tenant when required": {
+			requireOrgID: true,
+			wantCode:     codes.Unauthenticated,
+		},
+		"downstream error": {
+			orgID:      "tenant-1",
+			writeErr:   errorx.TooManyRequests{Msg: "slow down"},
+			wantTenant: "tenant-1",
+			wantCode:   codes.ResourceExhausted,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &remotewritemock.Client{}
+			defer client.AssertExpectations(t)
+			if tc.wantTenant != "" {
+				client.On("Write", mock.Anything, mock.Anything).Return(tc.writeErr).Run(func(args mock.Arguments) {
+					tenant, err := user.ExtractOrgID(args.Get(0).(context.Context))
+					require.NoError(t, err)
+					require.Equal(t, tc.wantTenant, tenant)
+					requireWritten(t, args.Get(1).(*mimirpb.WriteRequest))
+				})
+			}
+
+			r, err := NewReceiver(Config{AddMetricSuffixes: true, MaxRequestSize: defaultMaxRequestSize, RequireOrgID: tc.requireOrgID}, client, log.NewNopLogger())
+			require.NoError(t, err)
+
+			listener := bufconn.Listen(1024 * 1024)
+			srv := grpc.NewServer()
+			r.RegisterGRPC(srv)
+			go func() { _ = srv.Serve(listener) }()
+			defer srv.Stop()
+
+			conn, err := grpc.NewClient("passthrough:///bufconn",
+				grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+			)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			ctx := context.Background()
+			if tc.orgID != "" {
+				ctx, err = user.InjectIntoGRPCRequest(user.InjectOrgID(ctx, tc.orgID))
+				require.NoError(t, err)
+			}
+			_, err = pmetricotlp.NewGRPCClient(conn).Export(ctx, pmetricotlp.NewExportRequestFromMetrics(testMetrics()))
+			require.Equal(t, tc.wantCode, status.Code(err), err)
+		})
+	}
+}
+
+func TestReceiver_RejectedDataPoints(t *testing.T) {
+	md := testMetrics()
+	delta := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().AppendEmpty()
+	delta.SetName("requests")
+	sum := delta.SetEmptySum()
+	sum.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	sum.DataPoints().AppendEmpty().SetIntValue(1)
+	sum.DataPoints().AppendEmpty().SetIntValue(2)
+
+	client := &remotewritemock.Client{}
+	defer client.AssertExpectations(t)
+	client.On("Write", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		requireWritten(t, args.Get(1).(*mimirpb.WriteRequest))
+	})
+	r, err := NewReceiver(Config{AddMetricSuffixes: true, MaxRequestSize: defaultMaxRequestSize}, client, log.NewNopLogger())
+	require.NoError(t, err)
+
+	resp, err := r.write(user.InjectOrgID(context.Background(), "tenant-1"), log.NewNopLogger(), md)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), resp.PartialSuccess().RejectedDataPoints())
+	require.Contains(t, resp.PartialSuccess().ErrorMessage(), "requests")
+}
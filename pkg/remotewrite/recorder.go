@@ -10,6 +10,11 @@ import (
 type Recorder interface {
 	measureOutOfOrderSamples(count int)
 	measure(string, time.Duration, error)
+	measureActiveSeries(tenant string, active, limit int)
+	measureRejectedSeries(tenant string, count int)
+	measureTooOldSamples(policy string, count int)
+	measureRejectedWrite(reason string)
+	measureBatchSize(samples int)
 }
 
 // NewRecorder returns a new Prometheus metrics Recorder.
@@ -26,10 +31,46 @@ func NewRecorder(prefix string, reg prometheus.Registerer) Recorder {
 			Name:      "request_duration_seconds",
 			Help:      "Client-side duration of remote write calls.",
 		}, []string{"operation", "result"}),
+		activeSeries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "cardinality_active_series",
+			Help:      "The number of active series per tenant, for tenants with a series limit.",
+		}, []string{"user"}),
+		seriesLimit: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "cardinality_series_limit",
+			Help:      "The max number of active series per tenant.",
+		}, []string{"user"}),
+		rejectedSeries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "cardinality_rejected_series_total",
+			Help:      "The total number of series not written because the tenant reached its series limit.",
+		}, []string{"user"}),
+		tooOldSamples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "too_old_samples_total",
+			Help:      "The total number of samples older than the max sample age, by the policy applied to them.",
+		}, []string{"policy"}),
+		rejectedWrites: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "rejected_writes_total",
+			Help:      "The total number of writes rejected by Mimir with a 4xx response other than 408 and 429, by the Mimir error ID, or unauthorized or too-large for the 401, 403 and 413 responses.",
+		}, []string{"reason"}),
+		batchSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "adaptive_batch_size_samples",
+			Help:      "The current max number of samples per write, when adaptive batching is enabled.",
+		}),
 	}
 
 	reg.MustRegister(r.outOfOrderWrites)
 	reg.MustRegister(r.requestDuration)
+	reg.MustRegister(r.activeSeries)
+	reg.MustRegister(r.seriesLimit)
+	reg.MustRegister(r.rejectedSeries)
+	reg.MustRegister(r.tooOldSamples)
+	reg.MustRegister(r.rejectedWrites)
+	reg.MustRegister(r.batchSize)
 
 	return r
 }
@@ -39,6 +80,12 @@ func NewRecorder(prefix string, reg prometheus.Registerer) Recorder {
 type prometheusRecorder struct {
 	outOfOrderWrites *prometheus.CounterVec
 	requestDuration  *prometheus.HistogramVec
+	activeSeries     *prometheus.GaugeVec
+	seriesLimit      *prometheus.GaugeVec
+	rejectedSeries   *prometheus.CounterVec
+	tooOldSamples    *prometheus.CounterVec
+	rejectedWrites   *prometheus.CounterVec
+	batchSize        prometheus.Gauge
 }
 
 func (r prometheusRecorder) measureOutOfOrderSamples(count int) {
@@ -52,3 +99,24 @@ func (r prometheusRecorder) measure(op string, duration time.Duration, err error
 	}
 	r.requestDuration.WithLabelValues(op, result).Observe(duration.Seconds())
 }
+
+func (r prometheusRecorder) measureActiveSeries(tenant string, active, limit int) {
+	r.activeSeries.WithLabelValues(tenant).Set(float64(active))
+	r.seriesLimit.WithLabelValues(tenant).Set(float64(limit))
+}
+
+func (r prometheusRecorder) measureRejectedSeries(tenant string, count int) {
+	r.rejectedSeries.WithLabelValues(tenant).Add(float64(count))
+}
+
+func (r prometheusRecorder) measureTooOldSamples(policy string, count int) {
+	r.tooOldSamples.WithLabelValues(policy).Add(float64(count))
+}
+
+func (r prometheusRecorder) measureRejectedWrite(reason string) {
+	r.rejectedWrites.WithLabelValues(reason).Inc()
+}
+
+func (r prometheusRecorder) measureBatchSize(samples int) {
+	r.batchSize.Set(float64(samples))
+}
@@ -29,6 +29,44 @@ func TestRecorder(t *testing.T) {
 				"# TYPE my_proxy_out_of_order_writes_total counter\n" +
 				"my_proxy_out_of_order_writes_total 2\n",
 		},
+		"Measure too old samples and rejected writes": {
+			measure: func(r Recorder) {
+				r.measureTooOldSamples("drop", 4)
+				r.measureRejectedWrite("sample-timestamp-too-old")
+			},
+			expMetricNames: []string{
+				"my_proxy_too_old_samples_total",
+				"my_proxy_rejected_writes_total",
+			},
+			expMetrics: "" +
+				"# HELP my_proxy_rejected_writes_total The total number of writes rejected by Mimir with a 4xx response other than 408 and 429, by the Mimir error ID, or unauthorized or too-large for the 401, 403 and 413 responses.\n" +
+				"# TYPE my_proxy_rejected_writes_total counter\n" +
+				"my_proxy_rejected_writes_total{reason=\"sample-timestamp-too-old\"} 1\n" +
+				"# HELP my_proxy_too_old_samples_total The total number of samples older than the max sample age, by the policy applied to them.\n" +
+				"# TYPE my_proxy_too_old_samples_total counter\n" +
+				"my_proxy_too_old_samples_total{policy=\"drop\"} 4\n",
+		},
+		"Measure cardinality": {
+			measure: func(r Recorder) {
+				r.measureActiveSeries("tenant-1", 8, 10)
+				r.measureRejectedSeries("tenant-1", 3)
+			},
+			expMetricNames: []string{
+				"my_proxy_cardinality_active_series",
+				"my_proxy_cardinality_series_limit",
+				"my_proxy_cardinality_rejected_series_total",
+			},
+			expMetrics: "" +
+				"# HELP my_proxy_cardinality_active_series The number of active series per tenant, for tenants with a series limit.\n" +
+				"# TYPE my_proxy_cardinality_active_series gauge\n" +
+				"my_proxy_cardinality_active_series{user=\"tenant-1\"} 8\n" +
+				"# HELP my_proxy_cardinality_series_limit The max number of active series per tenant.\n" +
+				"# TYPE my_proxy_cardinality_series_limit gauge\n" +
+				"my_proxy_cardinality_series_limit{user=\"tenant-1\"} 10\n" +
+				"# HELP my_proxy_cardinality_rejected_series_total The total number of series not written because the tenant reached its series limit.\n" +
+				"# TYPE my_proxy_cardinality_rejected_series_total counter\n" +
+				"my_proxy_cardinality_rejected_series_total{user=\"tenant-1\"} 3\n",
+		},
 	}
 
 	for name, test := range tests {
@@ -0,0 +1,159 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+)
+
+const (
+	// TooOldPolicyForward writes samples older than the max age as they are,
+	// only counting them.
+	TooOldPolicyForward = "forward"
+	// TooOldPolicyDrop drops samples older than the max age.
+	TooOldPolicyDrop = "drop"
+	// TooOldPolicyClamp moves samples older than the max age to the max age,
+	// keeping only the most recent of them per series.
+	TooOldPolicyClamp = "clamp"
+)
+
+type SampleAgeConfig struct {
+	MaxAge       time.Duration `yaml:"max_age"`
+	TooOldPolicy string        `yaml:"too_old_policy"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *SampleAgeConfig) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *SampleAgeConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.DurationVar(&c.MaxAge, prefix+"sample-age.max-age", 0, "Samples older than this are handled with the too old policy. Usually set slightly below Mimir's -validation.past-grace-period or out-of-order window. 0 to disable.")
+	flags.StringVar(&c.TooOldPolicy, prefix+"sample-age.too-old-policy", TooOldPolicyDrop, fmt.Sprintf("What to do with samples older than the max age: %q, %q or %q.", TooOldPolicyDrop, TooOldPolicyClamp, TooOldPolicyForward))
+}
+
+// Validate checks the max age and policy.
+func (c *SampleAgeConfig) Validate() error {
+	if c.MaxAge < 0 {
+		return errors.New("max sample age can't be negative")
+	}
+	switch c.TooOldPolicy {
+	case TooOldPolicyForward, TooOldPolicyDrop, TooOldPolicyClamp:
+		return nil
+	default:
+		return fmt.Errorf("unknown too old sample policy %q", c.TooOldPolicy)
+	}
+}
+
+// SampleAgeClient applies the too old policy to the float and histogram
+// samples older than the max age before writing them, so they don't make
+// Mimir reject the whole request.
+type SampleAgeClient struct {
+	cfg      SampleAgeConfig
+	client   Client
+	recorder Recorder
+	timeNow  func() time.Time
+}
+
+func NewSampleAgeClient(client Client, cfg SampleAgeConfig, recorder Recorder, timeNow func() time.Time) Client {
+	return &SampleAgeClient{cfg: cfg, client: client, recorder: recorder, timeNow: timeNow}
+}
+
+func (c *SampleAgeClient) Write(ctx context.Context, req *mimirpb.WriteRequest) error {
+	if c.cfg.MaxAge <= 0 {
+		return c.client.Write(ctx, req)
+	}
+	cutoffMs := c.timeNow().Add(-c.cfg.MaxAge).UnixMilli()
+
+	var series []mimirpb.PreallocTimeseries
+	tooOld := 0
+	for i, ts := range req.Timeseries {
+		samples, samplesTooOld := applyTooOldPolicy(ts.Samples, cutoffMs, c.cfg.TooOldPolicy, func(s *mimirpb.Sample) *int64 { return &s.TimestampMs })
+		histograms, histogramsTooOld := applyTooOldPolicy(ts.Histograms, cutoffMs, c.cfg.TooOldPolicy, func(h *mimirpb.Histogram) *int64 { return &h.Timestamp })
+		tooOld += samplesTooOld + histogramsTooOld
+		if c.cfg.TooOldPolicy == TooOldPolicyForward || samplesTooOld+histogramsTooOld == 0 {
+			if series != nil {
+				series = append(series, ts)
+			}
+			continue
+		}
+
+		// Copy the series that are kept so far on the first change, so the
+		// caller's request isn't modified.
+		if series == nil {
+			series = make([]mimirpb.PreallocTimeseries, i, len(req.Timeseries))
+			copy(series, req.Timeseries[:i])
+		}
+		if len(samples) == 0 && len(histograms) == 0 {
+			continue
+		}
+		changed := *ts.TimeSeries
+		changed.Samples = samples
+		changed.Histograms = histograms
+		series = append(series, mimirpb.PreallocTimeseries{TimeSeries: &changed})
+	}
+
+	if tooOld > 0 {
+		c.recorder.measureTooOldSamples(c.cfg.TooOldPolicy, tooOld)
+	}
+	if series != nil {
+		filtered := *req
+		filtered.Timeseries = series
+		req = &filtered
+		if len(series) == 0 && len(req.Metadata) == 0 {
+			markDropped(ctx, DropReasonTooOld)
+			return nil
+		}
+	}
+	return c.client.Write(ctx, req)
+}
+
+// applyTooOldPolicy returns the samples to write and the number of samples
+// older than cutoffMs. The given samples are returned as they are if none is
+// too old, or if the policy is to forward them.
+func applyTooOldPolicy[T any](samples []T, cutoffMs int64, policy string, timestamp func(*T) *int64) ([]T, int) {
+	tooOld := 0
+	for i := range samples {
+		if *timestamp(&samples[i]) < cutoffMs {
+			tooOld++
+		}
+	}
+	if tooOld == 0 || policy == TooOldPolicyForward {
+		return samples, tooOld
+	}
+
+	kept := make([]T, 0, len(samples)-tooOld+1)
+	var newestTooOld *T
+	for i := range samples {
+		s := &samples[i]
+		if *timestamp(s) >= cutoffMs {
+			kept = append(kept, *s)
+		} else if newestTooOld == nil || *timestamp(s) > *timestamp(newestTooOld) {
+			newestTooOld = s
+		}
+	}
+	if policy != TooOldPolicyClamp {
+		return kept, tooOld
+	}
+
+	// The clamped sample would collide with a sample already at the cutoff.
+	for i := range kept {
+		if *timestamp(&kept[i]) == cutoffMs {
+			return kept, tooOld
+		}
+	}
+	clamped := *newestTooOld
+	*timestamp(&clamped) = cutoffMs
+	return append([]T{clamped}, kept...), tooOld
+}
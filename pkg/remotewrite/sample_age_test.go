@@ -0,0 +1,178 @@
+package remotewrite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+)
+
+func TestSampleAgeClient(t *testing.T) {
+	now := time.UnixMilli(100_000)
+	newRequest := func() *mimirpb.WriteRequest {
+		return &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{
+			{TimeSeries: &mimirpb.TimeSeries{
+				Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "recent"}},
+				Samples: []mimirpb.Sample{{TimestampMs: 95_000, Value: 1}},
+			}},
+			{TimeSeries: &mimirpb.TimeSeries{
+				Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "mixed"}},
+				Samples: []mimirpb.Sample{{TimestampMs: 10_000, Value: 1}, {TimestampMs: 20_000, Value: 2}, {TimestampMs: 95_000, Value: 3}},
+			}},
+			{TimeSeries: &mimirpb.TimeSeries{
+				Labels:     []mimirpb.LabelAdapter{{Name: "__name__", Value: "old_histogram"}},
+				Histograms: []mimirpb.Histogram{{Timestamp: 30_000, Sum: 1}},
+			}},
+		}}
+	}
+
+	for name, tc := range map[string]struct {
+		cfg        SampleAgeConfig
+		wantTooOld int
+		want       map[string][]int64
+	}{
+		"disabled": {
+			cfg:  SampleAgeConfig{TooOldPolicy: TooOldPolicyDrop},
+			want: map[string][]int64{"recent": {95_000}, "mixed": {10_000, 20_000, 95_000}, "old_histogram": {30_000}},
+		},
+		"forward": {
+			cfg:        SampleAgeConfig{MaxAge: time.Minute, TooOldPolicy: TooOldPolicyForward},
+			wantTooOld: 3,
+			want:       map[string][]int64{"recent": {95_000}, "mixed": {10_000, 20_000, 95_000}, "old_histogram": {30_000}},
+		},
+		"drop": {
+			cfg:        SampleAgeConfig{MaxAge: time.Minute, TooOldPolicy: TooOldPolicyDrop},
+			wantTooOld: 3,
+			want:       map[string][]int64{"recent": {95_000}, "mixed": {95_000}},
+		},
+		"clamp": {
+			cfg:        SampleAgeConfig{MaxAge: time.Minute, TooOldPolicy: TooOldPolicyClamp},
+			wantTooOld: 3,
+			want:       map[string][]int64{"recent": {95_000}, "mixed": {40_000, 95_000}, "old_histogram": {40_000}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &remotewritemock.Client{}
+			defer client.AssertExpectations(t)
+			client.On("Write", mock.Anything, mock.Anything).Return(nil).Once().Run(func(args mock.Arguments) {
+				got := map[string][]int64{}
+				for _, ts := range args.Get(1).(*mimirpb.WriteRequest).Timeseries {
+					name := ts.Labels[0].Value
+					for _, s := range ts.Samples {
+						got[name] = append(got[name], s.TimestampMs)
+					}
+					for _, h := range ts.Histograms {
+						got[name] = append(got[name], h.Timestamp)
+					}
+				}
+				require.Equal(t, tc.want, got)
+			})
+			recorder := &MockRecorder{}
+			defer recorder.AssertExpectations(t)
+			if tc.wantTooOld > 0 {
+				recorder.On("measureTooOldSamples", tc.cfg.TooOldPolicy, tc.wantTooOld).Return().Once()
+			}
+
+			c := NewSampleAgeClient(client, tc.cfg, recorder, func() time.Time { return now })
+			require.NoError(t, c.Write(context.Background(), newRequest()))
+		})
+	}
+
+	clamp := SampleAgeConfig{MaxAge: time.Minute, TooOldPolicy: TooOldPolicyClamp}
+
+	t.Run("samples at the cutoff aren't too old", func(t *testing.T) {
+		req := &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{{TimeSeries: &mimirpb.TimeSeries{
+			Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "edge"}},
+			Samples: []mimirpb.Sample{{TimestampMs: 40_000, Value: 1}},
+		}}}}
+		client := &remotewritemock.Client{}
+		defer client.AssertExpectations(t)
+		client.On("Write", mock.Anything, req).Return(nil).Once()
+
+		// The recorder has no expectations, so nothing is counted as too old.
+		c := NewSampleAgeClient(client, clamp, &MockRecorder{}, func() time.Time { return now })
+		require.NoError(t, c.Write(context.Background(), req))
+	})
+
+	t.Run("clamped samples don't collide with the ones at the cutoff", func(t *testing.T) {
+		client := &remotewritemock.Client{}
+		defer client.AssertExpectations(t)
+		client.On("Write", mock.Anything, mock.Anything).Return(nil).Once().Run(func(args mock.Arguments) {
+			series := args.Get(1).(*mimirpb.WriteRequest).Timeseries
+			require.Len(t, series, 1)
+			require.Equal(t, []mimirpb.Sample{{TimestampMs: 40_000, Value: 2}}, series[0].Samples)
+		})
+		recorder := &MockRecorder{}
+		recorder.On("measureTooOldSamples", TooOldPolicyClamp, 1).Return().Once()
+
+		c := NewSampleAgeClient(client, clamp, recorder, func() time.Time { return now })
+		require.NoError(t, c.Write(context.Background(), &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{{TimeSeries: &mimirpb.TimeSeries{
+			Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "edge"}},
+			Samples: []mimirpb.Sample{{TimestampMs: 30_000, Value: 1}, {TimestampMs: 40_000, Value: 2}},
+		}}}}))
+		recorder.AssertExpectations(t)
+	})
+
+	t.Run("clamping copies the samples it moves", func(t *testing.T) {
+		client := &remotewritemock.Client{}
+		client.On("Write", mock.Anything, mock.Anything).Return(nil).Once()
+		recorder := &MockRecorder{}
+		recorder.On("measureTooOldSamples", TooOldPolicyClamp, 3).Return().Once()
+
+		req := newRequest()
+		recent := req.Timeseries[0].TimeSeries
+		c := NewSampleAgeClient(client, clamp, recorder, func() time.Time { return now })
+		require.NoError(t, c.Write(context.Background(), req))
+
+		written := client.Calls[0].Arguments.Get(1).(*mimirpb.WriteRequest)
+		// The series without too old samples are written as they are.
+		require.Same(t, recent, written.Timeseries[0].TimeSeries)
+		require.Equal(t, int64(10_000), req.Timeseries[1].Samples[0].TimestampMs)
+		require.Equal(t, int64(30_000), req.Timeseries[2].Histograms[0].Timestamp)
+	})
+
+	t.Run("writes left empty are dropped", func(t *testing.T) {
+		old := func() *mimirpb.WriteRequest {
+			return &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{{TimeSeries: &mimirpb.TimeSeries{
+				Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "old"}},
+				Samples: []mimirpb.Sample{{TimestampMs: 10_000, Value: 1}},
+			}}}}
+		}
+		recorder := &MockRecorder{}
+		recorder.On("measureTooOldSamples", TooOldPolicyDrop, 1).Return().Twice()
+		drop := SampleAgeConfig{MaxAge: time.Minute, TooOldPolicy: TooOldPolicyDrop}
+
+		// The client has no expectations, so the write mustn't reach it.
+		var dropped string
+		c := NewSampleAgeClient(&remotewritemock.Client{}, drop, recorder, func() time.Time { return now })
+		require.NoError(t, WriteWithDeliveryHooks(context.Background(), c, old(), DeliveryHooks{
+			OnDropped: func(reason string, _ error) { dropped = reason },
+		}))
+		require.Equal(t, DropReasonTooOld, dropped)
+
+		// The metadata of the write is still written.
+		client := &remotewritemock.Client{}
+		defer client.AssertExpectations(t)
+		client.On("Write", mock.Anything, mock.Anything).Return(nil).Once().Run(func(args mock.Arguments) {
+			written := args.Get(1).(*mimirpb.WriteRequest)
+			require.Empty(t, written.Timeseries)
+			require.Len(t, written.Metadata, 1)
+		})
+		req := old()
+		req.Metadata = []*mimirpb.MetricMetadata{{MetricFamilyName: "old", Type: mimirpb.GAUGE}}
+		c = NewSampleAgeClient(client, drop, recorder, func() time.Time { return now })
+		require.NoError(t, c.Write(context.Background(), req))
+		recorder.AssertExpectations(t)
+	})
+}
+
+func TestSampleAgeConfigValidate(t *testing.T) {
+	require.NoError(t, (&SampleAgeConfig{MaxAge: time.Hour, TooOldPolicy: TooOldPolicyClamp}).Validate())
+	require.Error(t, (&SampleAgeConfig{MaxAge: -time.Hour, TooOldPolicy: TooOldPolicyClamp}).Validate())
+	require.Error(t, (&SampleAgeConfig{TooOldPolicy: "keep"}).Validate())
+}
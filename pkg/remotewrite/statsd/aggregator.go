@@ -0,0 +1,253 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite"
+)
+
+// Aggregator accumulates statsd metrics between flushes and turns them into
+// Prometheus series:
+//
+//   - counters are cumulative, so they can be used with rate();
+//   - gauges keep their last value;
+//   - timers (converted to seconds), histograms and distributions are
+//     aggregated into cumulative classic histograms with the configured
+//     buckets, from which percentiles can be computed with
+//     histogram_quantile();
+//   - sets are exported as the number of unique members seen since the last
+//     flush.
+//
+// A name and tags can only be used by one type of metric, the first one
+// seen, so a counter and a gauge don't write the same series. The series are
+// dropped after maxIdleFlushes flushes without updates, so the memory grows
+// with the series active recently rather than ever seen, and with the members
+// of the sets since the last flush. The number of series is limited to
+// maxSeries, if set: the metrics of new series fail with ErrSeriesLimit once
+// it's reached, until a flush drops some.
+type Aggregator struct {
+	buckets        []float64
+	maxIdleFlushes int
+	maxSeries      int
+
+	mtx sync.Mutex
+	// flushes is the number of flushes so far, the series are stamped with it
+	// when updated.
+	flushes int
+	// types is the type of metric owning each series key. Timers, histograms
+	// and distributions share the Histogram type.
+	types      map[string]MetricType
+	counters   map[string]*valueSeries
+	gauges     map[string]*valueSeries
+	histograms map[string]*histogramSeries
+	sets       map[string]*setSeries
+}
+
+type valueSeries struct {
+	lbls    labels.Labels
+	value   float64
+	updated int
+}
+
+type histogramSeries struct {
+	name    string
+	lbls    labels.Labels
+	updated int
+	// counts holds the number of observations per bucket, not cumulative. The
+	// last one is for the +Inf bucket.
+	counts []float64
+	sum    float64
+	count  float64
+}
+
+type setSeries struct {
+	lbls    labels.Labels
+	members map[string]struct{}
+}
+
+// ErrSeriesLimit is the error of Aggregator.Add for the metrics of new series
+// when the max number of series is reached.
+var ErrSeriesLimit = errors.New("statsd series limit reached")
+
+// NewAggregator creates an Aggregator using the given upper bounds for the
+// histogram buckets. The +Inf bucket is always added. The series are dropped
+// after maxIdleFlushes flushes without updates, 0 keeps them forever. At most
+// maxSeries series are aggregated, 0 for no limit.
+func NewAggregator(buckets []float64, maxIdleFlushes, maxSeries int) *Aggregator {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Aggregator{
+		buckets:        buckets,
+		maxIdleFlushes: maxIdleFlushes,
+		maxSeries:      maxSeries,
+		types:          map[string]MetricType{},
+		counters:       map[string]*valueSeries{},
+		gauges:         map[string]*valueSeries{},
+		histograms:     map[string]*histogramSeries{},
+		sets:           map[string]*setSeries{},
+	}
+}
+
+// Add accumulates a metric. It fails if the name and tags of m are already
+// used by another type of metric, and with ErrSeriesLimit if they're new and
+// the max number of series is reached.
+func (a *Aggregator) Add(m Metric) error {
+	name := remotewrite.SanitizeName(m.Name)
+	lbls := metricLabels(name, m.Tags)
+	key := lbls.String()
+	typ := m.Type
+	if typ == Timer || typ == Distribution {
+		typ = Histogram
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if owner, ok := a.types[key]; !ok {
+		if a.maxSeries > 0 && len(a.types) >= a.maxSeries {
+			return ErrSeriesLimit
+		}
+		a.types[key] = typ
+	} else if owner != typ {
+		return fmt.Errorf("%s is already used by a metric of type %q", key, owner)
+	}
+
+	switch m.Type {
+	case Counter:
+		s, ok := a.counters[key]
+		if !ok {
+			s = &valueSeries{lbls: lbls}
+			a.counters[key] = s
+		}
+		s.updated = a.flushes
+		s.value += m.Value / m.SampleRate
+	case Gauge:
+		s, ok := a.gauges[key]
+		if !ok {
+			s = &valueSeries{lbls: lbls}
+			a.gauges[key] = s
+		}
+		s.updated = a.flushes
+		if m.GaugeDelta {
+			s.value += m.Value
+		} else {
+			s.value = m.Value
+		}
+	case Timer, Histogram, Distribution:
+		s, ok := a.histograms[key]
+		if !ok {
+			s = &histogramSeries{name: name, lbls: lbls, counts: make([]float64, len(a.buckets)+1)}
+			a.histograms[key] = s
+		}
+		s.updated = a.flushes
+		v := m.Value
+		if m.Type == Timer {
+			v /= 1000
+		}
+		weight := 1 / m.SampleRate
+		s.counts[sort.SearchFloat64s(a.buckets, v)] += weight
+		s.sum += v * weight
+		s.count += weight
+	case Set:
+		s, ok := a.sets[key]
+		if !ok {
+			s = &setSeries{lbls: lbls, members: map[string]struct{}{}}
+			a.sets[key] = s
+		}
+		s.members[m.SetMember] = struct{}{}
+	}
+	return nil
+}
+
+// Flush returns the series for all the metrics seen so far, with a sample at
+// timestampMs, and resets the sets. The series idle for too long are dropped
+// first: counters and histograms updated again after that restart from 0,
+// which rate() handles as a reset.
+func (a *Aggregator) Flush(timestampMs int64) []mimirpb.PreallocTimeseries {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	a.flushes++
+	if a.maxIdleFlushes > 0 {
+		// The series updated since the previous flush have updated ==
+		// a.flushes-1.
+		idle := func(updated int) bool { return a.flushes-1-updated > a.maxIdleFlushes }
+		for key, s := range a.counters {
+			if idle(s.updated) {
+				delete(a.counters, key)
+				delete(a.types, key)
+			}
+		}
+		for key, s := range a.gauges {
+			if idle(s.updated) {
+				delete(a.gauges, key)
+				delete(a.types, key)
+			}
+		}
+		for key, s := range a.histograms {
+			if idle(s.updated) {
+				delete(a.histograms, key)
+				delete(a.types, key)
+			}
+		}
+	}
+
+	var series []mimirpb.PreallocTimeseries
+	add := func(lbls labels.Labels, value float64) {
+		series = append(series, mimirpb.PreallocTimeseries{TimeSeries: &mimirpb.TimeSeries{
+			Labels:  mimirpb.FromLabelsToLabelAdapters(lbls),
+			Samples: []mimirpb.Sample{{TimestampMs: timestampMs, Value: value}},
+		}})
+	}
+
+	for _, s := range a.counters {
+		add(s.lbls, s.value)
+	}
+	for _, s := range a.gauges {
+		add(s.lbls, s.value)
+	}
+	for _, s := range a.histograms {
+		b := labels.NewBuilder(s.lbls)
+		b.Set(labels.MetricName, s.name+"_bucket")
+		cumulative := 0.0
+		for i, count := range s.counts {
+			cumulative += count
+			le := math.Inf(1)
+			if i < len(a.buckets) {
+				le = a.buckets[i]
+			}
+			b.Set(labels.BucketLabel, strconv.FormatFloat(le, 'f', -1, 64))
+			add(b.Labels(), cumulative)
+		}
+		b.Del(labels.BucketLabel)
+		b.Set(labels.MetricName, s.name+"_sum")
+		add(b.Labels(), s.sum)
+		b.Set(labels.MetricName, s.name+"_count")
+		add(b.Labels(), s.count)
+	}
+	for key, s := range a.sets {
+		add(s.lbls, float64(len(s.members)))
+		delete(a.sets, key)
+		delete(a.types, key)
+	}
+	return series
+}
+
+func metricLabels(name string, tags map[string]string) labels.Labels {
+	b := labels.NewBuilder(nil)
+	for k, v := range tags {
+		if k = remotewrite.SanitizeName(k); k != "" && k != labels.MetricName {
+			b.Set(k, v)
+		}
+	}
+	b.Set(labels.MetricName, name)
+	return b.Labels()
+}
@@ -0,0 +1,152 @@
+package statsd
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregator(t *testing.T) {
+	a := NewAggregator([]float64{0.5, 0.1}, 0, 0)
+
+	for _, line := range []string{
+		"hits:1|c",
+		"hits:1|c|@0.5",
+		"hits:1|c|#env:prod",
+		"temp:20|g",
+		"temp:+5|g",
+		"latency:50|ms",
+		"latency:300|ms",
+		"latency:2000|ms",
+		"size:0.2|h",
+		"users:alice|s",
+		"users:bob|s",
+		"users:alice|s",
+	} {
+		m, err := ParseLine(line)
+		require.NoError(t, err)
+		require.NoError(t, a.Add(m))
+	}
+
+	require.Equal(t, []string{
+		`hits{env="prod"} 1`,
+		`hits{} 3`,
+		`latency_bucket{le="+Inf"} 3`,
+		`latency_bucket{le="0.1"} 1`,
+		`latency_bucket{le="0.5"} 2`,
+		`latency_count{} 3`,
+		`latency_sum{} 2.35`,
+		`size_bucket{le="+Inf"} 1`,
+		`size_bucket{le="0.1"} 0`,
+		`size_bucket{le="0.5"} 1`,
+		`size_count{} 1`,
+		`size_sum{} 0.2`,
+		`temp{} 25`,
+		`users{} 2`,
+	}, formatSeries(t, a.Flush(1000), 1000))
+
+	// Counters and histograms are cumulative, gauges keep their value and sets
+	// are reset.
+	m, err := ParseLine("hits:2|c")
+	require.NoError(t, err)
+	require.NoError(t, a.Add(m))
+	flushed := formatSeries(t, a.Flush(2000), 2000)
+	require.Contains(t, flushed, `hits{} 5`)
+	require.Contains(t, flushed, `temp{} 25`)
+	require.Contains(t, flushed, `latency_count{} 3`)
+	require.NotContains(t, flushed, `users{} 2`)
+}
+
+func TestAggregator_TypeConflicts(t *testing.T) {
+	a := NewAggregator(nil, 0, 0)
+	add := func(line string) error {
+		m, err := ParseLine(line)
+		require.NoError(t, err)
+		return a.Add(m)
+	}
+
+	require.NoError(t, add("hits:1|c"))
+	require.Error(t, add("hits:5|g"))
+	require.Error(t, add("hits:alice|s"))
+	require.NoError(t, add("hits:5|g|#env:prod"))
+	// Timers, histograms and distributions share the same series.
+	require.NoError(t, add("latency:100|ms"))
+	require.NoError(t, add("latency:0.2|h"))
+	require.Error(t, add("latency:1|c"))
+
+	require.Equal(t, []string{
+		`hits{env="prod"} 5`,
+		`hits{} 1`,
+		`latency_bucket{le="+Inf"} 2`,
+		`latency_count{} 2`,
+		`latency_sum{} 0.30000000000000004`,
+	}, formatSeries(t, a.Flush(1000), 1000))
+}
+
+func TestAggregator_IdleSeries(t *testing.T) {
+	a := NewAggregator(nil, 2, 0)
+	add := func(line string) {
+		m, err := ParseLine(line)
+		require.NoError(t, err)
+		require.NoError(t, a.Add(m))
+	}
+
+	add("hits:1|c")
+	add("temp:20|g")
+	require.Equal(t, []string{`hits{} 1`, `temp{} 20`}, formatSeries(t, a.Flush(1000), 1000))
+
+	// The series are still written for 2 flushes without updates.
+	add("temp:21|g")
+	require.Equal(t, []string{`hits{} 1`, `temp{} 21`}, formatSeries(t, a.Flush(2000), 2000))
+	require.Equal(t, []string{`hits{} 1`, `temp{} 21`}, formatSeries(t, a.Flush(3000), 3000))
+	require.Equal(t, []string{`temp{} 21`}, formatSeries(t, a.Flush(4000), 4000))
+	require.Empty(t, a.Flush(5000))
+
+	// Evicted series start over, and their name can be used by another type.
+	add("hits:1|g")
+	require.Equal(t, []string{`hits{} 1`}, formatSeries(t, a.Flush(6000), 6000))
+}
+
+func TestAggregator_SeriesLimit(t *testing.T) {
+	a := NewAggregator(nil, 1, 2)
+	add := func(line string) error {
+		m, err := ParseLine(line)
+		require.NoError(t, err)
+		return a.Add(m)
+	}
+
+	require.NoError(t, add("hits:1|c"))
+	require.NoError(t, add("users:alice|s"))
+	require.ErrorIs(t, add("temp:20|g"), ErrSeriesLimit)
+	// The series already aggregated are still updated.
+	require.NoError(t, add("hits:2|c"))
+	require.Equal(t, []string{`hits{} 3`, `users{} 1`}, formatSeries(t, a.Flush(1000), 1000))
+
+	// The flushes make room by dropping the sets and the idle series.
+	require.NoError(t, add("temp:20|g"))
+	require.ErrorIs(t, add("users:bob|s"), ErrSeriesLimit)
+	a.Flush(2000)
+	a.Flush(3000)
+	require.NoError(t, add("users:bob|s"))
+}
+
+// formatSeries formats the series as sorted `name{labels} value` strings,
+// checking that all samples have the given timestamp.
+func formatSeries(t *testing.T, series []mimirpb.PreallocTimeseries, timestampMs int64) []string {
+	out := make([]string, 0, len(series))
+	for _, s := range series {
+		lbls := mimirpb.FromLabelAdaptersToLabels(s.Labels)
+		require.Len(t, s.Samples, 1)
+		require.Equal(t, timestampMs, s.Samples[0].TimestampMs)
+		out = append(out, lbls.Get("__name__")+lbls.DropMetricName().String()+" "+formatValue(s.Samples[0].Value))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,118 @@
+package statsd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/server"
+)
+
+const (
+	defaultFlushInterval  = 10 * time.Second
+	defaultMaxLineLength  = 16 * 1024
+	defaultMaxIdleFlushes = 360
+
+	// SeriesLimitBlock and SeriesLimitShed are the policies of the listeners
+	// when the max number of series is reached: stop reading until a flush
+	// makes room, or drop the metrics of new series.
+	SeriesLimitBlock = "block"
+	SeriesLimitShed  = "shed"
+)
+
+type Config struct {
+	UDPListenAddress string        `yaml:"udp_listen_address"`
+	TCPListenAddress string        `yaml:"tcp_listen_address"`
+	FlushInterval    time.Duration `yaml:"flush_interval"`
+	// HistogramBuckets is a comma separated list of bucket upper bounds used
+	// for timers, in seconds, histograms and distributions.
+	HistogramBuckets string `yaml:"histogram_buckets"`
+	// Tenant, if set, is injected in the context of the remote writes.
+	Tenant        string `yaml:"tenant"`
+	MaxLineLength int    `yaml:"max_line_length"`
+	// MaxIdleFlushes is how many flushes a series is kept for without being
+	// updated, 0 keeps the series forever.
+	MaxIdleFlushes int `yaml:"max_idle_flushes"`
+
+	// MaxSeries bounds the series aggregated between flushes, 0 for no
+	// limit. When it's reached, each listener blocks or sheds according to
+	// its policy.
+	MaxSeries            int    `yaml:"max_series"`
+	UDPSeriesLimitPolicy string `yaml:"udp_series_limit_policy"`
+	TCPSeriesLimitPolicy string `yaml:"tcp_series_limit_policy"`
+
+	// Listen is how the listeners are opened, set from the server config of
+	// the app.
+	Listen server.ListenConfig `yaml:"-"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *Config) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *Config) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	flags.StringVar(&c.UDPListenAddress, prefix+"statsd.udp-listen-address", ":8125", "UDP address to accept statsd metrics on. Empty to disable.")
+	flags.StringVar(&c.TCPListenAddress, prefix+"statsd.tcp-listen-address", "", "TCP address to accept statsd metrics on. Empty to disable.")
+	flags.DurationVar(&c.FlushInterval, prefix+"statsd.flush-interval", defaultFlushInterval, "How often the aggregated statsd metrics are written.")
+	flags.StringVar(&c.HistogramBuckets, prefix+"statsd.histogram-buckets", ".005,.010,.025,.050,.100,.250,.500,1,2.5,5,10", "Buckets for timers, histograms and distributions, comma separated list of floats. Timers are converted to seconds.")
+	flags.StringVar(&c.Tenant, prefix+"statsd.tenant", "", "Tenant the statsd metrics are written for.")
+	flags.IntVar(&c.MaxLineLength, prefix+"statsd.max-line-length", defaultMaxLineLength, "Max length in bytes of a line received over TCP, including its newline. Connections sending longer lines are closed.")
+	flags.IntVar(&c.MaxIdleFlushes, prefix+"statsd.max-idle-flushes", defaultMaxIdleFlushes, "How many flushes a series is still written for after its last update. Counters and histograms updated again after that restart from 0. 0 keeps the series forever.")
+	flags.IntVar(&c.MaxSeries, prefix+"statsd.max-series", 0, "Max number of series aggregated between flushes. When it's reached, each listener blocks or sheds the metrics of new series according to its policy. 0 for no limit.")
+	flags.StringVar(&c.UDPSeriesLimitPolicy, prefix+"statsd.udp-series-limit-policy", SeriesLimitShed, "What the UDP listener does with the metrics of new series when statsd.max-series is reached: shed drops them and counts them, block stops reading packets until a flush makes room, and the kernel drops them once its buffer is full.")
+	flags.StringVar(&c.TCPSeriesLimitPolicy, prefix+"statsd.tcp-series-limit-policy", SeriesLimitBlock, "What the TCP listener does with the metrics of new series when statsd.max-series is reached: block stops reading from the connection until a flush makes room, pushing back on the sender, shed drops them and counts them.")
+}
+
+// Validate checks that at least one listener is enabled, the limits and that
+// the buckets can be parsed.
+func (c *Config) Validate() error {
+	if c.UDPListenAddress == "" && c.TCPListenAddress == "" {
+		return errors.New("at least one statsd listen address must be set")
+	}
+	if c.FlushInterval <= 0 {
+		return errors.New("statsd flush interval must be positive")
+	}
+	if c.TCPListenAddress != "" && c.MaxLineLength <= 0 {
+		return errors.New("statsd max line length must be positive when the TCP listener is enabled")
+	}
+	if c.MaxIdleFlushes < 0 {
+		return errors.New("statsd max idle flushes can't be negative")
+	}
+	if c.MaxSeries < 0 {
+		return errors.New("statsd max series can't be negative")
+	}
+	for _, policy := range []string{c.UDPSeriesLimitPolicy, c.TCPSeriesLimitPolicy} {
+		if policy != "" && policy != SeriesLimitBlock && policy != SeriesLimitShed {
+			return fmt.Errorf("unknown statsd series limit policy %q, must be %s or %s", policy, SeriesLimitBlock, SeriesLimitShed)
+		}
+	}
+	if _, err := c.buckets(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Config) buckets() ([]float64, error) {
+	if c.HistogramBuckets == "" {
+		return nil, nil
+	}
+	strs := strings.Split(c.HistogramBuckets, ",")
+	buckets := make([]float64, len(strs))
+	for i, s := range strs {
+		var err error
+		if buckets[i], err = strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+			return nil, fmt.Errorf("can't parse statsd histogram bucket %d: %w", i, err)
+		}
+	}
+	return buckets, nil
+}
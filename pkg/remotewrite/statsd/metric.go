@@ -0,0 +1,98 @@
+// Package statsd implements a statsd listener that aggregates counters,
+// gauges, timers, histograms and sets over a flush interval and writes them
+// through a remotewrite.Client.
+package statsd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MetricType is the type of a statsd metric, as sent after the first "|".
+type MetricType string
+
+const (
+	Counter      MetricType = "c"
+	Gauge        MetricType = "g"
+	Timer        MetricType = "ms"
+	Histogram    MetricType = "h"
+	Distribution MetricType = "d"
+	Set          MetricType = "s"
+)
+
+// Metric is a single statsd sample.
+type Metric struct {
+	Name string
+	Type MetricType
+	// Value is the sample value. It's unused for sets, which use SetMember.
+	Value     float64
+	SetMember string
+	// GaugeDelta is set for gauges sent with an explicit sign, which change
+	// the current value instead of replacing it.
+	GaugeDelta bool
+	// SampleRate is the "@rate" the client sampled the metric at, 1 if unset.
+	SampleRate float64
+	// Tags are the DogStatsD "#key:value" tags of the metric.
+	Tags map[string]string
+}
+
+// ParseLine parses a statsd line, "<name>:<value>|<type>[|@<rate>][|#<tags>]".
+func ParseLine(line string) (Metric, error) {
+	parts := strings.Split(line, "|")
+	if len(parts) < 2 {
+		return Metric{}, fmt.Errorf("expected \"<name>:<value>|<type>\", got %q", line)
+	}
+	sep := strings.LastIndexByte(parts[0], ':')
+	if sep <= 0 {
+		return Metric{}, fmt.Errorf("missing metric name or value in %q", line)
+	}
+	m := Metric{
+		Name:       parts[0][:sep],
+		Type:       MetricType(parts[1]),
+		SampleRate: 1,
+	}
+	value := parts[0][sep+1:]
+
+	switch m.Type {
+	case Set:
+		m.SetMember = value
+	case Counter, Gauge, Timer, Histogram, Distribution:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return Metric{}, fmt.Errorf("invalid value %q: %w", value, err)
+		}
+		m.Value = v
+		m.GaugeDelta = m.Type == Gauge && (value[0] == '+' || value[0] == '-')
+	default:
+		return Metric{}, fmt.Errorf("unknown metric type %q", parts[1])
+	}
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return Metric{}, fmt.Errorf("invalid sample rate %q", part)
+			}
+			m.SampleRate = rate
+		case strings.HasPrefix(part, "#"):
+			m.Tags = parseTags(part[1:])
+		default:
+			return Metric{}, fmt.Errorf("unexpected field %q", part)
+		}
+	}
+	return m, nil
+}
+
+func parseTags(s string) map[string]string {
+	tags := map[string]string{}
+	for _, tag := range strings.Split(s, ",") {
+		if tag == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(tag, ":")
+		tags[k] = v
+	}
+	return tags
+}
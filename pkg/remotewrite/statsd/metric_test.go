@@ -0,0 +1,70 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	for name, tc := range map[string]struct {
+		line    string
+		want    Metric
+		wantErr string
+	}{
+		"counter": {
+			line: "page.views:1|c",
+			want: Metric{Name: "page.views", Type: Counter, Value: 1, SampleRate: 1},
+		},
+		"sampled counter": {
+			line: "page.views:2|c|@0.1",
+			want: Metric{Name: "page.views", Type: Counter, Value: 2, SampleRate: 0.1},
+		},
+		"gauge": {
+			line: "queue.size:42|g",
+			want: Metric{Name: "queue.size", Type: Gauge, Value: 42, SampleRate: 1},
+		},
+		"gauge delta": {
+			line: "queue.size:-3|g",
+			want: Metric{Name: "queue.size", Type: Gauge, Value: -3, GaugeDelta: true, SampleRate: 1},
+		},
+		"timer with tags": {
+			line: "request.duration:320|ms|#env:prod,dc:eu",
+			want: Metric{Name: "request.duration", Type: Timer, Value: 320, SampleRate: 1, Tags: map[string]string{"env": "prod", "dc": "eu"}},
+		},
+		"set": {
+			line: "users:alice|s",
+			want: Metric{Name: "users", Type: Set, SetMember: "alice", SampleRate: 1},
+		},
+		"missing type": {
+			line:    "page.views:1",
+			wantErr: "expected",
+		},
+		"unknown type": {
+			line:    "page.views:1|x",
+			wantErr: "unknown metric type",
+		},
+		"invalid value": {
+			line:    "page.views:one|c",
+			wantErr: "invalid value",
+		},
+		"invalid sample rate": {
+			line:    "page.views:1|c|@2",
+			wantErr: "invalid sample rate",
+		},
+		"missing name": {
+			line:    ":1|c",
+			wantErr: "missing metric name",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseLine(tc.line)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package statsd
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//go:generate mockery --inpackage --testonly --case underscore --name Recorder
+type Recorder interface {
+	measureReceivedMetrics(count int)
+	measureRejectedMetrics(reason string, count int)
+	measureFlush(series int, duration time.Duration, err error)
+}
+
+// NewRecorder returns a new Prometheus metrics Recorder.
+// It ensures that the statsd listener metrics are properly registered.
+func NewRecorder(prefix string, reg prometheus.Registerer) Recorder {
+	r := &prometheusRecorder{
+		receivedMetrics: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "statsd_received_metrics_total",
+			Help:      "The total number of statsd metrics aggregated, excluding rejected metrics.",
+		}),
+		rejectedMetrics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "statsd_rejected_metrics_total",
+			Help:      "The total number of statsd metrics rejected, because they couldn't be parsed, conflict with the type of an existing series, are on a line too long or are of new series beyond the max number of series.",
+		}, []string{"reason"}),
+		flushedSeries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "statsd_flushed_series_total",
+			Help:      "The total number of series written by statsd flushes.",
+		}),
+		flushDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: prefix,
+			Name:      "statsd_flush_duration_seconds",
+			Help:      "Duration of the statsd flushes.",
+		}, []string{"result"}),
+	}
+
+	reg.MustRegister(r.receivedMetrics, r.rejectedMetrics, r.flushedSeries, r.flushDuration)
+
+	return r
+}
+
+type prometheusRecorder struct {
+	receivedMetrics prometheus.Counter
+	rejectedMetrics *prometheus.CounterVec
+	flushedSeries   prometheus.Counter
+	flushDuration   *prometheus.HistogramVec
+}
+
+func (r prometheusRecorder) measureReceivedMetrics(count int) {
+	r.receivedMetrics.Add(float64(count))
+}
+
+func (r prometheusRecorder) measureRejectedMetrics(reason string, count int) {
+	r.rejectedMetrics.WithLabelValues(reason).Add(float64(count))
+}
+
+func (r prometheusRecorder) measureFlush(series int, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	} else {
+		r.flushedSeries.Add(float64(series))
+	}
+	r.flushDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
@@ -0,0 +1,304 @@
+package statsd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite"
+	"github.com/grafana/mimir-graphite/v2/pkg/server"
+)
+
+const maxUDPPacketSize = 64 * 1024
+
+// Server accepts statsd metrics over UDP and TCP, aggregates them and writes
+// the aggregated series through a remotewrite.Client on every flush interval.
+//
+// If Config.MaxSeries is set and reached, the listeners with the block policy
+// stop reading the metrics of new series until a flush makes room, so the
+// TCP senders are pushed back on, and the others drop them.
+type Server struct {
+	cfg        Config
+	client     remotewrite.Client
+	aggregator *Aggregator
+	recorder   Recorder
+	logger     log.Logger
+
+	udpConn     net.PacketConn
+	tcpListener net.Listener
+
+	writeCtx context.Context
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	connsMtx sync.Mutex
+	conns    map[net.Conn]struct{}
+
+	// flushed is closed and replaced on every flush, waking up the listeners
+	// waiting for room in the aggregator.
+	flushedMtx sync.Mutex
+	flushed    chan struct{}
+}
+
+// NewServer opens the listeners enabled in cfg, so it fails early if an
+// address is in use. Call Run to start accepting metrics.
+func NewServer(cfg Config, client remotewrite.Client, recorder Recorder, logger log.Logger) (_ *Server, err error) {
+	buckets, err := cfg.buckets()
+	if err != nil {
+		return nil, err
+	}
+
+	writeCtx := context.Background()
+	if cfg.Tenant != "" {
+		writeCtx = user.InjectOrgID(writeCtx, cfg.Tenant)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Server{
+		cfg:        cfg,
+		client:     client,
+		aggregator: NewAggregator(buckets, cfg.MaxIdleFlushes, cfg.MaxSeries),
+		recorder:   recorder,
+		logger:     logger,
+		writeCtx:   writeCtx,
+		ctx:        ctx,
+		cancel:     cancel,
+		conns:      map[net.Conn]struct{}{},
+		flushed:    make(chan struct{}),
+	}
+	defer func() {
+		if err != nil {
+			s.close()
+		}
+	}()
+
+	if cfg.UDPListenAddress != "" {
+		if s.udpConn, err = server.ListenPacket(cfg.Listen, "statsd-udp", "udp", cfg.UDPListenAddress); err != nil {
+			return nil, err
+		}
+		level.Info(logger).Log("msg", "statsd listening", "addr", s.udpConn.LocalAddr().String(), "protocol", "udp")
+	}
+	if cfg.TCPListenAddress != "" {
+		if s.tcpListener, err = server.Listen(cfg.Listen, "statsd-tcp", "tcp", cfg.TCPListenAddress); err != nil {
+			return nil, err
+		}
+		level.Info(logger).Log("msg", "statsd listening", "addr", s.tcpListener.Addr().String(), "protocol", "tcp")
+	}
+	return s, nil
+}
+
+// UDPAddr returns the address of the UDP listener, or nil if it's disabled.
+func (s *Server) UDPAddr() net.Addr {
+	if s.udpConn == nil {
+		return nil
+	}
+	return s.udpConn.LocalAddr()
+}
+
+// TCPAddr returns the address of the TCP listener, or nil if it's disabled.
+func (s *Server) TCPAddr() net.Addr {
+	if s.tcpListener == nil {
+		return nil
+	}
+	return s.tcpListener.Addr()
+}
+
+// Handler returns two functions to run and stop the server.
+func (s *Server) Handler() (run func() error, stop func(error)) {
+	return s.Run, s.Stop
+}
+
+// Run accepts and flushes metrics until Stop is called. The metrics received
+// since the last flush are flushed before returning.
+func (s *Server) Run() error {
+	if s.udpConn != nil {
+		s.wg.Add(1)
+		go s.serveUDP()
+	}
+	if s.tcpListener != nil {
+		s.wg.Add(1)
+		go s.acceptTCP()
+	}
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.ctx.Done():
+			s.wg.Wait()
+			s.Flush()
+			return nil
+		}
+	}
+}
+
+// Stop closes the listeners and all open connections.
+func (s *Server) Stop(_ error) {
+	s.close()
+}
+
+// Flush writes the aggregated series.
+func (s *Server) Flush() {
+	series := s.aggregator.Flush(time.Now().UnixMilli())
+	s.flushedMtx.Lock()
+	close(s.flushed)
+	s.flushed = make(chan struct{})
+	s.flushedMtx.Unlock()
+	if len(series) == 0 {
+		return
+	}
+
+	start := time.Now()
+	err := s.client.Write(s.writeCtx, &mimirpb.WriteRequest{Timeseries: series, Source: mimirpb.API})
+	s.recorder.measureFlush(len(series), time.Since(start), err)
+	if err != nil {
+		level.Error(s.logger).Log("msg", "can't write statsd metrics", "series", len(series), "err", err)
+	}
+}
+
+func (s *Server) close() {
+	s.cancel()
+	if s.udpConn != nil {
+		_ = s.udpConn.Close()
+	}
+	if s.tcpListener != nil {
+		_ = s.tcpListener.Close()
+	}
+
+	s.connsMtx.Lock()
+	defer s.connsMtx.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, _, err := s.udpConn.ReadFrom(buf)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				level.Error(s.logger).Log("msg", "statsd udp listener stopped reading", "err", err)
+			}
+			return
+		}
+		block := s.cfg.UDPSeriesLimitPolicy == SeriesLimitBlock
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			s.handleLine(line, block)
+		}
+	}
+}
+
+func (s *Server) acceptTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcpListener.Accept()
+		if err != nil {
+			if s.ctx.Err() == nil {
+				level.Error(s.logger).Log("msg", "statsd listener stopped accepting connections", "err", err)
+			}
+			return
+		}
+
+		s.connsMtx.Lock()
+		if s.ctx.Err() != nil {
+			s.connsMtx.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.connsMtx.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn)
+
+			s.connsMtx.Lock()
+			delete(s.conns, conn)
+			s.connsMtx.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	reader := bufio.NewReaderSize(conn, s.cfg.MaxLineLength)
+	block := s.cfg.TCPSeriesLimitPolicy != SeriesLimitShed
+	for {
+		line, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			s.recorder.measureRejectedMetrics("line_too_long", 1)
+			level.Warn(s.logger).Log("msg", "closing statsd connection", "remote", conn.RemoteAddr().String(), "err", fmt.Errorf("line longer than the max of %d bytes", s.cfg.MaxLineLength))
+			return
+		}
+		s.handleLine(string(line), block)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && s.ctx.Err() == nil {
+				level.Warn(s.logger).Log("msg", "closing statsd connection", "remote", conn.RemoteAddr().String(), "err", err)
+			}
+			return
+		}
+	}
+}
+
+// handleLine aggregates the metric of line. If block is set, it waits for
+// room in the aggregator when the max number of series is reached, until the
+// server stops.
+func (s *Server) handleLine(line string, block bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	m, err := ParseLine(line)
+	if err != nil {
+		s.recorder.measureRejectedMetrics("invalid_line", 1)
+		level.Debug(s.logger).Log("msg", "can't parse statsd line", "line", line, "err", err)
+		return
+	}
+	if err := s.add(m, block); errors.Is(err, ErrSeriesLimit) {
+		s.recorder.measureRejectedMetrics("series_limit", 1)
+		level.Debug(s.logger).Log("msg", "dropped statsd metric", "line", line, "err", err)
+		return
+	} else if err != nil {
+		s.recorder.measureRejectedMetrics("type_conflict", 1)
+		level.Debug(s.logger).Log("msg", "rejected statsd metric", "line", line, "err", err)
+		return
+	}
+	s.recorder.measureReceivedMetrics(1)
+}
+
+// add adds m to the aggregator, waiting for the flushes to make room for it
+// if block is set, until the server stops.
+func (s *Server) add(m Metric, block bool) error {
+	for {
+		s.flushedMtx.Lock()
+		flushed := s.flushed
+		s.flushedMtx.Unlock()
+
+		err := s.aggregator.Add(m)
+		if !block || !errors.Is(err, ErrSeriesLimit) {
+			return err
+		}
+		select {
+		case <-flushed:
+		case <-s.ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+)
+
+func TestServer(t *testing.T) {
+	written := make(chan *mimirpb.WriteRequest, 1)
+	client := &remotewritemock.Client{}
+	client.On("Write", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		tenant, err := user.ExtractOrgID(args.Get(0).(context.Context))
+		require.NoError(t, err)
+		require.Equal(t, "tenant-1", tenant)
+		written <- args.Get(1).(*mimirpb.WriteRequest)
+	})
+
+	recorder := NewMockRecorder(t)
+	handled := make(chan struct{}, 4)
+	recorder.On("measureReceivedMetrics", 1).Return().Times(3).Run(func(mock.Arguments) { handled <- struct{}{} })
+	recorder.On("measureRejectedMetrics", "invalid_line", 1).Return().Once().Run(func(mock.Arguments) { handled <- struct{}{} })
+	recorder.On("measureFlush", 2, mock.Anything, nil).Return().Once()
+
+	s, err := NewServer(Config{
+		UDPListenAddress: "127.0.0.1:0",
+		TCPListenAddress: "127.0.0.1:0",
+		FlushInterval:    time.Hour,
+		HistogramBuckets: "",
+		Tenant:           "tenant-1",
+		MaxLineLength:    1024,
+	}, client, recorder, log.NewNopLogger())
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() { done <- s.Run() }()
+
+	udp, err := net.Dial("udp", s.UDPAddr().String())
+	require.NoError(t, err)
+	_, err = fmt.Fprint(udp, "hits:1|c\nnot a metric")
+	require.NoError(t, err)
+	require.NoError(t, udp.Close())
+
+	tcp, err := net.Dial("tcp", s.TCPAddr().String())
+	require.NoError(t, err)
+	_, err = fmt.Fprint(tcp, "hits:2|c\ntemp:20|g\n")
+	require.NoError(t, err)
+	require.NoError(t, tcp.Close())
+
+	for i := 0; i < 4; i++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the metrics to be handled")
+		}
+	}
+
+	// Stopping the server flushes the pending metrics.
+	s.Stop(nil)
+	require.NoError(t, <-done)
+
+	req := <-written
+	require.Equal(t, []string{`hits{} 3`, `temp{} 20`}, formatSeries(t, req.Timeseries, req.Timeseries[0].Samples[0].TimestampMs))
+}
+
+func TestServerSeriesLimit(t *testing.T) {
+	client := &remotewritemock.Client{}
+	client.On("Write", mock.Anything, mock.Anything).Return(nil)
+	received, shed := make(chan struct{}, 2), make(chan struct{}, 1)
+	recorder := NewMockRecorder(t)
+	recorder.On("measureReceivedMetrics", 1).Return().Run(func(mock.Arguments) { received <- struct{}{} })
+	recorder.On("measureRejectedMetrics", "series_limit", 1).Return().Once().Run(func(mock.Arguments) { shed <- struct{}{} })
+	recorder.On("measureFlush", mock.Anything, mock.Anything, nil).Return()
+
+	s, err := NewServer(Config{
+		UDPListenAddress:     "127.0.0.1:0",
+		TCPListenAddress:     "127.0.0.1:0",
+		FlushInterval:        time.Hour,
+		MaxLineLength:        1024,
+		MaxIdleFlushes:       1,
+		MaxSeries:            1,
+		UDPSeriesLimitPolicy: SeriesLimitShed,
+		TCPSeriesLimitPolicy: SeriesLimitBlock,
+	}, client, recorder, log.NewNopLogger())
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() { done <- s.Run() }()
+	wait := func(c chan struct{}, what string) {
+		select {
+		case <-c:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+
+	// The UDP metrics of new series are dropped once the limit is reached.
+	udp, err := net.Dial("udp", s.UDPAddr().String())
+	require.NoError(t, err)
+	_, err = fmt.Fprint(udp, "hits:1|c\nmisses:1|c")
+	require.NoError(t, err)
+	require.NoError(t, udp.Close())
+	wait(received, "the first metric")
+	wait(shed, "the shed metric")
+
+	// The TCP ones wait for the flushes to evict the idle series.
+	tcp, err := net.Dial("tcp", s.TCPAddr().String())
+	require.NoError(t, err)
+	_, err = fmt.Fprint(tcp, "temp:20|g\n")
+	require.NoError(t, err)
+	s.Flush()
+	s.Flush()
+	select {
+	case <-received:
+		t.Fatal("metric of a new series aggregated beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.Flush()
+	wait(received, "the blocked metric")
+	require.NoError(t, tcp.Close())
+
+	s.Stop(nil)
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,236 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+	"github.com/grafana/mimir-graphite/v2/pkg/graphite/convert"
+)
+
+// TenantRoutingConfig routes the series written to a shared endpoint to the
+// tenants of the teams they belong to, by Graphite prefix or label selector.
+// The series matching no route are written to the tenant of the request.
+type TenantRoutingConfig struct {
+	GraphitePrefixes flagext.LimitsMap[string] `yaml:"graphite_prefixes"`
+	Selectors        flagext.LimitsMap[string] `yaml:"selectors"`
+	// SourceTenants are the tenants whose requests are routed, the ones of
+	// the shared endpoints. The requests of the other tenants are written
+	// to their own tenant, so they can't write to the tenants of the routes.
+	SourceTenants flagext.StringSliceCSV `yaml:"source_tenants"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (c *TenantRoutingConfig) RegisterFlags(flags *flag.FlagSet) {
+	c.RegisterFlagsWithPrefix("", flags)
+}
+
+// RegisterFlagsWithPrefix registers flags, adding the provided prefix if
+// needed. If the prefix is not blank and doesn't end with '.', a '.' is
+// appended to it.
+func (c *TenantRoutingConfig) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	c.GraphitePrefixes = flagext.NewLimitsMap[string](nil)
+	c.Selectors = flagext.NewLimitsMap[string](nil)
+	flags.Var(&c.GraphitePrefixes, prefix+"tenant-routing.graphite-prefixes", "Tenant the Graphite series are written to by prefix of their name, as a JSON object of prefix to tenant, e.g. {\"team-a.\": \"team-a\"}. The longest matching prefix wins.")
+	flags.Var(&c.Selectors, prefix+"tenant-routing.selectors", "Tenant the series are written to by label selector, as a JSON object of selector to tenant, e.g. {\"{job=\\\"billing\\\"}\": \"team-b\"}. Only used for the series matching no Graphite prefix, the first matching selector in lexicographic order wins.")
+	flags.Var(&c.SourceTenants, prefix+"tenant-routing.source-tenants", "Comma separated list of the tenants whose requests are routed, e.g. the tenant of a shared ingestion endpoint. Required if there are routes: the requests of the other tenants are written to their own tenant.")
+}
+
+// Validate checks the tenants are set and the selectors can be parsed.
+func (c *TenantRoutingConfig) Validate() error {
+	routes, err := c.routes()
+	if err != nil {
+		return err
+	}
+	if len(routes) > 0 && len(c.SourceTenants) == 0 {
+		return errors.New("tenant routing source tenants must be set when there are routes")
+	}
+	return nil
+}
+
+// tenantRoute routes the series matching either prefix or matchers.
+type tenantRoute struct {
+	tenant   string
+	prefix   string
+	matchers []*labels.Matcher
+}
+
+// routes returns the routes in the order they're tried.
+func (c *TenantRoutingConfig) routes() ([]tenantRoute, error) {
+	var routes []tenantRoute
+	for prefix, tenant := range c.GraphitePrefixes.Read() {
+		if prefix == "" || tenant == "" {
+			return nil, fmt.Errorf("tenant routing graphite prefix %q and its tenant %q can't be empty", prefix, tenant)
+		}
+		routes = append(routes, tenantRoute{tenant: tenant, prefix: prefix})
+	}
+	sort.Slice(routes, func(i, j int) bool { return len(routes[i].prefix) > len(routes[j].prefix) })
+
+	selectors := make([]string, 0, len(c.Selectors.Read()))
+	for selector := range c.Selectors.Read() {
+		selectors = append(selectors, selector)
+	}
+	sort.Strings(selectors)
+	for _, selector := range selectors {
+		tenant := c.Selectors.Read()[selector]
+		if tenant == "" {
+			return nil, fmt.Errorf("tenant of tenant routing selector %q can't be empty", selector)
+		}
+		matchers, err := parser.ParseMetricSelector(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tenant routing selector %q: %w", selector, err)
+		}
+		routes = append(routes, tenantRoute{tenant: tenant, matchers: matchers})
+	}
+	return routes, nil
+}
+
+func (r tenantRoute) matches(lbls []mimirpb.LabelAdapter) bool {
+	if r.prefix != "" {
+		name, ok := graphiteName(lbls)
+		return ok && strings.HasPrefix(name, r.prefix)
+	}
+	for _, m := range r.matchers {
+		if !m.Matches(labelValue(lbls, m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// graphiteName returns the Graphite name of the series, and false if it
+// isn't a Graphite series.
+func graphiteName(lbls []mimirpb.LabelAdapter) (string, bool) {
+	switch labelValue(lbls, labels.MetricName) {
+	case convert.TaggedMetricName:
+		return labelValue(lbls, "name"), true
+	case convert.UntaggedMetricName:
+		// The nodes are sorted, __n000__ first.
+		var nodes []string
+		for _, l := range lbls {
+			if nodeLabel.MatchString(l.Name) {
+				nodes = append(nodes, l.Value)
+			}
+		}
+		return strings.Join(nodes, "."), true
+	default:
+		return "", false
+	}
+}
+
+// nodeLabel matches the names of the labels of the nodes of untagged
+// Graphite series.
+var nodeLabel = regexp.MustCompile(`^__n[0-9]{3}__$`)
+
+func labelValue(lbls []mimirpb.LabelAdapter, name string) string {
+	for _, l := range lbls {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// TenantRoutingClient writes the series of each request of the source
+// tenants to the tenants of their routes, and the others to the tenant of the
+// request, so a single ingestion endpoint can fan the data out to the tenants
+// of several teams.
+type TenantRoutingClient struct {
+	client        Client
+	routes        []tenantRoute
+	sourceTenants map[string]struct{}
+}
+
+// NewTenantRoutingClient wraps client to route the series as configured in
+// cfg, or returns client as is if there's no route.
+func NewTenantRoutingClient(client Client, cfg TenantRoutingConfig) (Client, error) {
+	routes, err := cfg.routes()
+	if err != nil {
+		return nil, err
+	}
+	if len(routes) == 0 {
+		return client, nil
+	}
+	sourceTenants := make(map[string]struct{}, len(cfg.SourceTenants))
+	for _, tenant := range cfg.SourceTenants {
+		sourceTenants[tenant] = struct{}{}
+	}
+	return &TenantRoutingClient{client: client, routes: routes, sourceTenants: sourceTenants}, nil
+}
+
+// Write writes the series of req to their tenants in parallel, or all of
+// them to the tenant of the request if it isn't a source tenant. The metadata
+// are written to the tenant of the request. The error of the first tenant
+// failing, in the order of their first series, is returned.
+//
+// The writes to the other tenants aren't undone when one fails, so retrying
+// the request writes their series again. Mimir accepts the samples written
+// again with the same values, so the whole request can be retried.
+func (c *TenantRoutingClient) Write(ctx context.Context, req *mimirpb.WriteRequest) error {
+	tenant, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return errorx.BadRequest{Msg: "can't route write request to tenants", Err: err}
+	}
+	if _, ok := c.sourceTenants[tenant]; !ok {
+		return c.client.Write(ctx, req)
+	}
+
+	// The tenants in the order of their first series.
+	var tenants []string
+	series := map[string][]mimirpb.PreallocTimeseries{}
+	for _, ts := range req.Timeseries {
+		to := tenant
+		for _, r := range c.routes {
+			if r.matches(ts.Labels) {
+				to = r.tenant
+				break
+			}
+		}
+		if _, ok := series[to]; !ok {
+			tenants = append(tenants, to)
+		}
+		series[to] = append(series[to], ts)
+	}
+	if len(tenants) == 0 || len(tenants) == 1 && tenants[0] == tenant {
+		return c.client.Write(ctx, req)
+	}
+	if _, ok := series[tenant]; !ok && len(req.Metadata) > 0 {
+		tenants = append(tenants, tenant)
+	}
+
+	errs := make([]error, len(tenants))
+	var wg sync.WaitGroup
+	for i, to := range tenants {
+		routed := &mimirpb.WriteRequest{Timeseries: series[to], Source: req.Source, SkipLabelValidation: req.SkipLabelValidation, SkipLabelCountValidation: req.SkipLabelCountValidation}
+		if to == tenant {
+			routed.Metadata = req.Metadata
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.client.Write(user.InjectOrgID(ctx, to), routed)
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("writing to tenant %q: %w", tenants[i], err)
+		}
+	}
+	return nil
+}
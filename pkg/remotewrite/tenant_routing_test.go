@@ -0,0 +1,104 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/remotewrite/remotewritemock"
+)
+
+func TestTenantRoutingClient(t *testing.T) {
+	untagged := func(nodes ...string) mimirpb.PreallocTimeseries {
+		lbls := []mimirpb.LabelAdapter{{Name: "__name__", Value: "graphite_untagged"}}
+		for i, node := range nodes {
+			lbls = append(lbls, mimirpb.LabelAdapter{Name: "__n00" + string(rune('0'+i)) + "__", Value: node})
+		}
+		return mimirpb.PreallocTimeseries{TimeSeries: &mimirpb.TimeSeries{Labels: lbls}}
+	}
+	tagged := func(name string) mimirpb.PreallocTimeseries {
+		return mimirpb.PreallocTimeseries{TimeSeries: &mimirpb.TimeSeries{Labels: []mimirpb.LabelAdapter{{Name: "__name__", Value: "graphite_tagged"}, {Name: "name", Value: name}}}}
+	}
+	prom := func(name, job string) mimirpb.PreallocTimeseries {
+		return mimirpb.PreallocTimeseries{TimeSeries: &mimirpb.TimeSeries{Labels: []mimirpb.LabelAdapter{{Name: "__name__", Value: name}, {Name: "job", Value: job}}}}
+	}
+
+	cfg := TenantRoutingConfig{
+		GraphitePrefixes: flagext.NewLimitsMapWithData(map[string]string{"team-a.": "team-a", "team-a.billing.": "billing"}, nil),
+		Selectors:        flagext.NewLimitsMapWithData(map[string]string{`{job="billing"}`: "billing", `{__name__=~"team_b_.*"}`: "team-b"}, nil),
+		SourceTenants:    flagext.StringSliceCSV{"shared"},
+	}
+	require.NoError(t, cfg.Validate())
+
+	var mtx sync.Mutex
+	written := map[string][]mimirpb.PreallocTimeseries{}
+	client := &remotewritemock.Client{}
+	client.On("Write", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		tenant, err := user.ExtractOrgID(args.Get(0).(context.Context))
+		require.NoError(t, err)
+		mtx.Lock()
+		defer mtx.Unlock()
+		written[tenant] = append(written[tenant], args.Get(1).(*mimirpb.WriteRequest).Timeseries...)
+	})
+	c, err := NewTenantRoutingClient(client, cfg)
+	require.NoError(t, err)
+
+	ctx := user.InjectOrgID(context.Background(), "shared")
+	require.NoError(t, c.Write(ctx, &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{
+		untagged("team-a", "cpu"),
+		untagged("team-a", "billing", "invoices"),
+		tagged("team-a.memory"),
+		prom("team_b_requests", "api"),
+		prom("up", "billing"),
+		untagged("team-c", "cpu"),
+	}}))
+	require.Equal(t, map[string][]mimirpb.PreallocTimeseries{
+		"team-a":  {untagged("team-a", "cpu"), tagged("team-a.memory")},
+		"billing": {untagged("team-a", "billing", "invoices"), prom("up", "billing")},
+		"team-b":  {prom("team_b_requests", "api")},
+		"shared":  {untagged("team-c", "cpu")},
+	}, written)
+
+	t.Run("other tenants aren't routed", func(t *testing.T) {
+		written = map[string][]mimirpb.PreallocTimeseries{}
+		require.NoError(t, c.Write(user.InjectOrgID(context.Background(), "team-c"), &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{
+			untagged("team-a", "cpu"),
+			prom("up", "billing"),
+		}}))
+		require.Equal(t, map[string][]mimirpb.PreallocTimeseries{
+			"team-c": {untagged("team-a", "cpu"), prom("up", "billing")},
+		}, written)
+	})
+
+	t.Run("errors name the tenant", func(t *testing.T) {
+		failing := &remotewritemock.Client{}
+		failing.On("Write", mock.Anything, mock.Anything).Return(errors.New("unavailable"))
+		c, err := NewTenantRoutingClient(failing, cfg)
+		require.NoError(t, err)
+		err = c.Write(ctx, &mimirpb.WriteRequest{Timeseries: []mimirpb.PreallocTimeseries{untagged("team-a", "cpu")}})
+		require.EqualError(t, err, `writing to tenant "team-a": unavailable`)
+	})
+
+	t.Run("no routes", func(t *testing.T) {
+		c, err := NewTenantRoutingClient(client, TenantRoutingConfig{})
+		require.NoError(t, err)
+		require.Same(t, client, c)
+	})
+
+	t.Run("source tenants are required", func(t *testing.T) {
+		cfg := TenantRoutingConfig{GraphitePrefixes: flagext.NewLimitsMapWithData(map[string]string{"team-a.": "team-a"}, nil)}
+		require.ErrorContains(t, cfg.Validate(), "source tenants must be set")
+	})
+
+	t.Run("invalid selector", func(t *testing.T) {
+		cfg := TenantRoutingConfig{Selectors: flagext.NewLimitsMapWithData(map[string]string{`{job=}`: "team-b"}, nil)}
+		require.ErrorContains(t, cfg.Validate(), `invalid tenant routing selector "{job=}"`)
+	})
+}
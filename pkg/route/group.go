@@ -0,0 +1,62 @@
+package route
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
+)
+
+// Group is a Registerer registering its routes under a path prefix, like an
+// API version (/api/v1, /api/v2), behind the middlewares of the group. This
+// lets a new API version change its behavior while the routes of the
+// previous version keep theirs.
+type Group struct {
+	reg         Registerer
+	prefix      string
+	middlewares []middleware.Interface
+}
+
+// NewGroup creates a Group registering its routes on reg under prefix.
+func NewGroup(reg Registerer, prefix string, middlewares ...middleware.Interface) *Group {
+	return &Group{
+		reg:         reg,
+		prefix:      strings.TrimSuffix(prefix, "/"),
+		middlewares: middlewares,
+	}
+}
+
+// Group returns a group nested in g, under the prefix of g followed by
+// prefix, whose routes are wrapped in the middlewares of g and then in
+// middlewares.
+func (g *Group) Group(prefix string, middlewares ...middleware.Interface) *Group {
+	return &Group{
+		reg:         g.reg,
+		prefix:      g.prefix + strings.TrimSuffix(prefix, "/"),
+		middlewares: append(append([]middleware.Interface(nil), g.middlewares...), middlewares...),
+	}
+}
+
+// Deprecated returns a group registering the same routes as g, setting the
+// Deprecation and Sunset headers on their responses, so that the clients
+// still using them are warned before they're removed.
+func (g *Group) Deprecated(d middleware.Deprecation) *Group {
+	return g.Group("", middleware.NewDeprecationMiddleware(d))
+}
+
+func (g *Group) RegisterRoute(path string, handler http.Handler, methods ...string) {
+	g.reg.RegisterRoute(g.prefix+path, g.wrap(handler), methods...)
+}
+
+func (g *Group) RegisterRoutesWithPrefix(prefix string, handler http.Handler, methods ...string) {
+	g.reg.RegisterRoutesWithPrefix(g.prefix+prefix, g.wrap(handler), methods...)
+}
+
+// wrap wraps handler in the middlewares of g, keeping its documentation.
+func (g *Group) wrap(handler http.Handler) http.Handler {
+	wrapped := middleware.Merge(g.middlewares...).Wrap(handler)
+	if op, ok := operationOf(handler); ok {
+		return Documented(wrapped, op)
+	}
+	return wrapped
+}
@@ -0,0 +1,64 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
+)
+
+func TestGroup(t *testing.T) {
+	header := func(name, value string) middleware.Interface {
+		return middleware.Func(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add(name, value)
+				next.ServeHTTP(w, r)
+			})
+		})
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	router := mux.NewRouter()
+	api := NewGroup(NewMuxRegisterer(router), "/api/", header("X-API", "true"))
+	v1 := api.Group("/v1", header("X-Version", "1")).Deprecated(middleware.Deprecation{
+		Since:  time.Unix(1735689600, 0),
+		Sunset: time.Unix(1767225600, 0),
+		Link:   "https://example.com/migrate-to-v2",
+	})
+	v2 := api.Group("/v2", header("X-Version", "2"))
+	v1.RegisterRoute("/find", ok, http.MethodGet)
+	v2.RegisterRoute("/find", ok, http.MethodGet)
+	v2.RegisterRoutesWithPrefix("/render/", ok, http.MethodGet)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := serve("/api/v1/find")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "true", rec.Header().Get("X-API"))
+	require.Equal(t, "1", rec.Header().Get("X-Version"))
+	require.Equal(t, "@1735689600", rec.Header().Get("Deprecation"))
+	require.Equal(t, "Thu, 01 Jan 2026 00:00:00 GMT", rec.Header().Get("Sunset"))
+	require.Equal(t, `<https://example.com/migrate-to-v2>; rel="deprecation"; type="text/html"`, rec.Header().Get("Link"))
+
+	rec = serve("/api/v2/find")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "true", rec.Header().Get("X-API"))
+	require.Equal(t, "2", rec.Header().Get("X-Version"))
+	require.Empty(t, rec.Header().Get("Deprecation"))
+	require.Empty(t, rec.Header().Get("Sunset"))
+
+	require.Equal(t, http.StatusOK, serve("/api/v2/render/a.b").Code)
+	require.Equal(t, http.StatusNotFound, serve("/api/v1/render/a.b").Code)
+	require.Equal(t, http.StatusNotFound, serve("/find").Code)
+}
@@ -0,0 +1,256 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorxpb"
+)
+
+// OpenAPIPath is the path the OpenAPI document of an OpenAPIRegisterer is
+// served at by RegisterDocumentRoute.
+const OpenAPIPath = "/api/openapi.json"
+
+// Operation documents a route in the OpenAPI document of the
+// OpenAPIRegisterer it's registered through.
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Parameters are the query and header parameters of the route. The
+	// variables of the path, eg. {id}, are documented as required path
+	// parameters unless they are listed here.
+	Parameters []Parameter
+	// Errors are the descriptions of the error responses of the route, by
+	// status code, documented with the schema of the JSON errors of
+	// errorx.
+	Errors map[int]string
+}
+
+// Parameter is a parameter of an Operation.
+type Parameter struct {
+	Name string
+	// In is where the parameter is: query, header or path.
+	In          string
+	Description string
+	Required    bool
+	// Type is the JSON schema type of the parameter, string if empty.
+	Type string
+}
+
+// documentedHandler is a handler with the Operation documenting it.
+type documentedHandler struct {
+	http.Handler
+	op Operation
+}
+
+// Documented returns handler documented by op in the OpenAPI document of
+// the OpenAPIRegisterer it's registered through, directly or through a
+// Group.
+func Documented(handler http.Handler, op Operation) http.Handler {
+	return documentedHandler{Handler: handler, op: op}
+}
+
+func operationOf(handler http.Handler) (Operation, bool) {
+	d, ok := handler.(documentedHandler)
+	return d.op, ok
+}
+
+// OpenAPIRegisterer is a Registerer recording the routes registered through
+// it in an OpenAPI v3 document, for client generation and gateway configs.
+// The routes registered with a prefix are left out, as OpenAPI paths are
+// exact. The routes registered without Documented are documented with their
+// methods and path parameters only.
+type OpenAPIRegisterer struct {
+	reg            Registerer
+	title, version string
+
+	mtx sync.Mutex
+	// operations are the operations by path and lower case method.
+	operations map[string]map[string]Operation
+}
+
+// NewOpenAPIRegisterer returns an OpenAPIRegisterer registering the routes on
+// reg, documented under the given API title and version.
+func NewOpenAPIRegisterer(reg Registerer, title, version string) *OpenAPIRegisterer {
+	return &OpenAPIRegisterer{
+		reg:        reg,
+		title:      title,
+		version:    version,
+		operations: map[string]map[string]Operation{},
+	}
+}
+
+func (r *OpenAPIRegisterer) RegisterRoute(path string, handler http.Handler, methods ...string) {
+	op, _ := operationOf(handler)
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet}
+	}
+	r.mtx.Lock()
+	if r.operations[path] == nil {
+		r.operations[path] = map[string]Operation{}
+	}
+	for _, method := range methods {
+		r.operations[path][strings.ToLower(method)] = op
+	}
+	r.mtx.Unlock()
+	r.reg.RegisterRoute(path, handler, methods...)
+}
+
+func (r *OpenAPIRegisterer) RegisterRoutesWithPrefix(prefix string, handler http.Handler, methods ...string) {
+	r.reg.RegisterRoutesWithPrefix(prefix, handler, methods...)
+}
+
+// RegisterDocumentRoute serves the document at OpenAPIPath, leaving the route
+// out of the document.
+func (r *OpenAPIRegisterer) RegisterDocumentRoute() {
+	r.reg.RegisterRoute(OpenAPIPath, r, http.MethodGet)
+}
+
+// ServeHTTP serves the OpenAPI document of the routes registered so far.
+func (r *OpenAPIRegisterer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(r.Document())
+}
+
+// Document returns the OpenAPI document of the routes registered so far,
+// marshaling to JSON.
+func (r *OpenAPIRegisterer) Document() map[string]interface{} {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	paths := map[string]interface{}{}
+	for path, operations := range r.operations {
+		openAPIPath, vars := pathVariables(path)
+		item := map[string]interface{}{}
+		for method, op := range operations {
+			item[method] = openAPIOperation(op, vars)
+		}
+		paths[openAPIPath] = item
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": r.title, "version": r.version},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{"Error": errorSchema()},
+		},
+	}
+}
+
+// muxVariable matches the variables of gorilla/mux paths, eg. {id} or
+// {id:[0-9]+}.
+var muxVariable = regexp.MustCompile(`\{([^{}:]+)(?::[^{}]*)?\}`)
+
+// pathVariables returns the OpenAPI path of a gorilla/mux path, without the
+// patterns of its variables, and the names of the variables.
+func pathVariables(path string) (string, []string) {
+	var vars []string
+	openAPIPath := muxVariable.ReplaceAllStringFunc(path, func(v string) string {
+		name := muxVariable.FindStringSubmatch(v)[1]
+		vars = append(vars, name)
+		return "{" + name + "}"
+	})
+	return openAPIPath, vars
+}
+
+func openAPIOperation(op Operation, vars []string) map[string]interface{} {
+	out := map[string]interface{}{}
+	if op.Summary != "" {
+		out["summary"] = op.Summary
+	}
+	if op.Description != "" {
+		out["description"] = op.Description
+	}
+	if len(op.Tags) > 0 {
+		out["tags"] = op.Tags
+	}
+
+	params := append([]Parameter(nil), op.Parameters...)
+	for _, v := range vars {
+		documented := false
+		for _, p := range op.Parameters {
+			documented = documented || p.In == "path" && p.Name == v
+		}
+		if !documented {
+			params = append(params, Parameter{Name: v, In: "path", Required: true})
+		}
+	}
+	if len(params) > 0 {
+		parameters := make([]interface{}, 0, len(params))
+		for _, p := range params {
+			typ := p.Type
+			if typ == "" {
+				typ = "string"
+			}
+			param := map[string]interface{}{
+				"name":     p.Name,
+				"in":       p.In,
+				"required": p.Required || p.In == "path",
+				"schema":   map[string]interface{}{"type": typ},
+			}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+			parameters = append(parameters, param)
+		}
+		out["parameters"] = parameters
+	}
+
+	responses := map[string]interface{}{
+		"default": map[string]interface{}{"description": "Success."},
+	}
+	for code, description := range op.Errors {
+		responses[strconv.Itoa(code)] = map[string]interface{}{
+			"description": description,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+				},
+			},
+		}
+	}
+	out["responses"] = responses
+	return out
+}
+
+// errorSchema is the schema of the JSON errors of errorx, as negotiated by
+// middleware.NewErrorFormatMiddleware.
+func errorSchema() map[string]interface{} {
+	types := make([]string, 0, len(errorxpb.ErrorxType_value))
+	for name := range errorxpb.ErrorxType_value {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	integer := map[string]interface{}{"type": "integer", "format": "int64"}
+	str := map[string]interface{}{"type": "string"}
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []string{"message", "type"},
+		"properties": map[string]interface{}{
+			"message":          str,
+			"type":             map[string]interface{}{"type": "string", "enum": types},
+			"retry_after_ms":   integer,
+			"limit":            str,
+			"partial_start_ms": integer,
+			"partial_end_ms":   integer,
+			"parse_input":      str,
+			"parse_position":   map[string]interface{}{"type": "integer", "format": "int32"},
+			"limit_value":      integer,
+			"limit_max":        integer,
+			"feature":          str,
+			"violations": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"field": str, "message": str},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,83 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/server/middleware"
+)
+
+func TestOpenAPIRegisterer(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	noop := middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(next.ServeHTTP)
+	})
+
+	router := mux.NewRouter()
+	reg := NewOpenAPIRegisterer(NewMuxRegisterer(router), "graphite", "v1")
+	api := NewGroup(reg, "/api/v1", noop)
+	api.RegisterRoute("/render", Documented(ok, Operation{
+		Summary:    "Render targets",
+		Tags:       []string{"render"},
+		Parameters: []Parameter{{Name: "target", In: "query", Required: true, Description: "Target to render."}},
+		Errors:     map[int]string{http.StatusBadRequest: "Invalid target."},
+	}), http.MethodGet, http.MethodPost)
+	api.RegisterRoute("/tenants/{tenant:[a-z]+}/usage", ok, http.MethodGet)
+	api.RegisterRoutesWithPrefix("/static/", ok, http.MethodGet)
+	reg.RegisterDocumentRoute()
+
+	// The routes are still served.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/tenants/abc/usage", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, OpenAPIPath, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var doc struct {
+		OpenAPI string                                       `json:"openapi"`
+		Info    map[string]string                            `json:"info"`
+		Paths   map[string]map[string]map[string]interface{} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	require.Equal(t, "3.0.3", doc.OpenAPI)
+	require.Equal(t, map[string]string{"title": "graphite", "version": "v1"}, doc.Info)
+	require.ElementsMatch(t, []string{"/api/v1/render", "/api/v1/tenants/{tenant}/usage"}, keys(doc.Paths))
+
+	render := doc.Paths["/api/v1/render"]
+	require.ElementsMatch(t, []string{"get", "post"}, keys(render))
+	require.Equal(t, "Render targets", render["get"]["summary"])
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"name": "target", "in": "query", "required": true, "description": "Target to render.",
+		"schema": map[string]interface{}{"type": "string"},
+	}}, render["get"]["parameters"])
+	require.Equal(t, map[string]interface{}{
+		"description": "Invalid target.",
+		"content": map[string]interface{}{"application/json": map[string]interface{}{
+			"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+		}},
+	}, render["post"]["responses"].(map[string]interface{})["400"])
+
+	usage := doc.Paths["/api/v1/tenants/{tenant}/usage"]["get"]
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"name": "tenant", "in": "path", "required": true,
+		"schema": map[string]interface{}{"type": "string"},
+	}}, usage["parameters"])
+}
+
+func keys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ConnMetrics records the lifecycle of the connections accepted by the
+// servers: how many are accepted and open, and how many requests each HTTP
+// connection serves before it's closed.
+type ConnMetrics struct {
+	accepted        *prometheus.CounterVec
+	active          *prometheus.GaugeVec
+	requestsPerConn *prometheus.HistogramVec
+
+	mtx      sync.Mutex
+	requests map[net.Conn]int
+}
+
+// NewConnMetrics creates and registers the connection metrics.
+func NewConnMetrics(prefix string, reg prometheus.Registerer) *ConnMetrics {
+	m := &ConnMetrics{
+		accepted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "connections_accepted_total",
+			Help:      "The total number of connections accepted.",
+		}, []string{"server"}),
+		active: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "connections_active",
+			Help:      "The number of open connections.",
+		}, []string{"server"}),
+		requestsPerConn: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: prefix,
+			Name:      "requests_per_connection",
+			Help:      "The number of HTTP requests served by a connection before it was closed.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}, []string{"server"}),
+		requests: map[net.Conn]int{},
+	}
+	reg.MustRegister(m.accepted, m.active, m.requestsPerConn)
+	return m
+}
+
+// InstrumentConnections records the connection metrics of the HTTP and gRPC
+// servers. It must be called before Run.
+func (s *Server) InstrumentConnections(m *ConnMetrics) {
+	s.httpListener = m.listener(s.httpListener, "http")
+	s.grpcListener = m.listener(s.grpcListener, "grpc")
+	s.HTTPServer.ConnState = m.connState("http", s.HTTPServer.ConnState)
+}
+
+func (m *ConnMetrics) listener(l net.Listener, server string) net.Listener {
+	return &instrumentedListener{Listener: l, accepted: m.accepted.WithLabelValues(server), active: m.active.WithLabelValues(server)}
+}
+
+// connState counts the requests of each HTTP connection, calling next, if
+// set, for every state change.
+func (m *ConnMetrics) connState(server string, next func(net.Conn, http.ConnState)) func(net.Conn, http.ConnState) {
+	requestsPerConn := m.requestsPerConn.WithLabelValues(server)
+	return func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateActive:
+			m.mtx.Lock()
+			m.requests[conn]++
+			m.mtx.Unlock()
+		case http.StateClosed, http.StateHijacked:
+			m.mtx.Lock()
+			requests := m.requests[conn]
+			delete(m.requests, conn)
+			m.mtx.Unlock()
+			requestsPerConn.Observe(float64(requests))
+		}
+		if next != nil {
+			next(conn, state)
+		}
+	}
+}
+
+type instrumentedListener struct {
+	net.Listener
+	accepted prometheus.Counter
+	active   prometheus.Gauge
+}
+
+func (l *instrumentedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.accepted.Inc()
+	l.active.Inc()
+	return &instrumentedConn{Conn: conn, active: l.active}, nil
+}
+
+type instrumentedConn struct {
+	net.Conn
+	active    prometheus.Gauge
+	closeOnce sync.Once
+}
+
+func (c *instrumentedConn) Close() error {
+	c.closeOnce.Do(c.active.Dec)
+	return c.Conn.Close()
+}
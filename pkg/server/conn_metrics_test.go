@@ -0,0 +1,79 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnMetrics(t *testing.T) {
+	var cfg Config
+	cfg.RegisterFlags(flag.NewFlagSet("", flag.ExitOnError))
+	cfg.HTTPListenPort = 0
+	cfg.HTTPListenAddress = "127.0.0.1"
+	cfg.GRPCListenPort = 0
+
+	server, err := NewServer(log.NewNopLogger(), cfg, mux.NewRouter(), nil)
+	require.NoError(t, err)
+	server.Router.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	reg := prometheus.NewPedanticRegistry()
+	server.InstrumentConnections(NewConnMetrics("test", reg))
+
+	go func() {
+		require.NoError(t, server.Run())
+	}()
+	defer server.Shutdown(nil)
+
+	client := &http.Client{Transport: &http.Transport{}}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(fmt.Sprintf("http://%s/test", server.Addr()))
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, resp.Body.Close())
+	}
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP test_connections_accepted_total The total number of connections accepted.
+		# TYPE test_connections_accepted_total counter
+		test_connections_accepted_total{server="grpc"} 0
+		test_connections_accepted_total{server="http"} 1
+		# HELP test_connections_active The number of open connections.
+		# TYPE test_connections_active gauge
+		test_connections_active{server="grpc"} 0
+		test_connections_active{server="http"} 1
+	`), "test_connections_accepted_total", "test_connections_active"))
+
+	client.CloseIdleConnections()
+	require.Eventually(t, func() bool {
+		return testutil.GatherAndCompare(reg, strings.NewReader(`
+			# HELP test_connections_active The number of open connections.
+			# TYPE test_connections_active gauge
+			test_connections_active{server="grpc"} 0
+			test_connections_active{server="http"} 0
+			# HELP test_requests_per_connection The number of HTTP requests served by a connection before it was closed.
+			# TYPE test_requests_per_connection histogram
+			test_requests_per_connection_bucket{server="http",le="1"} 0
+			test_requests_per_connection_bucket{server="http",le="4"} 1
+			test_requests_per_connection_bucket{server="http",le="16"} 1
+			test_requests_per_connection_bucket{server="http",le="64"} 1
+			test_requests_per_connection_bucket{server="http",le="256"} 1
+			test_requests_per_connection_bucket{server="http",le="1024"} 1
+			test_requests_per_connection_bucket{server="http",le="4096"} 1
+			test_requests_per_connection_bucket{server="http",le="16384"} 1
+			test_requests_per_connection_bucket{server="http",le="+Inf"} 1
+			test_requests_per_connection_sum{server="http"} 3
+			test_requests_per_connection_count{server="http"} 1
+		`), "test_connections_active", "test_requests_per_connection") == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	inheritedHTTPListener = "http"
+	inheritedGRPCListener = "grpc"
+)
+
+// listenFDsStart is the first file descriptor passed with the systemd socket
+// activation protocol.
+var listenFDsStart = 3
+
+// inherited holds the files of the listeners passed to the process, by name,
+// until they are used. They are read from the environment only once, as the
+// file descriptors can't be shared by several listeners.
+var inherited struct {
+	mtx    sync.Mutex
+	loaded bool
+	files  map[string]*os.File
+}
+
+// ListenConfig configures how the listeners of the app are opened.
+type ListenConfig struct {
+	// ReusePort sets SO_REUSEPORT on the TCP and UDP sockets.
+	ReusePort bool
+	// Inherit uses the listeners passed with the systemd socket activation
+	// protocol, by name, instead of opening new ones.
+	Inherit bool
+}
+
+// Listen returns the inherited listener called name if cfg.Inherit is set
+// and it was passed to the process, or opens a new one on addr.
+func Listen(cfg ListenConfig, name, network, addr string) (net.Listener, error) {
+	if f, err := inheritedFile(cfg, name); err != nil || f != nil {
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		l, err := net.FileListener(f)
+		return l, errors.Wrapf(err, "can't use inherited listener %s", name)
+	}
+	lc := net.ListenConfig{}
+	if cfg.ReusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.Listen(context.Background(), network, addr)
+}
+
+// ListenPacket is like Listen, for the connectionless protocols like UDP.
+func ListenPacket(cfg ListenConfig, name, network, addr string) (net.PacketConn, error) {
+	if f, err := inheritedFile(cfg, name); err != nil || f != nil {
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		conn, err := net.FilePacketConn(f)
+		return conn, errors.Wrapf(err, "can't use inherited listener %s", name)
+	}
+	lc := net.ListenConfig{}
+	if cfg.ReusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.ListenPacket(context.Background(), network, addr)
+}
+
+// inheritedFile returns the file of the inherited listener called name, or
+// nil if it wasn't passed to the process or cfg.Inherit isn't set.
+func inheritedFile(cfg ListenConfig, name string) (*os.File, error) {
+	if !cfg.Inherit {
+		return nil, nil
+	}
+	inherited.mtx.Lock()
+	defer inherited.mtx.Unlock()
+	if !inherited.loaded {
+		files, err := inheritedFiles()
+		if err != nil {
+			return nil, err
+		}
+		inherited.files, inherited.loaded = files, true
+	}
+	f := inherited.files[name]
+	delete(inherited.files, name)
+	return f, nil
+}
+
+// inheritedFiles returns the files of the listeners passed to the process
+// with the systemd socket activation protocol, by name, and unsets its
+// environment variables so the child processes don't use them too. The names
+// are the ones of LISTEN_FDNAMES, the first two unnamed listeners are the http
+// and grpc ones. It returns no files if none were passed to this process.
+func inheritedFiles() (map[string]*os.File, error) {
+	pid, pidErr := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	count, countErr := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if err := os.Unsetenv(env); err != nil {
+			return nil, errors.Wrapf(err, "can't unset %s", env)
+		}
+	}
+	if pidErr != nil || pid != os.Getpid() || countErr != nil || count <= 0 {
+		return nil, nil
+	}
+	defaultNames := []string{inheritedHTTPListener, inheritedGRPCListener}
+
+	files := make(map[string]*os.File, count)
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) && names[i] != "unknown" {
+			name = names[i]
+		}
+		if name == "" && i < len(defaultNames) {
+			name = defaultNames[i]
+		}
+		fd := listenFDsStart + i
+		if name == "" {
+			name = fmt.Sprintf("fd%d", fd)
+		}
+		files[name] = os.NewFile(uintptr(fd), name)
+	}
+	return files, nil
+}
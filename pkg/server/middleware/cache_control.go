@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/dskit/user"
+)
+
+// CacheControl is a Middleware letting browsers cache the successful responses
+// of GET requests for maxAge, and revalidate them afterwards with an ETag
+// computed from the response body. Meant for metadata endpoints, like the
+// Graphite find and tags ones, which Grafana requests again and again with the
+// same parameters.
+//
+// The response is buffered to compute the ETag, so it shouldn't wrap handlers
+// with large or streamed responses.
+type CacheControl struct {
+	cacheControl string
+}
+
+func NewCacheControlMiddleware(maxAge time.Duration) CacheControl {
+	// The responses are private to the tenant.
+	return CacheControl{cacheControl: fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds()))}
+}
+
+// Wrap implements middleware.Interface
+func (c CacheControl) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf := newResponseRecorder(nil, 0)
+		next.ServeHTTP(buf, r)
+
+		header := w.Header()
+		for k, v := range buf.Header() {
+			header[k] = v
+		}
+		if buf.statusCode != http.StatusOK {
+			w.WriteHeader(buf.statusCode)
+			_, _ = w.Write(buf.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(buf.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		header.Set("ETag", etag)
+		if header.Get("Cache-Control") == "" {
+			header.Set("Cache-Control", c.cacheControl)
+		}
+		header.Add("Vary", user.OrgIDHeaderName)
+
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			header.Del("Content-Length")
+			header.Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(buf.body.Bytes())
+	})
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using the
+// weak comparison required for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
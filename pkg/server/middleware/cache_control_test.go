@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheControl(t *testing.T) {
+	body := `[{"text": "servers", "leaf": 0}]`
+	handler := NewCacheControlMiddleware(30 * time.Second).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("query") == "" {
+			http.Error(w, "missing query", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	serve := func(method, url, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := serve(http.MethodGet, "/metrics/find?query=*", "")
+	require.Equal(t, http.StatusOK, first.Code)
+	require.Equal(t, body, first.Body.String())
+	require.Equal(t, "private, max-age=30", first.Header().Get("Cache-Control"))
+	require.Equal(t, "X-Scope-OrgID", first.Header().Get("Vary"))
+	etag := first.Header().Get("ETag")
+	require.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+
+	t.Run("same response has the same etag", func(t *testing.T) {
+		require.Equal(t, etag, serve(http.MethodGet, "/metrics/find?query=*", "").Header().Get("ETag"))
+	})
+
+	t.Run("matching etag is not modified", func(t *testing.T) {
+		for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+			rec := serve(http.MethodGet, "/metrics/find?query=*", ifNoneMatch)
+			require.Equal(t, http.StatusNotModified, rec.Code, ifNoneMatch)
+			require.Empty(t, rec.Body.String())
+			require.Equal(t, etag, rec.Header().Get("ETag"))
+			require.Empty(t, rec.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("different etag gets the response", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/metrics/find?query=*", `"stale"`)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, body, rec.Body.String())
+	})
+
+	t.Run("errors aren't cached", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/metrics/find", "")
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Equal(t, "missing query\n", rec.Body.String())
+		require.Empty(t, rec.Header().Get("ETag"))
+		require.Empty(t, rec.Header().Get("Cache-Control"))
+	})
+
+	t.Run("other methods pass through", func(t *testing.T) {
+		rec := serve(http.MethodPost, "/metrics/find?query=*", etag)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Empty(t, rec.Header().Get("ETag"))
+	})
+}
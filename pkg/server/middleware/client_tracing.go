@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// ClientTracing is a RoundTripper tracing the outgoing requests with a
+// client span, child of the span of the request context. Requests without a
+// span in their context aren't traced.
+type ClientTracing struct {
+	next http.RoundTripper
+	// operation is the name of the spans.
+	operation string
+}
+
+// NewClientTracing wraps next to trace its requests with spans named
+// operation.
+func NewClientTracing(next http.RoundTripper, operation string) *ClientTracing {
+	return &ClientTracing{next: next, operation: operation}
+}
+
+// NewClientTracingTripperware returns a tripperware tracing the requests of
+// the RoundTripper it wraps, for the clients built with tripperwares, like
+// remotewrite.NewClient.
+func NewClientTracingTripperware(operation string) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return NewClientTracing(next, operation)
+	}
+}
+
+// RoundTrip starts a client span, injects it in the request headers, and
+// finishes it once the response body is closed, tagged with the status code,
+// the response size, and the errorx classification of the failures.
+func (t *ClientTracing) RoundTrip(req *http.Request) (*http.Response, error) {
+	parent := opentracing.SpanFromContext(req.Context())
+	if parent == nil {
+		return t.next.RoundTrip(req)
+	}
+	tracer := parent.Tracer()
+	span := tracer.StartSpan(t.operation, opentracing.ChildOf(parent.Context()), ext.SpanKindRPCClient)
+	ext.HTTPMethod.Set(span, req.Method)
+	ext.HTTPUrl.Set(span, req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+	ext.PeerHostname.Set(span, req.URL.Hostname())
+
+	// The request must not be modified, inject the headers in a copy.
+	req = req.Clone(opentracing.ContextWithSpan(req.Context(), span))
+	_ = tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		tagError(span, err)
+		span.Finish()
+		return nil, err
+	}
+	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+	if resp.StatusCode/100 != 2 {
+		tagError(span, errorx.FromHTTPResponse(resp, "", fmt.Sprintf("%s: status %d", t.operation, resp.StatusCode), nil))
+	}
+	if resp.Body == nil || resp.Body == http.NoBody {
+		span.Finish()
+		return resp, nil
+	}
+	resp.Body = &tracedBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+func tagError(span opentracing.Span, err error) {
+	typ, retryable := errorx.Classify(err)
+	ext.Error.Set(span, true)
+	span.SetTag("error.type", typ)
+	span.SetTag("error.retryable", retryable)
+	span.LogKV("error", err.Error())
+}
+
+// tracedBody finishes the span of the request once the body is read or
+// closed, tagged with the number of bytes read.
+type tracedBody struct {
+	io.ReadCloser
+	span opentracing.Span
+	size int64
+	once sync.Once
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+	return err
+}
+
+func (b *tracedBody) finish() {
+	b.once.Do(func() {
+		b.span.SetTag("http.response_size", b.size)
+		b.span.Finish()
+	})
+}
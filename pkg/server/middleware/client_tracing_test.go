@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientTracing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Mockpfx-Ids-Traceid") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/unavailable" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	tracer := mocktracer.New()
+	client := &http.Client{Transport: NewClientTracing(http.DefaultTransport, "downstream")}
+	do := func(ctx context.Context, path string) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+path+"?key=secret", nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	t.Run("traced", func(t *testing.T) {
+		tracer.Reset()
+		parent := tracer.StartSpan("parent")
+		ctx := opentracing.ContextWithSpan(context.Background(), parent)
+		require.Equal(t, http.StatusOK, do(ctx, "/ok").StatusCode)
+
+		spans := tracer.FinishedSpans()
+		require.Len(t, spans, 1)
+		span := spans[0]
+		require.Equal(t, "downstream", span.OperationName)
+		require.Equal(t, parent.Context().(mocktracer.MockSpanContext).SpanID, span.ParentID)
+		require.Equal(t, uint16(http.StatusOK), span.Tag("http.status_code"))
+		require.Equal(t, int64(5), span.Tag("http.response_size"))
+		require.Equal(t, srv.URL+"/ok", span.Tag("http.url"))
+		require.Nil(t, span.Tag("error"))
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		tracer.Reset()
+		ctx := opentracing.ContextWithSpan(context.Background(), tracer.StartSpan("parent"))
+		require.Equal(t, http.StatusServiceUnavailable, do(ctx, "/unavailable").StatusCode)
+
+		span := tracer.FinishedSpans()[0]
+		require.Equal(t, true, span.Tag("error"))
+		require.Equal(t, "unavailable", span.Tag("error.type"))
+		require.Equal(t, true, span.Tag("error.retryable"))
+	})
+
+	t.Run("transport error", func(t *testing.T) {
+		tracer.Reset()
+		ctx, cancel := context.WithCancel(opentracing.ContextWithSpan(context.Background(), tracer.StartSpan("parent")))
+		cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		_, err = client.Do(req)
+		require.True(t, errors.Is(err, context.Canceled))
+
+		span := tracer.FinishedSpans()[0]
+		require.Equal(t, "canceled", span.Tag("error.type"))
+	})
+
+	t.Run("not traced without a parent span", func(t *testing.T) {
+		tracer.Reset()
+		require.Equal(t, http.StatusBadRequest, do(context.Background(), "/ok").StatusCode)
+		require.Empty(t, tracer.FinishedSpans())
+	})
+}
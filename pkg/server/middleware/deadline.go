@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DeadlineHeader carries the deadline of a request to the services it calls,
+// as a unix timestamp in milliseconds, so they can give up on work the caller
+// won't wait for.
+const DeadlineHeader = "X-Deadline"
+
+// Deadline is a Middleware setting the deadline of the request context to the
+// earliest of the configured timeout and the deadline in the request's
+// DeadlineHeader, if any.
+type Deadline struct {
+	timeout time.Duration
+	timeNow func() time.Time
+}
+
+// NewDeadlineMiddleware creates a Deadline middleware. A timeout of 0 only
+// honors the DeadlineHeader.
+func NewDeadlineMiddleware(timeout time.Duration) Deadline {
+	return Deadline{timeout: timeout, timeNow: time.Now}
+}
+
+// Wrap implements middleware.Interface
+func (d Deadline) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var deadline time.Time
+		if d.timeout > 0 {
+			deadline = d.timeNow().Add(d.timeout)
+		}
+		if header, ok := ParseDeadlineHeader(r.Header); ok && (deadline.IsZero() || header.Before(deadline)) {
+			deadline = header
+		}
+		if deadline.IsZero() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ParseDeadlineHeader returns the deadline set in the DeadlineHeader of h.
+func ParseDeadlineHeader(h http.Header) (time.Time, bool) {
+	v := h.Get(DeadlineHeader)
+	if v == "" {
+		return time.Time{}, false
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
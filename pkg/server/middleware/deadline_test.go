@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadline(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	header := func(d time.Duration) string {
+		return strconv.FormatInt(now.Add(d).UnixMilli(), 10)
+	}
+
+	for name, tc := range map[string]struct {
+		timeout      time.Duration
+		header       string
+		wantDeadline time.Duration
+		wantNone     bool
+	}{
+		"no timeout nor header": {
+			wantNone: true,
+		},
+		"timeout": {
+			timeout:      time.Minute,
+			wantDeadline: time.Minute,
+		},
+		"header only": {
+			header:       header(10 * time.Second),
+			wantDeadline: 10 * time.Second,
+		},
+		"header earlier than the timeout": {
+			timeout:      time.Minute,
+			header:       header(10 * time.Second),
+			wantDeadline: 10 * time.Second,
+		},
+		"timeout earlier than the header": {
+			timeout:      time.Minute,
+			header:       header(time.Hour),
+			wantDeadline: time.Minute,
+		},
+		"invalid header": {
+			timeout:      time.Minute,
+			header:       "soon",
+			wantDeadline: time.Minute,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := NewDeadlineMiddleware(tc.timeout)
+			d.timeNow = func() time.Time { return now }
+
+			var deadline time.Time
+			var ok bool
+			handler := d.Wrap(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				deadline, ok = r.Context().Deadline()
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set(DeadlineHeader, tc.header)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if tc.wantNone {
+				require.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			require.Equal(t, now.Add(tc.wantDeadline), deadline)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// minRatioCheckBytes is how many bytes are decompressed before the
+// decompression ratio is checked, as small bodies of repetitive data
+// legitimately compress very well.
+const minRatioCheckBytes = 1 << 20
+
+// DecompressionLimitError is wrapped by the errorx.LimitExceeded error
+// returned reading a body decompressed with NewLimitedDecompressor past one
+// of its limits.
+type DecompressionLimitError struct {
+	// MaxBytes is set when the decompressed size exceeded it.
+	MaxBytes int64
+	// MaxRatio is set when the decompression ratio exceeded it.
+	MaxRatio float64
+}
+
+func (e DecompressionLimitError) Error() string {
+	if e.MaxRatio > 0 {
+		return fmt.Sprintf("request body decompresses more than %g times its size", e.MaxRatio)
+	}
+	return fmt.Sprintf("decompressed request body is larger than the max of %d bytes", e.MaxBytes)
+}
+
+// NewLimitedDecompressor returns a reader decompressing r, compressed with
+// the given Content-Encoding, which fails with an errorx.LimitExceeded error
+// wrapping a DecompressionLimitError as soon as more than maxBytes bytes are
+// decompressed, or the data decompresses more than maxRatio times its
+// compressed size. Limits of 0 are disabled. It
+// returns nil for the encodings it doesn't support: gzip, x-gzip and deflate.
+func NewLimitedDecompressor(r io.Reader, encoding string, maxBytes int64, maxRatio float64) (io.ReadCloser, error) {
+	compressed := &countingReader{r: r}
+	var decompressor io.ReadCloser
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		decompressor, err = gzip.NewReader(compressed)
+	case "deflate":
+		decompressor, err = zlib.NewReader(compressed)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &limitedDecompressor{
+		ReadCloser: decompressor,
+		compressed: compressed,
+		maxBytes:   maxBytes,
+		maxRatio:   maxRatio,
+	}, nil
+}
+
+type limitedDecompressor struct {
+	io.ReadCloser
+	compressed   *countingReader
+	decompressed int64
+	maxBytes     int64
+	maxRatio     float64
+	// exceeded is set once a limit was exceeded.
+	exceeded error
+}
+
+func (d *limitedDecompressor) Read(p []byte) (int, error) {
+	if d.exceeded != nil {
+		return 0, d.exceeded
+	}
+	n, err := d.ReadCloser.Read(p)
+	d.decompressed += int64(n)
+	if d.maxBytes > 0 && d.decompressed > d.maxBytes {
+		d.exceeded = errorx.LimitExceeded{Msg: "request body too large", Err: DecompressionLimitError{MaxBytes: d.maxBytes}, Limit: "max-decompressed-bytes", Value: d.decompressed, Max: d.maxBytes}
+		return n, d.exceeded
+	}
+	if d.maxRatio > 0 && d.decompressed > minRatioCheckBytes && float64(d.decompressed) > d.maxRatio*float64(d.compressed.read) {
+		d.exceeded = errorx.LimitExceeded{Msg: "request body too large", Err: DecompressionLimitError{MaxRatio: d.maxRatio}, Limit: "max-decompression-ratio", Value: d.decompressed / max(d.compressed.read, 1), Max: int64(d.maxRatio)}
+		return n, d.exceeded
+	}
+	return n, err
+}
+
+type countingReader struct {
+	r    io.Reader
+	read int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+// Decompression is a Middleware decompressing the gzip and deflate request
+// bodies for the handlers as they read them, failing the reads past the
+// configured size or ratio before the bodies are fully inflated, to protect
+// the write adapters against zip bombs. The handlers see an
+// errorx.LimitExceeded error, and the bodies are never buffered whole.
+type Decompression struct {
+	maxBytes int64
+	maxRatio float64
+	logger   log.Logger
+}
+
+func NewDecompressionMiddleware(maxBytes int64, maxRatio float64, logger log.Logger) *Decompression {
+	return &Decompression{maxBytes: maxBytes, maxRatio: maxRatio, logger: logger}
+}
+
+// Wrap implements middleware.Interface
+func (d Decompression) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decompressor, err := NewLimitedDecompressor(r.Body, r.Header.Get("Content-Encoding"), d.maxBytes, d.maxRatio)
+		if err != nil {
+			errorx.LogAndSetHTTPError(r.Context(), w, d.logger, errorx.BadRequest{Msg: "can't decompress body", Err: err})
+			return
+		}
+		if decompressor == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer decompressor.Close()
+
+		r.Body = decompressor
+		r.ContentLength = -1
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		next.ServeHTTP(w, r)
+
+		if err := decompressor.(*limitedDecompressor).exceeded; err != nil {
+			_ = level.Warn(d.logger).Log("msg", "rejected request body", "err", err)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestDecompression(t *testing.T) {
+	var deflated bytes.Buffer
+	zw := zlib.NewWriter(&deflated)
+	_, err := zw.Write([]byte("cpu,host=a value=1"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	for name, tc := range map[string]struct {
+		body         []byte
+		encoding     string
+		maxBytes     int64
+		maxRatio     float64
+		expectedCode int
+		expectedBody string
+		// expectedLimit is the limit the handler sees exceeded.
+		expectedLimit string
+	}{
+		"gzip": {
+			body:         gzipped(t, []byte("cpu,host=a value=1")),
+			encoding:     "gzip",
+			maxBytes:     1024,
+			expectedCode: http.StatusOK,
+			expectedBody: "cpu,host=a value=1",
+		},
+		"deflate": {
+			body:         deflated.Bytes(),
+			encoding:     "deflate",
+			maxBytes:     1024,
+			expectedCode: http.StatusOK,
+			expectedBody: "cpu,host=a value=1",
+		},
+		"unsupported encodings are left to the handler": {
+			body:         []byte("snappy"),
+			encoding:     "snappy",
+			maxBytes:     1,
+			expectedCode: http.StatusOK,
+			expectedBody: "snappy",
+		},
+		"too large": {
+			body:          gzipped(t, bytes.Repeat([]byte("a"), 2048)),
+			encoding:      "gzip",
+			maxBytes:      1024,
+			expectedCode:  http.StatusBadRequest,
+			expectedLimit: "max-decompressed-bytes",
+		},
+		"ratio too high": {
+			body:          gzipped(t, make([]byte, 10<<20)),
+			encoding:      "gzip",
+			maxRatio:      100,
+			expectedCode:  http.StatusBadRequest,
+			expectedLimit: "max-decompression-ratio",
+		},
+		"small bodies can compress well": {
+			body:         gzipped(t, make([]byte, 1024)),
+			encoding:     "gzip",
+			maxRatio:     2,
+			expectedCode: http.StatusOK,
+			expectedBody: string(make([]byte, 1024)),
+		},
+		"corrupt": {
+			body:         []byte("not gzip"),
+			encoding:     "gzip",
+			maxBytes:     1024,
+			expectedCode: http.StatusBadRequest,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var readErr error
+			handler := NewDecompressionMiddleware(tc.maxBytes, tc.maxRatio, log.NewNopLogger()).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Content-Encoding") == "gzip" {
+					t.Error("Content-Encoding wasn't removed")
+				}
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					readErr = err
+					errorx.LogAndSetHTTPError(r.Context(), w, log.NewNopLogger(), errorx.BadRequest{Msg: "can't read body", Err: err})
+					return
+				}
+				_, _ = w.Write(body)
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/api/v2/write", bytes.NewReader(tc.body))
+			req.Header.Set("Content-Encoding", tc.encoding)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			require.Equal(t, tc.expectedCode, recorder.Code)
+			if tc.expectedCode == http.StatusOK {
+				require.Equal(t, tc.expectedBody, recorder.Body.String())
+			}
+			if tc.expectedLimit != "" {
+				var limitErr errorx.LimitExceeded
+				require.ErrorAs(t, readErr, &limitErr)
+				require.Equal(t, tc.expectedLimit, limitErr.Limit)
+			}
+		})
+	}
+}
+
+func TestNewLimitedDecompressor(t *testing.T) {
+	r, err := NewLimitedDecompressor(bytes.NewReader(gzipped(t, []byte(strings.Repeat("a", 100)))), "gzip", 10, 0)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.ErrorAs(t, err, &DecompressionLimitError{})
+	require.EqualError(t, err, "request body too large: decompressed request body is larger than the max of 10 bytes")
+
+	var limitErr errorx.LimitExceeded
+	require.ErrorAs(t, err, &limitErr)
+	require.Equal(t, int64(10), limitErr.Max)
+	require.Greater(t, limitErr.Value, int64(10))
+
+	// The body isn't read further once the limit is exceeded.
+	n, err := r.Read(make([]byte, 1))
+	require.Zero(t, n)
+	require.ErrorAs(t, err, &limitErr)
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Deprecation describes a deprecated route, announced to clients with the
+// Deprecation (RFC 9745), Sunset (RFC 8594) and Link headers.
+type Deprecation struct {
+	// Since is when the route was deprecated.
+	Since time.Time
+	// Sunset, if set, is when the route stops being served.
+	Sunset time.Time
+	// Link, if set, is the URL of the migration guide.
+	Link string
+}
+
+// DeprecationMiddleware sets the deprecation headers on the responses.
+type DeprecationMiddleware struct {
+	deprecation Deprecation
+}
+
+func NewDeprecationMiddleware(d Deprecation) DeprecationMiddleware {
+	return DeprecationMiddleware{deprecation: d}
+}
+
+// Wrap implements middleware.Interface
+func (m DeprecationMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Deprecation", "@"+strconv.FormatInt(m.deprecation.Since.Unix(), 10))
+		if !m.deprecation.Sunset.IsZero() {
+			h.Set("Sunset", m.deprecation.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if m.deprecation.Link != "" {
+			h.Add("Link", "<"+m.deprecation.Link+`>; rel="deprecation"; type="text/html"`)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeprecationMiddleware(t *testing.T) {
+	handler := NewDeprecationMiddleware(Deprecation{Since: time.Unix(1735689600, 0)}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/find", nil))
+
+	require.Equal(t, http.StatusTeapot, rec.Code)
+	require.Equal(t, "@1735689600", rec.Header().Get("Deprecation"))
+	// Without a sunset date or a migration guide, only Deprecation is set.
+	require.NotContains(t, rec.Header(), "Sunset")
+	require.NotContains(t, rec.Header(), "Link")
+}
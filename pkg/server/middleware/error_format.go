@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// ErrorFormat is a Middleware making the errors written with
+// errorx.LogAndSetHTTPError honor the Accept header of the requests: JSON by
+// default, plain text, or protobuf for machine clients. Only the errors
+// written by the handlers and the middlewares after it are affected.
+type ErrorFormat struct{}
+
+// NewErrorFormatMiddleware creates an ErrorFormat.
+func NewErrorFormatMiddleware() ErrorFormat {
+	return ErrorFormat{}
+}
+
+// Wrap implements middleware.Interface
+func (ErrorFormat) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := errorx.NegotiateResponseFormat(r.Header.Get("Accept"))
+		next.ServeHTTP(w, r.WithContext(errorx.ContextWithResponseFormat(r.Context(), format)))
+	})
+}
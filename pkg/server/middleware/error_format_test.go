@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+func TestErrorFormat(t *testing.T) {
+	handler := NewErrorFormatMiddleware().Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errorx.LogAndSetHTTPError(r.Context(), w, log.NewNopLogger(), errorx.BadRequest{Msg: "invalid query"})
+	}))
+
+	for accept, expected := range map[string]string{
+		"":                       "application/json",
+		"text/plain":             "text/plain; charset=utf-8",
+		"application/x-protobuf": "application/x-protobuf",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+		require.Equal(t, expected, recorder.Header().Get("Content-Type"), accept)
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-kit/log"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// HeaderLimits is a Middleware rejecting the requests with a URI or headers
+// larger than the configured limits with an errorx.BadRequest explaining
+// which limit was exceeded, instead of the opaque errors of the HTTP server or
+// of the proxies in front of it. Limits of 0 are disabled.
+type HeaderLimits struct {
+	maxURILength   int
+	maxHeaderCount int
+	maxHeaderBytes int
+	logger         log.Logger
+}
+
+func NewHeaderLimitsMiddleware(maxURILength, maxHeaderCount, maxHeaderBytes int, logger log.Logger) *HeaderLimits {
+	return &HeaderLimits{
+		maxURILength:   maxURILength,
+		maxHeaderCount: maxHeaderCount,
+		maxHeaderBytes: maxHeaderBytes,
+		logger:         logger,
+	}
+}
+
+// Wrap implements middleware.Interface
+func (l HeaderLimits) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := l.check(r); err != nil {
+			errorx.LogAndSetHTTPError(r.Context(), w, l.logger, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l HeaderLimits) check(r *http.Request) error {
+	if uriLength := len(r.RequestURI); l.maxURILength > 0 && uriLength > l.maxURILength {
+		return errorx.LimitExceeded{Msg: fmt.Sprintf("request URI of %d bytes exceeds the limit of %d bytes: send the query parameters in a form-encoded POST body instead", uriLength, l.maxURILength), Limit: "http-max-uri-length", Value: int64(uriLength), Max: int64(l.maxURILength)}
+	}
+
+	count, size := 0, 0
+	for name, values := range r.Header {
+		count += len(values)
+		for _, v := range values {
+			// As sent on the wire: "name: value\r\n".
+			size += len(name) + len(v) + 4
+		}
+	}
+	if l.maxHeaderCount > 0 && count > l.maxHeaderCount {
+		return errorx.LimitExceeded{Msg: fmt.Sprintf("request has %d headers, more than the limit of %d", count, l.maxHeaderCount), Limit: "http-max-header-count", Value: int64(count), Max: int64(l.maxHeaderCount)}
+	}
+	if l.maxHeaderBytes > 0 && size > l.maxHeaderBytes {
+		return errorx.LimitExceeded{Msg: fmt.Sprintf("request headers of %d bytes exceed the limit of %d bytes", size, l.maxHeaderBytes), Limit: "http-max-header-bytes", Value: int64(size), Max: int64(l.maxHeaderBytes)}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderLimits(t *testing.T) {
+	handler := NewHeaderLimitsMiddleware(100, 3, 200, log.NewNopLogger()).Wrap(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for name, tc := range map[string]struct {
+		target          string
+		headers         map[string]string
+		expectedStatus  int
+		expectedMessage string
+	}{
+		"within limits": {
+			target:         "/render?target=a.b.c",
+			headers:        map[string]string{"X-Scope-OrgID": "12345"},
+			expectedStatus: http.StatusNoContent,
+		},
+		"long URI": {
+			target:          "/render?target=" + strings.Repeat("a", 100),
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: "request URI of 115 bytes exceeds the limit of 100 bytes: send the query parameters in a form-encoded POST body instead\n",
+		},
+		"too many headers": {
+			target:          "/render",
+			headers:         map[string]string{"A": "1", "B": "2", "C": "3", "D": "4"},
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: "request has 4 headers, more than the limit of 3\n",
+		},
+		"large headers": {
+			target:          "/render",
+			headers:         map[string]string{"Cookie": strings.Repeat("a", 200)},
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: "request headers of 210 bytes exceed the limit of 200 bytes\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedStatus, rec.Code)
+			if tc.expectedMessage != "" {
+				require.Equal(t, tc.expectedMessage, rec.Body.String())
+			}
+		})
+	}
+}
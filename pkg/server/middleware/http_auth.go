@@ -6,10 +6,17 @@ import (
 	"github.com/go-kit/log"
 
 	"github.com/grafana/dskit/user"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
 )
 
 type HTTPAuth struct {
 	log log.Logger
+
+	// strict rejects the requests without an org ID with an errorx.Unauthorized
+	// error, except on the unauthenticatedPaths.
+	strict               bool
+	unauthenticatedPaths map[string]bool
 }
 
 func NewHTTPAuth(log log.Logger) *HTTPAuth {
@@ -18,9 +25,38 @@ func NewHTTPAuth(log log.Logger) *HTTPAuth {
 	}
 }
 
+// NewStrictHTTPAuth creates an HTTPAuth rejecting the requests without an org
+// ID with a message explaining how to set it, except the requests for the
+// unauthenticatedPaths, like health checks, which are let through without an
+// org ID.
+func NewStrictHTTPAuth(log log.Logger, unauthenticatedPaths []string) *HTTPAuth {
+	paths := make(map[string]bool, len(unauthenticatedPaths))
+	for _, p := range unauthenticatedPaths {
+		paths[p] = true
+	}
+	return &HTTPAuth{
+		log:                  log,
+		strict:               true,
+		unauthenticatedPaths: paths,
+	}
+}
+
 func (h HTTPAuth) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done := StartServerTiming(r.Context(), "auth")
 		_, ctx, err := user.ExtractOrgIDFromHTTPRequest(r)
+		done()
+		if err != nil && h.strict {
+			if h.unauthenticatedPaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			errorx.LogAndSetHTTPError(r.Context(), w, h.log, errorx.Unauthorized{
+				Msg: "no org ID: set the " + user.OrgIDHeaderName + " header to the tenant ID",
+				Err: err,
+			})
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			logRequest(h.log, r, http.StatusUnauthorized)
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPAuth(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID, err := user.ExtractOrgID(r.Context())
+		if err != nil {
+			orgID = "none"
+		}
+		_, _ = w.Write([]byte(orgID))
+	})
+	serve := func(auth *HTTPAuth, path, orgID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if orgID != "" {
+			req.Header.Set(user.OrgIDHeaderName, orgID)
+		}
+		rec := httptest.NewRecorder()
+		auth.Wrap(handler).ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("default", func(t *testing.T) {
+		auth := NewHTTPAuth(log.NewNopLogger())
+
+		rec := serve(auth, "/render", "12345")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "12345", rec.Body.String())
+
+		rec = serve(auth, "/render", "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Equal(t, "no org id\n", rec.Body.String())
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		auth := NewStrictHTTPAuth(log.NewNopLogger(), []string{"/healthz"})
+
+		rec := serve(auth, "/render", "12345")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "12345", rec.Body.String())
+
+		rec = serve(auth, "/render", "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Equal(t, "no org ID: set the X-Scope-OrgID header to the tenant ID\n", rec.Body.String())
+
+		rec = serve(auth, "/healthz", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "none", rec.Body.String())
+
+		rec = serve(auth, "/healthz", "12345")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "12345", rec.Body.String())
+	})
+}
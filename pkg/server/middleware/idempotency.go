@@ -0,0 +1,224 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/user"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header clients use to mark retries
+	// of the same mutating request.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed from the cache.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// Idempotency caches the responses of mutating requests carrying an
+// Idempotency-Key header, per tenant, and replays them for retries of the same
+// request within the configured window, so retried requests don't have
+// duplicate effects.
+//
+// Responses with a 5xx status or of handlers that panicked aren't cached, so
+// those requests can be retried. Responses larger than the max body size
+// aren't kept, but their retries get a 409 rather than running the request
+// again, as do the retries arriving while the original request is still being
+// handled. Reusing a key for a different method or path gets a 422. Once the
+// cache holds max entries, the oldest completed ones are evicted to make room.
+type Idempotency struct {
+	window      time.Duration
+	maxEntries  int
+	maxBodySize int
+	logger      log.Logger
+	now         func() time.Time
+
+	mtx     sync.Mutex
+	entries map[string]*idempotencyEntry
+	// expiries holds the keys in insertion order. Since the window is the same
+	// for all of them, this is also their expiration order.
+	expiries []idempotencyExpiry
+}
+
+type idempotencyEntry struct {
+	method, path string
+	done         bool
+	expires      time.Time
+	// tooLarge is set when the response was too large to keep, and only its
+	// status code was.
+	tooLarge bool
+
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+type idempotencyExpiry struct {
+	key     string
+	expires time.Time
+}
+
+// NewIdempotencyMiddleware caches up to maxEntries responses for window. The
+// responses with a body larger than maxBodySize bytes aren't cached. 0
+// disables either limit.
+func NewIdempotencyMiddleware(window time.Duration, maxEntries, maxBodySize int, logger log.Logger) *Idempotency {
+	return &Idempotency{
+		window:      window,
+		maxEntries:  maxEntries,
+		maxBodySize: maxBodySize,
+		logger:      logger,
+		now:         time.Now,
+		entries:     map[string]*idempotencyEntry{},
+	}
+}
+
+func (m *Idempotency) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+		if idempotencyKey == "" || !isMutatingMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tenant, _ := user.ExtractOrgID(r.Context())
+		key := tenant + "\x00" + idempotencyKey
+
+		entry, found := m.reserve(key, r)
+		if found {
+			m.replay(w, r, entry)
+			return
+		}
+
+		completed := false
+		defer func() {
+			if !completed {
+				// The handler panicked, the request can be retried.
+				m.forget(key, entry)
+			}
+		}()
+		rec := newResponseRecorder(w, m.maxBodySize)
+		next.ServeHTTP(rec, r)
+		m.complete(key, entry, rec)
+		completed = true
+	})
+}
+
+// reserve returns the cached entry for key if there is one, or creates an
+// in-flight entry for it otherwise.
+func (m *Idempotency) reserve(key string, r *http.Request) (entry idempotencyEntry, found bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	now := m.now()
+	m.expire(now)
+
+	if e, ok := m.entries[key]; ok {
+		return *e, true
+	}
+	for m.maxEntries > 0 && len(m.entries) >= m.maxEntries && m.evictOldest() {
+	}
+	e := &idempotencyEntry{method: r.Method, path: r.URL.Path, expires: now.Add(m.window)}
+	m.entries[key] = e
+	m.expiries = append(m.expiries, idempotencyExpiry{key: key, expires: e.expires})
+	return *e, false
+}
+
+// complete stores the recorded response for key, or only its status code if
+// it's too large to keep, or forgets the key if the request can be retried.
+func (m *Idempotency) complete(key string, entry idempotencyEntry, rec *responseRecorder) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok || e.expires != entry.expires {
+		// The entry expired while the request was being handled.
+		return
+	}
+	if rec.statusCode >= http.StatusInternalServerError {
+		delete(m.entries, key)
+		return
+	}
+	e.done = true
+	e.statusCode = rec.statusCode
+	if rec.truncated {
+		e.tooLarge = true
+		return
+	}
+	e.header = rec.Header().Clone()
+	e.body = rec.body.Bytes()
+}
+
+// forget removes the in-flight entry for key, unless it was replaced since.
+func (m *Idempotency) forget(key string, entry idempotencyEntry) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if e, ok := m.entries[key]; ok && e.expires == entry.expires && !e.done {
+		delete(m.entries, key)
+	}
+}
+
+func (m *Idempotency) replay(w http.ResponseWriter, r *http.Request, entry idempotencyEntry) {
+	switch {
+	case entry.method != r.Method || entry.path != r.URL.Path:
+		_ = level.Warn(m.logger).Log("msg", "idempotency key reused for a different request", "method", r.Method, "path", r.URL.Path)
+		http.Error(w, "idempotency key was already used for a different request", http.StatusUnprocessableEntity)
+	case !entry.done:
+		http.Error(w, "a request with the same idempotency key is still in progress", http.StatusConflict)
+	case entry.tooLarge:
+		http.Error(w, fmt.Sprintf("a request with the same idempotency key already completed with status %d, but its response is too large to replay", entry.statusCode), http.StatusConflict)
+	default:
+		for k, v := range entry.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		w.WriteHeader(entry.statusCode)
+		_, _ = w.Write(entry.body)
+	}
+}
+
+// expire removes the entries that expired before now. It must be called with
+// the mutex held.
+func (m *Idempotency) expire(now time.Time) {
+	i := 0
+	for ; i < len(m.expiries) && !m.expiries[i].expires.After(now); i++ {
+		if e, ok := m.entries[m.expiries[i].key]; ok && e.expires == m.expiries[i].expires {
+			delete(m.entries, m.expiries[i].key)
+		}
+	}
+	m.expiries = m.expiries[i:]
+}
+
+// evictOldest removes the completed entry expiring first, and returns false
+// if there's none. The in-flight entries are kept, as their retries would
+// otherwise run the request again. It must be called with the mutex held.
+func (m *Idempotency) evictOldest() bool {
+	for i := 0; i < len(m.expiries); {
+		oldest := m.expiries[i]
+		e, ok := m.entries[oldest.key]
+		switch {
+		case !ok || e.expires != oldest.expires:
+			// The entry was already removed.
+			m.expiries = append(m.expiries[:i], m.expiries[i+1:]...)
+		case !e.done:
+			i++
+		default:
+			delete(m.entries, oldest.key)
+			m.expiries = append(m.expiries[:i], m.expiries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
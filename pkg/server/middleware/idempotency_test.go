@@ -0,0 +1,209 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	now := time.Now()
+	calls := 0
+	status := http.StatusCreated
+	m := NewIdempotencyMiddleware(time.Minute, 0, 0, log.NewNopLogger())
+	m.now = func() time.Time { return now }
+	handler := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Call", fmt.Sprint(calls))
+		w.WriteHeader(status)
+		_, _ = fmt.Fprintf(w, "call %d", calls)
+	}))
+
+	do := func(method, path, tenant, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		req = req.WithContext(user.InjectOrgID(req.Context(), tenant))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("retries are replayed", func(t *testing.T) {
+		first := do(http.MethodPost, "/events", "tenant-1", "key-1")
+		require.Equal(t, http.StatusCreated, first.Code)
+		require.Equal(t, "call 1", first.Body.String())
+
+		retry := do(http.MethodPost, "/events", "tenant-1", "key-1")
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, "call 1", retry.Body.String())
+		assert.Equal(t, "1", retry.Header().Get("X-Call"))
+		assert.Equal(t, "true", retry.Header().Get(IdempotentReplayedHeader))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("keys are per tenant", func(t *testing.T) {
+		resp := do(http.MethodPost, "/events", "tenant-2", "key-1")
+		assert.Equal(t, "call 2", resp.Body.String())
+	})
+
+	t.Run("requests without key or non mutating are not cached", func(t *testing.T) {
+		assert.Equal(t, "call 3", do(http.MethodPost, "/events", "tenant-1", "").Body.String())
+		assert.Equal(t, "call 4", do(http.MethodGet, "/events", "tenant-1", "key-2").Body.String())
+		assert.Equal(t, "call 5", do(http.MethodGet, "/events", "tenant-1", "key-2").Body.String())
+	})
+
+	t.Run("key reused for a different request", func(t *testing.T) {
+		resp := do(http.MethodPost, "/admin", "tenant-1", "key-1")
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+		assert.Equal(t, 5, calls)
+	})
+
+	t.Run("server errors are not cached", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		assert.Equal(t, http.StatusInternalServerError, do(http.MethodPost, "/events", "tenant-1", "key-3").Code)
+		status = http.StatusCreated
+		assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/events", "tenant-1", "key-3").Code)
+		assert.Equal(t, 7, calls)
+	})
+
+	t.Run("entries expire after the window", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		resp := do(http.MethodPost, "/events", "tenant-1", "key-1")
+		assert.Equal(t, "call 8", resp.Body.String())
+		assert.Empty(t, resp.Header().Get(IdempotentReplayedHeader))
+	})
+}
+
+func TestIdempotencyMiddlewareInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := NewIdempotencyMiddleware(time.Minute, 0, 0, log.NewNopLogger()).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPut, "/events", nil)
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		return req
+	}
+
+	done := make(chan int)
+	go func() {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, newRequest())
+		done <- resp.Code
+	}()
+	<-started
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, newRequest())
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	close(release)
+	assert.Equal(t, http.StatusAccepted, <-done)
+}
+
+func TestIdempotencyMiddlewareLimits(t *testing.T) {
+	calls := 0
+	body := "short"
+	handler := NewIdempotencyMiddleware(time.Minute, 2, 10, log.NewNopLogger()).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/panic" {
+			panic(http.ErrAbortHandler)
+		}
+		_, _ = fmt.Fprint(w, body)
+	}))
+	do := func(path, key string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set(IdempotencyKeyHeader, key)
+		resp := httptest.NewRecorder()
+		func() {
+			defer func() { _ = recover() }()
+			handler.ServeHTTP(resp, req)
+		}()
+		return resp.Code
+	}
+
+	t.Run("oldest entries are evicted", func(t *testing.T) {
+		calls = 0
+		do("/events", "key-1")
+		do("/events", "key-2")
+		do("/events", "key-3")
+		do("/events", "key-3")
+		assert.Equal(t, 3, calls)
+		do("/events", "key-1")
+		assert.Equal(t, 4, calls)
+	})
+
+	t.Run("retries of large responses conflict", func(t *testing.T) {
+		calls, body = 0, "a response too large to keep"
+		assert.Equal(t, http.StatusOK, do("/events", "key-4"))
+		assert.Equal(t, http.StatusConflict, do("/events", "key-4"))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("panicking requests can be retried", func(t *testing.T) {
+		calls = 0
+		do("/panic", "key-5")
+		do("/panic", "key-5")
+		assert.Equal(t, 2, calls)
+	})
+}
+
+func TestIdempotencyMiddlewareEvictionKeepsInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	calls := 0
+	handler := NewIdempotencyMiddleware(time.Minute, 1, 0, log.NewNopLogger()).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	do := func(path, key string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set(IdempotencyKeyHeader, key)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	done := make(chan int)
+	go func() { done <- do("/slow", "key-1") }()
+	<-started
+
+	// The cache is full of the in-flight entry, which isn't evicted for
+	// the new one.
+	assert.Equal(t, http.StatusAccepted, do("/events", "key-2"))
+	assert.Equal(t, http.StatusConflict, do("/slow", "key-1"))
+	close(release)
+	assert.Equal(t, http.StatusAccepted, <-done)
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyMiddlewareFlush(t *testing.T) {
+	handler := NewIdempotencyMiddleware(time.Minute, 0, 0, log.NewNopLogger()).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "streamed")
+		flusher, ok := w.(http.Flusher)
+		require.True(t, ok)
+		flusher.Flush()
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/events", nil)
+	req.Header.Set(IdempotencyKeyHeader, "key")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.True(t, resp.Flushed)
+	assert.Equal(t, "streamed", resp.Body.String())
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/dskit/user"
+)
+
+// InflightRequest describes a request being served.
+type InflightRequest struct {
+	Method   string        `json:"method"`
+	Path     string        `json:"path"`
+	Route    string        `json:"route"`
+	Tenant   string        `json:"tenant,omitempty"`
+	TraceID  string        `json:"trace_id,omitempty"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+}
+
+// InflightTracker keeps track of the requests being served, to debug stuck
+// requests live.
+type InflightTracker struct {
+	mtx      sync.Mutex
+	nextID   uint64
+	requests map[uint64]InflightRequest
+}
+
+func NewInflightTracker() *InflightTracker {
+	return &InflightTracker{requests: map[uint64]InflightRequest{}}
+}
+
+// add tracks r until the returned function is called. The tenant is read from
+// the org ID header, since the request isn't authenticated yet.
+func (t *InflightTracker) add(r *http.Request, route string, start time.Time) (done func()) {
+	traceID, _ := ExtractTraceID(r.Context())
+	req := InflightRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Route:   route,
+		Tenant:  r.Header.Get(user.OrgIDHeaderName),
+		TraceID: traceID,
+		Start:   start,
+	}
+
+	t.mtx.Lock()
+	id := t.nextID
+	t.nextID++
+	t.requests[id] = req
+	t.mtx.Unlock()
+
+	return func() {
+		t.mtx.Lock()
+		delete(t.requests, id)
+		t.mtx.Unlock()
+	}
+}
+
+// Requests returns the requests being served, the longest running first.
+func (t *InflightTracker) Requests() []InflightRequest {
+	now := time.Now()
+	t.mtx.Lock()
+	requests := make([]InflightRequest, 0, len(t.requests))
+	for _, req := range t.requests {
+		req.Duration = now.Sub(req.Start)
+		requests = append(requests, req)
+	}
+	t.mtx.Unlock()
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Start.Before(requests[j].Start)
+	})
+	return requests
+}
+
+// Handler returns a handler listing the requests being served as JSON, for
+// admin endpoints: it lists the requests of all the tenants.
+func (t *InflightTracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Requests []InflightRequest `json:"requests"`
+		}{t.Requests()})
+	})
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInflightTracker(t *testing.T) {
+	router := mux.NewRouter()
+	instrument, err := NewInstrument(router, nil, "inflight_test")
+	require.NoError(t, err)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	router.Path("/slow").Name("slow").HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-release
+	})
+	handler := instrument.Wrap(router)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodPost, "/slow", nil)
+		req.Header.Set(user.OrgIDHeaderName, "tenant-1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	instrument.Inflight().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/inflight", nil))
+	var resp struct {
+		Requests []InflightRequest `json:"requests"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Requests, 1)
+	require.Equal(t, http.MethodPost, resp.Requests[0].Method)
+	require.Equal(t, "/slow", resp.Requests[0].Path)
+	require.Equal(t, "slow", resp.Requests[0].Route)
+	require.Equal(t, "tenant-1", resp.Requests[0].Tenant)
+	require.Positive(t, resp.Requests[0].Duration)
+
+	close(release)
+	<-done
+	require.Empty(t, instrument.Inflight().Requests())
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/felixge/httpsnoop"
@@ -14,6 +15,11 @@ import (
 const (
 	mb = 1024 * 1024
 	kb = 1024
+
+	// maxRouteLabelValues bounds the route label values, the routes beyond
+	// it are counted as otherRoute.
+	maxRouteLabelValues = 200
+	otherRoute          = "other"
 )
 
 // Instrument is a Middleware which records timings for every HTTP request
@@ -23,6 +29,29 @@ type Instrument struct {
 	requestBodySize  *prometheus.HistogramVec
 	responseBodySize *prometheus.HistogramVec
 	inflightRequests *prometheus.GaugeVec
+	inflight         *InflightTracker
+	routes           *routeLabelValues
+}
+
+// routeLabelValues are the route label values used so far.
+type routeLabelValues struct {
+	mtx    sync.Mutex
+	values map[string]struct{}
+}
+
+// get returns route if it's already used or there's room for it, or
+// otherRoute.
+func (v *routeLabelValues) get(route string) string {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	if _, ok := v.values[route]; ok {
+		return route
+	}
+	if len(v.values) >= maxRouteLabelValues {
+		return otherRoute
+	}
+	v.values[route] = struct{}{}
+	return route
 }
 
 var (
@@ -77,13 +106,18 @@ func NewInstrument(routeMatcher RouteMatcher, defBuckets []float64, prefix strin
 		requestBodySize:  receivedMessageSize,
 		responseBodySize: sentMessageSize,
 		inflightRequests: inflightRequests,
+		inflight:         NewInflightTracker(),
+		routes:           &routeLabelValues{values: map[string]struct{}{}},
 	}, nil
 
 }
 
 type instrumentContextKey int
 
-const requestBeginContextKey instrumentContextKey = 0
+const (
+	requestBeginContextKey instrumentContextKey = iota
+	routeContextKey
+)
 
 func extractRequestBeginTime(ctx context.Context) (time.Time, bool) {
 	begin := ctx.Value(requestBeginContextKey)
@@ -95,16 +129,25 @@ func extractRequestBeginTime(ctx context.Context) (time.Time, bool) {
 
 }
 
+// ExtractRoute returns the route of the request in ctx, as used in the
+// metrics of the Instrument middleware.
+func ExtractRoute(ctx context.Context) (string, bool) {
+	route, ok := ctx.Value(routeContextKey).(string)
+	return route, ok
+}
+
 // Wrap implements middleware.Interface
 func (i Instrument) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		begin := time.Now()
-		r = r.WithContext(context.WithValue(r.Context(), requestBeginContextKey, begin))
-
 		route := i.getRouteName(r)
+		ctx := context.WithValue(r.Context(), requestBeginContextKey, begin)
+		r = r.WithContext(context.WithValue(ctx, routeContextKey, route))
+
 		inflight := i.inflightRequests.WithLabelValues(r.Method, route)
 		inflight.Inc()
 		defer inflight.Dec()
+		defer i.inflight.add(r, route, begin)()
 
 		origBody := r.Body
 		defer func() {
@@ -136,6 +179,11 @@ func (i Instrument) Wrap(next http.Handler) http.Handler {
 	})
 }
 
+// Inflight returns the tracker of the requests being served.
+func (i Instrument) Inflight() *InflightTracker {
+	return i.inflight
+}
+
 // Return a name identifier for ths request.  There are three options:
 //  1. The request matches a gorilla mux route, with a name.  Use that.
 //  2. The request matches an unamed gorilla mux router.  Munge the path
@@ -144,14 +192,15 @@ func (i Instrument) Wrap(next http.Handler) http.Handler {
 //  3. The request doesn't match a mux route. Return "other"
 //
 // We do all this as we do not wish to emit high cardinality labels to
-// prometheus.
+// prometheus. For the same reason, the routes beyond the first
+// maxRouteLabelValues are also "other".
 func (i Instrument) getRouteName(r *http.Request) string {
 	route := getRouteName(i.routeMatcher, r)
 	if route == "" {
-		route = "other"
+		return otherRoute
 	}
 
-	return route
+	return i.routes.get(route)
 }
 
 type reqBody struct {
@@ -1,9 +1,14 @@
 package middleware
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/gorilla/mux"
 	"github.com/grafana/dskit/middleware"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMakeLabelValue(t *testing.T) {
@@ -28,3 +33,32 @@ func TestMakeLabelValue(t *testing.T) {
 		}
 	}
 }
+
+func TestInstrumentRoute(t *testing.T) {
+	router := mux.NewRouter()
+	instrument, err := NewInstrument(router, nil, "route_test")
+	require.NoError(t, err)
+
+	for i := 0; i <= maxRouteLabelValues; i++ {
+		router.Path(fmt.Sprintf("/route%d/{id}", i))
+	}
+	var route string
+	handler := instrument.Wrap(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		route, _ = ExtractRoute(r.Context())
+	}))
+	serve := func(path string) string {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		return route
+	}
+
+	require.Equal(t, "route0__id", serve("/route0/1"))
+	require.Equal(t, "route0__id", serve("/route0/2"))
+	require.Equal(t, otherRoute, serve("/unmatched"))
+
+	// The routes beyond maxRouteLabelValues are counted as otherRoute.
+	for i := 1; i < maxRouteLabelValues; i++ {
+		require.Equal(t, fmt.Sprintf("route%d__id", i), serve(fmt.Sprintf("/route%d/1", i)))
+	}
+	require.Equal(t, otherRoute, serve(fmt.Sprintf("/route%d/1", maxRouteLabelValues)))
+	require.Equal(t, "route0__id", serve("/route0/3"))
+}
@@ -62,6 +62,10 @@ func logRequest(logger log.Logger, r *http.Request, statusCode int) {
 		}
 	}
 
+	if route, ok := ExtractRoute(r.Context()); ok {
+		logger = log.With(logger, "route", route)
+	}
+
 	orgID, err := user.ExtractOrgID(r.Context())
 	if err == nil {
 		logger = log.With(logger, "orgID", orgID)
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -68,6 +69,21 @@ func TestLogRequest(t *testing.T) {
 		assert.Contains(t, line, path)
 		assert.NotContains(t, line, "api_key")
 	})
+
+	t.Run("logs the route", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		logger := log.NewLogfmtLogger(buf)
+
+		req, err := http.NewRequest(http.MethodGet, "https://example.com/render/12345", http.NoBody)
+		require.NoError(t, err)
+		req = req.WithContext(context.WithValue(req.Context(), routeContextKey, "render_id"))
+
+		logRequest(logger, req, http.StatusOK)
+
+		line := buf.String()
+		assert.Contains(t, line, "route=render_id")
+		assert.Contains(t, line, "/render/12345")
+	})
 }
 
 func TestLoggingMiddleware(t *testing.T) {
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/errorx"
+)
+
+// Recovery is a middleware answering the requests whose handler panics with
+// a 500, logging the panic with its stack, instead of letting net/http close
+// the connection.
+type Recovery struct {
+	log log.Logger
+}
+
+func NewRecoveryMiddleware(log log.Logger) Recovery {
+	return Recovery{log: log}
+}
+
+// Wrap implements middleware.Interface
+func (m Recovery) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler { //nolint:errorlint
+				// Aborting handlers panic on purpose.
+				panic(p)
+			}
+			err := errorx.FromPanic(p)
+			_ = level.Error(m.log).Log("msg", "panic serving request", "method", r.Method, "path", r.URL.Path, "err", err, "stack", errorx.StackTrace(err))
+			// The panic is already logged, with its stack.
+			errorx.LogAndSetHTTPError(r.Context(), w, log.NewNopLogger(), err)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
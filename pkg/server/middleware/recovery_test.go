@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecovery(t *testing.T) {
+	var logs bytes.Buffer
+	recovery := NewRecoveryMiddleware(log.NewLogfmtLogger(&logs))
+
+	rec := httptest.NewRecorder()
+	recovery.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		var m map[string]int
+		m["boom"]++
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/render", nil))
+
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Equal(t, "internal server error\n", rec.Body.String())
+	require.Contains(t, logs.String(), `msg="panic serving request"`)
+	require.Contains(t, logs.String(), "assignment to entry in nil map")
+	require.Contains(t, logs.String(), "middleware.TestRecovery")
+
+	t.Run("abort handler", func(t *testing.T) {
+		require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			recovery.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				panic(http.ErrAbortHandler)
+			})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/render", nil))
+		})
+	})
+}
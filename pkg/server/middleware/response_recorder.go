@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// responseRecorder keeps a copy of the status code and body of a response, up
+// to maxBodySize bytes of body if positive. The response is passed through to
+// next if set, and only kept otherwise, eg. to be written later.
+type responseRecorder struct {
+	next        http.ResponseWriter
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+	maxBodySize int
+	// truncated is set when the body is larger than maxBodySize, and the copy
+	// was dropped.
+	truncated bool
+	// err is set by NewShadowProxy when it couldn't get a response.
+	err error
+}
+
+func newResponseRecorder(next http.ResponseWriter, maxBodySize int) *responseRecorder {
+	return &responseRecorder{next: next, header: http.Header{}, statusCode: http.StatusOK, maxBodySize: maxBodySize}
+}
+
+func (w *responseRecorder) Header() http.Header {
+	if w.next != nil {
+		return w.next.Header()
+	}
+	return w.header
+}
+
+func (w *responseRecorder) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.statusCode = statusCode
+		w.wroteHeader = true
+	}
+	if w.next != nil {
+		w.next.WriteHeader(statusCode)
+	}
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	switch {
+	case w.truncated:
+	case w.maxBodySize > 0 && w.body.Len()+len(data) > w.maxBodySize:
+		w.truncated = true
+		w.body = bytes.Buffer{}
+	default:
+		w.body.Write(data)
+	}
+	if w.next != nil {
+		return w.next.Write(data)
+	}
+	return len(data), nil
+}
+
+// Flush implements http.Flusher, flushing next if it supports it, so that
+// streaming handlers keep streaming through the middlewares recording their
+// responses.
+func (w *responseRecorder) Flush() {
+	w.wroteHeader = true
+	if f, ok := w.next.(http.Flusher); ok {
+		f.Flush()
+	}
+}
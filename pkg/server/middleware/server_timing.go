@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+const serverTimingHeader = "Server-Timing"
+
+type serverTimingContextKey int
+
+const serverTimingKey serverTimingContextKey = 0
+
+// ServerTiming is a Middleware adding a Server-Timing response header with the
+// durations of the request phases recorded with RecordServerTiming, plus the
+// total time until the response headers were written. Server-Timing values
+// set by the handler, like ones copied from a downstream response, are kept.
+type ServerTiming struct{}
+
+// serverTimings holds the phase durations of a request, in the order they
+// were first recorded. Durations recorded for the same phase add up.
+type serverTimings struct {
+	mtx       sync.Mutex
+	names     []string
+	durations map[string]time.Duration
+}
+
+func NewServerTimingMiddleware() ServerTiming {
+	return ServerTiming{}
+}
+
+// RecordServerTiming adds d to the duration of the named phase of the request
+// in ctx. It's a no-op if the request isn't served through the ServerTiming
+// middleware. Names must be HTTP tokens, like "auth" or "remote_write".
+func RecordServerTiming(ctx context.Context, name string, d time.Duration) {
+	timings, ok := ctx.Value(serverTimingKey).(*serverTimings)
+	if !ok {
+		return
+	}
+	timings.mtx.Lock()
+	defer timings.mtx.Unlock()
+	if _, ok := timings.durations[name]; !ok {
+		timings.names = append(timings.names, name)
+	}
+	timings.durations[name] += d
+}
+
+// StartServerTiming starts timing the named phase of the request in ctx, and
+// returns a function to call when the phase is done.
+func StartServerTiming(ctx context.Context, name string) (done func()) {
+	start := time.Now()
+	return func() {
+		RecordServerTiming(ctx, name, time.Since(start))
+	}
+}
+
+func (t *serverTimings) header() string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	metrics := make([]string, 0, len(t.names))
+	for _, name := range t.names {
+		metrics = append(metrics, fmt.Sprintf("%s;dur=%.3f", name, float64(t.durations[name].Microseconds())/1000))
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// Wrap implements middleware.Interface
+func (ServerTiming) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		timings := &serverTimings{durations: map[string]time.Duration{}}
+		r = r.WithContext(context.WithValue(r.Context(), serverTimingKey, timings))
+
+		var once sync.Once
+		setHeader := func() {
+			once.Do(func() {
+				RecordServerTiming(r.Context(), "total", time.Since(start))
+				w.Header().Add(serverTimingHeader, timings.header())
+			})
+		}
+
+		ww := httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					setHeader()
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					setHeader()
+					return next(b)
+				}
+			},
+			ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					setHeader()
+					return next(src)
+				}
+			},
+		})
+		next.ServeHTTP(ww, r)
+		// Nothing was written, the headers are sent when the handler returns.
+		setHeader()
+	})
+}
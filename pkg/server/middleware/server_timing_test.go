@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerTiming(t *testing.T) {
+	for name, tc := range map[string]struct {
+		handler    http.HandlerFunc
+		wantHeader []*regexp.Regexp
+	}{
+		"phases are added up in recording order": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				RecordServerTiming(r.Context(), "auth", 2*time.Millisecond)
+				RecordServerTiming(r.Context(), "remote_write", 1500*time.Microsecond)
+				RecordServerTiming(r.Context(), "auth", time.Millisecond)
+				_, _ = w.Write([]byte("ok"))
+			},
+			wantHeader: []*regexp.Regexp{
+				regexp.MustCompile(`^auth;dur=3\.000, remote_write;dur=1\.500, total;dur=\d+\.\d{3}$`),
+			},
+		},
+		"existing values are kept": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Server-Timing", "mimir;dur=10")
+				w.WriteHeader(http.StatusAccepted)
+				// Recorded after the headers were sent, so ignored.
+				RecordServerTiming(r.Context(), "late", time.Millisecond)
+			},
+			wantHeader: []*regexp.Regexp{
+				regexp.MustCompile(`^mimir;dur=10$`),
+				regexp.MustCompile(`^total;dur=\d+\.\d{3}$`),
+			},
+		},
+		"nothing written": {
+			handler: func(http.ResponseWriter, *http.Request) {},
+			wantHeader: []*regexp.Regexp{
+				regexp.MustCompile(`^total;dur=\d+\.\d{3}$`),
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			NewServerTimingMiddleware().Wrap(tc.handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			got := rec.Result().Header.Values("Server-Timing")
+			require.Len(t, got, len(tc.wantHeader), got)
+			for i, want := range tc.wantHeader {
+				require.Regexp(t, want, got[i])
+			}
+		})
+	}
+}
+
+func TestRecordServerTiming_WithoutMiddleware(t *testing.T) {
+	require.NotPanics(t, func() {
+		RecordServerTiming(context.Background(), "auth", time.Second)
+		StartServerTiming(context.Background(), "auth")()
+	})
+}
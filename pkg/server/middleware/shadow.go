@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Shadow is a Middleware mirroring a percentage of the requests to a second
+// handler, eg. a new implementation of the same API, and comparing its
+// responses with the ones of the wrapped handler. The responses of the shadow
+// handler are discarded: only the comparison is recorded, in the
+// shadow_requests_total metric.
+//
+// Only GET and HEAD requests are mirrored, so writes aren't applied twice.
+// The shadow requests are handled in the background once the response to the
+// client was written, with the tenant of the original request and the
+// timeout, so they don't add latency to it. At most shadowMaxInFlight are
+// handled at once, the others are dropped, and the responses larger than
+// shadowMaxBodySize aren't compared.
+type Shadow struct {
+	shadow   http.Handler
+	percent  float64
+	timeout  time.Duration
+	logger   log.Logger
+	sample   func() float64
+	inFlight chan struct{}
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+const (
+	// shadowMaxInFlight is the max number of shadow requests handled at once.
+	shadowMaxInFlight = 100
+	// shadowMaxBodySize is the max size of the responses compared.
+	shadowMaxBodySize = 1 << 20
+	// defaultShadowTimeout bounds the shadow requests when no timeout is set.
+	defaultShadowTimeout = 30 * time.Second
+)
+
+// NewShadowMiddleware creates a Shadow mirroring percent (0 to 100) of the
+// GET and HEAD requests to shadow, which must handle them within timeout,
+// defaultShadowTimeout if not positive.
+func NewShadowMiddleware(shadow http.Handler, percent float64, timeout time.Duration, prefix string, reg prometheus.Registerer, logger log.Logger) *Shadow {
+	if timeout <= 0 {
+		timeout = defaultShadowTimeout
+	}
+	m := &Shadow{
+		shadow:   shadow,
+		percent:  percent,
+		timeout:  timeout,
+		logger:   logger,
+		sample:   rand.Float64,
+		inFlight: make(chan struct{}, shadowMaxInFlight),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "shadow_requests_total",
+			Help:      "The total number of requests mirrored to the shadow handler, by how its response compared to the primary one.",
+		}, []string{"result"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: prefix,
+			Name:      "shadow_request_duration_seconds",
+			Help:      "Time spent on the requests by the primary and shadow handlers, for the mirrored requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"handler"}),
+	}
+	reg.MustRegister(m.requests, m.duration)
+	return m
+}
+
+// Results of the shadow requests.
+const (
+	shadowMatch          = "match"
+	shadowStatusMismatch = "status_mismatch"
+	shadowBodyMismatch   = "body_mismatch"
+	shadowError          = "error"
+	// shadowDropped is for the requests not mirrored because too many shadow
+	// requests were in flight.
+	shadowDropped = "dropped"
+	// shadowTooLarge is for the responses too large to be compared.
+	shadowTooLarge = "too_large"
+)
+
+// Wrap implements middleware.Interface
+func (m *Shadow) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || m.sample()*100 >= m.percent {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Cloned before the primary handler can modify the request.
+		shadowReq := r.Clone(context.WithoutCancel(r.Context()))
+		shadowReq.Body = http.NoBody
+
+		start := time.Now()
+		rec := newResponseRecorder(w, shadowMaxBodySize)
+		next.ServeHTTP(rec, r)
+		m.duration.WithLabelValues("primary").Observe(time.Since(start).Seconds())
+		if rec.truncated {
+			m.requests.WithLabelValues(shadowTooLarge).Inc()
+			return
+		}
+
+		select {
+		case m.inFlight <- struct{}{}:
+		default:
+			m.requests.WithLabelValues(shadowDropped).Inc()
+			return
+		}
+		go func() {
+			defer func() { <-m.inFlight }()
+			m.mirror(shadowReq, rec.statusCode, rec.body.Bytes())
+		}()
+	})
+}
+
+func (m *Shadow) mirror(r *http.Request, statusCode int, body []byte) {
+	ctx, cancel := context.WithTimeout(r.Context(), m.timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	start := time.Now()
+	rec := newResponseRecorder(nil, shadowMaxBodySize)
+	m.shadow.ServeHTTP(rec, r)
+	m.duration.WithLabelValues("shadow").Observe(time.Since(start).Seconds())
+
+	result := shadowMatch
+	switch {
+	case ctx.Err() != nil || rec.err != nil:
+		result = shadowError
+	case rec.statusCode != statusCode:
+		result = shadowStatusMismatch
+	case rec.truncated:
+		result = shadowTooLarge
+	case !bytes.Equal(rec.body.Bytes(), body):
+		result = shadowBodyMismatch
+	}
+	m.requests.WithLabelValues(result).Inc()
+	if result != shadowMatch {
+		level.Debug(m.logger).Log("msg", "shadow response differs", "result", result, "method", r.Method, "path", r.URL.Path, "status", statusCode, "shadow_status", rec.statusCode)
+	}
+}
+
+// NewShadowProxy returns a handler for Shadow forwarding the requests to the
+// same path on target with client, and copying the responses.
+func NewShadowProxy(target *url.URL, client *http.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		u.Scheme, u.Host = target.Scheme, target.Host
+		u.Path = target.JoinPath(r.URL.Path).Path
+
+		req, err := http.NewRequestWithContext(r.Context(), r.Method, u.String(), r.Body)
+		if err != nil {
+			shadowProxyError(w, err)
+			return
+		}
+		req.Header = r.Header.Clone()
+		resp, err := client.Do(req)
+		if err != nil {
+			shadowProxyError(w, err)
+			return
+		}
+		defer resp.Body.Close()
+
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	})
+}
+
+func shadowProxyError(w http.ResponseWriter, err error) {
+	if rec, ok := w.(*responseRecorder); ok {
+		rec.err = err
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShadow(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	shadowRequests := make(chan string, 10)
+	release := make(chan struct{})
+	shadow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, _ := user.ExtractOrgID(r.Context())
+		shadowRequests <- tenant + ":" + r.Method + " " + r.URL.Path
+		switch r.URL.Path {
+		case "/status":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/body":
+			_, _ = w.Write([]byte("other"))
+		case "/slow":
+			<-r.Context().Done()
+		case "/blocked":
+			<-release
+		default:
+			_, _ = w.Write([]byte("ok"))
+		}
+	})
+	m := NewShadowMiddleware(shadow, 50, 50*time.Millisecond, "test", reg, log.NewNopLogger())
+	handler := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large" {
+			_, _ = w.Write(make([]byte, shadowMaxBodySize+1))
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	serve := func(method, path string) {
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(user.InjectOrgID(req.Context(), "tenant-1"))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	requireResults := func(want map[string]float64) {
+		t.Helper()
+		for result, count := range want {
+			require.Eventually(t, func() bool {
+				return testutil.ToFloat64(m.requests.WithLabelValues(result)) == count
+			}, time.Second, 10*time.Millisecond, result)
+		}
+	}
+
+	m.sample = func() float64 { return 0.5 }
+	serve(http.MethodGet, "/match")
+	require.Empty(t, shadowRequests, "requests above the percentage aren't mirrored")
+
+	m.sample = func() float64 { return 0.49 }
+	serve(http.MethodPost, "/match")
+	require.Empty(t, shadowRequests, "writes aren't mirrored")
+
+	for _, path := range []string{"/match", "/status", "/body", "/slow"} {
+		serve(http.MethodGet, path)
+		require.Equal(t, "tenant-1:GET "+path, <-shadowRequests)
+	}
+	serve(http.MethodGet, "/large")
+	requireResults(map[string]float64{
+		shadowMatch:          1,
+		shadowStatusMismatch: 1,
+		shadowBodyMismatch:   1,
+		shadowError:          1,
+		shadowTooLarge:       1,
+	})
+	require.Empty(t, shadowRequests, "responses too large to be compared aren't mirrored")
+
+	// The requests are dropped while too many are in flight.
+	for i := 0; i < shadowMaxInFlight; i++ {
+		serve(http.MethodGet, "/blocked")
+		<-shadowRequests
+	}
+	serve(http.MethodGet, "/match")
+	requireResults(map[string]float64{shadowDropped: 1})
+	close(release)
+	require.Eventually(t, func() bool { return len(m.inFlight) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestShadowProxy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Path", r.URL.Path+"?"+r.URL.RawQuery)
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(r.Header.Get(user.OrgIDHeaderName) + ":" + string(body)))
+	}))
+	defer srv.Close()
+
+	target, err := url.Parse(srv.URL + "/prefix")
+	require.NoError(t, err)
+	proxy := NewShadowProxy(target, srv.Client())
+
+	req := httptest.NewRequest(http.MethodPost, "/render?target=a.b", strings.NewReader("request body"))
+	req.Header.Set(user.OrgIDHeaderName, "tenant-1")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.Equal(t, "/prefix/render?target=a.b", rec.Header().Get("X-Path"))
+	require.Equal(t, "tenant-1:request body", rec.Body.String())
+}
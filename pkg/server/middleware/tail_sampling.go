@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/grafana/dskit/user"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+const (
+	// TailSamplingKeepTag is set to true on the spans of the requests that
+	// should be kept by tail sampling, for the collector's policies to match.
+	TailSamplingKeepTag = "sampling.keep"
+	// TailSamplingReasonTag says why the trace should be kept: error, slow
+	// or tenant.
+	TailSamplingReasonTag = "sampling.reason"
+)
+
+// TailSampling is a Middleware marking the spans of the requests worth
+// keeping, the ones that failed, were slow or are for some tenants, so
+// they're kept by tail sampling. It also raises their sampling priority, so
+// head sampling doesn't drop them. It must run after the auth middleware, so
+// the tenant is set in the request context.
+type TailSampling struct {
+	latencyThreshold time.Duration
+	tenants          map[string]bool
+}
+
+// NewTailSamplingMiddleware creates a TailSampling marking the requests
+// failing with a 5xx, slower than latencyThreshold if positive, or for
+// tenants.
+func NewTailSamplingMiddleware(latencyThreshold time.Duration, tenants []string) *TailSampling {
+	m := &TailSampling{
+		latencyThreshold: latencyThreshold,
+		tenants:          make(map[string]bool, len(tenants)),
+	}
+	for _, tenant := range tenants {
+		m.tenants[tenant] = true
+	}
+	return m
+}
+
+// Wrap implements middleware.Interface
+func (m TailSampling) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := opentracing.SpanFromContext(r.Context())
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		begin, ok := extractRequestBeginTime(r.Context())
+		if !ok {
+			begin = time.Now()
+		}
+
+		respMetrics := httpsnoop.CaptureMetricsFn(w, func(ww http.ResponseWriter) {
+			next.ServeHTTP(ww, r)
+		})
+
+		reason := ""
+		tenant, _ := user.ExtractOrgID(r.Context())
+		switch {
+		case respMetrics.Code >= http.StatusInternalServerError:
+			reason = "error"
+		case m.latencyThreshold > 0 && time.Since(begin) > m.latencyThreshold:
+			reason = "slow"
+		case m.tenants[tenant]:
+			reason = "tenant"
+		default:
+			return
+		}
+		span.SetTag(TailSamplingKeepTag, true)
+		span.SetTag(TailSamplingReasonTag, reason)
+		ext.SamplingPriority.Set(span, 1)
+	})
+}
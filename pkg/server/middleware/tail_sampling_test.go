@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailSampling(t *testing.T) {
+	m := NewTailSamplingMiddleware(20*time.Millisecond, []string{"debugged-tenant"})
+
+	for name, tc := range map[string]struct {
+		tenant         string
+		status         int
+		delay          time.Duration
+		expectedReason string
+	}{
+		"ok":     {tenant: "tenant-1", status: http.StatusOK},
+		"4xx":    {tenant: "tenant-1", status: http.StatusBadRequest},
+		"5xx":    {tenant: "tenant-1", status: http.StatusServiceUnavailable, expectedReason: "error"},
+		"slow":   {tenant: "tenant-1", status: http.StatusOK, delay: 30 * time.Millisecond, expectedReason: "slow"},
+		"tenant": {tenant: "debugged-tenant", status: http.StatusOK, expectedReason: "tenant"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			span := tracer.StartSpan("request")
+			ctx := user.InjectOrgID(opentracing.ContextWithSpan(context.Background(), span), tc.tenant)
+
+			handler := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				time.Sleep(tc.delay)
+				w.WriteHeader(tc.status)
+			}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+			span.Finish()
+
+			require.Equal(t, tc.status, rec.Code)
+			tags := tracer.FinishedSpans()[0].Tags()
+			if tc.expectedReason == "" {
+				require.Empty(t, tags)
+				return
+			}
+			require.Equal(t, true, tags[TailSamplingKeepTag])
+			require.Equal(t, tc.expectedReason, tags[TailSamplingReasonTag])
+		})
+	}
+
+	t.Run("requests without span", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		m.Wrap(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TenantBytes is a Middleware counting the request and response body bytes
+// per tenant, for per-tenant network usage. It must run after the auth
+// middleware, so the tenant is set in the request context.
+type TenantBytes struct {
+	receivedBytes *prometheus.CounterVec
+	sentBytes     *prometheus.CounterVec
+}
+
+func NewTenantBytesMiddleware(prefix string, reg prometheus.Registerer) *TenantBytes {
+	m := &TenantBytes{
+		receivedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "tenant_received_bytes_total",
+			Help:      "The total number of request body bytes received per tenant.",
+		}, []string{"user"}),
+		sentBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "tenant_sent_bytes_total",
+			Help:      "The total number of response body bytes sent per tenant.",
+		}, []string{"user"}),
+	}
+	reg.MustRegister(m.receivedBytes, m.sentBytes)
+	return m
+}
+
+// Wrap implements middleware.Interface
+func (m TenantBytes) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, err := user.ExtractOrgID(r.Context())
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		origBody := r.Body
+		defer func() {
+			r.Body = origBody
+		}()
+		rBody := &reqBody{b: origBody}
+		r.Body = rBody
+
+		respMetrics := httpsnoop.CaptureMetricsFn(w, func(ww http.ResponseWriter) {
+			next.ServeHTTP(ww, r)
+		})
+
+		m.receivedBytes.WithLabelValues(tenant).Add(float64(rBody.read))
+		m.sentBytes.WithLabelValues(tenant).Add(float64(respMetrics.Written))
+	})
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantBytes(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	handler := NewTenantBytesMiddleware("test", reg).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = w.Write([]byte("response"))
+	}))
+
+	for _, tenant := range []string{"tenant-1", "tenant-1", "tenant-2", ""} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("request body"))
+		if tenant != "" {
+			req = req.WithContext(user.InjectOrgID(req.Context(), tenant))
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP test_tenant_received_bytes_total The total number of request body bytes received per tenant.
+		# TYPE test_tenant_received_bytes_total counter
+		test_tenant_received_bytes_total{user="tenant-1"} 24
+		test_tenant_received_bytes_total{user="tenant-2"} 12
+		# HELP test_tenant_sent_bytes_total The total number of response body bytes sent per tenant.
+		# TYPE test_tenant_sent_bytes_total counter
+		test_tenant_sent_bytes_total{user="tenant-1"} 16
+		test_tenant_sent_bytes_total{user="tenant-2"} 8
+	`)))
+}
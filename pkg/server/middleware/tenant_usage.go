@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/grafana/dskit/user"
+)
+
+type tenantUsageContextKey int
+
+const returnedSamplesKey tenantUsageContextKey = 0
+
+// TenantUsageCounts is the usage of a tenant over a period.
+type TenantUsageCounts struct {
+	Requests  int64 `json:"requests"`
+	Samples   int64 `json:"samples"`
+	BytesSent int64 `json:"bytes_sent"`
+}
+
+// TenantUsageReport is the usage of the tenants over a calendar month, in
+// UTC. Final is set once the month is over, so its counts won't change
+// anymore.
+//
+// The counts are the ones of the process Instance since InstanceStart. Each
+// replica, and each restart of a replica, reports its own counts: the usage
+// of a tenant is the sum of the last report of every instance.
+type TenantUsageReport struct {
+	Instance      string                       `json:"instance"`
+	InstanceStart time.Time                    `json:"instance_start"`
+	PeriodStart   time.Time                    `json:"period_start"`
+	PeriodEnd     time.Time                    `json:"period_end"`
+	Final         bool                         `json:"final"`
+	Tenants       map[string]TenantUsageCounts `json:"tenants"`
+}
+
+// TenantUsage is a Middleware accumulating the requests, samples returned and
+// response body bytes of each tenant over calendar months, for chargeback.
+// Handlers report the samples they return with AddReturnedSamples. It must
+// run after the auth middleware, so the tenant is set in the request context.
+type TenantUsage struct {
+	instance      string
+	instanceStart time.Time
+	now           func() time.Time
+
+	// mtx is only held for writing to add the periods and tenants, the
+	// counters are updated atomically.
+	mtx sync.RWMutex
+	// periods are the counts of the tenants by month start, kept until the
+	// final report of the month is forgotten.
+	periods map[time.Time]map[string]*tenantUsageCounters
+}
+
+type tenantUsageCounters struct {
+	requests, samples, bytesSent atomic.Int64
+}
+
+// NewTenantUsageMiddleware creates a TenantUsage whose reports are identified
+// by a random instance ID, changing on restarts.
+func NewTenantUsageMiddleware() *TenantUsage {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return &TenantUsage{
+		instance:      hex.EncodeToString(id),
+		instanceStart: time.Now().UTC(),
+		now:           time.Now,
+		periods:       map[time.Time]map[string]*tenantUsageCounters{},
+	}
+}
+
+// AddReturnedSamples adds n to the samples returned to the tenant of the
+// request in ctx. It's a no-op if the request isn't served through the
+// TenantUsage middleware.
+func AddReturnedSamples(ctx context.Context, n int) {
+	if samples, ok := ctx.Value(returnedSamplesKey).(*atomic.Int64); ok {
+		samples.Add(int64(n))
+	}
+}
+
+// Wrap implements middleware.Interface
+func (m *TenantUsage) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, err := user.ExtractOrgID(r.Context())
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		samples := &atomic.Int64{}
+		r = r.WithContext(context.WithValue(r.Context(), returnedSamplesKey, samples))
+		respMetrics := httpsnoop.CaptureMetricsFn(w, func(ww http.ResponseWriter) {
+			next.ServeHTTP(ww, r)
+		})
+
+		counters := m.counters(monthStart(m.now()), tenant)
+		counters.requests.Add(1)
+		counters.samples.Add(samples.Load())
+		counters.bytesSent.Add(respMetrics.Written)
+	})
+}
+
+// counters returns the counters of tenant for the month starting at period,
+// adding them if needed.
+func (m *TenantUsage) counters(period time.Time, tenant string) *tenantUsageCounters {
+	m.mtx.RLock()
+	counters, ok := m.periods[period][tenant]
+	m.mtx.RUnlock()
+	if ok {
+		return counters
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	tenants, ok := m.periods[period]
+	if !ok {
+		tenants = map[string]*tenantUsageCounters{}
+		m.periods[period] = tenants
+	}
+	if counters, ok = tenants[tenant]; !ok {
+		counters = &tenantUsageCounters{}
+		tenants[tenant] = counters
+	}
+	return counters
+}
+
+// Reports returns the usage of the months not forgotten yet, oldest first.
+// The current month is always reported, even without usage.
+func (m *TenantUsage) Reports(now time.Time) []TenantUsageReport {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	current := monthStart(now)
+	if _, ok := m.periods[current]; !ok {
+		m.periods[current] = map[string]*tenantUsageCounters{}
+	}
+	reports := make([]TenantUsageReport, 0, len(m.periods))
+	for start, tenants := range m.periods {
+		report := TenantUsageReport{
+			Instance:      m.instance,
+			InstanceStart: m.instanceStart,
+			PeriodStart:   start,
+			PeriodEnd:     start.AddDate(0, 1, 0),
+			Final:         start.Before(current),
+			Tenants:       make(map[string]TenantUsageCounts, len(tenants)),
+		}
+		for tenant, counters := range tenants {
+			report.Tenants[tenant] = TenantUsageCounts{
+				Requests:  counters.requests.Load(),
+				Samples:   counters.samples.Load(),
+				BytesSent: counters.bytesSent.Load(),
+			}
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].PeriodStart.Before(reports[j].PeriodStart)
+	})
+	return reports
+}
+
+// Forget drops the counts of the month starting at periodStart, once its
+// final report was exported.
+func (m *TenantUsage) Forget(periodStart time.Time) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.periods, periodStart)
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantUsage(t *testing.T) {
+	now := time.Date(2026, time.January, 31, 23, 59, 0, 0, time.UTC)
+	m := NewTenantUsageMiddleware()
+	m.now = func() time.Time { return now }
+	handler := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddReturnedSamples(r.Context(), 3)
+		AddReturnedSamples(r.Context(), 2)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	serve := func(tenant string) {
+		req := httptest.NewRequest(http.MethodGet, "/render", nil)
+		if tenant != "" {
+			req = req.WithContext(user.InjectOrgID(req.Context(), tenant))
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("a")
+	serve("a")
+	serve("b")
+	serve("")
+
+	january := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	february := time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)
+	require.Len(t, m.instance, 32)
+	require.Equal(t, []TenantUsageReport{{
+		Instance:      m.instance,
+		InstanceStart: m.instanceStart,
+		PeriodStart:   january,
+		PeriodEnd:     february,
+		Tenants: map[string]TenantUsageCounts{
+			"a": {Requests: 2, Samples: 10, BytesSent: 10},
+			"b": {Requests: 1, Samples: 5, BytesSent: 5},
+		},
+	}}, m.Reports(now))
+
+	// The counts start over in February, January's are final.
+	now = now.Add(2 * time.Minute)
+	serve("a")
+	reports := m.Reports(now)
+	require.Len(t, reports, 2)
+	require.True(t, reports[0].Final)
+	require.Equal(t, int64(2), reports[0].Tenants["a"].Requests)
+	require.False(t, reports[1].Final)
+	require.Equal(t, february, reports[1].PeriodStart)
+	require.Equal(t, map[string]TenantUsageCounts{"a": {Requests: 1, Samples: 5, BytesSent: 5}}, reports[1].Tenants)
+
+	m.Forget(january)
+	reports = m.Reports(now)
+	require.Len(t, reports, 1)
+	require.Equal(t, february, reports[0].PeriodStart)
+}
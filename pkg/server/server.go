@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-kit/log"
@@ -32,6 +35,8 @@ const (
 
 	defaultListenPort = 8000
 	defaultGrpcPort   = 9095
+
+	defaultUnixSocketPermissions = 0o660
 )
 
 type Config struct {
@@ -49,8 +54,68 @@ type Config struct {
 
 	HTTPMaxRequestSizeLimit int64 `yaml:"http_max_request_size_limit"`
 
+	// HTTPMaxURILength, HTTPMaxHeaderCount and HTTPMaxHeaderBytes reject the
+	// requests exceeding them with a 400 explaining which limit was
+	// exceeded. 0 disables them.
+	HTTPMaxURILength   int `yaml:"http_max_uri_length"`
+	HTTPMaxHeaderCount int `yaml:"http_max_header_count"`
+	HTTPMaxHeaderBytes int `yaml:"http_max_header_bytes"`
+
+	// HTTPMaxDecompressedBytes and HTTPMaxDecompressionRatio limit the
+	// gzip and deflate request bodies, which are decompressed for the
+	// handlers when either is set. 0 disables a limit.
+	HTTPMaxDecompressedBytes  int64   `yaml:"http_max_decompressed_bytes"`
+	HTTPMaxDecompressionRatio float64 `yaml:"http_max_decompression_ratio"`
+
+	// HTTPRequestTimeout is the deadline set on the context of every request,
+	// which is propagated to the downstream requests. 0 disables it, but the
+	// deadline in the X-Deadline header of a request is still honored.
+	HTTPRequestTimeout time.Duration `yaml:"http_request_timeout"`
+
+	// IdempotencyWindow is how long responses to requests carrying an
+	// Idempotency-Key header are replayed for retries. 0 disables it.
+	IdempotencyWindow time.Duration `yaml:"idempotency_window"`
+	// IdempotencyMaxEntries and IdempotencyMaxBodyBytes bound the memory
+	// of the replayed responses. 0 disables a limit.
+	IdempotencyMaxEntries   int `yaml:"idempotency_max_entries"`
+	IdempotencyMaxBodyBytes int `yaml:"idempotency_max_body_bytes"`
+
+	// PerTenantByteMetrics enables counting the request and response body
+	// bytes per tenant.
+	PerTenantByteMetrics bool `yaml:"per_tenant_byte_metrics"`
+
+	// NegotiateErrorFormat makes error responses honor the Accept header of
+	// the requests: JSON by default, plain text or protobuf.
+	NegotiateErrorFormat bool `yaml:"negotiate_error_format"`
+
+	// ServerTimingHeader enables the Server-Timing response header with the
+	// durations of the request phases.
+	ServerTimingHeader bool `yaml:"server_timing_header"`
+
+	// ShadowURL, if set, is the base URL ShadowPercent percent of the GET and
+	// HEAD requests are mirrored to, comparing its responses with the ones
+	// served. ShadowTimeout bounds the mirrored requests.
+	ShadowURL     string        `yaml:"shadow_url"`
+	ShadowPercent float64       `yaml:"shadow_percent"`
+	ShadowTimeout time.Duration `yaml:"shadow_timeout"`
+
 	GRPCListenPort int `yaml:"grpc_listen_port"`
 
+	// HTTPUnixSocketPath and GRPCUnixSocketPath, if set, make the servers
+	// listen on a unix domain socket at the given path instead of TCP.
+	HTTPUnixSocketPath    string `yaml:"http_unix_socket_path"`
+	GRPCUnixSocketPath    string `yaml:"grpc_unix_socket_path"`
+	UnixSocketPermissions uint   `yaml:"unix_socket_permissions"`
+
+	// ListenReusePort sets SO_REUSEPORT on the listeners, so the new
+	// version of the app can start listening on the same ports before the
+	// old one stops.
+	ListenReusePort bool `yaml:"listen_reuse_port"`
+	// InheritListeners makes the servers use the listeners passed by the
+	// service manager with the systemd socket activation protocol, if any,
+	// instead of opening their own.
+	InheritListeners bool `yaml:"inherit_listeners"`
+
 	PathPrefix string `yaml:"path_prefix"`
 }
 
@@ -74,8 +139,139 @@ func (cfg *Config) RegisterFlagsWithPrefix(prefix string, flags *flag.FlagSet) {
 	flags.DurationVar(&cfg.HTTPServerWriteTimeout, prefix+"server.http-server-write-timeout", defaultHTTPWriteTimeout, "HTTP request write timeout")
 	flags.DurationVar(&cfg.HTTPServerIdleTimeout, prefix+"server.http-server-idle-timeout", defaultHTTPIdleTimeout, "HTTP request idle timeout")
 	flags.Int64Var(&cfg.HTTPMaxRequestSizeLimit, prefix+"server.http-max-req-size-limit", defaultHTTPRequestSizeLimit, "HTTP max request body size limit in bytes")
+	flags.IntVar(&cfg.HTTPMaxURILength, prefix+"server.http-max-uri-length", 0, "Max length in bytes of the request URIs, including the query string. Longer requests are rejected with a 400 explaining the limit. 0 for no limit.")
+	flags.IntVar(&cfg.HTTPMaxHeaderCount, prefix+"server.http-max-header-count", 0, "Max number of request headers. Requests with more headers are rejected with a 400 explaining the limit. 0 for no limit.")
+	flags.IntVar(&cfg.HTTPMaxHeaderBytes, prefix+"server.http-max-header-bytes", 0, "Max size in bytes of the request headers. Requests with larger headers are rejected with a 400 explaining the limit. 0 for no limit.")
+	flags.Int64Var(&cfg.HTTPMaxDecompressedBytes, prefix+"server.http-max-decompressed-bytes", 0, "Max decompressed size in bytes of the gzip and deflate request bodies, which are decompressed as the handlers read them when this or server.http-max-decompression-ratio is set. Reading larger bodies fails with a limit exceeded error as soon as the limit is reached. 0 for no limit.")
+	flags.Float64Var(&cfg.HTTPMaxDecompressionRatio, prefix+"server.http-max-decompression-ratio", 0, "Max ratio of the decompressed to compressed size of the gzip and deflate request bodies, checked past the first MiB. Reading bodies above it fails with a limit exceeded error. 0 for no limit.")
+	flags.DurationVar(&cfg.HTTPRequestTimeout, prefix+"server.http-request-timeout", 0, "Deadline of the HTTP requests, after which the work they started, including downstream requests, is abandoned. 0 to disable.")
+	flags.DurationVar(&cfg.IdempotencyWindow, prefix+"server.idempotency-window", 0, "How long responses to mutating requests with an Idempotency-Key header are replayed for retries with the same key, per tenant. 0 to disable.")
+	flags.IntVar(&cfg.IdempotencyMaxEntries, prefix+"server.idempotency-max-entries", 10000, "Max number of responses kept for server.idempotency-window. The oldest ones are evicted to make room. 0 for no limit.")
+	flags.IntVar(&cfg.IdempotencyMaxBodyBytes, prefix+"server.idempotency-max-body-bytes", 1<<20, "Max size in bytes of the bodies of the responses kept for server.idempotency-window. The larger responses aren't replayed. 0 for no limit.")
+	flags.BoolVar(&cfg.PerTenantByteMetrics, prefix+"server.per-tenant-byte-metrics", false, "Count the request and response body bytes per tenant.")
+	flags.BoolVar(&cfg.NegotiateErrorFormat, prefix+"server.negotiate-error-format", false, "Write error responses in the format of the Accept header of the requests: JSON by default, text/plain, or a google.rpc.Status with the error details for application/x-protobuf.")
+	flags.BoolVar(&cfg.ServerTimingHeader, prefix+"server.server-timing-header", false, "Add a Server-Timing header to the responses, with the durations of the request phases like auth and writes to Mimir.")
+	flags.StringVar(&cfg.ShadowURL, prefix+"server.shadow-url", "", "Base URL of a second backend GET and HEAD requests are mirrored to, discarding its responses but recording how they compare to the ones served. Empty to disable.")
+	flags.Float64Var(&cfg.ShadowPercent, prefix+"server.shadow-percent", 1, "Percentage of the GET and HEAD requests mirrored to server.shadow-url, from 0 to 100.")
+	flags.DurationVar(&cfg.ShadowTimeout, prefix+"server.shadow-timeout", 30*time.Second, "Timeout of the requests mirrored to server.shadow-url. Must be positive when it's set.")
 	flags.StringVar(&cfg.PathPrefix, prefix+"server.path-prefix", "", "Base path to serve all API routes from (e.g. /v1/)")
 	flags.IntVar(&cfg.GRPCListenPort, prefix+"server.grpc-listen-port", defaultGrpcPort, "Sets listen address port for the http server")
+	flags.StringVar(&cfg.HTTPUnixSocketPath, prefix+"server.http-unix-socket-path", "", "If set, the http server listens on a unix domain socket at this path instead of the http listen address and port")
+	flags.StringVar(&cfg.GRPCUnixSocketPath, prefix+"server.grpc-unix-socket-path", "", "If set, the grpc server listens on a unix domain socket at this path instead of the grpc listen port")
+	flags.UintVar(&cfg.UnixSocketPermissions, prefix+"server.unix-socket-permissions", defaultUnixSocketPermissions, "File permissions applied to the unix domain sockets, eg. 0660")
+	flags.BoolVar(&cfg.ListenReusePort, prefix+"server.listen-reuse-port", false, "Set SO_REUSEPORT on the listeners of the app, including the internal server, carbon and statsd ones, so a new version of the app can start accepting connections on the same ports before the old one drains.")
+	flags.BoolVar(&cfg.InheritListeners, prefix+"server.inherit-listeners", false, "Use the listeners passed with the systemd socket activation protocol (LISTEN_FDS) instead of opening new ones. They are named in LISTEN_FDNAMES: http, grpc, internal, carbon-plaintext, carbon-udp, carbon-pickle, statsd-udp and statsd-tcp. The first two unnamed ones are the http and grpc ones.")
+}
+
+// ListenConfig returns how the listeners of the app are opened.
+func (cfg *Config) ListenConfig() ListenConfig {
+	return ListenConfig{ReusePort: cfg.ListenReusePort, Inherit: cfg.InheritListeners}
+}
+
+// Validate checks the config for values the server can't start with.
+func (cfg *Config) Validate() error {
+	if err := ValidateListenAddress(cfg.HTTPListenAddress); err != nil {
+		return errors.Wrap(err, "invalid http listen address")
+	}
+	if err := validatePort(cfg.HTTPListenPort); err != nil {
+		return errors.Wrap(err, "invalid http listen port")
+	}
+	if err := validatePort(cfg.GRPCListenPort); err != nil {
+		return errors.Wrap(err, "invalid grpc listen port")
+	}
+	if cfg.HTTPListenPort != 0 && cfg.HTTPListenPort == cfg.GRPCListenPort && cfg.HTTPUnixSocketPath == "" && cfg.GRPCUnixSocketPath == "" {
+		return fmt.Errorf("http and grpc servers can't both listen on port %d", cfg.HTTPListenPort)
+	}
+	if cfg.HTTPUnixSocketPath != "" && cfg.HTTPUnixSocketPath == cfg.GRPCUnixSocketPath {
+		return fmt.Errorf("http and grpc servers can't both listen on unix socket %q", cfg.HTTPUnixSocketPath)
+	}
+	if cfg.UnixSocketPermissions > 0o777 {
+		return fmt.Errorf("invalid unix socket permissions %#o", cfg.UnixSocketPermissions)
+	}
+	if cfg.HTTPConnLimit < 0 {
+		return fmt.Errorf("http connection limit can't be negative")
+	}
+	if cfg.HTTPMaxRequestSizeLimit < 0 {
+		return fmt.Errorf("http max request size limit can't be negative")
+	}
+	if cfg.HTTPMaxURILength < 0 || cfg.HTTPMaxHeaderCount < 0 || cfg.HTTPMaxHeaderBytes < 0 {
+		return fmt.Errorf("http max uri length and header limits can't be negative")
+	}
+	if cfg.IdempotencyMaxEntries < 0 || cfg.IdempotencyMaxBodyBytes < 0 {
+		return fmt.Errorf("idempotency limits can't be negative")
+	}
+	if cfg.HTTPMaxDecompressedBytes < 0 || cfg.HTTPMaxDecompressionRatio < 0 {
+		return fmt.Errorf("http decompression limits can't be negative")
+	}
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"graceful shutdown timeout", cfg.ServerGracefulShutdownTimeout},
+		{"http server read timeout", cfg.HTTPServerReadTimeout},
+		{"http server write timeout", cfg.HTTPServerWriteTimeout},
+		{"http server idle timeout", cfg.HTTPServerIdleTimeout},
+		{"idempotency window", cfg.IdempotencyWindow},
+		{"http request timeout", cfg.HTTPRequestTimeout},
+		{"shadow timeout", cfg.ShadowTimeout},
+	} {
+		if timeout.value < 0 {
+			return fmt.Errorf("%s can't be negative", timeout.name)
+		}
+	}
+	if cfg.ShadowPercent < 0 || cfg.ShadowPercent > 100 {
+		return fmt.Errorf("shadow percent %v must be between 0 and 100", cfg.ShadowPercent)
+	}
+	if cfg.ShadowURL != "" {
+		if cfg.ShadowTimeout <= 0 {
+			return errors.New("shadow timeout must be positive when the shadow URL is set")
+		}
+		if u, err := url.Parse(cfg.ShadowURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("shadow URL %q must be an absolute http or https URL", cfg.ShadowURL)
+		}
+	}
+	if cfg.PathPrefix != "" && !strings.HasPrefix(cfg.PathPrefix, "/") {
+		return fmt.Errorf("path prefix %q must start with /", cfg.PathPrefix)
+	}
+	return nil
+}
+
+// maxHeaderBytes returns the max size of the request line and headers read by
+// the HTTP server, leaving room for the requests exceeding the URI and header
+// limits to be rejected by the HeaderLimits middleware with a clear message,
+// rather than by the server with an opaque 431.
+func maxHeaderBytes(cfg Config) int {
+	if cfg.HTTPMaxURILength == 0 && cfg.HTTPMaxHeaderBytes == 0 {
+		return http.DefaultMaxHeaderBytes
+	}
+	uriLength, headerBytes := cfg.HTTPMaxURILength, cfg.HTTPMaxHeaderBytes
+	if uriLength == 0 {
+		uriLength = http.DefaultMaxHeaderBytes
+	}
+	if headerBytes == 0 {
+		headerBytes = http.DefaultMaxHeaderBytes
+	}
+	// Requests up to twice the limits get a clear message.
+	return max(2*(uriLength+headerBytes), http.DefaultMaxHeaderBytes)
+}
+
+// ValidateListenAddress checks that addr is either empty, an IP address or a
+// host name, without a port.
+func ValidateListenAddress(addr string) error {
+	if addr == "" || net.ParseIP(addr) != nil {
+		return nil
+	}
+	if strings.ContainsAny(addr, ":/ ") {
+		return fmt.Errorf("%q is not an IP address or host name", addr)
+	}
+	return nil
+}
+
+func validatePort(port int) error {
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("%d is out of range", port)
+	}
+	return nil
 }
 
 // Server initializes an Router webserver as well as the desired middleware configuration
@@ -97,7 +293,7 @@ func NewServer(log log.Logger, cfg Config, router *mux.Router, middlewares []mid
 	}
 
 	// Setup listeners first, so we can fail early if the port is in use.
-	httpListener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.HTTPListenAddress, cfg.HTTPListenPort))
+	httpListener, err := listen(cfg.ListenConfig(), inheritedHTTPListener, cfg.HTTPUnixSocketPath, fmt.Sprintf("%s:%d", cfg.HTTPListenAddress, cfg.HTTPListenPort), cfg.UnixSocketPermissions)
 	if err != nil {
 		return nil, err
 	}
@@ -112,16 +308,18 @@ func NewServer(log log.Logger, cfg Config, router *mux.Router, middlewares []mid
 	}
 
 	httpServer := &http.Server{
-		ReadTimeout:  cfg.HTTPServerReadTimeout,
-		WriteTimeout: cfg.HTTPServerWriteTimeout,
-		IdleTimeout:  cfg.HTTPServerIdleTimeout,
-		Handler:      middleware.Merge(middlewares...).Wrap(router),
+		ReadTimeout:    cfg.HTTPServerReadTimeout,
+		WriteTimeout:   cfg.HTTPServerWriteTimeout,
+		IdleTimeout:    cfg.HTTPServerIdleTimeout,
+		MaxHeaderBytes: maxHeaderBytes(cfg),
+		Handler:        middleware.Merge(middlewares...).Wrap(router),
 	}
 
 	grpcServer := grpc.NewServer()
 
-	grpcListener, grpcListenerErr := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", cfg.GRPCListenPort))
-	if grpcListenerErr != nil {
+	grpcListener, err := listen(cfg.ListenConfig(), inheritedGRPCListener, cfg.GRPCUnixSocketPath, fmt.Sprintf("0.0.0.0:%d", cfg.GRPCListenPort), cfg.UnixSocketPermissions)
+	if err != nil {
+		_ = httpListener.Close()
 		return nil, err
 	}
 
@@ -139,6 +337,31 @@ func NewServer(log log.Logger, cfg Config, router *mux.Router, middlewares []mid
 	}, nil
 }
 
+// listen opens a unix domain socket listener at socketPath with the given
+// permissions if socketPath is set, or uses Listen for a TCP listener on
+// tcpAddr otherwise. A stale socket file left behind by a previous process is
+// removed first, but listening fails if another process still accepts
+// connections on it.
+func listen(cfg ListenConfig, name, socketPath, tcpAddr string, perm uint) (net.Listener, error) {
+	if socketPath == "" {
+		return Listen(cfg, name, "tcp", tcpAddr)
+	}
+
+	if fi, err := os.Stat(socketPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.DialTimeout("unix", socketPath, time.Second)
+		switch {
+		case err == nil:
+			_ = conn.Close()
+			return nil, fmt.Errorf("unix socket %s is in use by another process", socketPath)
+		case errors.Is(err, syscall.ECONNREFUSED):
+			if err := os.Remove(socketPath); err != nil {
+				return nil, errors.Wrap(err, "can't remove stale unix socket")
+			}
+		}
+	}
+	return listenUnixSocket(socketPath, os.FileMode(perm))
+}
+
 // Addr returns the address the server is listening on.
 func (s *Server) Addr() net.Addr {
 	return s.httpListener.Addr()
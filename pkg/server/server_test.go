@@ -1,9 +1,14 @@
 package server
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
 
 	"github.com/go-kit/log"
@@ -11,6 +16,7 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
 // TestServerRun ensures that after initializing the server and configuring a route
@@ -41,3 +47,203 @@ func TestServerRun(t *testing.T) {
 	_, err = http.DefaultClient.Do(req)
 	require.NoError(t, err)
 }
+
+func TestServerRunUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	var cfg Config
+	cfg.RegisterFlags(flag.NewFlagSet("", flag.ExitOnError))
+	cfg.HTTPUnixSocketPath = filepath.Join(dir, "http.sock")
+	cfg.GRPCUnixSocketPath = filepath.Join(dir, "grpc.sock")
+	cfg.UnixSocketPermissions = 0o600
+
+	server, err := NewServer(log.NewNopLogger(), cfg, mux.NewRouter(), nil)
+	require.NoError(t, err)
+
+	server.Router.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	go func() {
+		require.NoError(t, server.Run())
+	}()
+	defer server.Shutdown(nil)
+
+	for _, path := range []string{cfg.HTTPUnixSocketPath, cfg.GRPCUnixSocketPath} {
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", cfg.HTTPUnixSocketPath)
+		},
+	}}
+	resp, err := client.Get("http://unix/test")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestServerUnixSocketReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	// Leave the socket file behind, as a crashed process would.
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	l, err := listen(ListenConfig{}, "", path, "", 0o660)
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+}
+
+func TestServerUnixSocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+
+	live, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	defer live.Close()
+
+	_, err = listen(ListenConfig{}, "", path, "", 0o660)
+	require.ErrorContains(t, err, "in use by another process")
+
+	// The socket of the other process is left in place.
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+}
+
+func TestServerListenReusePort(t *testing.T) {
+	var cfg Config
+	cfg.RegisterFlags(flag.NewFlagSet("", flag.ExitOnError))
+	cfg.HTTPListenAddress = "127.0.0.1"
+	cfg.HTTPListenPort = 0
+	cfg.GRPCListenPort = 0
+	cfg.ListenReusePort = true
+
+	old, err := NewServer(log.NewNopLogger(), cfg, mux.NewRouter(), nil)
+	require.NoError(t, err)
+	go func() {
+		require.NoError(t, old.Run())
+	}()
+	defer old.Shutdown(nil)
+
+	// The new server listens on the same port while the old one still does.
+	cfg.HTTPListenPort = old.Addr().(*net.TCPAddr).Port
+	replacement, err := NewServer(log.NewNopLogger(), cfg, mux.NewRouter(), nil)
+	require.NoError(t, err)
+	go func() {
+		require.NoError(t, replacement.Run())
+	}()
+	defer replacement.Shutdown(nil)
+	require.Equal(t, old.Addr().String(), replacement.Addr().String())
+}
+
+func TestServerInheritListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+	// The inherited file descriptor is closed once the server listens on it.
+	fd, err := syscall.Dup(int(f.Fd()))
+	require.NoError(t, err)
+
+	inheritListeners(t, fd, 1, "http")
+
+	var cfg Config
+	cfg.RegisterFlags(flag.NewFlagSet("", flag.ExitOnError))
+	cfg.HTTPListenPort = 0
+	cfg.GRPCListenPort = 0
+	cfg.InheritListeners = true
+	server, err := NewServer(log.NewNopLogger(), cfg, mux.NewRouter(), nil)
+	require.NoError(t, err)
+	server.Router.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	go func() {
+		require.NoError(t, server.Run())
+	}()
+	defer server.Shutdown(nil)
+
+	require.Equal(t, l.Addr().String(), server.Addr().String())
+	resp, err := http.Get(fmt.Sprintf("http://%s/test", server.Addr()))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestListenInherited(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcp.Close()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer udp.Close()
+
+	// The inherited file descriptors must follow each other.
+	tcpFile, err := tcp.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer tcpFile.Close()
+	udpFile, err := udp.(*net.UDPConn).File()
+	require.NoError(t, err)
+	defer udpFile.Close()
+	fd, err := unix.FcntlInt(tcpFile.Fd(), unix.F_DUPFD, 1000)
+	require.NoError(t, err)
+	udpFD, err := unix.FcntlInt(udpFile.Fd(), unix.F_DUPFD, fd+1)
+	require.NoError(t, err)
+	require.Equal(t, fd+1, udpFD)
+
+	inheritListeners(t, fd, 2, "statsd-tcp:statsd-udp")
+	cfg := ListenConfig{Inherit: true}
+
+	l, err := Listen(cfg, "statsd-tcp", "tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	require.Equal(t, tcp.Addr().String(), l.Addr().String())
+	conn, err := ListenPacket(cfg, "statsd-udp", "udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, udp.LocalAddr().String(), conn.LocalAddr().String())
+
+	// The environment is cleared for the child processes, and the listeners
+	// not passed, or already used, are opened.
+	require.Empty(t, os.Getenv("LISTEN_FDS"))
+	other, err := Listen(cfg, "statsd-tcp", "tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer other.Close()
+	require.NotEqual(t, tcp.Addr().String(), other.Addr().String())
+}
+
+func TestListenReusePort(t *testing.T) {
+	cfg := ListenConfig{ReusePort: true}
+	first, err := ListenPacket(cfg, "", "udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := ListenPacket(cfg, "", "udp", first.LocalAddr().String())
+	require.NoError(t, err)
+	defer second.Close()
+}
+
+// inheritListeners passes count listeners to the process, from the file
+// descriptor start on, as the systemd socket activation protocol does.
+func inheritListeners(t *testing.T, start, count int, names string) {
+	oldStart := listenFDsStart
+	listenFDsStart = start
+	t.Setenv("LISTEN_PID", fmt.Sprint(os.Getpid()))
+	t.Setenv("LISTEN_FDS", fmt.Sprint(count))
+	t.Setenv("LISTEN_FDNAMES", names)
+	inherited.mtx.Lock()
+	inherited.loaded, inherited.files = false, nil
+	inherited.mtx.Unlock()
+	t.Cleanup(func() {
+		listenFDsStart = oldStart
+		inherited.mtx.Lock()
+		inherited.loaded, inherited.files = false, nil
+		inherited.mtx.Unlock()
+	})
+}
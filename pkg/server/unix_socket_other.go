@@ -0,0 +1,24 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package server
+
+import (
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// listenUnixSocket listens on a unix domain socket at path, and sets its
+// permissions to perm, as there's no umask to create it with them.
+func listenUnixSocket(path string, perm os.FileMode) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		_ = l.Close()
+		return nil, errors.Wrap(err, "can't set unix socket permissions")
+	}
+	return l, nil
+}
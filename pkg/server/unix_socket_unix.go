@@ -0,0 +1,28 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package server
+
+import (
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// umaskMtx serializes the changes of the umask of the process, which is
+// shared by all its goroutines.
+var umaskMtx sync.Mutex
+
+// listenUnixSocket listens on a unix domain socket at path, created with
+// perm rather than chmod-ed after the fact, so it's never reachable with
+// wider permissions. The umask is narrowed while the socket is created, so
+// the files created meanwhile by other goroutines get at most the same
+// permissions.
+func listenUnixSocket(path string, perm os.FileMode) (net.Listener, error) {
+	umaskMtx.Lock()
+	defer umaskMtx.Unlock()
+	old := unix.Umask(int(0o777 &^ perm))
+	defer unix.Umask(old)
+	return net.Listen("unix", path)
+}
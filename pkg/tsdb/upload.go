@@ -0,0 +1,349 @@
+package tsdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/multierror"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+
+	"github.com/grafana/mimir-graphite/v2/pkg/appcommon/secrets"
+)
+
+const metaFilename = "meta.json"
+
+// The states of a block upload returned by Mimir's check endpoint.
+const (
+	uploadStateComplete = "complete"
+	uploadStateFailed   = "failed"
+)
+
+// errBlockExists is returned when Mimir already has the block, which happens
+// when resuming an import.
+var errBlockExists = errors.New("block already exists")
+
+type UploaderConfig struct {
+	// Address is the base URL of Mimir, eg. https://prometheus-prod-01.grafana.net.
+	Address string
+	// TenantID is sent as X-Scope-OrgID, and as the basic auth user when
+	// APIKey is set.
+	TenantID string
+	// APIKey is read for every request, so the rotated keys are picked up
+	// during long imports.
+	APIKey secrets.Secret
+	// Concurrency is the number of blocks uploaded at the same time.
+	Concurrency int
+	// MaxRetries is how many times each request is retried on network errors,
+	// 429 and 5xx responses.
+	MaxRetries int
+	// PollInterval is how often the validation of an uploaded block is
+	// checked.
+	PollInterval time.Duration
+}
+
+// Uploader imports blocks to Mimir with its block upload API: for each block
+// it starts the upload with the block's meta.json, uploads the index and chunk
+// files, finishes the upload and waits for Mimir to validate the block.
+// Blocks Mimir already has are skipped, so an interrupted import can be run
+// again.
+type Uploader struct {
+	cfg     UploaderConfig
+	address *url.URL
+	client  *http.Client
+	logger  log.Logger
+}
+
+func NewUploader(cfg UploaderConfig, logger log.Logger) (*Uploader, error) {
+	address, err := url.Parse(cfg.Address)
+	if err != nil || address.Scheme == "" || address.Host == "" {
+		return nil, fmt.Errorf("invalid Mimir address %q", cfg.Address)
+	}
+	if cfg.TenantID == "" {
+		return nil, errors.New("the tenant ID is required")
+	}
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 5 * time.Second
+	}
+	return &Uploader{cfg: cfg, address: address, client: http.DefaultClient, logger: logger}, nil
+}
+
+// UploadBlocks uploads all the blocks in blocksDir, which are the directories
+// named after a block ID holding a meta.json file. It returns the number of
+// blocks uploaded and the errors of the blocks that couldn't be.
+func (u *Uploader) UploadBlocks(ctx context.Context, blocksDir string) (int, error) {
+	entries, err := os.ReadDir(blocksDir)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't list blocks")
+	}
+	var blockDirs []string
+	for _, entry := range entries {
+		if _, err := ulid.Parse(entry.Name()); err != nil || !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(blocksDir, entry.Name(), metaFilename)); err != nil {
+			// Not finished yet.
+			continue
+		}
+		blockDirs = append(blockDirs, filepath.Join(blocksDir, entry.Name()))
+	}
+
+	blocks := make(chan string)
+	var (
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		uploaded int
+		errs     multierror.MultiError
+	)
+	for i := 0; i < u.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for blockDir := range blocks {
+				err := u.UploadBlock(ctx, blockDir)
+				mtx.Lock()
+				switch {
+				case errors.Is(err, errBlockExists):
+					level.Info(u.logger).Log("msg", "Block already uploaded, skipping", "block", filepath.Base(blockDir))
+				case err != nil:
+					level.Error(u.logger).Log("msg", "Error uploading block", "block", filepath.Base(blockDir), "err", err)
+					errs.Add(fmt.Errorf("block %s: %w", filepath.Base(blockDir), err))
+				default:
+					uploaded++
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+	for _, blockDir := range blockDirs {
+		blocks <- blockDir
+	}
+	close(blocks)
+	wg.Wait()
+	return uploaded, errs.Err()
+}
+
+// UploadBlock uploads a single block, and waits until Mimir validated it.
+func (u *Uploader) UploadBlock(ctx context.Context, blockDir string) error {
+	blockID := filepath.Base(blockDir)
+	files, err := blockFiles(blockDir)
+	if err != nil {
+		return err
+	}
+	meta, err := metaWithFiles(blockDir, files)
+	if err != nil {
+		return err
+	}
+
+	level.Info(u.logger).Log("msg", "Uploading block", "block", blockID, "files", len(files))
+	err = u.do(ctx, http.MethodPost, path.Join("/api/v1/upload/block", blockID, "start"), nil, func() (io.Reader, error) {
+		return bytes.NewReader(meta), nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		query := url.Values{"path": {f.RelPath}}
+		err := u.do(ctx, http.MethodPost, path.Join("/api/v1/upload/block", blockID, "files"), query, func() (io.Reader, error) {
+			return os.Open(filepath.Join(blockDir, filepath.FromSlash(f.RelPath)))
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("can't upload %s: %w", f.RelPath, err)
+		}
+	}
+
+	if err := u.do(ctx, http.MethodPost, path.Join("/api/v1/upload/block", blockID, "finish"), nil, nil, nil); err != nil {
+		return err
+	}
+	return u.waitValidated(ctx, blockID)
+}
+
+// waitValidated polls the state of the block upload until Mimir has
+// validated the block.
+func (u *Uploader) waitValidated(ctx context.Context, blockID string) error {
+	ticker := time.NewTicker(u.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		var state struct {
+			Result string `json:"result"`
+			Error  string `json:"error"`
+		}
+		if err := u.do(ctx, http.MethodGet, path.Join("/api/v1/upload/block", blockID, "check"), nil, nil, &state); err != nil {
+			return err
+		}
+		switch state.Result {
+		case uploadStateComplete:
+			level.Info(u.logger).Log("msg", "Block uploaded", "block", blockID)
+			return nil
+		case uploadStateFailed:
+			return fmt.Errorf("block validation failed: %s", state.Error)
+		}
+		level.Debug(u.logger).Log("msg", "Waiting for block validation", "block", blockID, "state", state.Result)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// do sends a request to Mimir, retrying on network errors, 429 and 5xx
+// responses. body, if set, returns a new request body for every attempt, and
+// the JSON response is decoded into out, if set.
+func (u *Uploader) do(ctx context.Context, method, urlPath string, query url.Values, body func() (io.Reader, error), out interface{}) error {
+	reqURL := *u.address
+	reqURL.Path = path.Join(reqURL.Path, urlPath)
+	reqURL.RawQuery = query.Encode()
+
+	retries := backoff.New(ctx, backoff.Config{
+		MinBackoff: time.Second,
+		MaxBackoff: 30 * time.Second,
+		MaxRetries: u.cfg.MaxRetries + 1,
+	})
+	var lastErr error
+	for retries.Ongoing() {
+		retryable, err := u.doOnce(ctx, method, reqURL.String(), body, out)
+		if err == nil || !retryable {
+			return err
+		}
+		lastErr = err
+		level.Warn(u.logger).Log("msg", "Block upload request failed, retrying", "url", reqURL.Path, "err", err)
+		retries.Wait()
+	}
+	if lastErr == nil {
+		lastErr = retries.Err()
+	}
+	return lastErr
+}
+
+func (u *Uploader) doOnce(ctx context.Context, method, reqURL string, body func() (io.Reader, error), out interface{}) (retryable bool, _ error) {
+	var reqBody io.Reader
+	if body != nil {
+		var err error
+		if reqBody, err = body(); err != nil {
+			return false, err
+		}
+		if closer, ok := reqBody.(io.Closer); ok {
+			defer closer.Close()
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reqBody)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("X-Scope-OrgID", u.cfg.TenantID)
+	if u.cfg.APIKey.IsSet() {
+		apiKey, err := u.cfg.APIKey.Get(ctx)
+		if err != nil {
+			return false, errors.Wrap(err, "can't read the API key")
+		}
+		req.SetBasicAuth(u.cfg.TenantID, apiKey)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusConflict && path.Base(req.URL.Path) == "start" {
+		return false, errBlockExists
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("%s %s returned HTTP status %s: %s", method, req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5, err
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return false, errors.Wrap(err, "can't decode response")
+		}
+	}
+	return false, nil
+}
+
+// blockFile is a file of a block, as listed in the thanos.files field of the
+// meta.json Mimir expects.
+type blockFile struct {
+	RelPath   string `json:"rel_path"`
+	SizeBytes int64  `json:"size_bytes,omitempty"`
+}
+
+// blockFiles returns the index and chunk files of a block, sorted by path.
+func blockFiles(blockDir string) ([]blockFile, error) {
+	var files []blockFile
+	err := filepath.WalkDir(blockDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(blockDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "index" && path.Dir(rel) != "chunks" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, blockFile{RelPath: rel, SizeBytes: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list block files")
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].RelPath < files[j].RelPath })
+	return files, nil
+}
+
+// metaWithFiles returns the meta.json of the block with the list of its files
+// set in thanos.files, which Mimir uses to validate the upload.
+func metaWithFiles(blockDir string, files []blockFile) ([]byte, error) {
+	content, err := os.ReadFile(filepath.Join(blockDir, metaFilename))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read block meta")
+	}
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return nil, errors.Wrap(err, "can't parse block meta")
+	}
+	thanos := map[string]json.RawMessage{}
+	if raw, ok := meta["thanos"]; ok {
+		if err := json.Unmarshal(raw, &thanos); err != nil {
+			return nil, errors.Wrap(err, "can't parse block meta")
+		}
+	}
+	allFiles := append([]blockFile{{RelPath: metaFilename}}, files...)
+	if thanos["files"], err = json.Marshal(allFiles); err != nil {
+		return nil, err
+	}
+	if meta["thanos"], err = json.Marshal(thanos); err != nil {
+		return nil, err
+	}
+	return json.Marshal(meta)
+}
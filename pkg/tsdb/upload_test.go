@@ -0,0 +1,203 @@
+package tsdb
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBlockUpload implements the block upload API of Mimir, keeping the
+// uploaded files in memory.
+type fakeBlockUpload struct {
+	mtx           sync.Mutex
+	metas         map[string]map[string]interface{}
+	files         map[string]map[string]string
+	finished      map[string]bool
+	checks        map[string]int
+	failNextFiles int
+	apiKey        string
+}
+
+func newFakeBlockUpload() *fakeBlockUpload {
+	return &fakeBlockUpload{
+		metas:    map[string]map[string]interface{}{},
+		files:    map[string]map[string]string{},
+		finished: map[string]bool{},
+		checks:   map[string]int{},
+		apiKey:   "secret",
+	}
+}
+
+func (f *fakeBlockUpload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if r.Header.Get("X-Scope-OrgID") != "12345" {
+		http.Error(w, "no org id", http.StatusUnauthorized)
+		return
+	}
+	if user, pass, _ := r.BasicAuth(); user != "12345" || pass != f.apiKey {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/upload/block/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	blockID, action := parts[0], parts[1]
+	switch action {
+	case "start":
+		if _, ok := f.metas[blockID]; ok {
+			http.Error(w, "block already exists", http.StatusConflict)
+			return
+		}
+		var meta map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.metas[blockID] = meta
+		f.files[blockID] = map[string]string{}
+	case "files":
+		if f.failNextFiles > 0 {
+			f.failNextFiles--
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		content, _ := io.ReadAll(r.Body)
+		f.files[blockID][r.URL.Query().Get("path")] = string(content)
+	case "finish":
+		f.finished[blockID] = true
+	case "check":
+		// The block is validating on the first check.
+		f.checks[blockID]++
+		result := "validating"
+		if f.checks[blockID] > 1 {
+			result = "complete"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"result": result})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeTestBlock(t *testing.T, blocksDir, blockID string) {
+	blockDir := filepath.Join(blocksDir, blockID)
+	require.NoError(t, os.MkdirAll(filepath.Join(blockDir, "chunks"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(blockDir, "index"), []byte("index of "+blockID), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(blockDir, "chunks", "000001"), []byte("chunks of "+blockID), 0o644))
+	meta := `{"ulid": "` + blockID + `", "minTime": 0, "maxTime": 7200000, "version": 1}`
+	require.NoError(t, os.WriteFile(filepath.Join(blockDir, "meta.json"), []byte(meta), 0o644))
+}
+
+func TestUploader(t *testing.T) {
+	blocksDir := t.TempDir()
+	blockIDs := []string{"01H00000000000000000000001", "01H00000000000000000000002", "01H00000000000000000000003"}
+	for _, id := range blockIDs {
+		writeTestBlock(t, blocksDir, id)
+	}
+	// Not a block.
+	require.NoError(t, os.MkdirAll(filepath.Join(blocksDir, "tmp"), 0o755))
+	// Not finished yet.
+	require.NoError(t, os.MkdirAll(filepath.Join(blocksDir, "01H00000000000000000000004"), 0o755))
+
+	mimir := newFakeBlockUpload()
+	mimir.failNextFiles = 1
+	srv := httptest.NewServer(mimir)
+	defer srv.Close()
+
+	apiKeyFile := filepath.Join(t.TempDir(), "api-key")
+	require.NoError(t, os.WriteFile(apiKeyFile, []byte("secret\n"), 0o600))
+	cfg := UploaderConfig{
+		Address:      srv.URL,
+		TenantID:     "12345",
+		Concurrency:  2,
+		MaxRetries:   2,
+		PollInterval: time.Millisecond,
+	}
+	require.NoError(t, cfg.APIKey.Set("file:"+apiKeyFile))
+	uploader, err := NewUploader(cfg, log.NewNopLogger())
+	require.NoError(t, err)
+
+	uploaded, err := uploader.UploadBlocks(context.Background(), blocksDir)
+	require.NoError(t, err)
+	require.Equal(t, 3, uploaded)
+
+	for _, id := range blockIDs {
+		require.True(t, mimir.finished[id])
+		require.Equal(t, map[string]string{
+			"index":         "index of " + id,
+			"chunks/000001": "chunks of " + id,
+		}, mimir.files[id])
+		require.Equal(t, map[string]interface{}{
+			"files": []interface{}{
+				map[string]interface{}{"rel_path": "meta.json"},
+				map[string]interface{}{"rel_path": "chunks/000001", "size_bytes": float64(len("chunks of " + id))},
+				map[string]interface{}{"rel_path": "index", "size_bytes": float64(len("index of " + id))},
+			},
+		}, mimir.metas[id]["thanos"])
+		require.Equal(t, id, mimir.metas[id]["ulid"])
+	}
+
+	t.Run("blocks already uploaded are skipped", func(t *testing.T) {
+		uploaded, err := uploader.UploadBlocks(context.Background(), blocksDir)
+		require.NoError(t, err)
+		require.Equal(t, 0, uploaded)
+	})
+
+	t.Run("rotated API keys are picked up", func(t *testing.T) {
+		mimir.mtx.Lock()
+		mimir.apiKey = "rotated"
+		mimir.mtx.Unlock()
+		require.NoError(t, os.WriteFile(apiKeyFile, []byte("rotated\n"), 0o600))
+
+		blocksDir := t.TempDir()
+		writeTestBlock(t, blocksDir, "01H00000000000000000000005")
+		uploaded, err := uploader.UploadBlocks(context.Background(), blocksDir)
+		require.NoError(t, err)
+		require.Equal(t, 1, uploaded)
+	})
+
+	t.Run("failed validation", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/check") {
+				_ = json.NewEncoder(w).Encode(map[string]string{"result": "failed", "error": "out of order chunks"})
+			}
+		}))
+		defer srv.Close()
+		uploader, err := NewUploader(UploaderConfig{Address: srv.URL, TenantID: "12345"}, log.NewNopLogger())
+		require.NoError(t, err)
+
+		err = uploader.UploadBlock(context.Background(), filepath.Join(blocksDir, blockIDs[0]))
+		require.EqualError(t, err, "block validation failed: out of order chunks")
+	})
+
+	t.Run("client errors aren't retried", func(t *testing.T) {
+		requests := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			http.Error(w, "block upload is disabled", http.StatusBadRequest)
+		}))
+		defer srv.Close()
+		uploader, err := NewUploader(UploaderConfig{Address: srv.URL, TenantID: "12345", MaxRetries: 3}, log.NewNopLogger())
+		require.NoError(t, err)
+
+		uploaded, err := uploader.UploadBlocks(context.Background(), blocksDir)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "400 Bad Request: block upload is disabled")
+		require.Equal(t, 0, uploaded)
+		require.Equal(t, len(blockIDs), requests)
+	})
+}